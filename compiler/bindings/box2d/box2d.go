@@ -4,6 +4,7 @@ package box2d
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -117,7 +118,9 @@ func (contactListener) BeginContact(contact box2d.B2ContactInterface) {
 
 func (contactListener) EndContact(box2d.B2ContactInterface)                         {}
 func (contactListener) PreSolve(box2d.B2ContactInterface, box2d.B2Manifold)         {}
-func (contactListener) PostSolve(box2d.B2ContactInterface, *box2d.B2ContactImpulse) {}
+func (contactListener) PostSolve(contact box2d.B2ContactInterface, impulse *box2d.B2ContactImpulse) {
+	recordImpulse2D(contact, impulse)
+}
 
 func bodyKey(worldId, bodyId string) string { return worldId + "\x00" + bodyId }
 
@@ -142,6 +145,7 @@ func bodyIdFromBody(worldId string, body *box2d.B2Body) string {
 func RegisterBox2D(v *vm.VM) {
 	registerFlat2D(v)
 	registerEntityGetters2D(v)
+	registerContacts2D(v)
 }
 
 // registerEntityGetters2D registers getters for entity.x, entity.y, entity.angle when the entity has "body" and "world" properties (2D physics).
@@ -256,6 +260,7 @@ func registerFlat2D(v *vm.VM) {
 		bodyOrderMu.Lock()
 		delete(bodyOrder, worldId)
 		bodyOrderMu.Unlock()
+		forgetContacts2D(worldId)
 		return nil, nil
 	})
 	v.RegisterForeign("Step2D", func(args []interface{}) (interface{}, error) {
@@ -277,8 +282,9 @@ func registerFlat2D(v *vm.VM) {
 			}
 		}
 		collisionBuffer2DMu.Unlock()
+		resetImpulses2D(worldId)
 		w.Step(dt, 8, 3)
-		return nil, nil
+		return nil, dispatchContacts2D(v, worldId, w)
 	})
 	// Physics2DStep(dt): alias that steps world "default".
 	v.RegisterForeign("Physics2DStep", func(args []interface{}) (interface{}, error) {
@@ -344,12 +350,17 @@ func registerFlat2D(v *vm.VM) {
 			ids = append(ids, id)
 		}
 		worldMu.RUnlock()
+		sort.Strings(ids)
 		for _, worldId := range ids {
 			worldMu.RLock()
 			w := worlds[worldId]
 			worldMu.RUnlock()
 			if w != nil {
+				resetImpulses2D(worldId)
 				w.Step(dt, 8, 3)
+				if err := dispatchContacts2D(v, worldId, w); err != nil {
+					return nil, err
+				}
 			}
 		}
 		return nil, nil
@@ -779,6 +790,7 @@ func registerFlat2D(v *vm.VM) {
 			return nil, fmt.Errorf("body not found")
 		}
 		b.SetTransform(box2d.MakeB2Vec2(toFloat64(args[2]), toFloat64(args[3])), b.GetAngle())
+		b.SetAwake(true) // a sleeping body keeps its old contacts until it is simulated again
 		return nil, nil
 	})
 
@@ -0,0 +1,213 @@
+package box2d
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"cyberbasic/compiler/vm"
+	"github.com/bytearena/box2d"
+)
+
+// contact2D is a touching pair after a step, keyed by body ids with a < b. The normal points from a toward b.
+type contact2D struct {
+	a, b    string
+	nx, ny  float64
+	px, py  float64
+	impulse float64
+	sensor  bool
+}
+
+var (
+	touching2D   = make(map[string]map[[2]string]*contact2D)             // worldId -> pairs touching after the last dispatched step
+	impulses2D   = make(map[string]map[box2d.B2ContactInterface]float64) // worldId -> normal impulse per contact in the current step
+	contacts2DMu sync.Mutex
+)
+
+// recordImpulse2D adds the solver's normal impulses for one contact (called from PostSolve during the step).
+func recordImpulse2D(contact box2d.B2ContactInterface, impulse *box2d.B2ContactImpulse) {
+	fa := contact.GetFixtureA()
+	if fa == nil || fa.GetBody() == nil || impulse == nil {
+		return
+	}
+	worldMu.RLock()
+	worldId := worldIdByPtr[fa.GetBody().GetWorld()]
+	worldMu.RUnlock()
+	if worldId == "" {
+		return
+	}
+	sum := 0.0
+	for i := 0; i < impulse.Count; i++ {
+		sum += impulse.NormalImpulses[i]
+	}
+	contacts2DMu.Lock()
+	if impulses2D[worldId] == nil {
+		impulses2D[worldId] = make(map[box2d.B2ContactInterface]float64)
+	}
+	impulses2D[worldId][contact] += sum
+	contacts2DMu.Unlock()
+}
+
+// resetImpulses2D forgets the impulses of the previous step of worldId. Call before stepping it.
+func resetImpulses2D(worldId string) {
+	contacts2DMu.Lock()
+	clear(impulses2D[worldId])
+	contacts2DMu.Unlock()
+}
+
+// forgetContacts2D drops worldId's contact state when the world is destroyed.
+func forgetContacts2D(worldId string) {
+	contacts2DMu.Lock()
+	delete(touching2D, worldId)
+	delete(impulses2D, worldId)
+	contacts2DMu.Unlock()
+}
+
+// contactEvent2D is one call of an OnCollision2D handler.
+type contactEvent2D struct {
+	sub  vm.Value // Sub name or function value
+	args []interface{}
+}
+
+// contactEvents2D diffs w's touching contacts against the last dispatched step and lists the handler calls:
+// begin, stay and end for solid fixtures, enter and exit for sensors. Each is reported to both bodies with the
+// normal pointing toward the body being told, sorted by body ids so handlers run in the same order every run.
+func contactEvents2D(v *vm.VM, worldId string, w *box2d.B2World) []contactEvent2D {
+	ids := make(map[*box2d.B2Body]string)
+	prefix := worldId + "\x00"
+	bodiesMu.RLock()
+	for k, b := range bodies {
+		if strings.HasPrefix(k, prefix) {
+			ids[b] = k[len(prefix):]
+		}
+	}
+	bodiesMu.RUnlock()
+
+	contacts2DMu.Lock()
+	defer contacts2DMu.Unlock()
+	now := make(map[[2]string]*contact2D)
+	for c := w.GetContactList(); c != nil; c = c.GetNext() {
+		if !c.IsTouching() || !c.IsEnabled() {
+			continue
+		}
+		fa, fb := c.GetFixtureA(), c.GetFixtureB()
+		a, b := ids[fa.GetBody()], ids[fb.GetBody()]
+		if a == "" || b == "" {
+			continue
+		}
+		var wm box2d.B2WorldManifold
+		c.GetWorldManifold(&wm)
+		nx, ny := wm.Normal.X, wm.Normal.Y
+		if b < a {
+			a, b, nx, ny = b, a, -nx, -ny
+		}
+		key := [2]string{a, b}
+		cur := now[key]
+		if cur == nil {
+			cur = &contact2D{a: a, b: b, nx: nx, ny: ny}
+			if n := c.GetManifold().PointCount; n > 0 {
+				for i := 0; i < n; i++ {
+					cur.px += wm.Points[i].X / float64(n)
+					cur.py += wm.Points[i].Y / float64(n)
+				}
+			} else { // sensors have no manifold points: use the middle of the two bodies
+				pa, pb := fa.GetBody().GetPosition(), fb.GetBody().GetPosition()
+				cur.px, cur.py = (pa.X+pb.X)/2, (pa.Y+pb.Y)/2
+			}
+			now[key] = cur
+		}
+		cur.sensor = cur.sensor || fa.IsSensor() || fb.IsSensor()
+		cur.impulse += impulses2D[worldId][c]
+	}
+	prev := touching2D[worldId]
+	touching2D[worldId] = now
+
+	type change struct {
+		c    *contact2D
+		kind string
+	}
+	var changes []change
+	for key, c := range now {
+		kind := "stay"
+		if prev[key] == nil {
+			kind = "begin"
+		}
+		changes = append(changes, change{c, kind})
+	}
+	for key, c := range prev {
+		if now[key] == nil {
+			changes = append(changes, change{c, "end"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		ci, cj := changes[i].c, changes[j].c
+		if ci.a != cj.a {
+			return ci.a < cj.a
+		}
+		return ci.b < cj.b
+	})
+
+	bus := v.HasEventHandler("Collision2D")
+	var events []contactEvent2D
+	for _, ch := range changes {
+		c, kind := ch.c, ch.kind
+		if c.sensor {
+			switch kind {
+			case "begin":
+				kind = "enter"
+			case "end":
+				kind = "exit"
+			default:
+				continue
+			}
+		}
+		impulse := c.impulse
+		if kind == "end" || kind == "exit" {
+			impulse = 0
+		}
+		if bus {
+			v.PostEvent("Collision2D", kind, worldId, c.a, c.b, -c.nx, -c.ny, impulse, c.px, c.py)
+			v.PostEvent("Collision2D", kind, worldId, c.b, c.a, c.nx, c.ny, impulse, c.px, c.py)
+		}
+		if sub := v.ContactHandler(worldId, c.a, kind); sub != nil {
+			events = append(events, contactEvent2D{sub, []interface{}{c.b, -c.nx, -c.ny, impulse, c.px, c.py}})
+		}
+		if sub := v.ContactHandler(worldId, c.b, kind); sub != nil {
+			events = append(events, contactEvent2D{sub, []interface{}{c.a, c.nx, c.ny, impulse, c.px, c.py}})
+		}
+	}
+	return events
+}
+
+// dispatchContacts2D calls the Subs registered with OnCollision2D for worldId's last step and posts the same
+// contacts as Collision2D events for ON Collision2D handlers. It runs after B2World.Step has returned (Box2D
+// locks the world during the step), so handlers may create or destroy bodies.
+func dispatchContacts2D(v *vm.VM, worldId string, w *box2d.B2World) error {
+	if !v.HasContactHandlers(worldId) && !v.HasEventHandler("Collision2D") {
+		return nil
+	}
+	for _, ev := range contactEvents2D(v, worldId, w) {
+		if _, err := v.Invoke(ev.sub, ev.args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerContacts2D registers OnCollision2D.
+func registerContacts2D(v *vm.VM) {
+	v.RegisterForeign("OnCollision2D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 4 {
+			return nil, fmt.Errorf("OnCollision2D requires (world$, body$, event$, handler)")
+		}
+		event := strings.ToLower(toString(args[2]))
+		switch event {
+		case "begin", "stay", "end", "enter", "exit":
+		default:
+			return nil, fmt.Errorf("OnCollision2D: unknown event %q (begin, stay, end, enter, exit)", toString(args[2]))
+		}
+		v.RegisterContactHandler(toString(args[0]), toString(args[1]), event, vm.Callback(args[3]))
+		return nil, nil
+	})
+}
@@ -0,0 +1,89 @@
+package box2d
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+// step2DEvents steps world once the way Step2D does and returns the handler calls it produced.
+func step2DEvents(v *vm.VM, world string) []contactEvent2D {
+	worldMu.RLock()
+	w := worlds[world]
+	worldMu.RUnlock()
+	resetImpulses2D(world)
+	w.Step(1.0/60, 8, 3)
+	return contactEvents2D(v, world, w)
+}
+
+func TestContactEvents2D(t *testing.T) {
+	v := vm.NewVM()
+	RegisterBox2D(v)
+	call := func(name string, args ...interface{}) {
+		t.Helper()
+		if _, err := v.CallForeign(name, args); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	call("CreateWorld2D", "ev2d", 0.0, -10.0)
+	defer call("DestroyWorld2D", "ev2d")
+	call("CreateBox2D", "ev2d", "ground", 0.0, -0.5, 20.0, 1.0, 0.0, 0)
+	call("CreateBox2D", "ev2d", "zone", 0.0, 2.0, 4.0, 0.5, 0.0, 0)
+	call("SetSensor2D", "ev2d", "zone", 1)
+	call("CreateCircle2D", "ev2d", "ball", 0.0, 4.0, 0.5, 1.0, 1)
+	for _, h := range [][2]string{
+		{"ball", "begin"}, {"ball", "end"}, {"ball", "enter"}, {"ball", "exit"},
+		{"ground", "begin"}, {"ground", "stay"}, {"zone", "enter"},
+	} {
+		call("OnCollision2D", "ev2d", h[0], h[1], h[0]+"_"+h[1])
+	}
+	if _, err := v.CallForeign("OnCollision2D", []interface{}{"ev2d", "ball", "bump", "x"}); err == nil {
+		t.Error("OnCollision2D accepted an unknown event")
+	}
+
+	var log []string
+	var groundBegin []interface{}
+	for i := 0; i < 180; i++ {
+		var step []string
+		for _, ev := range step2DEvents(v, "ev2d") {
+			step = append(step, fmt.Sprintf("%s:%v", ev.sub, ev.args[0]))
+			if ev.sub == "ground_begin" {
+				groundBegin = ev.args
+			}
+		}
+		log = append(log, strings.Join(step, ","))
+	}
+	seq := strings.Join(log, "|")
+	for _, want := range []string{"ball_enter:zone,zone_enter:ball", "ball_exit:zone", "ball_begin:ground,ground_begin:ball"} {
+		if strings.Count(seq, want) != 1 {
+			t.Errorf("want %q exactly once, got %q", want, seq)
+		}
+	}
+	if !(strings.Index(seq, "ball_enter") < strings.Index(seq, "ball_exit") && strings.Index(seq, "ball_exit") < strings.Index(seq, "ball_begin")) {
+		t.Errorf("events out of order: %q", seq)
+	}
+	if got := log[len(log)-1]; got != "ground_stay:ball" {
+		t.Errorf("last step events = %q, want the resting ball to keep reporting stay", got)
+	}
+	// The ground is told the normal pointing from the ball toward it, the impulse that stopped the ball and
+	// a contact point on the ground's surface.
+	if groundBegin == nil {
+		t.Fatal("no ground_begin event")
+	}
+	nx, ny, impulse, py := toFloat64(groundBegin[1]), toFloat64(groundBegin[2]), toFloat64(groundBegin[3]), toFloat64(groundBegin[5])
+	if math.Abs(nx) > 1e-6 || math.Abs(ny+1) > 1e-6 || impulse <= 0 || math.Abs(py) > 0.1 {
+		t.Errorf("ground_begin args = %v, want normal (0,-1), positive impulse and a point near y=0", groundBegin)
+	}
+
+	call("SetPosition2D", "ev2d", "ball", 10.0, 10.0)
+	var got []string
+	for _, ev := range step2DEvents(v, "ev2d") {
+		got = append(got, fmt.Sprint(ev.sub))
+	}
+	if strings.Join(got, ",") != "ball_end" {
+		t.Errorf("moving the ball away gave %v, want ball_end", got)
+	}
+}
@@ -25,6 +25,7 @@ var box2dNames = []string{
 	"SetJointLimits2D", "SetJointMotor2D", "DestroyJoint2D",
 	"RayCast2D", "RayHitX2D", "RayHitY2D", "RayHitBody2D", "RayHitNormalX2D", "RayHitNormalY2D",
 	"GetCollisionCount2D", "GetCollisionOther2D", "GetCollisionNormalX2D", "GetCollisionNormalY2D",
+	"OnCollision2D",
 }
 
 func lowerMap(names []string) map[string]string {
@@ -0,0 +1,52 @@
+package bullet
+
+import "sort"
+
+// aabbProxy is a body's world-space bounds for one broadphase pass.
+type aabbProxy struct {
+	b        *body
+	min, max vec3
+}
+
+// broadphasePairs returns the pairs of active bodies whose AABBs overlap, using sweep-and-prune along X:
+// bodies are sorted by their minimum X and each one is only compared with the following bodies until their
+// minimum X passes its maximum X. Pairs that can never produce a response (two bodies that are each static or
+// asleep) are skipped. The order is deterministic (ties broken by body id) so the solver is reproducible.
+// Call with w.mu held.
+func broadphasePairs(w *world) [][2]*body {
+	proxies := w.proxies[:0]
+	for _, b := range w.bodies {
+		if !b.active {
+			continue
+		}
+		min, max := bodyAABB(b)
+		proxies = append(proxies, aabbProxy{b: b, min: min, max: max})
+	}
+	sort.Slice(proxies, func(i, j int) bool {
+		if proxies[i].min.x != proxies[j].min.x {
+			return proxies[i].min.x < proxies[j].min.x
+		}
+		return proxies[i].b.id < proxies[j].b.id
+	})
+	w.proxies = proxies
+
+	pairs := w.pairs[:0]
+	for i := range proxies {
+		a := &proxies[i]
+		for j := i + 1; j < len(proxies); j++ {
+			b := &proxies[j]
+			if b.min.x > a.max.x {
+				break
+			}
+			if b.min.y > a.max.y || b.max.y < a.min.y || b.min.z > a.max.z || b.max.z < a.min.z {
+				continue
+			}
+			if !bodyIsAwake(a.b) && !bodyIsAwake(b.b) {
+				continue
+			}
+			pairs = append(pairs, [2]*body{a.b, b.b})
+		}
+	}
+	w.pairs = pairs
+	return pairs
+}
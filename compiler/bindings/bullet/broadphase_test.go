@@ -0,0 +1,200 @@
+package bullet
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+func TestBroadphaseMatchesBruteForce(t *testing.T) {
+	CreateWorld("bp", 0, -9.81, 0)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		id := fmt.Sprintf("b%d", i)
+		x, y, z := rng.Float64()*20, rng.Float64()*20, rng.Float64()*20
+		mass := 1.0
+		if i%5 == 0 {
+			mass = 0
+		}
+		if i%2 == 0 {
+			CreateSphere("bp", id, x, y, z, 0.2+rng.Float64(), mass)
+		} else {
+			CreateBox("bp", id, x, y, z, 0.2+rng.Float64(), 0.2+rng.Float64(), 0.2+rng.Float64(), mass)
+		}
+	}
+	w := getWorld("bp")
+	defer dropWorld("bp")
+
+	want := map[[2]string]bool{}
+	var list []*body
+	for _, b := range w.bodies {
+		list = append(list, b)
+	}
+	for i, a := range list {
+		amin, amax := bodyAABB(a)
+		for _, b := range list[i+1:] {
+			if a.mass <= 0 && b.mass <= 0 {
+				continue
+			}
+			bmin, bmax := bodyAABB(b)
+			if amin.x <= bmax.x && bmin.x <= amax.x && amin.y <= bmax.y && bmin.y <= amax.y && amin.z <= bmax.z && bmin.z <= amax.z {
+				want[pairKey(a.id, b.id)] = true
+			}
+		}
+	}
+	got := map[[2]string]bool{}
+	for _, p := range broadphasePairs(w) {
+		k := pairKey(p[0].id, p[1].id)
+		if got[k] {
+			t.Fatalf("pair %v reported twice", k)
+		}
+		got[k] = true
+	}
+	if len(want) == 0 {
+		t.Fatal("test scene has no overlapping bodies")
+	}
+	for k := range want {
+		if !got[k] {
+			t.Errorf("broadphase missed %v", k)
+		}
+	}
+	for k := range got {
+		if !want[k] {
+			t.Errorf("broadphase reported non-overlapping %v", k)
+		}
+	}
+}
+
+func pairKey(a, b string) [2]string {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// dropWorld removes a world created by a test so package-level state does not leak between tests.
+func dropWorld(id string) {
+	worldMu.Lock()
+	delete(worlds, id)
+	worldMu.Unlock()
+}
+
+func stepN(t *testing.T, v *vm.VM, world string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := v.CallForeign("Step3D", []interface{}{world, 1.0 / 60}); err != nil {
+			t.Fatalf("Step3D failed: %v", err)
+		}
+	}
+}
+
+func isSleeping(t *testing.T, v *vm.VM, world, id string) bool {
+	t.Helper()
+	got, err := v.CallForeign("IsSleeping3D", []interface{}{world, id})
+	if err != nil {
+		t.Fatalf("IsSleeping3D failed: %v", err)
+	}
+	return got == true
+}
+
+func TestRestingStackSleepsAndWakesAsIsland(t *testing.T) {
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("sleep")
+	calls := [][]interface{}{
+		{"CreateWorld3D", "sleep", 0.0, -9.81, 0.0},
+		{"CreateBox3D", "sleep", "ground", 0.0, -0.5, 0.0, 20.0, 1.0, 20.0, 0.0},
+		{"CreateBox3D", "sleep", "low", 0.0, 0.6, 0.0, 1.0, 1.0, 1.0, 1.0},
+		{"CreateBox3D", "sleep", "high", 0.0, 1.7, 0.0, 1.0, 1.0, 1.0, 1.0},
+		{"CreateBox3D", "sleep", "apart", 5.0, 0.6, 5.0, 1.0, 1.0, 1.0, 1.0},
+	}
+	for _, c := range calls {
+		if _, err := v.CallForeign(c[0].(string), c[1:]); err != nil {
+			t.Fatalf("%s failed: %v", c[0], err)
+		}
+	}
+	stepN(t, v, "sleep", 180)
+	for _, id := range []string{"low", "high", "apart"} {
+		if !isSleeping(t, v, "sleep", id) {
+			t.Fatalf("%s still awake after resting for 3s", id)
+		}
+	}
+	if y := GetPositionY("sleep", "high"); y < 1.4 || y > 1.6 {
+		t.Fatalf("top crate at y=%v, want resting on the lower crate near 1.5", y)
+	}
+	if n, _ := v.CallForeign("GetCollisionCount3D", []interface{}{"sleep", "ground"}); n != 2 {
+		t.Errorf("ground reports %v contacts while the crates sleep, want 2", n)
+	}
+
+	if _, err := v.CallForeign("SetVelocity3D", []interface{}{"sleep", "low", 3.0, 0.0, 0.0}); err != nil {
+		t.Fatalf("SetVelocity3D failed: %v", err)
+	}
+	stepN(t, v, "sleep", 1)
+	if isSleeping(t, v, "sleep", "low") || isSleeping(t, v, "sleep", "high") {
+		t.Error("pushing the lower crate should wake its whole stack")
+	}
+	if !isSleeping(t, v, "sleep", "apart") {
+		t.Error("a crate in another island woke up")
+	}
+
+	if _, err := v.CallForeign("SetSleepThreshold3D", []interface{}{"sleep", 0.0}); err != nil {
+		t.Fatalf("SetSleepThreshold3D failed: %v", err)
+	}
+	stepN(t, v, "sleep", 180)
+	if isSleeping(t, v, "sleep", "apart") || isSleeping(t, v, "sleep", "low") {
+		t.Error("threshold 0 should keep every body awake")
+	}
+}
+
+// crateWorld builds n crates in a grid resting on (or falling onto) a floor.
+func crateWorld(id string, n int) *world {
+	CreateWorld(id, 0, -9.81, 0)
+	CreateBox(id, "floor", 0, -0.5, 0, 1000, 0.5, 1000, 0)
+	side := 1
+	for side*side < n {
+		side++
+	}
+	for i := 0; i < n; i++ {
+		x := float64(i%side) * 1.5
+		z := float64(i/side) * 1.5
+		CreateBox(id, fmt.Sprintf("crate%d", i), x, 0.5+float64(i%3)*0.02, z, 0.5, 0.5, 0.5, 1)
+	}
+	return getWorld(id)
+}
+
+func BenchmarkStep(b *testing.B) {
+	for _, n := range []int{100, 400, 1000} {
+		for _, sleeping := range []bool{false, true} {
+			name := fmt.Sprintf("crates=%d/awake", n)
+			if sleeping {
+				name = fmt.Sprintf("crates=%d/sleeping", n)
+			}
+			b.Run(name, func(b *testing.B) {
+				w := crateWorld("bench", n)
+				defer dropWorld("bench")
+				if !sleeping {
+					w.sleepLinear, w.sleepAngular = 0, 0
+				}
+				for i := 0; i < 120; i++ { // settle
+					Step("bench", 1.0/60)
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					Step("bench", 1.0/60)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkBroadphasePairs(b *testing.B) {
+	w := crateWorld("bench", 1000)
+	defer dropWorld("bench")
+	w.sleepLinear, w.sleepAngular = 0, 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		broadphasePairs(w)
+	}
+}
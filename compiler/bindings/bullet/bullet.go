@@ -73,13 +73,17 @@ type body struct {
 	radius          float64 // for sphere (0 = box/cylinder)
 	mass            float64
 	active          bool
-	collisions      []collisionHit // filled each Step, cleared at start
+	collisions      []collisionHit // filled each Step, cleared at start (kept while the body sleeps)
+	sleeping        bool           // deactivated: not integrated or tested until something wakes it
+	sleepTime       float64        // seconds spent below the world's sleep thresholds
+	sleepState      bodySleepState // state when it fell asleep, to detect outside changes
 	// body properties (used in Step and resolveCollisions)
 	friction       float64
 	restitution    float64
 	linearDamping  float64
 	angularDamping float64
 	kinematic      bool
+	trigger        bool // overlaps are reported as enter/exit events but never pushed apart
 	gravityScale   float64
 	linearFactor   vec3
 	angularFactor  vec3
@@ -88,6 +92,8 @@ type body struct {
 	meshTriangles []triangle
 	meshAABBMin   vec3 // local space
 	meshAABBMax   vec3 // local space
+	triMesh       *triMesh // triangle collision for meshes and heightfields (nil for other shapes)
+	character     *character // kinematic character controller state (CharacterCreate3D only)
 	// compound collider: multiple axis-aligned boxes in parent space (center offset + half extents)
 	compound []struct{ ox, oy, oz, hx, hy, hz float64 }
 }
@@ -278,10 +284,17 @@ type joint struct {
 }
 
 type world struct {
-	gravity vec3
-	bodies  map[string]*body
-	joints  map[string]*joint
-	mu      sync.RWMutex
+	gravity      vec3
+	bodies       map[string]*body
+	joints       map[string]*joint
+	mu           sync.RWMutex
+	sleepLinear  float64 // bodies slower than this (and sleepAngular) for sleepDelay seconds sleep; 0 disables
+	sleepAngular float64
+	proxies      []aabbProxy // broadphase scratch, reused between steps
+	pairs        [][2]*body
+	contacts     map[[2]*body]*contact // pairs found in this step (see contactKey)
+	touching     map[[2]*body]*contact // pairs touching after the last step
+	ended        []*contact            // pairs that stopped touching in the last step
 }
 
 const defaultPhysicsWorld = "default"
@@ -311,7 +324,13 @@ func bulletFeatureAvailable(name string) bool {
 		return true
 	case "compound", "compound_shapes":
 		return true
-	case "native", "native_backend", "joints", "heightmap", "exact_mesh_collision":
+	case "broadphase", "sleeping":
+		return true
+	case "heightmap", "heightfield", "exact_mesh_collision":
+		return true
+	case "character", "character_controller":
+		return true
+	case "native", "native_backend", "joints":
 		return false
 	default:
 		return false
@@ -356,16 +375,21 @@ func getOrCreateWorld(id string, gx, gy, gz float64) *world {
 	worldMu.Lock()
 	defer worldMu.Unlock()
 	if w, ok := worlds[id]; ok {
+		w.mu.Lock()
 		w.gravity = vec3{gx, gy, gz}
 		if w.joints == nil {
 			w.joints = make(map[string]*joint)
 		}
+		wakeAll(w)
+		w.mu.Unlock()
 		return w
 	}
 	w := &world{
-		gravity: vec3{gx, gy, gz},
-		bodies:  make(map[string]*body),
-		joints:  make(map[string]*joint),
+		gravity:      vec3{gx, gy, gz},
+		bodies:       make(map[string]*body),
+		joints:       make(map[string]*joint),
+		sleepLinear:  defaultSleepLinear,
+		sleepAngular: defaultSleepAngular,
 	}
 	worlds[id] = w
 	return w
@@ -383,6 +407,8 @@ func getBody(w *world, id string) *body {
 // The compiler rewrites BULLET.* calls to these flat names for backward compatibility.
 func RegisterBullet(v *vm.VM) {
 	registerFlat3D(v)
+	registerCharacter3D(v)
+	registerContacts3D(v)
 }
 
 // registerEntityGetters3D registers getters for entity.x, entity.y, entity.z, and rotation when the entity has "body" and "world" (3D physics).
@@ -516,6 +542,7 @@ func registerFlat3D(v *vm.VM) {
 		}
 		w.mu.Lock()
 		w.gravity = vec3{toFloat64(args[1]), toFloat64(args[2]), toFloat64(args[3])}
+		wakeAll(w)
 		w.mu.Unlock()
 		return nil, nil
 	})
@@ -541,20 +568,8 @@ func registerFlat3D(v *vm.VM) {
 		if w == nil {
 			return nil, fmt.Errorf("world not found")
 		}
-		dt := toFloat64(args[1])
-		w.mu.Lock()
-		for _, b := range w.bodies {
-			if !b.active || b.mass <= 0 {
-				continue
-			}
-			integrateBody(b, w.gravity, dt)
-		}
-		solveJoints(w, dt)
-		for pass := 0; pass < 3; pass++ {
-			resolveCollisions(w)
-		}
-		w.mu.Unlock()
-		return nil, nil
+		stepWorld(w, toFloat64(args[1]), 3)
+		return nil, dispatchContacts(v, toString(args[0]), w)
 	})
 	v.RegisterForeign("StepAllPhysics3D", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
@@ -572,16 +587,10 @@ func registerFlat3D(v *vm.VM) {
 			if w == nil {
 				continue
 			}
-			w.mu.Lock()
-			for _, b := range w.bodies {
-				if !b.active || b.mass <= 0 {
-					continue
-				}
-				integrateBody(b, w.gravity, dt)
+			stepWorld(w, dt, 1)
+			if err := dispatchContacts(v, id, w); err != nil {
+				return nil, err
 			}
-			solveJoints(w, dt)
-			resolveCollisions(w)
-			w.mu.Unlock()
 		}
 		return nil, nil
 	})
@@ -685,6 +694,7 @@ func registerFlat3D(v *vm.VM) {
 				b.meshTriangles = tris
 				b.meshAABBMin = minV
 				b.meshAABBMax = maxV
+				b.triMesh = newTriMesh(tris, false)
 				b.halfExt = vec3{(maxV.x - minV.x) / 2, (maxV.y - minV.y) / 2, (maxV.z - minV.z) / 2}
 			}
 		}
@@ -741,8 +751,52 @@ func registerFlat3D(v *vm.VM) {
 		w.mu.Unlock()
 		return nil, nil
 	})
+	// CreateHeightmap3D(world$, body$, terrainId$ [, x, y, z]): static heightfield with the terrain's heights,
+	// placed where the terrain is drawn unless a position is given.
 	v.RegisterForeign("CreateHeightmap3D", func(args []interface{}) (interface{}, error) {
-		return nil, fmt.Errorf("CreateHeightmap3D: use CreateStaticMesh3D with a heightfield mesh or a large static CreateBox3D in the pure-Go fallback; heightfield sampling is not implemented here")
+		if len(args) < 3 {
+			return nil, fmt.Errorf("CreateHeightmap3D requires (world$, body$, terrainId$ [, x, y, z])")
+		}
+		if heightfieldSource == nil {
+			return nil, fmt.Errorf("CreateHeightmap3D: terrain support is not registered")
+		}
+		hf, err := heightfieldSource(toString(args[2]))
+		if err != nil {
+			return nil, fmt.Errorf("CreateHeightmap3D: %w", err)
+		}
+		tris, err := heightfieldTriangles(hf)
+		if err != nil {
+			return nil, fmt.Errorf("CreateHeightmap3D: %w", err)
+		}
+		pos := vec3{hf.X, hf.Y, hf.Z}
+		if len(args) >= 6 {
+			pos = vec3{toFloat64(args[3]), toFloat64(args[4]), toFloat64(args[5])}
+		}
+		minY, maxY := math.Inf(1), math.Inf(-1)
+		for _, h := range hf.Heights[:hf.Width*hf.Depth] {
+			minY, maxY = math.Min(minY, h), math.Max(maxY, h)
+		}
+		wid := toString(args[0])
+		w := getWorld(wid)
+		if w == nil {
+			w = getOrCreateWorld(wid, 0, -9.81, 0)
+		}
+		b := &body{
+			id:            toString(args[1]),
+			position:      pos,
+			mass:          0,
+			active:        true,
+			scale:         vec3{1, 1, 1},
+			meshTriangles: tris,
+			meshAABBMin:   vec3{-hf.SizeX / 2, minY, -hf.SizeZ / 2},
+			meshAABBMax:   vec3{hf.SizeX / 2, maxY, hf.SizeZ / 2},
+			triMesh:       newTriMesh(tris, true),
+		}
+		b.halfExt = vec3{hf.SizeX / 2, (maxY - minY) / 2, hf.SizeZ / 2}
+		w.mu.Lock()
+		w.bodies[b.id] = b
+		w.mu.Unlock()
+		return nil, nil
 	})
 	v.RegisterForeign("CreateCompound3D", func(args []interface{}) (interface{}, error) {
 		if len(args) < 6 {
@@ -1109,6 +1163,39 @@ func registerFlat3D(v *vm.VM) {
 		return nil, nil
 	})
 
+	// Sleeping: resting bodies stop simulating until touched or changed (SetPosition3D, ApplyImpulse3D, ...)
+	v.RegisterForeign("SetSleepThreshold3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("SetSleepThreshold3D requires (worldId, linearSpeed [, angularSpeed]); 0 disables sleeping")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		linear := toFloat64(args[1])
+		angular := linear * defaultSleepAngular / defaultSleepLinear
+		if len(args) >= 3 {
+			angular = toFloat64(args[2])
+		}
+		w.mu.Lock()
+		w.sleepLinear, w.sleepAngular = linear, angular
+		w.mu.Unlock()
+		return nil, nil
+	})
+	v.RegisterForeign("IsSleeping3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("IsSleeping3D requires (worldId, bodyId)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return false, nil
+		}
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		b := w.bodies[toString(args[1])]
+		return b != nil && b.sleeping, nil
+	})
+
 	// BulletJointsAvailable: 0 = pure-Go (no joints), 1 = joints supported (PointToPoint, Fixed)
 	v.RegisterForeign("BulletJointsAvailable", func(args []interface{}) (interface{}, error) {
 		return 1, nil
@@ -1328,14 +1415,13 @@ func registerFlat3D(v *vm.VM) {
 			if !b.active {
 				continue
 			}
-			min, max := bodyAABB(b)
-			t := rayAABB(fx, fy, fz, dx, dy, dz, min.x, min.y, min.z, max.x, max.y, max.z)
-			if t >= 0 && t < maxDist && t < bestT {
+			t, n, ok := rayBody(b, vec3{fx, fy, fz}, vec3{dx, dy, dz}, maxDist)
+			if ok && t < bestT {
 				bestT = t
 				hit = true
 				hitP = vec3{fx + dx*t, fy + dy*t, fz + dz*t}
 				hitBodyId = id
-				hitNorm = vec3{-dx, -dy, -dz}
+				hitNorm = n
 			}
 		}
 		w.mu.RUnlock()
@@ -1391,14 +1477,13 @@ func registerFlat3D(v *vm.VM) {
 			if exclude != "" && strings.ToLower(id) == exclude {
 				continue
 			}
-			min, max := bodyAABB(b)
-			t := rayAABB(sx, sy, sz, dx, dy, dz, min.x, min.y, min.z, max.x, max.y, max.z)
-			if t >= 0 && t < maxDist && t < bestT {
+			t, n, ok := rayBody(b, vec3{sx, sy, sz}, vec3{dx, dy, dz}, maxDist)
+			if ok && t < bestT {
 				bestT = t
 				hit = true
 				hitP = vec3{sx + dx*t, sy + dy*t, sz + dz*t}
 				hitBodyId = id
-				hitNorm = vec3{-dx, -dy, -dz}
+				hitNorm = n
 			}
 		}
 		w.mu.RUnlock()
@@ -1763,7 +1848,7 @@ func solveJoints(w *world, dt float64) {
 	for iter := 0; iter < iterations; iter++ {
 		for _, j := range w.joints {
 			a, b := w.bodies[j.bodyA], w.bodies[j.bodyB]
-			if a == nil || b == nil || !a.active || !b.active {
+			if a == nil || b == nil || !a.active || !b.active || !bodyIsAwake(a) && !bodyIsAwake(b) {
 				continue
 			}
 			worldA := vec3{
@@ -1975,109 +2060,131 @@ func bodiesAreJoined(w *world, aId, bId string) bool {
 	return false
 }
 
-// resolveCollisions resolves overlaps between bodies and records collision events. Call with w.mu held.
-func resolveCollisions(w *world) {
-	if w == nil {
-		return
+// stepWorld advances w by dt: sleeping bodies changed from outside wake up, awake dynamic bodies integrate,
+// joints are solved, collisions are resolved in passes and resting islands go to sleep.
+func stepWorld(w *world, dt float64, passes int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wakeChangedBodies(w)
+	for _, b := range w.bodies {
+		if !b.active || b.mass <= 0 || b.sleeping {
+			continue
+		}
+		integrateBody(b, w.gravity, dt)
 	}
-	bodyList := make([]*body, 0, len(w.bodies))
+	solveJoints(w, dt)
 	for _, b := range w.bodies {
-		if b.active {
+		if !b.sleeping {
 			b.collisions = nil
-			bodyList = append(bodyList, b)
 		}
 	}
-	for i, a := range bodyList {
-		if a.mass <= 0 {
+	if w.contacts == nil {
+		w.contacts = make(map[[2]*body]*contact)
+	}
+	for pass := 0; pass < passes; pass++ {
+		resolveCollisions(w)
+	}
+	updateSleeping(w, dt)
+	updateContacts(w)
+	for _, b := range w.bodies {
+		if !b.sleeping {
 			continue
 		}
-		for j, b := range bodyList {
-			if i == j {
-				continue
-			}
-			if bodiesAreJoined(w, a.id, b.id) {
-				continue
-			}
-			nx, ny, nz, depth := overlapBodies(a, b)
-			if depth <= 0 {
-				continue
+		for _, hit := range b.collisions { // static neighbours of a sleeping body keep reporting the contact
+			if o := w.bodies[hit.otherId]; o != nil && o.active && o.mass <= 0 {
+				o.collisions = append(o.collisions, collisionHit{b.id, vec3{-hit.normal.x, -hit.normal.y, -hit.normal.z}})
 			}
-			a.collisions = append(a.collisions, collisionHit{b.id, vec3{nx, ny, nz}})
-			b.collisions = append(b.collisions, collisionHit{a.id, vec3{-nx, -ny, -nz}})
-			// Only push dynamic bodies; do not move kinematic bodies
-			if !a.kinematic {
-				a.position.x += nx * depth
-				a.position.y += ny * depth
-				a.position.z += nz * depth
-				vn := a.velocity.x*nx + a.velocity.y*ny + a.velocity.z*nz
-				// restitution: reflect normal velocity (use average of both bodies)
-				rest := a.restitution
-				if b.restitution > rest {
-					rest = b.restitution
-				}
-				if vn < 0 {
-					a.velocity.x -= (1 + rest) * vn * nx
-					a.velocity.y -= (1 + rest) * vn * ny
-					a.velocity.z -= (1 + rest) * vn * nz
-				}
-				// friction: reduce tangential velocity
-				fric := a.friction
-				if b.friction > fric {
-					fric = b.friction
-				}
-				if fric > 0 {
-					vx, vy, vz := a.velocity.x, a.velocity.y, a.velocity.z
-					vnVal := vx*nx + vy*ny + vz*nz
-					tx := vx - vnVal*nx
-					ty := vy - vnVal*ny
-					tz := vz - vnVal*nz
-					scale := 1.0 - fric
-					if scale < 0 {
-						scale = 0
-					}
-					a.velocity.x = vnVal*nx + tx*scale
-					a.velocity.y = vnVal*ny + ty*scale
-					a.velocity.z = vnVal*nz + tz*scale
-				}
-			}
-			if !b.kinematic {
-				b.position.x -= nx * depth
-				b.position.y -= ny * depth
-				b.position.z -= nz * depth
-				vn := b.velocity.x*(-nx) + b.velocity.y*(-ny) + b.velocity.z*(-nz)
-				rest := b.restitution
-				if a.restitution > rest {
-					rest = a.restitution
-				}
-				if vn < 0 {
-					b.velocity.x -= (1 + rest) * vn * (-nx)
-					b.velocity.y -= (1 + rest) * vn * (-ny)
-					b.velocity.z -= (1 + rest) * vn * (-nz)
-				}
-				fric := b.friction
-				if a.friction > fric {
-					fric = a.friction
-				}
-				if fric > 0 {
-					vx, vy, vz := b.velocity.x, b.velocity.y, b.velocity.z
-					nnx, nny, nnz := -nx, -ny, -nz
-					vnVal := vx*nnx + vy*nny + vz*nnz
-					tx := vx - vnVal*nnx
-					ty := vy - vnVal*nny
-					tz := vz - vnVal*nnz
-					scale := 1.0 - fric
-					if scale < 0 {
-						scale = 0
-					}
-					b.velocity.x = vnVal*nnx + tx*scale
-					b.velocity.y = vnVal*nny + ty*scale
-					b.velocity.z = vnVal*nnz + tz*scale
-				}
+		}
+	}
+}
+
+// resolveCollisions resolves overlaps between the pairs found by broadphasePairs and records collision events.
+// Static (mass 0) and kinematic bodies are never pushed; two dynamic bodies share the correction. A moving body
+// that touches a sleeping one wakes it. Call with w.mu held.
+func resolveCollisions(w *world) {
+	if w == nil {
+		return
+	}
+	for _, pair := range broadphasePairs(w) {
+		a, b := pair[0], pair[1]
+		if bodiesAreJoined(w, a.id, b.id) {
+			continue
+		}
+		nx, ny, nz, depth := overlapBodies(a, b)
+		if depth <= 0 {
+			continue
+		}
+		if a.trigger || b.trigger {
+			recordContact(w, a, b, vec3{nx, ny, nz}, depth)
+			continue
+		}
+		for _, sleeper := range pair {
+			if sleeper.sleeping {
+				wakeBody(sleeper)
+				sleeper.collisions = nil
 			}
 		}
+		if w.contacts[contactKey(a, b)] == nil { // once per step, whichever pass finds it first
+			// Each body's event normal points from the other body toward it.
+			a.collisions = append(a.collisions, collisionHit{b.id, vec3{-nx, -ny, -nz}})
+			b.collisions = append(b.collisions, collisionHit{a.id, vec3{nx, ny, nz}})
+		}
+		c := recordContact(w, a, b, vec3{nx, ny, nz}, depth)
+		moveA, moveB := bodyCanSleep(a), bodyCanSleep(b)
+		share := depth
+		if moveA && moveB {
+			share = depth / 2
+		}
+		var impulseA, impulseB float64
+		if moveA {
+			impulseA = respondToContact(a, b, vec3{-nx, -ny, -nz}, share)
+		}
+		if moveB {
+			impulseB = respondToContact(b, a, vec3{nx, ny, nz}, share)
+		}
+		c.impulse += math.Max(impulseA, impulseB)
 	}
 }
 
+// respondToContact pushes b out by depth along n (pointing away from other), removes the approaching normal
+// velocity with restitution and damps the tangential velocity by friction (the larger of the two bodies' values).
+// It returns the normal impulse applied to b.
+func respondToContact(b, other *body, n vec3, depth float64) float64 {
+	b.position.x += n.x * depth
+	b.position.y += n.y * depth
+	b.position.z += n.z * depth
+	rest := b.restitution
+	if other.restitution > rest {
+		rest = other.restitution
+	}
+	vn := vec3Dot(b.velocity, n)
+	impulse := 0.0
+	if vn < 0 {
+		b.velocity.x -= (1 + rest) * vn * n.x
+		b.velocity.y -= (1 + rest) * vn * n.y
+		b.velocity.z -= (1 + rest) * vn * n.z
+		impulse = -(1 + rest) * vn * b.mass
+	}
+	fric := b.friction
+	if other.friction > fric {
+		fric = other.friction
+	}
+	if fric > 0 {
+		vnVal := vec3Dot(b.velocity, n)
+		tx := b.velocity.x - vnVal*n.x
+		ty := b.velocity.y - vnVal*n.y
+		tz := b.velocity.z - vnVal*n.z
+		scale := 1.0 - fric
+		if scale < 0 {
+			scale = 0
+		}
+		b.velocity.x = vnVal*n.x + tx*scale
+		b.velocity.y = vnVal*n.y + ty*scale
+		b.velocity.z = vnVal*n.z + tz*scale
+	}
+	return impulse
+}
+
 // overlapBoxWithBody tests an axis-aligned box vs body b (same rules as overlapBodies for b).
 func overlapBoxWithBody(ax, ay, az, ahx, ahy, ahz float64, b *body) (nx, ny, nz, depth float64) {
 	if b == nil {
@@ -2133,6 +2240,13 @@ func overlapCompoundWithBody(a, b *body) (nx, ny, nz, depth float64) {
 
 // overlapBodies returns contact normal (from a toward b) and penetration depth. Depth > 0 means overlap. Caller holds world lock.
 func overlapBodies(a, b *body) (nx, ny, nz, depth float64) {
+	if b.triMesh != nil {
+		nx, ny, nz, depth = overlapTriangles(a, b)
+		return -nx, -ny, -nz, depth
+	}
+	if a.triMesh != nil {
+		return overlapTriangles(b, a)
+	}
 	if bodyUsesCapsuleBounds(a) {
 		aSphere := a.radius
 		a.radius = 0
@@ -2191,11 +2305,12 @@ func overlapBodies(a, b *body) (nx, ny, nz, depth float64) {
 		return overlapSphereSphere(a.position.x, a.position.y, a.position.z, a.radius, b.position.x, b.position.y, b.position.z, b.radius)
 	}
 	if aIsSphere && !bIsSphere {
-		return overlapSphereBox(a.position.x, a.position.y, a.position.z, a.radius, bx, by, bz, bhx, bhy, bhz)
+		// overlapSphereBox's normal points from the box toward the sphere
+		nx, ny, nz, depth := overlapSphereBox(a.position.x, a.position.y, a.position.z, a.radius, bx, by, bz, bhx, bhy, bhz)
+		return -nx, -ny, -nz, depth
 	}
 	if !aIsSphere && bIsSphere {
-		nx, ny, nz, depth := overlapSphereBox(b.position.x, b.position.y, b.position.z, b.radius, ax, ay, az, ahx, ahy, ahz)
-		return -nx, -ny, -nz, depth
+		return overlapSphereBox(b.position.x, b.position.y, b.position.z, b.radius, ax, ay, az, ahx, ahy, ahz)
 	}
 	return overlapBoxBox(ax, ay, az, ahx, ahy, ahz, bx, by, bz, bhx, bhy, bhz)
 }
@@ -2230,13 +2345,13 @@ func overlapSphereBox(sx, sy, sz, sr, bx, by, bz, hx, hy, hz float64) (nx, ny, n
 		return 0, 0, 0, 0
 	}
 	if distSq < 1e-18 {
-		// Sphere center inside box: push out along the axis of minimum penetration (depth = distance to push sphere center so surface touches face)
-		penR := (bx + hx) - sx - sr
-		penL := sx - (bx - hx) - sr
-		penU := (by + hy) - sy - sr
-		penD := sy - (by - hy) - sr
-		penF := (bz + hz) - sz - sr
-		penB := sz - (bz - hz) - sr
+		// Sphere center inside box: push out along the axis of minimum penetration (depth = distance to push sphere center out of the face, plus the radius so the surface just touches it)
+		penR := (bx + hx) - sx + sr
+		penL := sx - (bx - hx) + sr
+		penU := (by + hy) - sy + sr
+		penD := sy - (by - hy) + sr
+		penF := (bz + hz) - sz + sr
+		penB := sz - (bz - hz) + sr
 		best := penR
 		nx, ny, nz = 1, 0, 0
 		if penL < best {
@@ -2363,6 +2478,7 @@ func SetGravity(worldId string, gx, gy, gz float64) {
 	if w := getWorld(worldId); w != nil {
 		w.mu.Lock()
 		w.gravity = vec3{gx, gy, gz}
+		wakeAll(w)
 		w.mu.Unlock()
 	}
 }
@@ -2373,16 +2489,7 @@ func Step(worldId string, timeStep float64) {
 	if w == nil {
 		return
 	}
-	w.mu.Lock()
-	for _, b := range w.bodies {
-		if !b.active || b.mass <= 0 {
-			continue
-		}
-		integrateBody(b, w.gravity, timeStep)
-	}
-	solveJoints(w, timeStep)
-	resolveCollisions(w)
-	w.mu.Unlock()
+	stepWorld(w, timeStep, 1)
 }
 
 // CreateBox adds a box rigid body. halfEx* are half extents.
@@ -2571,14 +2678,13 @@ func RayCast(worldId string, startX, startY, startZ, dirX, dirY, dirZ, maxDist f
 		if !b.active {
 			continue
 		}
-		min, max := bodyAABB(b)
-		t := rayAABB(startX, startY, startZ, dx, dy, dz, min.x, min.y, min.z, max.x, max.y, max.z)
-		if t >= 0 && t < maxDist && t < bestT {
+		t, n, ok := rayBody(b, vec3{startX, startY, startZ}, vec3{dx, dy, dz}, maxDist)
+		if ok && t < bestT {
 			bestT = t
 			hit = true
 			hitP = vec3{startX + dx*t, startY + dy*t, startZ + dz*t}
 			hitBodyId = id
-			hitNorm = vec3{-dx, -dy, -dz}
+			hitNorm = n
 		}
 	}
 	w.mu.RUnlock()
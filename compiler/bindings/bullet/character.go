@@ -0,0 +1,397 @@
+package bullet
+
+import (
+	"fmt"
+	"math"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Collision flags returned by CharacterGetCollisionFlags3D for the last CharacterMove3D.
+const (
+	characterBelow = 1 // standing on walkable ground
+	characterSides = 2 // touching a wall or a slope steeper than the max slope
+	characterAbove = 4 // touching a ceiling
+)
+
+const (
+	defaultStepHeight = 0.35
+	defaultMaxSlope   = 45.0 // degrees
+	characterPasses   = 6    // depenetration passes per slide
+	groundSnap        = 0.05 // how far a grounded character with no step height looks down for the ground
+	characterSkin     = 1e-7 // gap left between the character and what it touches
+)
+
+// character is the kinematic controller attached to a capsule body by CharacterCreate3D. The body stays in the
+// world (rays hit it, dynamic bodies are pushed out of it) but the step never moves it; CharacterMove3D does.
+type character struct {
+	stepHeight   float64
+	maxSlope     float64 // degrees
+	minGroundY   float64 // cos(maxSlope): contact normals with a larger y are ground, not walls
+	verticalVel  float64 // gravity and jumps; horizontal velocity comes from each move
+	grounded     bool
+	groundNormal vec3
+	flags        int
+}
+
+func newCharacter(stepHeight, maxSlope float64) *character {
+	c := &character{stepHeight: stepHeight}
+	setCharacterMaxSlope(c, maxSlope)
+	return c
+}
+
+func setCharacterMaxSlope(c *character, degrees float64) {
+	c.maxSlope = clamp(degrees, 1, 89)
+	c.minGroundY = math.Cos(c.maxSlope * math.Pi / 180)
+}
+
+// characterContact returns the deepest overlap between the character body b and any other active body, with n
+// pointing from that body toward the character. Call with w.mu held.
+func characterContact(w *world, b *body) (n vec3, depth float64) {
+	bmin, bmax := bodyAABB(b)
+	for _, o := range w.bodies {
+		if o == b || !o.active || o.trigger {
+			continue
+		}
+		omin, omax := bodyAABB(o)
+		if bmin.x > omax.x || omin.x > bmax.x || bmin.y > omax.y || omin.y > bmax.y || bmin.z > omax.z || omin.z > bmax.z {
+			continue
+		}
+		nx, ny, nz, d := overlapBodies(b, o)
+		if d > depth {
+			depth = d
+			n = vec3{-nx, -ny, -nz}
+		}
+	}
+	return n, depth
+}
+
+// characterPush classifies a contact normal n (pointing toward the character) and returns how far to move the
+// character to get depth out of it. Ground pushes straight up, so standing on a ramp does not creep downhill;
+// walls and too-steep slopes push horizontally only, so walking into them cannot climb them.
+func characterPush(c *character, n vec3, depth float64) (flag int, push vec3) {
+	depth += characterSkin
+	switch {
+	case n.y >= c.minGroundY:
+		return characterBelow, vec3{0, depth / n.y, 0}
+	case n.y <= -c.minGroundY:
+		return characterAbove, vec3Scale(n, depth)
+	}
+	if h := math.Hypot(n.x, n.z); h > 0.1 {
+		return characterSides, vec3{n.x * depth / (h * h), 0, n.z * depth / (h * h)}
+	}
+	return characterSides, vec3Scale(n, depth)
+}
+
+// characterSlide moves the character body by delta, stopping at the first surface in the way and sliding the
+// rest of the move along it. The surface is found by bisecting the move, so the contact is always shallow and
+// its normal is the face that was reached (a box edge walked onto from above is ground, not a wall). Walking up
+// walkable ground keeps the horizontal distance. It returns the collision flags met and the most upward ground
+// normal. Call with w.mu held.
+func characterSlide(w *world, c *character, b *body, delta vec3) (flags int, ground vec3) {
+	contact := func(n vec3, depth float64) int {
+		flag, push := characterPush(c, n, depth)
+		flags |= flag
+		if flag == characterBelow && n.y > ground.y {
+			ground = n
+		}
+		b.position = vec3Add(b.position, push)
+		return flag
+	}
+	for pass := 0; pass < characterPasses; pass++ { // get out of anything that moved into the character
+		n, depth := characterContact(w, b)
+		if depth <= 0 {
+			break
+		}
+		contact(n, depth)
+	}
+	rest := delta
+	for pass := 0; pass < characterPasses && vec3Dot(rest, rest) > 1e-18; pass++ {
+		from := b.position
+		b.position = vec3Add(from, rest)
+		if _, depth := characterContact(w, b); depth <= 0 {
+			break
+		}
+		lo, hi := 0.0, 1.0
+		for i := 0; i < 16; i++ {
+			mid := (lo + hi) / 2
+			b.position = vec3Add(from, vec3Scale(rest, mid))
+			if _, depth := characterContact(w, b); depth > 0 {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		b.position = vec3Add(from, vec3Scale(rest, hi))
+		n, depth := characterContact(w, b)
+		flag := contact(n, depth)
+		rest = vec3Scale(rest, 1-hi)
+		switch flag {
+		case characterBelow:
+			if rest.y < 0 {
+				rest.y = 0
+			}
+			if h := math.Hypot(rest.x, rest.z); h > 0 {
+				along := vec3Sub(rest, vec3Scale(n, vec3Dot(rest, n))) // up the ramp, same horizontal distance
+				if ah := math.Hypot(along.x, along.z); ah > 1e-12 {
+					rest = vec3Scale(along, h/ah)
+				}
+			}
+		case characterAbove:
+			if rest.y > 0 {
+				rest.y = 0
+			}
+		default:
+			if h := math.Hypot(n.x, n.z); h > 0 {
+				wall := vec3{n.x / h, 0, n.z / h}
+				if d := vec3Dot(rest, wall); d < 0 {
+					rest = vec3Sub(rest, vec3Scale(wall, d))
+				}
+			}
+		}
+	}
+	return flags, ground
+}
+
+// moveCharacter walks the character at (vx, vz) units per second for dt seconds and applies its vertical
+// velocity. A walk blocked by a wall is retried lifted by the step height and set back down, keeping whichever
+// attempt got further, so kerbs and stairs are climbed; a grounded character then snaps down to the ground so
+// it follows slopes and steps downward instead of skipping off them. Call with w.mu held.
+func moveCharacter(w *world, b *body, vx, vz, dt float64) {
+	c := b.character
+	start := b.position
+	if c.grounded && c.verticalVel <= 0 {
+		c.verticalVel = 0
+	} else {
+		c.verticalVel += w.gravity.y * dt
+	}
+
+	flags := 0
+	var ground vec3
+	if walk := (vec3{vx * dt, 0, vz * dt}); walk != (vec3{}) {
+		flags, ground = characterSlide(w, c, b, walk)
+		if c.grounded && c.stepHeight > 0 && flags&characterSides != 0 {
+			plain, plainFlags, plainGround := b.position, flags, ground
+			b.position = start
+			characterSlide(w, c, b, vec3{0, c.stepHeight, 0})
+			lifted := b.position.y - start.y
+			sideFlags, _ := characterSlide(w, c, b, walk)
+			downFlags, downGround := characterSlide(w, c, b, vec3{0, -lifted, 0})
+			if downFlags&characterBelow != 0 && horizontalDistance(start, b.position) > horizontalDistance(start, plain)+1e-9 {
+				flags, ground = sideFlags|downFlags, downGround
+			} else {
+				b.position, flags, ground = plain, plainFlags, plainGround
+			}
+		}
+	}
+
+	if c.grounded && c.verticalVel <= 0 {
+		snap := math.Max(c.stepHeight, groundSnap)
+		before := b.position
+		f, g := characterSlide(w, c, b, vec3{0, -snap, 0})
+		if f&characterBelow != 0 {
+			flags |= f
+			if g.y > ground.y {
+				ground = g
+			}
+		} else {
+			b.position = before // walked off a ledge: start falling next move
+		}
+	} else {
+		f, g := characterSlide(w, c, b, vec3{0, c.verticalVel * dt, 0})
+		flags |= f
+		if g.y > ground.y {
+			ground = g
+		}
+		if f&characterAbove != 0 && c.verticalVel > 0 {
+			c.verticalVel = 0
+		}
+	}
+
+	c.flags = flags
+	c.grounded = flags&characterBelow != 0
+	c.groundNormal = ground
+	if c.grounded && c.verticalVel < 0 {
+		c.verticalVel = 0
+	}
+	if dt > 0 {
+		b.velocity = vec3Scale(vec3Sub(b.position, start), 1/dt)
+	}
+}
+
+func horizontalDistance(a, b vec3) float64 {
+	return math.Hypot(b.x-a.x, b.z-a.z)
+}
+
+// getCharacter looks up a character body for a Character*3D foreign. Call with w.mu held.
+func getCharacter(w *world, name, id string) (*body, error) {
+	b := w.bodies[id]
+	if b == nil {
+		return nil, fmt.Errorf("body not found")
+	}
+	if b.character == nil {
+		return nil, fmt.Errorf("%s: body %q is not a character (create it with CharacterCreate3D)", name, id)
+	}
+	return b, nil
+}
+
+// registerCharacter3D registers the kinematic character controller foreigns.
+func registerCharacter3D(v *vm.VM) {
+	v.RegisterForeign("CharacterCreate3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 7 {
+			return nil, fmt.Errorf("CharacterCreate3D requires (world$, body$, x, y, z, radius, height [, stepHeight, maxSlopeDegrees])")
+		}
+		wid := toString(args[0])
+		bid := toString(args[1])
+		w := getWorld(wid)
+		if w == nil {
+			w = getOrCreateWorld(wid, 0, -9.81, 0)
+		}
+		radius := toFloat64(args[5])
+		height := toFloat64(args[6])
+		if height < radius*2 {
+			height = radius * 2
+		}
+		step, slope := defaultStepHeight, defaultMaxSlope
+		if len(args) >= 8 {
+			step = math.Max(toFloat64(args[7]), 0)
+		}
+		if len(args) >= 9 {
+			slope = toFloat64(args[8])
+		}
+		w.mu.Lock()
+		w.bodies[bid] = &body{
+			id:        bid,
+			position:  vec3{toFloat64(args[2]), toFloat64(args[3]), toFloat64(args[4])},
+			halfExt:   vec3{radius, height / 2, radius},
+			radius:    radius,
+			mass:      1,
+			kinematic: true,
+			active:    true,
+			scale:     vec3{1, 1, 1},
+			character: newCharacter(step, slope),
+		}
+		w.mu.Unlock()
+		return nil, nil
+	})
+	v.RegisterForeign("CharacterMove3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 5 {
+			return nil, fmt.Errorf("CharacterMove3D requires (world$, body$, vx, vz, dt)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		b, err := getCharacter(w, "CharacterMove3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		moveCharacter(w, b, toFloat64(args[2]), toFloat64(args[3]), toFloat64(args[4]))
+		return nil, nil
+	})
+	v.RegisterForeign("CharacterJump3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 3 {
+			return nil, fmt.Errorf("CharacterJump3D requires (world$, body$, speed)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		b, err := getCharacter(w, "CharacterJump3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		if !b.character.grounded {
+			return false, nil
+		}
+		b.character.verticalVel = toFloat64(args[2])
+		b.character.grounded = false
+		return true, nil
+	})
+	v.RegisterForeign("CharacterIsGrounded3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("CharacterIsGrounded3D requires (world$, body$)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return false, nil
+		}
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		b, err := getCharacter(w, "CharacterIsGrounded3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return b.character.grounded, nil
+	})
+	v.RegisterForeign("CharacterGetCollisionFlags3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("CharacterGetCollisionFlags3D requires (world$, body$)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return 0, nil
+		}
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		b, err := getCharacter(w, "CharacterGetCollisionFlags3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return b.character.flags, nil
+	})
+	v.RegisterForeign("CharacterGetGroundNormalY3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("CharacterGetGroundNormalY3D requires (world$, body$)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return 0.0, nil
+		}
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		b, err := getCharacter(w, "CharacterGetGroundNormalY3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		return b.character.groundNormal.y, nil
+	})
+	v.RegisterForeign("CharacterSetStepHeight3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 3 {
+			return nil, fmt.Errorf("CharacterSetStepHeight3D requires (world$, body$, height)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		b, err := getCharacter(w, "CharacterSetStepHeight3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		b.character.stepHeight = math.Max(toFloat64(args[2]), 0)
+		return nil, nil
+	})
+	v.RegisterForeign("CharacterSetMaxSlope3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 3 {
+			return nil, fmt.Errorf("CharacterSetMaxSlope3D requires (world$, body$, degrees)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		b, err := getCharacter(w, "CharacterSetMaxSlope3D", toString(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		setCharacterMaxSlope(b.character, toFloat64(args[2]))
+		return nil, nil
+	})
+}
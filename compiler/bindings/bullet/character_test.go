@@ -0,0 +1,129 @@
+package bullet
+
+import (
+	"math"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+func moveN(t *testing.T, v *vm.VM, world, id string, vx, vz float64, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		mustCall(t, v, "CharacterMove3D", world, id, vx, vz, 1.0/60)
+	}
+}
+
+func TestCharacterWalksStepsAndJumps(t *testing.T) {
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("cc")
+	mustCall(t, v, "CreateWorld3D", "cc", 0.0, -9.81, 0.0)
+	mustCall(t, v, "CreateBox3D", "cc", "floor", 0.0, -0.5, 0.0, 40.0, 1.0, 40.0, 0.0)
+	mustCall(t, v, "CreateBox3D", "cc", "kerb", 3.0, 0.15, 0.0, 2.0, 0.3, 4.0, 0.0) // top at 0.3: climbable
+	mustCall(t, v, "CreateBox3D", "cc", "wall", 0.0, 0.5, 4.0, 4.0, 1.0, 0.5, 0.0)  // 1 unit high: blocks
+	mustCall(t, v, "CreateBox3D", "cc", "roof", -5.0, 2.5, 0.0, 2.0, 1.0, 2.0, 0.0) // underside at 2
+	mustCall(t, v, "CharacterCreate3D", "cc", "hero", 0.0, 2.0, 0.0, 0.4, 1.8)
+
+	moveN(t, v, "cc", "hero", 0, 0, 60)
+	if mustCall(t, v, "CharacterIsGrounded3D", "cc", "hero") != true {
+		t.Fatal("character not grounded after falling onto the floor")
+	}
+	if y := GetPositionY("cc", "hero"); math.Abs(y-0.9) > 1e-6 {
+		t.Fatalf("character at y=%v, want standing on the floor at 0.9", y)
+	}
+
+	moveN(t, v, "cc", "hero", 3, 0, 60) // onto the kerb
+	if x, y := GetPositionX("cc", "hero"), GetPositionY("cc", "hero"); math.Abs(x-3) > 0.01 || math.Abs(y-1.2) > 1e-6 {
+		t.Errorf("character at (%.3f, %.3f), want stepped up onto the kerb at (3, 1.2)", x, y)
+	}
+	moveN(t, v, "cc", "hero", -3, 0, 60) // and back down
+	if x, y := GetPositionX("cc", "hero"), GetPositionY("cc", "hero"); math.Abs(x) > 0.01 || math.Abs(y-0.9) > 1e-6 {
+		t.Errorf("character at (%.3f, %.3f), want back on the floor at (0, 0.9)", x, y)
+	}
+
+	moveN(t, v, "cc", "hero", 0, 3, 90)
+	if z := GetPositionZ("cc", "hero"); math.Abs(z-3.35) > 1e-6 {
+		t.Errorf("character at z=%v, want stopped by the wall at 3.35", z)
+	}
+	if flags := mustCall(t, v, "CharacterGetCollisionFlags3D", "cc", "hero"); flags != characterBelow|characterSides {
+		t.Errorf("collision flags against the wall = %v, want below|sides", flags)
+	}
+
+	if mustCall(t, v, "CharacterJump3D", "cc", "hero", 5.0) != true {
+		t.Fatal("grounded character could not jump")
+	}
+	if mustCall(t, v, "CharacterJump3D", "cc", "hero", 5.0) != false {
+		t.Error("character jumped again in mid-air")
+	}
+	peak := 0.0
+	for i := 0; i < 90; i++ {
+		mustCall(t, v, "CharacterMove3D", "cc", "hero", 0.0, 0.0, 1.0/60)
+		peak = math.Max(peak, GetPositionY("cc", "hero"))
+	}
+	if want := 0.9 + 25/(2*9.81); math.Abs(peak-want) > 0.1 {
+		t.Errorf("jump peaked at y=%.3f, want about %.3f", peak, want)
+	}
+	if mustCall(t, v, "CharacterIsGrounded3D", "cc", "hero") != true || math.Abs(GetPositionY("cc", "hero")-0.9) > 1e-6 {
+		t.Errorf("character did not land: y=%v", GetPositionY("cc", "hero"))
+	}
+
+	SetPosition("cc", "hero", -5, 0.9, 0) // under the roof
+	mustCall(t, v, "CharacterJump3D", "cc", "hero", 5.0)
+	mustCall(t, v, "CharacterMove3D", "cc", "hero", 0.0, 0.0, 1.0/60)
+	for i := 0; i < 10; i++ {
+		mustCall(t, v, "CharacterMove3D", "cc", "hero", 0.0, 0.0, 1.0/60)
+		if flags := toInt(mustCall(t, v, "CharacterGetCollisionFlags3D", "cc", "hero")); flags&characterAbove != 0 {
+			if y := GetPositionY("cc", "hero"); math.Abs(y-1.1) > 1e-6 {
+				t.Errorf("head hit the roof at y=%v, want 1.1", y)
+			}
+			return
+		}
+	}
+	t.Error("jumping under the roof never reported a ceiling hit")
+}
+
+func TestCharacterMaxSlope(t *testing.T) {
+	SetHeightfieldSource(slopeSource) // rises 0.5 per unit along X: about 26.6 degrees
+	defer SetHeightfieldSource(nil)
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("ccslope")
+	mustCall(t, v, "CreateWorld3D", "ccslope", 0.0, -9.81, 0.0)
+	mustCall(t, v, "CreateHeightmap3D", "ccslope", "ground", "slope")
+	mustCall(t, v, "CharacterCreate3D", "ccslope", "walker", -4.0, 0.0, 0.0, 0.4, 1.8)
+	mustCall(t, v, "CharacterCreate3D", "ccslope", "slider", -4.0, 0.0, 4.0, 0.4, 1.8, 0.35, 20.0)
+	cos := 1 / math.Sqrt(1.25)
+	onSlope := func(x float64) float64 { return 0.5*x + 0.5 + 0.4/cos }
+
+	moveN(t, v, "ccslope", "walker", 0, 0, 30)
+	if mustCall(t, v, "CharacterIsGrounded3D", "ccslope", "walker") != true {
+		t.Fatal("character not grounded on a 27 degree slope with a 45 degree limit")
+	}
+	if ny := toFloat64(mustCall(t, v, "CharacterGetGroundNormalY3D", "ccslope", "walker")); math.Abs(ny-cos) > 1e-6 {
+		t.Errorf("ground normal y = %v, want %v", ny, cos)
+	}
+	x0 := GetPositionX("ccslope", "walker")
+	moveN(t, v, "ccslope", "walker", 0, 0, 30)
+	if x := GetPositionX("ccslope", "walker"); x != x0 {
+		t.Errorf("standing character crept from x=%v to %v", x0, x)
+	}
+	moveN(t, v, "ccslope", "walker", 2, 0, 60) // uphill
+	if x, y := GetPositionX("ccslope", "walker"), GetPositionY("ccslope", "walker"); math.Abs(x-(x0+2)) > 0.01 || math.Abs(y-onSlope(x)) > 0.01 {
+		t.Errorf("walker at (%.3f, %.3f), want (%.3f, %.3f) up the slope", x, y, x0+2, onSlope(x0+2))
+	}
+	moveN(t, v, "ccslope", "walker", -2, 0, 60) // downhill, staying grounded
+	if mustCall(t, v, "CharacterIsGrounded3D", "ccslope", "walker") != true {
+		t.Error("character lost the ground walking downhill")
+	}
+
+	moveN(t, v, "ccslope", "slider", 0, 0, 30)
+	if mustCall(t, v, "CharacterIsGrounded3D", "ccslope", "slider") != false {
+		t.Error("character grounded on a slope steeper than its 20 degree limit")
+	}
+	sx := GetPositionX("ccslope", "slider")
+	moveN(t, v, "ccslope", "slider", 2, 0, 60)
+	if x := GetPositionX("ccslope", "slider"); x > sx+0.01 {
+		t.Errorf("character climbed a too-steep slope from x=%.3f to %.3f", sx, x)
+	}
+}
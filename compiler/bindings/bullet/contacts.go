@@ -0,0 +1,223 @@
+package bullet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler/vm"
+)
+
+// contact is one touching (or, for triggers, overlapping) pair in a step. a.id < b.id; normal points from a
+// toward b. point is an estimate (the middle of the overlap) and impulse is the normal impulse the solver applied
+// to separate the pair this step.
+type contact struct {
+	a, b    *body
+	normal  vec3
+	point   vec3
+	impulse float64
+	trigger bool
+	began   bool // first step of this contact
+	ended   bool // the bodies stopped touching this step
+}
+
+// contactKey orders a pair by body id so the same two bodies map to the same contact in every step, whatever
+// order the broadphase returns them in.
+func contactKey(a, b *body) [2]*body {
+	if b.id < a.id {
+		return [2]*body{b, a}
+	}
+	return [2]*body{a, b}
+}
+
+// recordContact adds the pair's contact for this step (the first pass that finds it wins) and returns it.
+// n points from a toward b. Call with w.mu held.
+func recordContact(w *world, a, b *body, n vec3, depth float64) *contact {
+	key := contactKey(a, b)
+	if c := w.contacts[key]; c != nil {
+		return c
+	}
+	if key[0] != a {
+		n = vec3{-n.x, -n.y, -n.z}
+	}
+	c := w.touching[key] // reuse last step's record for a pair that stays in contact
+	if c == nil {
+		c = &contact{a: key[0], b: key[1]}
+	}
+	c.normal, c.point, c.impulse, c.trigger = n, contactPoint(key[0], key[1], n, depth), 0, a.trigger || b.trigger
+	w.contacts[key] = c
+	return c
+}
+
+// contactPoint estimates where a and b touch: the centre of their bounds' overlap, or the deepest point of a
+// sphere's surface when one of them is a sphere.
+func contactPoint(a, b *body, n vec3, depth float64) vec3 {
+	if a.triMesh == nil && a.radius > 0 && !bodyUsesCapsuleBounds(a) {
+		return vec3Add(a.position, vec3Scale(n, a.radius-depth/2))
+	}
+	if b.triMesh == nil && b.radius > 0 && !bodyUsesCapsuleBounds(b) {
+		return vec3Sub(b.position, vec3Scale(n, b.radius-depth/2))
+	}
+	amin, amax := bodyAABB(a)
+	bmin, bmax := bodyAABB(b)
+	return vec3{
+		(math.Max(amin.x, bmin.x) + math.Min(amax.x, bmax.x)) / 2,
+		(math.Max(amin.y, bmin.y) + math.Min(amax.y, bmax.y)) / 2,
+		(math.Max(amin.z, bmin.z) + math.Min(amax.z, bmax.z)) / 2,
+	}
+}
+
+// updateContacts compares this step's contacts with the last step's. New pairs begin, pairs still touching stay
+// and missing pairs end. Pairs between bodies that are both asleep or static are not tested by the broadphase,
+// so they carry over instead of ending. Call with w.mu held.
+func updateContacts(w *world) {
+	w.ended = w.ended[:0]
+	for key, c := range w.touching {
+		if w.contacts[key] != nil {
+			continue
+		}
+		a, b := w.bodies[c.a.id], w.bodies[c.b.id]
+		if a == c.a && b == c.b && a.active && b.active && !bodyIsAwake(a) && !bodyIsAwake(b) {
+			c.began, c.impulse = false, 0
+			w.contacts[key] = c
+			continue
+		}
+		c.ended = true
+		w.ended = append(w.ended, c)
+	}
+	for key, c := range w.contacts {
+		c.began = w.touching[key] == nil
+	}
+	w.touching, w.contacts = w.contacts, w.touching
+	clear(w.contacts)
+}
+
+// contactEvent is one call of an OnCollision3D handler.
+type contactEvent struct {
+	sub  vm.Value // Sub name or function value
+	args []interface{}
+}
+
+// contactEvents lists the handler calls for w's last step: begin, stay and end for solid contacts, enter and exit
+// for triggers, each reported to both bodies with the normal pointing toward the body being told. Events are
+// sorted by body ids so handlers run in the same order every run.
+func contactEvents(v *vm.VM, worldId string, w *world) []contactEvent {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	list := make([]*contact, 0, len(w.touching)+len(w.ended))
+	for _, c := range w.touching {
+		list = append(list, c)
+	}
+	list = append(list, w.ended...)
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].a.id != list[j].a.id {
+			return list[i].a.id < list[j].a.id
+		}
+		return list[i].b.id < list[j].b.id
+	})
+	bus := v.HasEventHandler("Collision3D")
+	var events []contactEvent
+	for _, c := range list {
+		kind := "stay"
+		switch {
+		case c.ended:
+			kind = "end"
+		case c.began:
+			kind = "begin"
+		}
+		if c.trigger {
+			switch kind {
+			case "begin":
+				kind = "enter"
+			case "end":
+				kind = "exit"
+			default:
+				continue
+			}
+		}
+		impulse := c.impulse
+		if kind == "end" || kind == "exit" {
+			impulse = 0
+		}
+		for _, side := range [2]struct {
+			self, other *body
+			n           vec3
+		}{{c.a, c.b, vec3{-c.normal.x, -c.normal.y, -c.normal.z}}, {c.b, c.a, c.normal}} {
+			if bus {
+				v.PostEvent("Collision3D", kind, worldId, side.self.id, side.other.id,
+					side.n.x, side.n.y, side.n.z, impulse, c.point.x, c.point.y, c.point.z)
+			}
+			sub := v.ContactHandler(worldId, side.self.id, kind)
+			if sub == nil {
+				continue
+			}
+			events = append(events, contactEvent{sub, []interface{}{
+				side.other.id, side.n.x, side.n.y, side.n.z, impulse, c.point.x, c.point.y, c.point.z,
+			}})
+		}
+	}
+	return events
+}
+
+// dispatchContacts calls the Subs registered with OnCollision3D for worldId's last step and posts the same
+// contacts as Collision3D events for ON Collision3D handlers, which run at the next ProcessEvents. It runs after
+// the step has released the world lock, so handlers may move, create or destroy bodies.
+func dispatchContacts(v *vm.VM, worldId string, w *world) error {
+	if !v.HasContactHandlers(worldId) && !v.HasEventHandler("Collision3D") {
+		return nil
+	}
+	for _, ev := range contactEvents(v, worldId, w) {
+		if _, err := v.Invoke(ev.sub, ev.args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerContacts3D registers the collision event and trigger foreigns.
+func registerContacts3D(v *vm.VM) {
+	v.RegisterForeign("OnCollision3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 4 {
+			return nil, fmt.Errorf("OnCollision3D requires (world$, body$, event$, handler)")
+		}
+		event := strings.ToLower(toString(args[2]))
+		switch event {
+		case "begin", "stay", "end", "enter", "exit":
+		default:
+			return nil, fmt.Errorf("OnCollision3D: unknown event %q (begin, stay, end, enter, exit)", toString(args[2]))
+		}
+		v.RegisterContactHandler(toString(args[0]), toString(args[1]), event, vm.Callback(args[3]))
+		return nil, nil
+	})
+	v.RegisterForeign("SetTrigger3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 3 {
+			return nil, fmt.Errorf("SetTrigger3D requires (world$, body$, trigger)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return nil, fmt.Errorf("world not found")
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		b := w.bodies[toString(args[1])]
+		if b == nil {
+			return nil, fmt.Errorf("body not found")
+		}
+		b.trigger = toFloat64(args[2]) != 0
+		return nil, nil
+	})
+	v.RegisterForeign("IsTrigger3D", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("IsTrigger3D requires (world$, body$)")
+		}
+		w := getWorld(toString(args[0]))
+		if w == nil {
+			return false, nil
+		}
+		w.mu.RLock()
+		defer w.mu.RUnlock()
+		b := w.bodies[toString(args[1])]
+		return b != nil && b.trigger, nil
+	})
+}
@@ -0,0 +1,86 @@
+package bullet
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+// stepEvents steps world once and returns the handler calls it produced as "sub:other".
+func stepEvents(t *testing.T, v *vm.VM, world string) []string {
+	t.Helper()
+	mustCall(t, v, "Step3D", world, 1.0/60)
+	var out []string
+	for _, ev := range contactEvents(v, world, getWorld(world)) {
+		out = append(out, fmt.Sprintf("%s:%v", ev.sub, ev.args[0]))
+	}
+	return out
+}
+
+func TestContactEvents3D(t *testing.T) {
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("ev")
+	mustCall(t, v, "CreateWorld3D", "ev", 0.0, -9.81, 0.0)
+	mustCall(t, v, "CreateBox3D", "ev", "floor", 0.0, -0.5, 0.0, 20.0, 1.0, 20.0, 0.0)
+	mustCall(t, v, "CreateBox3D", "ev", "zone", 0.0, 1.5, 0.0, 4.0, 0.5, 4.0, 0.0)
+	mustCall(t, v, "SetTrigger3D", "ev", "zone", 1)
+	mustCall(t, v, "CreateSphere3D", "ev", "ball", 0.0, 3.0, 0.0, 0.5, 1.0)
+	for _, h := range [][2]string{
+		{"ball", "begin"}, {"ball", "end"}, {"ball", "enter"}, {"ball", "exit"},
+		{"floor", "begin"}, {"floor", "stay"}, {"zone", "enter"}, {"zone", "exit"},
+	} {
+		mustCall(t, v, "OnCollision3D", "ev", h[0], h[1], h[0]+"_"+h[1])
+	}
+	if mustCall(t, v, "IsTrigger3D", "ev", "zone") != true || mustCall(t, v, "IsTrigger3D", "ev", "ball") != false {
+		t.Fatal("IsTrigger3D does not report SetTrigger3D")
+	}
+	if _, err := v.CallForeign("OnCollision3D", []interface{}{"ev", "ball", "bump", "x"}); err == nil {
+		t.Error("OnCollision3D accepted an unknown event")
+	}
+
+	var log []string
+	for i := 0; i < 120; i++ {
+		log = append(log, strings.Join(stepEvents(t, v, "ev"), ","))
+	}
+	seq := strings.Join(log, "|")
+	for _, want := range []string{"ball_enter:zone,zone_enter:ball", "ball_exit:zone,zone_exit:ball", "ball_begin:floor,floor_begin:ball"} {
+		if strings.Count(seq, want) != 1 {
+			t.Errorf("want %q exactly once, got %q", want, seq)
+		}
+	}
+	if !(strings.Index(seq, "ball_enter") < strings.Index(seq, "ball_exit") && strings.Index(seq, "ball_exit") < strings.Index(seq, "ball_begin")) {
+		t.Errorf("events out of order: %q", seq)
+	}
+	if got := log[len(log)-1]; got != "floor_stay:ball" {
+		t.Errorf("last step events = %q, want the resting ball to keep reporting stay", got)
+	}
+	if bodyIsAwake(getWorld("ev").bodies["ball"]) {
+		t.Error("ball still awake after resting for a second and a half")
+	}
+
+	mustCall(t, v, "SetPosition3D", "ev", "ball", 0.0, 10.0, 0.0)
+	if got := stepEvents(t, v, "ev"); strings.Join(got, ",") != "ball_end:floor" {
+		t.Errorf("lifting the ball away gave %v, want ball_end:floor", got)
+	}
+	// Dropped back in: the floor is told the normal pointing from the ball toward it, and the impulse that stopped it.
+	mustCall(t, v, "SetPosition3D", "ev", "ball", 0.0, 0.45, 0.0)
+	mustCall(t, v, "Step3D", "ev", 1.0/60)
+	found := false
+	for _, ev := range contactEvents(v, "ev", getWorld("ev")) {
+		if ev.sub != "floor_begin" {
+			continue
+		}
+		found = true
+		ny, impulse, py := toFloat64(ev.args[2]), toFloat64(ev.args[4]), toFloat64(ev.args[6])
+		if math.Abs(ny+1) > 1e-6 || impulse <= 0 || math.Abs(py) > 0.1 {
+			t.Errorf("floor_begin args = %v, want normal (0,-1,0), positive impulse and a point near y=0", ev.args)
+		}
+	}
+	if !found {
+		t.Error("dropping the ball back on the floor did not begin a contact")
+	}
+}
@@ -0,0 +1,140 @@
+package bullet
+
+import "math"
+
+// Default sleeping thresholds, the same as Bullet's btRigidBody defaults: a body whose linear speed stays below
+// 0.8 units/s and angular speed below 1 rad/s for sleepDelay seconds is deactivated.
+const (
+	defaultSleepLinear  = 0.8
+	defaultSleepAngular = 1.0
+	sleepDelay          = 1.0
+)
+
+// bodySleepState is the state a body had when it fell asleep. A sleeping body whose state no longer matches was
+// changed from outside the step (SetPosition3D, SetVelocity3D, ApplyImpulse3D, SetMass3D...) and wakes up.
+type bodySleepState struct {
+	position, rotation vec3
+	gravityScale, mass float64
+	kinematic          bool
+}
+
+// bodyCanSleep reports whether b takes part in sleeping: only dynamic bodies do.
+func bodyCanSleep(b *body) bool {
+	return b.mass > 0 && !b.kinematic
+}
+
+// bodyIsAwake reports whether b moves on its own this step and so needs collision tests against its neighbours.
+func bodyIsAwake(b *body) bool {
+	return b.mass > 0 && !b.sleeping
+}
+
+func sleepStateOf(b *body) bodySleepState {
+	return bodySleepState{position: b.position, rotation: b.rotation, gravityScale: b.gravityScale, mass: b.mass, kinematic: b.kinematic}
+}
+
+func sleepBody(b *body) {
+	b.sleeping = true
+	b.velocity = vec3{}
+	b.angularVelocity = vec3{}
+	b.sleepState = sleepStateOf(b)
+}
+
+func wakeBody(b *body) {
+	b.sleeping = false
+	b.sleepTime = 0
+}
+
+// wakeAll wakes every body in w (after a world-wide change such as gravity). Call with w.mu held.
+func wakeAll(w *world) {
+	for _, b := range w.bodies {
+		wakeBody(b)
+	}
+}
+
+// wakeChangedBodies wakes sleeping bodies that were moved, pushed or reconfigured since they fell asleep.
+// Call with w.mu held.
+func wakeChangedBodies(w *world) {
+	for _, b := range w.bodies {
+		if !b.sleeping {
+			continue
+		}
+		if b.velocity != (vec3{}) || b.angularVelocity != (vec3{}) || b.pendingTorque != (vec3{}) || sleepStateOf(b) != b.sleepState {
+			wakeBody(b)
+		}
+	}
+}
+
+// updateSleeping puts resting islands to sleep after a step. Dynamic bodies touching each other (or joined) form
+// an island; an island sleeps only when every body in it has been slower than the world's thresholds for
+// sleepDelay seconds, and a sleeping body in an island with an awake one is woken. Static bodies do not link
+// islands, so crates resting on the same floor sleep independently. Call with w.mu held.
+func updateSleeping(w *world, dt float64) {
+	if w.sleepLinear <= 0 && w.sleepAngular <= 0 {
+		for _, b := range w.bodies {
+			if b.sleeping {
+				wakeBody(b)
+			}
+		}
+		return
+	}
+	parent := make(map[*body]*body)
+	var find func(b *body) *body
+	find = func(b *body) *body {
+		p, ok := parent[b]
+		if !ok || p == b {
+			return b
+		}
+		r := find(p)
+		parent[b] = r
+		return r
+	}
+	union := func(a, b *body) {
+		if a == nil || b == nil || !bodyCanSleep(a) || !bodyCanSleep(b) {
+			return
+		}
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[ra] = rb
+		}
+	}
+	for _, b := range w.bodies {
+		if !b.active || !bodyCanSleep(b) {
+			continue
+		}
+		for _, hit := range b.collisions {
+			union(b, w.bodies[hit.otherId])
+		}
+		if b.sleeping {
+			continue
+		}
+		speed := math.Sqrt(vec3Dot(b.velocity, b.velocity))
+		spin := math.Sqrt(vec3Dot(b.angularVelocity, b.angularVelocity))
+		if (w.sleepLinear <= 0 || speed < w.sleepLinear) && (w.sleepAngular <= 0 || spin < w.sleepAngular) {
+			b.sleepTime += dt
+		} else {
+			b.sleepTime = 0
+		}
+	}
+	for _, j := range w.joints {
+		union(w.bodies[j.bodyA], w.bodies[j.bodyB])
+	}
+
+	restless := make(map[*body]bool) // island roots with a body that must stay awake
+	for _, b := range w.bodies {
+		if b.active && bodyCanSleep(b) && !b.sleeping && b.sleepTime < sleepDelay {
+			restless[find(b)] = true
+		}
+	}
+	for _, b := range w.bodies {
+		if !b.active || !bodyCanSleep(b) {
+			continue
+		}
+		if restless[find(b)] {
+			if b.sleeping {
+				wakeBody(b)
+			}
+		} else if !b.sleeping {
+			sleepBody(b)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package bullet
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// triLeafSize is the most triangles a triMesh BVH leaf holds.
+const triLeafSize = 8
+
+// triMesh is the collision shape of a static mesh or heightfield body: its triangles in body-local space plus a
+// bounding volume hierarchy so contact and ray queries only visit triangles near the query box.
+type triMesh struct {
+	tris     []triangle
+	nodes    []triNode
+	oneSided bool // heightfields: contacts always push along the face normal (out of the ground)
+}
+
+// triNode is a BVH node; leaves have count > 0 and cover tris[start:start+count].
+type triNode struct {
+	min, max     vec3
+	left, right  int
+	start, count int
+}
+
+// Heightfield is a grid of terrain heights for CreateHeightmap3D. Heights holds Depth rows (along Z) of Width
+// samples (along X) in world units; the grid spans SizeX by SizeZ centred on the body position, split into
+// triangles the same way the terrain package builds its render mesh.
+type Heightfield struct {
+	Width, Depth int
+	Heights      []float64
+	SizeX, SizeZ float64
+	X, Y, Z      float64 // position the terrain is drawn at; the default body position
+}
+
+var heightfieldSource func(id string) (*Heightfield, error)
+
+// SetHeightfieldSource installs the lookup CreateHeightmap3D uses to turn a terrain id into heights
+// (bindings.RegisterAll wires the terrain package's).
+func SetHeightfieldSource(fn func(id string) (*Heightfield, error)) {
+	heightfieldSource = fn
+}
+
+// heightfieldTriangles converts hf into local-space triangles: cell (i, j) with corners a=(i,j), b=(i+1,j),
+// c=(i,j+1), d=(i+1,j+1) becomes (a,c,b) and (b,c,d), both facing up.
+func heightfieldTriangles(hf *Heightfield) ([]triangle, error) {
+	if hf.Width < 2 || hf.Depth < 2 || len(hf.Heights) < hf.Width*hf.Depth {
+		return nil, fmt.Errorf("heightfield needs at least 2x2 samples")
+	}
+	if hf.SizeX <= 0 || hf.SizeZ <= 0 {
+		return nil, fmt.Errorf("heightfield size must be positive")
+	}
+	stepX := hf.SizeX / float64(hf.Width-1)
+	stepZ := hf.SizeZ / float64(hf.Depth-1)
+	at := func(i, j int) vec3 {
+		return vec3{float64(i)*stepX - hf.SizeX/2, hf.Heights[j*hf.Width+i], float64(j)*stepZ - hf.SizeZ/2}
+	}
+	tris := make([]triangle, 0, (hf.Width-1)*(hf.Depth-1)*2)
+	for j := 0; j < hf.Depth-1; j++ {
+		for i := 0; i < hf.Width-1; i++ {
+			a, b, c, d := at(i, j), at(i+1, j), at(i, j+1), at(i+1, j+1)
+			tris = append(tris, triangle{a, c, b}, triangle{b, c, d})
+		}
+	}
+	return tris, nil
+}
+
+func triangleBounds(t triangle) (min, max vec3) {
+	min, max = t.v0, t.v0
+	for _, p := range []vec3{t.v1, t.v2} {
+		min = vec3{math.Min(min.x, p.x), math.Min(min.y, p.y), math.Min(min.z, p.z)}
+		max = vec3{math.Max(max.x, p.x), math.Max(max.y, p.y), math.Max(max.z, p.z)}
+	}
+	return min, max
+}
+
+// newTriMesh builds the BVH by splitting triangles at the median of the longest axis of their centroids.
+// tris is reordered in place.
+func newTriMesh(tris []triangle, oneSided bool) *triMesh {
+	m := &triMesh{tris: tris, oneSided: oneSided}
+	if len(tris) > 0 {
+		m.build(0, len(tris))
+	}
+	return m
+}
+
+func (m *triMesh) build(start, end int) int {
+	idx := len(m.nodes)
+	m.nodes = append(m.nodes, triNode{})
+	n := triNode{start: start}
+	n.min, n.max = triangleBounds(m.tris[start])
+	cmin, cmax := vec3{math.Inf(1), math.Inf(1), math.Inf(1)}, vec3{math.Inf(-1), math.Inf(-1), math.Inf(-1)}
+	for _, t := range m.tris[start:end] {
+		tmin, tmax := triangleBounds(t)
+		n.min = vec3{math.Min(n.min.x, tmin.x), math.Min(n.min.y, tmin.y), math.Min(n.min.z, tmin.z)}
+		n.max = vec3{math.Max(n.max.x, tmax.x), math.Max(n.max.y, tmax.y), math.Max(n.max.z, tmax.z)}
+		c := triangleCentroid(t)
+		cmin = vec3{math.Min(cmin.x, c.x), math.Min(cmin.y, c.y), math.Min(cmin.z, c.z)}
+		cmax = vec3{math.Max(cmax.x, c.x), math.Max(cmax.y, c.y), math.Max(cmax.z, c.z)}
+	}
+	if end-start <= triLeafSize {
+		n.count = end - start
+		m.nodes[idx] = n
+		return idx
+	}
+	axis := func(v vec3) float64 { return v.x }
+	if ext := vec3Sub(cmax, cmin); ext.y > ext.x && ext.y >= ext.z {
+		axis = func(v vec3) float64 { return v.y }
+	} else if ext.z > ext.x && ext.z > ext.y {
+		axis = func(v vec3) float64 { return v.z }
+	}
+	part := m.tris[start:end]
+	sort.Slice(part, func(i, j int) bool { return axis(triangleCentroid(part[i])) < axis(triangleCentroid(part[j])) })
+	mid := start + (end-start)/2
+	n.left = m.build(start, mid)
+	n.right = m.build(mid, end)
+	m.nodes[idx] = n
+	return idx
+}
+
+func triangleCentroid(t triangle) vec3 {
+	return vec3{(t.v0.x + t.v1.x + t.v2.x) / 3, (t.v0.y + t.v1.y + t.v2.y) / 3, (t.v0.z + t.v1.z + t.v2.z) / 3}
+}
+
+// query calls fn with every triangle whose bounds overlap [min, max] (local space).
+func (m *triMesh) query(min, max vec3, fn func(t triangle)) {
+	if len(m.nodes) == 0 {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		n := &m.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if n.min.x > max.x || n.max.x < min.x || n.min.y > max.y || n.max.y < min.y || n.min.z > max.z || n.max.z < min.z {
+			continue
+		}
+		if n.count > 0 {
+			for _, t := range m.tris[n.start : n.start+n.count] {
+				fn(t)
+			}
+			continue
+		}
+		stack = append(stack, n.left, n.right)
+	}
+}
+
+// raycast returns the nearest hit t in [0, maxDist] of the local-space ray o + t*d and the face normal there
+// (turned toward the ray origin unless the mesh is one-sided).
+func (m *triMesh) raycast(o, d vec3, maxDist float64) (best float64, normal vec3, ok bool) {
+	best = maxDist
+	if len(m.nodes) == 0 {
+		return 0, vec3{}, false
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		n := &m.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		t := rayAABB(o.x, o.y, o.z, d.x, d.y, d.z, n.min.x, n.min.y, n.min.z, n.max.x, n.max.y, n.max.z)
+		if t < 0 || t > best {
+			continue
+		}
+		if n.count == 0 {
+			stack = append(stack, n.left, n.right)
+			continue
+		}
+		for _, tri := range m.tris[n.start : n.start+n.count] {
+			if th, hit := rayTriangle(o, d, tri); hit && th <= best {
+				nf := triangleNormal(tri)
+				if vec3Dot(nf, d) > 0 {
+					if m.oneSided {
+						continue // from below the ground
+					}
+					nf = vec3Scale(nf, -1)
+				}
+				best, normal, ok = th, nf, true
+			}
+		}
+	}
+	return best, normal, ok
+}
+
+// rayTriangle is the Möller-Trumbore ray/triangle test.
+func rayTriangle(o, d vec3, t triangle) (float64, bool) {
+	const eps = 1e-12
+	e1, e2 := vec3Sub(t.v1, t.v0), vec3Sub(t.v2, t.v0)
+	p := vec3Cross(d, e2)
+	det := vec3Dot(e1, p)
+	if math.Abs(det) < eps {
+		return 0, false
+	}
+	inv := 1 / det
+	s := vec3Sub(o, t.v0)
+	u := vec3Dot(s, p) * inv
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+	q := vec3Cross(s, e1)
+	v := vec3Dot(d, q) * inv
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+	th := vec3Dot(e2, q) * inv
+	return th, th >= 0
+}
+
+// rayBody intersects a ray with body b: triangles for mesh and heightfield bodies, the AABB otherwise (whose
+// normal is reported as facing back along the ray, as before triangle meshes existed).
+func rayBody(b *body, o, d vec3, maxDist float64) (t float64, normal vec3, ok bool) {
+	if b.triMesh != nil {
+		t, normal, ok = b.triMesh.raycast(vec3Sub(o, b.position), d, maxDist)
+		return t, normal, ok
+	}
+	min, max := bodyAABB(b)
+	t = rayAABB(o.x, o.y, o.z, d.x, d.y, d.z, min.x, min.y, min.z, max.x, max.y, max.z)
+	return t, vec3{-d.x, -d.y, -d.z}, t >= 0 && t < maxDist
+}
+
+func vec3Sub(a, b vec3) vec3 { return vec3{a.x - b.x, a.y - b.y, a.z - b.z} }
+
+func vec3Add(a, b vec3) vec3 { return vec3{a.x + b.x, a.y + b.y, a.z + b.z} }
+
+func vec3Scale(a vec3, s float64) vec3 { return vec3{a.x * s, a.y * s, a.z * s} }
+
+func triangleNormal(t triangle) vec3 {
+	n := vec3Cross(vec3Sub(t.v1, t.v0), vec3Sub(t.v2, t.v0))
+	vec3Norm(&n)
+	return n
+}
+
+// closestPointOnTriangle returns the point of t nearest p (Ericson, Real-Time Collision Detection 5.1.5).
+func closestPointOnTriangle(p vec3, t triangle) vec3 {
+	a, b, c := t.v0, t.v1, t.v2
+	ab, ac, ap := vec3Sub(b, a), vec3Sub(c, a), vec3Sub(p, a)
+	d1, d2 := vec3Dot(ab, ap), vec3Dot(ac, ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+	bp := vec3Sub(p, b)
+	d3, d4 := vec3Dot(ab, bp), vec3Dot(ac, bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return vec3Add(a, vec3Scale(ab, d1/(d1-d3)))
+	}
+	cp := vec3Sub(p, c)
+	d5, d6 := vec3Dot(ab, cp), vec3Dot(ac, cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return vec3Add(a, vec3Scale(ac, d2/(d2-d6)))
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		return vec3Add(b, vec3Scale(vec3Sub(c, b), (d4-d3)/((d4-d3)+(d5-d6))))
+	}
+	denom := 1 / (va + vb + vc)
+	return vec3Add(a, vec3Add(vec3Scale(ab, vb*denom), vec3Scale(ac, vc*denom)))
+}
+
+// closestPointsSegments returns the closest points of segments p1-q1 and p2-q2 (Ericson 5.1.9).
+func closestPointsSegments(p1, q1, p2, q2 vec3) (c1, c2 vec3) {
+	const eps = 1e-12
+	d1, d2, r := vec3Sub(q1, p1), vec3Sub(q2, p2), vec3Sub(p1, p2)
+	a, e, f := vec3Dot(d1, d1), vec3Dot(d2, d2), vec3Dot(d2, r)
+	var s, t float64
+	switch {
+	case a <= eps && e <= eps:
+		return p1, p2
+	case a <= eps:
+		t = clamp(f/e, 0, 1)
+	default:
+		c := vec3Dot(d1, r)
+		if e <= eps {
+			s = clamp(-c/a, 0, 1)
+		} else {
+			b := vec3Dot(d1, d2)
+			if denom := a*e - b*b; denom != 0 {
+				s = clamp((b*f-c*e)/denom, 0, 1)
+			}
+			t = (b*s + f) / e
+			if t < 0 {
+				t, s = 0, clamp(-c/a, 0, 1)
+			} else if t > 1 {
+				t, s = 1, clamp((b-c)/a, 0, 1)
+			}
+		}
+	}
+	return vec3Add(p1, vec3Scale(d1, s)), vec3Add(p2, vec3Scale(d2, t))
+}
+
+// pointProjectsInside reports whether p, projected along the face normal n, lands inside t.
+func pointProjectsInside(p vec3, t triangle, n vec3) bool {
+	edges := [3][2]vec3{{t.v0, t.v1}, {t.v1, t.v2}, {t.v2, t.v0}}
+	for _, e := range edges {
+		if vec3Dot(vec3Cross(vec3Sub(e[1], e[0]), vec3Sub(p, e[0])), n) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// capsuleTriangle tests the capsule around segment p0-p1 with radius r (a sphere when p0 == p1) against t.
+// The normal points from the triangle toward the capsule, i.e. the direction to push the capsule out.
+func capsuleTriangle(p0, p1 vec3, r float64, t triangle, oneSided bool) (n vec3, depth float64) {
+	nf := triangleNormal(t)
+	if nf == (vec3{}) {
+		return vec3{}, 0 // degenerate
+	}
+	mid := vec3Scale(vec3Add(p0, p1), 0.5)
+	if !oneSided && vec3Dot(vec3Sub(mid, t.v0), nf) < 0 {
+		nf = vec3Scale(nf, -1)
+	}
+	// Face contact: the endpoint deepest along the normal is over the triangle.
+	s0, s1 := vec3Dot(vec3Sub(p0, t.v0), nf), vec3Dot(vec3Sub(p1, t.v0), nf)
+	low, s := p0, s0
+	if s1 < s0 {
+		low, s = p1, s1
+	}
+	if s < r && pointProjectsInside(low, t, nf) {
+		return nf, r - s // for one-sided ground this also lifts a capsule that sank below the surface
+	}
+	// Edge or vertex contact: nearest point between the segment and the triangle's boundary.
+	best := math.Inf(1)
+	var bestV vec3
+	try := func(onSeg, onTri vec3) {
+		v := vec3Sub(onSeg, onTri)
+		if d := vec3Dot(v, v); d < best {
+			best, bestV = d, v
+		}
+	}
+	for _, e := range [3][2]vec3{{t.v0, t.v1}, {t.v1, t.v2}, {t.v2, t.v0}} {
+		cs, ct := closestPointsSegments(p0, p1, e[0], e[1])
+		try(cs, ct)
+	}
+	try(p0, closestPointOnTriangle(p0, t))
+	try(p1, closestPointOnTriangle(p1, t))
+	if best >= r*r {
+		return vec3{}, 0
+	}
+	d := math.Sqrt(best)
+	if d < 1e-9 {
+		return nf, r
+	}
+	n = vec3Scale(bestV, 1/d)
+	if oneSided && vec3Dot(n, nf) < 0 {
+		return vec3{}, 0 // touching a ground edge from below
+	}
+	return n, r - d
+}
+
+// boxTriangle is a separating-axis test of the axis-aligned box (center c, half extents h) against t. It returns
+// the axis of least penetration, oriented from the triangle toward the box; the face normal wins near-ties so
+// boxes slide over shared edges of flat ground instead of catching on them.
+func boxTriangle(c, h vec3, t triangle, oneSided bool) (n vec3, depth float64) {
+	nf := triangleNormal(t)
+	if nf == (vec3{}) {
+		return vec3{}, 0
+	}
+	if !oneSided && vec3Dot(vec3Sub(c, t.v0), nf) < 0 {
+		nf = vec3Scale(nf, -1)
+	}
+	faceDepth := h.x*math.Abs(nf.x) + h.y*math.Abs(nf.y) + h.z*math.Abs(nf.z) - vec3Dot(vec3Sub(c, t.v0), nf)
+	if faceDepth <= 0 {
+		return vec3{}, 0
+	}
+	if oneSided && pointProjectsInside(c, t, nf) {
+		return nf, faceDepth // over (or sunk into) the ground: always push up
+	}
+	bestDepth, bestAxis := faceDepth, nf
+	edges := [3]vec3{vec3Sub(t.v1, t.v0), vec3Sub(t.v2, t.v1), vec3Sub(t.v0, t.v2)}
+	axes := []vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	for _, e := range edges {
+		for _, a := range [3]vec3{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+			axes = append(axes, vec3Cross(a, e))
+		}
+	}
+	for _, axis := range axes {
+		if vec3Norm(&axis) == 0 {
+			continue
+		}
+		rb := h.x*math.Abs(axis.x) + h.y*math.Abs(axis.y) + h.z*math.Abs(axis.z)
+		cb := vec3Dot(c, axis)
+		p0, p1, p2 := vec3Dot(t.v0, axis), vec3Dot(t.v1, axis), vec3Dot(t.v2, axis)
+		tmin, tmax := math.Min(p0, math.Min(p1, p2)), math.Max(p0, math.Max(p1, p2))
+		up, down := tmax-(cb-rb), (cb+rb)-tmin // push along +axis / -axis
+		if up <= 0 || down <= 0 {
+			return vec3{}, 0 // separated
+		}
+		d, dir := up, axis
+		if down < up {
+			d, dir = down, vec3Scale(axis, -1)
+		}
+		if d < bestDepth*0.95-1e-4 {
+			bestDepth, bestAxis = d, dir
+		}
+	}
+	if oneSided && vec3Dot(bestAxis, nf) < 0 {
+		return vec3{}, 0
+	}
+	return bestAxis, bestDepth
+}
+
+// overlapTriangles tests the convex body shape (sphere, capsule, box or compound of boxes) against the triangles of
+// the mesh or heightfield body tris. It returns the deepest contact with the normal pointing from tris toward
+// shape; the solver's passes pick up the remaining contacts.
+func overlapTriangles(shape, tris *body) (nx, ny, nz, depth float64) {
+	m := tris.triMesh
+	if m == nil || shape.triMesh != nil {
+		return 0, 0, 0, 0
+	}
+	min, max := bodyAABB(shape)
+	min, max = vec3Sub(min, tris.position), vec3Sub(max, tris.position)
+	pos := vec3Sub(shape.position, tris.position)
+	var best vec3
+	keep := func(n vec3, d float64) {
+		if d > depth {
+			best, depth = n, d
+		}
+	}
+	switch {
+	case bodyIsCompound(shape):
+		for _, p := range shape.compound {
+			c, h := vec3Add(pos, vec3{p.ox, p.oy, p.oz}), vec3{p.hx, p.hy, p.hz}
+			m.query(vec3Sub(c, h), vec3Add(c, h), func(t triangle) { keep(boxTriangle(c, h, t, m.oneSided)) })
+		}
+	case bodyUsesCapsuleBounds(shape):
+		half := shape.halfExt.y - shape.radius
+		if half < 0 {
+			half = 0
+		}
+		p0, p1 := vec3Add(pos, vec3{0, -half, 0}), vec3Add(pos, vec3{0, half, 0})
+		m.query(min, max, func(t triangle) { keep(capsuleTriangle(p0, p1, shape.radius, t, m.oneSided)) })
+	case shape.radius > 0:
+		m.query(min, max, func(t triangle) { keep(capsuleTriangle(pos, pos, shape.radius, t, m.oneSided)) })
+	default:
+		m.query(min, max, func(t triangle) { keep(boxTriangle(pos, shape.halfExt, t, m.oneSided)) })
+	}
+	return best.x, best.y, best.z, depth
+}
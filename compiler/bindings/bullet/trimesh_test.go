@@ -0,0 +1,117 @@
+package bullet
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+// slopeSource serves one terrain, "slope": 21x21 samples over 20x20 units rising 0.5 per unit along X.
+func slopeSource(id string) (*Heightfield, error) {
+	hf := &Heightfield{Width: 21, Depth: 21, SizeX: 20, SizeZ: 20}
+	for j := 0; j < hf.Depth; j++ {
+		for i := 0; i < hf.Width; i++ {
+			hf.Heights = append(hf.Heights, 0.5*(float64(i)-10))
+		}
+	}
+	return hf, nil
+}
+
+func mustCall(t *testing.T, v *vm.VM, name string, args ...interface{}) interface{} {
+	t.Helper()
+	got, err := v.CallForeign(name, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", name, err)
+	}
+	return got
+}
+
+func TestShapesRestOnHeightfieldSlope(t *testing.T) {
+	SetHeightfieldSource(slopeSource)
+	defer SetHeightfieldSource(nil)
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("hf")
+	mustCall(t, v, "CreateWorld3D", "hf", 0.0, -9.81, 0.0)
+	mustCall(t, v, "CreateHeightmap3D", "hf", "ground", "slope")
+	mustCall(t, v, "CreateSphere3D", "hf", "ball", 2.0, 3.0, 0.0, 0.5, 1.0)
+	mustCall(t, v, "CreateCapsule3D", "hf", "hero", -4.0, 1.0, 3.0, 0.4, 1.8, 1.0)
+	mustCall(t, v, "CreateBox3D", "hf", "crate", 0.0, 2.0, -4.0, 1.0, 1.0, 1.0, 1.0)
+	for _, id := range []string{"ball", "hero", "crate"} {
+		mustCall(t, v, "SetFriction3D", "hf", id, 1.0)
+	}
+	stepN(t, v, "hf", 120)
+
+	slope := func(x float64) float64 { return 0.5 * x }
+	cos := 1 / math.Sqrt(1.25) // cosine of the slope angle
+	if x, y := GetPositionX("hf", "ball"), GetPositionY("hf", "ball"); math.Abs(y-(slope(x)+0.5/cos)) > 0.05 {
+		t.Errorf("ball at (%.3f, %.3f), want resting on the slope at y=%.3f", x, y, slope(x)+0.5/cos)
+	}
+	// The capsule's lower sphere (0.5 below its center) rests on the slope.
+	if x, y := GetPositionX("hf", "hero"), GetPositionY("hf", "hero"); math.Abs(y-(slope(x)+0.5+0.4/cos)) > 0.05 {
+		t.Errorf("capsule at (%.3f, %.3f), want resting on the slope at y=%.3f", x, y, slope(x)+0.5+0.4/cos)
+	}
+	// The box rests on its lower corner edge: its bottom is half a unit below its center, on the uphill side.
+	if x, y := GetPositionX("hf", "crate"), GetPositionY("hf", "crate"); y < slope(x)+0.4 || y > slope(x+0.5)+0.55 {
+		t.Errorf("crate at (%.3f, %.3f), want on the slope (surface %.3f..%.3f)", x, y, slope(x-0.5), slope(x+0.5))
+	}
+
+	if n := mustCall(t, v, "GetCollisionCount3D", "hf", "ball"); n != 1 {
+		t.Fatalf("ball contact count = %v, want 1", n)
+	}
+	nx := toF(mustCall(t, v, "GetCollisionNormalX3D", "hf", "ball", 0))
+	ny := toF(mustCall(t, v, "GetCollisionNormalY3D", "hf", "ball", 0))
+	if math.Abs(nx+0.5*cos) > 0.02 || math.Abs(ny-cos) > 0.02 {
+		t.Errorf("ball contact normal = (%.3f, %.3f), want the slope normal (%.3f, %.3f)", nx, ny, -0.5*cos, cos)
+	}
+
+	if hit := mustCall(t, v, "RayCastFromDir3D", "hf", 6.0, 50.0, 6.0, 0.0, -1.0, 0.0, 100.0); hit != 1 {
+		t.Fatal("ray down onto the heightfield missed")
+	}
+	if y, body := RayHitY3D(), RayHitBody3D(); body != "ground" || math.Abs(y-3) > 1e-6 {
+		t.Errorf("ray hit %s at y=%v, want ground at 3", body, y)
+	}
+	if nx := RayHitNormalX3D(); math.Abs(nx+0.5*cos) > 1e-6 {
+		t.Errorf("ray hit normal x = %v, want %v", nx, -0.5*cos)
+	}
+}
+
+func TestStaticMeshUsesTriangles(t *testing.T) {
+	// One triangle covering the -X half of a 10x10 square: the AABB covers the whole square, the mesh does not.
+	path := filepath.Join(t.TempDir(), "half.obj")
+	obj := "v -5 0 -5\nv -5 0 5\nv 5 0 -5\nf 1 2 3\n"
+	if err := os.WriteFile(path, []byte(obj), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v := vm.NewVM()
+	RegisterBullet(v)
+	defer dropWorld("mesh")
+	mustCall(t, v, "CreateWorld3D", "mesh", 0.0, -9.81, 0.0)
+	mustCall(t, v, "CreateStaticMesh3D", "mesh", "level", path)
+	mustCall(t, v, "CreateSphere3D", "mesh", "on", -3.0, 1.0, -3.0, 0.5, 1.0)
+	mustCall(t, v, "CreateSphere3D", "mesh", "off", 3.0, 1.0, 3.0, 0.5, 1.0)
+	stepN(t, v, "mesh", 90)
+	if y := GetPositionY("mesh", "on"); math.Abs(y-0.5) > 0.02 {
+		t.Errorf("sphere over the triangle at y=%v, want resting at 0.5", y)
+	}
+	if y := GetPositionY("mesh", "off"); y > -1 {
+		t.Errorf("sphere beside the triangle at y=%v, want it to fall past the mesh", y)
+	}
+}
+
+func TestBoxTriangleSlidesOverSharedEdges(t *testing.T) {
+	// Two coplanar triangles sharing the edge x=0; a box straddling the edge must be pushed straight up.
+	left := triangle{vec3{-2, 0, 0}, vec3{0, 0, 2}, vec3{0, 0, -2}}
+	right := triangle{vec3{2, 0, 0}, vec3{0, 0, -2}, vec3{0, 0, 2}}
+	for _, tri := range []triangle{left, right} {
+		for _, oneSided := range []bool{true, false} {
+			n, d := boxTriangle(vec3{0.1, 0.45, 0}, vec3{0.5, 0.5, 0.5}, tri, oneSided)
+			if math.Abs(n.y-1) > 1e-9 || math.Abs(d-0.05) > 1e-9 {
+				t.Errorf("box vs %v (one-sided %v): normal %v depth %v, want (0,1,0) and 0.05", tri, oneSided, n, d)
+			}
+		}
+	}
+}
@@ -1,4 +1,5 @@
-// Package bulletdot exposes global "bullet" as a modfacade over flat 3D physics foreigns.
+// Package bulletdot exposes global "bullet" as a modfacade over flat 3D physics foreigns, plus
+// bullet.character(...) → CharacterDot over the kinematic character controller.
 package bulletdot
 
 import (
@@ -20,7 +21,7 @@ var bulletNames = []string{
 	"SetAngularVelocity3D", "GetAngularVelocityX3D", "GetAngularVelocityY3D", "GetAngularVelocityZ3D",
 	"ApplyForce3D", "ApplyImpulse3D", "ApplyTorque3D", "ApplyTorqueImpulse3D",
 	"SetFriction3D", "SetRestitution3D", "SetDamping3D", "SetKinematic3D", "SetGravity3D", "SetMass3D", "GetMass3D",
-	"SetLinearFactor3D", "SetAngularFactor3D", "SetCCD3D",
+	"SetLinearFactor3D", "SetAngularFactor3D", "SetCCD3D", "SetSleepThreshold3D", "IsSleeping3D",
 	"BulletJointsAvailable", "CreateHingeJoint3D", "CreateSliderJoint3D", "CreateConeTwistJoint3D",
 	"CreatePointToPointJoint3D", "CreateFixedJoint3D", "SetJointLimits3D", "SetJointMotor3D",
 	"RayCast3D", "RayCastFromDir3D",
@@ -29,6 +30,9 @@ var bulletNames = []string{
 	"PhysicsEnable", "PhysicsDisable", "PhysicsSetGravity",
 	"CreateRigidBody", "ApplyForce", "ApplyImpulse", "SetBodyPosition", "GetBodyPosition", "SetBodyVelocity", "GetBodyVelocity",
 	"CheckCollision3D",
+	"CharacterCreate3D", "CharacterMove3D", "CharacterJump3D", "CharacterIsGrounded3D", "CharacterGetCollisionFlags3D",
+	"CharacterGetGroundNormalY3D", "CharacterSetStepHeight3D", "CharacterSetMaxSlope3D",
+	"OnCollision3D", "SetTrigger3D", "IsTrigger3D",
 }
 
 func lowerMap(names []string) map[string]string {
@@ -41,5 +45,5 @@ func lowerMap(names []string) map[string]string {
 
 // Register installs global "bullet" after bullet.RegisterBullet.
 func Register(v *vm.VM) {
-	v.SetGlobal("bullet", modfacade.New(v, lowerMap(bulletNames)))
+	v.SetGlobal("bullet", &bulletModuleDot{ModuleDot: modfacade.New(v, lowerMap(bulletNames)), v: v})
 }
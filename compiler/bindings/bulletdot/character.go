@@ -0,0 +1,138 @@
+package bulletdot
+
+import (
+	"fmt"
+	"strings"
+
+	"cyberbasic/compiler/bindings/modfacade"
+	"cyberbasic/compiler/vm"
+)
+
+// bulletModuleDot is the "bullet" namespace: flat foreigns through modfacade, plus character(...).
+type bulletModuleDot struct {
+	*modfacade.ModuleDot
+	v *vm.VM
+}
+
+func (b *bulletModuleDot) CallMethod(name string, args []vm.Value) (vm.Value, error) {
+	if strings.ToLower(name) != "character" {
+		return b.ModuleDot.CallMethod(name, args)
+	}
+	if len(args) < 7 {
+		return nil, fmt.Errorf("bullet.character requires (world$, body$, x, y, z, radius, height [, stepHeight, maxSlopeDegrees])")
+	}
+	if _, err := b.v.CallForeign("CharacterCreate3D", toInterfaces(args)); err != nil {
+		return nil, err
+	}
+	return &CharacterDot{v: b.v, world: fmt.Sprint(args[0]), id: fmt.Sprint(args[1])}, nil
+}
+
+// CharacterDot wraps a character controller body; methods call the Character*3D foreigns with world and id prepended.
+type CharacterDot struct {
+	v     *vm.VM
+	world string
+	id    string
+}
+
+func (c *CharacterDot) call(fn string, tail []vm.Value) (vm.Value, error) {
+	ia := make([]interface{}, 0, 2+len(tail))
+	ia = append(ia, c.world, c.id)
+	return c.v.CallForeign(fn, append(ia, toInterfaces(tail)...))
+}
+
+func (c *CharacterDot) GetProp(path []string) (vm.Value, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	switch strings.ToLower(path[0]) {
+	case "world":
+		return c.world, nil
+	case "id":
+		return c.id, nil
+	case "x":
+		return c.call("GetPositionX3D", nil)
+	case "y":
+		return c.call("GetPositionY3D", nil)
+	case "z":
+		return c.call("GetPositionZ3D", nil)
+	case "vx":
+		return c.call("GetVelocityX3D", nil)
+	case "vy":
+		return c.call("GetVelocityY3D", nil)
+	case "vz":
+		return c.call("GetVelocityZ3D", nil)
+	case "grounded":
+		return c.call("CharacterIsGrounded3D", nil)
+	case "flags":
+		return c.call("CharacterGetCollisionFlags3D", nil)
+	case "groundnormaly":
+		return c.call("CharacterGetGroundNormalY3D", nil)
+	default:
+		return nil, fmt.Errorf("character: unknown property %q (world, id, x, y, z, vx, vy, vz, grounded, flags, groundnormaly)", path[0])
+	}
+}
+
+func (c *CharacterDot) SetProp(path []string, val vm.Value) error {
+	if len(path) != 1 {
+		return fmt.Errorf("character: nested property set not supported")
+	}
+	p := strings.ToLower(path[0])
+	switch p {
+	case "x", "y", "z":
+		x, _ := c.call("GetPositionX3D", nil)
+		y, _ := c.call("GetPositionY3D", nil)
+		z, _ := c.call("GetPositionZ3D", nil)
+		switch p {
+		case "x":
+			x = val
+		case "y":
+			y = val
+		case "z":
+			z = val
+		}
+		_, err := c.call("SetPosition3D", []vm.Value{x, y, z})
+		return err
+	case "stepheight":
+		_, err := c.call("CharacterSetStepHeight3D", []vm.Value{val})
+		return err
+	case "maxslope":
+		_, err := c.call("CharacterSetMaxSlope3D", []vm.Value{val})
+		return err
+	default:
+		return fmt.Errorf("character: unknown or read-only property %q", path[0])
+	}
+}
+
+func (c *CharacterDot) CallMethod(name string, args []vm.Value) (vm.Value, error) {
+	switch strings.ToLower(name) {
+	case "move":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("move(vx, vz, dt) requires 3 arguments; flat: CharacterMove3D")
+		}
+		return c.call("CharacterMove3D", args)
+	case "jump":
+		if len(args) < 1 {
+			return nil, fmt.Errorf("jump(speed) requires 1 argument; flat: CharacterJump3D")
+		}
+		return c.call("CharacterJump3D", args)
+	case "isgrounded":
+		return c.call("CharacterIsGrounded3D", nil)
+	case "position", "setposition":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("position(x, y, z) requires 3 arguments; flat: SetPosition3D")
+		}
+		return c.call("SetPosition3D", args)
+	case "destroy":
+		return c.call("DestroyBody3D", nil)
+	default:
+		return nil, fmt.Errorf("character: unknown method %q (move, jump, isgrounded, position, destroy)", name)
+	}
+}
+
+func toInterfaces(args []vm.Value) []interface{} {
+	ia := make([]interface{}, len(args))
+	for i := range args {
+		ia[i] = args[i]
+	}
+	return ia
+}
@@ -31,15 +31,16 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	"cyberbasic/compiler/bindings/raylib"
 	"cyberbasic/compiler/bindings/terrain"
 	"cyberbasic/compiler/bindings/water"
+	"cyberbasic/compiler/rng"
 	"cyberbasic/compiler/runtime"
 	"cyberbasic/compiler/runtime/assets"
 	"cyberbasic/compiler/runtime/camera"
 	"cyberbasic/compiler/runtime/errors"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/runtime/renderer"
 	gametime "cyberbasic/compiler/runtime/time"
 	"cyberbasic/compiler/vm"
@@ -159,6 +160,23 @@ func newDbpObject(model rl.Model) *dbpObject {
 	return obj
 }
 
+// loadModel is rl.LoadModel, or an empty model when headless: the object keeps its transform, color and
+// collision but has nothing to draw.
+func loadModel(path string) rl.Model {
+	if headless.Enabled() {
+		return rl.Model{}
+	}
+	return rl.LoadModel(path)
+}
+
+// meshModel returns a model of the mesh gen generates, or an empty model when headless (see loadModel).
+func meshModel(gen func() rl.Mesh) rl.Model {
+	if headless.Enabled() {
+		return rl.Model{}
+	}
+	return rl.LoadModelFromMesh(gen())
+}
+
 func toInt(v interface{}) int {
 	switch x := v.(type) {
 	case int:
@@ -573,7 +591,7 @@ func RegisterDBP(v *vm.VM) {
 		}
 		path := toString(args[0])
 		id := toInt(args[1])
-		model := rl.LoadModel(path)
+		model := loadModel(path)
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -593,7 +611,7 @@ func RegisterDBP(v *vm.VM) {
 			if err == nil && len(m.Animations) > 0 {
 				// Animated GLTF: use raylib for bones + animation support (asset cache doesn't apply)
 				assets.UnloadModelForBuild(path)
-				rlModel := rl.LoadModel(path)
+				rlModel := loadModel(path)
 				objectsMu.Lock()
 				objects[id] = newDbpObject(rlModel)
 				objectsMu.Unlock()
@@ -640,8 +658,7 @@ func RegisterDBP(v *vm.VM) {
 		}
 		id := toInt(args[0])
 		size := toFloat32(args[1])
-		mesh := rl.GenMeshCube(size, size, size)
-		model := rl.LoadModelFromMesh(mesh)
+		model := meshModel(func() rl.Mesh { return rl.GenMeshCube(size, size, size) })
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -784,8 +801,7 @@ func RegisterDBP(v *vm.VM) {
 		}
 		id := toInt(args[0])
 		radius := toFloat32(args[1])
-		mesh := rl.GenMeshSphere(radius, 16, 16)
-		model := rl.LoadModelFromMesh(mesh)
+		model := meshModel(func() rl.Mesh { return rl.GenMeshSphere(radius, 16, 16) })
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -798,8 +814,7 @@ func RegisterDBP(v *vm.VM) {
 		}
 		id := toInt(args[0])
 		w, h := toFloat32(args[1]), toFloat32(args[2])
-		mesh := rl.GenMeshPlane(w, h, 1, 1)
-		model := rl.LoadModelFromMesh(mesh)
+		model := meshModel(func() rl.Mesh { return rl.GenMeshPlane(w, h, 1, 1) })
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -968,8 +983,7 @@ func RegisterDBP(v *vm.VM) {
 			return nil, fmt.Errorf("unknown object id %d", srcID)
 		}
 		// Create procedural cube with same scale as source (raylib has no model clone)
-		mesh := rl.GenMeshCube(src.scaleX*2, src.scaleY*2, src.scaleZ*2)
-		newModel := rl.LoadModelFromMesh(mesh)
+		newModel := meshModel(func() rl.Mesh { return rl.GenMeshCube(src.scaleX*2, src.scaleY*2, src.scaleZ*2) })
 		clone := &dbpObject{
 			model: newModel,
 			x:     src.x, y: src.y, z: src.z,
@@ -1621,7 +1635,7 @@ func RegisterDBP(v *vm.VM) {
 
 	// --- Random / Utility ---
 	v.RegisterForeign("Randomize", func(args []interface{}) (interface{}, error) {
-		seed := time.Now().UnixNano()
+		seed := rng.Int63()
 		if len(args) >= 1 {
 			s := toInt(args[0])
 			if s != 0 {
@@ -1923,12 +1937,17 @@ func RegisterDBP(v *vm.VM) {
 
 	// --- Frame sync and input ---
 	// Sync: end frame and present. When UseUnifiedRenderer is enabled, runs full unified frame.
+	// When SYNC polled input it also runs the ON event handlers for the new frame.
 	v.RegisterForeign("Sync", func(args []interface{}) (interface{}, error) {
-		runtime.SyncFrame()
+		if runtime.SyncFrame() {
+			return nil, v.ProcessEvents()
+		}
 		return nil, nil
 	})
 	v.RegisterForeign("SYNC", func(args []interface{}) (interface{}, error) {
-		runtime.SyncFrame()
+		if runtime.SyncFrame() {
+			return nil, v.ProcessEvents()
+		}
 		return nil, nil
 	})
 	// UseUnifiedRenderer: enable unified render pipeline. SYNC then does full frame (3D→2D→GUI).
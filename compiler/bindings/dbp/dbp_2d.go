@@ -4,12 +4,13 @@ package dbp
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
 
 	"cyberbasic/compiler/bindings/aseprite"
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -556,7 +557,7 @@ func register2DSpritesheets(v *vm.VM) {
 func UpdateSpriteAnimations() {
 	dt := float32(0.016)
 	if rl.IsWindowReady() {
-		dt = rl.GetFrameTime()
+		dt = headless.FrameTime()
 	}
 	spritesheetsMu.Lock()
 	defer spritesheetsMu.Unlock()
@@ -1233,10 +1234,10 @@ func register2DParticles(v *vm.VM) {
 			return nil, nil
 		}
 		for i := 0; i < count && len(p.particles) < p.maxCount; i++ {
-			angle := float32(rand.Float64() * 2 * math.Pi)
-			vx := float32(math.Cos(float64(angle))) * p.speed * (0.5 + float32(rand.Float64())*0.5)
-			vy := float32(math.Sin(float64(angle))) * p.speed * (0.5 + float32(rand.Float64())*0.5)
-			life := 0.5 + float32(rand.Float64())*1.5
+			angle := float32(rng.Float64() * 2 * math.Pi)
+			vx := float32(math.Cos(float64(angle))) * p.speed * (0.5 + float32(rng.Float64())*0.5)
+			vy := float32(math.Sin(float64(angle))) * p.speed * (0.5 + float32(rng.Float64())*0.5)
+			life := 0.5 + float32(rng.Float64())*1.5
 			p.particles = append(p.particles, particle2D{
 				x: x, y: y, vx: vx, vy: vy,
 				life: life, maxLife: life,
@@ -1252,7 +1253,7 @@ func register2DParticles(v *vm.VM) {
 			return nil, fmt.Errorf("DrawParticles2D(id) requires 1 argument")
 		}
 		id := toInt(args[0])
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		particles2DMu.Lock()
 		p, ok := particles2D[id]
 		if !ok {
@@ -190,8 +190,7 @@ func register3DObjects(v *vm.VM) {
 		id := toInt(args[0])
 		radius := toFloat32(args[1])
 		height := toFloat32(args[2])
-		mesh := rl.GenMeshCylinder(radius, height, 16)
-		model := rl.LoadModelFromMesh(mesh)
+		model := meshModel(func() rl.Mesh { return rl.GenMeshCylinder(radius, height, 16) })
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -209,8 +208,7 @@ func register3DObjects(v *vm.VM) {
 		if slices < 1 {
 			slices = 1
 		}
-		mesh := rl.GenMeshPlane(size, size, slices, slices)
-		model := rl.LoadModelFromMesh(mesh)
+		model := meshModel(func() rl.Mesh { return rl.GenMeshPlane(size, size, slices, slices) })
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	blendanim "cyberbasic/compiler/runtime/animation"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -380,14 +381,14 @@ func register3DAnimation(v *vm.VM) {
 		var frames []rl.Model
 		for i := 0; i < frameCount; i++ {
 			path := filepath.Join(folder, fmt.Sprintf("%03d.obj", i+1))
-			model := rl.LoadModel(path)
+			model := loadModel(path)
 			if model.MeshCount == 0 {
 				path = filepath.Join(folder, fmt.Sprintf("frame_%03d.obj", i+1))
-				model = rl.LoadModel(path)
+				model = loadModel(path)
 			}
 			if model.MeshCount == 0 {
 				path = filepath.Join(folder, fmt.Sprintf("%d.obj", i+1))
-				model = rl.LoadModel(path)
+				model = loadModel(path)
 			}
 			frames = append(frames, model)
 		}
@@ -454,7 +455,7 @@ func UpdateMeshAnimation(objID int) {
 		meshAnimMu.Unlock()
 		return
 	}
-	dt := rl.GetFrameTime()
+	dt := headless.FrameTime()
 	st.frame += st.speed * dt * 30
 	fc := float32(len(st.frames))
 	if st.loop {
@@ -495,7 +496,7 @@ func UpdateObjectAnimation(objID int, obj *dbpObject) {
 	if ci < 0 || ci >= len(clips) {
 		ci = 0
 	}
-	dt := rl.GetFrameTime()
+	dt := headless.FrameTime()
 	objectAnimMu.Lock()
 	st = objectAnimState[objID]
 	if st == nil {
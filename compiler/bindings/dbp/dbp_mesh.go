@@ -16,7 +16,7 @@ func register3DMesh(v *vm.VM) {
 		}
 		id := toInt(args[0])
 		path := toString(args[1])
-		model := rl.LoadModel(path)
+		model := loadModel(path)
 		objectsMu.Lock()
 		objects[id] = newDbpObject(model)
 		objectsMu.Unlock()
@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"cyberbasic/compiler/bindings/model"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/runtime/resources"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -176,8 +177,8 @@ func buildModelInternal(m *model.Model, objectIDBase int, basePath string, withH
 	meshModels := make([]rl.Model, len(m.Meshes))
 	for i := range m.Meshes {
 		rlMesh, indicesKeep, err := meshToRaylib(&m.Meshes[i])
-		if err != nil {
-			continue // skip empty meshes, do not fail
+		if err != nil || headless.Enabled() {
+			continue // skip empty meshes, do not fail; headless objects have no model (see loadModel)
 		}
 		rl.UploadMesh(&rlMesh, false)
 		mod := rl.LoadModelFromMesh(rlMesh)
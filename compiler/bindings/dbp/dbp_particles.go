@@ -3,10 +3,11 @@ package dbp
 
 import (
 	"fmt"
-	"math/rand"
 	"strconv"
 	"sync"
 
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -156,10 +157,10 @@ func registerParticles(v *vm.VM) {
 			maxCap = 10000
 		}
 		for i := 0; i < count && len(ps.particles) < maxCap; i++ {
-			vx := ps.velX + float32(rand.Float64()*0.2-0.1)
-			vy := ps.velY + float32(rand.Float64()*0.2-0.1)
-			vz := ps.velZ + float32(rand.Float64()*0.2-0.1)
-			life := ps.lifetime * (0.8 + float32(rand.Float64())*0.4)
+			vx := ps.velX + float32(rng.Float64()*0.2-0.1)
+			vy := ps.velY + float32(rng.Float64()*0.2-0.1)
+			vz := ps.velZ + float32(rng.Float64()*0.2-0.1)
+			life := ps.lifetime * (0.8 + float32(rng.Float64())*0.4)
 			ps.particles = append(ps.particles, dbpParticle{
 				x: x, y: y, z: z,
 				vx: vx, vy: vy, vz: vz,
@@ -296,7 +297,7 @@ func toUint8(v interface{}) uint8 {
 }
 
 func drawDbpParticles(id int) {
-	dt := rl.GetFrameTime()
+	dt := headless.FrameTime()
 	dbpParticleSystemsMu.Lock()
 	ps, ok := dbpParticleSystems[id]
 	if !ok {
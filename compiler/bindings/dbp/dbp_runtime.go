@@ -49,7 +49,7 @@ func registerRuntime(v *vm.VM) {
 	})
 	v.RegisterForeign("OnFixedUpdate", func(args []interface{}) (interface{}, error) {
 		if len(args) >= 1 {
-			runtime.SetFixedUpdateLabel(toString(args[0]))
+			runtime.SetFixedUpdateLabel(args[0])
 		}
 		return nil, nil
 	})
@@ -58,8 +58,8 @@ func registerRuntime(v *vm.VM) {
 	})
 }
 
-// FixedUpdateLabel returns the label set by OnFixedUpdate (for game loop integration).
-func FixedUpdateLabel() string {
+// FixedUpdateLabel returns the Sub name or function set by OnFixedUpdate (for game loop integration).
+func FixedUpdateLabel() vm.Value {
 	return runtime.FixedUpdateLabel()
 }
 
@@ -11,6 +11,9 @@ import (
 	"sync"
 	"time"
 
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/shadergraph"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -212,11 +215,12 @@ var (
 	replicateScale = make(map[string]bool)
 	replicateMu    sync.Mutex
 
-	// Shader graph (nodes + connections; compile = stub)
-	shaderGraphNodes  = make(map[string]*sgNode)
-	shaderGraphGraphs = make(map[string]*sgGraph)
-	shaderGraphSeq    int
-	shaderGraphMu     sync.Mutex
+	// Shader graph (nodes, graphs and the shaders loaded from them)
+	shaderGraphNodes   = make(map[string]*shadergraph.Node)
+	shaderGraphGraphs  = make(map[string]*sgGraph)
+	shaderGraphShaders = make(map[string]*shadergraph.Shader) // ShaderGraphLoad shader id -> compiled graph
+	shaderGraphSeq     int
+	shaderGraphMu      sync.Mutex
 
 	// Anim state machine
 	animStates      = make(map[string]*animStateData)
@@ -300,15 +304,79 @@ func runBTNode(v *vm.VM, nodeId, entityId string) (bool, error) {
 	}
 }
 
-type sgNode struct {
-	ID   string
-	Type string
-	Args []interface{}
+// sgGraph is a ShaderGraphCreate graph: nodes are shared between graphs, so its connections are kept here and
+// applied to copies of the nodes when it is compiled.
+type sgGraph struct {
+	Conns []sgConn
 }
 
-type sgGraph struct {
-	Nodes []string
-	Conns []struct{ Out, In string }
+type sgConn struct {
+	Out, In string
+	Input   int // input index, or -1 for the first unconnected one
+}
+
+// addShaderNode adds a node of kind, set up by init, and returns its id. The caller holds shaderGraphMu.
+func addShaderNode(kind string, init func(n *shadergraph.Node)) string {
+	shaderGraphSeq++
+	id := fmt.Sprintf("sg_%d", shaderGraphSeq)
+	n, _ := shadergraph.NewNode(id, kind)
+	if init != nil {
+		init(n)
+	}
+	shaderGraphNodes[id] = n
+	return id
+}
+
+// newShaderNode adds a node of kind whose inputs are args: node ids or numbers. A texture input also takes a
+// texture id, which gets a sampler node of its own; "" leaves it on the texture being drawn.
+func newShaderNode(kind string, args []interface{}) (string, error) {
+	n, err := shadergraph.NewNode("", kind)
+	if err != nil {
+		return "", err
+	}
+	if len(args) > len(n.Inputs) {
+		return "", fmt.Errorf("takes at most %d arguments", len(n.Inputs))
+	}
+	shaderGraphMu.Lock()
+	defer shaderGraphMu.Unlock()
+	for i, a := range args {
+		s, isString := a.(string)
+		switch {
+		case isString && shaderGraphNodes[s] != nil:
+			n.Inputs[i] = shadergraph.Input{Node: s}
+		case shadergraph.InputType(kind, i) == shadergraph.Sampler:
+			if tex := toString(a); tex != "" {
+				n.Inputs[i] = shadergraph.Input{Node: addShaderNode("sampler", func(sn *shadergraph.Node) { sn.Texture = tex })}
+			}
+		case isString:
+			return "", fmt.Errorf("%q is not a shader node", s)
+		case a != nil:
+			n.Inputs[i] = shadergraph.Input{Value: toFloat64(a), Const: true}
+		}
+	}
+	return addShaderNode(kind, func(nn *shadergraph.Node) { nn.Inputs = n.Inputs }), nil
+}
+
+// compileShaderGraph compiles graph gid, or returns nil for an unknown graph.
+func compileShaderGraph(gid string) (*shadergraph.Shader, error) {
+	shaderGraphMu.Lock()
+	sg := shaderGraphGraphs[gid]
+	if sg == nil {
+		shaderGraphMu.Unlock()
+		return nil, nil
+	}
+	g := shadergraph.New()
+	for _, n := range shaderGraphNodes {
+		g.Add(n.Clone())
+	}
+	conns := append([]sgConn(nil), sg.Conns...)
+	shaderGraphMu.Unlock()
+	for _, c := range conns {
+		if err := g.Connect(c.Out, c.In, c.Input); err != nil {
+			return nil, fmt.Errorf("ShaderGraphConnect(%s, %s): %v", c.Out, c.In, err)
+		}
+	}
+	return shadergraph.Compile(g)
 }
 
 type animStateData struct {
@@ -679,7 +747,7 @@ func RegisterGame(v *vm.VM) {
 			return nil, fmt.Errorf("DrawParticles requires (systemId)")
 		}
 		id := toString(args[0])
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		particleMu.Lock()
 		ps := particleSystems[id]
 		if ps == nil {
@@ -749,7 +817,7 @@ func RegisterGame(v *vm.VM) {
 			return nil, nil
 		}
 		eid := toString(args[0])
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		aiMu.Lock()
 		pos, ok := aiPos[eid]
 		if !ok {
@@ -1712,7 +1780,7 @@ func RegisterGame(v *vm.VM) {
 		if roomCount < 3 {
 			roomCount = 3
 		}
-		seeded := time.Now().UnixNano()
+		seeded := rng.Int63()
 		for i := 0; i < roomCount; i++ {
 			rw := 3 + (int(seeded+int64(i*7)) % 5)
 			rh := 3 + (int(seeded+int64(i*11)) % 4)
@@ -1734,7 +1802,7 @@ func RegisterGame(v *vm.VM) {
 			seed = int64(toFloat64(args[0]))
 		}
 		if seed == 0 {
-			seed = time.Now().UnixNano()
+			seed = rng.Int63()
 		}
 		// Return a deterministic tree id (script can use for drawing or placement)
 		id := fmt.Sprintf("tree_%d", seed)
@@ -2199,50 +2267,45 @@ func RegisterGame(v *vm.VM) {
 	})
 	v.RegisterForeign("RPC", func(args []interface{}) (interface{}, error) { return nil, nil })
 
-	// --- Shader graph (stub; compile returns empty) ---
-	v.RegisterForeign("ShaderNodeTexture", func(args []interface{}) (interface{}, error) {
-		shaderGraphMu.Lock()
-		shaderGraphSeq++
-		id := fmt.Sprintf("sg_%d", shaderGraphSeq)
+	// --- Shader graph (compiled to GLSL 330 by compiler/shadergraph) ---
+	// Node inputs are the arguments, in order: node ids or numbers; unconnected ones can be connected later.
+	shaderNode := func(kind string) vm.ForeignFunc {
+		return func(args []interface{}) (interface{}, error) {
+			id, err := newShaderNode(kind, args)
+			if err != nil {
+				return nil, fmt.Errorf("%s shader node: %v", kind, err)
+			}
+			return id, nil
+		}
+	}
+	v.RegisterForeign("ShaderNodeTexture", shaderNode("texture"))     // ([texture [, uv]]) -> vec4
+	v.RegisterForeign("ShaderNodeNormalMap", shaderNode("normalmap")) // ([texture [, strength [, uv]]]) -> vec3 world normal
+	v.RegisterForeign("ShaderNodeAdd", shaderNode("add"))             // (a, b)
+	v.RegisterForeign("ShaderNodeMultiply", shaderNode("multiply"))   // (a, b)
+	v.RegisterForeign("ShaderNodeLerp", shaderNode("lerp"))           // (a, b, t)
+	v.RegisterForeign("ShaderNodeTime", shaderNode("time"))           // () -> float seconds
+	v.RegisterForeign("ShaderNodeUV", shaderNode("uv"))               // () -> vec2
+	v.RegisterForeign("ShaderNodeVertexColor", shaderNode("vertexcolor"))
+	v.RegisterForeign("ShaderNodeFresnel", shaderNode("fresnel"))   // ([power [, normal]]) -> float
+	v.RegisterForeign("ShaderNodeNoise", shaderNode("noise"))       // ([scale [, uv]]) -> float
+	v.RegisterForeign("ShaderNodeUVScroll", shaderNode("uvscroll")) // (speedX, speedY [, uv]) -> vec2
+	v.RegisterForeign("ShaderNodeSampler", func(args []interface{}) (interface{}, error) {
 		tex := ""
 		if len(args) >= 1 {
 			tex = toString(args[0])
 		}
-		shaderGraphNodes[id] = &sgNode{ID: id, Type: "texture", Args: []interface{}{tex}}
-		shaderGraphMu.Unlock()
-		return id, nil
-	})
-	v.RegisterForeign("ShaderNodeColor", func(args []interface{}) (interface{}, error) {
 		shaderGraphMu.Lock()
-		shaderGraphSeq++
-		id := fmt.Sprintf("sg_%d", shaderGraphSeq)
-		shaderGraphNodes[id] = &sgNode{ID: id, Type: "color", Args: args}
-		shaderGraphMu.Unlock()
-		return id, nil
+		defer shaderGraphMu.Unlock()
+		return addShaderNode("sampler", func(n *shadergraph.Node) { n.Texture = tex }), nil
 	})
-	v.RegisterForeign("ShaderNodeAdd", func(args []interface{}) (interface{}, error) {
-		shaderGraphMu.Lock()
-		shaderGraphSeq++
-		id := fmt.Sprintf("sg_%d", shaderGraphSeq)
-		shaderGraphNodes[id] = &sgNode{ID: id, Type: "add", Args: args}
-		shaderGraphMu.Unlock()
-		return id, nil
-	})
-	v.RegisterForeign("ShaderNodeMultiply", func(args []interface{}) (interface{}, error) {
-		shaderGraphMu.Lock()
-		shaderGraphSeq++
-		id := fmt.Sprintf("sg_%d", shaderGraphSeq)
-		shaderGraphNodes[id] = &sgNode{ID: id, Type: "multiply", Args: args}
-		shaderGraphMu.Unlock()
-		return id, nil
-	})
-	v.RegisterForeign("ShaderNodeTime", func(args []interface{}) (interface{}, error) {
+	v.RegisterForeign("ShaderNodeColor", func(args []interface{}) (interface{}, error) {
+		c := [4]float64{1, 1, 1, 1}
+		for i := 0; i < len(args) && i < 4; i++ {
+			c[i] = toFloat64(args[i]) / 255
+		}
 		shaderGraphMu.Lock()
-		shaderGraphSeq++
-		id := fmt.Sprintf("sg_%d", shaderGraphSeq)
-		shaderGraphNodes[id] = &sgNode{ID: id, Type: "time", Args: nil}
-		shaderGraphMu.Unlock()
-		return id, nil
+		defer shaderGraphMu.Unlock()
+		return addShaderNode("color", func(n *shadergraph.Node) { n.Color = c }), nil
 	})
 	v.RegisterForeign("ShaderGraphCreate", func(args []interface{}) (interface{}, error) {
 		shaderGraphMu.Lock()
@@ -2252,40 +2315,131 @@ func RegisterGame(v *vm.VM) {
 		shaderGraphMu.Unlock()
 		return id, nil
 	})
+	// ShaderGraphConnect(graphId, fromNode, toNode [, input]): input is an index from 0 or a name; without it the
+	// first unconnected input. toNode "output" makes fromNode the graph's output.
 	v.RegisterForeign("ShaderGraphConnect", func(args []interface{}) (interface{}, error) {
 		if len(args) < 3 {
 			return nil, fmt.Errorf("ShaderGraphConnect requires (graphId, outputNodeId, inputNodeId)")
 		}
-		gid := toString(args[0])
+		gid, from, to := toString(args[0]), toString(args[1]), toString(args[2])
 		shaderGraphMu.Lock()
+		defer shaderGraphMu.Unlock()
 		g := shaderGraphGraphs[gid]
-		if g != nil {
-			g.Conns = append(g.Conns, struct{ Out, In string }{toString(args[1]), toString(args[2])})
+		if g == nil {
+			return nil, fmt.Errorf("ShaderGraphConnect: unknown shader graph %q", gid)
 		}
-		shaderGraphMu.Unlock()
+		if shaderGraphNodes[from] == nil {
+			return nil, fmt.Errorf("ShaderGraphConnect: unknown shader node %q", from)
+		}
+		if strings.EqualFold(to, "output") {
+			g.Conns = append(g.Conns, sgConn{Out: from, In: to, Input: -1})
+			return nil, nil
+		}
+		n := shaderGraphNodes[to]
+		if n == nil {
+			return nil, fmt.Errorf("ShaderGraphConnect: unknown shader node %q", to)
+		}
+		input := -1
+		if len(args) >= 4 {
+			if name, ok := args[3].(string); ok {
+				if input = shadergraph.InputIndex(n.Kind, name); input < 0 {
+					return nil, fmt.Errorf("ShaderGraphConnect: %s node has no input %q", n.Kind, name)
+				}
+			} else if input = int(toFloat64(args[3])); input < 0 || input >= len(n.Inputs) {
+				return nil, fmt.Errorf("ShaderGraphConnect: %s node has %d inputs", n.Kind, len(n.Inputs))
+			}
+		}
+		g.Conns = append(g.Conns, sgConn{Out: from, In: to, Input: input})
 		return nil, nil
 	})
+	v.RegisterForeign("ShaderGraphSetOutput", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("ShaderGraphSetOutput requires (graphId, nodeId)")
+		}
+		return v.CallForeign("ShaderGraphConnect", []interface{}{args[0], args[1], "output"})
+	})
+	// ShaderGraphCompile(graphId) returns the fragment shader; ShaderGraphVertex(graphId) the vertex shader.
 	v.RegisterForeign("ShaderGraphCompile", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
 			return "", fmt.Errorf("ShaderGraphCompile requires (graphId)")
 		}
-		gid := toString(args[0])
+		sh, err := compileShaderGraph(toString(args[0]))
+		if err != nil || sh == nil {
+			return "", err
+		}
+		return sh.Fragment, nil
+	})
+	v.RegisterForeign("ShaderGraphVertex", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return "", fmt.Errorf("ShaderGraphVertex requires (graphId)")
+		}
+		sh, err := compileShaderGraph(toString(args[0]))
+		if err != nil || sh == nil {
+			return "", err
+		}
+		return sh.Vertex, nil
+	})
+	// ShaderGraphLoad(graphId) compiles the graph and loads it as a shader (LoadShaderFromMemory) -> shaderId.
+	v.RegisterForeign("ShaderGraphLoad", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ShaderGraphLoad requires (graphId)")
+		}
+		sh, err := compileShaderGraph(toString(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		if sh == nil {
+			return nil, fmt.Errorf("ShaderGraphLoad: unknown shader graph %q", toString(args[0]))
+		}
+		id, err := v.CallForeign("LoadShaderFromMemory", []interface{}{sh.Vertex, sh.Fragment})
+		if err != nil {
+			return nil, err
+		}
 		shaderGraphMu.Lock()
-		g := shaderGraphGraphs[gid]
+		shaderGraphShaders[toString(id)] = sh
 		shaderGraphMu.Unlock()
-		if g == nil {
-			return "", nil
-		}
-		// Minimal: emit trivial passthrough fragment shader for valid graphs
-		// Full node-to-GLSL would traverse graph; for now return passthrough
-		_ = g
-		return `#version 330
-in vec2 fragTexCoord;
-out vec4 finalColor;
-uniform sampler2D texture0;
-void main() {
-    finalColor = texture(texture0, fragTexCoord);
-}`, nil
+		return id, nil
+	})
+	// ShaderGraphBegin(shaderId) is BeginShaderMode for a ShaderGraphLoad shader that also sets the uniforms the
+	// graph uses: its textures (raylib binds them only inside shader mode), time (GetTime) and viewPos (the 3D
+	// camera position). Colours keep their node values until changed with SetShaderUniformVec4 (0..1).
+	v.RegisterForeign("ShaderGraphBegin", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ShaderGraphBegin requires (shaderId)")
+		}
+		id := toString(args[0])
+		if _, err := v.CallForeign("BeginShaderMode", []interface{}{id}); err != nil {
+			return nil, err
+		}
+		shaderGraphMu.Lock()
+		sh := shaderGraphShaders[id]
+		shaderGraphMu.Unlock()
+		if sh == nil {
+			return nil, nil
+		}
+		for _, u := range sh.Uniforms {
+			var err error
+			switch {
+			case u.Type == shadergraph.Sampler && u.Texture != "":
+				_, err = v.CallForeign("SetShaderValueTexture", []interface{}{id, u.Name, u.Texture})
+			case u.Name == "time":
+				var t interface{}
+				if t, err = v.CallForeign("GetTime", nil); err == nil {
+					_, err = v.CallForeign("SetShaderUniform", []interface{}{id, u.Name, t})
+				}
+			case u.Name == "viewPos":
+				pos := []interface{}{id, u.Name}
+				for _, axis := range []string{"X", "Y", "Z"} {
+					c, _ := v.CallForeign("GetCameraPosition"+axis, nil)
+					pos = append(pos, c)
+				}
+				_, err = v.CallForeign("SetShaderUniformVec3", pos)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
 	})
 
 	// --- Animation state machine ---
@@ -40,6 +40,8 @@ var gameNames = []string{
 	"InventoryCreate", "InventoryAddItem", "InventoryRemoveItem", "InventoryHasItem", "ItemDefine", "ItemSetProperty", "InventoryDraw",
 	"CreateHingeJoint", "CreateBallJoint", "CreateSliderJoint", "CreateRagdoll", "RagdollEnable", "RagdollDisable",
 	"ShaderGraphCreate", "ShaderGraphConnect", "ShaderNodeAdd", "ShaderNodeTexture", "ShaderNodeColor", "ShaderNodeMultiply", "ShaderNodeTime", "ShaderGraphCompile",
+	"ShaderNodeSampler", "ShaderNodeNormalMap", "ShaderNodeLerp", "ShaderNodeUV", "ShaderNodeVertexColor", "ShaderNodeFresnel", "ShaderNodeNoise", "ShaderNodeUVScroll",
+	"ShaderGraphSetOutput", "ShaderGraphVertex", "ShaderGraphLoad", "ShaderGraphBegin",
 	"NetStartServer", "NetStartClient", "RPC", "ReplicateValue", "ReplicateVariable", "ReplicatePosition", "ReplicateRotation", "ReplicateScale",
 	"AnimStateCreate", "AnimStateSetClip", "AnimTransition", "AnimSetParameter", "AnimSetState", "AnimUpdate",
 }
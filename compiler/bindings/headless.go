@@ -0,0 +1,363 @@
+package bindings
+
+import (
+	"fmt"
+	"strings"
+
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/time"
+	"cyberbasic/compiler/vm"
+)
+
+// keptInHeadless are frame and physics commands whose own implementations already work without a window.
+var keptInHeadless = map[string]bool{
+	"enddrawing": true, "endframe": true, "sync": true, "clearrenderqueues": true, "flushrenderqueues": true,
+	"stepallphysics2d": true, "stepallphysics3d": true, "enddraw": true,
+	"begin": true, // SQL transaction
+}
+
+// gpuNouns name the GPU resources whose load, create, query and update commands (a gpuVerbs word followed by
+// one of these) the null renderer stubs. DBP objects are not among them: without a window they are created
+// with no model, so their transforms still work.
+var gpuNouns = map[string]bool{
+	"texture": true, "shader": true, "model": true, "mesh": true, "font": true, "material": true, "skybox": true,
+	"cubemap": true, "sprite": true, "heightmap": true, "terrain": true, "water": true, "particles": true,
+	"light": true, "tilemap": true, "level": true, "tree": true, "city": true, "render": true,
+}
+
+var gpuVerbs = map[string]bool{
+	"load": true, "generate": true, "gen": true, "make": true, "create": true, "upload": true, "unload": true,
+	"update": true, "set": true, "get": true, "is": true,
+}
+
+// installHeadless puts the null renderer in front of the registered commands (--headless). Window and timing
+// commands (and TIMER) use headless's fixed clock and screen size, input commands read the scripted input, and
+// commands that draw, touch GPU resources, manage the window or play audio only record their call
+// (headless.LastFrame).
+// Creating a resource returns a handle string such as "headless:LoadTexture:1"; queries of stubbed resources
+// return 0 or false. Every other command runs normally, so a GPU command outside these groups still needs a
+// window. SimulateKey, SimulateMouseButton, SimulateMouseMove and SimulateMouseWheel script the input.
+func installHeadless(v *vm.VM) {
+	v.SetClock(headless.Time)
+	handles := 0
+	for _, name := range v.ForeignNames() {
+		n := strings.ToLower(name)
+		if keptInHeadless[n] || hasAnyPrefix(n, "flag_", "on") || headlessSpecial(v, name) || scriptedInput(v, name) {
+			continue
+		}
+		name := name
+		switch {
+		case isDrawCommand(n):
+			result := interface{}(nil)
+			if strings.HasPrefix(n, "gui") {
+				result = false // controls report no interaction
+			}
+			v.RegisterForeign(name, func(args []interface{}) (interface{}, error) {
+				headless.Record(name, args)
+				return result, nil
+			})
+		case isAudioCommand(n), isWindowCommand(n), isResourceCommand(name):
+			v.RegisterForeign(name, func(args []interface{}) (interface{}, error) {
+				headless.Record(name, args)
+				switch {
+				case hasAnyPrefix(n, "load", "generate", "gen", "make", "create"):
+					handles++
+					return fmt.Sprintf("headless:%s:%d", name, handles), nil
+				case strings.HasPrefix(n, "get"):
+					return 0.0, nil
+				case strings.HasPrefix(n, "is"), strings.HasSuffix(n, "exists"):
+					return false, nil
+				}
+				return nil, nil
+			})
+		}
+	}
+	registerSimulate(v)
+}
+
+// installScriptedInput makes the input commands read the scripted input, and TIMER the scripted clock, while
+// the program keeps its window (--record, --replay).
+func installScriptedInput(v *vm.VM) {
+	v.SetClock(headless.Time)
+	for _, name := range v.ForeignNames() {
+		scriptedInput(v, name)
+	}
+}
+
+// headlessSpecial replaces name when it is a window or clock command with a headless meaning and reports
+// whether it did.
+func headlessSpecial(v *vm.VM, name string) bool {
+	var fn vm.ForeignFunc
+	switch strings.ToLower(name) {
+	case "initwindow", "window", "setwindowsize":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) >= 2 {
+				headless.SetScreenSize(int(headlessNum(args[0])), int(headlessNum(args[1])))
+			}
+			return nil, nil
+		}
+	case "windowshouldclose":
+		fn = func(args []interface{}) (interface{}, error) { return frame.Done(), nil }
+	case "iswindowready":
+		fn = func(args []interface{}) (interface{}, error) { return true, nil }
+	case "closewindow":
+		fn = func(args []interface{}) (interface{}, error) { return nil, nil }
+	case "settargetfps":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) >= 1 && headlessNum(args[0]) > 0 {
+				headless.SetFrameTime(1 / headlessNum(args[0]))
+			}
+			return nil, nil
+		}
+	case "getframetime":
+		fn = func(args []interface{}) (interface{}, error) { return float64(headless.FrameTime()), nil }
+	case "gettime":
+		fn = func(args []interface{}) (interface{}, error) { return headless.Time(), nil }
+	case "getfps":
+		fn = func(args []interface{}) (interface{}, error) { return int(1/headless.FrameTime() + 0.5), nil }
+	case "getscreenwidth", "getrenderwidth", "getwindowwidth":
+		fn = func(args []interface{}) (interface{}, error) { w, _ := headless.ScreenSize(); return w, nil }
+	case "getscreenheight", "getrenderheight", "getwindowheight":
+		fn = func(args []interface{}) (interface{}, error) { _, h := headless.ScreenSize(); return h, nil }
+	case "begindrawing", "beginframe":
+		fn = func(args []interface{}) (interface{}, error) {
+			time.Update(headless.FrameTime())
+			return nil, nil
+		}
+	default:
+		return false
+	}
+	v.RegisterForeign(name, fn)
+	return true
+}
+
+// scriptedInput replaces name when it is a keyboard, mouse or gamepad command with one that reads the scripted
+// input and reports whether it did.
+func scriptedInput(v *vm.VM, name string) bool {
+	var fn vm.ForeignFunc
+	switch strings.ToLower(name) {
+	case "iskeydown", "keydown", "iskeyup", "iskeypressed", "keypressed", "iskeyreleased":
+		test := map[string]func(int) bool{
+			"iskeydown": headless.KeyDown, "keydown": headless.KeyDown, "iskeypressed": headless.KeyPressed,
+			"keypressed": headless.KeyPressed, "iskeyreleased": headless.KeyReleased,
+			"iskeyup": func(k int) bool { return !headless.KeyDown(k) },
+		}[strings.ToLower(name)]
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) < 1 {
+				return nil, fmt.Errorf("%s requires (key)", name)
+			}
+			return test(int(headlessNum(args[0]))), nil
+		}
+	case "getkeypressed":
+		fn = func(args []interface{}) (interface{}, error) { return headless.NextKeyPressed(), nil }
+	case "ismousebuttondown", "mousedown", "ismousebuttonpressed", "mousepressed", "ismousebuttonreleased",
+		"mousereleased", "ismousebuttonup":
+		n := strings.ToLower(name)
+		test := headless.MouseButtonDown
+		switch {
+		case strings.HasSuffix(n, "pressed"):
+			test = headless.MouseButtonPressed
+		case strings.HasSuffix(n, "released"):
+			test = headless.MouseButtonReleased
+		case strings.HasSuffix(n, "up"):
+			test = func(b int) bool { return !headless.MouseButtonDown(b) }
+		}
+		fn = func(args []interface{}) (interface{}, error) {
+			b := 0
+			if len(args) >= 1 {
+				b = int(headlessNum(args[0]))
+			}
+			return test(b), nil
+		}
+	case "getmousex":
+		fn = func(args []interface{}) (interface{}, error) { x, _ := headless.Mouse(); return int(x), nil }
+	case "getmousey":
+		fn = func(args []interface{}) (interface{}, error) { _, y := headless.Mouse(); return int(y), nil }
+	case "getmouseposition":
+		fn = func(args []interface{}) (interface{}, error) {
+			x, y := headless.Mouse()
+			return []interface{}{x, y}, nil
+		}
+	case "getmousedelta":
+		fn = func(args []interface{}) (interface{}, error) {
+			dx, dy := headless.MouseDelta()
+			return []interface{}{dx, dy}, nil
+		}
+	case "getmousedeltax":
+		fn = func(args []interface{}) (interface{}, error) { dx, _ := headless.MouseDelta(); return dx, nil }
+	case "getmousedeltay":
+		fn = func(args []interface{}) (interface{}, error) { _, dy := headless.MouseDelta(); return dy, nil }
+	case "getmousewheelmove":
+		fn = func(args []interface{}) (interface{}, error) { return headless.Wheel(), nil }
+	case "setmouseposition":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) >= 2 {
+				headless.SetMouse(headlessNum(args[0]), headlessNum(args[1]))
+			}
+			return nil, nil
+		}
+	case "isgamepadavailable", "gamepadconnected":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) < 1 {
+				return nil, fmt.Errorf("%s requires (gamepad)", name)
+			}
+			return headless.GamepadAvailable(int(headlessNum(args[0]))), nil
+		}
+	case "isgamepadbuttondown", "gamepadbuttondown", "isgamepadbuttonpressed", "isgamepadbuttonreleased",
+		"isgamepadbuttonup":
+		n := strings.ToLower(name)
+		test := headless.GamepadButtonDown
+		switch {
+		case strings.HasSuffix(n, "pressed"):
+			test = headless.GamepadButtonPressed
+		case strings.HasSuffix(n, "released"):
+			test = headless.GamepadButtonReleased
+		case strings.HasSuffix(n, "up"):
+			test = func(pad, b int) bool { return !headless.GamepadButtonDown(pad, b) }
+		}
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("%s requires (gamepad, button)", name)
+			}
+			return test(int(headlessNum(args[0])), int(headlessNum(args[1]))), nil
+		}
+	case "getgamepadbuttonpressed":
+		fn = func(args []interface{}) (interface{}, error) { return headless.LastGamepadButtonPressed(), nil }
+	case "getgamepadaxismovement", "getgamepadaxis":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("%s requires (gamepad, axis)", name)
+			}
+			return headless.GamepadAxis(int(headlessNum(args[0])), int(headlessNum(args[1]))), nil
+		}
+	case "getgamepadaxiscount":
+		fn = func(args []interface{}) (interface{}, error) {
+			if len(args) >= 1 && headless.GamepadAvailable(int(headlessNum(args[0]))) {
+				return headless.GamepadAxes, nil
+			}
+			return 0, nil
+		}
+	default:
+		return false
+	}
+	v.RegisterForeign(name, fn)
+	return true
+}
+
+// registerSimulate adds the commands that script input in headless mode.
+func registerSimulate(v *vm.VM) {
+	v.RegisterForeign("SimulateKey", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("SimulateKey requires (key, down)")
+		}
+		headless.SetKey(int(headlessNum(args[0])), headlessBool(args[1]))
+		return nil, nil
+	})
+	v.RegisterForeign("SimulateMouseButton", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("SimulateMouseButton requires (button, down)")
+		}
+		headless.SetMouseButton(int(headlessNum(args[0])), headlessBool(args[1]))
+		return nil, nil
+	})
+	v.RegisterForeign("SimulateMouseMove", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("SimulateMouseMove requires (x, y)")
+		}
+		headless.SetMouse(headlessNum(args[0]), headlessNum(args[1]))
+		return nil, nil
+	})
+	v.RegisterForeign("SimulateMouseWheel", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("SimulateMouseWheel requires (delta)")
+		}
+		headless.SetWheel(headlessNum(args[0]))
+		return nil, nil
+	})
+}
+
+func isDrawCommand(n string) bool {
+	switch n {
+	case "background", "rect", "circle", "cube", "sprite":
+		return true
+	}
+	return hasAnyPrefix(n, "draw", "begin", "end", "gui", "clear")
+}
+
+func isAudioCommand(n string) bool {
+	return strings.Contains(n, "sound") || strings.Contains(n, "music") || strings.Contains(n, "audio")
+}
+
+func isWindowCommand(n string) bool {
+	for _, s := range []string{"window", "monitor", "cursor", "fullscreen"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isResourceCommand reports whether name is a gpuVerbs word followed by a gpuNouns word, e.g. LoadTexture or
+// GetModelBounds, or reads the screen. Physics bodies (Create...3D, Make...Collider, joints) are not resources.
+func isResourceCommand(name string) bool {
+	n := strings.ToLower(name)
+	if n == "loadimagefromscreen" || n == "loadimagefromtexture" {
+		return true
+	}
+	if strings.HasSuffix(n, "2d") || strings.HasSuffix(n, "3d") || strings.Contains(n, "collider") ||
+		strings.Contains(n, "joint") {
+		return false
+	}
+	words := camelWords(name)
+	return len(words) >= 2 && gpuVerbs[words[0]] && gpuNouns[words[1]]
+}
+
+// camelWords splits a CamelCase name into lower-case words ("GenMeshCube" gives gen, mesh, cube).
+func camelWords(name string) []string {
+	var words []string
+	start := 0
+	for i := 1; i < len(name); i++ {
+		if name[i] >= 'A' && name[i] <= 'Z' && name[i-1] >= 'a' && name[i-1] <= 'z' {
+			words = append(words, strings.ToLower(name[start:i]))
+			start = i
+		}
+	}
+	return append(words, strings.ToLower(name[start:]))
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func headlessNum(a interface{}) float64 {
+	switch x := a.(type) {
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case int:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case bool:
+		if x {
+			return 1
+		}
+	}
+	return 0
+}
+
+func headlessBool(a interface{}) bool {
+	if b, ok := a.(bool); ok {
+		return b
+	}
+	return headlessNum(a) != 0
+}
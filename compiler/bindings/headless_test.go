@@ -0,0 +1,121 @@
+package bindings
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/runtime"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/replay"
+)
+
+func TestHeadlessRunsFramesWithoutAWindow(t *testing.T) {
+	src := `InitWindow(320, 200, "t")
+SetTargetFPS(20)
+VAR n = 0
+VAR presses = 0
+VAR t = 0
+WHILE NOT WindowShouldClose()
+  n = n + 1
+  t = t + GetFrameTime()
+  IF n = 2 THEN
+    SimulateKey(KEY_SPACE, TRUE)
+  END IF
+  IF IsKeyPressed(KEY_SPACE) THEN
+    presses = presses + 1
+  END IF
+  BeginDrawing()
+  ClearBackground(0, 0, 0, 255)
+  DrawRectangle(n, presses, GetScreenWidth(), INT(t * 100 + 0.5), 255, 0, 0, 255)
+  EndDrawing()
+WEND
+`
+	chunk, err := compiler.New().Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headless.Enable()
+	defer headless.Reset()
+	rt := runtime.NewRuntime()
+	v := rt.GetVM()
+	v.LoadChunk(chunk)
+	v.SetRuntime(rt)
+	if err := RegisterAll(v, RegisterOptions{Source: src, Headless: true}); err != nil {
+		t.Fatal(err)
+	}
+	frame.Limit(5, v.Quit)
+	defer frame.Limit(0, nil)
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last frame's DrawRectangle shows n, presses, the screen width and the elapsed time in 1/100 s.
+	last := headless.LastFrame()
+	if len(last) != 2 || last[0].Name != "ClearBackground" || last[1].Name != "DrawRectangle" {
+		t.Fatalf("last frame recorded %v", last)
+	}
+	if got := fmt.Sprint(last[1].Args[:4]); got != "[5 1 320 25]" {
+		t.Errorf("DrawRectangle(n, presses, width, time) = %s, want [5 1 320 25]", got)
+	}
+}
+
+// TestReplayRepeatsTimerAndRandomize records a headless run and replays it: TIMER follows the scripted clock and
+// an unseeded Randomize draws its seed from the recorded one, so both runs draw the same.
+func TestReplayRepeatsTimerAndRandomize(t *testing.T) {
+	src := `InitWindow(320, 200, "t")
+Randomize()
+VAR a = RandomMinMax(0, 1000000)
+WHILE NOT WindowShouldClose()
+  BeginDrawing()
+  DrawRectangle(INT(a), INT(RANDOM(1000000)), INT(TIMER() * 1000000 + 0.5), 0, 255, 0, 0, 255)
+  EndDrawing()
+WEND
+`
+	chunk, err := compiler.New().Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(start func() error) []interface{} {
+		headless.Enable()
+		defer headless.Reset()
+		if err := start(); err != nil {
+			t.Fatal(err)
+		}
+		defer replay.Close()
+		rt := runtime.NewRuntime()
+		v := rt.GetVM()
+		v.LoadChunk(chunk)
+		v.SetRuntime(rt)
+		if err := RegisterAll(v, RegisterOptions{Source: src, Headless: true}); err != nil {
+			t.Fatal(err)
+		}
+		frame.Limit(3, v.Quit)
+		defer frame.Limit(0, nil)
+		if err := v.Run(); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range headless.LastFrame() {
+			if c.Name == "DrawRectangle" {
+				return c.Args[:3]
+			}
+		}
+		t.Fatalf("last frame recorded %v", headless.LastFrame())
+		return nil
+	}
+	path := filepath.Join(t.TempDir(), "session.replay")
+	recorded := run(func() error { return replay.Record(path) })
+	replayed := run(func() error {
+		_, err := replay.Replay(path)
+		return err
+	})
+	if fmt.Sprint(recorded) != fmt.Sprint(replayed) {
+		t.Errorf("replay drew %v, the recorded run %v", replayed, recorded)
+	}
+	// The third frame starts after two 1/60 s frames.
+	if fmt.Sprint(recorded[2]) != "33333" {
+		t.Errorf("TIMER in frame 3 = %v µs, want 33333", recorded[2])
+	}
+}
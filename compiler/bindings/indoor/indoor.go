@@ -49,6 +49,7 @@ type door struct {
 
 type trigger struct {
 	MinX, MinY, MinZ, MaxX, MaxY, MaxZ float64
+	inside                             map[string]bool // entities inside as of the last TriggerUpdate
 }
 
 type interactable struct {
@@ -257,6 +258,39 @@ func RegisterIndoor(v *vm.VM) {
 		indoorMu.Unlock()
 		return nil, nil
 	})
+	// TriggerUpdate(triggerId, entity, x, y, z): posts TriggerEnter / TriggerExit (keyed by trigger id, args
+	// triggerId, entity) when entity's position crosses the trigger's bounds. Returns whether it is inside.
+	v.RegisterForeign("TriggerUpdate", func(args []interface{}) (interface{}, error) {
+		if len(args) < 5 {
+			return nil, fmt.Errorf("TriggerUpdate requires (triggerId, entity, x, y, z)")
+		}
+		id, entity := toString(args[0]), toString(args[1])
+		x, y, z := toFloat64(args[2]), toFloat64(args[3]), toFloat64(args[4])
+		indoorMu.Lock()
+		t := triggers[id]
+		if t == nil {
+			indoorMu.Unlock()
+			return false, nil
+		}
+		in := x >= t.MinX && x <= t.MaxX && y >= t.MinY && y <= t.MaxY && z >= t.MinZ && z <= t.MaxZ
+		was := t.inside[entity]
+		if in {
+			if t.inside == nil {
+				t.inside = make(map[string]bool)
+			}
+			t.inside[entity] = true
+		} else {
+			delete(t.inside, entity)
+		}
+		indoorMu.Unlock()
+		switch {
+		case in && !was:
+			v.PostEvent("TriggerEnter", id, id, entity)
+		case !in && was:
+			v.PostEvent("TriggerExit", id, id, entity)
+		}
+		return in, nil
+	})
 	v.RegisterForeign("InteractableCreate", func(args []interface{}) (interface{}, error) {
 		x, y, z := 0.0, 0.0, 0.0
 		if len(args) >= 3 {
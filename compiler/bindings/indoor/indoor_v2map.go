@@ -15,6 +15,7 @@ var indoorV2 = map[string]string{
 	"switchcreate":             "SwitchCreate",
 	"triggercreate":            "TriggerCreate",
 	"triggersetbounds":         "TriggerSetBounds",
+	"triggerupdate":            "TriggerUpdate",
 	"interactablecreate":       "InteractableCreate",
 	"pickupcreate":             "PickupCreate",
 	"lightzonecreate":          "LightZoneCreate",
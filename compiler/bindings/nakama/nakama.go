@@ -417,19 +417,19 @@ func RegisterNakama(v *vm.VM) {
 		for _, ev := range events {
 			switch ev.typ {
 			case "match_data":
-				if _, ok := nakamaVM.Chunk().GetFunction("onnakamamatchdata"); ok {
+				if nakamaVM.HasFunction("onnakamamatchdata") {
 					_ = nakamaVM.InvokeSub("onnakamamatchdata", []interface{}{ev.matchId, ev.opCode, ev.data, ev.sender})
 				}
 			case "match_join":
-				if _, ok := nakamaVM.Chunk().GetFunction("onnakamamatchjoin"); ok {
+				if nakamaVM.HasFunction("onnakamamatchjoin") {
 					_ = nakamaVM.InvokeSub("onnakamamatchjoin", []interface{}{ev.matchId, ev.presences})
 				}
 			case "match_leave":
-				if _, ok := nakamaVM.Chunk().GetFunction("onnakamamatchleave"); ok {
+				if nakamaVM.HasFunction("onnakamamatchleave") {
 					_ = nakamaVM.InvokeSub("onnakamamatchleave", []interface{}{ev.matchId, ev.presences})
 				}
 			case "matchmaker_matched":
-				if _, ok := nakamaVM.Chunk().GetFunction("onnakamamatchmakermatched"); ok {
+				if nakamaVM.HasFunction("onnakamamatchmakermatched") {
 					_ = nakamaVM.InvokeSub("onnakamamatchmakermatched", []interface{}{ev.matchId, ev.token})
 				}
 			}
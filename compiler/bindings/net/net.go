@@ -84,7 +84,7 @@ var (
 	netVM             *vm.VM
 	eventQueue        []netEvent
 	eventMu           sync.Mutex
-	rpcHandlers       = make(map[string]string) // RPC name (lowercase) -> Sub name for InvokeSub
+	rpcHandlers       = make(map[string]vm.Value) // RPC name (lowercase) -> Sub name or function value for Invoke
 	rpcMu             sync.Mutex
 	pingSentAt        = make(map[string]time.Time)
 	lastRTTMs         = make(map[string]float64)
@@ -134,8 +134,8 @@ type interestFilter struct {
 // Rollback and prediction: snapshot storage and handlers
 var (
 	rollbackSnapshots     = make(map[string]string) // tickId -> json state
-	rollbackSnapshotSub   string                    // sub to call for save
-	rollbackRestoreSub    string                    // sub to call for restore
+	rollbackSnapshotSub   vm.Value                  // Sub name or function to call for save
+	rollbackRestoreSub    vm.Value                  // Sub name or function to call for restore
 	rollbackMu            sync.Mutex
 	predictionEnabled     bool
 	predictionInputBuffer = make(map[string]string) // tickId -> input
@@ -1266,25 +1266,27 @@ func RegisterNet(v *vm.VM) {
 		for _, ev := range events {
 			switch ev.typ {
 			case "lockstep_tick_ready":
-				if _, ok := netVM.Chunk().GetFunction("onlocksteptickready"); ok {
+				if netVM.HasFunction("onlocksteptickready") {
 					if err := netVM.InvokeSub("onlocksteptickready", []interface{}{ev.id}); err != nil {
 						return nil, err
 					}
 				}
 			case "rollback_required":
-				if _, ok := netVM.Chunk().GetFunction("onrollbackrequired"); ok {
+				if netVM.HasFunction("onrollbackrequired") {
 					if err := netVM.InvokeSub("onrollbackrequired", []interface{}{ev.id, ev.payload}); err != nil {
 						return nil, err
 					}
 				}
 			case "connect":
-				if _, ok := netVM.Chunk().GetFunction("onclientconnect"); ok {
+				netVM.PostEvent("NetConnect", "", ev.id)
+				if netVM.HasFunction("onclientconnect") {
 					if err := netVM.InvokeSub("onclientconnect", []interface{}{ev.id}); err != nil {
 						return nil, err
 					}
 				}
 			case "disconnect":
-				if _, ok := netVM.Chunk().GetFunction("onclientdisconnect"); ok {
+				netVM.PostEvent("NetDisconnect", "", ev.id)
+				if netVM.HasFunction("onclientdisconnect") {
 					if err := netVM.InvokeSub("onclientdisconnect", []interface{}{ev.id}); err != nil {
 						return nil, err
 					}
@@ -1313,7 +1315,7 @@ func RegisterNet(v *vm.VM) {
 						remoteEntities[entityId]["y"] = y
 						remoteEntities[entityId]["z"] = z
 						remoteEntitiesMu.Unlock()
-						if _, ok := netVM.Chunk().GetFunction("onentitysync"); ok {
+						if netVM.HasFunction("onentitysync") {
 							if err := netVM.InvokeSub("onentitysync", []interface{}{entityId, x, y, z}); err != nil {
 								return nil, err
 							}
@@ -1330,10 +1332,10 @@ func RegisterNet(v *vm.VM) {
 							_ = json.Unmarshal([]byte(rest), &rpcArgs)
 						}
 						rpcMu.Lock()
-						subName, hasHandler := rpcHandlers[rpcName]
+						handler := rpcHandlers[rpcName]
 						rpcMu.Unlock()
-						if hasHandler && subName != "" {
-							if err := netVM.InvokeSub(subName, rpcArgs); err != nil {
+						if handler != nil {
+							if _, err := netVM.Invoke(handler, rpcArgs); err != nil {
 								return nil, err
 							}
 							handled = true
@@ -1341,7 +1343,8 @@ func RegisterNet(v *vm.VM) {
 					}
 				}
 				if !handled {
-					if _, ok := netVM.Chunk().GetFunction("onmessage"); ok {
+					netVM.PostEvent("NetMessage", "", ev.id, msg)
+					if netVM.HasFunction("onmessage") {
 						if err := netVM.InvokeSub("onmessage", []interface{}{ev.id, msg}); err != nil {
 							return nil, err
 						}
@@ -1353,12 +1356,11 @@ func RegisterNet(v *vm.VM) {
 	})
 	v.RegisterForeign("RegisterRPC", func(args []interface{}) (interface{}, error) {
 		if len(args) < 2 {
-			return nil, fmt.Errorf("RegisterRPC(name, subName) requires 2 arguments")
+			return nil, fmt.Errorf("RegisterRPC(name, handler) requires 2 arguments")
 		}
 		name := strings.ToLower(toString(args[0]))
-		subName := toString(args[1])
 		rpcMu.Lock()
-		rpcHandlers[name] = subName
+		rpcHandlers[name] = vm.Callback(args[1])
 		rpcMu.Unlock()
 		return nil, nil
 	})
@@ -1739,19 +1741,19 @@ func RegisterNet(v *vm.VM) {
 	})
 	v.RegisterForeign("RegisterSnapshotHandler", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
-			return nil, fmt.Errorf("RegisterSnapshotHandler(subName) requires 1 argument")
+			return nil, fmt.Errorf("RegisterSnapshotHandler(handler) requires 1 argument")
 		}
 		rollbackMu.Lock()
-		rollbackSnapshotSub = toString(args[0])
+		rollbackSnapshotSub = vm.Callback(args[0])
 		rollbackMu.Unlock()
 		return nil, nil
 	})
 	v.RegisterForeign("RegisterRestoreHandler", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
-			return nil, fmt.Errorf("RegisterRestoreHandler(subName) requires 1 argument")
+			return nil, fmt.Errorf("RegisterRestoreHandler(handler) requires 1 argument")
 		}
 		rollbackMu.Lock()
-		rollbackRestoreSub = toString(args[0])
+		rollbackRestoreSub = vm.Callback(args[0])
 		rollbackMu.Unlock()
 		return nil, nil
 	})
@@ -1766,13 +1768,13 @@ func RegisterNet(v *vm.VM) {
 		rollbackMu.Lock()
 		sub := rollbackSnapshotSub
 		rollbackMu.Unlock()
-		if sub == "" {
-			return nil, fmt.Errorf("no snapshot handler registered; call RegisterSnapshotHandler(subName)")
+		if sub == nil {
+			return nil, fmt.Errorf("no snapshot handler registered; call RegisterSnapshotHandler(handler)")
 		}
-		if _, ok := netVM.Chunk().GetFunction(strings.ToLower(sub)); !ok {
-			return nil, fmt.Errorf("snapshot handler sub not found: %s", sub)
+		if !netVM.Callable(sub) {
+			return nil, fmt.Errorf("snapshot handler sub not found: %v", sub)
 		}
-		if err := netVM.InvokeSub(sub, []interface{}{tickId}); err != nil {
+		if _, err := netVM.Invoke(sub, []interface{}{tickId}); err != nil {
 			return nil, err
 		}
 		rollbackMu.Lock()
@@ -1803,16 +1805,16 @@ func RegisterNet(v *vm.VM) {
 		data := rollbackSnapshots[tickId]
 		sub := rollbackRestoreSub
 		rollbackMu.Unlock()
-		if sub == "" {
-			return nil, fmt.Errorf("no restore handler registered; call RegisterRestoreHandler(subName)")
+		if sub == nil {
+			return nil, fmt.Errorf("no restore handler registered; call RegisterRestoreHandler(handler)")
 		}
 		if netVM == nil || netVM.Chunk() == nil {
 			return nil, nil
 		}
-		if _, ok := netVM.Chunk().GetFunction(strings.ToLower(sub)); !ok {
-			return nil, fmt.Errorf("restore handler sub not found: %s", sub)
+		if !netVM.Callable(sub) {
+			return nil, fmt.Errorf("restore handler sub not found: %v", sub)
 		}
-		if err := netVM.InvokeSub(sub, []interface{}{tickId, data}); err != nil {
+		if _, err := netVM.Invoke(sub, []interface{}{tickId, data}); err != nil {
 			return nil, err
 		}
 		return true, nil
@@ -1843,19 +1845,19 @@ func RegisterNet(v *vm.VM) {
 		rollbackMu.Lock()
 		sub := rollbackRestoreSub
 		rollbackMu.Unlock()
-		if sub == "" {
-			return nil, fmt.Errorf("no restore handler for prediction; call RegisterRestoreHandler(subName)")
+		if sub == nil {
+			return nil, fmt.Errorf("no restore handler for prediction; call RegisterRestoreHandler(handler)")
 		}
 		if netVM == nil || netVM.Chunk() == nil {
 			return nil, nil
 		}
-		if _, ok := netVM.Chunk().GetFunction(strings.ToLower(sub)); !ok {
-			return nil, fmt.Errorf("restore handler sub not found: %s", sub)
+		if !netVM.Callable(sub) {
+			return nil, fmt.Errorf("restore handler sub not found: %v", sub)
 		}
-		if err := netVM.InvokeSub(sub, []interface{}{tickId, stateJson}); err != nil {
+		if _, err := netVM.Invoke(sub, []interface{}{tickId, stateJson}); err != nil {
 			return nil, err
 		}
-		if _, ok := netVM.Chunk().GetFunction("onpredictioncorrected"); ok {
+		if netVM.HasFunction("onpredictioncorrected") {
 			_ = netVM.InvokeSub("onpredictioncorrected", []interface{}{tickId})
 		}
 		return true, nil
@@ -36,7 +36,7 @@ func (l *fakeListener) SetDeadline(t time.Time) error {
 func resetNetGlobals() {
 	netVM = nil
 	eventQueue = nil
-	rpcHandlers = make(map[string]string)
+	rpcHandlers = make(map[string]vm.Value)
 	pingSentAt = make(map[string]time.Time)
 	lastRTTMs = make(map[string]float64)
 	remoteEntities = make(map[string]map[string]interface{})
@@ -4,10 +4,10 @@ package objects
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"sync"
 
 	"cyberbasic/compiler/bindings/modfacade"
+	"cyberbasic/compiler/rng"
 	"cyberbasic/compiler/vm"
 )
 
@@ -158,10 +158,10 @@ func RegisterObjects(v *vm.VM) {
 		}
 		var ids []interface{}
 		for i := 0; i < count; i++ {
-			x := (float32(rand.Float64()) - 0.5) * 2 * areaX
-			z := (float32(rand.Float64()) - 0.5) * 2 * areaZ
-			scale := minS + float32(rand.Float64())*(maxS-minS)
-			rot := float32(rand.Float64() * 2 * math.Pi)
+			x := (float32(rng.Float64()) - 0.5) * 2 * areaX
+			z := (float32(rng.Float64()) - 0.5) * 2 * areaZ
+			scale := minS + float32(rng.Float64())*(maxS-minS)
+			rot := float32(rng.Float64() * 2 * math.Pi)
 			res, err := v.CallForeign("ObjectPlace", []interface{}{modelID, x, 0, z, scale, rot})
 			if err != nil {
 				continue
@@ -185,12 +185,12 @@ func RegisterObjects(v *vm.VM) {
 			n = 5
 		}
 		for i := 0; i < n; i++ {
-			angle := float32(rand.Float64() * 2 * math.Pi)
-			r := float32(rand.Float64()) * radius
+			angle := float32(rng.Float64() * 2 * math.Pi)
+			r := float32(rng.Float64()) * radius
 			gx := x + r*float32(math.Cos(float64(angle)))
 			gz := z + r*float32(math.Sin(float64(angle)))
-			scale := 0.8 + float32(rand.Float64())*0.4
-			rot := float32(rand.Float64() * 2 * math.Pi)
+			scale := 0.8 + float32(rng.Float64())*0.4
+			rot := float32(rng.Float64() * 2 * math.Pi)
 			_, _ = v.CallForeign("ObjectPlace", []interface{}{modelID, gx, 0, gz, scale, rot})
 		}
 		return nil, nil
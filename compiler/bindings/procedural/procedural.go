@@ -4,10 +4,10 @@ package procedural
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"sync"
 
 	"cyberbasic/compiler/bindings/modfacade"
+	"cyberbasic/compiler/rng"
 	"cyberbasic/compiler/vm"
 )
 
@@ -125,10 +125,10 @@ func RegisterProcedural(v *vm.VM) {
 		}
 		procMu.Lock()
 		for i := 0; i < n; i++ {
-			x := (float32(rand.Float64()) - 0.5) * 2 * areaX
-			z := (float32(rand.Float64()) - 0.5) * 2 * areaZ
-			scale := 0.8 + float32(rand.Float64())*0.4
-			rot := float32(rand.Float64() * 2 * math.Pi)
+			x := (float32(rng.Float64()) - 0.5) * 2 * areaX
+			z := (float32(rng.Float64()) - 0.5) * 2 * areaZ
+			scale := 0.8 + float32(rng.Float64())*0.4
+			rot := float32(rng.Float64() * 2 * math.Pi)
 			_, _ = v.CallForeign("TreePlace", []interface{}{sysID, typeID, x, 0, z, scale, rot})
 		}
 		procMu.Unlock()
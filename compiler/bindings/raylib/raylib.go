@@ -3,13 +3,14 @@
 package raylib
 
 import (
+	"cyberbasic/compiler/rng"
 	"cyberbasic/compiler/runtime/camera"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	"fmt"
 	"math/rand"
 	"strconv"
 	"sync"
-	"time"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -208,12 +209,12 @@ var (
 	cullingMargin   float32 = 64
 )
 
-// getRand returns the seedable RNG, creating it with a time-based seed if never set.
+// getRand returns the seedable RNG, seeding it from the run's source (rng) if never set.
 func getRand() *rand.Rand {
 	seedableRandMu.Lock()
 	defer seedableRandMu.Unlock()
 	if seedableRand == nil {
-		seedableRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		seedableRand = rand.New(rand.NewSource(rng.Int63()))
 	}
 	return seedableRand
 }
@@ -515,7 +516,7 @@ func getCurrentCamera2D() rl.Camera2D {
 		cam = camera2D
 	}
 	if fid != "" && id == fid && spd > 0 {
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		cam.Target.X += (tx - cam.Target.X) * spd * dt
 		cam.Target.Y += (ty - cam.Target.Y) * spd * dt
 		camera2DMu.Lock()
@@ -3,6 +3,7 @@ package raylib
 
 import (
 	"cyberbasic/compiler/runtime/camera"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	"fmt"
 	"math"
@@ -407,7 +408,7 @@ func register3D(v *vm.VM) {
 		}
 		id := toString(args[0])
 		speedDeg := toFloat32(args[1])
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		radPerSec := speedDeg * (3.14159265 / 180)
 		modelStateMu.Lock()
 		modelAngles[id] += radPerSec * dt
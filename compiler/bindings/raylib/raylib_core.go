@@ -2,6 +2,9 @@
 package raylib
 
 import (
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/replay"
 	"cyberbasic/compiler/runtime/time"
 	"cyberbasic/compiler/vm"
 	"fmt"
@@ -12,6 +15,11 @@ import (
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
 
+func init() {
+	headless.SetDeviceClock(rl.GetFrameTime, rl.GetTime)
+	replay.SetDevices(sampleDevices)
+}
+
 // DebugRender is true when CYBERBASIC_DEBUG=render or CYBERBASIC_DEBUG=1
 func DebugRender() bool {
 	s := os.Getenv("CYBERBASIC_DEBUG")
@@ -60,7 +68,7 @@ func registerCore(v *vm.VM) {
 		return nil, nil
 	})
 	v.RegisterForeign("WindowShouldClose", func(args []interface{}) (interface{}, error) {
-		return rl.WindowShouldClose(), nil
+		return frame.Done() || rl.WindowShouldClose(), nil
 	})
 	v.RegisterForeign("CloseWindow", func(args []interface{}) (interface{}, error) {
 		rl.CloseWindow()
@@ -78,7 +86,7 @@ func registerCore(v *vm.VM) {
 			fmt.Println("[DEBUG] BeginDrawing")
 		}
 		// Poll and CaptureOrbitWheel are in SyncFrame; BeginDrawing only starts the draw
-		time.Update(rl.GetFrameTime())
+		time.Update(headless.FrameTime())
 		rl.BeginDrawing()
 		return nil, nil
 	})
@@ -86,18 +94,18 @@ func registerCore(v *vm.VM) {
 		if debugThrottled() {
 			fmt.Println("[DEBUG] EndDrawing")
 		}
-		rl.EndDrawing()
+		frame.End()
 		return nil, nil
 	})
 	// BeginFrame(): alias for BeginDrawing (start frame)
 	v.RegisterForeign("BeginFrame", func(args []interface{}) (interface{}, error) {
-		time.Update(rl.GetFrameTime())
+		time.Update(headless.FrameTime())
 		rl.BeginDrawing()
 		return nil, nil
 	})
 	// EndFrame(): alias for EndDrawing (end frame)
 	v.RegisterForeign("EndFrame", func(args []interface{}) (interface{}, error) {
-		rl.EndDrawing()
+		frame.End()
 		return nil, nil
 	})
 	// SetUpdateFunction(func), SetDrawFunction(func): no-op (use mainloop...endmain or WHILE NOT WindowShouldClose() ... WEND and call your update/draw logic manually).
@@ -145,7 +153,7 @@ func registerCore(v *vm.VM) {
 		return nil, nil
 	})
 	v.RegisterForeign("GetFrameTime", func(args []interface{}) (interface{}, error) {
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		if dt < 0 {
 			dt = 0
 		}
@@ -195,7 +203,7 @@ func registerCore(v *vm.VM) {
 		return rl.IsWindowFullscreen(), nil
 	})
 	v.RegisterForeign("GetTime", func(args []interface{}) (interface{}, error) {
-		return rl.GetTime(), nil
+		return headless.Time(), nil
 	})
 	v.RegisterForeign("GetRandomValue", func(args []interface{}) (interface{}, error) {
 		if len(args) < 2 {
@@ -726,6 +734,24 @@ func registerCore(v *vm.VM) {
 		}
 		return nil, nil
 	})
+	v.RegisterForeign("SetShaderUniformVec3", func(args []interface{}) (interface{}, error) {
+		if len(args) < 5 {
+			return nil, fmt.Errorf("SetShaderUniformVec3 requires (shaderId, name, x, y, z)")
+		}
+		id := toString(args[0])
+		name := toString(args[1])
+		shaderMu.Lock()
+		sh, ok := shaders[id]
+		shaderMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown shader id: %s", id)
+		}
+		loc := rl.GetShaderLocation(sh, name)
+		if loc >= 0 {
+			rl.SetShaderValue(sh, loc, []float32{toFloat32(args[2]), toFloat32(args[3]), toFloat32(args[4])}, rl.ShaderUniformVec3)
+		}
+		return nil, nil
+	})
 	// SetShaderValueMatrix(shaderId, uniformName, m0..m15): set shader uniform to 4x4 matrix (row-major: M0,M4,M8,M12, M1,M5,M9,M13, ...).
 	v.RegisterForeign("SetShaderValueMatrix", func(args []interface{}) (interface{}, error) {
 		if len(args) < 19 {
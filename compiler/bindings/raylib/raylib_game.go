@@ -8,6 +8,7 @@ import (
 
 	"cyberbasic/compiler/bindings/box2d"
 	"cyberbasic/compiler/bindings/bullet"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -1051,14 +1052,14 @@ func registerGame(v *vm.VM) {
 		if len(args) < 2 {
 			return nil, fmt.Errorf("SetCollisionHandler requires (bodyId, subName)")
 		}
-		v.RegisterCollisionHandler(fmt.Sprint(args[0]), fmt.Sprint(args[1]))
+		v.RegisterCollisionHandler(fmt.Sprint(args[0]), vm.Callback(args[1]))
 		return nil, nil
 	})
 	v.RegisterForeign("SetCollisionHandler", func(args []interface{}) (interface{}, error) {
 		if len(args) < 2 {
 			return nil, fmt.Errorf("SetCollisionHandler requires (bodyId, subName)")
 		}
-		v.RegisterCollisionHandler(fmt.Sprint(args[0]), fmt.Sprint(args[1]))
+		v.RegisterCollisionHandler(fmt.Sprint(args[0]), vm.Callback(args[1]))
 		return nil, nil
 	})
 	// ProcessCollisions2D(worldId): call registered collision Subs for each collision this frame. Call after BOX2D.Step.
@@ -1067,11 +1068,11 @@ func registerGame(v *vm.VM) {
 			return nil, fmt.Errorf("ProcessCollisions2D requires (worldId)")
 		}
 		worldId := fmt.Sprint(args[0])
-		for bodyId, subName := range v.GetCollisionHandlers() {
+		for bodyId, handler := range v.GetCollisionHandlers() {
 			n := box2d.GetCollisionCountForBody(worldId, bodyId)
 			for i := 0; i < n; i++ {
 				other := box2d.GetCollisionOtherForBody(worldId, bodyId, i)
-				_ = v.InvokeSub(subName, []interface{}{other})
+				_, _ = v.Invoke(handler, []interface{}{other})
 			}
 		}
 		return nil, nil
@@ -1081,11 +1082,11 @@ func registerGame(v *vm.VM) {
 			return nil, fmt.Errorf("ProcessCollisions2D requires (worldId)")
 		}
 		worldId := fmt.Sprint(args[0])
-		for bodyId, subName := range v.GetCollisionHandlers() {
+		for bodyId, handler := range v.GetCollisionHandlers() {
 			n := box2d.GetCollisionCountForBody(worldId, bodyId)
 			for i := 0; i < n; i++ {
 				other := box2d.GetCollisionOtherForBody(worldId, bodyId, i)
-				_ = v.InvokeSub(subName, []interface{}{other})
+				_, _ = v.Invoke(handler, []interface{}{other})
 			}
 		}
 		return nil, nil
@@ -1291,7 +1292,7 @@ func registerGame(v *vm.VM) {
 	})
 	// ClampDelta(maxDt): return min(GetFrameTime(), maxDt) for stable physics step
 	v.RegisterForeign("GAME.ClampDelta", func(args []interface{}) (interface{}, error) {
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		if len(args) >= 1 {
 			maxDt := toFloat32(args[0])
 			if maxDt > 0 && float32(dt) > maxDt {
@@ -1301,7 +1302,7 @@ func registerGame(v *vm.VM) {
 		return float64(dt), nil
 	})
 	v.RegisterForeign("ClampDelta", func(args []interface{}) (interface{}, error) {
-		dt := rl.GetFrameTime()
+		dt := headless.FrameTime()
 		if len(args) >= 1 {
 			maxDt := toFloat32(args[0])
 			if maxDt > 0 && float32(dt) > maxDt {
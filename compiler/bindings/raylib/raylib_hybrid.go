@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"cyberbasic/compiler/bindings/game"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
@@ -199,6 +201,11 @@ func DrawQueuesGUI(v *vm.VM) {
 }
 
 func flushRenderQueues(v *vm.VM) (interface{}, error) {
+	if headless.Enabled() {
+		headless.RecordQueues(v)
+		frame.End()
+		return nil, nil
+	}
 	// PollInputEvents is called once at frame start (beginRuntimeFrame or BeginDrawing); do not poll here or IsKeyPressed/IsMouseButtonPressed get cleared
 	rl.BeginDrawing()
 	rl.ClearBackground(rl.NewColor(25, 25, 35, 255))
@@ -207,7 +214,7 @@ func flushRenderQueues(v *vm.VM) (interface{}, error) {
 	DrawQueues3D(v)
 	rl.EndMode3D()
 	DrawQueuesGUI(v)
-	rl.EndDrawing()
+	frame.End()
 	return nil, nil
 }
 
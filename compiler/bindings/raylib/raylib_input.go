@@ -2,6 +2,8 @@
 package raylib
 
 import (
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/replay"
 	"cyberbasic/compiler/vm"
 	"fmt"
 
@@ -434,3 +436,37 @@ func registerInput(v *vm.VM) {
 	v.RegisterForeign("KEY_F11", func(args []interface{}) (interface{}, error) { return int(rl.KeyF11), nil })
 	v.RegisterForeign("KEY_F12", func(args []interface{}) (interface{}, error) { return int(rl.KeyF12), nil })
 }
+
+// sampleDevices returns the keyboard, mouse and gamepads' state and raylib's frame time, for --record with a
+// window (replay.SetDevices).
+func sampleDevices() replay.Frame {
+	fr := replay.Frame{DT: rl.GetFrameTime(), Wheel: float64(rl.GetMouseWheelMove())}
+	for k := int32(1); k <= rl.KeyKbMenu; k++ {
+		if rl.IsKeyDown(k) {
+			fr.Keys = append(fr.Keys, int(k))
+		}
+	}
+	pos := rl.GetMousePosition()
+	fr.MouseX, fr.MouseY = float64(pos.X), float64(pos.Y)
+	for b := 0; b < 3; b++ {
+		if rl.IsMouseButtonDown(rl.MouseButton(b)) {
+			fr.Buttons = append(fr.Buttons, b)
+		}
+	}
+	for id := int32(0); id < headless.MaxGamepads; id++ {
+		if !rl.IsGamepadAvailable(id) {
+			continue
+		}
+		pad := replay.Pad{ID: int(id)}
+		for b := int32(1); b < headless.GamepadButtons; b++ {
+			if rl.IsGamepadButtonDown(id, b) {
+				pad.Buttons = append(pad.Buttons, int(b))
+			}
+		}
+		for a := int32(0); a < headless.GamepadAxes; a++ {
+			pad.Axes = append(pad.Axes, float64(rl.GetGamepadAxisMovement(id, a)))
+		}
+		fr.Pads = append(fr.Pads, pad)
+	}
+	return fr
+}
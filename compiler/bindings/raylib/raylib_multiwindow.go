@@ -25,12 +25,13 @@ type windowMessage struct {
 	Data    interface{}
 }
 
+// windowEventHandlers holds the Sub names or function values (vm.Callback) registered with OnWindow*.
 type windowEventHandlers struct {
-	Update  string
-	Draw    string
-	Resize  string
-	Close   string
-	Message string
+	Update  vm.Value
+	Draw    vm.Value
+	Resize  vm.Value
+	Close   vm.Value
+	Message vm.Value
 }
 
 type windowState struct {
@@ -44,7 +45,7 @@ type windowState struct {
 	RenderTextureID  string
 	MessageQueue    []windowMessage
 	Handlers        windowEventHandlers
-	RegisteredFuncs map[string]string // name -> Sub name
+	RegisteredFuncs map[string]vm.Value // name -> Sub name or function value
 	CameraID        string            // for 3D
 }
 
@@ -110,7 +111,7 @@ func createWindow(w, h int32, title, winType string, parentID int) (int, error)
 		RenderTextureID: rtID,
 		MessageQueue:    nil,
 		Handlers:        windowEventHandlers{},
-		RegisteredFuncs: make(map[string]string),
+		RegisteredFuncs: make(map[string]vm.Value),
 	}
 	multiWindowMu.Unlock()
 	return id, nil
@@ -599,14 +600,14 @@ func registerMultiWindow(v *vm.VM) {
 			return nil, fmt.Errorf("OnWindowUpdate requires (id, function)")
 		}
 		id := int(toInt32(args[0]))
-		subName := toString(args[1])
+		handler := vm.Callback(args[1])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.Handlers.Update = subName
+		win.Handlers.Update = handler
 		return nil, nil
 	})
 	v.RegisterForeign("OnWindowDraw", func(args []interface{}) (interface{}, error) {
@@ -614,14 +615,14 @@ func registerMultiWindow(v *vm.VM) {
 			return nil, fmt.Errorf("OnWindowDraw requires (id, function)")
 		}
 		id := int(toInt32(args[0]))
-		subName := toString(args[1])
+		handler := vm.Callback(args[1])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.Handlers.Draw = subName
+		win.Handlers.Draw = handler
 		return nil, nil
 	})
 	v.RegisterForeign("OnWindowResize", func(args []interface{}) (interface{}, error) {
@@ -629,14 +630,14 @@ func registerMultiWindow(v *vm.VM) {
 			return nil, fmt.Errorf("OnWindowResize requires (id, function)")
 		}
 		id := int(toInt32(args[0]))
-		subName := toString(args[1])
+		handler := vm.Callback(args[1])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.Handlers.Resize = subName
+		win.Handlers.Resize = handler
 		return nil, nil
 	})
 	v.RegisterForeign("OnWindowClose", func(args []interface{}) (interface{}, error) {
@@ -644,14 +645,14 @@ func registerMultiWindow(v *vm.VM) {
 			return nil, fmt.Errorf("OnWindowClose requires (id, function)")
 		}
 		id := int(toInt32(args[0]))
-		subName := toString(args[1])
+		handler := vm.Callback(args[1])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.Handlers.Close = subName
+		win.Handlers.Close = handler
 		return nil, nil
 	})
 	v.RegisterForeign("OnWindowMessage", func(args []interface{}) (interface{}, error) {
@@ -659,14 +660,14 @@ func registerMultiWindow(v *vm.VM) {
 			return nil, fmt.Errorf("OnWindowMessage requires (id, function)")
 		}
 		id := int(toInt32(args[0]))
-		subName := toString(args[1])
+		handler := vm.Callback(args[1])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.Handlers.Message = subName
+		win.Handlers.Message = handler
 		return nil, nil
 	})
 	v.RegisterForeign("WindowProcessEvents", func(args []interface{}) (interface{}, error) {
@@ -677,8 +678,8 @@ func registerMultiWindow(v *vm.VM) {
 		}
 		multiWindowMu.RUnlock()
 		for _, w := range winList {
-			if w.Handlers.Update != "" {
-				_ = v.InvokeSub(w.Handlers.Update, []interface{}{w.ID})
+			if w.Handlers.Update != nil {
+				_, _ = v.Invoke(w.Handlers.Update, []interface{}{w.ID})
 			}
 		}
 		return nil, nil
@@ -692,7 +693,7 @@ func registerMultiWindow(v *vm.VM) {
 		if win == nil {
 			return nil, nil
 		}
-		if win.Handlers.Draw == "" {
+		if win.Handlers.Draw == nil {
 			return nil, nil
 		}
 		if id != 0 && win.RenderTextureID != "" {
@@ -702,13 +703,13 @@ func registerMultiWindow(v *vm.VM) {
 			if ok {
 				rl.BeginTextureMode(rt)
 				currentDrawWindow = id
-				_ = v.InvokeSub(win.Handlers.Draw, []interface{}{id})
+				_, _ = v.Invoke(win.Handlers.Draw, []interface{}{id})
 				rl.EndTextureMode()
 				currentDrawWindow = -1
 			}
 		} else if id == 0 {
 			currentDrawWindow = 0
-			_ = v.InvokeSub(win.Handlers.Draw, []interface{}{0})
+			_, _ = v.Invoke(win.Handlers.Draw, []interface{}{0})
 			currentDrawWindow = -1
 		}
 		return nil, nil
@@ -770,14 +771,14 @@ func registerMultiWindow(v *vm.VM) {
 		}
 		id := int(toInt32(args[0]))
 		name := toString(args[1])
-		subName := toString(args[2])
+		handler := vm.Callback(args[2])
 		multiWindowMu.Lock()
 		defer multiWindowMu.Unlock()
 		win, ok := windows[id]
 		if !ok {
 			return nil, nil
 		}
-		win.RegisteredFuncs[name] = subName
+		win.RegisteredFuncs[name] = handler
 		return nil, nil
 	})
 	v.RegisterForeign("WindowCall", func(args []interface{}) (interface{}, error) {
@@ -788,19 +789,19 @@ func registerMultiWindow(v *vm.VM) {
 		funcName := toString(args[1])
 		multiWindowMu.RLock()
 		win, ok := windows[targetID]
-		var subName string
+		var handler vm.Value
 		if ok {
-			subName = win.RegisteredFuncs[funcName]
+			handler = win.RegisteredFuncs[funcName]
 		}
 		multiWindowMu.RUnlock()
-		if subName == "" {
+		if handler == nil {
 			return nil, nil
 		}
 		callArgs := make([]interface{}, 0, len(args)-2)
 		for i := 2; i < len(args); i++ {
 			callArgs = append(callArgs, args[i])
 		}
-		_ = v.InvokeSub(subName, callArgs)
+		_, _ = v.Invoke(handler, callArgs)
 		return nil, nil
 	})
 
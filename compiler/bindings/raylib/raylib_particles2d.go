@@ -4,9 +4,10 @@ package raylib
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"sync"
 
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -171,7 +172,7 @@ func drawParticleEmitter2D(id string) {
 		particleEmitter2DMu.Unlock()
 		return
 	}
-	dt := rl.GetFrameTime()
+	dt := headless.FrameTime()
 	e.Accum += e.Rate * dt
 	for e.Accum >= 1 {
 		e.Accum -= 1
@@ -221,7 +222,7 @@ func randFloat() float64 {
 	if seedableRand != nil {
 		return seedableRand.Float64()
 	}
-	return float64(rand.Int31()) / (1 << 31)
+	return float64(rng.Int31()) / (1 << 31)
 }
 
 // GetParticleEmitter2DLayerAndZ returns layer and z for 2D emitter (for flush sorting).
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	rl "github.com/gen2brain/raylib-go/raylib"
 )
@@ -295,7 +296,7 @@ func registerSprite(v *vm.VM) {
 		spriteMu.Lock()
 		s, ok := sprites[id]
 		if ok && s.Playing && s.FrameCount > 0 && s.AnimSpeed > 0 {
-			dt := rl.GetFrameTime()
+			dt := headless.FrameTime()
 			s.FrameIndex = int(float32(s.FrameIndex) + s.AnimSpeed*dt) % s.FrameCount
 			if s.FrameIndex < 0 {
 				s.FrameIndex += s.FrameCount
@@ -51,8 +51,16 @@ import (
 type RegisterOptions struct {
 	// Source is full program source (or accumulated REPL session). Used with runtime.DetectWindowMode.
 	Source string
+	// Mode, when non-nil, replaces DetectWindowMode(Source). Precompiled .cbc programs carry the mode instead of source.
+	Mode *runtime.WindowMode
 	// SkipRaylib skips raylib + flush override + renderer global hooks (for headless/unit tests).
 	SkipRaylib bool
+	// Headless puts the null renderer in front of the window, drawing, GPU resource, audio and input commands
+	// (--headless); headless.Enable must have been called.
+	Headless bool
+	// ScriptedInput makes the keyboard, mouse and gamepad commands read headless's scripted input while the
+	// window stays (--record, --replay); package replay must have been started.
+	ScriptedInput bool
 }
 
 // RegisterAll installs every foreign binding on v in a fixed order.
@@ -64,6 +72,8 @@ type RegisterOptions struct {
 //  4. Net, Nakama, Scene, Game — multiplayer and scene graph.
 //  5. DBP 2D overlay, SQL, terrain stack, objects, procedural, water, vegetation, world, nav, indoor.
 //  6. Std + v2 modules (audio, input, assets, shader, effect, camera.fx, tween, AI) + WINDOW + engine composition last.
+//  7. With Headless, the null renderer wraps what is registered (installHeadless); with ScriptedInput, only
+//     the input commands are wrapped (installScriptedInput).
 //
 // DBP terrain/water/object overlays must run after their native packages so integer-ID commands take precedence where intended.
 func RegisterAll(v *vm.VM, opts RegisterOptions) error {
@@ -96,6 +106,7 @@ func RegisterAll(v *vm.VM, opts RegisterOptions) error {
 	dbp.Register2D(v)
 	sql.RegisterSQL(v)
 	terrain.RegisterTerrain(v)
+	bullet.SetHeightfieldSource(terrain.PhysicsHeightfield)
 	dbp.RegisterTerrain(v)
 	objects.RegisterObjects(v)
 	dbp.RegisterDrawObjectOverlay(v)
@@ -121,6 +132,15 @@ func RegisterAll(v *vm.VM, opts RegisterOptions) error {
 	engine.RegisterEngine(v)
 
 	physics2d.WorldEnsured = false
-	physics2d.RequireExplicitWorld = runtime.DetectWindowMode(opts.Source) == runtime.ModeExplicit
+	mode := runtime.DetectWindowMode(opts.Source)
+	if opts.Mode != nil {
+		mode = *opts.Mode
+	}
+	physics2d.RequireExplicitWorld = mode == runtime.ModeExplicit
+	if opts.Headless {
+		installHeadless(v)
+	} else if opts.ScriptedInput {
+		installScriptedInput(v)
+	}
 	return nil
 }
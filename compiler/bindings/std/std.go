@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"math"
-	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
@@ -20,6 +19,7 @@ import (
 	"time"
 
 	"cyberbasic/compiler/bindings/modfacade"
+	"cyberbasic/compiler/rng"
 	"cyberbasic/compiler/valueutil"
 	"cyberbasic/compiler/vm"
 	"github.com/google/uuid"
@@ -61,13 +61,17 @@ var (
 	locMu            sync.RWMutex
 
 	httpAsyncMu    sync.Mutex
-	httpAsyncJobs  = make(map[string]chan asyncHTTPResult)
+	httpAsyncJobs  = make(map[string]*vm.Future)
 	httpAsyncSeq   int64
 )
 
-type asyncHTTPResult struct {
-	val interface{}
-	err error
+// channelArg returns a as a channel or a "fn: expected a channel" error.
+func channelArg(fn string, a interface{}) (*vm.Channel, error) {
+	ch, ok := a.(*vm.Channel)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a channel from ChanCreate, got %T", fn, a)
+	}
+	return ch, nil
 }
 
 func toFloat64(v interface{}) float64 {
@@ -408,7 +412,7 @@ func RegisterStd(v *vm.VM) {
 	// Random(n): integer 0 to n-1. Random(min, max): integer in [min, max] inclusive.
 	v.RegisterForeign("Random", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
-			return rand.Int(), nil
+			return rng.Int(), nil
 		}
 		if len(args) >= 2 {
 			lo, hi := toInt(args[0]), toInt(args[1])
@@ -419,13 +423,13 @@ func RegisterStd(v *vm.VM) {
 			if n <= 0 {
 				return lo, nil
 			}
-			return rand.Intn(n) + lo, nil
+			return rng.Intn(n) + lo, nil
 		}
 		n := toInt(args[0])
 		if n <= 0 {
 			return 0, nil
 		}
-		return rand.Intn(n), nil
+		return rng.Intn(n), nil
 	})
 	v.RegisterForeign("TimeNow", func(args []interface{}) (interface{}, error) {
 		return float64(time.Now().UnixNano()) / 1e9, nil
@@ -453,6 +457,124 @@ func RegisterStd(v *vm.VM) {
 		}
 		return time.Since(start).Seconds(), nil
 	})
+	// ProcessEvents(): run pending ON handlers now (the game loop and SYNC already do this once per frame)
+	v.RegisterForeign("ProcessEvents", func(args []interface{}) (interface{}, error) {
+		return nil, v.ProcessEvents()
+	})
+	// StartEventTimer(name, seconds [, repeat]): raise ON Timer("name") after seconds, every seconds unless repeat is 0
+	v.RegisterForeign("StartEventTimer", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("StartEventTimer(name, seconds [, repeat]) requires 2 arguments")
+		}
+		repeat := len(args) < 3 || toFloat64(args[2]) != 0
+		v.StartEventTimer(toString(args[0]), toFloat64(args[1]), repeat)
+		return nil, nil
+	})
+	v.RegisterForeign("StopEventTimer", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("StopEventTimer(name) requires 1 argument")
+		}
+		v.StopEventTimer(toString(args[0]))
+		return nil, nil
+	})
+	// --- Channels and tasks (AWAIT ChanReceive(ch) / AWAIT task in coroutines) ---
+	v.RegisterForeign("ChanCreate", func(args []interface{}) (interface{}, error) {
+		capacity := 0
+		if len(args) >= 1 {
+			capacity = int(toFloat64(args[0]))
+		}
+		return vm.NewChannel(capacity), nil
+	})
+	v.RegisterForeign("ChanSend", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("ChanSend(channel, value) requires 2 arguments")
+		}
+		ch, err := channelArg("ChanSend", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.Send(args[1]), nil
+	})
+	v.RegisterForeign("ChanReceive", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ChanReceive(channel) requires 1 argument")
+		}
+		ch, err := channelArg("ChanReceive", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.Receive(), nil
+	})
+	// ChanSelect(timeout, ch1, ch2, ...): AWAIT gives the 1-based index received from (0 on timeout); ChanSelectValue(sel) the value
+	v.RegisterForeign("ChanSelect", func(args []interface{}) (interface{}, error) {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("ChanSelect(timeout, channel, ...) requires at least 2 arguments")
+		}
+		chans := make([]*vm.Channel, 0, len(args)-1)
+		for _, a := range args[1:] {
+			ch, err := channelArg("ChanSelect", a)
+			if err != nil {
+				return nil, err
+			}
+			chans = append(chans, ch)
+		}
+		return vm.NewSelect(toFloat64(args[0]), chans), nil
+	})
+	v.RegisterForeign("ChanSelectValue", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ChanSelectValue(select) requires 1 argument")
+		}
+		sel, ok := args[0].(*vm.Select)
+		if !ok {
+			return nil, fmt.Errorf("ChanSelectValue: expected the result of ChanSelect, got %T", args[0])
+		}
+		return sel.Value(), nil
+	})
+	v.RegisterForeign("ChanCount", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ChanCount(channel) requires 1 argument")
+		}
+		ch, err := channelArg("ChanCount", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.Len(), nil
+	})
+	v.RegisterForeign("ChanClose", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ChanClose(channel) requires 1 argument")
+		}
+		ch, err := channelArg("ChanClose", args[0])
+		if err != nil {
+			return nil, err
+		}
+		ch.Close()
+		return nil, nil
+	})
+	v.RegisterForeign("ChanClosed", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("ChanClosed(channel) requires 1 argument")
+		}
+		ch, err := channelArg("ChanClosed", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.Closed(), nil
+	})
+	// IsDone(x): true once a task, async result or channel operation has completed (without waiting)
+	v.RegisterForeign("IsDone", func(args []interface{}) (interface{}, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("IsDone(task) requires 1 argument")
+		}
+		switch x := args[0].(type) {
+		case *vm.Task:
+			return x.Done(), nil
+		case *vm.Future:
+			done, _, _ := x.Poll()
+			return done, nil
+		}
+		return nil, fmt.Errorf("IsDone: expected a task or async result, got %T", args[0])
+	})
 	// PrintDebug(value): print value to stderr for debugging (e.g. PrintDebug("x=" + Str(x)))
 	v.RegisterForeign("PrintDebug", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
@@ -607,7 +729,7 @@ func RegisterStd(v *vm.VM) {
 		if hi < lo {
 			lo, hi = hi, lo
 		}
-		return lo + rand.Float64()*(hi-lo), nil
+		return lo + rng.Float64()*(hi-lo), nil
 	})
 	v.RegisterForeign("RandomInt", func(args []interface{}) (interface{}, error) {
 		if len(args) < 2 {
@@ -621,7 +743,7 @@ func RegisterStd(v *vm.VM) {
 		if n <= 0 {
 			return lo, nil
 		}
-		return rand.Intn(n) + lo, nil
+		return rng.Intn(n) + lo, nil
 	})
 	v.RegisterForeign("Log", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
@@ -635,13 +757,13 @@ func RegisterStd(v *vm.VM) {
 	// --- Math (DBP-style: Rnd, Int) ---
 	v.RegisterForeign("Rnd", func(args []interface{}) (interface{}, error) {
 		if len(args) == 0 {
-			return rand.Float64(), nil
+			return rng.Float64(), nil
 		}
 		n := toInt(args[0])
 		if n <= 0 {
 			return 1, nil
 		}
-		return rand.Intn(n) + 1, nil
+		return rng.Intn(n) + 1, nil
 	})
 	v.RegisterForeign("Int", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
@@ -1066,31 +1188,36 @@ func RegisterStd(v *vm.VM) {
 		}
 		return string(body), nil
 	})
+	// HttpGetAsync(url) starts the request and returns at once; AWAIT the result in a coroutine, or pass it to
+	// HttpAwait (which blocks). The result prints as its job id.
 	v.RegisterForeign("HttpGetAsync", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
 			return nil, fmt.Errorf("HttpGetAsync(url) requires 1 argument")
 		}
 		urlStr := toString(args[0])
 		id := fmt.Sprintf("httpjob_%d", atomic.AddInt64(&httpAsyncSeq, 1))
-		ch := make(chan asyncHTTPResult, 1)
-		httpAsyncMu.Lock()
-		httpAsyncJobs[id] = ch
-		httpAsyncMu.Unlock()
-		go func() {
+		job := vm.Go(id, func() (vm.Value, error) {
 			resp, err := http.Get(urlStr)
 			if err != nil {
-				ch <- asyncHTTPResult{nil, err}
-				return
+				return nil, err
 			}
 			defer resp.Body.Close()
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
-				ch <- asyncHTTPResult{nil, err}
-				return
+				return nil, err
 			}
-			ch <- asyncHTTPResult{string(body), nil}
-		}()
-		return id, nil
+			return string(body), nil
+		})
+		// The id is only needed by HttpAwait(id$); a job consumed through AWAIT is forgotten.
+		job.OnAwaited(func() {
+			httpAsyncMu.Lock()
+			delete(httpAsyncJobs, id)
+			httpAsyncMu.Unlock()
+		})
+		httpAsyncMu.Lock()
+		httpAsyncJobs[id] = job
+		httpAsyncMu.Unlock()
+		return job, nil
 	})
 	v.RegisterForeign("HttpAwait", func(args []interface{}) (interface{}, error) {
 		if len(args) < 1 {
@@ -1098,14 +1225,13 @@ func RegisterStd(v *vm.VM) {
 		}
 		id := toString(args[0])
 		httpAsyncMu.Lock()
-		ch, ok := httpAsyncJobs[id]
+		job, ok := httpAsyncJobs[id]
 		delete(httpAsyncJobs, id)
 		httpAsyncMu.Unlock()
 		if !ok {
 			return nil, fmt.Errorf("HttpAwait: unknown job id %q", id)
 		}
-		res := <-ch
-		return res.val, res.err
+		return job.Wait()
 	})
 	v.RegisterForeign("HttpPost", func(args []interface{}) (interface{}, error) {
 		if len(args) < 2 {
@@ -1259,3 +1385,8 @@ func RegisterStd(v *vm.VM) {
 func helpCommandLine(cmd string) string {
 	return helpMap[cmd]
 }
+
+// HelpLine returns the one-line help shown by HELP("name") (any case), or "" if there is none. Used by the language server.
+func HelpLine(name string) string {
+	return helpMap[strings.ToLower(name)]
+}
@@ -44,6 +44,20 @@ var stdV2 = map[string]string{
 	"timenow":      "TimeNow",
 	"timerstart":   "TimerStart",
 	"timerelapsed": "TimerElapsed",
+	// Events
+	"processevents":   "ProcessEvents",
+	"starteventtimer": "StartEventTimer",
+	"stopeventtimer":  "StopEventTimer",
+	// Channels / tasks
+	"chancreate":      "ChanCreate",
+	"chansend":        "ChanSend",
+	"chanreceive":     "ChanReceive",
+	"chanselect":      "ChanSelect",
+	"chanselectvalue": "ChanSelectValue",
+	"chancount":       "ChanCount",
+	"chanclose":       "ChanClose",
+	"chanclosed":      "ChanClosed",
+	"isdone":          "IsDone",
 	// Math
 	"sin": "Sin", "cos": "Cos", "tan": "Tan", "sqrt": "Sqrt",
 	"log": "Log", "int": "Int",
@@ -3,6 +3,8 @@ package terrain
 import (
 	"fmt"
 	"math"
+
+	"cyberbasic/compiler/bindings/bullet"
 )
 
 // TerrainGetHeight returns world Y at (x,z) using bilinear sampling. Returns 0 if terrain invalid.
@@ -97,3 +99,25 @@ func TerrainRaycast(terrainID string, ox, oy, oz, dx, dy, dz float64) (hit bool,
 	}
 	return false, 0, 0, 0, 0, nil
 }
+
+// PhysicsHeightfield returns the terrain's heights (scaled by HeightScale) for bullet's CreateHeightmap3D.
+// The heights are copied, so re-create the physics body after editing the terrain.
+func PhysicsHeightfield(terrainID string) (*bullet.Heightfield, error) {
+	ts := GetTerrainState(terrainID)
+	if ts == nil {
+		return nil, fmt.Errorf("unknown terrain id: %s", terrainID)
+	}
+	hm := GetHeightmap(ts.HeightmapID)
+	if hm == nil {
+		return nil, fmt.Errorf("terrain %s has no heightmap", terrainID)
+	}
+	heights := make([]float64, len(hm.Heights))
+	for i, h := range hm.Heights {
+		heights[i] = float64(h) * float64(ts.HeightScale)
+	}
+	return &bullet.Heightfield{
+		Width: hm.Width, Depth: hm.Depth, Heights: heights,
+		SizeX: float64(ts.SizeX), SizeZ: float64(ts.SizeZ),
+		X: float64(ts.PosX), Y: float64(ts.PosY), Z: float64(ts.PosZ),
+	}, nil
+}
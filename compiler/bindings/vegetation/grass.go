@@ -3,8 +3,8 @@ package vegetation
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"sync"
+	"cyberbasic/compiler/rng"
 )
 
 // GrassInstance is one grass blade/patch position.
@@ -102,8 +102,8 @@ func GrassPaint(grassID string, x, z, radius, density float32) {
 	}
 	grassMu.Lock()
 	for i := 0; i < n; i++ {
-		angle := float32(rand.Float64() * 2 * math.Pi)
-		r := float32(rand.Float64()) * radius
+		angle := float32(rng.Float64() * 2 * math.Pi)
+		r := float32(rng.Float64()) * radius
 		gx := x + r*float32(math.Cos(float64(angle)))
 		gz := z + r*float32(math.Sin(float64(angle)))
 		g.Instances = append(g.Instances, GrassInstance{X: gx, Y: 0, Z: gz, Scale: g.Height, Rotation: float32(i) * 0.1})
@@ -3,6 +3,7 @@ package windowdot
 
 import (
 	"cyberbasic/compiler/errors"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	"fmt"
 	"strings"
@@ -91,7 +92,7 @@ func (w *WindowDot) GetProp(path []string) (vm.Value, error) {
 		}
 		return float64(0), nil
 	case "deltatime", "dt":
-		return float64(rl.GetFrameTime()), nil
+		return float64(headless.FrameTime()), nil
 	case "mousex":
 		if rl.IsWindowReady() {
 			return float64(rl.GetMouseX()), nil
@@ -2,8 +2,10 @@ package codegen
 
 import (
 	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/semantic"
 	"cyberbasic/compiler/vm"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -25,8 +27,7 @@ func (e *Emitter) compileCall(call *parser.Call) error {
 				}
 			}
 			varIndex, _ := e.chunk.GetVariable(call.Name)
-			e.chunk.Write(byte(vm.OpLoadArray))
-			e.chunk.Write(byte(varIndex))
+			e.emit(vm.OpLoadArray, varIndex)
 			return nil
 		}
 	}
@@ -40,38 +41,33 @@ func (e *Emitter) compileCall(call *parser.Call) error {
 				}
 			}
 			idx := e.chunk.WriteConstant(nameConst)
-			if err := checkConstIndex(idx, " for user call"); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpCallUser))
-			e.chunk.Write(byte(idx))
-			e.chunk.Write(byte(len(call.Arguments)))
+			e.emit(vm.OpCallUser, idx, len(call.Arguments))
 			return nil
 		}
 		parts := strings.Split(call.Name, ".")
 		first := strings.ToLower(parts[0])
 		if first != "rl" && first != "box2d" && first != "bullet" && first != "game" {
-			if flat := physicsNamespaceToFlat(nameConst); flat == "" {
+			if flat := PhysicsFlatName(nameConst); flat == "" {
 				return e.compileDotMethodCall(call, parts)
 			}
 		}
+		// bullet.* names without a legacy flat alias (bullet.character, bullet.createcapsule3d…) go through the
+		// "bullet" DotObject, which dispatches to the same foreigns.
+		if first == "bullet" && PhysicsFlatName(nameConst) == "" {
+			return e.compileDotMethodCall(call, parts)
+		}
 		for _, arg := range call.Arguments {
 			if err := e.compileExpression(arg); err != nil {
 				return err
 			}
 		}
-		if flat := physicsNamespaceToFlat(nameConst); flat != "" {
+		if flat := PhysicsFlatName(nameConst); flat != "" {
 			nameConst = flat
 		} else if strings.HasPrefix(nameConst, "rl.") {
 			nameConst = nameConst[3:]
 		}
 		idx := e.chunk.WriteConstant(nameConst)
-		if err := checkConstIndex(idx, " for foreign call"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallForeign))
-		e.chunk.Write(byte(idx))
-		e.chunk.Write(byte(len(call.Arguments)))
+		e.emit(vm.OpCallForeign, idx, len(call.Arguments))
 		return nil
 	}
 
@@ -108,22 +104,14 @@ func (e *Emitter) compileCall(call *parser.Call) error {
 		ri := e.chunk.WriteConstant(strings.ToLower(r))
 		ai := e.chunk.WriteConstant(strings.ToLower(a))
 		bi := e.chunk.WriteConstant(strings.ToLower(b))
-		if err := checkConstIndex(ri, ""); err != nil {
-			return err
-		}
-		if err := checkConstIndex(ai, ""); err != nil {
-			return err
-		}
-		if err := checkConstIndex(bi, ""); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpMatMul))
-		e.chunk.Write(byte(ri))
-		e.chunk.Write(byte(ai))
-		e.chunk.Write(byte(bi))
+		e.emit(vm.OpMatMul, ri, ai, bi)
 		return nil
 	}
 
+	if td, ok := e.sem.TypeDefs[name]; ok && !e.sem.UserFuncs[name] {
+		return e.compileConstructor(call, td)
+	}
+
 	for _, arg := range call.Arguments {
 		if err := e.compileExpression(arg); err != nil {
 			return err
@@ -132,308 +120,215 @@ func (e *Emitter) compileCall(call *parser.Call) error {
 
 	if e.sem.UserFuncs != nil && e.sem.UserFuncs[name] {
 		idx := e.chunk.WriteConstant(name)
-		if err := checkConstIndex(idx, " for user call"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallUser))
-		e.chunk.Write(byte(idx))
-		e.chunk.Write(byte(len(call.Arguments)))
+		e.emit(vm.OpCallUser, idx, len(call.Arguments))
 		return nil
 	}
 
-	switch name {
-	case "str":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("STR() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpStr))
-		return nil
-	case "random":
-		if len(call.Arguments) == 0 {
-			e.chunk.Write(byte(vm.OpRandom))
-			return nil
-		}
-		if len(call.Arguments) == 1 {
-			e.chunk.Write(byte(vm.OpRandomN))
-			return nil
-		}
-		return fmt.Errorf("Random() expects 0 or 1 argument")
-	case "sleep", "wait":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Sleep/Wait expect 1 argument (milliseconds)")
-		}
-		e.chunk.Write(byte(vm.OpSleep))
-		return nil
-	case "int":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Int() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpInt))
-		return nil
-	case "timer":
-		if len(call.Arguments) != 0 {
-			return fmt.Errorf("Timer() takes no arguments")
-		}
-		e.chunk.Write(byte(vm.OpTimer))
-		return nil
-	case "resettimer":
-		if len(call.Arguments) != 0 {
-			return fmt.Errorf("ResetTimer() takes no arguments")
-		}
-		e.chunk.Write(byte(vm.OpResetTimer))
-		return nil
-	case "quit":
-		if len(call.Arguments) != 0 {
-			return fmt.Errorf("Quit takes no arguments")
-		}
-		e.chunk.Write(byte(vm.OpQuit))
-		return nil
-	case "sin":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Sin() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpSin))
-		return nil
-	case "cos":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Cos() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpCos))
-		return nil
-	case "tan":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Tan() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpTan))
-		return nil
-	case "sqrt":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Sqrt() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpSqrt))
-		return nil
-	case "abs":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Abs() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpAbs))
-		return nil
-	case "lerp":
-		if len(call.Arguments) != 3 {
-			return fmt.Errorf("Lerp(a, b, t) expects 3 arguments")
-		}
-		e.chunk.Write(byte(vm.OpLerp))
-		return nil
-	case "noise", "noise2d", "perlin", "simplex":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Noise(x, y) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpNoise2D))
-		return nil
-	case "openfile":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("OpenFile(path, mode) expects 2 arguments; mode 0=read, 1=write, 2=append")
-		}
-		e.chunk.Write(byte(vm.OpOpenFile))
-		return nil
-	case "readline":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("ReadLine(handle) expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpReadLine))
-		return nil
-	case "writeline":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("WriteLine(handle, text) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpWriteLine))
-		return nil
-	case "closefile":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("CloseFile(handle) expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpCloseFile))
-		return nil
-	case "readbyte":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("ReadByte(handle) expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpReadByte))
-		return nil
-	case "writebyte":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("WriteByte(handle, value) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpWriteByte))
-		return nil
-	case "floor":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Floor() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpFloor))
-		return nil
-	case "ceil":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Ceil() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpCeil))
-		return nil
-	case "round":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Round() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpRound))
-		return nil
-	case "min":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Min(a, b) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpMin))
-		return nil
-	case "max":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Max(a, b) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpMax))
-		return nil
-	case "clamp":
-		if len(call.Arguments) != 3 {
-			return fmt.Errorf("Clamp(x, lo, hi) expects 3 arguments")
-		}
-		e.chunk.Write(byte(vm.OpClamp))
-		return nil
-	case "pow":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Pow(base, exp) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpPow))
-		return nil
-	case "exp":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Exp() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpExp))
-		return nil
-	case "log":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Log() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpLog))
-		return nil
-	case "log10":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Log10() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpLog10))
-		return nil
-	case "atan2":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Atan2(y, x) expects 2 arguments")
-		}
-		e.chunk.Write(byte(vm.OpAtan2))
-		return nil
-	case "sign":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Sign() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpSign))
-		return nil
-	case "deg2rad":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Deg2Rad() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpDeg2Rad))
-		return nil
-	case "rad2deg":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Rad2Deg() expects 1 argument")
-		}
-		e.chunk.Write(byte(vm.OpRad2Deg))
-		return nil
-	case "distance2d":
-		if len(call.Arguments) != 4 {
-			return fmt.Errorf("Distance2D(x1, y1, x2, y2) expects 4 arguments")
-		}
-		e.chunk.Write(byte(vm.OpDistance2D))
-		return nil
-	case "distance3d":
-		if len(call.Arguments) != 6 {
-			return fmt.Errorf("Distance3D(x1, y1, z1, x2, y2, z2) expects 6 arguments")
-		}
-		e.chunk.Write(byte(vm.OpDistance3D))
-		return nil
-	case "distsq2d":
-		if len(call.Arguments) != 4 {
-			return fmt.Errorf("DistSq2D(x1, y1, x2, y2) expects 4 arguments")
-		}
-		e.chunk.Write(byte(vm.OpDistSq2D))
-		return nil
-	case "distsq3d":
-		if len(call.Arguments) != 6 {
-			return fmt.Errorf("DistSq3D(x1, y1, z1, x2, y2, z2) expects 6 arguments")
-		}
-		e.chunk.Write(byte(vm.OpDistSq3D))
-		return nil
-	case "inradius2d":
-		if len(call.Arguments) != 5 {
-			return fmt.Errorf("InRadius2D(x1, y1, x2, y2, radius) expects 5 arguments")
-		}
-		e.chunk.Write(byte(vm.OpInRadius2D))
-		return nil
-	case "inradius3d":
-		if len(call.Arguments) != 7 {
-			return fmt.Errorf("InRadius3D(x1, y1, z1, x2, y2, z2, radius) expects 7 arguments")
+	if op, ok, err := Builtin(name, len(call.Arguments)); ok {
+		if err != nil {
+			return err
 		}
-		e.chunk.Write(byte(vm.OpInRadius3D))
+		e.chunk.Write(byte(op))
 		return nil
-	case "angle2d":
-		if len(call.Arguments) != 4 {
-			return fmt.Errorf("Angle2D(x1, y1, x2, y2) expects 4 arguments")
-		}
-		e.chunk.Write(byte(vm.OpAngle2D))
+	}
+
+	nameConst := strings.ToLower(call.Name)
+	if strings.HasPrefix(nameConst, "rl.") {
+		nameConst = nameConst[3:]
+	}
+	idx := e.chunk.WriteConstant(nameConst)
+	if e.loadFunctionValue(call.Name) {
+		e.emit(vm.OpCallValue, idx, len(call.Arguments))
+		e.callValueEnd = len(e.chunk.Code)
 		return nil
-	case "left", "left$":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Left(s, n) expects 2 arguments")
+	}
+	e.emit(vm.OpCallForeign, idx, len(call.Arguments))
+	return nil
+}
+
+// loadFunctionValue pushes the parameter or variable name when one exists, for OpCallValue to call the function
+// value it holds (or the foreign function name when it holds something else).
+func (e *Emitter) loadFunctionValue(name string) bool {
+	if idx, ok := e.funcParamIndices[strings.ToLower(name)]; ok {
+		e.emit(vm.OpLoadParam, idx)
+		return true
+	}
+	if idx, ok := e.chunk.GetVariable(name); ok {
+		e.emit(vm.OpLoadVar, idx)
+		return true
+	}
+	return false
+}
+
+// compileConstructor compiles TypeName(args): a new instance, passed as ME with args to the type's New method
+// when it has one. The instance is left on the stack.
+func (e *Emitter) compileConstructor(call *parser.Call, td *parser.TypeDecl) error {
+	typeName := strings.ToLower(td.Name)
+	e.emit(vm.OpNewObject, e.chunk.WriteConstant(typeName))
+	ctor := typeName + ".new"
+	if !e.sem.UserFuncs[ctor] {
+		if len(call.Arguments) > 0 {
+			return errWithLine(call, fmt.Errorf("%s has no New method and takes no arguments", td.Name))
 		}
-		e.chunk.Write(byte(vm.OpLeftStr))
 		return nil
-	case "right", "right$":
-		if len(call.Arguments) != 2 {
-			return fmt.Errorf("Right(s, n) expects 2 arguments")
+	}
+	e.chunk.Write(byte(vm.OpDup))
+	for _, arg := range call.Arguments {
+		if err := e.compileExpression(arg); err != nil {
+			return err
 		}
-		e.chunk.Write(byte(vm.OpRightStr))
-		return nil
-	case "mid", "mid$":
-		if len(call.Arguments) != 3 {
-			return fmt.Errorf("Mid(s, start, n) expects 3 arguments; start is 1-based")
+	}
+	e.emit(vm.OpCallUser, e.chunk.WriteConstant(ctor), len(call.Arguments)+1)
+	for _, m := range td.Methods {
+		if _, isFunc := m.(*parser.FunctionDecl); isFunc && semantic.QualifiedName(m) == ctor {
+			e.chunk.Write(byte(vm.OpPop)) // a FUNCTION New's result is dropped; the call yields the instance
 		}
-		e.chunk.Write(byte(vm.OpMidStr))
-		return nil
-	case "len":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("Len(s) expects 1 argument")
+	}
+	return nil
+}
+
+// compileFunctionRef pushes a function value for the user Function or Sub name (qualified, lowercase). Its code
+// offset is patched once every declaration has been compiled.
+func (e *Emitter) compileFunctionRef(name string) {
+	params := 0
+	for _, d := range e.sem.Decls {
+		if semantic.QualifiedName(d) != name {
+			continue
+		}
+		switch n := d.(type) {
+		case *parser.FunctionDecl:
+			params = len(n.Parameters)
+		case *parser.SubDecl:
+			params = len(n.Parameters)
 		}
-		e.chunk.Write(byte(vm.OpLenStr))
-		return nil
-	case "eof":
-		if len(call.Arguments) != 1 {
-			return fmt.Errorf("EOF(handle) expects 1 argument")
+	}
+	pos, wide := e.emitTarget(vm.OpMakeFunction, 0, e.chunk.WriteConstant(name), params, 0)
+	e.startCoroutinePatchList = append(e.startCoroutinePatchList, startCoroutinePatch{patchPos: pos, wide: wide, subName: name})
+}
+
+// compileFunctionExpression compiles an anonymous function in place: the body is jumped over, then OpMakeFunction
+// pushes a function value for it that captures every parameter in scope (of the enclosing Sub, Function, handler
+// or anonymous function). Captured values follow the function's own parameters in its call frame.
+func (e *Emitter) compileFunctionExpression(fn *parser.FunctionExpression) error {
+	params := make(map[string]int, len(fn.Parameters)+len(e.funcParamIndices))
+	for i, p := range fn.Parameters {
+		params[strings.ToLower(p)] = i
+	}
+	outer := make([]string, 0, len(e.funcParamIndices))
+	for name := range e.funcParamIndices {
+		if _, shadowed := params[name]; !shadowed {
+			outer = append(outer, name)
 		}
-		e.chunk.Write(byte(vm.OpEOF))
-		return nil
+	}
+	sort.Slice(outer, func(i, j int) bool { return e.funcParamIndices[outer[i]] < e.funcParamIndices[outer[j]] })
+	for i, name := range outer {
+		params[name] = len(fn.Parameters) + i
 	}
 
-	nameConst := strings.ToLower(call.Name)
-	if strings.HasPrefix(nameConst, "rl.") {
-		nameConst = nameConst[3:]
+	skip := e.emitJump(vm.OpJump)
+	start := len(e.chunk.Code)
+	savedParams, savedExits, savedContinues, savedTry := e.funcParamIndices, e.loopExitStack, e.loopContinueStack, e.tryStack
+	e.funcParamIndices, e.loopExitStack, e.loopContinueStack, e.tryStack = params, nil, nil, nil
+	for _, stmt := range fn.Body.Statements {
+		if err := e.compileStatement(stmt); err != nil {
+			return err
+		}
 	}
-	idx := e.chunk.WriteConstant(nameConst)
-	if err := checkConstIndex(idx, " for foreign call"); err != nil {
-		return err
+	e.chunk.Write(byte(vm.OpReturn))
+	e.funcParamIndices, e.loopExitStack, e.loopContinueStack, e.tryStack = savedParams, savedExits, savedContinues, savedTry
+	e.patchJump(skip)
+
+	for _, name := range outer {
+		e.emit(vm.OpLoadParam, e.funcParamIndices[name])
 	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(len(call.Arguments)))
+	pos, wide := e.emitTarget(vm.OpMakeFunction, 0, e.chunk.WriteConstant(""), len(fn.Parameters), len(outer))
+	e.patchTarget(pos, start, wide)
 	return nil
 }
+
+// builtin is a function compiled to a single instruction.
+type builtin struct {
+	op    vm.OpCode
+	args  int
+	usage string // error when called with another number of arguments
+}
+
+// builtins are the functions compiled to one instruction (RANDOM, whose instruction depends on the argument
+// count, is handled by Builtin).
+var builtins = map[string]builtin{
+	"str":        {vm.OpStr, 1, "STR() expects 1 argument"},
+	"sleep":      {vm.OpSleep, 1, "Sleep/Wait expect 1 argument (milliseconds)"},
+	"wait":       {vm.OpSleep, 1, "Sleep/Wait expect 1 argument (milliseconds)"},
+	"int":        {vm.OpInt, 1, "Int() expects 1 argument"},
+	"timer":      {vm.OpTimer, 0, "Timer() takes no arguments"},
+	"resettimer": {vm.OpResetTimer, 0, "ResetTimer() takes no arguments"},
+	"quit":       {vm.OpQuit, 0, "Quit takes no arguments"},
+	"sin":        {vm.OpSin, 1, "Sin() expects 1 argument"},
+	"cos":        {vm.OpCos, 1, "Cos() expects 1 argument"},
+	"tan":        {vm.OpTan, 1, "Tan() expects 1 argument"},
+	"sqrt":       {vm.OpSqrt, 1, "Sqrt() expects 1 argument"},
+	"abs":        {vm.OpAbs, 1, "Abs() expects 1 argument"},
+	"lerp":       {vm.OpLerp, 3, "Lerp(a, b, t) expects 3 arguments"},
+	"noise":      {vm.OpNoise2D, 2, "Noise(x, y) expects 2 arguments"},
+	"noise2d":    {vm.OpNoise2D, 2, "Noise(x, y) expects 2 arguments"},
+	"perlin":     {vm.OpNoise2D, 2, "Noise(x, y) expects 2 arguments"},
+	"simplex":    {vm.OpNoise2D, 2, "Noise(x, y) expects 2 arguments"},
+	"openfile":   {vm.OpOpenFile, 2, "OpenFile(path, mode) expects 2 arguments; mode 0=read, 1=write, 2=append"},
+	"readline":   {vm.OpReadLine, 1, "ReadLine(handle) expects 1 argument"},
+	"writeline":  {vm.OpWriteLine, 2, "WriteLine(handle, text) expects 2 arguments"},
+	"closefile":  {vm.OpCloseFile, 1, "CloseFile(handle) expects 1 argument"},
+	"readbyte":   {vm.OpReadByte, 1, "ReadByte(handle) expects 1 argument"},
+	"writebyte":  {vm.OpWriteByte, 2, "WriteByte(handle, value) expects 2 arguments"},
+	"floor":      {vm.OpFloor, 1, "Floor() expects 1 argument"},
+	"ceil":       {vm.OpCeil, 1, "Ceil() expects 1 argument"},
+	"round":      {vm.OpRound, 1, "Round() expects 1 argument"},
+	"min":        {vm.OpMin, 2, "Min(a, b) expects 2 arguments"},
+	"max":        {vm.OpMax, 2, "Max(a, b) expects 2 arguments"},
+	"clamp":      {vm.OpClamp, 3, "Clamp(x, lo, hi) expects 3 arguments"},
+	"pow":        {vm.OpPow, 2, "Pow(base, exp) expects 2 arguments"},
+	"exp":        {vm.OpExp, 1, "Exp() expects 1 argument"},
+	"log":        {vm.OpLog, 1, "Log() expects 1 argument"},
+	"log10":      {vm.OpLog10, 1, "Log10() expects 1 argument"},
+	"atan2":      {vm.OpAtan2, 2, "Atan2(y, x) expects 2 arguments"},
+	"sign":       {vm.OpSign, 1, "Sign() expects 1 argument"},
+	"deg2rad":    {vm.OpDeg2Rad, 1, "Deg2Rad() expects 1 argument"},
+	"rad2deg":    {vm.OpRad2Deg, 1, "Rad2Deg() expects 1 argument"},
+	"distance2d": {vm.OpDistance2D, 4, "Distance2D(x1, y1, x2, y2) expects 4 arguments"},
+	"distance3d": {vm.OpDistance3D, 6, "Distance3D(x1, y1, z1, x2, y2, z2) expects 6 arguments"},
+	"distsq2d":   {vm.OpDistSq2D, 4, "DistSq2D(x1, y1, x2, y2) expects 4 arguments"},
+	"distsq3d":   {vm.OpDistSq3D, 6, "DistSq3D(x1, y1, z1, x2, y2, z2) expects 6 arguments"},
+	"inradius2d": {vm.OpInRadius2D, 5, "InRadius2D(x1, y1, x2, y2, radius) expects 5 arguments"},
+	"inradius3d": {vm.OpInRadius3D, 7, "InRadius3D(x1, y1, z1, x2, y2, z2, radius) expects 7 arguments"},
+	"angle2d":    {vm.OpAngle2D, 4, "Angle2D(x1, y1, x2, y2) expects 4 arguments"},
+	"left":       {vm.OpLeftStr, 2, "Left(s, n) expects 2 arguments"},
+	"left$":      {vm.OpLeftStr, 2, "Left(s, n) expects 2 arguments"},
+	"right":      {vm.OpRightStr, 2, "Right(s, n) expects 2 arguments"},
+	"right$":     {vm.OpRightStr, 2, "Right(s, n) expects 2 arguments"},
+	"mid":        {vm.OpMidStr, 3, "Mid(s, start, n) expects 3 arguments; start is 1-based"},
+	"mid$":       {vm.OpMidStr, 3, "Mid(s, start, n) expects 3 arguments; start is 1-based"},
+	"len":        {vm.OpLenStr, 1, "Len(s) expects 1 argument"},
+	"eof":        {vm.OpEOF, 1, "EOF(handle) expects 1 argument"},
+}
+
+// Builtin returns the instruction a call of the builtin name with nargs arguments compiles to. ok is false when
+// name is not a builtin; err is the compile error for a wrong argument count.
+func Builtin(name string, nargs int) (op vm.OpCode, ok bool, err error) {
+	name = strings.ToLower(name)
+	if name == "random" {
+		switch nargs {
+		case 0:
+			return vm.OpRandom, true, nil
+		case 1:
+			return vm.OpRandomN, true, nil
+		}
+		return 0, true, fmt.Errorf("Random() expects 0 or 1 argument")
+	}
+	b, ok := builtins[name]
+	if !ok {
+		return 0, false, nil
+	}
+	if nargs != b.args {
+		return 0, true, fmt.Errorf("%s", b.usage)
+	}
+	return b.op, true, nil
+}
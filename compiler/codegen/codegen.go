@@ -4,18 +4,23 @@ import (
 	"cyberbasic/compiler/parser"
 	"cyberbasic/compiler/semantic"
 	"cyberbasic/compiler/vm"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 )
 
 // eventPatch records a position to patch with the OnEvent handler offset.
 type eventPatch struct {
 	patchPos int
+	wide     bool
 	stmt     *parser.OnEventStatement
 }
 
 // startCoroutinePatch records a position to patch with the sub's bytecode offset after decls are compiled.
 type startCoroutinePatch struct {
 	patchPos int
+	wide     bool
 	subName  string
 }
 
@@ -23,41 +28,98 @@ type startCoroutinePatch struct {
 type Emitter struct {
 	chunk                   *vm.Chunk
 	sem                     *semantic.Result
-	constIndices            map[string]byte
+	constIndices            map[string]int
 	loopExitStack           [][]int
 	loopContinueStack       [][]int
 	funcParamIndices        map[string]int
 	eventPatchList          []eventPatch
 	startCoroutinePatchList []startCoroutinePatch
+	tryStack                []tryScope // TRY scopes enclosing the current emit position (for RETURN/EXIT unwinding)
+	callValueEnd            int        // code offset just after the last OpCallValue (a statement call then pops its result)
+	wideJumps               bool       // emit every jump/target operand in the 4-byte OpWide form
+	err                     error      // first operand-encoding error (sticky; checked after each statement)
 }
 
+// errJumpOverflow means a narrow jump or code target could not be patched; Emit retries with wideJumps.
+var errJumpOverflow = errors.New("jump distance exceeds 16-bit range")
+
 // Emit compiles the program AST into bytecode using the semantic analysis result.
+// Jumps use 16-bit operands; if any jump or handler offset does not fit, the program is re-emitted with 32-bit ones.
 func Emit(program *parser.Program, sem *semantic.Result) (*vm.Chunk, error) {
+	return EmitReload(program, sem, nil)
+}
+
+// EmitReload compiles the program like Emit for VM.Reload into a program running base: variables keep base's
+// slots and constants keep base's indices (new ones are added after them). A nil base is the same as Emit.
+// Reload moves absolute targets past base's code, so they are emitted wide once they could end up beyond 16 bits.
+func EmitReload(program *parser.Program, sem *semantic.Result, base *vm.Chunk) (*vm.Chunk, error) {
+	chunk, err := emitProgram(sem, false, base)
+	if errors.Is(err, errJumpOverflow) || err == nil && base != nil && len(base.Code)+len(chunk.Code) > math.MaxUint16 {
+		chunk, err = emitProgram(sem, true, base)
+	}
+	return chunk, err
+}
+
+func emitProgram(sem *semantic.Result, wideJumps bool, base *vm.Chunk) (*vm.Chunk, error) {
 	chunk := vm.NewChunk()
+	if base != nil {
+		for name, idx := range base.Variables {
+			chunk.Variables[name] = idx
+		}
+		for name, dims := range base.VarDims {
+			chunk.VarDims[name] = dims
+		}
+		chunk.Constants = append(chunk.Constants, base.Constants...)
+	}
 	e := &Emitter{
 		chunk:                   chunk,
 		sem:                     sem,
-		constIndices:            make(map[string]byte),
+		constIndices:            make(map[string]int),
 		eventPatchList:          nil,
 		startCoroutinePatchList: nil,
+		wideJumps:               wideJumps,
 	}
+	e.registerTypes()
 	// Compile main program (no Function/Sub bodies)
 	for _, stmt := range sem.MainStmts {
 		if err := e.compileStatement(stmt); err != nil {
 			return nil, err
 		}
+		if e.err != nil {
+			return nil, errWithLine(stmt, e.err)
+		}
 	}
 	// Jump over all function/sub bodies
-	jumpPos := len(chunk.Code)
-	chunk.Write(byte(vm.OpJump))
-	chunk.WriteJumpOffset(0)
+	jumpPos := e.emitJump(vm.OpJump)
 	// Compile each Sub/Function
 	for _, stmt := range sem.Decls {
 		if err := e.compileDecl(stmt); err != nil {
 			return nil, err
 		}
+		if e.err != nil {
+			return nil, errWithLine(stmt, e.err)
+		}
 	}
-	// Patch StartCoroutine target offsets
+	// Compile event handlers and patch registration offsets
+	for _, ep := range e.eventPatchList {
+		handlerStart := len(chunk.Code)
+		e.funcParamIndices = nil
+		if len(ep.stmt.Params) > 0 {
+			e.funcParamIndices = make(map[string]int)
+			for i, p := range ep.stmt.Params {
+				e.funcParamIndices[strings.ToLower(p)] = i
+			}
+		}
+		for _, stmt := range ep.stmt.Body.Statements {
+			if err := e.compileStatement(stmt); err != nil {
+				return nil, err
+			}
+		}
+		e.funcParamIndices = nil
+		chunk.Write(byte(vm.OpReturn))
+		e.patchTarget(ep.patchPos, handlerStart, ep.wide)
+	}
+	// Patch StartCoroutine and function reference target offsets (handlers may use them too)
 	for _, p := range e.startCoroutinePatchList {
 		target, ok := chunk.GetFunction(p.subName)
 		if !ok {
@@ -71,25 +133,106 @@ func Emit(program *parser.Program, sem *semantic.Result) (*vm.Chunk, error) {
 			}
 			return nil, fmt.Errorf("%s", msg)
 		}
-		chunk.PatchJumpOffset(p.patchPos, target)
-	}
-	// Compile event handlers and patch registration offsets
-	for _, ep := range e.eventPatchList {
-		handlerStart := len(chunk.Code)
-		for _, stmt := range ep.stmt.Body.Statements {
-			if err := e.compileStatement(stmt); err != nil {
-				return nil, err
-			}
-		}
-		chunk.Write(byte(vm.OpReturn))
-		chunk.PatchJumpOffset(ep.patchPos, handlerStart)
+		e.patchTarget(p.patchPos, target, p.wide)
 	}
-	endPos := len(chunk.Code)
-	chunk.PatchJumpOffset(jumpPos+1, endPos-(jumpPos+3))
+	e.patchJump(jumpPos)
+	chunk.SetLine(0) // the final halt belongs to no statement (not the last handler's)
 	chunk.Write(byte(vm.OpHalt))
+	if e.err != nil {
+		return nil, e.err
+	}
 	return chunk, nil
 }
 
+// EmitExpression compiles one expression for a debugger to run with VM.Evaluate while base is stopped.
+// Variables resolve to base's slots, names in params to the current Sub/Function's parameters, and other
+// names to globals and foreign functions. User Subs/Functions cannot be called (their code is in base).
+func EmitExpression(expr parser.Node, base *vm.Chunk, params []string) (*vm.Chunk, error) {
+	chunk := vm.NewChunk()
+	for name, idx := range base.Variables {
+		chunk.Variables[name] = idx
+	}
+	for name, dims := range base.VarDims {
+		chunk.VarDims[name] = dims
+	}
+	for name, members := range base.Enums {
+		chunk.Enums[name] = members
+	}
+	chunk.Types = base.Types
+	e := &Emitter{
+		chunk:        chunk,
+		sem:          &semantic.Result{TypeDefs: map[string]*parser.TypeDecl{}, EntityNames: map[string]bool{}, UserFuncs: map[string]bool{}},
+		constIndices: make(map[string]int),
+	}
+	if len(params) > 0 {
+		e.funcParamIndices = make(map[string]int)
+		for i, p := range params {
+			e.funcParamIndices[strings.ToLower(p)] = i
+		}
+	}
+	if err := e.compileExpression(expr); err != nil {
+		return nil, err
+	}
+	if len(chunk.Variables) != len(base.Variables) {
+		return nil, fmt.Errorf("expression would create variables")
+	}
+	return chunk, e.err
+}
+
+// emit writes op with its operands; OpWide is added automatically for indices above 255.
+func (e *Emitter) emit(op vm.OpCode, operands ...int) {
+	if err := e.chunk.Emit(op, operands...); err != nil && e.err == nil {
+		e.err = err
+	}
+}
+
+// emitJump writes a forward jump op with a placeholder offset and returns the placeholder position for patchJump.
+func (e *Emitter) emitJump(op vm.OpCode) int {
+	pos, _, err := e.chunk.EmitJump(op, e.wideJumps, 0)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return pos
+}
+
+// emitTarget writes an op with an absolute code-offset placeholder (see emitJump). It reports whether the
+// instruction was emitted wide, which patchTarget needs later.
+func (e *Emitter) emitTarget(op vm.OpCode, operands ...int) (int, bool) {
+	pos, wide, err := e.chunk.EmitJump(op, e.wideJumps, operands...)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	return pos, wide
+}
+
+// emitLoop writes a jump op back to the already-emitted loopStart.
+func (e *Emitter) emitLoop(op vm.OpCode, loopStart int) {
+	e.patchJumpTo(e.emitJump(op), loopStart)
+}
+
+// patchJump points the jump whose placeholder is at pos to the current end of code.
+func (e *Emitter) patchJump(pos int) {
+	e.patchJumpTo(pos, len(e.chunk.Code))
+}
+
+// patchJumpTo points the jump whose placeholder is at pos to the absolute code offset target.
+func (e *Emitter) patchJumpTo(pos, target int) {
+	width := 2
+	if e.wideJumps {
+		width = 4
+	}
+	if !e.chunk.PatchJump(pos, target-(pos+width), e.wideJumps) && e.err == nil {
+		e.err = errJumpOverflow
+	}
+}
+
+// patchTarget writes an absolute code offset (event handler, coroutine entry) at pos.
+func (e *Emitter) patchTarget(pos, target int, wide bool) {
+	if !e.chunk.PatchTarget(pos, target, wide) && e.err == nil {
+		e.err = errJumpOverflow
+	}
+}
+
 // compileDecl compiles a single FunctionDecl or SubDecl.
 func (e *Emitter) compileDecl(stmt parser.Node) error {
 	switch node := stmt.(type) {
@@ -53,6 +53,11 @@ var dotObjectRoots = map[string]bool{
 	"game":       true,
 }
 
+// IsDotObjectRoot reports whether name is one of the dotObjectRoots.
+func IsDotObjectRoot(name string) bool {
+	return dotObjectRoots[strings.ToLower(name)]
+}
+
 func collectMemberAccessChain(ma *parser.MemberAccess) ([]string, parser.Node) {
 	var segs []string
 	cur := parser.Node(ma)
@@ -71,16 +76,7 @@ func (e *Emitter) emitOpGetProp(path []string) error {
 	if len(path) < 1 || len(path) > 32 {
 		return fmt.Errorf("invalid property path length")
 	}
-	e.chunk.Write(byte(vm.OpGetProp))
-	e.chunk.Write(byte(len(path)))
-	for _, seg := range path {
-		low := strings.ToLower(seg)
-		ci := e.chunk.WriteConstant(low)
-		if err := checkConstIndex(ci, " for OpGetProp path"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(ci))
-	}
+	e.emit(vm.OpGetProp, e.propPathOperands(path)...)
 	return nil
 }
 
@@ -108,12 +104,7 @@ func (e *Emitter) compileDotMethodCall(call *parser.Call, parts []string) error
 		}
 	}
 	mi := e.chunk.WriteConstant(method)
-	if err := checkConstIndex(mi, " for OpCallMethod"); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpCallMethod))
-	e.chunk.Write(byte(mi))
-	e.chunk.Write(byte(len(call.Arguments)))
+	e.emit(vm.OpCallMethod, mi, len(call.Arguments))
 	return nil
 }
 
@@ -121,15 +112,16 @@ func (e *Emitter) emitOpSetProp(path []string) error {
 	if len(path) < 1 || len(path) > 32 {
 		return fmt.Errorf("invalid property path length")
 	}
-	e.chunk.Write(byte(vm.OpSetProp))
-	e.chunk.Write(byte(len(path)))
+	e.emit(vm.OpSetProp, e.propPathOperands(path)...)
+	return nil
+}
+
+// propPathOperands returns the OpGetProp/OpSetProp operands: segment count, then one constant per lowercased segment.
+func (e *Emitter) propPathOperands(path []string) []int {
+	operands := make([]int, 0, len(path)+1)
+	operands = append(operands, len(path))
 	for _, seg := range path {
-		low := strings.ToLower(seg)
-		ci := e.chunk.WriteConstant(low)
-		if err := checkConstIndex(ci, " for OpSetProp path"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(ci))
+		operands = append(operands, e.chunk.WriteConstant(strings.ToLower(seg)))
 	}
-	return nil
+	return operands
 }
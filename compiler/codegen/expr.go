@@ -39,6 +39,20 @@ func (e *Emitter) compileExpression(expr parser.Node) error {
 		return e.compileInterpolatedString(node)
 	case *parser.DictLiteral:
 		return e.compileDictLiteral(node)
+	case *parser.ListLiteral:
+		return e.compileListLiteral(node)
+	case *parser.MapLiteral:
+		return e.compileMapLiteral(node)
+	case *parser.StartCoroutineStatement:
+		return e.compileStartTask(node)
+	case *parser.AwaitExpression:
+		if err := e.compileExpression(node.Value); err != nil {
+			return err
+		}
+		e.chunk.Write(byte(vm.OpAwait))
+		return nil
+	case *parser.FunctionExpression:
+		return e.compileFunctionExpression(node)
 	default:
 		return errWithLine(expr, fmt.Errorf("unsupported expression type: %T", expr))
 	}
@@ -47,30 +61,18 @@ func (e *Emitter) compileExpression(expr parser.Node) error {
 // compileDictLiteral compiles { k: v, ... } as CreateDict then SetDictKey for each pair.
 func (e *Emitter) compileDictLiteral(node *parser.DictLiteral) error {
 	ci := e.chunk.WriteConstant("createdict")
-	if err := checkConstIndex(ci, ""); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(ci))
-	e.chunk.Write(byte(0))
+	e.emit(vm.OpCallForeign, ci, 0)
 	for i, p := range node.Pairs {
 		if i > 0 {
 			e.chunk.Write(byte(vm.OpDup))
 		}
 		keyIdx := e.chunk.WriteConstant(p.Key)
-		if err := checkConstIndex(keyIdx, " for dict key"); err != nil {
-			return err
-		}
+		e.emit(vm.OpLoadConst, keyIdx)
 		if err := e.compileExpression(p.Value); err != nil {
 			return err
 		}
 		setIdx := e.chunk.WriteConstant("setdictkey")
-		if err := checkConstIndex(setIdx, ""); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallForeign))
-		e.chunk.Write(byte(setIdx))
-		e.chunk.Write(byte(3))
+		e.emit(vm.OpCallForeign, setIdx, 3)
 		if i < len(node.Pairs)-1 {
 			e.chunk.Write(byte(vm.OpPop))
 		}
@@ -78,6 +80,60 @@ func (e *Emitter) compileDictLiteral(node *parser.DictLiteral) error {
 	return nil
 }
 
+// maxCollectionOperand is the most items (or MAP pairs) one OpMakeList/OpMakeMap takes; its count is one byte.
+const maxCollectionOperand = 255
+
+// compileListLiteral compiles [a, b, c] as OpMakeList. Items past the first 255 are appended with .add.
+func (e *Emitter) compileListLiteral(node *parser.ListLiteral) error {
+	first := node.Elements
+	if len(first) > maxCollectionOperand {
+		first = first[:maxCollectionOperand]
+	}
+	for _, el := range first {
+		if err := e.compileExpression(el); err != nil {
+			return err
+		}
+	}
+	e.emit(vm.OpMakeList, len(first))
+	for rest := node.Elements[len(first):]; len(rest) > 0; {
+		n := min(len(rest), maxCollectionOperand)
+		e.chunk.Write(byte(vm.OpDup))
+		for _, el := range rest[:n] {
+			if err := e.compileExpression(el); err != nil {
+				return err
+			}
+		}
+		e.emit(vm.OpCallMethod, e.chunk.WriteConstant("add"), n)
+		rest = rest[n:]
+	}
+	return nil
+}
+
+// compileMapLiteral compiles [k: v, ...] as OpMakeMap. Pairs past the first 255 are stored with OpSetIndex.
+func (e *Emitter) compileMapLiteral(node *parser.MapLiteral) error {
+	n := min(len(node.Keys), maxCollectionOperand)
+	for i := 0; i < n; i++ {
+		if err := e.compileExpression(node.Keys[i]); err != nil {
+			return err
+		}
+		if err := e.compileExpression(node.Values[i]); err != nil {
+			return err
+		}
+	}
+	e.emit(vm.OpMakeMap, n)
+	for i := n; i < len(node.Keys); i++ {
+		e.chunk.Write(byte(vm.OpDup))
+		if err := e.compileExpression(node.Keys[i]); err != nil {
+			return err
+		}
+		if err := e.compileExpression(node.Values[i]); err != nil {
+			return err
+		}
+		e.chunk.Write(byte(vm.OpSetIndex))
+	}
+	return nil
+}
+
 // compileSliceExpr compiles s[start:end], s[i] (strings), or arr[i,j] (multi-dim arrays).
 func (e *Emitter) compileSliceExpr(node *parser.SliceExpr) error {
 	// Multi-dim array access: arr[i,j,k]
@@ -92,8 +148,7 @@ func (e *Emitter) compileSliceExpr(node *parser.SliceExpr) error {
 					}
 				}
 				varIndex, _ := e.chunk.GetVariable(id.Name)
-				e.chunk.Write(byte(vm.OpLoadArray))
-				e.chunk.Write(byte(varIndex))
+				e.emit(vm.OpLoadArray, varIndex)
 				return nil
 			}
 		}
@@ -112,25 +167,16 @@ func (e *Emitter) compileSliceExpr(node *parser.SliceExpr) error {
 					return err
 				}
 				varIndex, _ := e.chunk.GetVariable(id.Name)
-				e.chunk.Write(byte(vm.OpLoadArray))
-				e.chunk.Write(byte(varIndex))
+				e.emit(vm.OpLoadArray, varIndex)
 				return nil
 			}
 		}
-		// Single char s[i]: push i, i+1 (0-based, end exclusive)
+		// LIST item, MAP entry or single char s[i] (0-based)
 		if err := e.compileExpression(node.Start); err != nil {
 			return err
 		}
-		if err := e.compileExpression(node.Start); err != nil {
-			return err
-		}
-		ci := e.chunk.WriteConstant(1)
-		if err := checkConstIndex(ci, ""); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(ci))
-		e.chunk.Write(byte(vm.OpAdd))
+		e.chunk.Write(byte(vm.OpIndex))
+		return nil
 	} else {
 		// Range: s[start:end], s[start:], s[:end], s[:]
 		if node.End == nil && node.Start != nil {
@@ -145,21 +191,13 @@ func (e *Emitter) compileSliceExpr(node *parser.SliceExpr) error {
 			e.chunk.Write(byte(vm.OpDup))
 			e.chunk.Write(byte(vm.OpLenStr))
 			ci := e.chunk.WriteConstant(0)
-			if err := checkConstIndex(ci, ""); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(ci))
+			e.emit(vm.OpLoadConst, ci)
 			e.chunk.Write(byte(vm.OpSwap))
 			e.chunk.Write(byte(vm.OpStrSlice))
 		} else if node.End == nil {
 			// s[:end] - start is nil, end is set. Shouldn't happen with our grammar.
 			ci := e.chunk.WriteConstant(0)
-			if err := checkConstIndex(ci, ""); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(ci))
+			e.emit(vm.OpLoadConst, ci)
 			if err := e.compileExpression(node.End); err != nil {
 				return err
 			}
@@ -171,11 +209,7 @@ func (e *Emitter) compileSliceExpr(node *parser.SliceExpr) error {
 				}
 			} else {
 				ci := e.chunk.WriteConstant(0)
-				if err := checkConstIndex(ci, ""); err != nil {
-					return err
-				}
-				e.chunk.Write(byte(vm.OpLoadConst))
-				e.chunk.Write(byte(ci))
+				e.emit(vm.OpLoadConst, ci)
 			}
 			if err := e.compileExpression(node.End); err != nil {
 				return err
@@ -205,33 +239,19 @@ func (e *Emitter) compileInterpolatedString(node *parser.InterpolatedString) err
 	}
 	if len(node.Parts) == 0 {
 		ci := e.chunk.WriteConstant("")
-		if err := checkConstIndex(ci, ""); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(ci))
+		e.emit(vm.OpLoadConst, ci)
 	}
 	return nil
 }
 
-// compileJSONIndexAccess compiles obj["key"] as GetJSONKey(obj, "key")
+// compileJSONIndexAccess compiles obj["key"] as OpIndex: a MAP entry, a dictionary key, or GetJSONKey on a JSON handle.
 func (e *Emitter) compileJSONIndexAccess(node *parser.JSONIndexAccess) error {
 	if err := e.compileExpression(node.Object); err != nil {
 		return err
 	}
 	keyIdx := e.chunk.WriteConstant(node.Key)
-	if err := checkConstIndex(keyIdx, " for JSON key"); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpLoadConst))
-	e.chunk.Write(byte(keyIdx))
-	nameIdx := e.chunk.WriteConstant("getjsonkey")
-	if err := checkConstIndex(nameIdx, ""); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(nameIdx))
-	e.chunk.Write(byte(2))
+	e.emit(vm.OpLoadConst, keyIdx)
+	e.chunk.Write(byte(vm.OpIndex))
 	return nil
 }
 
@@ -247,53 +267,35 @@ func (e *Emitter) compileMemberAccess(m *parser.MemberAccess) error {
 		if e.sem.EntityNames != nil && e.sem.EntityNames[objLower] && len(segs) == 1 {
 			entityIdx := e.chunk.WriteConstant(objLower)
 			propIdx := e.chunk.WriteConstant(mb)
-			if err := checkConstIndex(entityIdx, " for entity prop"); err != nil {
-				return err
-			}
-			if err := checkConstIndex(propIdx, " for entity prop"); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpLoadEntityProp))
-			e.chunk.Write(byte(entityIdx))
-			e.chunk.Write(byte(propIdx))
+			e.emit(vm.OpLoadEntityProp, entityIdx, propIdx)
 			return nil
 		}
 		if e.sem.TypeDefs != nil {
 			if td, ok := e.sem.TypeDefs[objLower]; ok && len(segs) == 1 {
-				val, err := e.resolveUDTConstantMember(td, mb)
+				val, err := UDTConstant(td, mb)
 				if err == nil {
 					key := objLower + "." + mb
 					if idx, has := e.constIndices[key]; has {
-						e.chunk.Write(byte(vm.OpLoadConst))
-						e.chunk.Write(byte(idx))
+						e.emit(vm.OpLoadConst, idx)
 						return nil
 					}
 					ci := e.chunk.WriteConstant(val)
-					if err := checkConstIndex(ci, ""); err != nil {
-						return err
-					}
-					e.constIndices[key] = byte(ci)
-					e.chunk.Write(byte(vm.OpLoadConst))
-					e.chunk.Write(byte(ci))
+					e.constIndices[key] = ci
+					e.emit(vm.OpLoadConst, ci)
 					return nil
 				}
 			}
 		}
 		if (objLower == "rl" || objLower == "box2d" || objLower == "bullet" || objLower == "game") && len(segs) == 1 && mb != "x" && mb != "y" && mb != "z" {
 			idx := e.chunk.WriteConstant(mb)
-			if err := checkConstIndex(idx, " for foreign constant"); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpCallForeign))
-			e.chunk.Write(byte(idx))
-			e.chunk.Write(byte(0))
+			e.emit(vm.OpCallForeign, idx, 0)
 			return nil
 		}
 	}
 
 	// DotObject roots (window, physics, …): never treat .x/.y/.z as vector swizzle on the namespace itself.
 	if id, ok := base.(*parser.Identifier); ok {
-		if dotObjectRoots[strings.ToLower(id.Name)] {
+		if IsDotObjectRoot(id.Name) {
 			if err := e.compileIdentifier(id); err != nil {
 				return err
 			}
@@ -301,31 +303,7 @@ func (e *Emitter) compileMemberAccess(m *parser.MemberAccess) error {
 		}
 	}
 
-	// Vector components: single segment x/y/z on any expression (e.g. pos.x, GetMousePosition().x)
-	if len(segs) == 1 && (mb == "x" || mb == "y" || mb == "z") {
-		if err := e.compileExpression(base); err != nil {
-			return err
-		}
-		var name string
-		switch mb {
-		case "x":
-			name = "getvector2x"
-		case "y":
-			name = "getvector2y"
-		case "z":
-			name = "getvector3z"
-		}
-		idx := e.chunk.WriteConstant(name)
-		if err := checkConstIndex(idx, " for member getter"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallForeign))
-		e.chunk.Write(byte(idx))
-		e.chunk.Write(byte(1))
-		return nil
-	}
-
-	// DotObject property path (WINDOW.*, nested handles, VAR.prop...)
+	// DotObject property path (WINDOW.*, nested handles, VAR.prop...); OpGetProp also reads .x/.y/.z of vectors.
 	if bid, ok := base.(*parser.Identifier); ok {
 		if err := e.compileIdentifier(bid); err != nil {
 			return err
@@ -340,50 +318,48 @@ func (e *Emitter) compileMemberAccess(m *parser.MemberAccess) error {
 
 // compileNumber compiles a number literal
 func (e *Emitter) compileNumber(num *parser.Number) error {
-	if strings.Contains(num.Value, ".") {
-		if floatVal, err := parseFloat(num.Value); err == nil {
-			constIndex := e.chunk.WriteConstant(floatVal)
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(constIndex))
-			return nil
+	val, err := NumberValue(num.Value)
+	if err != nil {
+		return err
+	}
+	e.emit(vm.OpLoadConst, e.chunk.WriteConstant(val))
+	return nil
+}
+
+// NumberValue returns the value of the number literal lit: a float64 when it has a decimal point, else an int.
+func NumberValue(lit string) (interface{}, error) {
+	if strings.Contains(lit, ".") {
+		if floatVal, err := parseFloat(lit); err == nil {
+			return floatVal, nil
 		}
 	}
-	if intVal, err := parseInt(num.Value); err == nil {
-		constIndex := e.chunk.WriteConstant(intVal)
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(constIndex))
-		return nil
+	if intVal, err := parseInt(lit); err == nil {
+		return intVal, nil
 	}
-	if floatVal, err := parseFloat(num.Value); err == nil {
-		constIndex := e.chunk.WriteConstant(floatVal)
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(constIndex))
-		return nil
+	if floatVal, err := parseFloat(lit); err == nil {
+		return floatVal, nil
 	}
-	return fmt.Errorf("invalid number format: %s", num.Value)
+	return nil, fmt.Errorf("invalid number format: %s", lit)
 }
 
 // compileString compiles a string literal
 func (e *Emitter) compileString(str *parser.StringLiteral) error {
 	constIndex := e.chunk.WriteConstant(str.Value)
-	e.chunk.Write(byte(vm.OpLoadString))
-	e.chunk.Write(byte(constIndex))
+	e.emit(vm.OpLoadString, constIndex)
 	return nil
 }
 
 // compileBoolean compiles a boolean literal
 func (e *Emitter) compileBoolean(bool *parser.Boolean) error {
 	constIndex := e.chunk.WriteConstant(bool.Value)
-	e.chunk.Write(byte(vm.OpLoadConst))
-	e.chunk.Write(byte(constIndex))
+	e.emit(vm.OpLoadConst, constIndex)
 	return nil
 }
 
 // compileNilLiteral compiles the null/nil literal (pushes nil onto the stack)
 func (e *Emitter) compileNilLiteral() error {
 	constIndex := e.chunk.WriteConstant(nil)
-	e.chunk.Write(byte(vm.OpLoadConst))
-	e.chunk.Write(byte(constIndex))
+	e.emit(vm.OpLoadConst, constIndex)
 	return nil
 }
 
@@ -391,52 +367,49 @@ func (e *Emitter) compileNilLiteral() error {
 func (e *Emitter) compileIdentifier(ident *parser.Identifier) error {
 	if e.funcParamIndices != nil {
 		if idx, ok := e.funcParamIndices[strings.ToLower(ident.Name)]; ok {
-			e.chunk.Write(byte(vm.OpLoadParam))
-			e.chunk.Write(byte(idx))
+			e.emit(vm.OpLoadParam, idx)
 			return nil
 		}
 	}
 	if varIndex, exists := e.chunk.GetVariable(ident.Name); exists {
-		e.chunk.Write(byte(vm.OpLoadVar))
-		e.chunk.Write(byte(varIndex))
+		e.emit(vm.OpLoadVar, varIndex)
 		return nil
 	}
 	if e.constIndices != nil {
 		if idx, ok := e.constIndices[strings.ToLower(ident.Name)]; ok {
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(idx))
+			e.emit(vm.OpLoadConst, idx)
 			return nil
 		}
 	}
 	nameLower := strings.ToLower(ident.Name)
-	if strings.HasPrefix(nameLower, "rl.") || strings.HasPrefix(nameLower, "box2d.") || strings.HasPrefix(nameLower, "bullet.") || strings.HasPrefix(nameLower, "game.") {
-		nameConst := nameLower
-		if flat := physicsNamespaceToFlat(nameConst); flat != "" {
-			nameConst = flat
-		} else if strings.HasPrefix(nameConst, "rl.") {
-			nameConst = nameConst[3:]
-		} else if strings.HasPrefix(nameConst, "box2d.") {
-			nameConst = nameConst[6:]
-		} else if strings.HasPrefix(nameConst, "bullet.") {
-			nameConst = nameConst[7:]
-		} else if strings.HasPrefix(nameConst, "game.") {
-			nameConst = nameConst[5:]
-		}
-		idx := e.chunk.WriteConstant(nameConst)
-		if err := checkConstIndex(idx, " for foreign constant"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallForeign))
-		e.chunk.Write(byte(idx))
-		e.chunk.Write(byte(0))
+	if e.sem.UserFuncs[nameLower] {
+		e.compileFunctionRef(nameLower)
+		return nil
+	}
+	if name, ok := NamespaceConstant(nameLower); ok {
+		idx := e.chunk.WriteConstant(name)
+		e.emit(vm.OpCallForeign, idx, 0)
 		return nil
 	}
 	constIndex := e.chunk.WriteConstant(ident.Name)
-	e.chunk.Write(byte(vm.OpLoadGlobal))
-	e.chunk.Write(byte(constIndex))
+	e.emit(vm.OpLoadGlobal, constIndex)
 	return nil
 }
 
+// NamespaceConstant returns the foreign function a qualified constant such as RL.DarkGray or BOX2D.GetBodyCount
+// (lowercase) is read with, called without arguments.
+func NamespaceConstant(nameLower string) (string, bool) {
+	for _, prefix := range []string{"rl.", "box2d.", "bullet.", "game."} {
+		if strings.HasPrefix(nameLower, prefix) {
+			if flat := PhysicsFlatName(nameLower); flat != "" {
+				return flat, true
+			}
+			return nameLower[len(prefix):], true
+		}
+	}
+	return "", false
+}
+
 // compileBinaryOp compiles a binary operation
 func (e *Emitter) compileBinaryOp(op *parser.BinaryOp) error {
 	if err := e.compileExpression(op.Left); err != nil {
@@ -445,46 +418,27 @@ func (e *Emitter) compileBinaryOp(op *parser.BinaryOp) error {
 	if err := e.compileExpression(op.Right); err != nil {
 		return err
 	}
-	opCanon := strings.ToLower(op.Operator)
-	switch opCanon {
-	case "+":
-		e.chunk.Write(byte(vm.OpAdd))
-	case "-":
-		e.chunk.Write(byte(vm.OpSub))
-	case "*":
-		e.chunk.Write(byte(vm.OpMul))
-	case "/":
-		e.chunk.Write(byte(vm.OpDiv))
-	case "%":
-		e.chunk.Write(byte(vm.OpMod))
-	case "^":
-		e.chunk.Write(byte(vm.OpPower))
-	case "\\":
-		e.chunk.Write(byte(vm.OpIntDiv))
-	case "=", "==":
-		e.chunk.Write(byte(vm.OpEqual))
-	case "<>":
-		e.chunk.Write(byte(vm.OpNotEqual))
-	case "<":
-		e.chunk.Write(byte(vm.OpLess))
-	case "<=":
-		e.chunk.Write(byte(vm.OpLessEqual))
-	case ">":
-		e.chunk.Write(byte(vm.OpGreater))
-	case ">=":
-		e.chunk.Write(byte(vm.OpGreaterEqual))
-	case "and":
-		e.chunk.Write(byte(vm.OpAnd))
-	case "or":
-		e.chunk.Write(byte(vm.OpOr))
-	case "xor":
-		e.chunk.Write(byte(vm.OpXor))
-	default:
+	code, ok := binaryOps[strings.ToLower(op.Operator)]
+	if !ok {
 		return errWithLine(op, fmt.Errorf("unsupported binary operator: %s", op.Operator))
 	}
+	e.chunk.Write(byte(code))
 	return nil
 }
 
+// binaryOps maps each binary operator (lowercase) to its instruction. AND and OR evaluate both operands.
+var binaryOps = map[string]vm.OpCode{
+	"+": vm.OpAdd, "-": vm.OpSub, "*": vm.OpMul, "/": vm.OpDiv, "%": vm.OpMod, "^": vm.OpPower, "\\": vm.OpIntDiv,
+	"=": vm.OpEqual, "==": vm.OpEqual, "<>": vm.OpNotEqual, "<": vm.OpLess, "<=": vm.OpLessEqual,
+	">": vm.OpGreater, ">=": vm.OpGreaterEqual, "and": vm.OpAnd, "or": vm.OpOr, "xor": vm.OpXor,
+}
+
+// BinaryOp returns the instruction of the binary operator op.
+func BinaryOp(op string) (vm.OpCode, bool) {
+	code, ok := binaryOps[strings.ToLower(op)]
+	return code, ok
+}
+
 // compileUnaryOp compiles a unary operation
 func (e *Emitter) compileUnaryOp(op *parser.UnaryOp) error {
 	if err := e.compileExpression(op.Operand); err != nil {
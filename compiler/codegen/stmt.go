@@ -20,11 +20,19 @@ func (e *Emitter) compileStatement(stmt parser.Node) error {
 	case *parser.CompoundAssign:
 		return e.compileCompoundAssign(node)
 	case *parser.Call:
-		return e.compileCall(node)
+		if err := e.compileCall(node); err != nil {
+			return err
+		}
+		if e.callValueEnd == len(e.chunk.Code) {
+			e.chunk.Write(byte(vm.OpPop)) // a call through a function value always leaves a result
+		}
+		return nil
 	case *parser.IfStatement:
 		return e.compileIfStatement(node)
 	case *parser.ForStatement:
 		return e.compileForStatement(node)
+	case *parser.ForEachStatement:
+		return e.compileForEachStatement(node)
 	case *parser.WhileStatement:
 		return e.compileWhileStatement(node)
 	case *parser.MainLoopStatement:
@@ -67,6 +75,12 @@ func (e *Emitter) compileStatement(stmt parser.Node) error {
 		return e.compileOnEventStatement(node)
 	case *parser.StartCoroutineStatement:
 		return e.compileStartCoroutineStatement(node)
+	case *parser.AwaitExpression:
+		if err := e.compileExpression(node); err != nil {
+			return err
+		}
+		e.chunk.Write(byte(vm.OpPop))
+		return nil
 	case *parser.YieldStatement:
 		e.chunk.Write(byte(vm.OpYield))
 		return nil
@@ -80,12 +94,7 @@ func (e *Emitter) compileStatement(stmt parser.Node) error {
 		if err := e.compileExpression(node.Frames); err != nil {
 			return err
 		}
-		e.chunk.Write(byte(vm.OpLoadConst))
-		ci := e.chunk.WriteConstant(60.0)
-		if err := checkConstIndex(ci, " for WaitFrames divisor"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(ci))
+		e.emit(vm.OpLoadConst, e.chunk.WriteConstant(60.0))
 		e.chunk.Write(byte(vm.OpDiv))
 		e.chunk.Write(byte(vm.OpWaitSeconds))
 		return nil
@@ -98,6 +107,14 @@ func (e *Emitter) compileStatement(stmt parser.Node) error {
 		return nil
 	case *parser.GosubStatement:
 		return e.compileGosubStatement(node)
+	case *parser.TryStatement:
+		return e.compileTryStatement(node)
+	case *parser.TestStatement:
+		return e.compileBlock(node.Lowered())
+	case *parser.ThrowStatement:
+		return e.compileThrowStatement(node)
+	case *parser.EmitStatement:
+		return e.compileEmitStatement(node)
 	default:
 		return errWithLine(stmt, fmt.Errorf("unsupported statement type: %T", stmt))
 	}
@@ -106,41 +123,23 @@ func (e *Emitter) compileStatement(stmt parser.Node) error {
 // compileEntityDecl emits CreateDict, SetDictKey for each property, then StoreGlobal(entityName).
 func (e *Emitter) compileEntityDecl(ed *parser.EntityDecl) error {
 	ci := e.chunk.WriteConstant("createdict")
-	if err := checkConstIndex(ci, ""); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(ci))
-	e.chunk.Write(byte(0))
+	e.emit(vm.OpCallForeign, ci, 0)
 	entityLower := strings.ToLower(ed.Name)
 	for i, p := range ed.Properties {
 		if i > 0 {
 			e.chunk.Write(byte(vm.OpDup))
 		}
 		keyIdx := e.chunk.WriteConstant(p.Name)
-		if err := checkConstIndex(keyIdx, " for entity property key"); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(keyIdx))
+		e.emit(vm.OpLoadConst, keyIdx)
 		if err := e.compileExpression(p.Value); err != nil {
 			return err
 		}
 		setIdx := e.chunk.WriteConstant("setdictkey")
-		if err := checkConstIndex(setIdx, ""); err != nil {
-			return err
-		}
-		e.chunk.Write(byte(vm.OpCallForeign))
-		e.chunk.Write(byte(setIdx))
-		e.chunk.Write(byte(3))
+		e.emit(vm.OpCallForeign, setIdx, 3)
 		// SetDictKey returns the dict; keep it on stack for next property or StoreGlobal
 	}
 	globalIdx := e.chunk.WriteConstant(entityLower)
-	if err := checkConstIndex(globalIdx, " for entity global"); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpStoreGlobal))
-	e.chunk.Write(byte(globalIdx))
+	e.emit(vm.OpStoreGlobal, globalIdx)
 	return nil
 }
 
@@ -157,15 +156,7 @@ func (e *Emitter) compileAssignment(assign *parser.Assignment) error {
 			}
 			entityIdx := e.chunk.WriteConstant(entityLower)
 			propIdx := e.chunk.WriteConstant(propName)
-			if err := checkConstIndex(entityIdx, " for entity prop"); err != nil {
-				return err
-			}
-			if err := checkConstIndex(propIdx, " for entity prop"); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(vm.OpStoreEntityProp))
-			e.chunk.Write(byte(entityIdx))
-			e.chunk.Write(byte(propIdx))
+			e.emit(vm.OpStoreEntityProp, entityIdx, propIdx)
 			return nil
 		}
 	}
@@ -178,18 +169,25 @@ func (e *Emitter) compileAssignment(assign *parser.Assignment) error {
 			path := parts[1:]
 			lowerBase := strings.ToLower(baseName)
 			if lowerBase != "rl" && lowerBase != "box2d" && lowerBase != "bullet" && lowerBase != "game" {
-				if err := e.compileExpression(assign.Value); err != nil {
-					return err
-				}
+				// OpSetProp pops the value, then the object.
 				ident := &parser.Identifier{Name: baseName, Line: assign.Line, Col: assign.Col}
 				if err := e.compileIdentifier(ident); err != nil {
 					return err
 				}
+				if err := e.compileExpression(assign.Value); err != nil {
+					return err
+				}
 				return e.emitOpSetProp(path)
 			}
 		}
 	}
 
+	if len(assign.Indices) == 1 {
+		if _, isArray := e.chunk.GetVarDims(assign.Variable); !isArray {
+			return e.compileIndexAssignment(assign)
+		}
+	}
+
 	err := e.compileExpression(assign.Value)
 	if err != nil {
 		return err
@@ -205,26 +203,44 @@ func (e *Emitter) compileAssignment(assign *parser.Assignment) error {
 		if !exists {
 			return errWithLine(assign, fmt.Errorf("array variable not declared: %s", assign.Variable))
 		}
-		e.chunk.Write(byte(vm.OpStoreArray))
-		e.chunk.Write(byte(varIndex))
+		e.emit(vm.OpStoreArray, varIndex)
 		return nil
 	}
 
 	if e.funcParamIndices != nil {
 		if idx, ok := e.funcParamIndices[strings.ToLower(assign.Variable)]; ok {
-			e.chunk.Write(byte(vm.OpStoreParam))
-			e.chunk.Write(byte(idx))
+			e.emit(vm.OpStoreParam, idx)
 			return nil
 		}
 	}
 	if varIndex, exists := e.chunk.GetVariable(assign.Variable); exists {
-		e.chunk.Write(byte(vm.OpStoreVar))
-		e.chunk.Write(byte(varIndex))
+		e.emit(vm.OpStoreVar, varIndex)
 	} else {
 		varIndex := e.chunk.AddVariable(assign.Variable)
-		e.chunk.Write(byte(vm.OpStoreVar))
-		e.chunk.Write(byte(varIndex))
+		e.emit(vm.OpStoreVar, varIndex)
+	}
+	return nil
+}
+
+// compileIndexAssignment compiles coll[i] = value for a LIST, MAP or dictionary (anything not DIM'd as an array):
+// the collection, the index and the value, then OpSetIndex. coll may be a field path (player.items[0] = 1).
+func (e *Emitter) compileIndexAssignment(assign *parser.Assignment) error {
+	parts := strings.Split(assign.Variable, ".")
+	if err := e.compileIdentifier(&parser.Identifier{Name: parts[0], Line: assign.Line, Col: assign.Col}); err != nil {
+		return err
+	}
+	if len(parts) > 1 {
+		if err := e.emitOpGetProp(parts[1:]); err != nil {
+			return err
+		}
+	}
+	if err := e.compileExpression(assign.Indices[0]); err != nil {
+		return err
+	}
+	if err := e.compileExpression(assign.Value); err != nil {
+		return err
 	}
+	e.chunk.Write(byte(vm.OpSetIndex))
 	return nil
 }
 
@@ -233,8 +249,7 @@ func (e *Emitter) compileCompoundAssign(ca *parser.CompoundAssign) error {
 	var varIndex int
 	if e.funcParamIndices != nil {
 		if idx, ok := e.funcParamIndices[strings.ToLower(ca.Variable)]; ok {
-			e.chunk.Write(byte(vm.OpLoadParam))
-			e.chunk.Write(byte(idx))
+			e.emit(vm.OpLoadParam, idx)
 			if err := e.compileExpression(ca.Value); err != nil {
 				return err
 			}
@@ -250,8 +265,7 @@ func (e *Emitter) compileCompoundAssign(ca *parser.CompoundAssign) error {
 			default:
 				return errWithLine(ca, fmt.Errorf("unsupported compound assign op: %s", ca.Op))
 			}
-			e.chunk.Write(byte(vm.OpStoreParam))
-			e.chunk.Write(byte(idx))
+			e.emit(vm.OpStoreParam, idx)
 			return nil
 		}
 	}
@@ -261,8 +275,7 @@ func (e *Emitter) compileCompoundAssign(ca *parser.CompoundAssign) error {
 		varIndex = e.chunk.AddVariable(ca.Variable)
 	}
 	// Load current value
-	e.chunk.Write(byte(vm.OpLoadVar))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpLoadVar, varIndex)
 	// Load RHS
 	if err := e.compileExpression(ca.Value); err != nil {
 		return err
@@ -279,8 +292,7 @@ func (e *Emitter) compileCompoundAssign(ca *parser.CompoundAssign) error {
 	default:
 		return errWithLine(ca, fmt.Errorf("unsupported compound assign op: %s", ca.Op))
 	}
-	e.chunk.Write(byte(vm.OpStoreVar))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpStoreVar, varIndex)
 	return nil
 }
 
@@ -296,10 +308,7 @@ func (e *Emitter) compileSelectCaseStatement(s *parser.SelectCaseStatement) erro
 			return err
 		}
 		e.chunk.Write(byte(vm.OpEqual))
-		e.chunk.Write(byte(vm.OpJumpIfFalse))
-		e.chunk.Write(byte(0))
-		e.chunk.Write(byte(0))
-		skipPos := len(e.chunk.Code) - 2
+		skipPos := e.emitJump(vm.OpJumpIfFalse)
 		e.chunk.Write(byte(vm.OpPop))
 		e.chunk.Write(byte(vm.OpPop))
 		for _, stmt := range k.Block.Statements {
@@ -307,12 +316,9 @@ func (e *Emitter) compileSelectCaseStatement(s *parser.SelectCaseStatement) erro
 				return err
 			}
 		}
-		e.chunk.Write(byte(vm.OpJump))
-		e.chunk.Write(byte(0))
-		e.chunk.Write(byte(0))
-		endJumpPositions = append(endJumpPositions, len(e.chunk.Code)-2)
+		endJumpPositions = append(endJumpPositions, e.emitJump(vm.OpJump))
 		// Patch skip to here
-		e.chunk.PatchJumpOffset(skipPos, len(e.chunk.Code)-skipPos-2)
+		e.patchJump(skipPos)
 	}
 	if s.ElseBlock != nil {
 		e.chunk.Write(byte(vm.OpPop)) // drop the SELECT expr
@@ -326,7 +332,7 @@ func (e *Emitter) compileSelectCaseStatement(s *parser.SelectCaseStatement) erro
 	}
 	endTarget := len(e.chunk.Code)
 	for _, pos := range endJumpPositions {
-		e.chunk.PatchJumpOffset(pos, endTarget-pos-2)
+		e.patchJumpTo(pos, endTarget)
 	}
 	return nil
 }
@@ -336,10 +342,10 @@ func (e *Emitter) compileExitLoopStatement(ex *parser.ExitLoopStatement) error {
 	if len(e.loopExitStack) == 0 {
 		return errWithLine(ex, fmt.Errorf("EXIT/BREAK %s outside loop", ex.Kind))
 	}
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	e.loopExitStack[len(e.loopExitStack)-1] = append(e.loopExitStack[len(e.loopExitStack)-1], len(e.chunk.Code)-2)
+	if err := e.leaveTryScopes(e.loopTryDepth(), false); err != nil {
+		return err
+	}
+	e.loopExitStack[len(e.loopExitStack)-1] = append(e.loopExitStack[len(e.loopExitStack)-1], e.emitJump(vm.OpJump))
 	return nil
 }
 
@@ -348,10 +354,10 @@ func (e *Emitter) compileContinueLoopStatement(cl *parser.ContinueLoopStatement)
 	if len(e.loopContinueStack) == 0 {
 		return errWithLine(cl, fmt.Errorf("CONTINUE %s outside loop", cl.Kind))
 	}
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	e.loopContinueStack[len(e.loopContinueStack)-1] = append(e.loopContinueStack[len(e.loopContinueStack)-1], len(e.chunk.Code)-2)
+	if err := e.leaveTryScopes(e.loopTryDepth(), false); err != nil {
+		return err
+	}
+	e.loopContinueStack[len(e.loopContinueStack)-1] = append(e.loopContinueStack[len(e.loopContinueStack)-1], e.emitJump(vm.OpJump))
 	return nil
 }
 
@@ -366,72 +372,23 @@ func (e *Emitter) compileAssertStatement(a *parser.AssertStatement) error {
 		}
 	} else {
 		idx := e.chunk.WriteConstant("assertion failed")
-		e.chunk.Write(byte(vm.OpLoadConst))
-		e.chunk.Write(byte(idx))
+		e.emit(vm.OpLoadConst, idx)
 	}
 	idx := e.chunk.WriteConstant("assert")
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(2))
+	e.emit(vm.OpCallForeign, idx, 2)
 	return nil
 }
 
 // compileRepeatStatement compiles REPEAT ... UNTIL condition (jump back when condition false)
 func (e *Emitter) compileRepeatStatement(r *parser.RepeatStatement) error {
 	loopStart := len(e.chunk.Code)
-	wrapFrame := isGameLoopCondition(r.Condition, true)
-	hybridMode := wrapFrame && (e.sem.UserFuncs["update"] || e.sem.UserFuncs["draw"])
-	if hybridMode {
-		wrapFrame = false
-	} else if wrapFrame && r.Body != nil && (e.bodyCallsUserSub(r.Body.Statements) || bodyContainsFrameBoundaries(r.Body.Statements)) {
-		wrapFrame = false
-	}
-	use3D := wrapFrame && r.Body != nil && bodyContains3DDraw(r.Body.Statements)
-	if hybridMode {
-		e.emitHybridLoopBody()
-	} else {
-		if wrapFrame {
-			e.emitFrameWrap( "BeginDrawing")
-			if use3D {
-				e.emitFrameWrap( "BeginMode3D")
-			} else {
-				e.emitFrameWrap( "BeginMode2D")
-			}
-		}
-		for _, stmt := range r.Body.Statements {
-			// Emit EndMode2D/EndMode3D right before SYNC so 2D/3D content is flushed before EndDrawing.
-			if wrapFrame && bodyContainsSync(r.Body.Statements) {
-				n := unwrapStatement(stmt)
-				if gc, ok := n.(*parser.GameCommand); ok && strings.ToLower(gc.Command) == "sync" {
-					if use3D {
-						e.emitFrameWrap( "EndMode3D")
-					} else {
-						e.emitFrameWrap( "EndMode2D")
-					}
-				}
-			}
-			if err := e.compileStatement(stmt); err != nil {
-				return err
-			}
-		}
-		if wrapFrame && !bodyContainsSync(r.Body.Statements) {
-			// When body contains SYNC, omit EndDrawing; SYNC does it.
-			if use3D {
-				e.emitFrameWrap( "EndMode3D")
-			} else {
-				e.emitFrameWrap( "EndMode2D")
-			}
-			e.emitFrameWrap( "EndDrawing")
-		}
+	if err := e.compileFramedBody(GameLoopFrame(r.Condition, true, r.Body, e.sem.UserFuncs), r.Body.Statements); err != nil {
+		return err
 	}
 	if err := e.compileExpression(r.Condition); err != nil {
 		return err
 	}
-	e.chunk.Write(byte(vm.OpJumpIfFalse))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	offset := loopStart - len(e.chunk.Code)
-	e.chunk.PatchJumpOffset(len(e.chunk.Code)-2, offset)
+	e.emitLoop(vm.OpJumpIfFalse, loopStart)
 	return nil
 }
 
@@ -444,10 +401,7 @@ func (e *Emitter) compileIfStatement(ifStmt *parser.IfStatement) error {
 	}
 
 	// Emit jump if false (skip then block; go to first ELSEIF or ELSE or end)
-	e.chunk.Write(byte(vm.OpJumpIfFalse))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	jumpPos := len(e.chunk.Code) - 2
+	jumpPos := e.emitJump(vm.OpJumpIfFalse)
 
 	// Compile then block
 	for _, stmt := range ifStmt.ThenBlock.Statements {
@@ -458,13 +412,10 @@ func (e *Emitter) compileIfStatement(ifStmt *parser.IfStatement) error {
 	}
 
 	// Jump over all ELSEIF/ELSE to end (patched later)
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	endJumpPos := len(e.chunk.Code) - 2
+	endJumpPos := e.emitJump(vm.OpJump)
 
 	// Patch first "jump if false" to here (start of first ELSEIF or ELSE or after end)
-	e.chunk.PatchJumpOffset(jumpPos, len(e.chunk.Code)-jumpPos-2)
+	e.patchJump(jumpPos)
 
 	var endJumpPositions []int
 	endJumpPositions = append(endJumpPositions, endJumpPos)
@@ -475,10 +426,7 @@ func (e *Emitter) compileIfStatement(ifStmt *parser.IfStatement) error {
 		if err != nil {
 			return err
 		}
-		e.chunk.Write(byte(vm.OpJumpIfFalse))
-		e.chunk.Write(byte(0))
-		e.chunk.Write(byte(0))
-		elseIfJumpPos := len(e.chunk.Code) - 2
+		elseIfJumpPos := e.emitJump(vm.OpJumpIfFalse)
 
 		for _, stmt := range branch.Block.Statements {
 			err = e.compileStatement(stmt)
@@ -486,12 +434,9 @@ func (e *Emitter) compileIfStatement(ifStmt *parser.IfStatement) error {
 				return err
 			}
 		}
-		e.chunk.Write(byte(vm.OpJump))
-		e.chunk.Write(byte(0))
-		e.chunk.Write(byte(0))
-		elseIfEndPos := len(e.chunk.Code) - 2
+		elseIfEndPos := e.emitJump(vm.OpJump)
 		endJumpPositions = append(endJumpPositions, elseIfEndPos)
-		e.chunk.PatchJumpOffset(elseIfJumpPos, len(e.chunk.Code)-elseIfJumpPos-2)
+		e.patchJump(elseIfJumpPos)
 	}
 
 	// Optional ELSE block
@@ -506,7 +451,7 @@ func (e *Emitter) compileIfStatement(ifStmt *parser.IfStatement) error {
 
 	// Patch all "jump to end" offsets (after then block and after each ELSEIF block)
 	for _, pos := range endJumpPositions {
-		e.chunk.PatchJumpOffset(pos, len(e.chunk.Code)-pos-2)
+		e.patchJump(pos)
 	}
 
 	return nil
@@ -523,8 +468,7 @@ func (e *Emitter) compileForStatement(forStmt *parser.ForStatement) error {
 	}
 
 	varIndex := e.chunk.AddVariable(forStmt.Variable)
-	e.chunk.Write(byte(vm.OpStoreVar))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpStoreVar, varIndex)
 
 	// Loop start
 	loopStart := len(e.chunk.Code)
@@ -541,10 +485,7 @@ func (e *Emitter) compileForStatement(forStmt *parser.ForStatement) error {
 	}
 
 	e.chunk.Write(byte(vm.OpGreater))
-	e.chunk.Write(byte(vm.OpJumpIfTrue))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	exitJumpPos := len(e.chunk.Code) - 2
+	exitJumpPos := e.emitJump(vm.OpJumpIfTrue)
 
 	// Compile loop body
 	for _, stmt := range forStmt.Body.Statements {
@@ -574,60 +515,169 @@ func (e *Emitter) compileForStatement(forStmt *parser.ForStatement) error {
 	}
 
 	e.chunk.Write(byte(vm.OpAdd))
-	e.chunk.Write(byte(vm.OpStoreVar))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpStoreVar, varIndex)
 
 	// Jump back to loop start (2-byte offset for large loop bodies)
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	e.chunk.PatchJumpOffset(len(e.chunk.Code)-2, loopStart-len(e.chunk.Code))
+	e.emitLoop(vm.OpJump, loopStart)
 
 	// Fix exit jump offset
-	e.chunk.PatchJumpOffset(exitJumpPos, len(e.chunk.Code)-exitJumpPos-2)
+	e.patchJump(exitJumpPos)
 	// Patch EXIT FOR jumps
 	for _, pos := range e.loopExitStack[len(e.loopExitStack)-1] {
-		e.chunk.PatchJumpOffset(pos, len(e.chunk.Code)-pos-2)
+		e.patchJump(pos)
 	}
 	e.loopExitStack = e.loopExitStack[:len(e.loopExitStack)-1]
 	// Patch CONTINUE FOR jumps to increment
 	for _, pos := range e.loopContinueStack[len(e.loopContinueStack)-1] {
-		e.chunk.PatchJumpOffset(pos, continueTargetIP-pos-2)
+		e.patchJumpTo(pos, continueTargetIP)
 	}
 	e.loopContinueStack = e.loopContinueStack[:len(e.loopContinueStack)-1]
 
 	return nil
 }
 
-// bodyCallsUserSub returns true if the given statements (or any nested block) contain a call to a user-defined SUB or FUNCTION.
-// When true, we skip automatic BeginDrawing/EndDrawing so the user's own Draw() (or similar) is not double-wrapped and flicker is avoided.
-func (e *Emitter) bodyCallsUserSub(statements []parser.Node) bool {
-	return WalkStatements(statements, func(n parser.Node) bool {
+// compileForEachStatement compiles FOR EACH [key,] item IN coll ... NEXT. The iterator (a snapshot of coll) lives
+// in a hidden variable; each pass OpIterNext pushes key, value and true, or false when the loop is done.
+func (e *Emitter) compileForEachStatement(fe *parser.ForEachStatement) error {
+	vars := 1
+	if fe.Key != "" {
+		vars = 2
+	}
+	// Variables get their stack slot when first touched; give the loop variables theirs now, while no temporaries
+	// sit on the stack, since OpIterNext leaves the key below the value being stored.
+	for _, name := range []string{fe.Variable, fe.Key} {
+		if _, isParam := e.funcParamIndices[strings.ToLower(name)]; name == "" || isParam {
+			continue
+		}
+		e.emit(vm.OpLoadVar, e.chunk.AddVariable(name))
+		e.chunk.Write(byte(vm.OpPop))
+	}
+	if err := e.compileExpression(fe.Collection); err != nil {
+		return err
+	}
+	e.emit(vm.OpIterNew, vars)
+	iter := e.chunk.AddVariable(fmt.Sprintf("__each%d", len(e.chunk.Code)))
+	e.emit(vm.OpStoreVar, iter)
+	e.loopExitStack = append(e.loopExitStack, nil)
+	e.loopContinueStack = append(e.loopContinueStack, nil)
+
+	loopStart := len(e.chunk.Code)
+	e.emit(vm.OpLoadVar, iter)
+	e.chunk.Write(byte(vm.OpIterNext))
+	exitJumpPos := e.emitJump(vm.OpJumpIfFalse)
+	e.emitStoreName(fe.Variable)
+	if fe.Key != "" {
+		e.emitStoreName(fe.Key)
+	} else {
+		e.chunk.Write(byte(vm.OpPop))
+	}
+	if err := e.compileBlock(fe.Body); err != nil {
+		return err
+	}
+	e.emitLoop(vm.OpJump, loopStart)
+
+	e.patchJump(exitJumpPos)
+	for _, pos := range e.loopExitStack[len(e.loopExitStack)-1] {
+		e.patchJump(pos)
+	}
+	e.loopExitStack = e.loopExitStack[:len(e.loopExitStack)-1]
+	for _, pos := range e.loopContinueStack[len(e.loopContinueStack)-1] {
+		e.patchJumpTo(pos, loopStart)
+	}
+	e.loopContinueStack = e.loopContinueStack[:len(e.loopContinueStack)-1]
+	// Drop the snapshot so the loop does not keep the collection alive.
+	if err := e.compileNilLiteral(); err != nil {
+		return err
+	}
+	e.emit(vm.OpStoreVar, iter)
+	return nil
+}
+
+// LoopFrame is how each pass of a game loop is framed for drawing.
+type LoopFrame struct {
+	Hybrid bool // the body is replaced by a StepFrame call, which runs update and draw (the program has them)
+	Wrap   bool // BeginDrawing and BeginMode2D/3D open each pass; EndMode2D/3D and EndDrawing close it
+	Use3D  bool // Wrap with BeginMode3D rather than BeginMode2D
+	Sync   bool // Wrap, and the body has a SYNC, which ends the drawing: EndMode2D/3D goes right before it
+}
+
+// GameLoopFrame returns the framing of WHILE NOT WindowShouldClose() or (forRepeat) REPEAT ... UNTIL
+// WindowShouldClose(); other loops are not framed. userFuncs are the program's Subs and Functions.
+func GameLoopFrame(cond parser.Node, forRepeat bool, body *parser.Block, userFuncs map[string]bool) LoopFrame {
+	if !isGameLoopCondition(cond, forRepeat) {
+		return LoopFrame{}
+	}
+	if userFuncs["update"] || userFuncs["draw"] {
+		return LoopFrame{Hybrid: true}
+	}
+	return MainLoopFrame(body, userFuncs)
+}
+
+// MainLoopFrame returns the framing of a MAINLOOP body. A body that begins and ends drawing itself, or calls a
+// Sub (which may), is not wrapped: that would draw twice a frame and flicker.
+func MainLoopFrame(body *parser.Block, userFuncs map[string]bool) LoopFrame {
+	if body == nil {
+		return LoopFrame{Wrap: true}
+	}
+	callsUser := WalkStatements(body.Statements, func(n parser.Node) bool {
 		call, ok := n.(*parser.Call)
-		return ok && e.sem.UserFuncs != nil && e.sem.UserFuncs[strings.ToLower(call.Name)]
+		return ok && userFuncs[strings.ToLower(call.Name)]
 	})
+	if callsUser || bodyContainsFrameBoundaries(body.Statements) {
+		return LoopFrame{}
+	}
+	return LoopFrame{Wrap: true, Use3D: bodyContains3DDraw(body.Statements), Sync: bodyContainsSync(body.Statements)}
+}
+
+// Mode returns the name of the 2D/3D mode a wrapped pass is drawn in ("Mode2D" or "Mode3D").
+func (f LoopFrame) Mode() string {
+	if f.Use3D {
+		return "Mode3D"
+	}
+	return "Mode2D"
+}
+
+// EndsModeAt reports whether the mode ends right before the body statement stmt (a top-level SYNC).
+func (f LoopFrame) EndsModeAt(stmt parser.Node) bool {
+	gc, ok := unwrapStatement(stmt).(*parser.GameCommand)
+	return f.Sync && ok && strings.ToLower(gc.Command) == "sync"
+}
+
+// compileFramedBody compiles one pass of a loop body framed as f.
+func (e *Emitter) compileFramedBody(f LoopFrame, body []parser.Node) error {
+	if f.Hybrid {
+		e.emitHybridLoopBody()
+		return nil
+	}
+	if f.Wrap {
+		e.emitFrameWrap("BeginDrawing")
+		e.emitFrameWrap("Begin" + f.Mode())
+	}
+	for _, stmt := range body {
+		if f.EndsModeAt(stmt) {
+			e.emitFrameWrap("End" + f.Mode())
+		}
+		if err := e.compileStatement(stmt); err != nil {
+			return err
+		}
+	}
+	if f.Wrap && !f.Sync {
+		e.emitFrameWrap("End" + f.Mode())
+		e.emitFrameWrap("EndDrawing")
+	}
+	return nil
 }
 
 // emitFrameWrap emits a no-arg foreign call (BeginDrawing, EndDrawing, BeginMode2D, EndMode2D). Used for automatic frame wrapping.
 func (e *Emitter) emitFrameWrap(name string) {
 	idx := e.chunk.WriteConstant(strings.ToLower(name))
-	if idx > MaxConstIndex {
-		return
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(0))
+	e.emit(vm.OpCallForeign, idx, 0)
 }
 
 // emitHybridLoopBody emits a single runtime StepFrame call so all hybrid entry points share the same fixed-step behavior.
 func (e *Emitter) emitHybridLoopBody() {
 	idx := e.chunk.WriteConstant("stepframe")
-	if idx > MaxConstIndex {
-		return
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(0))
+	e.emit(vm.OpCallForeign, idx, 0)
 }
 
 // compileWhileStatement compiles a WHILE loop
@@ -636,14 +686,7 @@ func (e *Emitter) compileWhileStatement(whileStmt *parser.WhileStatement) error
 	e.loopContinueStack = append(e.loopContinueStack, nil)
 	// Loop start (CONTINUE WHILE jumps here)
 	loopStart := len(e.chunk.Code)
-	wrapFrame := isGameLoopCondition(whileStmt.Condition, false)
-	hybridMode := wrapFrame && (e.sem.UserFuncs["update"] || e.sem.UserFuncs["draw"])
-	if hybridMode {
-		wrapFrame = false
-	} else if wrapFrame && whileStmt.Body != nil && (e.bodyCallsUserSub(whileStmt.Body.Statements) || bodyContainsFrameBoundaries(whileStmt.Body.Statements)) {
-		wrapFrame = false // user does their own BeginDrawing/EndDrawing (or calls Draw()), avoid double wrap and flicker
-	}
-	use3D := wrapFrame && whileStmt.Body != nil && bodyContains3DDraw(whileStmt.Body.Statements)
+	frame := GameLoopFrame(whileStmt.Condition, false, whileStmt.Body, e.sem.UserFuncs)
 
 	// Compile condition
 	err := e.compileExpression(whileStmt.Condition)
@@ -652,66 +695,25 @@ func (e *Emitter) compileWhileStatement(whileStmt *parser.WhileStatement) error
 	}
 
 	// Jump if false (2-byte offset)
-	e.chunk.Write(byte(vm.OpJumpIfFalse))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	exitJumpPos := len(e.chunk.Code) - 2
+	exitJumpPos := e.emitJump(vm.OpJumpIfFalse)
 
-	if hybridMode {
-		e.emitHybridLoopBody()
-	} else {
-		if wrapFrame {
-			e.emitFrameWrap( "BeginDrawing")
-			if use3D {
-				e.emitFrameWrap( "BeginMode3D")
-			} else {
-				e.emitFrameWrap( "BeginMode2D")
-			}
-		}
-		for _, stmt := range whileStmt.Body.Statements {
-			// Emit EndMode2D/EndMode3D right before SYNC so 2D/3D content is flushed before EndDrawing.
-			if wrapFrame && bodyContainsSync(whileStmt.Body.Statements) {
-				n := unwrapStatement(stmt)
-				if gc, ok := n.(*parser.GameCommand); ok && strings.ToLower(gc.Command) == "sync" {
-					if use3D {
-						e.emitFrameWrap( "EndMode3D")
-					} else {
-						e.emitFrameWrap( "EndMode2D")
-					}
-				}
-			}
-			err = e.compileStatement(stmt)
-			if err != nil {
-				return err
-			}
-		}
-		if wrapFrame && !bodyContainsSync(whileStmt.Body.Statements) {
-			// When body contains SYNC, omit EndDrawing; SYNC does it.
-			if use3D {
-				e.emitFrameWrap( "EndMode3D")
-			} else {
-				e.emitFrameWrap( "EndMode2D")
-			}
-			e.emitFrameWrap( "EndDrawing")
-		}
+	if err := e.compileFramedBody(frame, whileStmt.Body.Statements); err != nil {
+		return err
 	}
 
 	// Jump back to loop start (2-byte offset)
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	e.chunk.PatchJumpOffset(len(e.chunk.Code)-2, loopStart-len(e.chunk.Code))
+	e.emitLoop(vm.OpJump, loopStart)
 
 	// Fix exit jump offset
-	e.chunk.PatchJumpOffset(exitJumpPos, len(e.chunk.Code)-exitJumpPos-2)
+	e.patchJump(exitJumpPos)
 	// Patch EXIT WHILE jumps
 	for _, pos := range e.loopExitStack[len(e.loopExitStack)-1] {
-		e.chunk.PatchJumpOffset(pos, len(e.chunk.Code)-pos-2)
+		e.patchJump(pos)
 	}
 	e.loopExitStack = e.loopExitStack[:len(e.loopExitStack)-1]
 	// Patch CONTINUE WHILE jumps to condition
 	for _, pos := range e.loopContinueStack[len(e.loopContinueStack)-1] {
-		e.chunk.PatchJumpOffset(pos, loopStart-pos-2)
+		e.patchJumpTo(pos, loopStart)
 	}
 	e.loopContinueStack = e.loopContinueStack[:len(e.loopContinueStack)-1]
 
@@ -724,11 +726,6 @@ func (e *Emitter) compileMainLoopStatement(m *parser.MainLoopStatement) error {
 	e.loopContinueStack = append(e.loopContinueStack, nil)
 	loopStart := len(e.chunk.Code)
 
-	wrapFrame := true
-	if m.Body != nil && (e.bodyCallsUserSub(m.Body.Statements) || bodyContainsFrameBoundaries(m.Body.Statements)) {
-		wrapFrame = false
-	}
-	use3D := wrapFrame && m.Body != nil && bodyContains3DDraw(m.Body.Statements)
 	body := m.Body
 	if body == nil {
 		body = &parser.Block{}
@@ -736,64 +733,24 @@ func (e *Emitter) compileMainLoopStatement(m *parser.MainLoopStatement) error {
 
 	// Condition: NOT WindowShouldClose()
 	idx := e.chunk.WriteConstant("windowshouldclose")
-	if err := checkConstIndex(idx, ""); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpCallForeign))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(0))
+	e.emit(vm.OpCallForeign, idx, 0)
 	e.chunk.Write(byte(vm.OpNot))
 
-	e.chunk.Write(byte(vm.OpJumpIfFalse))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	exitJumpPos := len(e.chunk.Code) - 2
-
-	if wrapFrame {
-		e.emitFrameWrap( "BeginDrawing")
-		if use3D {
-			e.emitFrameWrap( "BeginMode3D")
-		} else {
-			e.emitFrameWrap( "BeginMode2D")
-		}
-	}
-	for _, stmt := range body.Statements {
-		if wrapFrame && bodyContainsSync(body.Statements) {
-			n := unwrapStatement(stmt)
-			if gc, ok := n.(*parser.GameCommand); ok && strings.ToLower(gc.Command) == "sync" {
-				if use3D {
-					e.emitFrameWrap( "EndMode3D")
-				} else {
-					e.emitFrameWrap( "EndMode2D")
-				}
-			}
-		}
-		err := e.compileStatement(stmt)
-		if err != nil {
-			return err
-		}
-	}
-	if wrapFrame && !bodyContainsSync(body.Statements) {
-		if use3D {
-			e.emitFrameWrap( "EndMode3D")
-		} else {
-			e.emitFrameWrap( "EndMode2D")
-		}
-		e.emitFrameWrap( "EndDrawing")
+	exitJumpPos := e.emitJump(vm.OpJumpIfFalse)
+
+	if err := e.compileFramedBody(MainLoopFrame(m.Body, e.sem.UserFuncs), body.Statements); err != nil {
+		return err
 	}
 
-	e.chunk.Write(byte(vm.OpJump))
-	e.chunk.Write(byte(0))
-	e.chunk.Write(byte(0))
-	e.chunk.PatchJumpOffset(len(e.chunk.Code)-2, loopStart-len(e.chunk.Code))
+	e.emitLoop(vm.OpJump, loopStart)
 
-	e.chunk.PatchJumpOffset(exitJumpPos, len(e.chunk.Code)-exitJumpPos-2)
+	e.patchJump(exitJumpPos)
 	for _, pos := range e.loopExitStack[len(e.loopExitStack)-1] {
-		e.chunk.PatchJumpOffset(pos, len(e.chunk.Code)-pos-2)
+		e.patchJump(pos)
 	}
 	e.loopExitStack = e.loopExitStack[:len(e.loopExitStack)-1]
 	for _, pos := range e.loopContinueStack[len(e.loopContinueStack)-1] {
-		e.chunk.PatchJumpOffset(pos, loopStart-pos-2)
+		e.patchJumpTo(pos, loopStart)
 	}
 	e.loopContinueStack = e.loopContinueStack[:len(e.loopContinueStack)-1]
 
@@ -804,11 +761,15 @@ func (e *Emitter) compileMainLoopStatement(m *parser.MainLoopStatement) error {
 func (e *Emitter) compileFunctionDecl(fn *parser.FunctionDecl) error {
 	name := semantic.QualifiedName(fn)
 	e.chunk.Functions[name] = len(e.chunk.Code)
+	e.chunk.Params[name] = fn.Parameters
 	// Params map to stack indices 0, 1, ... so body sees a=0, b=1, etc.
 	e.funcParamIndices = make(map[string]int)
 	for i, p := range fn.Parameters {
 		e.funcParamIndices[strings.ToLower(p)] = i
 	}
+	if fn.TypeName != "" {
+		e.funcParamIndices["self"] = 0 // SELF is ME in methods
+	}
 	for _, stmt := range fn.Body.Statements {
 		if err := e.compileStatement(stmt); err != nil {
 			return err
@@ -822,10 +783,14 @@ func (e *Emitter) compileFunctionDecl(fn *parser.FunctionDecl) error {
 func (e *Emitter) compileSubDecl(sub *parser.SubDecl) error {
 	name := semantic.QualifiedName(sub)
 	e.chunk.Functions[name] = len(e.chunk.Code)
+	e.chunk.Params[name] = sub.Parameters
 	e.funcParamIndices = make(map[string]int)
 	for i, p := range sub.Parameters {
 		e.funcParamIndices[strings.ToLower(p)] = i
 	}
+	if sub.TypeName != "" {
+		e.funcParamIndices["self"] = 0 // SELF is ME in methods
+	}
 	for _, stmt := range sub.Body.Statements {
 		if err := e.compileStatement(stmt); err != nil {
 			return err
@@ -874,11 +839,7 @@ func (e *Emitter) compileReadStatement(r *parser.ReadStatement) error {
 			return errWithLine(v, fmt.Errorf("READ requires variable name"))
 		}
 		idx := e.chunk.AddVariable(name)
-		if idx > 255 {
-			return fmt.Errorf("too many variables for READ")
-		}
-		e.chunk.Write(byte(vm.OpRead))
-		e.chunk.Write(byte(idx))
+		e.emit(vm.OpRead, idx)
 	}
 	return nil
 }
@@ -889,55 +850,94 @@ func (e *Emitter) compileGosubStatement(g *parser.GosubStatement) error {
 		return errWithLine(g, fmt.Errorf("unknown sub for GOSUB: %s", g.SubName))
 	}
 	idx := e.chunk.WriteConstant(name)
-	if err := checkConstIndex(idx, " for GOSUB"); err != nil {
-		return err
-	}
-	e.chunk.Write(byte(vm.OpGosub))
-	e.chunk.Write(byte(idx))
-	e.chunk.Write(byte(0)) // arg count
+	e.emit(vm.OpGosub, idx, 0) // arg count
 	return nil
 }
 
-// compileStartCoroutineStatement compiles StartCoroutine SubName(): emit OpStartCoroutine with 2-byte target offset (patched after decls)
+// compileStartCoroutineStatement compiles StartCoroutine SubName(): emit OpStartCoroutine with a target offset (patched after decls).
+// With arguments it starts the coroutine as a task and drops the task handle.
 func (e *Emitter) compileStartCoroutineStatement(stmt *parser.StartCoroutineStatement) error {
-	name := strings.ToLower(stmt.SubName)
-	if !e.sem.UserFuncs[name] {
-		candidates := make([]string, 0, len(e.sem.UserFuncs))
-		for q := range e.sem.UserFuncs {
-			candidates = append(candidates, q)
-		}
-		msg := fmt.Sprintf("unknown sub for StartCoroutine: %s", stmt.SubName)
-		if sug := nearestName(name, candidates, 3); sug != "" {
-			msg += " (did you mean " + sug + "?)"
+	if len(stmt.Args) > 0 {
+		if err := e.compileStartTask(stmt); err != nil {
+			return err
 		}
-		return errWithLine(stmt, fmt.Errorf("%s", msg))
+		e.chunk.Write(byte(vm.OpPop))
+		return nil
 	}
-	e.chunk.Write(byte(vm.OpStartCoroutine))
-	e.chunk.WriteJumpOffset(0)
+	if err := e.checkCoroutineSub(stmt); err != nil {
+		return err
+	}
+	name := strings.ToLower(stmt.SubName)
 	nameIdx := e.chunk.WriteConstant(name)
-	if err := checkConstIndex(nameIdx, " for StartCoroutine name"); err != nil {
+	pos, wide := e.emitTarget(vm.OpStartCoroutine, 0, nameIdx)
+	e.startCoroutinePatchList = append(e.startCoroutinePatchList, startCoroutinePatch{patchPos: pos, wide: wide, subName: name})
+	return nil
+}
+
+// checkCoroutineSub reports an unknown StartCoroutine sub, suggesting the nearest declared name.
+func (e *Emitter) checkCoroutineSub(stmt *parser.StartCoroutineStatement) error {
+	name := strings.ToLower(stmt.SubName)
+	if e.sem.UserFuncs[name] {
+		return nil
+	}
+	candidates := make([]string, 0, len(e.sem.UserFuncs))
+	for q := range e.sem.UserFuncs {
+		candidates = append(candidates, q)
+	}
+	msg := fmt.Sprintf("unknown sub for StartCoroutine: %s", stmt.SubName)
+	if sug := nearestName(name, candidates, 3); sug != "" {
+		msg += " (did you mean " + sug + "?)"
+	}
+	return errWithLine(stmt, fmt.Errorf("%s", msg))
+}
+
+// compileStartTask compiles StartCoroutine SubName(args) as an expression: push the args, then OpStartTask
+// (target patched after decls), which pushes the task handle.
+func (e *Emitter) compileStartTask(stmt *parser.StartCoroutineStatement) error {
+	if err := e.checkCoroutineSub(stmt); err != nil {
 		return err
 	}
-	e.chunk.Write(byte(nameIdx))
-	e.startCoroutinePatchList = append(e.startCoroutinePatchList, startCoroutinePatch{patchPos: len(e.chunk.Code) - 3, subName: name})
+	if len(stmt.Args) > 255 {
+		return fmt.Errorf("StartCoroutine %s: too many arguments (%d, max 255)", stmt.SubName, len(stmt.Args))
+	}
+	for _, arg := range stmt.Args {
+		if err := e.compileExpression(arg); err != nil {
+			return err
+		}
+	}
+	name := strings.ToLower(stmt.SubName)
+	nameIdx := e.chunk.WriteConstant(name)
+	pos, wide := e.emitTarget(vm.OpStartTask, 0, nameIdx, len(stmt.Args))
+	e.startCoroutinePatchList = append(e.startCoroutinePatchList, startCoroutinePatch{patchPos: pos, wide: wide, subName: name})
 	return nil
 }
 
-// compileOnEventStatement compiles On KeyDown("X") ... End On: emit OpRegisterEvent (handler offset patched later)
+// compileOnEventStatement compiles On Event("key", params) ... End On: emit OpRegisterEvent (handler offset patched
+// later). The body is compiled after the Subs with the params bound like Sub parameters.
 func (e *Emitter) compileOnEventStatement(on *parser.OnEventStatement) error {
 	eventTypeConst := e.chunk.WriteConstant(strings.ToLower(on.EventType))
 	keyConst := e.chunk.WriteConstant(on.Key)
-	if err := checkConstIndex(eventTypeConst, " for On event"); err != nil {
-		return err
+	pos, wide := e.emitTarget(vm.OpRegisterEvent, eventTypeConst, keyConst, 0)
+	e.eventPatchList = append(e.eventPatchList, eventPatch{patchPos: pos, wide: wide, stmt: on})
+	return nil
+}
+
+// compileEmitStatement compiles EMIT name, args: push the name and args, then OpEmit queues the event.
+func (e *Emitter) compileEmitStatement(em *parser.EmitStatement) error {
+	if len(em.Args) > 255 {
+		return errWithLine(em, fmt.Errorf("EMIT supports at most 255 arguments"))
 	}
-	if err := checkConstIndex(keyConst, " for On event"); err != nil {
+	if lit, ok := em.Name.(*parser.StringLiteral); ok {
+		e.emit(vm.OpLoadString, e.chunk.WriteConstant(strings.ToLower(lit.Value)))
+	} else if err := e.compileExpression(em.Name); err != nil {
 		return err
 	}
-	e.chunk.Write(byte(vm.OpRegisterEvent))
-	e.chunk.Write(byte(eventTypeConst))
-	e.chunk.Write(byte(keyConst))
-	e.chunk.WriteJumpOffset(0)
-	e.eventPatchList = append(e.eventPatchList, eventPatch{patchPos: len(e.chunk.Code) - 2, stmt: on})
+	for _, arg := range em.Args {
+		if err := e.compileExpression(arg); err != nil {
+			return err
+		}
+	}
+	e.emit(vm.OpEmit, len(em.Args))
 	return nil
 }
 
@@ -948,8 +948,14 @@ func (e *Emitter) compileReturnStatement(ret *parser.ReturnStatement) error {
 		if err != nil {
 			return err
 		}
+		if err := e.leaveTryScopes(0, true); err != nil {
+			return err
+		}
 		e.chunk.Write(byte(vm.OpReturnVal))
 	} else {
+		if err := e.leaveTryScopes(0, false); err != nil {
+			return err
+		}
 		e.chunk.Write(byte(vm.OpReturn))
 	}
 	return nil
@@ -974,7 +980,8 @@ func (e *Emitter) compileDimStatement(dim *parser.DimStatement) error {
 			// Fixed-size array: DIM a(10, 20)
 			// Array: DIM a(10, 20) AS Integer
 			dims := make([]int, len(v.Dimensions))
-			constIndices := make([]byte, len(v.Dimensions))
+			operands := make([]int, 0, len(v.Dimensions)+2)
+			operands = append(operands, len(v.Dimensions))
 			for i, d := range v.Dimensions {
 				n, err := constIntFromNode(d)
 				if err != nil {
@@ -984,60 +991,43 @@ func (e *Emitter) compileDimStatement(dim *parser.DimStatement) error {
 					return fmt.Errorf("dimension %d for %s must be >= 1", i+1, v.Name)
 				}
 				dims[i] = n
-				ci := e.chunk.WriteConstant(n)
-				if err := checkConstIndex(ci, ""); err != nil {
-					return err
-				}
-				constIndices[i] = byte(ci)
+				operands = append(operands, e.chunk.WriteConstant(n))
 			}
 			e.chunk.SetVarDims(v.Name, dims)
-			e.chunk.Write(byte(vm.OpCreateArray))
-			e.chunk.Write(byte(len(dims)))
-			for _, b := range constIndices {
-				e.chunk.Write(b)
-			}
-			e.chunk.Write(byte(varIndex))
+			e.emit(vm.OpCreateArray, append(operands, varIndex)...)
 			continue
 		}
 
 		// DIM a() - empty dynamic array (dimensions is [] from parser when we saw ())
 		if v.Dimensions != nil && len(v.Dimensions) == 0 {
 			e.chunk.SetVarDims(v.Name, []int{})
-			e.chunk.Write(byte(vm.OpCreateArray))
-			e.chunk.Write(byte(1))
-			ci := e.chunk.WriteConstant(0)
-			if err := checkConstIndex(ci, ""); err != nil {
-				return err
-			}
-			e.chunk.Write(byte(ci))
-			e.chunk.Write(byte(varIndex))
+			e.emit(vm.OpCreateArray, 1, e.chunk.WriteConstant(0), varIndex)
 			continue
 		}
 
+		// DIM p AS SomeType: a new instance with default fields (the New method is not called)
+		if _, ok := e.sem.TypeDefs[strings.ToLower(v.Type)]; ok {
+			e.emit(vm.OpNewObject, e.chunk.WriteConstant(strings.ToLower(v.Type)))
+			e.emit(vm.OpStoreVar, varIndex)
+			continue
+		}
 		// Scalar: initialize with default value (dynamic type when v.Type == "")
 		switch strings.ToLower(v.Type) {
 		case "integer", "int", "":
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(e.chunk.WriteConstant(0)))
+			e.emit(vm.OpLoadConst, e.chunk.WriteConstant(0))
 		case "string", "str":
-			e.chunk.Write(byte(vm.OpLoadString))
-			e.chunk.Write(byte(e.chunk.WriteConstant("")))
+			e.emit(vm.OpLoadString, e.chunk.WriteConstant(""))
 		case "float", "single", "double":
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(e.chunk.WriteConstant(0.0)))
+			e.emit(vm.OpLoadConst, e.chunk.WriteConstant(0.0))
 		case "boolean", "bool":
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(e.chunk.WriteConstant(false)))
+			e.emit(vm.OpLoadConst, e.chunk.WriteConstant(false))
 		case "vector2", "vector3", "body", "color":
 			// Optional type hints (e.g. DIM pos AS Vector2); stored as dynamic 0, use .x/.y/.z or API later
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(e.chunk.WriteConstant(0)))
+			e.emit(vm.OpLoadConst, e.chunk.WriteConstant(0))
 		default:
-			e.chunk.Write(byte(vm.OpLoadConst))
-			e.chunk.Write(byte(e.chunk.WriteConstant(0)))
+			e.emit(vm.OpLoadConst, e.chunk.WriteConstant(0))
 		}
-		e.chunk.Write(byte(vm.OpStoreVar))
-		e.chunk.Write(byte(varIndex))
+		e.emit(vm.OpStoreVar, varIndex)
 	}
 	return nil
 }
@@ -1053,9 +1043,7 @@ func (e *Emitter) compileRedimStatement(r *parser.RedimStatement) error {
 			return err
 		}
 	}
-	e.chunk.Write(byte(vm.OpResizeArray))
-	e.chunk.Write(byte(len(r.Dimensions)))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpResizeArray, len(r.Dimensions), varIndex)
 	return nil
 }
 
@@ -1068,13 +1056,12 @@ func (e *Emitter) compileAppendStatement(a *parser.AppendStatement) error {
 	if err := e.compileExpression(a.Value); err != nil {
 		return err
 	}
-	e.chunk.Write(byte(vm.OpAppendArray))
-	e.chunk.Write(byte(varIndex))
+	e.emit(vm.OpAppendArray, varIndex)
 	return nil
 }
 
-// evalConstExpr evaluates a constant expression (number, string, boolean, or -number). Returns error if not constant.
-func evalConstExpr(n parser.Node) (interface{}, error) {
+// ConstValue evaluates a CONST, ENUM or TYPE constant value (number, string, boolean, or -number). Returns error if not constant.
+func ConstValue(n parser.Node) (interface{}, error) {
 	switch node := n.(type) {
 	case *parser.Number:
 		if strings.Contains(node.Value, ".") {
@@ -1121,15 +1108,12 @@ func evalConstExpr(n parser.Node) (interface{}, error) {
 // compileConstStatement compiles CONST name = value (, name = value)*
 func (e *Emitter) compileConstStatement(cs *parser.ConstStatement) error {
 	for _, d := range cs.Decls {
-		val, err := evalConstExpr(d.Value)
+		val, err := ConstValue(d.Value)
 		if err != nil {
 			return err
 		}
 		idx := e.chunk.WriteConstant(val)
-		if err := checkConstIndex(idx, ""); err != nil {
-			return err
-		}
-		e.constIndices[strings.ToLower(d.Name)] = byte(idx)
+		e.constIndices[strings.ToLower(d.Name)] = idx
 	}
 	return nil
 }
@@ -1148,18 +1132,55 @@ func toInt64(v interface{}) (int64, error) {
 	}
 }
 
-// resolveUDTConstantMember returns the value for TypeName.Member when the type is used as a constant group (eval or auto-increment).
-func (e *Emitter) resolveUDTConstantMember(td *parser.TypeDecl, memberLower string) (interface{}, error) {
+// registerTypes records the data fields of every TYPE in the chunk for OpNewObject.
+func (e *Emitter) registerTypes() {
+	for key, ti := range TypeInfos(e.sem.TypeDefs) {
+		e.chunk.Types[key] = ti
+	}
+}
+
+// TypeInfos returns the layouts of the TYPEs typeDefs (keyed by lowercase name). Fields start at the zero
+// value of their AS type (0 when untyped); a field whose type is another TYPE gets a nested instance.
+func TypeInfos(typeDefs map[string]*parser.TypeDecl) map[string]*vm.TypeInfo {
+	types := make(map[string]*vm.TypeInfo, len(typeDefs))
+	for key, td := range typeDefs {
+		ti := &vm.TypeInfo{Name: td.Name}
+		for _, f := range td.Fields {
+			if f.ConstValue != nil {
+				continue // constant group member
+			}
+			fi := vm.FieldInfo{Name: strings.ToLower(f.Name), Default: 0}
+			switch ft := strings.ToLower(f.FieldType); ft {
+			case "string", "str":
+				fi.Default = ""
+			case "float", "single", "double":
+				fi.Default = 0.0
+			case "boolean", "bool":
+				fi.Default = false
+			default:
+				if _, ok := typeDefs[ft]; ok {
+					fi.Default, fi.TypeName = nil, ft
+				}
+			}
+			ti.Fields = append(ti.Fields, fi)
+		}
+		types[key] = ti
+	}
+	return types
+}
+
+// UDTConstant returns the value for TypeName.Member when the type is used as a constant group (eval or auto-increment).
+func UDTConstant(td *parser.TypeDecl, memberLower string) (interface{}, error) {
 	nextVal := int64(0)
 	for _, f := range td.Fields {
 		if strings.ToLower(f.Name) == memberLower {
 			if f.ConstValue != nil {
-				return evalConstExpr(f.ConstValue)
+				return ConstValue(f.ConstValue)
 			}
 			return nextVal, nil
 		}
 		if f.ConstValue != nil {
-			val, err := evalConstExpr(f.ConstValue)
+			val, err := ConstValue(f.ConstValue)
 			if err != nil {
 				return nil, err
 			}
@@ -1184,34 +1205,41 @@ func (e *Emitter) resolveUDTConstantMember(td *parser.TypeDecl, memberLower stri
 // compileEnumStatement compiles ENUM Name : a, b = 2, c ... members as constants (auto-increment from 0 or explicit value).
 // Also records enum name -> member -> value in e.chunk.Enums for Enum.getValue/getName/hasValue at runtime.
 func (e *Emitter) compileEnumStatement(es *parser.EnumStatement) error {
-	enumName := strings.ToLower(es.Name)
+	members, err := EnumMembers(es)
+	if err != nil {
+		return err
+	}
 	if e.chunk.Enums == nil {
 		e.chunk.Enums = make(map[string]vm.EnumMembers)
 	}
+	for _, m := range es.Members {
+		memLower := strings.ToLower(m.Name)
+		e.constIndices[memLower] = e.chunk.WriteConstant(members[memLower])
+	}
+	e.chunk.Enums[strings.ToLower(es.Name)] = members
+	return nil
+}
+
+// EnumMembers returns the values of the members of es (lowercase names), counting up from 0 or from the last
+// explicit value.
+func EnumMembers(es *parser.EnumStatement) (vm.EnumMembers, error) {
 	members := make(vm.EnumMembers)
 	nextVal := int64(0)
 	for _, m := range es.Members {
 		if m.Value != nil {
-			val, err := evalConstExpr(m.Value)
+			val, err := ConstValue(m.Value)
 			if err != nil {
-				return fmt.Errorf("enum member %s: %w", m.Name, err)
+				return nil, fmt.Errorf("enum member %s: %w", m.Name, err)
 			}
 			nextVal, err = toInt64(val)
 			if err != nil {
-				return fmt.Errorf("enum member %s: %w", m.Name, err)
+				return nil, fmt.Errorf("enum member %s: %w", m.Name, err)
 			}
 		}
-		memLower := strings.ToLower(m.Name)
-		members[memLower] = nextVal
-		idx := e.chunk.WriteConstant(nextVal)
-		if err := checkConstIndex(idx, ""); err != nil {
-			return err
-		}
-		e.constIndices[memLower] = byte(idx)
+		members[strings.ToLower(m.Name)] = nextVal
 		nextVal++
 	}
-	e.chunk.Enums[enumName] = members
-	return nil
+	return members, nil
 }
 
 // compileGameCommand compiles game-specific commands
@@ -1227,49 +1255,39 @@ func (e *Emitter) compileGameCommand(cmd *parser.GameCommand) error {
 	// Case-insensitive: canonical form is lowercase
 	command := strings.ToLower(cmd.Command)
 
-	// Emit game command instruction
-	switch command {
-	case "print":
-		e.chunk.Write(byte(vm.OpPrint))
-	case "str":
-		e.chunk.Write(byte(vm.OpStr))
-	case "loadimage":
-		e.chunk.Write(byte(vm.OpLoadImage))
-	case "createsprite":
-		e.chunk.Write(byte(vm.OpCreateSprite))
-	case "setspriteposition":
-		e.chunk.Write(byte(vm.OpSetSpritePosition))
-	case "drawsprite":
-		e.chunk.Write(byte(vm.OpDrawSprite))
-	case "loadmodel":
-		e.chunk.Write(byte(vm.OpLoadModel))
-	case "createcamera":
-		e.chunk.Write(byte(vm.OpCreateCamera))
-	case "setcameraposition":
-		e.chunk.Write(byte(vm.OpSetCameraPosition))
-	case "drawmodel":
-		e.chunk.Write(byte(vm.OpDrawModel))
-	case "playmusic":
-		e.chunk.Write(byte(vm.OpPlayMusic))
-	case "playsound":
-		e.chunk.Write(byte(vm.OpPlaySound))
-	case "loadsound":
-		e.chunk.Write(byte(vm.OpLoadSound))
-	case "createphysicsbody":
-		e.chunk.Write(byte(vm.OpCreatePhysicsBody))
-	case "setvelocity":
-		e.chunk.Write(byte(vm.OpSetVelocity))
-	case "applyforce":
-		e.chunk.Write(byte(vm.OpApplyForce))
-	case "raycast3d":
-		e.chunk.Write(byte(vm.OpRayCast3D))
-	case "sync":
-		e.chunk.Write(byte(vm.OpSync))
-	case "shouldclose":
-		e.chunk.Write(byte(vm.OpShouldClose))
-	default:
+	op, ok := gameCommands[command]
+	if !ok {
 		return fmt.Errorf("unsupported game command: %s", cmd.Command)
 	}
-
+	e.chunk.Write(byte(op))
 	return nil
 }
+
+// gameCommands maps each game command (lowercase) to the instruction it compiles to, after its arguments.
+var gameCommands = map[string]vm.OpCode{
+	"print":             vm.OpPrint,
+	"str":               vm.OpStr,
+	"loadimage":         vm.OpLoadImage,
+	"createsprite":      vm.OpCreateSprite,
+	"setspriteposition": vm.OpSetSpritePosition,
+	"drawsprite":        vm.OpDrawSprite,
+	"loadmodel":         vm.OpLoadModel,
+	"createcamera":      vm.OpCreateCamera,
+	"setcameraposition": vm.OpSetCameraPosition,
+	"drawmodel":         vm.OpDrawModel,
+	"playmusic":         vm.OpPlayMusic,
+	"playsound":         vm.OpPlaySound,
+	"loadsound":         vm.OpLoadSound,
+	"createphysicsbody": vm.OpCreatePhysicsBody,
+	"setvelocity":       vm.OpSetVelocity,
+	"applyforce":        vm.OpApplyForce,
+	"raycast3d":         vm.OpRayCast3D,
+	"sync":              vm.OpSync,
+	"shouldclose":       vm.OpShouldClose,
+}
+
+// GameCommand returns the instruction of the game command name.
+func GameCommand(name string) (vm.OpCode, bool) {
+	op, ok := gameCommands[strings.ToLower(name)]
+	return op, ok
+}
@@ -0,0 +1,140 @@
+package codegen
+
+import (
+	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/vm"
+	"strings"
+)
+
+// tryScope is a TRY (or CATCH body protected for FINALLY) whose handler is active at the current emit position.
+type tryScope struct {
+	loopDepth int           // len(loopExitStack) when the scope opened; EXIT/CONTINUE leave scopes opened inside the loop
+	finally   *parser.Block // run when leaving the scope early (RETURN, EXIT, CONTINUE); nil = none
+	pending   bool          // a FINALLY body running with the error to rethrow on the stack (no handler to end)
+}
+
+// compileTryStatement compiles TRY/CATCH/FINALLY:
+//
+//	OpTry handler; body; OpEndTry; Jump done
+//	handler: [OpTry rethrow]; store error in catch var; catch body; [OpEndTry]; Jump done
+//	rethrow: finally body (the error stays on the stack); OpThrow
+//	done: finally body
+//
+// Without CATCH the handler is the rethrow path, so FINALLY runs and the error propagates.
+func (e *Emitter) compileTryStatement(t *parser.TryStatement) error {
+	tryPos, tryWide := e.emitTarget(vm.OpTry, 0)
+	e.tryStack = append(e.tryStack, tryScope{loopDepth: len(e.loopExitStack), finally: t.FinallyBlock})
+	if err := e.compileBlock(t.Body); err != nil {
+		return err
+	}
+	e.tryStack = e.tryStack[:len(e.tryStack)-1]
+	e.chunk.Write(byte(vm.OpEndTry))
+	doneJumps := []int{e.emitJump(vm.OpJump)}
+	e.patchTarget(tryPos, len(e.chunk.Code), tryWide)
+
+	if t.CatchBlock != nil {
+		var protectPos int
+		var protectWide bool
+		if t.FinallyBlock != nil {
+			protectPos, protectWide = e.emitTarget(vm.OpTry, 0)
+			e.tryStack = append(e.tryStack, tryScope{loopDepth: len(e.loopExitStack), finally: t.FinallyBlock})
+		}
+		if t.CatchVar != "" {
+			e.emitStoreName(t.CatchVar)
+		} else {
+			e.chunk.Write(byte(vm.OpPop))
+		}
+		if err := e.compileBlock(t.CatchBlock); err != nil {
+			return err
+		}
+		if t.FinallyBlock != nil {
+			e.tryStack = e.tryStack[:len(e.tryStack)-1]
+			e.chunk.Write(byte(vm.OpEndTry))
+			doneJumps = append(doneJumps, e.emitJump(vm.OpJump))
+			e.patchTarget(protectPos, len(e.chunk.Code), protectWide)
+		} else {
+			doneJumps = append(doneJumps, e.emitJump(vm.OpJump))
+		}
+	}
+
+	if t.FinallyBlock != nil {
+		// The error is kept on the stack, not in a variable, so a FINALLY that re-enters this code cannot overwrite it.
+		e.tryStack = append(e.tryStack, tryScope{loopDepth: len(e.loopExitStack), pending: true})
+		if err := e.compileBlock(t.FinallyBlock); err != nil {
+			return err
+		}
+		e.tryStack = e.tryStack[:len(e.tryStack)-1]
+		e.chunk.Write(byte(vm.OpThrow))
+	}
+
+	for _, pos := range doneJumps {
+		e.patchJump(pos)
+	}
+	if t.FinallyBlock != nil {
+		return e.compileBlock(t.FinallyBlock)
+	}
+	return nil
+}
+
+// compileThrowStatement compiles THROW expr.
+func (e *Emitter) compileThrowStatement(t *parser.ThrowStatement) error {
+	if err := e.compileExpression(t.Value); err != nil {
+		return err
+	}
+	e.chunk.Write(byte(vm.OpThrow))
+	return nil
+}
+
+// leaveTryScopes emits OpEndTry and the FINALLY body for every active TRY scope above depth (innermost first),
+// for RETURN (depth 0) and EXIT/CONTINUE (scopes opened inside the innermost loop). Leaving a FINALLY body that
+// runs before a rethrow drops the pending error; valueOnTop keeps the RETURN value above it.
+func (e *Emitter) leaveTryScopes(depth int, valueOnTop bool) error {
+	saved := e.tryStack
+	defer func() { e.tryStack = saved }()
+	for i := len(saved) - 1; i >= depth; i-- {
+		e.tryStack = saved[:i]
+		if saved[i].pending {
+			if valueOnTop {
+				e.chunk.Write(byte(vm.OpSwap))
+			}
+			e.chunk.Write(byte(vm.OpPop))
+			continue
+		}
+		e.chunk.Write(byte(vm.OpEndTry))
+		if saved[i].finally != nil {
+			if err := e.compileBlock(saved[i].finally); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loopTryDepth returns the index of the first TRY scope opened inside the innermost loop.
+func (e *Emitter) loopTryDepth() int {
+	depth := len(e.tryStack)
+	for depth > 0 && e.tryStack[depth-1].loopDepth >= len(e.loopExitStack) {
+		depth--
+	}
+	return depth
+}
+
+// emitStoreName stores the value on top of the stack in a scalar variable or the current Sub/Function parameter.
+func (e *Emitter) emitStoreName(name string) {
+	if e.funcParamIndices != nil {
+		if idx, ok := e.funcParamIndices[strings.ToLower(name)]; ok {
+			e.emit(vm.OpStoreParam, idx)
+			return
+		}
+	}
+	e.emit(vm.OpStoreVar, e.chunk.AddVariable(name))
+}
+
+func (e *Emitter) compileBlock(b *parser.Block) error {
+	for _, stmt := range b.Statements {
+		if err := e.compileStatement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
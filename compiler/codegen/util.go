@@ -6,8 +6,8 @@ import (
 	"strings"
 )
 
-// physicsNamespaceToFlat maps BOX2D.* and BULLET.* dotted names (lowercase) to flat VM names.
-func physicsNamespaceToFlat(name string) string {
+// PhysicsFlatName maps BOX2D.* and BULLET.* dotted names (lowercase) to flat VM names ("" when there is none).
+func PhysicsFlatName(name string) string {
 	name = strings.ToLower(name)
 	if flat, ok := physicsNamespaceFlatMap[name]; ok {
 		return flat
@@ -34,16 +34,6 @@ var physicsNamespaceFlatMap = map[string]string{
 	"bullet.getraycasthitnormaly": "rayhitnormaly3d", "bullet.getraycasthitnormalz": "rayhitnormalz3d",
 }
 
-// MaxConstIndex is the maximum constant index (0-255) that fits in a single byte in the chunk.
-const MaxConstIndex = 255
-
-func checkConstIndex(idx int, kind string) error {
-	if idx > MaxConstIndex {
-		return fmt.Errorf("%s", "too many constants"+kind)
-	}
-	return nil
-}
-
 func parseInt(s string) (int, error) {
 	var result int
 	_, err := fmt.Sscanf(s, "%d", &result)
@@ -87,10 +77,18 @@ func WalkStatements(nodes []parser.Node, pred func(parser.Node) bool) bool {
 			if v.Body != nil && WalkStatements(v.Body.Statements, pred) {
 				return true
 			}
+		case *parser.ForEachStatement:
+			if v.Body != nil && WalkStatements(v.Body.Statements, pred) {
+				return true
+			}
 		case *parser.WhileStatement:
 			if v.Body != nil && WalkStatements(v.Body.Statements, pred) {
 				return true
 			}
+		case *parser.TestStatement:
+			if WalkStatements(v.Body.Statements, pred) {
+				return true
+			}
 		case *parser.RepeatStatement:
 			if v.Body != nil && WalkStatements(v.Body.Statements, pred) {
 				return true
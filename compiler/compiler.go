@@ -1,5 +1,5 @@
-// Package compiler is the single front-end for CyberBasic: one pipeline (lex → parse → semantic → codegen)
-// with modular subpackages (lexer, parser, semantic, codegen). Use Compiler.Compile or CompileWithOptions
+// Package compiler is the single front-end for CyberBasic: one pipeline (lex → parse → semantic → codegen → optimizer)
+// with modular subpackages (lexer, parser, semantic, codegen, optimizer). Use Compiler.Compile or CompileWithOptions
 // for end-to-end builds; use Tokenize, Parse, Analyze for tooling that stops early. All full compilations
 // share the same internal pipeline so behavior cannot drift between callers.
 package compiler
@@ -7,8 +7,10 @@ package compiler
 import (
 	"cyberbasic/compiler/codegen"
 	"cyberbasic/compiler/lexer"
+	"cyberbasic/compiler/optimizer"
 	"cyberbasic/compiler/parser"
 	"cyberbasic/compiler/semantic"
+	"cyberbasic/compiler/srcmap"
 	"cyberbasic/compiler/vm"
 	"fmt"
 )
@@ -23,6 +25,13 @@ type Compiler struct {
 type CompileOptions struct {
 	// Filename is prepended to phase errors (lexical, parse, semantic, codegen). Empty uses Compiler.Filename.
 	Filename string
+	// SourceMap maps lines of preprocessed source back to included files; it is attached to the compiled Chunk.
+	SourceMap *srcmap.Map
+	// Optimize selects the bytecode optimizer level (optimizer.O0, the zero value, leaves codegen output as is).
+	Optimize optimizer.Level
+	// Base is the chunk of a running program that the result will be loaded into with VM.Reload (nil for a
+	// normal build): the result keeps Base's variable slots and constant indices.
+	Base *vm.Chunk
 }
 
 // New creates a new compiler instance.
@@ -93,17 +102,38 @@ func (c *Compiler) Analyze(program *parser.Program) (*semantic.Result, error) {
 
 // Compile compiles BASIC source code to bytecode using Compiler.Filename for diagnostics.
 func (c *Compiler) Compile(source string) (*vm.Chunk, error) {
-	return c.fullPipeline(source, c.Filename)
+	return c.fullPipeline(source, c.Filename, CompileOptions{})
 }
 
 // CompileWithOptions compiles source to bytecode. opts.Filename overrides Compiler.Filename for error prefixes when non-empty.
 func (c *Compiler) CompileWithOptions(source string, opts CompileOptions) (*vm.Chunk, error) {
-	return c.fullPipeline(source, c.effectiveFilename(&opts))
+	return c.fullPipeline(source, c.effectiveFilename(&opts), opts)
 }
 
-// fullPipeline is the only place that chains lexer → parser → semantic → codegen for a complete build.
-// filename is used only for error messages (may be empty).
-func (c *Compiler) fullPipeline(source, filename string) (*vm.Chunk, error) {
+// CompileExpression compiles a debugger watch expression against base, the chunk of a stopped program.
+// params are the parameter names of the Sub/Function being inspected (nil at top level). Run the result with VM.Evaluate.
+func (c *Compiler) CompileExpression(expr string, base *vm.Chunk, params []string) (*vm.Chunk, error) {
+	program, err := c.Parse("watch = " + expr)
+	if err != nil {
+		return nil, err
+	}
+	var assign *parser.Assignment
+	if len(program.Statements) == 1 {
+		assign, _ = program.Statements[0].(*parser.Assignment)
+	}
+	if assign == nil || assign.Indices != nil {
+		return nil, fmt.Errorf("not an expression: %s", expr)
+	}
+	chunk, err := codegen.EmitExpression(assign.Value, base, params)
+	if err != nil {
+		return nil, c.wrapErr("code generation error", err)
+	}
+	return chunk, nil
+}
+
+// fullPipeline is the only place that chains lexer → parser → semantic → codegen → optimizer for a complete build.
+// filename is used only for error messages (may be empty); opts.SourceMap (may be nil) is attached to the chunk.
+func (c *Compiler) fullPipeline(source, filename string, opts CompileOptions) (*vm.Chunk, error) {
 	tokens, err := lexer.New(source).Tokenize()
 	if err != nil {
 		return nil, wrapFilenameErr(filename, "lexical error", err)
@@ -117,9 +147,11 @@ func (c *Compiler) fullPipeline(source, filename string) (*vm.Chunk, error) {
 	if err != nil {
 		return nil, wrapFilenameErr(filename, "semantic error", err)
 	}
-	chunk, err := codegen.Emit(program, semResult)
+	chunk, err := codegen.EmitReload(program, semResult, opts.Base)
 	if err != nil {
 		return nil, wrapFilenameErr(filename, "code generation error", err)
 	}
+	chunk = optimizer.Optimize(chunk, opts.Optimize)
+	chunk.SourceMap = opts.SourceMap
 	return chunk, nil
 }
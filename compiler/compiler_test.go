@@ -1,9 +1,17 @@
 package compiler
 
 import (
+	"bytes"
+	"compress/gzip"
+	"cyberbasic/compiler/bindings/bullet"
 	"cyberbasic/compiler/bindings/std"
+	"cyberbasic/compiler/srcmap"
 	"cyberbasic/compiler/vm"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 func mustCompile(t *testing.T, source string) *vm.Chunk {
@@ -16,12 +24,43 @@ func mustCompile(t *testing.T, source string) *vm.Chunk {
 	return chunk
 }
 
+// noteVM returns a new VM with the foreign Note, which appends its argument to the returned log, and the
+// foreigns the register functions add.
+func noteVM(register ...func(*vm.VM)) (*vm.VM, *[]string) {
+	log := new([]string)
+	v := vm.NewVM()
+	v.RegisterForeign("Note", func(args []interface{}) (interface{}, error) {
+		*log = append(*log, fmt.Sprint(args[0]))
+		return nil, nil
+	})
+	for _, r := range register {
+		r(v)
+	}
+	return v, log
+}
+
+// runNotes compiles and runs src on a noteVM and returns what it noted, joined by "|".
+func runNotes(t *testing.T, src string, register ...func(*vm.VM)) string {
+	t.Helper()
+	v, log := noteVM(register...)
+	v.LoadChunk(mustCompile(t, src))
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	return strings.Join(*log, "|")
+}
+
+// chunkContainsOp reports whether op is emitted as an instruction (operand bytes equal to op do not count).
 func chunkContainsOp(chunk *vm.Chunk, op vm.OpCode) bool {
-	b := byte(op)
-	for _, c := range chunk.Code {
-		if c == b {
+	for off := 0; off < len(chunk.Code); {
+		in, err := chunk.DecodeInstruction(off)
+		if err != nil {
+			return false
+		}
+		if in.Op == op || op == vm.OpWide && in.Wide {
 			return true
 		}
+		off += in.Size
 	}
 	return false
 }
@@ -322,3 +361,921 @@ CloseWindow()
 		}
 	}
 }
+
+// TestCompileManyConstantsAndLongLoop checks programs past the old 256-constant and int16 jump limits.
+func TestCompileManyConstantsAndLongLoop(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("VAR total = 0\n")
+	want := 0
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "total = total + %d\n", 1000+i)
+		want += 1000 + i
+	}
+	sb.WriteString("VAR i = 0\nVAR s = 0\nWHILE i < 3\n")
+	for i := 0; i < 6000; i++ {
+		sb.WriteString("s = s + 1\n")
+	}
+	sb.WriteString("i = i + 1\nWEND\n")
+
+	chunk := mustCompile(t, sb.String())
+	if len(chunk.Constants) <= 256 {
+		t.Fatalf("expected more than 256 constants, got %d", len(chunk.Constants))
+	}
+	if !chunkContainsOp(chunk, vm.OpWide) {
+		t.Fatal("expected OpWide in compiled chunk")
+	}
+	v := vm.NewVM()
+	v.LoadChunk(chunk)
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got, _ := v.WatchValue("total"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("total = %v, want %d", got, want)
+	}
+	if got, _ := v.WatchValue("s"); fmt.Sprint(got) != "18000" {
+		t.Errorf("s = %v, want 18000", got)
+	}
+}
+
+// TestSourceMapPositions checks that runtime errors, stack traces and breakpoints use included-file positions.
+func TestSourceMapPositions(t *testing.T) {
+	// Preprocessed form of game.bas: line 2 was #include "enemies.bas" (3 lines).
+	src := "VAR d = 0\nFunction Boom(a)\n  Return a / d\nEnd Function\nVAR z = Boom(1)\n"
+	m := srcmap.New("game.bas")
+	m.Add("game.bas", 1)
+	for i := 1; i <= 3; i++ {
+		m.Add("enemies.bas", i)
+	}
+	m.Add("game.bas", 3)
+	chunk, err := New().CompileWithOptions(src, CompileOptions{Filename: "game.bas", SourceMap: m})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	v := vm.NewVM()
+	v.LoadChunk(chunk)
+	err = v.Run()
+	if err == nil || !strings.Contains(err.Error(), "enemies.bas:2: ") {
+		t.Fatalf("run error = %v, want enemies.bas:2 position", err)
+	}
+
+	v = vm.NewVM()
+	v.LoadChunk(chunk)
+	if bad := v.SetBreakpointsAt([]srcmap.Pos{{File: "enemies.bas", Line: 2}, {Line: 99}}); len(bad) != 1 || bad[0].Line != 99 {
+		t.Fatalf("unresolved breakpoints = %v", bad)
+	}
+	v.SetDebugMode(true)
+	err = v.Run()
+	bp, ok := err.(*vm.ErrBreakpoint)
+	if !ok || bp.File != "enemies.bas" || bp.Line != 2 {
+		t.Fatalf("Run() = %v, want breakpoint at enemies.bas:2", err)
+	}
+	trace := v.StackTrace()
+	if len(trace) < 2 || trace[0].Pos().String() != "enemies.bas:2" || trace[1].Pos().String() != "game.bas:3" {
+		t.Errorf("stack trace = %+v", trace)
+	}
+}
+
+// TestTryCatchFinally checks THROW and foreign errors are caught across user calls and GOSUB, FINALLY runs on
+// every exit path, and uncaught errors still abort Run with their position.
+func TestTryCatchFinally(t *testing.T) {
+	src := `Function Risky(n)
+  If n > 2 Then
+    Throw "too big"
+  End If
+  Return n
+End Function
+Sub Deep()
+  Note(Risky(5))
+End Sub
+Function Early()
+  Try
+    Return 7
+  Finally
+    Note("early finally")
+  End Try
+End Function
+Try
+  Deep()
+Catch e
+  Note("caught " + e.message + " at " + STR(e.line))
+Finally
+  Note("finally")
+End Try
+Try
+  Gosub Deep
+Catch e
+  Note("gosub " + e.message)
+End Try
+Try
+  Fail("db.sqlite")
+Catch e
+  Note(e.func + ": " + e.message)
+End Try
+Note(Early())
+For i = 1 To 3
+  Try
+    If i = 2 Then
+      Exit For
+    End If
+  Finally
+    Note("loop " + STR(i))
+  End Try
+Next
+Try
+  Try
+    Throw "inner"
+  Finally
+    Note("inner finally")
+  End Try
+Catch e
+  Note("outer " + e.message)
+End Try
+Note(Risky(1))
+`
+	got := runNotes(t, src, func(v *vm.VM) {
+		v.RegisterForeign("Fail", func(args []interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("cannot open %v", args[0])
+		})
+	})
+	want := strings.Join([]string{
+		"caught too big at 3", "finally",
+		"gosub too big",
+		"fail: cannot open db.sqlite",
+		"early finally", "7",
+		"loop 1", "loop 2",
+		"inner finally", "outer inner",
+		"1",
+	}, "|")
+	if got != want {
+		t.Errorf("log =\n  %q\nwant\n  %q", got, want)
+	}
+
+	v := vm.NewVM()
+	v.LoadChunk(mustCompile(t, "VAR a = 1\nThrow \"boom\"\n"))
+	if err := v.Run(); err == nil || !strings.Contains(err.Error(), "line 2: boom") {
+		t.Errorf("uncaught throw: err = %v", err)
+	}
+}
+
+// TestFinallyRethrowsItsOwnError checks the error pending across FINALLY belongs to its own activation when
+// FINALLY calls the same function again, and that leaving FINALLY early drops it.
+func TestFinallyRethrowsItsOwnError(t *testing.T) {
+	src := `Function Work(n)
+  Try
+    Throw "error " + STR(n)
+  Finally
+    If n < 2 Then
+      Try
+        Work(n + 1)
+      Catch e
+        Note("inner " + e.message)
+      End Try
+    End If
+  End Try
+End Function
+Function Swallow()
+  Try
+    Throw "dropped"
+  Finally
+    Return "returned"
+  End Try
+End Function
+Try
+  Work(1)
+Catch e
+  Note("outer " + e.message)
+End Try
+Note(Swallow())
+For i = 1 To 3
+  Try
+    Throw "loop"
+  Finally
+    Exit For
+  End Try
+Next
+Note("after loop")
+`
+	if got, want := runNotes(t, src), "inner error 2|outer error 1|returned|after loop"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+// TestTryCatchPerFiber checks each fiber keeps its own handlers across Yield.
+func TestTryCatchPerFiber(t *testing.T) {
+	src := `Sub Worker()
+  Try
+    Yield
+    Throw "worker"
+  Catch e
+    Note("worker caught " + e.message)
+  End Try
+End Sub
+StartCoroutine Worker()
+Try
+  Yield
+  Note("main")
+  Yield
+  Note("main again")
+Catch e
+  Note("main caught " + e.message)
+End Try
+`
+	if got, want := runNotes(t, src), "main|worker caught worker|main again"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestDebugHookInspectAndEvaluate(t *testing.T) {
+	src := `total = 10
+Function Twice(n)
+  Return n * 2
+End Function
+total = Twice(21)
+Note(total)
+`
+	v, log := noteVM()
+	chunk := mustCompile(t, src)
+	v.LoadChunk(chunk)
+	stopped := false
+	v.SetDebugHook(func(v *vm.VM) error {
+		frames := v.DebugFrames()
+		if stopped || frames[0].Func != "twice" {
+			return nil
+		}
+		stopped = true
+		if len(frames) != 2 || frames[1].Func != "" {
+			t.Fatalf("frames = %+v, want twice then main", frames)
+		}
+		if p := frames[0].Params; len(p) != 1 || p[0].Name != "n" || fmt.Sprint(p[0].Value) != "21" {
+			t.Errorf("params = %+v, want n=21", p)
+		}
+		expr, err := New().CompileExpression("n + total", chunk, chunk.Params["twice"])
+		if err != nil {
+			t.Fatalf("compile expression: %v", err)
+		}
+		got, err := v.Evaluate(expr)
+		if err != nil || fmt.Sprint(got) != "31" {
+			t.Errorf("n + total = %v, %v; want 31", got, err)
+		}
+		if fibers := v.Fibers(); len(fibers) != 1 || fibers[0].State != vm.FiberRunning || fibers[0].Line != 3 {
+			t.Errorf("fibers = %+v, want main running at line 3", fibers)
+		}
+		return nil
+	})
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !stopped {
+		t.Fatal("hook never saw Twice")
+	}
+	if got := strings.Join(*log, "|"); got != "42" {
+		t.Errorf("log = %q, want 42 (evaluation must not disturb the program)", got)
+	}
+}
+
+func TestProfilerSamplesAndForeignCalls(t *testing.T) {
+	src := `SUB Work()
+  VAR i = 0
+  WHILE i < 50000
+    i = i + 1
+  WEND
+  Nap()
+END SUB
+Work()
+`
+	v := vm.NewVM()
+	v.RegisterForeign("Nap", func(args []interface{}) (interface{}, error) {
+		time.Sleep(2 * time.Millisecond)
+		return nil, nil
+	})
+	v.LoadChunk(mustCompile(t, src))
+	p := vm.NewProfiler(50 * time.Microsecond)
+	v.SetProfiler(p)
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	p.Stop()
+
+	var nap *vm.ProfileSample
+	sampledWork := false
+	for i, s := range p.Samples() {
+		leaf := s.Stack[len(s.Stack)-1]
+		if leaf.Foreign {
+			nap = &p.Samples()[i]
+			continue
+		}
+		if len(s.Stack) == 2 && s.Stack[0].Func == "main" && leaf.Func == "work" && leaf.Line >= 3 && leaf.Line <= 5 {
+			sampledWork = true
+		}
+	}
+	if nap == nil || nap.Count != 1 || nap.Nanos < int64(2*time.Millisecond) {
+		t.Fatalf("foreign sample = %+v, want one Nap call of at least 2ms", nap)
+	}
+	if got := nap.Stack; len(got) != 3 || got[0].Func != "main" || got[0].Line != 8 || got[1].Func != "work" || got[1].Line != 6 || got[2].Func != "Nap" {
+		t.Errorf("Nap stack = %+v, want main:8 > work:6 > Nap", got)
+	}
+	if !sampledWork {
+		t.Errorf("no sample inside the Work loop: %+v", p.Samples())
+	}
+
+	var folded, report, pprof bytes.Buffer
+	if err := p.WriteFolded(&folded); err != nil || !strings.Contains(folded.String(), "main;work;Nap ") {
+		t.Errorf("folded = %q, %v; want a main;work;Nap line", folded.String(), err)
+	}
+	if err := p.WriteReport(&report); err != nil || !strings.Contains(report.String(), "Nap") || !strings.Contains(report.String(), "work") {
+		t.Errorf("report = %q, %v; want work and Nap rows", report.String(), err)
+	}
+	if err := p.WritePprof(&pprof); err != nil {
+		t.Fatalf("pprof: %v", err)
+	}
+	zr, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatalf("pprof is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil || !bytes.Contains(raw, []byte("nanoseconds")) {
+		t.Errorf("pprof payload missing sample types (%v)", err)
+	}
+}
+
+// propsDot is a DotObject that stores whatever is assigned to it.
+type propsDot map[string]vm.Value
+
+func (p propsDot) GetProp(path []string) (vm.Value, error) { return p[strings.Join(path, ".")], nil }
+func (p propsDot) SetProp(path []string, val vm.Value) error {
+	p[strings.Join(path, ".")] = val
+	return nil
+}
+func (p propsDot) CallMethod(name string, args []vm.Value) (vm.Value, error) {
+	return nil, fmt.Errorf("no method %q", name)
+}
+
+// registerHandle adds the foreign Handle, which returns a new propsDot.
+func registerHandle(v *vm.VM) {
+	v.RegisterForeign("Handle", func(args []interface{}) (interface{}, error) {
+		return propsDot{}, nil
+	})
+}
+
+func TestDotObjectPropertyAssignment(t *testing.T) {
+	src := `VAR h = Handle()
+h.speed = 3
+h.name = "hero"
+Note(h.speed)
+Note(h.name)
+`
+	if got, want := runNotes(t, src, registerHandle), "3|hero"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestXYZMemberAccess(t *testing.T) {
+	src := `VAR h = Handle()
+h.x = 4
+VAR v = Vec()
+Note(h.x)
+Note(v.x + v.y + v.z)
+`
+	got := runNotes(t, src, registerHandle, func(v *vm.VM) {
+		v.RegisterForeign("Vec", func(args []interface{}) (interface{}, error) {
+			return []interface{}{float64(1), float64(2), float64(3)}, nil
+		})
+	})
+	if want := "4|6"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestCollisionEventsCallSubs(t *testing.T) {
+	src := `SUB Landed(other, nx, ny, nz, impulse, px, py, pz)
+  Note(other)
+  Note(ny)
+  DestroyBody3D("subs3d", "ball")
+END SUB
+CreateWorld3D("subs3d", 0, -9.81, 0)
+CreateBox3D("subs3d", "floor", 0, -0.5, 0, 20, 1, 20, 0)
+CreateSphere3D("subs3d", "ball", 0, 2, 0, 0.5, 1)
+OnCollision3D("subs3d", "ball", "begin", "Landed")
+OnCollision3D("subs3d", "floor", "begin", "Landed")
+FOR i = 1 TO 120
+  Step3D("subs3d", 0.016)
+NEXT i
+DestroyWorld3D("subs3d")
+Note("done")
+`
+	// Both handlers run once, after the step, and return to the loop; the first may destroy the body.
+	if got, want := runNotes(t, src, bullet.RegisterBullet), "floor|1|ball|-1|done"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestEventBusEmitAndTimers(t *testing.T) {
+	src := `ON Score(points, who)
+  Note(points)
+  Note(who)
+  IF points > 5 THEN
+    EMIT Bonus
+  END IF
+END ON
+ON Bonus
+  Note("bonus")
+END ON
+ON Timer("once")
+  Note("timer")
+END ON
+ON Collision3D("begin", world, body)
+  Note(body)
+END ON
+emit = 3
+StartEventTimer("once", 0, 0)
+EMIT Score, 7, "ann"
+EMIT "SCORE", emit, "bob"
+ProcessEvents()
+Note("mid")
+ProcessEvents()
+ProcessEvents()
+`
+	v, log := noteVM(std.RegisterStd)
+	v.LoadChunk(mustCompile(t, src))
+	v.PostEvent("Collision3D", "stay", "w", "ignored")
+	v.PostEvent("collision3d", "BEGIN", "w", "ball")
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	// Posted events run in order, then due timers; events emitted by a handler wait for the next ProcessEvents.
+	if got, want := strings.Join(*log, "|"), "ball|7|ann|3|bob|timer|mid|bonus"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+// pollRuntime reports the events in fired through vm.EventPoller; other runtime methods are not used.
+type pollRuntime struct {
+	vm.GameRuntime
+	fired map[string][]vm.Value
+	polls int
+}
+
+func (r *pollRuntime) IsKeyDown(string) bool    { return false }
+func (r *pollRuntime) IsKeyPressed(string) bool { return false }
+func (r *pollRuntime) PollEvent(eventType, key string) (bool, []vm.Value) {
+	r.polls++
+	args, ok := r.fired[eventType+":"+key]
+	return ok, args
+}
+
+func TestEventBusPolledEvents(t *testing.T) {
+	src := `ON MouseDown("LEFT", x, y)
+  Note(x + y)
+END ON
+ON MouseDown("LEFT")
+  Note("again")
+END ON
+ON MouseDown("RIGHT")
+  Note("right")
+END ON
+ON Resize(w, h)
+  Note(w)
+END ON
+ProcessEvents()
+`
+	rt := &pollRuntime{fired: map[string][]vm.Value{"mousedown:LEFT": {10.0, 5.0}, "resize:": {800.0, 600.0}}}
+	got := runNotes(t, src, std.RegisterStd, func(v *vm.VM) { v.SetRuntime(rt) })
+	if want := "15|again|800"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	if rt.polls != 3 {
+		t.Errorf("runtime polled %d times, want once per distinct event and key (3)", rt.polls)
+	}
+}
+
+func TestCoroutineAwaitAndChannels(t *testing.T) {
+	src := `FUNCTION Square(n)
+  WaitSeconds(0.01)
+  RETURN n * n
+END FUNCTION
+SUB Producer(ch, count)
+  FOR k = 1 TO count
+    AWAIT ChanSend(ch, k * 10)
+  NEXT k
+  ChanClose(ch)
+END SUB
+await = 2
+ch = ChanCreate()
+t = StartCoroutine Square(7)
+StartCoroutine Producer(ch, 3)
+Note(IsDone(t))
+Note(AWAIT t)
+Note(IsDone(t))
+FOR j = 1 TO 3
+  Note(AWAIT ChanReceive(ch))
+NEXT j
+TRY
+  x = AWAIT ChanReceive(ch)
+CATCH e
+  Note(e.message)
+END TRY
+buf = ChanCreate(2)
+ChanSend(buf, "a")
+ChanSend(buf, "b")
+Note(ChanCount(buf))
+sel = ChanSelect(1, ChanCreate(), buf)
+Note(AWAIT sel)
+Note(ChanSelectValue(sel))
+Note(AWAIT ChanSelect(0.01, ChanCreate()))
+Note(AWAIT Slow(await))
+`
+	got := runNotes(t, src, std.RegisterStd, func(v *vm.VM) {
+		v.RegisterForeign("Slow", func(args []interface{}) (interface{}, error) {
+			n := args[0]
+			return vm.Go("slow", func() (vm.Value, error) {
+				time.Sleep(5 * time.Millisecond)
+				return fmt.Sprintf("slow %v", n), nil
+			}), nil
+		})
+	})
+	if want := "false|49|true|10|20|30|receive from closed channel|2|2|a|0|slow 2"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+// TestAwaitRunsOnAwaited checks AWAIT tells a Future it was taken, whether it had to wait or not, and that
+// polling it with IsDone does not.
+func TestAwaitRunsOnAwaited(t *testing.T) {
+	src := `Sub Worker()
+  Note(AWAIT Job("slow"))
+End Sub
+w = StartCoroutine Worker()
+j = Job("fast")
+WHILE NOT IsDone(j)
+  WaitSeconds(0.001)
+WEND
+Note(AWAIT j)
+AWAIT w
+`
+	var taken []string
+	got := runNotes(t, src, std.RegisterStd, func(v *vm.VM) {
+		v.RegisterForeign("Job", func(args []interface{}) (interface{}, error) {
+			name := fmt.Sprint(args[0])
+			f := vm.Go(name, func() (vm.Value, error) {
+				if name == "slow" {
+					time.Sleep(5 * time.Millisecond)
+				}
+				return name + " done", nil
+			})
+			f.OnAwaited(func() { taken = append(taken, name) })
+			return f, nil
+		})
+	})
+	if want := "fast done|slow done"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	if got := strings.Join(taken, "|"); got != "fast|slow" {
+		t.Errorf("OnAwaited ran for %q, want fast|slow", got)
+	}
+}
+
+func TestAwaitInsideHandler(t *testing.T) {
+	src := `SUB Forever()
+  WaitSeconds(10)
+END SUB
+ON Ping
+  Note(AWAIT Slow())
+  AWAIT task
+END ON
+task = StartCoroutine Forever()
+EMIT Ping
+ProcessEvents()
+`
+	v, log := noteVM(std.RegisterStd, func(v *vm.VM) {
+		v.RegisterForeign("Slow", func(args []interface{}) (interface{}, error) {
+			return vm.Go("slow", func() (vm.Value, error) { return "done", nil }), nil
+		})
+	})
+	v.LoadChunk(mustCompile(t, src))
+	err := v.Run()
+	// A handler the runtime calls cannot suspend: futures are waited for, tasks are an error.
+	if err == nil || !strings.Contains(err.Error(), "await it from a coroutine") {
+		t.Fatalf("run error = %v, want an AWAIT inside a handler error", err)
+	}
+	if got := strings.Join(*log, "|"); got != "done" {
+		t.Errorf("log = %q, want the future awaited inside the handler", got)
+	}
+}
+
+func TestFunctionValuesAndClosures(t *testing.T) {
+	src := `FUNCTION Twice(f, x)
+  RETURN f(f(x))
+END FUNCTION
+FUNCTION AddOne(n)
+  RETURN n + 1
+END FUNCTION
+FUNCTION MakeCounter(start)
+  RETURN FUNCTION()
+    start = start + 1
+    RETURN start
+  END FUNCTION
+END FUNCTION
+FUNCTION Adder(k)
+  RETURN FUNCTION(x) x + k
+END FUNCTION
+SUB Hello(name)
+  Note("hello " + name)
+END SUB
+Note(Twice(AddOne, 5))
+c = MakeCounter(10)
+c()
+Note(c())
+d = MakeCounter(0)
+Note(d())
+Note(Twice(Adder(3), 1))
+h = Hello
+h("bob")
+Note(h)
+sq = FUNCTION(n) AS INTEGER
+  RETURN n * n
+END FUNCTION
+Note(sq(9))
+Note(Apply(sq, 4))
+Note(Apply("AddOne", 4))
+Note(Apply(FUNCTION(s) s + "!", "hi"))
+Keep(c)
+`
+	var kept vm.Value
+	v, log := noteVM(func(v *vm.VM) {
+		v.RegisterForeign("Apply", func(args []interface{}) (interface{}, error) {
+			return v.Invoke(vm.Callback(args[0]), args[1:])
+		})
+		v.RegisterForeign("Keep", func(args []interface{}) (interface{}, error) {
+			kept = vm.Callback(args[0])
+			return nil, nil
+		})
+	})
+	v.LoadChunk(mustCompile(t, src))
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := "7|12|1|7|hello bob|function hello|81|16|5|hi!"
+	if got := strings.Join(*log, "|"); got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+	// A closure kept by a binding is called after the program ended, the way physics and net callbacks are.
+	if got, err := v.Invoke(kept, nil); err != nil || fmt.Sprint(got) != "13" {
+		t.Errorf("Invoke(kept counter) = %v, %v, want 13", got, err)
+	}
+	if _, err := v.Invoke(42, nil); err == nil {
+		t.Error("Invoke(42) did not report a non-callable value")
+	}
+}
+
+func TestTypeMethodsAndConstructors(t *testing.T) {
+	src := `TYPE Vec
+  px AS FLOAT
+  py AS FLOAT
+END TYPE
+TYPE Player
+  name AS STRING
+  hp AS INTEGER
+  pos AS Vec
+  onHit
+  SUB New(n, h)
+    ME.name = n
+    ME.hp = h
+  END SUB
+  SUB Hurt(d)
+    SELF.hp = SELF.hp - d
+    IF ME.hp <= 0 THEN
+      ME.Die()
+    ENDIF
+  END SUB
+  SUB Die()
+    ME.hp = 0
+    Note(ME.name + " died")
+  END SUB
+  FUNCTION Describe() AS STRING
+    RETURN ME.name + ":" + STR(ME.hp)
+  END FUNCTION
+  SUB MoveBy(dx, dy)
+    ME.pos.px = ME.pos.px + dx
+    ME.pos.py = ME.pos.py + dy
+  END SUB
+END TYPE
+p = Player("bob", 10)
+p.Hurt(3)
+Note(p.Describe())
+p.MoveBy(1.5, 2)
+p.MoveBy(1, 1)
+Note(p.pos)
+DIM q AS Player
+Note(q.Describe())
+r = p
+r.Hurt(100)
+Note(Player.Describe(p))
+p.onHit = FUNCTION(n) n * 2
+Note(p.onHit(21))
+`
+	want := "bob:7|vec{px: 2.5, py: 3}|:0|bob died|bob:0|42"
+	if got := runNotes(t, src); got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+
+	v := vm.NewVM()
+	v.LoadChunk(mustCompile(t, "TYPE T\n  n\nEND TYPE\nx = T()\nx.Missing()\n"))
+	if err := v.Run(); err == nil || !strings.Contains(err.Error(), "has no method missing") {
+		t.Errorf("calling an unknown method on a dynamic variable: err = %v", err)
+	}
+}
+
+func TestListsAndMaps(t *testing.T) {
+	src := `nums = [5, 3, 9]
+nums.add(1)
+nums[1] = 4
+Note(STR(nums[0] + nums[-1]) + " " + STR(LEN(nums)) + " " + STR(nums.length))
+total = 0
+FOR EACH n IN nums
+  IF n = 9 THEN
+    CONTINUE FOR
+  ENDIF
+  total = total + n
+NEXT
+Note(total)
+nums.sort()
+Note(nums)
+nums.sort(FUNCTION(a, b) a > b)
+Note(nums)
+Note(nums.filter(FUNCTION(x) x > 4))
+Note(nums.map(FUNCTION(x) x * 10))
+Note(nums[1:3])
+ages = ["bob": 7, "amy": 9]
+ages["cy"] = 3
+ages.remove("bob")
+FOR EACH k, v IN ages
+  Note(k + "=" + STR(v))
+NEXT
+FOR EACH k IN ages
+  Note(k)
+  EXIT FOR
+NEXT
+Note(ages["nobody"] = NIL)
+a = [1, [2, 3], ["k": [4]]]
+b = a.copy()
+Note(a = b)
+inner = b[1]
+inner[0] = 99
+Note(a = b)
+c = a
+c.add(5)
+Note(a)
+Note([1, 2] = [1.0, 2])
+Note([1, 2] <> [2, 1])
+SUB Sum(xs)
+  s = 0
+  FOR EACH x IN xs
+    s = s + x
+  NEXT
+  Note(s)
+END SUB
+Sum([1, 2, 3])
+`
+	got := runNotes(t, src)
+	want := strings.Join([]string{
+		"6 4 4", "10", "[1, 4, 5, 9]", "[9, 5, 4, 1]", "[9, 5]", "[90, 50, 40, 10]", "[5, 4]",
+		"amy=9", "cy=3", "amy", "true", "true", "false", `[1, [2, 3], ["k": [4]], 5]`, "true", "true",
+		"6",
+	}, "|")
+	if got != want {
+		t.Errorf("log = %q\nwant  %q", got, want)
+	}
+
+	for src, msg := range map[string]string{
+		"x = [1, 2]\nNote(x[2])\n":                    "out of range",
+		"m = [:]\nm[[1]] = 2\n":                       "MAP keys must be",
+		"FOR EACH n IN 5\nNEXT\n":                     "cannot iterate a number",
+		"x = [\"a\", 1]\nx.sort()\n":                  "cannot compare",
+		"x = [1]\nx.shuffle()\n":                      "has no method shuffle",
+		"x = [1, 2]\nx.sort(FUNCTION(a, b) \"no\")\n": "comparator must return",
+	} {
+		v, _ := noteVM()
+		v.LoadChunk(mustCompile(t, src))
+		if err := v.Run(); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%q: err = %v, want %q", src, err, msg)
+		}
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	v1 := `score = 0
+SUB update(dt)
+  score = score + 1
+END SUB
+FUNCTION Label()
+  RETURN "v1"
+END FUNCTION
+old = FUNCTION(x) x + 1
+update(0)
+Keep(old)
+`
+	v2 := `score = 0
+SUB update(dt)
+  bonus = 10
+  TRY
+    score = score + bonus * dt
+  CATCH e
+    Note(e.message)
+  END TRY
+END SUB
+FUNCTION Label()
+  f = FUNCTION(s) s + "!"
+  RETURN f("v2")
+END FUNCTION
+SUB Broken()
+  x = [1]
+  Note(x[5])
+END SUB
+FUNCTION Score()
+  RETURN score
+END FUNCTION
+old = FUNCTION(x) x + 1
+`
+	var kept vm.Value
+	v, _ := noteVM(func(v *vm.VM) {
+		v.RegisterForeign("Keep", func(args []interface{}) (interface{}, error) {
+			kept = vm.Callback(args[0])
+			return nil, nil
+		})
+	})
+	v.SetSpareVariables(8)
+	v.LoadChunk(mustCompile(t, v1))
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	// Variables and constants keep their slots only when the new build is compiled against the running one.
+	if err := v.Reload(mustCompile(t, "label = 1\n"+v2)); err == nil || !strings.Contains(err.Error(), "different slot") {
+		t.Errorf("Reload of an unrelated build: err = %v", err)
+	}
+	next, err := New().CompileWithOptions(v2, CompileOptions{Base: v.Chunk()})
+	if err != nil {
+		t.Fatalf("compile v2: %v", err)
+	}
+	if err := v.Reload(next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if err := v.InvokeSub("update", []interface{}{2}); err != nil {
+		t.Fatalf("update after reload: %v", err)
+	}
+	if got, err := v.Invoke("Score", nil); err != nil || fmt.Sprint(got) != "21" {
+		t.Errorf("score = %v, %v, want 21 (1 from the old update, then 20 from the new one)", got, err)
+	}
+	if got, err := v.Invoke("Label", nil); err != nil || got != "v2!" {
+		t.Errorf("Label() = %v, %v, want v2!", got, err)
+	}
+	if got, err := v.Invoke(kept, []interface{}{5}); err != nil || fmt.Sprint(got) != "6" {
+		t.Errorf("old function value = %v, %v, want 6", got, err)
+	}
+	// Errors in the new code point at the new source.
+	if err := v.InvokeSub("Broken", nil); err == nil || !strings.Contains(err.Error(), "line 16") {
+		t.Errorf("Broken() err = %v, want an error at line 16", err)
+	}
+
+	w := vm.NewVM()
+	w.LoadChunk(mustCompile(t, v1))
+	next, err = New().CompileWithOptions(v2, CompileOptions{Base: w.Chunk()})
+	if err != nil {
+		t.Fatalf("compile v2: %v", err)
+	}
+	if err := w.Reload(next); err == nil || !strings.Contains(err.Error(), "restart") {
+		t.Errorf("Reload adding variables without spare slots: err = %v", err)
+	}
+}
+
+// TestHotReloadRepeatedly reloads the same program until the running code is past the 16-bit target range, the
+// way --watch does on every save.
+func TestHotReloadRepeatedly(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("total = 0\nFUNCTION Work(n)\n  add = FUNCTION(a) a + n\n  TRY\n")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&b, "    total = total + add(%d)\n", i)
+	}
+	b.WriteString("  CATCH e\n    total = -1\n  END TRY\n  RETURN total\nEND FUNCTION\n")
+	src := b.String()
+
+	v := vm.NewVM()
+	v.LoadChunk(mustCompile(t, src))
+	if err := v.Run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for i := 1; len(v.Chunk().Code) <= 2*0xFFFF; i++ {
+		next, err := New().CompileWithOptions(src, CompileOptions{Base: v.Chunk()})
+		if err != nil {
+			t.Fatalf("compile reload %d: %v", i, err)
+		}
+		if err := v.Reload(next); err != nil {
+			t.Fatalf("reload %d after %d bytes: %v", i, len(v.Chunk().Code), err)
+		}
+		// Each call adds 1+2+…+300 to the global total.
+		if got, err := v.Invoke("Work", []interface{}{1}); err != nil || fmt.Sprint(got) != fmt.Sprint(45150*i) {
+			t.Fatalf("Work() after reload %d = %v, %v, want %d", i, got, err, 45150*i)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package compiler
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite testdata/disasm/*.golden from the current compiler output")
+
+// TestDisassemblyGolden compiles every testdata/disasm/*.bas and compares its listing with the .golden file next to it.
+// After an intended codegen change, run `go test ./compiler -run Golden -update` and review the diff.
+func TestDisassemblyGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "disasm", "*.bas"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no golden programs: %v", err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := vm.Disassemble(&got, mustCompile(t, string(src))); err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(file, ".bas") + ".golden"
+			if *updateGolden {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("disassembly of %s changed; run with -update if intended.\ngot:\n%s\nwant:\n%s", file, got.String(), want)
+			}
+		})
+	}
+}
@@ -8,13 +8,16 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
+	"cyberbasic/compiler/srcmap"
+
 	"github.com/rhysd/locerr"
 )
 
-// linePrefix matches "line 123: " at the start of a segment (we look for the last occurrence in the chain).
-var lineRegex = regexp.MustCompile(`line (\d+): (.+)`)
+// linePrefix matches "line 123: " (or the parser's "line 123, col 4: ") at the start of a segment (we look for the last occurrence in the chain).
+var lineRegex = regexp.MustCompile(`line (\d+)(?:, col (\d+))?: (.+)`)
 
 // PrettyPrint writes the compilation error to w. If the error chain contains
 // a "line N: message" segment (from codegen.errWithLine), it uses locerr to
@@ -22,22 +25,48 @@ var lineRegex = regexp.MustCompile(`line (\d+): (.+)`)
 // error as-is. source is the full file content; filename is used only in the
 // locerr source name when non-empty.
 func PrettyPrint(w io.Writer, source string, filename string, err error) {
+	PrettyPrintMapped(w, source, filename, nil, err)
+}
+
+// PrettyPrintMapped is PrettyPrint for preprocessed source: m maps the error's line back to the
+// included file and line, and the snippet is taken from that file (m may be nil). Errors joined
+// with errors.Join (semantic analysis reports all of its errors at once) are printed one by one.
+func PrettyPrintMapped(w io.Writer, source string, filename string, m *srcmap.Map, err error) {
 	if err == nil {
 		return
 	}
-	msg := err.Error()
-	line, rest := parseLineFromError(msg)
+	for _, e := range splitErrors(err) {
+		printOne(w, source, filename, m, e)
+	}
+}
+
+func printOne(w io.Writer, source string, filename string, m *srcmap.Map, err error) {
+	line, col, rest := parseLineFromError(err.Error())
 	if line <= 0 || source == "" {
 		fmt.Fprintf(w, "Compilation error: %v\n", err)
 		return
 	}
-	_ = filename // locerr.NewDummySource does not take a name; snippet still shows line/col
 	src := locerr.NewDummySource(source)
+	if m != nil {
+		pos := m.Lookup(line)
+		source = m.FileText(source, pos.File)
+		src = &locerr.Source{Path: pos.File, Code: []byte(source)}
+		line = pos.Line
+	} else if filename != "" {
+		src.Path = filename
+	}
 	offset := lineToOffset(source, line)
+	lineEnd := len(source)
+	if i := strings.IndexByte(source[offset:], '\n'); i >= 0 {
+		lineEnd = offset + i
+	}
+	if col <= 0 || offset+col-1 > lineEnd {
+		col = 1
+	}
 	pos := locerr.Pos{
-		Offset: offset,
+		Offset: offset + col - 1,
 		Line:   line,
-		Column: 1,
+		Column: col,
 		File:   src,
 	}
 	locErr := locerr.ErrorAt(pos, strings.TrimSpace(rest))
@@ -48,27 +77,45 @@ func PrettyPrint(w io.Writer, source string, filename string, err error) {
 	}
 }
 
-// parseLineFromError finds the last "line N: message" in the error chain and returns (N, message).
-// If none found, returns (0, "").
-func parseLineFromError(errMsg string) (line int, message string) {
+// splitErrors flattens errors.Join results, also under the "file: phase: " prefix added by the compiler driver.
+func splitErrors(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []error
+		for _, e := range j.Unwrap() {
+			out = append(out, splitErrors(e)...)
+		}
+		return out
+	}
+	if u, ok := err.(interface{ Unwrap() error }); ok {
+		if inner := u.Unwrap(); inner != nil {
+			if parts := splitErrors(inner); len(parts) > 1 {
+				return parts
+			}
+		}
+	}
+	return []error{err}
+}
+
+// parseLineFromError finds the last "line N: message" (or "line N, col C: message") in the error chain
+// and returns (N, C, message); C is 0 when the error carries no column. If none found, returns (0, 0, "").
+func parseLineFromError(errMsg string) (line, col int, message string) {
 	// Unwrap chain: "file: phase: line 5: msg" -> we want the last "line N: msg"
 	idx := strings.LastIndex(errMsg, "line ")
 	if idx < 0 {
-		return 0, ""
+		return 0, 0, ""
 	}
-	sub := errMsg[idx:]
-	if !lineRegex.MatchString(sub) {
-		return 0, ""
+	matches := lineRegex.FindStringSubmatch(errMsg[idx:])
+	if len(matches) != 4 {
+		return 0, 0, ""
 	}
-	matches := lineRegex.FindStringSubmatch(sub)
-	if len(matches) != 3 {
-		return 0, ""
+	n, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, 0, ""
 	}
-	var n int
-	if _, err := fmt.Sscanf(matches[1], "%d", &n); err != nil {
-		return 0, ""
+	if matches[2] != "" {
+		col, _ = strconv.Atoi(matches[2])
 	}
-	return n, matches[2]
+	return n, col, matches[3]
 }
 
 func lineToOffset(source string, line int) int {
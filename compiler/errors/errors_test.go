@@ -0,0 +1,67 @@
+package errors
+
+import (
+	"bytes"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+func TestParseLineFromError(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		line int
+		col  int
+		rest string
+	}{
+		{"game.bas: code generation error: line 7: unknown sub", 7, 0, "unknown sub"},
+		{"game.bas: parse error: Parse error at line 12, col 3: unexpected token", 12, 3, "unexpected token"},
+		{"no position here", 0, 0, ""},
+	} {
+		line, col, rest := parseLineFromError(tt.msg)
+		if line != tt.line || col != tt.col || rest != tt.rest {
+			t.Errorf("parseLineFromError(%q) = %d, %d, %q; want %d, %d, %q", tt.msg, line, col, rest, tt.line, tt.col, tt.rest)
+		}
+	}
+}
+
+func TestPrettyPrintMapped(t *testing.T) {
+	// game.bas line 2 was `#include "lib.bas"`; lib.bas lines 1-2 are spliced in its place.
+	source := "PRINT 1\nREM lib\nx = = 2\nPRINT 3\n"
+	m := srcmap.New("game.bas")
+	m.Add("game.bas", 1)
+	m.Add("lib.bas", 1)
+	m.Add("lib.bas", 2)
+	m.Add("game.bas", 3)
+
+	var buf bytes.Buffer
+	PrettyPrintMapped(&buf, source, "game.bas", m, fmt.Errorf("parse error: line 3: unexpected ="))
+	out := buf.String()
+	if !strings.Contains(out, "lib.bas:2") {
+		t.Errorf("output should point at lib.bas:2:\n%s", out)
+	}
+	if !strings.Contains(out, "x = = 2") {
+		t.Errorf("output should show the offending line:\n%s", out)
+	}
+}
+
+func TestPrettyPrintJoined(t *testing.T) {
+	source := "FUNCTION Add(a, b)\n  RETURN a + b\nEND FUNCTION\nPRINT Add(1)\nDIM s AS STRING\ns = 5\n"
+	joined := stderrors.Join(
+		fmt.Errorf("line 4, col 7: Add expects 2 arguments, got 1"),
+		fmt.Errorf("line 6, col 1: cannot assign INTEGER to s (STRING)"),
+	)
+	err := fmt.Errorf("game.bas: semantic error: %w", joined)
+
+	var buf bytes.Buffer
+	PrettyPrint(&buf, source, "game.bas", err)
+	out := buf.String()
+	for _, want := range []string{"game.bas:4:7", "Add expects 2 arguments", "game.bas:6:1", "cannot assign INTEGER"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+}
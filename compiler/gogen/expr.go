@@ -0,0 +1,517 @@
+package gogen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler/codegen"
+	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/vm"
+)
+
+// expr returns the Go expression of a BASIC expression, as codegen's compileExpression compiles it.
+func (t *translator) expr(node parser.Node) (string, error) {
+	switch n := node.(type) {
+	case *parser.Number:
+		v, err := codegen.NumberValue(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return literal(v), nil
+	case *parser.StringLiteral:
+		return literal(n.Value), nil
+	case *parser.Boolean:
+		return literal(n.Value), nil
+	case *parser.NilLiteral:
+		return "nil", nil
+	case *parser.Identifier:
+		return t.identifier(n)
+	case *parser.BinaryOp:
+		left, err := t.expr(n.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := t.expr(n.Right)
+		if err != nil {
+			return "", err
+		}
+		op, ok := codegen.BinaryOp(n.Operator)
+		if !ok {
+			return "", withLine(n, fmt.Errorf("unsupported binary operator: %s", n.Operator))
+		}
+		return t.op(op, left, right), nil
+	case *parser.UnaryOp:
+		x, err := t.expr(n.Operand)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case n.Operator == "-":
+			return t.op(vm.OpNeg, x), nil
+		case strings.EqualFold(n.Operator, "NOT"):
+			return t.op(vm.OpNot, x), nil
+		}
+		return "", withLine(n, fmt.Errorf("unsupported unary operator: %s", n.Operator))
+	case *parser.Call:
+		return t.call(n)
+	case *parser.MemberAccess:
+		return t.member(n)
+	case *parser.JSONIndexAccess:
+		obj, err := t.expr(n.Object)
+		if err != nil {
+			return "", err
+		}
+		return t.op(vm.OpIndex, obj, literal(n.Key)), nil
+	case *parser.SliceExpr:
+		return t.slice(n)
+	case *parser.InterpolatedString:
+		return t.interpolated(n)
+	case *parser.DictLiteral:
+		dict := `rt.Foreign("createdict")`
+		for _, p := range n.Pairs {
+			val, err := t.expr(p.Value)
+			if err != nil {
+				return "", err
+			}
+			dict = fmt.Sprintf(`rt.Foreign("setdictkey", %s, %q, %s)`, dict, p.Key, val)
+		}
+		return dict, nil
+	case *parser.ListLiteral:
+		items, err := t.exprList(n.Elements)
+		if err != nil {
+			return "", err
+		}
+		return "rt.List(" + strings.Join(items, ", ") + ")", nil
+	case *parser.MapLiteral:
+		kv := make([]string, 0, 2*len(n.Keys))
+		for i := range n.Keys {
+			k, err := t.expr(n.Keys[i])
+			if err != nil {
+				return "", err
+			}
+			v, err := t.expr(n.Values[i])
+			if err != nil {
+				return "", err
+			}
+			kv = append(kv, k, v)
+		}
+		return "rt.Map(" + strings.Join(kv, ", ") + ")", nil
+	case *parser.StartCoroutineStatement:
+		return t.startTask(n)
+	case *parser.AwaitExpression:
+		x, err := t.expr(n.Value)
+		if err != nil {
+			return "", err
+		}
+		return "rt.Await(" + x + ")", nil
+	case *parser.FunctionExpression:
+		return t.functionExpr(n)
+	default:
+		return "", withLine(node, fmt.Errorf("unsupported expression type: %T", node))
+	}
+}
+
+func (t *translator) exprList(nodes []parser.Node) ([]string, error) {
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		x, err := t.expr(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = x
+	}
+	return out, nil
+}
+
+// op returns the Go expression applying the instruction op to args.
+func (t *translator) op(op vm.OpCode, args ...string) string {
+	return "rt.Op(" + opArgs(op, args) + ")"
+}
+
+// identifier resolves a name as codegen's compileIdentifier does: parameter, variable, CONST or ENUM member,
+// Sub or Function (as a value), namespace constant (RL.DarkGray), else a global of the bindings.
+func (t *translator) identifier(id *parser.Identifier) (string, error) {
+	if g, ok := t.param(id.Name); ok {
+		return g, nil
+	}
+	if t.isVar(id.Name) {
+		return goName("v_", id.Name), nil
+	}
+	lower := strings.ToLower(id.Name)
+	if c, ok := t.consts[lower]; ok {
+		return c, nil
+	}
+	if t.sem.UserFuncs[lower] {
+		return fmt.Sprintf("rt.Ref(%q)", lower), nil
+	}
+	if name, ok := codegen.NamespaceConstant(lower); ok {
+		return fmt.Sprintf("rt.Foreign(%q)", name), nil
+	}
+	return fmt.Sprintf("rt.Global(%q)", id.Name), nil
+}
+
+// call translates a call as codegen's compileCall resolves it: array element, user Sub or Function, method,
+// foreign function, PRINT, MatMul, TYPE constructor, builtin, or a function value held by a variable.
+func (t *translator) call(c *parser.Call) (string, error) {
+	if len(c.Arguments) == 0 && strings.EqualFold(c.Name, "shouldclose") {
+		return t.op(vm.OpShouldClose), nil
+	}
+	name := strings.ToLower(c.Name)
+	if !strings.Contains(c.Name, ".") {
+		dims, hasDims := t.dims[name]
+		if hasDims && (len(dims) == len(c.Arguments) || (len(dims) == 0 && len(c.Arguments) == 1)) {
+			indices, err := t.exprList(c.Arguments)
+			if err != nil {
+				return "", err
+			}
+			return t.load(c.Name, indices), nil
+		}
+	}
+
+	if strings.Contains(c.Name, ".") {
+		if t.sem.UserFuncs[name] {
+			args, err := t.exprList(c.Arguments)
+			if err != nil {
+				return "", err
+			}
+			return t.userCall(name, args), nil
+		}
+		parts := strings.Split(c.Name, ".")
+		first := strings.ToLower(parts[0])
+		flat := codegen.PhysicsFlatName(name)
+		if flat == "" && (!namespaceRoot(first) || first == "bullet") {
+			return t.methodCall(c, parts)
+		}
+		args, err := t.exprList(c.Arguments)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case flat != "":
+			name = flat
+		case strings.HasPrefix(name, "rl."):
+			name = name[3:]
+		}
+		return foreign(name, args), nil
+	}
+
+	switch name {
+	case "print":
+		prints := make([]string, len(c.Arguments))
+		for i, arg := range c.Arguments {
+			x, err := t.expr(arg)
+			if err != nil {
+				return "", err
+			}
+			prints[i] = t.op(vm.OpPrint, x)
+		}
+		switch len(prints) {
+		case 0:
+			return "nil", nil
+		case 1:
+			return prints[0], nil
+		}
+		return "func() vm.Value {\n" + strings.Join(prints, "\n") + "\nreturn nil\n}()", nil
+	case "matmul":
+		if len(c.Arguments) != 3 {
+			return "", fmt.Errorf("MatMul(resultName, aName, bName) expects 3 arguments")
+		}
+		var arrays, dims []string
+		for _, arg := range c.Arguments {
+			var n string
+			switch v := arg.(type) {
+			case *parser.Identifier:
+				n = v.Name
+			case *parser.StringLiteral:
+				n = v.Value
+			default:
+				return "", fmt.Errorf("MatMul: all 3 args must be variable names or string literals")
+			}
+			arrays = append(arrays, t.declare(n))
+			dims = append(dims, t.dimsVar(n))
+		}
+		return "rt.MatMul(" + strings.Join(append(arrays, dims...), ", ") + ")", nil
+	}
+
+	if td, ok := t.sem.TypeDefs[name]; ok && !t.sem.UserFuncs[name] {
+		return t.constructor(c, td)
+	}
+	args, err := t.exprList(c.Arguments)
+	if err != nil {
+		return "", err
+	}
+	if t.sem.UserFuncs[name] {
+		return t.userCall(name, args), nil
+	}
+	if op, ok, err := codegen.Builtin(name, len(args)); ok {
+		if err != nil {
+			return "", err
+		}
+		if op == vm.OpQuit {
+			return "rt.Quit()", nil
+		}
+		return t.op(op, args...), nil
+	}
+	name = strings.TrimPrefix(name, "rl.")
+	callee, ok := t.param(c.Name)
+	if !ok && t.isVar(c.Name) {
+		callee, ok = goName("v_", c.Name), true
+	}
+	if ok {
+		return "rt.CallValue(" + strings.Join(append([]string{callee, literal(name)}, args...), ", ") + ")", nil
+	}
+	return foreign(name, args), nil
+}
+
+// foreign returns the Go call of the foreign function name with args.
+func foreign(name string, args []string) string {
+	return "rt.Foreign(" + strings.Join(append([]string{literal(name)}, args...), ", ") + ")"
+}
+
+// load returns the element at indices of the DIM array name.
+func (t *translator) load(name string, indices []string) string {
+	return "rt.Load(" + strings.Join(append([]string{goName("v_", name), t.dimsVar(name)}, indices...), ", ") + ")"
+}
+
+// userCall returns the Go call of the user Sub or Function name with args, laid out for its parameters:
+// missing arguments are nil and extra ones are evaluated and dropped, as for a bytecode call.
+func (t *translator) userCall(name string, args []string) string {
+	fn, params := goName("f_", name), t.params[name]
+	if len(args) > params {
+		frame := make([]string, params)
+		for i := range frame {
+			frame[i] = fmt.Sprintf("a[%d]", i)
+		}
+		return fmt.Sprintf("func(a ...vm.Value) vm.Value {\nreturn %s(%s)\n}(%s)", fn, strings.Join(frame, ", "), strings.Join(args, ", "))
+	}
+	for len(args) < params {
+		args = append(args, "nil")
+	}
+	return fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+// methodCall translates obj.path.method(args) on a TYPE instance, LIST or API handle.
+func (t *translator) methodCall(c *parser.Call, parts []string) (string, error) {
+	obj, err := t.identifier(&parser.Identifier{Name: parts[0], Line: c.Line, Col: c.Col})
+	if err != nil {
+		return "", err
+	}
+	if path := parts[1 : len(parts)-1]; len(path) > 0 {
+		p, err := propPath(path)
+		if err != nil {
+			return "", err
+		}
+		obj = "rt.GetProp(" + obj + ", " + p + ")"
+	}
+	args, err := t.exprList(c.Arguments)
+	if err != nil {
+		return "", err
+	}
+	method := literal(strings.ToLower(parts[len(parts)-1]))
+	return "rt.Method(" + strings.Join(append([]string{obj, method}, args...), ", ") + ")", nil
+}
+
+// constructor translates TypeName(args): a new instance, passed as ME with args to the type's New method
+// when it has one.
+func (t *translator) constructor(c *parser.Call, td *parser.TypeDecl) (string, error) {
+	typeName := strings.ToLower(td.Name)
+	ctor := typeName + ".new"
+	if !t.sem.UserFuncs[ctor] {
+		if len(c.Arguments) > 0 {
+			return "", withLine(c, fmt.Errorf("%s has no New method and takes no arguments", td.Name))
+		}
+		return fmt.Sprintf("rt.New(%q)", typeName), nil
+	}
+	args, err := t.exprList(c.Arguments)
+	if err != nil {
+		return "", err
+	}
+	call := t.userCall(ctor, append([]string{"o"}, args...))
+	return fmt.Sprintf("func(o vm.Value) vm.Value {\n%s\nreturn o\n}(rt.New(%q))", call, typeName), nil
+}
+
+// startTask translates StartCoroutine Sub(args), which evaluates to the coroutine's task.
+func (t *translator) startTask(s *parser.StartCoroutineStatement) (string, error) {
+	name := strings.ToLower(s.SubName)
+	if !t.sem.UserFuncs[name] {
+		return "", fmt.Errorf("unknown sub for StartCoroutine: %s", s.SubName)
+	}
+	if len(s.Args) > 255 {
+		return "", fmt.Errorf("StartCoroutine %s: too many arguments (%d, max 255)", s.SubName, len(s.Args))
+	}
+	args, err := t.exprList(s.Args)
+	if err != nil {
+		return "", err
+	}
+	return "rt.Start(" + strings.Join(append([]string{fmt.Sprintf("rt.Ref(%q)", name)}, args...), ", ") + ")", nil
+}
+
+// member translates expr.member as codegen's compileMemberAccess does.
+func (t *translator) member(m *parser.MemberAccess) (string, error) {
+	var segs []string
+	base := parser.Node(m)
+	for {
+		ma, ok := base.(*parser.MemberAccess)
+		if !ok {
+			break
+		}
+		segs = append([]string{ma.Member}, segs...)
+		base = ma.Object
+	}
+	mb := strings.ToLower(segs[len(segs)-1])
+	if id, ok := base.(*parser.Identifier); ok {
+		objLower := strings.ToLower(id.Name)
+		if t.sem.EntityNames[objLower] && len(segs) == 1 {
+			return fmt.Sprintf("rt.EntityProp(%q, %q)", objLower, mb), nil
+		}
+		if td, ok := t.sem.TypeDefs[objLower]; ok && len(segs) == 1 {
+			if val, err := codegen.UDTConstant(td, mb); err == nil {
+				return literal(val), nil
+			}
+		}
+		if namespaceRoot(objLower) && len(segs) == 1 && mb != "x" && mb != "y" && mb != "z" {
+			return foreign(mb, nil), nil
+		}
+		if codegen.IsDotObjectRoot(id.Name) {
+			obj, err := t.identifier(id)
+			if err != nil {
+				return "", err
+			}
+			path, err := propPath(segs)
+			if err != nil {
+				return "", err
+			}
+			return "rt.GetProp(" + obj + ", " + path + ")", nil
+		}
+	}
+	obj, err := t.expr(base)
+	if err != nil {
+		return "", err
+	}
+	path, err := propPath(segs)
+	if err != nil {
+		return "", err
+	}
+	return "rt.GetProp(" + obj + ", " + path + ")", nil
+}
+
+// slice translates s[start:end], s[i], coll[i] and arr[i, j].
+func (t *translator) slice(n *parser.SliceExpr) (string, error) {
+	arrayDims := func() ([]int, bool) {
+		id, ok := n.Object.(*parser.Identifier)
+		if !ok || strings.Contains(id.Name, ".") {
+			return nil, false
+		}
+		dims, ok := t.dims[strings.ToLower(id.Name)]
+		return dims, ok
+	}
+	if n.Indices != nil {
+		if dims, ok := arrayDims(); ok && (len(dims) == len(n.Indices) || (len(dims) == 0 && len(n.Indices) == 1)) {
+			indices, err := t.exprList(n.Indices)
+			if err != nil {
+				return "", err
+			}
+			return t.load(n.Object.(*parser.Identifier).Name, indices), nil
+		}
+		return "", fmt.Errorf("multi-index [i,j,...] requires an array variable with matching dimensions")
+	}
+	obj, err := t.expr(n.Object)
+	if err != nil {
+		return "", err
+	}
+	if !n.HasColon && n.Start != nil {
+		start, err := t.expr(n.Start)
+		if err != nil {
+			return "", err
+		}
+		if dims, ok := arrayDims(); ok && len(dims) <= 1 {
+			return t.load(n.Object.(*parser.Identifier).Name, []string{start}), nil
+		}
+		return t.op(vm.OpIndex, obj, start), nil
+	}
+	switch {
+	case n.End == nil && n.Start != nil:
+		start, err := t.expr(n.Start)
+		if err != nil {
+			return "", err
+		}
+		return t.op(vm.OpStrSliceFrom, obj, start), nil
+	case n.End == nil:
+		whole := t.op(vm.OpStrSlice, "s", "0", t.op(vm.OpLenStr, "s"))
+		return fmt.Sprintf("func(s vm.Value) vm.Value {\nreturn %s\n}(%s)", whole, obj), nil
+	}
+	start := "0"
+	if n.Start != nil {
+		if start, err = t.expr(n.Start); err != nil {
+			return "", err
+		}
+	}
+	end, err := t.expr(n.End)
+	if err != nil {
+		return "", err
+	}
+	return t.op(vm.OpStrSlice, obj, start, end), nil
+}
+
+// interpolated translates "Hello {name}!" as "Hello " + Str(name) + "!".
+func (t *translator) interpolated(n *parser.InterpolatedString) (string, error) {
+	if len(n.Parts) == 0 {
+		return `""`, nil
+	}
+	var acc string
+	for i, p := range n.Parts {
+		var part string
+		if sl, ok := p.(*parser.StringLiteral); ok {
+			part = literal(sl.Value)
+		} else {
+			x, err := t.expr(p)
+			if err != nil {
+				return "", err
+			}
+			part = t.op(vm.OpStr, x)
+		}
+		if i == 0 {
+			acc = part
+		} else {
+			acc = t.op(vm.OpAdd, acc, part)
+		}
+	}
+	return acc, nil
+}
+
+// functionExpr translates an anonymous FUNCTION. It captures the parameters in scope by value: the Go
+// variables are passed to a function that returns the closure, which keeps its own copies across calls.
+func (t *translator) functionExpr(fn *parser.FunctionExpression) (string, error) {
+	params := make(map[string]string, len(fn.Parameters)+len(t.fn.params))
+	var prologue strings.Builder
+	for i, p := range fn.Parameters {
+		g := goName("p_", p)
+		params[strings.ToLower(p)] = g
+		fmt.Fprintf(&prologue, "%s := a[%d]\n_ = %s\n", g, i, g)
+	}
+	seen := make(map[string]bool)
+	var captured []string
+	for name, g := range t.fn.params {
+		if _, shadowed := params[name]; shadowed {
+			continue
+		}
+		params[name] = g
+		if !seen[g] {
+			seen[g] = true
+			captured = append(captured, g)
+		}
+	}
+	sort.Strings(captured)
+	code, err := t.function(params, func() error { return t.stmts(fn.Body.Statements) })
+	if err != nil {
+		return "", err
+	}
+	closure := fmt.Sprintf("rt.Func(%d, func(a []vm.Value) vm.Value {\n%s%s%s})", len(fn.Parameters), prologue.String(), code,
+		epilogue(fn.Body.Statements))
+	if len(captured) == 0 {
+		return closure, nil
+	}
+	list := strings.Join(captured, ", ")
+	return fmt.Sprintf("func(%s vm.Value) vm.Value {\nreturn %s\n}(%s)", list, closure, list), nil
+}
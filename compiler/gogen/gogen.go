@@ -1,550 +1,420 @@
-// Package gogen generates Go source code from CyberBasic AST.
-// The generated code calls raylib (and other libraries) directly.
+// Package gogen translates a CyberBasic program to Go (cyberbasic --gen-go and --build-native).
+//
+// The generated program is package main. Its statements run as Go code: variables are Go variables, loops are
+// Go loops, Subs and Functions are Go functions. Everything else goes through the runtime in package native,
+// which sets up the same VM, runtime and binding packages cyberbasic runs bytecode with, so operators,
+// builtins, foreign functions, events and TYPE instances behave as they do there. Translation follows codegen
+// statement by statement and resolves names the way it does, from the same semantic.Result.
 package gogen
 
 import (
 	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
 	"strings"
 
+	"cyberbasic/compiler/codegen"
 	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/semantic"
+	"cyberbasic/compiler/srcmap"
+	"cyberbasic/compiler/vm"
 )
 
-// useLibsResult holds which libraries are used in the program.
-type useLibsResult struct {
-	useRL, useBullet, useBox2D, useNet, useECS bool
+// Options describes the program being translated.
+type Options struct {
+	Mode      string      // window mode (runtime.WindowMode.String() of runtime.DetectWindowMode)
+	SourceMap *srcmap.Map // origin of each line when the source #includes files; nil otherwise
 }
 
-// usesLibs scans the program and returns which libraries are used.
-func usesLibs(program *parser.Program) useLibsResult {
-	var r useLibsResult
-	for _, stmt := range program.Statements {
-		if node, ok := stmt.(*parser.Call); ok && strings.Contains(node.Name, ".") {
-			lib := strings.ToLower(strings.SplitN(node.Name, ".", 2)[0])
-			switch lib {
-			case "rl":
-				r.useRL = true
-			case "bullet":
-				r.useBullet = true
-			case "box2d":
-				r.useBox2D = true
-			case "net":
-				r.useNet = true
-			case "ecs":
-				r.useECS = true
-			}
+// Generate returns the Go source of program. The program is compiled to bytecode first, so it fails with the
+// errors cyberbasic reports for it; the bytecode's DATA values, ENUMs and TYPE layouts go into the Go program.
+// The source starts with a go:build ignore line so it can live in the module without being part of its build.
+func Generate(program *parser.Program, opts Options) (string, error) {
+	sem, err := semantic.Analyze(program)
+	if err != nil {
+		return "", err
+	}
+	chunk, err := codegen.Emit(program, sem)
+	if err != nil {
+		return "", err
+	}
+	t := newTranslator(sem)
+	run, err := t.function(nil, func() error { return t.stmts(sem.MainStmts) })
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(t.decls); i++ {
+		if err := t.decl(t.decls[i]); err != nil {
+			return "", err
 		}
-		if _, ok := stmt.(*parser.GameCommand); ok {
-			r.useRL = true
+	}
+	for i := 0; i < len(t.handlers); i++ {
+		if err := t.handler(i); err != nil {
+			return "", err
 		}
 	}
-	return r
-}
 
-// Generate produces Go source code from a parsed BASIC program.
-// Output is package main with imports for raylib and/or bullet, box2d, etc. as used.
-func Generate(program *parser.Program) (string, error) {
-	used := usesLibs(program)
 	var b strings.Builder
-	b.WriteString("//go:build ignore\n\npackage main\n\n")
-	b.WriteString("import (\n")
-	b.WriteString("\t\"fmt\"\n")
-	if used.useBullet {
-		b.WriteString("\t\"cyberbasic/compiler/bindings/bullet\"\n")
-	}
-	if used.useBox2D {
-		b.WriteString("\t\"cyberbasic/compiler/bindings/box2d\"\n")
+	b.WriteString("//go:build ignore\n\n")
+	b.WriteString("// Code generated by cyberbasic --gen-go. DO NOT EDIT.\n\n")
+	b.WriteString("package main\n\n")
+	b.WriteString("import (\n\t\"cyberbasic/compiler/gogen/native\"\n")
+	if opts.SourceMap != nil {
+		b.WriteString("\t\"cyberbasic/compiler/srcmap\"\n")
+	}
+	b.WriteString("\t\"cyberbasic/compiler/vm\"\n)\n\n")
+	b.WriteString("var rt = native.New(native.Config{\n")
+	fmt.Fprintf(&b, "Mode: %q,\n", opts.Mode)
+	if opts.SourceMap != nil {
+		b.WriteString("SourceMap: " + sourceMapLiteral(opts.SourceMap) + ",\n")
+	}
+	if len(chunk.Types) > 0 {
+		b.WriteString("Types: " + typesLiteral(chunk.Types) + ",\n")
+	}
+	if len(chunk.Enums) > 0 {
+		b.WriteString("Enums: " + enumsLiteral(chunk.Enums) + ",\n")
+	}
+	if len(chunk.DataValues) > 0 {
+		b.WriteString("Data: []vm.Value{")
+		for i, v := range chunk.DataValues {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(literal(v))
+		}
+		b.WriteString("},\n")
 	}
-	if used.useNet {
-		b.WriteString("\t\"cyberbasic/compiler/bindings/net\"\n")
+	b.WriteString("})\n\n")
+	for _, name := range sortedKeys(t.vars) {
+		fmt.Fprintf(&b, "var %s vm.Value\n", goName("v_", name))
 	}
-	if used.useECS {
-		b.WriteString("\t\"cyberbasic/compiler/bindings/ecs\"\n")
+	for _, name := range sortedKeys(t.dimVars) {
+		fmt.Fprintf(&b, "var %s []int\n", goName("d_", name))
 	}
-	if used.useRL {
-		b.WriteString("\trl \"github.com/gen2brain/raylib-go/raylib\"\n")
+	b.WriteString("\nfunc main() {\n\trt.Main(run)\n}\n\n")
+	b.WriteString("func run() vm.Value {\n" + run + "return nil\n}\n\n")
+	if t.registry.Len() > 0 {
+		b.WriteString("func init() {\n" + t.registry.String() + "}\n\n")
 	}
-	b.WriteString(")\n\n")
-	b.WriteString("func main() {\n")
-
-	// Track sprite positions for DRAWSPRITE (simplified: draw as rectangles)
-	spritePos := make(map[string]string) // id -> "x, y" or "x, y, w, h"
+	b.WriteString(t.funcs.String())
 
-	for _, stmt := range program.Statements {
-		code, err := emitStatement(stmt, &spritePos, "")
-		if err != nil {
-			return "", err
-		}
-		if code != "" {
-			b.WriteString("\t" + code + "\n")
-		}
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("generated Go does not parse: %w", err)
 	}
+	return string(src), nil
+}
 
-	b.WriteString("}\n")
-	return b.String(), nil
+// translator holds what the translation has learnt so far. Like codegen it goes through the main program,
+// then the Subs and Functions, then the ON handlers, so variables and arrays become known in the same order.
+type translator struct {
+	sem      *semantic.Result
+	vars     map[string]bool  // variables (lowercase) in the order codegen allocates them
+	dims     map[string][]int // dimensions of the DIM'd arrays codegen knows (by lowercase name)
+	dimVars  map[string]bool  // arrays whose dimensions the code tracks in a d_ variable
+	consts   map[string]string
+	params   map[string]int // parameter counts of the Subs and Functions, ME included
+	decls    []parser.Node  // Subs and Functions to translate
+	handlers []*parser.OnEventStatement
+	registry strings.Builder // body of init: registration of the Subs and Functions
+	funcs    strings.Builder // the Go functions of Subs, Functions and handlers
+	out      *strings.Builder
+	fn       *scope
+	seq      int // numbers loop labels, handlers and temporaries
 }
 
-func emitStatement(stmt parser.Node, spritePos *map[string]string, indent string) (string, error) {
-	switch node := stmt.(type) {
-	case *parser.DimStatement:
-		return emitDim(node)
-	case *parser.Assignment:
-		return emitAssignment(node)
-	case *parser.Call:
-		return emitCall(node)
-	case *parser.GameCommand:
-		return emitGameCommand(node, spritePos)
-	case *parser.IfStatement:
-		return emitIf(node, spritePos, indent)
-	case *parser.ForStatement:
-		return emitFor(node, spritePos, indent)
-	case *parser.WhileStatement:
-		return emitWhile(node, spritePos, indent)
-	case *parser.MainLoopStatement:
-		return emitMainLoop(node, spritePos, indent)
-	case *parser.ReturnStatement:
-		if node.Value != nil {
-			expr, _ := emitExpr(node.Value)
-			return "return " + expr, nil
-		}
-		return "return", nil
-	default:
-		return "", nil
-	}
+// scope is the Sub, Function, handler or anonymous FUNCTION being translated.
+type scope struct {
+	params  map[string]string // parameter (lowercase) → Go variable
+	loops   []*loop
+	tries   int            // TRY blocks open: their code runs in closures passed to rt.Try
+	jumps   map[int]jump   // flow codes of RETURN, EXIT and CONTINUE that leave a closure of rt.Try
+	escapes []map[int]bool // per open TRY: the flow codes its closures return
+	usesRet bool
 }
 
-func emitDim(d *parser.DimStatement) (string, error) {
-	var parts []string
-	for _, v := range d.Variables {
-		goType := basicTypeToGo(v.Type)
-		parts = append(parts, fmt.Sprintf("var %s %s", v.Name, goType))
-	}
-	return strings.Join(parts, "\n\t"), nil
+// loop is a FOR, FOR EACH, WHILE or MAINLOOP being translated.
+type loop struct {
+	label string
+	n     int
+	tries int // TRY blocks open where the loop starts
+	used  bool
 }
 
-func basicTypeToGo(t string) string {
-	switch strings.ToLower(t) {
-	case "integer", "int":
-		return "int"
-	case "float", "single", "double":
-		return "float64"
-	case "string", "str":
-		return "string"
-	case "boolean", "bool":
-		return "bool"
-	default:
-		return "interface{}"
-	}
+// jump is where a flow code goes: the Go statement that carries it out once no TRY closure is left between.
+type jump struct {
+	tries int
+	stmt  string
 }
 
-func emitAssignment(a *parser.Assignment) (string, error) {
-	rhs, err := emitExpr(a.Value)
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s = %s", a.Variable, rhs), nil
+// flowReturn is the flow code of RETURN; EXIT and CONTINUE of loop n are 2n+2 and 2n+3.
+const flowReturn = 1
+
+func newTranslator(sem *semantic.Result) *translator {
+	t := &translator{
+		sem:     sem,
+		vars:    make(map[string]bool),
+		dims:    make(map[string][]int),
+		dimVars: make(map[string]bool),
+		consts:  make(map[string]string),
+		params:  make(map[string]int),
+	}
+	t.decls = append(t.decls, sem.Decls...)
+	for _, d := range sem.Decls {
+		switch n := d.(type) {
+		case *parser.FunctionDecl:
+			t.params[semantic.QualifiedName(n)] = len(n.Parameters)
+		case *parser.SubDecl:
+			t.params[semantic.QualifiedName(n)] = len(n.Parameters)
+		}
+	}
+	return t
 }
 
-// box2dGoName maps BASIC BOX2D.* method (lowercase) to flat Go export (no namespace in generated code).
-var box2dGoName = map[string]string{
-	"createworld": "CreateWorld2D", "destroyworld": "DestroyWorld2D", "step": "Step2D",
-	"createbody": "CreateBody2D", "destroybody": "DestroyBody2D",
-	"getbodycount": "GetBodyCount2D", "getbodyid": "GetBodyId2D", "createbodyatscreen": "CreateBodyAtScreen2D",
-	"getpositionx": "GetPositionX2D", "getpositiony": "GetPositionY2D", "getangle": "GetAngle2D",
-	"setlinearvelocity": "SetVelocity2D", "settransform": "SetTransform2D", "applyforce": "ApplyForce2D",
+// function translates the body gen writes, in a fresh scope whose parameters are params (lowercase name → Go
+// variable), and returns its code.
+func (t *translator) function(params map[string]string, gen func() error) (string, error) {
+	savedOut, savedFn := t.out, t.fn
+	defer func() { t.out, t.fn = savedOut, savedFn }()
+	t.out = &strings.Builder{}
+	t.fn = &scope{params: params, jumps: make(map[int]jump)}
+	if err := gen(); err != nil {
+		return "", err
+	}
+	if t.fn.usesRet {
+		return "var ret vm.Value\n" + t.out.String(), nil
+	}
+	return t.out.String(), nil
 }
 
-// bulletGoName maps BASIC BULLET.* method (lowercase) to flat Go export (no namespace in generated code).
-var bulletGoName = map[string]string{
-	"createworld": "CreateWorld3D", "destroyworld": "DestroyWorld3D", "setgravity": "SetWorldGravity3D", "step": "Step3D",
-	"createbox": "CreateBox3D", "createsphere": "CreateSphere3D", "destroybody": "DestroyBody3D",
-	"setposition": "SetPosition3D", "getpositionx": "GetPositionX3D", "getpositiony": "GetPositionY3D", "getpositionz": "GetPositionZ3D",
-	"setvelocity": "SetVelocity3D", "getvelocityx": "GetVelocityX3D", "getvelocityy": "GetVelocityY3D", "getvelocityz": "GetVelocityZ3D",
-	"getrotationx": "GetYaw3D", "getrotationy": "GetPitch3D", "getrotationz": "GetRoll3D", "setrotation": "SetRotation3D",
-	"applyforce": "ApplyForce3D", "applycentralforce": "ApplyForce3D", "applyimpulse": "ApplyImpulse3D",
-	"raycast": "RayCastFromDir3D",
-	"getraycasthitx": "RayHitX3D", "getraycasthity": "RayHitY3D", "getraycasthitz": "RayHitZ3D",
-	"getraycasthitbody": "RayHitBody3D",
-	"getraycasthitnormalx": "RayHitNormalX3D", "getraycasthitnormaly": "RayHitNormalY3D", "getraycasthitnormalz": "RayHitNormalZ3D",
+// capture returns the code gen writes, in the current scope.
+func (t *translator) capture(gen func() error) (string, error) {
+	saved := t.out
+	defer func() { t.out = saved }()
+	t.out = &strings.Builder{}
+	err := gen()
+	return t.out.String(), err
 }
 
-// rlGoName maps BASIC RL.* (canonical lowercase) to raylib-go export (PascalCase).
-var rlGoName = map[string]string{
-	"initwindow": "InitWindow", "settargetfps": "SetTargetFPS", "windowshouldclose": "WindowShouldClose",
-	"closewindow": "CloseWindow", "setwindowposition": "SetWindowPosition",
-	"begindrawing": "BeginDrawing", "enddrawing": "EndDrawing", "clearbackground": "ClearBackground",
-	"drawrectangle": "DrawRectangle", "drawcircle": "DrawCircle", "drawtext": "DrawText",
-	"drawline": "DrawLine", "getscreenwidth": "GetScreenWidth", "getscreenheight": "GetScreenHeight",
-	"iskeypressed": "IsKeyPressed", "iskeydown": "IsKeyDown", "white": "White", "black": "Black",
+// emit writes one line of Go.
+func (t *translator) emit(format string, args ...interface{}) {
+	fmt.Fprintf(t.out, format, args...)
+	t.out.WriteByte('\n')
 }
 
-func emitCall(c *parser.Call) (string, error) {
-	// Foreign API: RL.InitWindow(...) -> rl.InitWindow(...), BULLET.* -> bullet.*
-	if strings.Contains(c.Name, ".") {
-		parts := strings.SplitN(c.Name, ".", 2)
-		lib, fn := parts[0], parts[1]
-		if strings.EqualFold(lib, "rl") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("rl.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "bullet") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			goName := bulletGoName[strings.ToLower(fn)]
-			if goName == "" {
-				goName = fn
-			}
-			return fmt.Sprintf("bullet.%s(%s)", goName, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "box2d") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			goName := box2dGoName[strings.ToLower(fn)]
-			if goName == "" {
-				goName = fn
-			}
-			return fmt.Sprintf("box2d.%s(%s)", goName, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "audio") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("audio.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "ui") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("ui.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "tile") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("tile.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "net") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("net.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "assimp") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("assimp.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "path") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("path.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		if strings.EqualFold(lib, "ecs") {
-			args, err := emitExprList(c.Arguments)
-			if err != nil {
-				return "", err
-			}
-			return fmt.Sprintf("ecs.%s(%s)", fn, strings.Join(args, ", ")), nil
-		}
-		return "", fmt.Errorf("unknown library: %s", lib)
+// decl translates a Sub or Function to the Go function f_<name> and registers it with the runtime.
+func (t *translator) decl(node parser.Node) error {
+	var params []string
+	var body *parser.Block
+	var typeName string
+	isSub := false
+	switch n := node.(type) {
+	case *parser.FunctionDecl:
+		params, body, typeName = n.Parameters, n.Body, n.TypeName
+	case *parser.SubDecl:
+		params, body, typeName, isSub = n.Parameters, n.Body, n.TypeName, true
+	default:
+		return fmt.Errorf("compileDecl: expected FunctionDecl or SubDecl, got %T", node)
 	}
-	// Built-in: Print -> fmt.Println (case-insensitive)
-	if strings.EqualFold(c.Name, "print") {
-		if len(c.Arguments) == 0 {
-			return "fmt.Println()", nil
-		}
-		args, err := emitExprList(c.Arguments)
-		if err != nil {
-			return "", err
+	name := semantic.QualifiedName(node)
+	scopeParams := make(map[string]string, len(params)+1)
+	goParams := make([]string, len(params))
+	for i, p := range params {
+		goParams[i] = goName("p_", p)
+		scopeParams[strings.ToLower(p)] = goParams[i]
+	}
+	if typeName != "" && len(params) > 0 {
+		scopeParams["self"] = goParams[0] // SELF is ME in methods
+	}
+	code, err := t.function(scopeParams, func() error {
+		if isSub && name == "draw" {
+			t.emit("defer rt.Draw()()")
 		}
-		return "fmt.Println(" + strings.Join(args, ", ") + ")", nil
+		return t.stmts(body.Statements)
+	})
+	if err != nil {
+		return err
 	}
-	// Multi-window API: not supported in --gen-go (VM-only binding)
-	if isMultiWindowCall(c.Name) {
-		return "", fmt.Errorf("multi-window API %s is not supported in --gen-go; run without --gen-go", c.Name)
+	fn := goName("f_", name)
+	sig := ""
+	if len(goParams) > 0 {
+		sig = strings.Join(goParams, ", ") + " vm.Value"
 	}
-	return "", fmt.Errorf("unsupported call: %s", c.Name)
-}
-
-// isMultiWindowCall returns true for Window*, Channel*, State*, Dock*, OnWindow* APIs.
-func isMultiWindowCall(name string) bool {
-	n := strings.ToLower(name)
-	return strings.HasPrefix(n, "window") || strings.HasPrefix(n, "channel") ||
-		strings.HasPrefix(n, "state") || strings.HasPrefix(n, "dock") ||
-		strings.HasPrefix(n, "onwindow")
+	fmt.Fprintf(&t.funcs, "func %s(%s) vm.Value {\n%s%s}\n\n", fn, sig, code, epilogue(body.Statements))
+	args := make([]string, len(params))
+	for i := range params {
+		args[i] = fmt.Sprintf("a[%d]", i)
+	}
+	fmt.Fprintf(&t.registry, "rt.Function(%q, %d, func(a []vm.Value) vm.Value { return %s(%s) })\n",
+		name, len(params), fn, strings.Join(args, ", "))
+	return nil
 }
 
-func emitGameCommand(g *parser.GameCommand, spritePos *map[string]string) (string, error) {
-	cmd := strings.ToLower(g.Command)
-	args, err := emitExprList(g.Arguments)
+// handler translates the body of the i-th ON statement to the Go function h<i>.
+func (t *translator) handler(i int) error {
+	on := t.handlers[i]
+	params := make(map[string]string, len(on.Params))
+	var prologue strings.Builder
+	for j, p := range on.Params {
+		g := goName("p_", p)
+		params[strings.ToLower(p)] = g
+		fmt.Fprintf(&prologue, "%s := a[%d]\n_ = %s\n", g, j, g)
+	}
+	code, err := t.function(params, func() error { return t.stmts(on.Body.Statements) })
 	if err != nil {
-		return "", err
+		return err
 	}
+	fmt.Fprintf(&t.funcs, "func h%d(a []vm.Value) vm.Value {\n%s%s%s}\n\n", i, prologue.String(), code, epilogue(on.Body.Statements))
+	return nil
+}
 
-	switch cmd {
-	case "initgraphics3d", "initgraphics":
-		if len(args) < 3 {
-			return "", fmt.Errorf("%s requires (width, height, title)", cmd)
-		}
-		return fmt.Sprintf("rl.InitWindow(%s, %s, %s)\n\trl.SetTargetFPS(60)\n\trl.SetWindowPosition(120, 80)", args[0], args[1], args[2]), nil
-	case "createsprite":
-		if len(args) >= 3 {
-			(*spritePos)[args[0]] = args[1] + ", " + args[2] + ", 64, 64"
-		}
-		return "", nil
-	case "setspriteposition":
-		if len(args) >= 3 {
-			(*spritePos)[args[0]] = args[1] + ", " + args[2] + ", 64, 64"
-		}
-		return "", nil
-	case "drawsprite":
-		if len(args) < 1 {
-			return "", fmt.Errorf("DRAWSPRITE requires sprite id")
-		}
-		id := strings.Trim(args[0], "\"")
-		pos, ok := (*spritePos)[args[0]]
-		if !ok {
-			pos = "0, 0, 64, 64"
-		}
-		_ = id
-		return fmt.Sprintf("rl.DrawRectangle(%s, rl.White)", pos), nil
-	case "sync":
-		// Emit only EndDrawing; caller ensures BeginDrawing/ClearBackground at loop start
-		return "rl.EndDrawing()", nil
-	case "shouldclose":
-		return "", fmt.Errorf("SHOULDCLOSE is used in condition only")
-	default:
-		return "", fmt.Errorf("unsupported game command in Go gen: %s", cmd)
-	}
+// declare records that the variable name exists from here on, as codegen's AddVariable does, and returns its
+// Go variable.
+func (t *translator) declare(name string) string {
+	t.vars[strings.ToLower(name)] = true
+	return goName("v_", name)
 }
 
-func emitIf(i *parser.IfStatement, spritePos *map[string]string, indent string) (string, error) {
-	cond, err := emitExpr(i.Condition)
-	if err != nil {
-		return "", err
-	}
-	// Go requires bool; BASIC uses int (0=false). Add " != 0" unless condition is clearly boolean.
-	if !strings.Contains(cond, "WindowShouldClose") && !strings.Contains(cond, "true") && !strings.Contains(cond, "false") && !strings.Contains(cond, "==") && !strings.Contains(cond, "!=") {
-		cond = cond + " != 0"
-	}
-	tab := indent + "\t"
-	var b strings.Builder
-	b.WriteString(fmt.Sprintf("if %s {\n", cond))
-	for _, s := range i.ThenBlock.Statements {
-		line, err := emitStatement(s, spritePos, tab)
-		if err != nil {
-			return "", err
-		}
-		if line != "" {
-			b.WriteString(tab + line + "\n")
-		}
-	}
-	if i.ElseBlock != nil {
-		b.WriteString(indent + "} else {\n")
-		for _, s := range i.ElseBlock.Statements {
-			line, err := emitStatement(s, spritePos, tab)
-			if err != nil {
-				return "", err
-			}
-			if line != "" {
-				b.WriteString(tab + line + "\n")
-			}
-		}
-	}
-	b.WriteString(indent + "}")
-	return b.String(), nil
+// isVar reports whether the variable name exists at this point of the translation.
+func (t *translator) isVar(name string) bool {
+	return t.vars[strings.ToLower(name)]
+}
+
+// param returns the Go variable of the parameter name of the current scope.
+func (t *translator) param(name string) (string, bool) {
+	g, ok := t.fn.params[strings.ToLower(name)]
+	return g, ok
+}
+
+// dimsVar returns the Go variable holding the dimensions of the array name.
+func (t *translator) dimsVar(name string) string {
+	t.dimVars[strings.ToLower(name)] = true
+	return goName("d_", name)
+}
+
+// temp returns a new Go variable name starting with prefix.
+func (t *translator) temp(prefix string) string {
+	t.seq++
+	return prefix + strconv.Itoa(t.seq)
 }
 
-func emitFor(f *parser.ForStatement, spritePos *map[string]string, indent string) (string, error) {
-	start, _ := emitExpr(f.Start)
-	end, _ := emitExpr(f.End)
-	step := "1"
-	if f.Step != nil {
-		step, _ = emitExpr(f.Step)
-	}
-	tab := indent + "\t"
+// goName returns the Go identifier for the BASIC name (case-insensitive) with prefix. Characters Go does not
+// allow become an underscore and an uppercase letter, which a lowercased name never has.
+func goName(prefix, name string) string {
 	var b strings.Builder
-	b.WriteString(fmt.Sprintf("for %s := %s; %s <= %s; %s += %s {\n", f.Variable, start, f.Variable, end, f.Variable, step))
-	for _, s := range f.Body.Statements {
-		line, err := emitStatement(s, spritePos, tab)
-		if err != nil {
-			return "", err
-		}
-		if line != "" {
-			b.WriteString(tab + line + "\n")
-		}
-	}
-	b.WriteString(indent + "}")
-	return b.String(), nil
+	b.WriteString(prefix)
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			b.WriteRune(r)
+		case r == '.':
+			b.WriteString("_M")
+		case r == '$':
+			b.WriteString("_S")
+		case r == '#':
+			b.WriteString("_H")
+		case r == '%':
+			b.WriteString("_P")
+		case r == '!':
+			b.WriteString("_B")
+		case r == '&':
+			b.WriteString("_A")
+		default:
+			fmt.Fprintf(&b, "_X%X", r)
+		}
+	}
+	return b.String()
 }
 
-func blockContainsDrawOrSync(statements []parser.Node) bool {
-	for _, s := range statements {
-		if g, ok := s.(*parser.GameCommand); ok && strings.EqualFold(g.Command, "sync") {
-			return true
-		}
-		// Any draw-like call (ClearBackground, DrawCircle, etc.) - treat as needing frame
-		if st, ok := s.(*parser.Statement); ok && st.Value != nil {
-			if c, ok := st.Value.(*parser.Call); ok {
-				nm := strings.ToLower(c.Name)
-				if strings.HasSuffix(nm, "background") || strings.HasPrefix(nm, "draw") {
-					return true
-				}
-			}
-		}
+// literal returns the Go expression of the constant v, keeping its VM type.
+func literal(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return "int64(" + strconv.FormatInt(x, 10) + ")"
+	case float64:
+		s := strconv.FormatFloat(x, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEIN") {
+			s += ".0"
+		}
+		return s
+	case string:
+		return strconv.Quote(x)
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		return fmt.Sprintf("%#v", x)
 	}
-	return false
 }
 
-func emitWhile(w *parser.WhileStatement, spritePos *map[string]string, indent string) (string, error) {
-	cond, err := emitExpr(w.Condition)
-	if err != nil {
-		return "", err
-	}
-	// BASIC: WHILE NOT ShouldClose() -> Go: for !rl.WindowShouldClose()
-	if cond == "!rl.WindowShouldClose()" || strings.Contains(cond, "WindowShouldClose") {
-		cond = "!rl.WindowShouldClose()"
-	}
-	tab := indent + "\t"
+func sourceMapLiteral(m *srcmap.Map) string {
 	var b strings.Builder
-	b.WriteString(fmt.Sprintf("for %s {\n", cond))
-	injectFrame := blockContainsDrawOrSync(w.Body.Statements)
-	if injectFrame {
-		b.WriteString(tab + "rl.BeginDrawing()\n")
-		b.WriteString(tab + "rl.ClearBackground(rl.NewColor(20, 20, 30, 255))\n")
-	}
-	for _, s := range w.Body.Statements {
-		line, err := emitStatement(s, spritePos, tab)
-		if err != nil {
-			return "", err
-		}
-		if line != "" {
-			b.WriteString(tab + line + "\n")
+	b.WriteString("&srcmap.Map{Files: []string{")
+	for i, f := range m.Files {
+		if i > 0 {
+			b.WriteString(", ")
 		}
+		b.WriteString(strconv.Quote(f))
 	}
-	if injectFrame {
-		b.WriteString(tab + "rl.EndDrawing()\n")
+	b.WriteString("}, Lines: []srcmap.Origin{")
+	for i, o := range m.Lines {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "{File: %d, Line: %d}", o.File, o.Line)
 	}
-	b.WriteString(indent + "}")
-	return b.String(), nil
+	b.WriteString("}}")
+	return b.String()
 }
 
-func emitMainLoop(m *parser.MainLoopStatement, spritePos *map[string]string, indent string) (string, error) {
-	// MAINLOOP...ENDMAIN -> for !rl.WindowShouldClose() { ... }
-	tab := indent + "\t"
+func typesLiteral(types map[string]*vm.TypeInfo) string {
 	var b strings.Builder
-	b.WriteString("for !rl.WindowShouldClose() {\n")
-	bodyStmts := []parser.Node{}
-	if m.Body != nil {
-		bodyStmts = m.Body.Statements
-	}
-	injectFrame := blockContainsDrawOrSync(bodyStmts)
-	if injectFrame {
-		b.WriteString(tab + "rl.BeginDrawing()\n")
-		b.WriteString(tab + "rl.ClearBackground(rl.NewColor(20, 20, 30, 255))\n")
-	}
-	for _, s := range bodyStmts {
-		line, err := emitStatement(s, spritePos, tab)
-		if err != nil {
-			return "", err
-		}
-		if line != "" {
-			b.WriteString(tab + line + "\n")
+	b.WriteString("map[string]*vm.TypeInfo{\n")
+	for _, key := range sortedKeys(types) {
+		ti := types[key]
+		fmt.Fprintf(&b, "%q: {Name: %q, Fields: []vm.FieldInfo{", key, ti.Name)
+		for i, f := range ti.Fields {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "{Name: %q, Default: %s", f.Name, literal(f.Default))
+			if f.TypeName != "" {
+				fmt.Fprintf(&b, ", TypeName: %q", f.TypeName)
+			}
+			b.WriteString("}")
 		}
+		b.WriteString("}},\n")
 	}
-	if injectFrame {
-		b.WriteString(tab + "rl.EndDrawing()\n")
-	}
-	b.WriteString(indent + "}")
-	return b.String(), nil
+	b.WriteString("}")
+	return b.String()
 }
 
-func emitExprList(nodes []parser.Node) ([]string, error) {
-	var out []string
-	for _, n := range nodes {
-		s, err := emitExpr(n)
-		if err != nil {
-			return nil, err
+func enumsLiteral(enums map[string]vm.EnumMembers) string {
+	var b strings.Builder
+	b.WriteString("map[string]vm.EnumMembers{\n")
+	for _, name := range sortedKeys(enums) {
+		fmt.Fprintf(&b, "%q: {", name)
+		for i, m := range sortedKeys(enums[name]) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%q: %d", m, enums[name][m])
 		}
-		out = append(out, s)
+		b.WriteString("},\n")
 	}
-	return out, nil
+	b.WriteString("}")
+	return b.String()
 }
 
-func emitExpr(expr parser.Node) (string, error) {
-	switch node := expr.(type) {
-	case *parser.Number:
-		return node.Value, nil
-	case *parser.StringLiteral:
-		return `"` + strings.Trim(node.Value, `"`) + `"`, nil
-	case *parser.Boolean:
-		if node.Value {
-			return "true", nil
-		}
-		return "false", nil
-	case *parser.Identifier:
-		return node.Name, nil
-	case *parser.Call:
-		if strings.EqualFold(node.Name, "shouldclose") {
-			return "rl.WindowShouldClose()", nil
-		}
-		if strings.Contains(node.Name, ".") {
-			parts := strings.SplitN(node.Name, ".", 2)
-			if strings.EqualFold(parts[0], "rl") {
-				args, _ := emitExprList(node.Arguments)
-				return "rl." + parts[1] + "(" + strings.Join(args, ", ") + ")", nil
-			}
-			if strings.EqualFold(parts[0], "bullet") {
-				args, _ := emitExprList(node.Arguments)
-				goName := bulletGoName[strings.ToLower(parts[1])]
-				if goName == "" {
-					goName = parts[1]
-				}
-				return "bullet." + goName + "(" + strings.Join(args, ", ") + ")", nil
-			}
-			if strings.EqualFold(parts[0], "box2d") {
-				args, _ := emitExprList(node.Arguments)
-				goName := box2dGoName[strings.ToLower(parts[1])]
-				if goName == "" {
-					goName = parts[1]
-				}
-				return "box2d." + goName + "(" + strings.Join(args, ", ") + ")", nil
-			}
-		}
-		args, _ := emitExprList(node.Arguments)
-		return node.Name + "(" + strings.Join(args, ", ") + ")", nil
-	case *parser.BinaryOp:
-		left, _ := emitExpr(node.Left)
-		right, _ := emitExpr(node.Right)
-		op := node.Operator
-		if strings.EqualFold(op, "and") {
-			op = "&&"
-		}
-		if strings.EqualFold(op, "or") {
-			op = "||"
-		}
-		return fmt.Sprintf("(%s %s %s)", left, op, right), nil
-	case *parser.UnaryOp:
-		operand, _ := emitExpr(node.Operand)
-		if strings.EqualFold(node.Operator, "NOT") {
-			return "!" + operand, nil
-		}
-		return node.Operator + operand, nil
-	default:
-		return "", fmt.Errorf("unsupported expression: %T", expr)
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,393 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/bindings"
+	"cyberbasic/compiler/bindings/std"
+	"cyberbasic/compiler/runtime"
+	"cyberbasic/compiler/srcmap"
+)
+
+// programs run under the VM and as generated Go; both must print the same.
+var programs = map[string]string{
+	"functions_select_repeat_data": `FUNCTION Sq(x)
+  RETURN x * x
+END FUNCTION
+SUB Show(a, b)
+  PRINT a + b
+END SUB
+VAR tot = 0
+FOR i = 1 TO 5
+  tot = tot + Sq(i)
+NEXT i
+PRINT tot
+Show(2, 3)
+VAR k = 2
+SELECT CASE k
+  CASE 1
+    PRINT "one"
+  CASE 2
+    PRINT "two"
+  CASE ELSE
+    PRINT "other"
+END SELECT
+VAR n = 0
+REPEAT
+  n = n + 1
+UNTIL n >= 3
+PRINT n
+DATA 10, 20, "hi"
+VAR a = 0
+VAR b = 0
+VAR c = 0
+READ a, b, c
+PRINT a + b
+PRINT c
+RESTORE
+READ a
+PRINT a
+`,
+	"types_enums": `TYPE Vec
+  x AS FLOAT
+  y AS FLOAT
+  FUNCTION New(ax, ay)
+    ME.x = ax
+    ME.y = ay
+  END FUNCTION
+  FUNCTION Len2()
+    RETURN ME.x * ME.x + ME.y * ME.y
+  END FUNCTION
+END TYPE
+ENUM Hue
+  Red
+  Green = 5
+  Blue
+END ENUM
+VAR v = Vec(3, 4)
+PRINT v.Len2()
+v.x = 1
+PRINT v.x
+PRINT "x={v.x}!"
+PRINT Blue
+`,
+	"coroutines": `SUB Worker(n)
+  FOR i = 1 TO n
+    PRINT "w" + STR(i)
+    YIELD
+  NEXT i
+END SUB
+VAR t = StartCoroutine Worker(2)
+PRINT "main"
+YIELD
+PRINT "main2"
+AWAIT t
+PRINT "done"
+`,
+	"try_exit_return": `TRY
+  THROW "boom"
+CATCH e
+  PRINT "caught"
+FINALLY
+  PRINT "finally"
+END TRY
+FUNCTION Find(limit)
+  FOR i = 1 TO 10
+    TRY
+      IF i = limit THEN
+        RETURN i * 100
+      END IF
+    FINALLY
+      PRINT "f" + STR(i)
+    END TRY
+  NEXT i
+  RETURN -1
+END FUNCTION
+PRINT Find(2)
+FOR i = 1 TO 3
+  TRY
+    IF i = 2 THEN
+      EXIT FOR
+    END IF
+    PRINT "in" + STR(i)
+  FINALLY
+    PRINT "fin" + STR(i)
+  END TRY
+NEXT i
+VAR s = 0
+FOR i = 1 TO 10
+  IF i = 3 THEN
+    CONTINUE FOR
+  END IF
+  IF i > 4 THEN
+    EXIT FOR
+  END IF
+  s += i
+NEXT i
+PRINT s
+VAR w = 0
+WHILE w < 5
+  w = w + 2
+WEND
+PRINT w
+`,
+	"closures_collections_arrays": `FUNCTION MakeAdder(n)
+  RETURN FUNCTION(x)
+    RETURN x + n
+  END FUNCTION
+END FUNCTION
+VAR add5 = MakeAdder(5)
+PRINT add5(10)
+VAR lst = [1, 2, 3]
+lst.add(4)
+FOR EACH it IN lst
+  PRINT it
+NEXT
+VAR m = {"a": 1}
+PRINT m["a"]
+DIM grid(3, 3)
+FOR i = 0 TO 2
+  FOR j = 0 TO 2
+    grid(i, j) = i * 10 + j
+  NEXT j
+NEXT i
+PRINT grid(2, 1)
+DIM arr(3)
+arr(1) = 7
+REDIM arr(5)
+arr(4) = 9
+PRINT arr(4)
+VAR q = "hello world"
+PRINT q[0:5]
+PRINT q[6:]
+PRINT UPPER(q)
+PRINT 7 % 3
+`,
+	"uncaught_error": `SUB Boom()
+  VAR z = nil
+  z.foo()
+END SUB
+PRINT "before"
+Boom()
+PRINT "after"
+`,
+}
+
+func TestGeneratedProgramsMatchVM(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not on PATH")
+	}
+	for name, src := range programs {
+		t.Run(name, func(t *testing.T) {
+			want := stripStack(runVM(t, src))
+			got := runGo(t, src)
+			if got != want {
+				t.Errorf("generated Go printed\n%s\nthe VM printed\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestGenerateExamples translates every example that compiles and checks the result parses; unless -short, the
+// translations are also type-checked and vetted (unreachable code included) with go vet.
+func TestGenerateExamples(t *testing.T) {
+	files, _ := filepath.Glob("../../examples/*.bas")
+	if len(files) == 0 {
+		t.Skip("no examples")
+	}
+	dir := ""
+	if _, err := exec.LookPath("go"); err == nil && !testing.Short() {
+		// The translations import packages of this module, so they are vetted inside it.
+		if dir, err = os.MkdirTemp(".", "gen"); err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+	}
+	for _, f := range files {
+		src, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := compiler.New().Compile(string(src)); err != nil {
+			continue // examples that do not compile are reported by the compiler tests
+		}
+		program, err := compiler.New().Parse(string(src))
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		code, err := Generate(program, Options{Mode: runtime.DetectWindowMode(string(src)).String()})
+		if err != nil {
+			t.Errorf("%s: %v", f, err)
+			continue
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), "main.go", code, 0); err != nil {
+			t.Errorf("%s: generated Go does not parse: %v", f, err)
+			continue
+		}
+		if dir != "" {
+			pkg := filepath.Join(dir, strings.TrimSuffix(filepath.Base(f), ".bas"))
+			if err := os.Mkdir(pkg, 0755); err != nil {
+				t.Fatal(err)
+			}
+			// Without its "ignore" build constraint, so go vet sees the file as a package.
+			code = strings.Replace(code, "//go:build ignore\n", "", 1)
+			if err := os.WriteFile(filepath.Join(pkg, "main.go"), []byte(code), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if dir != "" {
+		if out, err := exec.Command("go", "vet", "./"+dir+"/...").CombinedOutput(); err != nil {
+			t.Errorf("go vet of the generated examples: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestNoReturnAfterReturn(t *testing.T) {
+	src := `FUNCTION Sq(x)
+  RETURN x * x
+END FUNCTION
+FUNCTION Sign(x)
+  IF x < 0 THEN
+    RETURN -1
+  ELSEIF x = 0 THEN
+    RETURN 0
+  ELSE
+    RETURN 1
+  END IF
+END FUNCTION
+FUNCTION Clamp(x)
+  IF x > 10 THEN
+    RETURN 10
+  END IF
+END FUNCTION
+VAR inc = FUNCTION(n)
+  RETURN n + 1
+END FUNCTION
+PRINT Sq(inc(2)) + Sign(-3) + Clamp(20)
+`
+	program, err := compiler.New().Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := Generate(program, Options{Mode: runtime.DetectWindowMode(src).String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(code, "\n")
+	for i := 1; i < len(lines); i++ {
+		prev, cur := strings.TrimSpace(lines[i-1]), strings.TrimSpace(lines[i])
+		if strings.HasPrefix(prev, "return ") && cur == "return nil" {
+			t.Errorf("unreachable return nil after %q", lines[i-1])
+		}
+	}
+	if !regexp.MustCompile(`return nil\s*}`).MatchString(code) {
+		t.Error("Clamp can fall through and needs a trailing return nil")
+	}
+}
+
+// stripStack drops the call stack the VM appends to runtime errors; generated programs report the failing
+// line only.
+func stripStack(out string) string {
+	lines := strings.SplitAfter(out, "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "stack: ") {
+			kept = append(kept, l)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// lineMap maps each line of src to prog.bas, as cyberbasic does for a program without includes.
+func lineMap(src string) *srcmap.Map {
+	m := srcmap.New("prog.bas")
+	for i := range strings.Split(src, "\n") {
+		m.Add("prog.bas", i+1)
+	}
+	return m
+}
+
+// runVM runs src as cyberbasic does and returns what it printed.
+func runVM(t *testing.T, src string) string {
+	t.Helper()
+	chunk, err := compiler.New().CompileWithOptions(src, compiler.CompileOptions{Filename: "prog.bas", SourceMap: lineMap(src)})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	mode := runtime.DetectWindowMode(src)
+	rt := runtime.NewRuntime()
+	rt.GetVM().LoadChunk(chunk)
+	std.RegisterEnums(chunk.Enums)
+	rt.GetVM().SetRuntime(rt)
+	if err := bindings.RegisterAll(rt.GetVM(), bindings.RegisterOptions{Source: src, Mode: &mode}); err != nil {
+		t.Fatalf("register bindings: %v", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		var b bytes.Buffer
+		_, _ = io.Copy(&b, r)
+		out <- b.String()
+	}()
+	err = rt.GetVM().Run()
+	if err == nil && rt.HasImplicitHandlers() && mode != runtime.ModeExplicit {
+		err = rt.RunImplicitLoop()
+	}
+	if err != nil {
+		fmt.Printf("Runtime error: %v\n", err)
+	}
+	os.Stdout = stdout
+	w.Close()
+	return <-out
+}
+
+// runGo translates src to Go, runs it and returns what it printed.
+func runGo(t *testing.T, src string) string {
+	t.Helper()
+	program, err := compiler.New().Parse(src)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	code, err := Generate(program, Options{Mode: runtime.DetectWindowMode(src).String(), SourceMap: lineMap(src)})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	// The generated program imports packages of this module, so it is built inside it.
+	dir, err := os.MkdirTemp(".", "gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", file)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if _, exited := err.(*exec.ExitError); err != nil && (!exited || !strings.Contains(string(out), "Runtime error")) {
+		t.Fatalf("go run: %v\n%s\n%s", err, out, stderr.String())
+	}
+	return string(out)
+}
@@ -0,0 +1,154 @@
+package native
+
+import (
+	"strconv"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Function registers the Sub or Function name (qualified: "player.move") with params parameters, ME included
+// for methods, so the runtime and bindings can call it by name (update, draw, callbacks) and method calls on
+// TYPE instances find it.
+func (p *Program) Function(name string, params int, body func(args []vm.Value) vm.Value) {
+	f := p.v.RegisterFunction(name, params, p.callback(body))
+	p.funcs[f.Name] = f
+	p.bodies[f] = body
+}
+
+// Ref returns the function value of the Sub or Function name (a name used as a value).
+func (p *Program) Ref(name string) vm.Value {
+	return p.funcs[lower(name)]
+}
+
+// Func returns an anonymous FUNCTION with params parameters. body sees the captured parameters of the code
+// around it as Go variables.
+func (p *Program) Func(params int, body func(args []vm.Value) vm.Value) vm.Value {
+	f := vm.NativeFunction("", params, p.callback(body))
+	p.bodies[f] = body
+	return f
+}
+
+// callback adapts body for calls from the runtime. The error it panics with is returned, positioned as an
+// uncaught error (nil for QUIT), and the statement line of the caller is restored.
+func (p *Program) callback(body func(args []vm.Value) vm.Value) func(args []vm.Value) (vm.Value, error) {
+	return func(args []vm.Value) (result vm.Value, err error) {
+		f, line := p.cur, p.cur.line
+		p.invokeDepth++
+		defer func() {
+			p.invokeDepth--
+			if r := recover(); r != nil {
+				result, err = nil, p.uncaught(r)
+			}
+			f.line = line
+		}()
+		return body(args), nil
+	}
+}
+
+// CallValue calls callee, the function value held by a variable or parameter, with args. When callee holds
+// anything else the foreign function name is called instead.
+func (p *Program) CallValue(callee vm.Value, name string, args ...vm.Value) vm.Value {
+	f, ok := callee.(*vm.Function)
+	if !ok {
+		return p.Foreign(name, args...)
+	}
+	if body := p.bodies[f]; body != nil {
+		return body(frame(args, f.Params))
+	}
+	in := make([]interface{}, len(args))
+	for i, a := range args {
+		in[i] = a
+	}
+	return p.onMain(func() (vm.Value, error) { return p.v.Invoke(f, in) })
+}
+
+// Method calls obj.name(args): a method of a TYPE instance, or of a LIST or API handle.
+func (p *Program) Method(obj vm.Value, name string, args ...vm.Value) vm.Value {
+	name = lower(name)
+	if o, ok := obj.(*vm.Object); ok {
+		if f := p.funcs[lower(o.Type.Name)+"."+name]; f != nil {
+			if f.Params-1 != len(args) {
+				errorf("%s.%s expects %d arguments, got %d", o.Type.Name, name, f.Params-1, len(args))
+			}
+			return p.bodies[f](append([]vm.Value{o}, args...))
+		}
+	}
+	return p.onMain(func() (vm.Value, error) { return p.v.CallMethod(obj, name, args) })
+}
+
+// frame returns args laid out for a function with params parameters: missing ones are nil, extra ones dropped.
+func frame(args []vm.Value, params int) []vm.Value {
+	if len(args) == params {
+		return args
+	}
+	out := make([]vm.Value, params)
+	copy(out, args)
+	return out
+}
+
+// Array returns a new DIM array with dimensions dims, filled with 0.
+func (p *Program) Array(dims ...int) vm.Value {
+	size := 1
+	for _, d := range dims {
+		size *= d
+	}
+	arr := make([]vm.Value, size)
+	for i := range arr {
+		arr[i] = 0
+	}
+	return arr
+}
+
+// Load returns the element at indices of the DIM array arr, whose dimensions are dims.
+func (p *Program) Load(arr vm.Value, dims []int, indices ...vm.Value) vm.Value {
+	a := array(arr, "OpLoadArray")
+	return a[check(vm.ArrayOffset(a, dims, indices)).(int)]
+}
+
+// Store assigns val to the element at indices of the DIM array arr, whose dimensions are dims.
+func (p *Program) Store(arr vm.Value, dims []int, val vm.Value, indices ...vm.Value) {
+	a := array(arr, "OpStoreArray")
+	a[check(vm.ArrayOffset(a, dims, indices)).(int)] = val
+}
+
+// Resize returns a new array of dimensions sizes, filled with 0, and its dimensions (REDIM).
+func (p *Program) Resize(sizes ...vm.Value) (vm.Value, []int) {
+	dims := make([]int, len(sizes))
+	for i, s := range sizes {
+		dims[i] = max(toInt(s), 0)
+	}
+	return p.Array(dims...), dims
+}
+
+// Append returns the DIM array arr with val added at the end (APPEND).
+func (p *Program) Append(arr, val vm.Value) vm.Value {
+	return append(array(arr, "OpAppendArray"), val)
+}
+
+// MatMul stores the product of the 2D arrays a and b in r; rd, ad and bd are their dimensions.
+func (p *Program) MatMul(r, a, b vm.Value, rd, ad, bd []int) vm.Value {
+	return check(nil, vm.MatMul(array(r, "OpMatMul"), array(a, "OpMatMul"), array(b, "OpMatMul"), rd, ad, bd))
+}
+
+// array returns v as a DIM array, or panics with op's error.
+func array(v vm.Value, op string) []vm.Value {
+	a, ok := v.([]vm.Value)
+	if !ok {
+		errorf("%s: variable is not an array", op)
+	}
+	return a
+}
+
+// toInt converts a number (or numeric string) to int as the VM does for sizes and indices.
+func toInt(v vm.Value) int {
+	switch x := v.(type) {
+	case int:
+		return x
+	case float64:
+		return int(x)
+	case string:
+		i, _ := strconv.Atoi(x)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,339 @@
+// Package native is the runtime of BASIC programs translated to Go by gogen (cyberbasic --gen-go and
+// --build-native). The generated code runs the program's statements as Go and calls a Program for everything
+// else, so it shares the VM's value semantics, foreign functions, events and TYPE instances with bytecode:
+// arithmetic and builtins go through VM.Apply, names through the binding packages RegisterAll installs.
+//
+// A failing operation panics with its error. Main, Try and the functions the runtime calls back (update, draw,
+// ON handlers, callbacks) recover it, as the VM's TRY handlers and its error reporting do.
+package native
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"cyberbasic/compiler/bindings"
+	"cyberbasic/compiler/bindings/std"
+	"cyberbasic/compiler/runtime"
+	rtframe "cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/replay"
+	"cyberbasic/compiler/srcmap"
+	"cyberbasic/compiler/valueutil"
+	"cyberbasic/compiler/vm"
+)
+
+// Config is what the compiler records about a program besides its code.
+type Config struct {
+	Mode      string                    // window mode (runtime.WindowMode.String())
+	SourceMap *srcmap.Map               // origin of each line when the program #includes files; nil otherwise
+	Types     map[string]*vm.TypeInfo   // TYPE layouts (codegen.TypeInfos)
+	Enums     map[string]vm.EnumMembers // ENUM members, for Enum.getValue and friends
+	Data      []vm.Value                // DATA values in program order
+}
+
+// Program is a translated program while it runs.
+type Program struct {
+	rt     *runtime.Runtime
+	v      *vm.VM
+	mode   runtime.WindowMode
+	smap   *srcmap.Map
+	data   []vm.Value
+	next   int                                             // next DATA value READ takes
+	funcs  map[string]*vm.Function                         // Subs and Functions by qualified lowercase name
+	bodies map[*vm.Function]func(args []vm.Value) vm.Value // Go bodies of funcs and anonymous FUNCTIONs
+	sched
+}
+
+// errQuit ends the program normally (QUIT).
+var errQuit = errors.New("quit")
+
+// New sets up the runtime and bindings for a program, as cyberbasic does before running bytecode. The built
+// program takes cyberbasic's --headless, --frames N, --record file and --replay file flags.
+func New(cfg Config) *Program {
+	opts := runFlags()
+	if opts.headless {
+		headless.Enable()
+	}
+	if opts.record != "" {
+		if err := replay.Record(opts.record); err != nil {
+			fmt.Printf("Error: --record: %v\n", err)
+			os.Exit(1)
+		}
+	} else if opts.replay != "" {
+		n, err := replay.Replay(opts.replay)
+		if err != nil {
+			fmt.Printf("Error: --replay: %v\n", err)
+			os.Exit(1)
+		}
+		if opts.frames == 0 {
+			opts.frames = n
+		}
+	}
+	rt := runtime.NewRuntime()
+	chunk := vm.NewChunk()
+	for name, ti := range cfg.Types {
+		chunk.Types[name] = ti
+	}
+	chunk.Enums = cfg.Enums
+	chunk.SourceMap = cfg.SourceMap
+	v := rt.GetVM()
+	v.LoadChunk(chunk)
+	std.RegisterEnums(chunk.Enums)
+	v.SetRuntime(rt)
+	p := &Program{
+		rt:     rt,
+		v:      v,
+		mode:   runtime.ParseWindowMode(cfg.Mode),
+		smap:   cfg.SourceMap,
+		data:   cfg.Data,
+		funcs:  make(map[string]*vm.Function),
+		bodies: make(map[*vm.Function]func(args []vm.Value) vm.Value),
+	}
+	scripted := opts.record != "" || opts.replay != ""
+	if err := bindings.RegisterAll(v, bindings.RegisterOptions{Mode: &p.mode, Headless: opts.headless, ScriptedInput: scripted}); err != nil {
+		fmt.Printf("Register bindings: %v\n", err)
+		os.Exit(1)
+	}
+	if opts.frames > 0 {
+		rtframe.Limit(opts.frames, func() { panic(errQuit) })
+	}
+	p.initSched()
+	return p
+}
+
+// runOptions are the cyberbasic run flags a built program takes.
+type runOptions struct {
+	headless bool
+	frames   int
+	record   string
+	replay   string
+}
+
+// runFlags parses the --headless, --frames N, --record file and --replay file flags of the command line.
+func runFlags() runOptions {
+	var o runOptions
+	for i := 1; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == "--headless":
+			o.headless = true
+		case (arg == "--frames" || arg == "--record" || arg == "--replay") && i+1 < len(os.Args):
+			i++
+			o.set(arg[2:], os.Args[i])
+		case strings.HasPrefix(arg, "--frames="), strings.HasPrefix(arg, "--record="), strings.HasPrefix(arg, "--replay="):
+			eq := strings.IndexByte(arg, '=')
+			o.set(arg[2:eq], arg[eq+1:])
+		}
+	}
+	return o
+}
+
+func (o *runOptions) set(flag, value string) {
+	switch flag {
+	case "frames":
+		o.frames, _ = strconv.Atoi(value)
+	case "record":
+		o.record = value
+	case "replay":
+		o.replay = value
+	}
+}
+
+// Main runs the program (run is its main part), then the update/draw loop when it has those Subs and does not
+// open the window itself. A runtime error is printed and exits with status 2.
+func (p *Program) Main(run func() vm.Value) {
+	err := p.protect(func() { run() })
+	if err == nil && p.rt.HasImplicitHandlers() && p.mode != runtime.ModeExplicit {
+		err = p.rt.RunImplicitLoop()
+	}
+	if err != nil {
+		fmt.Printf("Runtime error: %v\n", err)
+		p.rt.CloseWindow()
+		replay.Close()
+		os.Exit(2)
+	}
+	p.rt.CloseWindow()
+	if err := replay.Close(); err != nil {
+		fmt.Printf("Warning: --record: %v\n", err)
+	}
+}
+
+// protect runs fn and returns the error it panicked with, as an uncaught error (nil when the program QUIT).
+func (p *Program) protect(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = p.uncaught(r)
+		}
+	}()
+	fn()
+	return nil
+}
+
+// uncaught turns a recovered panic into the error the VM reports for an uncaught one: prefixed with the position
+// of the failing statement, or of the THROW. QUIT and the end of the program are nil.
+func (p *Program) uncaught(r interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		panic(r)
+	}
+	var h *haltError
+	if errors.As(err, &h) {
+		return h.err
+	}
+	if err == errQuit {
+		return nil
+	}
+	pos := p.smap.Lookup(p.cur.line)
+	var se *vm.ScriptError
+	if errors.As(err, &se) && se.Line > 0 {
+		pos = srcmap.Pos{File: se.File, Line: se.Line}
+	}
+	if pos.Line > 0 {
+		return fmt.Errorf("%s: %w", pos, err)
+	}
+	return err
+}
+
+// check panics with err, else returns v.
+func check(v vm.Value, err error) vm.Value {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Line records that the statement at line (of the preprocessed source) runs, for error positions.
+func (p *Program) Line(line int) {
+	p.cur.line = line
+}
+
+// Truthy reports whether v counts as true in a condition.
+func (p *Program) Truthy(v vm.Value) bool {
+	return valueutil.IsTruthy(v)
+}
+
+// Op runs the instruction op on args and returns its result (see VM.Apply): operators, PRINT, STR and the
+// builtins compiled to one instruction.
+func (p *Program) Op(op vm.OpCode, args ...vm.Value) vm.Value {
+	return p.onMain(func() (vm.Value, error) { return p.v.Apply(op, args...) })
+}
+
+// Quit ends the program (QUIT): Main returns normally.
+func (p *Program) Quit() vm.Value {
+	panic(errQuit)
+}
+
+// Foreign calls the foreign function name.
+func (p *Program) Foreign(name string, args ...vm.Value) vm.Value {
+	in := make([]interface{}, len(args))
+	for i, a := range args {
+		in[i] = a
+	}
+	return p.onMain(func() (vm.Value, error) { return p.v.Foreign(name, in) })
+}
+
+// Global returns the global name: a variable set by the bindings, or else the foreign function of that name
+// called without arguments (KEY_W).
+func (p *Program) Global(name string) vm.Value {
+	return p.onMain(func() (vm.Value, error) { return p.v.Global(name) })
+}
+
+// GetProp returns obj.path.
+func (p *Program) GetProp(obj vm.Value, path ...string) vm.Value {
+	return p.onMain(func() (vm.Value, error) { return p.v.GetProp(obj, path) })
+}
+
+// SetProp assigns obj.path = val.
+func (p *Program) SetProp(obj, val vm.Value, path ...string) {
+	p.onMain(func() (vm.Value, error) { return nil, p.v.SetProp(obj, path, val) })
+}
+
+// EntityProp returns the property prop of the ENTITY entity.
+func (p *Program) EntityProp(entity, prop string) vm.Value {
+	return p.onMain(func() (vm.Value, error) { return p.v.EntityProp(entity, prop) })
+}
+
+// SetEntityProp assigns the property prop of the ENTITY entity.
+func (p *Program) SetEntityProp(entity, prop string, val vm.Value) {
+	p.onMain(func() (vm.Value, error) { return nil, p.v.SetEntityProp(entity, prop, val) })
+}
+
+// SetGlobal assigns the global name (an ENTITY's dictionary).
+func (p *Program) SetGlobal(name string, val vm.Value) {
+	p.v.SetGlobal(name, val)
+}
+
+// List returns a new LIST of items.
+func (p *Program) List(items ...vm.Value) vm.Value {
+	return vm.NewList(items...)
+}
+
+// Map returns a new MAP of the key, value pairs kv.
+func (p *Program) Map(kv ...vm.Value) vm.Value {
+	m := vm.NewMap()
+	for i := 0; i+1 < len(kv); i += 2 {
+		check(nil, m.Set(kv[i], kv[i+1]))
+	}
+	return m
+}
+
+// New returns a new instance of the TYPE name with default fields.
+func (p *Program) New(name string) vm.Value {
+	return check(p.v.NewObject(name))
+}
+
+// Each returns the keys and values FOR EACH visits in coll with vars loop variables.
+func (p *Program) Each(coll vm.Value, vars int) (keys, values []vm.Value) {
+	keys, values, err := vm.Each(coll, vars)
+	check(nil, err)
+	return keys, values
+}
+
+// Read returns the next DATA value (READ), or 0 when there are none left.
+func (p *Program) Read() vm.Value {
+	if p.next >= len(p.data) {
+		return float64(0)
+	}
+	p.next++
+	return p.data[p.next-1]
+}
+
+// Restore makes READ start again from the first DATA value.
+func (p *Program) Restore() {
+	p.next = 0
+}
+
+// Draw marks the Sub draw as running, so drawing commands are queued for the frame. Call the function it
+// returns when draw returns.
+func (p *Program) Draw() func() {
+	was := p.v.InsideDraw()
+	p.v.SetInsideDraw(true)
+	return func() { p.v.SetInsideDraw(was) }
+}
+
+// On registers fn, with params parameters, as an ON eventType(key) handler.
+func (p *Program) On(eventType, key string, params int, fn func(args []vm.Value) vm.Value) {
+	p.v.RegisterEventHandler(eventType, key, vm.NativeFunction("", params, p.callback(fn)))
+}
+
+// Emit queues the user event name with args (EMIT).
+func (p *Program) Emit(name vm.Value, args ...vm.Value) {
+	in := make([]interface{}, len(args))
+	for i, a := range args {
+		in[i] = a
+	}
+	p.v.PostEvent(fmt.Sprint(name), "", in...)
+}
+
+// errorf panics with a runtime error.
+func errorf(format string, args ...interface{}) {
+	panic(fmt.Errorf(format, args...))
+}
+
+// lower returns name in the lowercase form names are registered under.
+func lower(name string) string {
+	return strings.ToLower(name)
+}
@@ -0,0 +1,397 @@
+package native
+
+import (
+	"fmt"
+	"time"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Coroutines run on goroutines of their own, one at a time, in the order the VM runs its fibers: the running
+// fiber is first in the queue, YIELD moves it to the back, WAIT and AWAIT park it until its time is up or what
+// it awaits completes. A fiber hands over by waking the next one and blocking on its wake channel.
+//
+// Raylib must be called from the main OS thread, so while the main fiber is blocked it runs the calls the
+// others make to the runtime (foreign functions, instructions) on their behalf.
+
+// fiber is the main program or a coroutine.
+type fiber struct {
+	name    string
+	main    bool
+	wake    chan struct{}
+	line    int // statement running, for error positions
+	done    bool
+	result  vm.Value
+	stopped bool // StopTask: never runs again
+	paused  bool // PauseTask: parked until ResumeTask
+	awaited vm.Value
+	err     error // what AWAIT got when the fiber was woken
+}
+
+// sleeper is a fiber parked by WAIT (until), AWAIT (awaiting) or PauseTask (paused).
+type sleeper struct {
+	f        *fiber
+	until    time.Time
+	awaiting vm.Awaitable
+	paused   bool
+}
+
+type sched struct {
+	cur         *fiber
+	mainFiber   *fiber
+	queue       []*fiber
+	sleeping    []sleeper
+	calls       chan func() // runtime calls of coroutines, run by the main fiber
+	halt        *haltError  // set when a coroutine ended the program
+	invokeDepth int         // calls from the runtime in progress (update, draw, ON handlers)
+}
+
+// haltError ends the program from a coroutine: err is the uncaught error, or nil when the program is over.
+type haltError struct {
+	err error
+}
+
+func (h *haltError) Error() string {
+	if h.err == nil {
+		return "program ended"
+	}
+	return h.err.Error()
+}
+
+func (p *Program) initSched() {
+	p.mainFiber = &fiber{main: true, wake: make(chan struct{}, 1)}
+	p.cur = p.mainFiber
+	p.queue = []*fiber{p.mainFiber}
+	p.calls = make(chan func())
+	reg := func(name string, fn func(string)) {
+		p.v.RegisterForeign(name, func(args []interface{}) (interface{}, error) {
+			if len(args) >= 1 {
+				fn(lower(fmt.Sprint(args[0])))
+			}
+			return nil, nil
+		})
+	}
+	reg("StopTask", p.stopTask)
+	reg("PauseTask", p.pauseTask)
+	reg("ResumeTask", p.resumeTask)
+}
+
+// onMain runs fn on the main fiber's goroutine and panics with its error.
+func (p *Program) onMain(fn func() (vm.Value, error)) vm.Value {
+	if p.cur.main {
+		return check(fn())
+	}
+	var v vm.Value
+	var err error
+	done := make(chan struct{})
+	p.calls <- func() {
+		v, err = fn()
+		close(done)
+	}
+	<-done
+	return check(v, err)
+}
+
+// Start queues a coroutine running the Sub or Function fn with args (StartCoroutine) and returns its task,
+// which AWAIT waits for.
+func (p *Program) Start(fn vm.Value, args ...vm.Value) vm.Value {
+	f := fn.(*vm.Function)
+	body := p.bodies[f]
+	args = frame(args, f.Params)
+	co := &fiber{name: f.Name, wake: make(chan struct{}, 1)}
+	p.queue = append(p.queue, co)
+	go func() {
+		p.park(co)
+		var result vm.Value
+		quit := true
+		err := p.protect(func() {
+			result = body(args)
+			quit = false
+		})
+		if quit && err == nil {
+			p.end(nil) // QUIT in a coroutine ends the program too
+			return
+		}
+		p.finish(co, result, err)
+	}()
+	return &task{co}
+}
+
+// task is the value StartCoroutine gives a program.
+type task struct {
+	f *fiber
+}
+
+// Poll reports whether the coroutine has returned, with its return value.
+func (t *task) Poll() (bool, vm.Value, error) {
+	return t.f.done, t.f.result, nil
+}
+
+func (t *task) String() string { return "task " + t.f.name }
+
+// Yield lets the other queued fibers run first (YIELD).
+func (p *Program) Yield() {
+	f := p.cur
+	if f.stopped || f.paused {
+		p.suspend(f)
+		return
+	}
+	if len(p.queue) < 2 {
+		return
+	}
+	p.queue = append(p.queue[1:], f)
+	p.switchFrom(f)
+}
+
+// Wait parks the running fiber for sec seconds; other fibers run meanwhile (WAIT).
+func (p *Program) Wait(sec vm.Value) {
+	var s float64
+	switch v := sec.(type) {
+	case float64:
+		s = v
+	case int:
+		s = float64(v)
+	default:
+		s = 1
+	}
+	if s <= 0 {
+		return
+	}
+	f := p.cur
+	p.dequeue(f)
+	p.sleeping = append(p.sleeping, sleeper{f: f, until: time.Now().Add(time.Duration(s * float64(time.Second)))})
+	p.switchFrom(f)
+}
+
+// Await returns the result of x: a value that is not awaitable is its own result; otherwise the running fiber
+// parks until it completes. Inside a call from the runtime other fibers cannot run, so only a Future is waited
+// for there, by blocking.
+func (p *Program) Await(x vm.Value) vm.Value {
+	a, ok := x.(vm.Awaitable)
+	if !ok {
+		return x
+	}
+	done, val, err := a.Poll()
+	switch {
+	case done:
+	case p.invokeDepth > 0:
+		fut, ok := a.(*vm.Future)
+		if !ok {
+			errorf("AWAIT %v cannot finish inside a handler called by the runtime; await it from a coroutine", a)
+		}
+		val, err = fut.Wait()
+	default:
+		f := p.cur
+		p.dequeue(f)
+		p.sleeping = append(p.sleeping, sleeper{f: f, awaiting: a})
+		p.switchFrom(f)
+		val, err = f.awaited, f.err
+	}
+	return check(val, err)
+}
+
+// suspend takes f, stopped or paused by a task command while running, off the queue at its next YIELD.
+func (p *Program) suspend(f *fiber) {
+	p.dequeue(f)
+	if f.paused {
+		p.sleeping = append(p.sleeping, sleeper{f: f, paused: true})
+	}
+	p.switchFrom(f)
+}
+
+// switchFrom hands over from f, which has just been requeued or parked, to the next runnable fiber, and
+// returns once f runs again.
+func (p *Program) switchFrom(f *fiber) {
+	next := p.nextRunnable()
+	if next == f {
+		return
+	}
+	if next == nil {
+		if f.main {
+			panic(&haltError{})
+		}
+		p.end(nil)
+	} else {
+		p.resume(next)
+	}
+	p.park(f)
+}
+
+// park blocks f until it is resumed. The main fiber runs the calls of the others meanwhile, and ends the
+// program when a coroutine has halted it.
+func (p *Program) park(f *fiber) {
+	var calls chan func()
+	if f.main {
+		calls = p.calls
+	}
+	for {
+		select {
+		case <-f.wake:
+			if f.main && p.halt != nil {
+				panic(p.halt)
+			}
+			return
+		case call := <-calls:
+			call()
+		}
+	}
+}
+
+// resume makes f the running fiber.
+func (p *Program) resume(f *fiber) {
+	p.cur = f
+	f.wake <- struct{}{}
+}
+
+// finish ends the coroutine f, which returned result or failed with err.
+func (p *Program) finish(f *fiber, result vm.Value, err error) {
+	f.done, f.result = true, result
+	if err != nil || (len(p.queue) <= 1 && len(p.sleeping) == 0) {
+		p.end(err)
+		return
+	}
+	p.dequeue(f)
+	if next := p.nextRunnable(); next != nil {
+		p.resume(next)
+	} else {
+		p.end(nil)
+	}
+}
+
+// end stops every fiber: the main fiber wakes and Main reports err (nil when the program simply ends).
+func (p *Program) end(err error) {
+	p.halt = &haltError{err}
+	p.resume(p.mainFiber)
+}
+
+// nextRunnable returns the first queued fiber, waking sleepers that are due (sleeping while none is), or nil
+// when no fiber can ever run again.
+func (p *Program) nextRunnable() *fiber {
+	for {
+		p.wakeSleeping()
+		if len(p.queue) > 0 {
+			return p.queue[0]
+		}
+		wait := time.Duration(-1)
+		for _, s := range p.sleeping {
+			if s.paused {
+				continue
+			}
+			d := time.Millisecond
+			if s.awaiting == nil {
+				d = max(time.Until(s.until), 0)
+			}
+			if wait < 0 || d < wait {
+				wait = d
+			}
+		}
+		if wait < 0 {
+			return nil
+		}
+		time.Sleep(wait)
+	}
+}
+
+// wakeSleeping queues the sleepers whose time is up or whose awaited value has completed.
+func (p *Program) wakeSleeping() {
+	now := time.Now()
+	still := p.sleeping[:0]
+	for _, s := range p.sleeping {
+		switch {
+		case s.paused:
+			still = append(still, s)
+		case s.awaiting != nil:
+			done, val, err := s.awaiting.Poll()
+			if !done {
+				still = append(still, s)
+				continue
+			}
+			s.f.awaited, s.f.err = val, err
+			p.queue = append(p.queue, s.f)
+		case !now.Before(s.until):
+			p.queue = append(p.queue, s.f)
+		default:
+			still = append(still, s)
+		}
+	}
+	p.sleeping = still
+}
+
+// dequeue removes f from the run queue.
+func (p *Program) dequeue(f *fiber) {
+	for i, q := range p.queue {
+		if q == f {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// fibers returns the coroutines named name, queued or sleeping, besides the running one.
+func (p *Program) fibers(name string) []*fiber {
+	var out []*fiber
+	for _, f := range p.queue {
+		if f.name == name && f != p.cur {
+			out = append(out, f)
+		}
+	}
+	for _, s := range p.sleeping {
+		if s.f.name == name {
+			out = append(out, s.f)
+		}
+	}
+	return out
+}
+
+// stopTask ends the coroutines named name (StopTask); AWAIT on their tasks returns nil.
+func (p *Program) stopTask(name string) {
+	for _, f := range p.fibers(name) {
+		f.stopped, f.done = true, true
+		p.dequeue(f)
+	}
+	still := p.sleeping[:0]
+	for _, s := range p.sleeping {
+		if !s.f.stopped {
+			still = append(still, s)
+		}
+	}
+	p.sleeping = still
+	if p.cur.name == name && !p.cur.main {
+		p.cur.stopped, p.cur.done = true, true
+	}
+}
+
+// pauseTask parks the queued coroutines named name until ResumeTask (PauseTask).
+func (p *Program) pauseTask(name string) {
+	for _, f := range p.queue {
+		if f.name == name && !f.main && f != p.cur {
+			f.paused = true
+			p.sleeping = append(p.sleeping, sleeper{f: f, paused: true})
+		}
+	}
+	for _, s := range p.sleeping {
+		if s.paused {
+			p.dequeue(s.f)
+		}
+	}
+	if p.cur.name == name && !p.cur.main {
+		p.cur.paused = true
+	}
+}
+
+// resumeTask queues the paused coroutines named name again (ResumeTask).
+func (p *Program) resumeTask(name string) {
+	still := p.sleeping[:0]
+	for _, s := range p.sleeping {
+		if s.paused && s.f.name == name {
+			s.f.paused = false
+			p.queue = append(p.queue, s.f)
+			continue
+		}
+		still = append(still, s)
+	}
+	p.sleeping = still
+	if p.cur.name == name {
+		p.cur.paused = false
+	}
+}
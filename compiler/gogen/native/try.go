@@ -0,0 +1,80 @@
+package native
+
+import (
+	"errors"
+	"fmt"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Flow is how a block run by Try ended: Next when it ran to its end, otherwise a RETURN, EXIT or CONTINUE
+// the translated code carries out once FINALLY has run (gogen numbers them per function).
+type Flow int
+
+// Next is the Flow of a block that ran to its end.
+const Next Flow = 0
+
+// Try runs TRY body CATCH catch FINALLY finally (catch and finally may be nil). An error in body is passed to
+// catch as an error object; one in catch, or in body without a CATCH, is raised again after FINALLY. QUIT and
+// the end of the program are never caught.
+func (p *Program) Try(body func() Flow, catch func(e vm.Value) Flow, finally func() Flow) Flow {
+	flow, err := p.attempt(body)
+	if err != nil && catch != nil {
+		e := p.errorObject(err)
+		flow, err = p.attempt(func() Flow { return catch(e) })
+	}
+	if finally != nil {
+		if f := finally(); f != Next {
+			return f
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
+	return flow
+}
+
+// attempt runs fn and returns the error it panicked with.
+func (p *Program) attempt(fn func() Flow) (flow Flow, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			var h *haltError
+			if !ok || e == errQuit || errors.As(e, &h) {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return fn(), nil
+}
+
+// errorObject converts err into the error object CATCH receives. Thrown error objects keep their origin.
+func (p *Program) errorObject(err error) *vm.ScriptError {
+	var se *vm.ScriptError
+	if errors.As(err, &se) {
+		return se
+	}
+	pos := p.smap.Lookup(p.cur.line)
+	obj := &vm.ScriptError{Message: err.Error(), File: pos.File, Line: pos.Line}
+	var fe *vm.ForeignError
+	if errors.As(err, &fe) {
+		obj.Func = fe.Name
+		obj.Message = fe.Err.Error()
+	}
+	obj.Value = obj.Message
+	return obj
+}
+
+// Throw raises v as an error (THROW): an error object as it is, anything else as the message of a new one.
+func (p *Program) Throw(v vm.Value) {
+	if se, ok := v.(*vm.ScriptError); ok {
+		panic(se)
+	}
+	pos := p.smap.Lookup(p.cur.line)
+	msg, ok := v.(string)
+	if !ok {
+		msg = fmt.Sprintf("%v", v)
+	}
+	panic(&vm.ScriptError{Message: msg, File: pos.File, Line: pos.Line, Value: v})
+}
@@ -0,0 +1,855 @@
+package gogen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler/codegen"
+	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/vm"
+)
+
+func (t *translator) stmts(list []parser.Node) error {
+	for _, s := range list {
+		if err := t.stmt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stmt translates one statement as codegen's compileStatement compiles it.
+func (t *translator) stmt(node parser.Node) error {
+	if line := lineOf(node); line > 0 {
+		t.emit("rt.Line(%d)", line)
+	}
+	switch n := node.(type) {
+	case *parser.Assignment:
+		return t.assignment(n)
+	case *parser.CompoundAssign:
+		return t.compoundAssign(n)
+	case *parser.Call:
+		x, err := t.call(n)
+		if err != nil {
+			return err
+		}
+		t.exprStmt(x)
+	case *parser.IfStatement:
+		return t.ifStmt(n)
+	case *parser.ForStatement:
+		return t.forStmt(n)
+	case *parser.ForEachStatement:
+		return t.forEach(n)
+	case *parser.WhileStatement:
+		return t.whileStmt(n)
+	case *parser.MainLoopStatement:
+		return t.mainLoop(n)
+	case *parser.RepeatStatement:
+		return t.repeat(n)
+	case *parser.FunctionDecl, *parser.SubDecl:
+		t.decls = append(t.decls, n)
+	case *parser.ReturnStatement:
+		return t.returnStmt(n)
+	case *parser.DimStatement:
+		return t.dim(n)
+	case *parser.RedimStatement:
+		return t.redim(n)
+	case *parser.AppendStatement:
+		if !t.isVar(n.Variable) {
+			return fmt.Errorf("variable %s not declared for APPEND", n.Variable)
+		}
+		val, err := t.expr(n.Value)
+		if err != nil {
+			return err
+		}
+		g := goName("v_", n.Variable)
+		t.emit("%s = rt.Append(%s, %s)", g, g, val)
+	case *parser.ConstStatement:
+		for _, d := range n.Decls {
+			val, err := codegen.ConstValue(d.Value)
+			if err != nil {
+				return err
+			}
+			t.consts[strings.ToLower(d.Name)] = literal(val)
+		}
+	case *parser.EnumStatement:
+		members, err := codegen.EnumMembers(n)
+		if err != nil {
+			return err
+		}
+		for _, m := range n.Members {
+			t.consts[strings.ToLower(m.Name)] = literal(members[strings.ToLower(m.Name)])
+		}
+	case *parser.TypeDecl, *parser.Identifier:
+	case *parser.EntityDecl:
+		dict := `rt.Foreign("createdict")`
+		for _, p := range n.Properties {
+			val, err := t.expr(p.Value)
+			if err != nil {
+				return err
+			}
+			dict = fmt.Sprintf(`rt.Foreign("setdictkey", %s, %q, %s)`, dict, p.Name, val)
+		}
+		t.emit("rt.SetGlobal(%q, %s)", strings.ToLower(n.Name), dict)
+	case *parser.GameCommand:
+		args, err := t.exprList(n.Arguments)
+		if err != nil {
+			return err
+		}
+		op, ok := codegen.GameCommand(n.Command)
+		if !ok {
+			return fmt.Errorf("unsupported game command: %s", n.Command)
+		}
+		t.emit("rt.Op(%s)", opArgs(op, args))
+	case *parser.SelectCaseStatement:
+		return t.selectCase(n)
+	case *parser.ExitLoopStatement:
+		if len(t.fn.loops) == 0 {
+			return fmt.Errorf("EXIT/BREAK %s outside loop", n.Kind)
+		}
+		l := t.fn.loops[len(t.fn.loops)-1]
+		l.used = true
+		t.jump(2*l.n+2, l.tries, "break "+l.label)
+	case *parser.ContinueLoopStatement:
+		if len(t.fn.loops) == 0 {
+			return fmt.Errorf("CONTINUE %s outside loop", n.Kind)
+		}
+		l := t.fn.loops[len(t.fn.loops)-1]
+		l.used = true
+		t.jump(2*l.n+3, l.tries, "continue "+l.label)
+	case *parser.AssertStatement:
+		cond, err := t.expr(n.Condition)
+		if err != nil {
+			return err
+		}
+		msg := `"assertion failed"`
+		if n.Message != nil {
+			if msg, err = t.expr(n.Message); err != nil {
+				return err
+			}
+		}
+		t.emit(`rt.Foreign("assert", %s, %s)`, cond, msg)
+	case *parser.OnEventStatement:
+		t.handlers = append(t.handlers, n)
+		t.emit("rt.On(%q, %q, %d, h%d)", strings.ToLower(n.EventType), n.Key, len(n.Params), len(t.handlers)-1)
+	case *parser.StartCoroutineStatement:
+		x, err := t.startTask(n)
+		if err != nil {
+			return err
+		}
+		t.emit("%s", x)
+	case *parser.AwaitExpression:
+		x, err := t.expr(n)
+		if err != nil {
+			return err
+		}
+		t.emit("%s", x)
+	case *parser.YieldStatement:
+		t.emit("rt.Yield()")
+	case *parser.WaitSecondsStatement:
+		sec, err := t.expr(n.Seconds)
+		if err != nil {
+			return err
+		}
+		t.emit("rt.Wait(%s)", sec)
+	case *parser.WaitFramesStatement:
+		frames, err := t.expr(n.Frames)
+		if err != nil {
+			return err
+		}
+		t.emit("rt.Wait(rt.Op(vm.OpDiv, %s, 60.0))", frames)
+	case *parser.DataStatement:
+		// the values are in the program's Config
+	case *parser.ReadStatement:
+		for _, v := range n.Variables {
+			name := ""
+			switch r := v.(type) {
+			case *parser.Identifier:
+				name = r.Name
+			case *parser.Call:
+				if len(r.Arguments) > 0 {
+					name = r.Name // READ a(i) reads into a, as the bytecode does
+				}
+			}
+			if name == "" {
+				return withLine(v, fmt.Errorf("READ requires variable name"))
+			}
+			t.emit("%s = rt.Read()", t.declare(name))
+		}
+	case *parser.RestoreStatement:
+		t.emit("rt.Restore()")
+	case *parser.GosubStatement:
+		name := strings.ToLower(n.SubName)
+		if !t.sem.UserFuncs[name] {
+			return fmt.Errorf("unknown sub for GOSUB: %s", n.SubName)
+		}
+		t.emit("%s", t.userCall(name, nil))
+	case *parser.TryStatement:
+		return t.try(n)
+	case *parser.TestStatement:
+		return t.stmts(n.Lowered().Statements)
+	case *parser.ThrowStatement:
+		val, err := t.expr(n.Value)
+		if err != nil {
+			return err
+		}
+		t.emit("rt.Throw(%s)", val)
+	case *parser.EmitStatement:
+		return t.emitStmt(n)
+	default:
+		return withLine(node, fmt.Errorf("unsupported statement type: %T", node))
+	}
+	return nil
+}
+
+// exprStmt writes the expression x as a statement.
+func (t *translator) exprStmt(x string) {
+	if strings.HasSuffix(x, ")") && (strings.HasPrefix(x, "rt.") || strings.HasPrefix(x, "f_") || strings.HasPrefix(x, "func(")) {
+		t.emit("%s", x)
+		return
+	}
+	t.emit("_ = %s", x)
+}
+
+// storeName assigns val to the parameter name of the current scope, or else to the variable name.
+func (t *translator) storeName(name, val string) {
+	if g, ok := t.param(name); ok {
+		t.emit("%s = %s", g, val)
+		return
+	}
+	t.emit("%s = %s", t.declare(name), val)
+}
+
+func (t *translator) assignment(a *parser.Assignment) error {
+	if len(a.Indices) == 0 && strings.Contains(a.Variable, ".") {
+		parts := strings.SplitN(a.Variable, ".", 2)
+		if entity := strings.ToLower(parts[0]); t.sem.EntityNames[entity] {
+			val, err := t.expr(a.Value)
+			if err != nil {
+				return err
+			}
+			t.emit("rt.SetEntityProp(%q, %q, %s)", entity, parts[1], val)
+			return nil
+		}
+		parts = strings.Split(a.Variable, ".")
+		if !namespaceRoot(parts[0]) {
+			obj, err := t.identifier(&parser.Identifier{Name: parts[0], Line: a.Line, Col: a.Col})
+			if err != nil {
+				return err
+			}
+			val, err := t.expr(a.Value)
+			if err != nil {
+				return err
+			}
+			path, err := propPath(parts[1:])
+			if err != nil {
+				return err
+			}
+			t.emit("rt.SetProp(%s, %s, %s)", obj, val, path)
+			return nil
+		}
+	}
+
+	if _, isArray := t.dims[strings.ToLower(a.Variable)]; len(a.Indices) == 1 && !isArray {
+		// coll[i] = value on a LIST, MAP or dictionary, possibly a field path (player.items[0] = 1)
+		parts := strings.Split(a.Variable, ".")
+		coll, err := t.identifier(&parser.Identifier{Name: parts[0], Line: a.Line, Col: a.Col})
+		if err != nil {
+			return err
+		}
+		if len(parts) > 1 {
+			path, err := propPath(parts[1:])
+			if err != nil {
+				return err
+			}
+			coll = fmt.Sprintf("rt.GetProp(%s, %s)", coll, path)
+		}
+		idx, err := t.expr(a.Indices[0])
+		if err != nil {
+			return err
+		}
+		val, err := t.expr(a.Value)
+		if err != nil {
+			return err
+		}
+		t.emit("rt.Op(vm.OpSetIndex, %s, %s, %s)", coll, idx, val)
+		return nil
+	}
+
+	val, err := t.expr(a.Value)
+	if err != nil {
+		return err
+	}
+	if len(a.Indices) > 0 {
+		indices, err := t.exprList(a.Indices)
+		if err != nil {
+			return err
+		}
+		if !t.isVar(a.Variable) {
+			return withLine(a, fmt.Errorf("array variable not declared: %s", a.Variable))
+		}
+		t.emit("rt.Store(%s, %s, %s, %s)", goName("v_", a.Variable), t.dimsVar(a.Variable), val, strings.Join(indices, ", "))
+		return nil
+	}
+	t.storeName(a.Variable, val)
+	return nil
+}
+
+func (t *translator) compoundAssign(ca *parser.CompoundAssign) error {
+	ops := map[string]string{"+=": "vm.OpAdd", "-=": "vm.OpSub", "*=": "vm.OpMul", "/=": "vm.OpDiv"}
+	target, ok := t.param(ca.Variable)
+	if !ok {
+		target = t.declare(ca.Variable)
+	}
+	val, err := t.expr(ca.Value)
+	if err != nil {
+		return err
+	}
+	op, ok := ops[ca.Op]
+	if !ok {
+		return withLine(ca, fmt.Errorf("unsupported compound assign op: %s", ca.Op))
+	}
+	t.emit("%s = rt.Op(%s, %s, %s)", target, op, target, val)
+	return nil
+}
+
+func (t *translator) ifStmt(n *parser.IfStatement) error {
+	cond, err := t.expr(n.Condition)
+	if err != nil {
+		return err
+	}
+	t.emit("if rt.Truthy(%s) {", cond)
+	if err := t.stmts(n.ThenBlock.Statements); err != nil {
+		return err
+	}
+	for _, b := range n.ElseIfs {
+		// An ELSEIF condition may declare variables, so it is translated after the blocks before it.
+		cond, err := t.expr(b.Condition)
+		if err != nil {
+			return err
+		}
+		t.emit("} else if rt.Truthy(%s) {", cond)
+		if err := t.stmts(b.Block.Statements); err != nil {
+			return err
+		}
+	}
+	if n.ElseBlock != nil {
+		t.emit("} else {")
+		if err := t.stmts(n.ElseBlock.Statements); err != nil {
+			return err
+		}
+	}
+	t.emit("}")
+	return nil
+}
+
+func (t *translator) selectCase(s *parser.SelectCaseStatement) error {
+	x, err := t.expr(s.Expr)
+	if err != nil {
+		return err
+	}
+	if len(s.Cases) == 0 && s.ElseBlock == nil {
+		t.emit("_ = %s", x)
+		return nil
+	}
+	sel := t.temp("sel")
+	t.emit("{")
+	t.emit("%s := %s", sel, x)
+	if len(s.Cases) == 0 {
+		t.emit("_ = %s", sel)
+	}
+	for i, c := range s.Cases {
+		val, err := t.expr(c.Value)
+		if err != nil {
+			return err
+		}
+		kw := "if"
+		if i > 0 {
+			kw = "} else if"
+		}
+		t.emit("%s rt.Truthy(rt.Op(vm.OpEqual, %s, %s)) {", kw, sel, val)
+		if err := t.stmts(c.Block.Statements); err != nil {
+			return err
+		}
+	}
+	if s.ElseBlock != nil {
+		if len(s.Cases) > 0 {
+			t.emit("} else {")
+		}
+		if err := t.stmts(s.ElseBlock.Statements); err != nil {
+			return err
+		}
+	}
+	if len(s.Cases) > 0 {
+		t.emit("}")
+	}
+	t.emit("}")
+	return nil
+}
+
+// pushLoop opens a loop scope for EXIT and CONTINUE.
+func (t *translator) pushLoop() *loop {
+	t.seq++
+	l := &loop{label: fmt.Sprintf("L%d", t.seq), n: t.seq, tries: t.fn.tries}
+	t.fn.loops = append(t.fn.loops, l)
+	return l
+}
+
+func (t *translator) popLoop() {
+	t.fn.loops = t.fn.loops[:len(t.fn.loops)-1]
+}
+
+// loopHeader writes the label of l, when EXIT or CONTINUE use it, and the for clause.
+func (t *translator) loopHeader(l *loop, clause string) {
+	if l.used {
+		t.emit("%s:", l.label)
+	}
+	t.emit("for %s{", clause)
+}
+
+func (t *translator) forStmt(f *parser.ForStatement) error {
+	l := t.pushLoop()
+	defer t.popLoop()
+	start, err := t.expr(f.Start)
+	if err != nil {
+		return err
+	}
+	v := t.declare(f.Variable)
+	t.emit("%s = %s", v, start)
+	counter, err := t.identifier(&parser.Identifier{Name: f.Variable})
+	if err != nil {
+		return err
+	}
+	end, err := t.expr(f.End)
+	if err != nil {
+		return err
+	}
+	body, err := t.capture(func() error { return t.stmts(f.Body.Statements) })
+	if err != nil {
+		return err
+	}
+	step := "1"
+	if f.Step != nil {
+		if step, err = t.expr(f.Step); err != nil {
+			return err
+		}
+	}
+	t.loopHeader(l, fmt.Sprintf("; !rt.Truthy(rt.Op(vm.OpGreater, %s, %s)); %s = rt.Op(vm.OpAdd, %s, %s) ", counter, end, v, counter, step))
+	t.out.WriteString(body)
+	t.emit("}")
+	return nil
+}
+
+func (t *translator) forEach(fe *parser.ForEachStatement) error {
+	vars := 1
+	if fe.Key != "" {
+		vars = 2
+	}
+	for _, name := range []string{fe.Variable, fe.Key} {
+		if _, isParam := t.param(name); name != "" && !isParam {
+			t.declare(name)
+		}
+	}
+	coll, err := t.expr(fe.Collection)
+	if err != nil {
+		return err
+	}
+	l := t.pushLoop()
+	defer t.popLoop()
+	keys, vals, i := fmt.Sprintf("keys%d", l.n), fmt.Sprintf("values%d", l.n), fmt.Sprintf("i%d", l.n)
+	body, err := t.capture(func() error {
+		t.storeName(fe.Variable, vals+"["+i+"]")
+		if fe.Key != "" {
+			t.storeName(fe.Key, keys+"["+i+"]")
+		}
+		return t.stmts(fe.Body.Statements)
+	})
+	if err != nil {
+		return err
+	}
+	if fe.Key == "" {
+		keys = "_"
+	}
+	t.emit("%s, %s := rt.Each(%s, %d)", keys, vals, coll, vars)
+	t.loopHeader(l, fmt.Sprintf("%s := range %s ", i, vals))
+	t.out.WriteString(body)
+	t.emit("}")
+	return nil
+}
+
+func (t *translator) whileStmt(w *parser.WhileStatement) error {
+	l := t.pushLoop()
+	defer t.popLoop()
+	frame := codegen.GameLoopFrame(w.Condition, false, w.Body, t.sem.UserFuncs)
+	cond, err := t.expr(w.Condition)
+	if err != nil {
+		return err
+	}
+	body, err := t.capture(func() error { return t.framedBody(frame, w.Body.Statements) })
+	if err != nil {
+		return err
+	}
+	t.loopHeader(l, fmt.Sprintf("rt.Truthy(%s) ", cond))
+	t.out.WriteString(body)
+	t.emit("}")
+	return nil
+}
+
+func (t *translator) mainLoop(m *parser.MainLoopStatement) error {
+	l := t.pushLoop()
+	defer t.popLoop()
+	body := m.Body
+	if body == nil {
+		body = &parser.Block{}
+	}
+	code, err := t.capture(func() error { return t.framedBody(codegen.MainLoopFrame(m.Body, t.sem.UserFuncs), body.Statements) })
+	if err != nil {
+		return err
+	}
+	t.loopHeader(l, `rt.Truthy(rt.Op(vm.OpNot, rt.Foreign("windowshouldclose"))) `)
+	t.out.WriteString(code)
+	t.emit("}")
+	return nil
+}
+
+// repeat translates REPEAT ... UNTIL, which EXIT and CONTINUE do not apply to.
+func (t *translator) repeat(r *parser.RepeatStatement) error {
+	frame := codegen.GameLoopFrame(r.Condition, true, r.Body, t.sem.UserFuncs)
+	body, err := t.capture(func() error { return t.framedBody(frame, r.Body.Statements) })
+	if err != nil {
+		return err
+	}
+	cond, err := t.expr(r.Condition)
+	if err != nil {
+		return err
+	}
+	t.emit("for {")
+	t.out.WriteString(body)
+	t.emit("if rt.Truthy(%s) {", cond)
+	t.emit("break")
+	t.emit("}")
+	t.emit("}")
+	return nil
+}
+
+// framedBody translates one pass of a loop body framed for drawing as codegen frames it.
+func (t *translator) framedBody(f codegen.LoopFrame, body []parser.Node) error {
+	if f.Hybrid {
+		t.emit(`rt.Foreign("stepframe")`)
+		return nil
+	}
+	mode := strings.ToLower(f.Mode())
+	if f.Wrap {
+		t.emit(`rt.Foreign("begindrawing")`)
+		t.emit(`rt.Foreign("begin%s")`, mode)
+	}
+	for _, s := range body {
+		if f.EndsModeAt(s) {
+			t.emit(`rt.Foreign("end%s")`, mode)
+		}
+		if err := t.stmt(s); err != nil {
+			return err
+		}
+	}
+	if f.Wrap && !f.Sync {
+		t.emit(`rt.Foreign("end%s")`, mode)
+		t.emit(`rt.Foreign("enddrawing")`)
+	}
+	return nil
+}
+
+func (t *translator) returnStmt(r *parser.ReturnStatement) error {
+	val := "nil"
+	if r.Value != nil {
+		var err error
+		if val, err = t.expr(r.Value); err != nil {
+			return err
+		}
+	}
+	if t.fn.tries == 0 {
+		t.emit("return %s", val)
+		return nil
+	}
+	t.fn.usesRet = true
+	t.emit("ret = %s", val)
+	t.jump(flowReturn, 0, "return ret")
+	return nil
+}
+
+// epilogue returns the trailing "return nil" of a translated body, or "" when stmts cannot fall through (the
+// last statement is a RETURN, or an IF whose branches all end in one) and Go would flag it as unreachable.
+func epilogue(stmts []parser.Node) string {
+	if terminates(stmts) {
+		return ""
+	}
+	return "return nil\n"
+}
+
+func terminates(stmts []parser.Node) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+	switch n := stmts[len(stmts)-1].(type) {
+	case *parser.ReturnStatement:
+		return true
+	case *parser.IfStatement:
+		if n.ElseBlock == nil || !terminates(n.ThenBlock.Statements) || !terminates(n.ElseBlock.Statements) {
+			return false
+		}
+		for _, b := range n.ElseIfs {
+			if !terminates(b.Block.Statements) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// jump carries out stmt (a RETURN, EXIT or CONTINUE) whose target lies outside tries TRY blocks. Inside the
+// closure of a deeper TRY the closure returns code instead; rt.Try runs FINALLY, and the code after each Try
+// passes the code on until the TRY the target is in, which runs stmt.
+func (t *translator) jump(code, tries int, stmt string) {
+	if t.fn.tries == tries {
+		t.emit("%s", stmt)
+		return
+	}
+	t.fn.jumps[code] = jump{tries: tries, stmt: stmt}
+	for i := tries; i < t.fn.tries; i++ {
+		t.fn.escapes[i][code] = true
+	}
+	t.emit("return %d", code)
+}
+
+func (t *translator) try(n *parser.TryStatement) error {
+	depth := t.fn.tries
+	t.fn.tries++
+	t.fn.escapes = append(t.fn.escapes, make(map[int]bool))
+	body, catch, finally, err := t.tryClauses(n)
+	escapes := t.fn.escapes[depth]
+	t.fn.tries--
+	t.fn.escapes = t.fn.escapes[:depth]
+	if err != nil {
+		return err
+	}
+	call := fmt.Sprintf("rt.Try(%s, %s, %s)", body, catch, finally)
+	if len(escapes) == 0 {
+		t.emit("%s", call)
+		return nil
+	}
+	codes := make([]int, 0, len(escapes))
+	for code := range escapes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	t.emit("switch %s {", call)
+	for _, code := range codes {
+		t.emit("case %d:", code)
+		if j := t.fn.jumps[code]; j.tries == depth {
+			t.emit("%s", j.stmt)
+		} else {
+			t.emit("return %d", code)
+		}
+	}
+	t.emit("}")
+	return nil
+}
+
+// tryClauses returns the closures rt.Try runs for the TRY, CATCH and FINALLY blocks ("nil" when absent).
+func (t *translator) tryClauses(n *parser.TryStatement) (body, catch, finally string, err error) {
+	block := func(b *parser.Block) (string, error) {
+		return t.capture(func() error { return t.stmts(b.Statements) })
+	}
+	code, err := block(n.Body)
+	if err != nil {
+		return "", "", "", err
+	}
+	body = "func() native.Flow {\n" + code + "return native.Next\n}"
+	catch, finally = "nil", "nil"
+	if n.CatchBlock != nil {
+		code, err := t.capture(func() error {
+			if n.CatchVar != "" {
+				t.storeName(n.CatchVar, "e")
+			}
+			return t.stmts(n.CatchBlock.Statements)
+		})
+		if err != nil {
+			return "", "", "", err
+		}
+		param := "vm.Value"
+		if n.CatchVar != "" {
+			param = "e vm.Value"
+		}
+		catch = "func(" + param + ") native.Flow {\n" + code + "return native.Next\n}"
+	}
+	if n.FinallyBlock != nil {
+		code, err := block(n.FinallyBlock)
+		if err != nil {
+			return "", "", "", err
+		}
+		finally = "func() native.Flow {\n" + code + "return native.Next\n}"
+	}
+	return body, catch, finally, nil
+}
+
+func (t *translator) dim(d *parser.DimStatement) error {
+	for _, v := range d.Variables {
+		g := t.declare(v.Name)
+		key := strings.ToLower(v.Name)
+		switch {
+		case len(v.Dimensions) > 0:
+			dims := make([]string, len(v.Dimensions))
+			sizes := make([]int, len(v.Dimensions))
+			for i, dn := range v.Dimensions {
+				num, ok := dn.(*parser.Number)
+				if !ok {
+					return fmt.Errorf("dimension %d for %s: array dimension must be a constant number", i+1, v.Name)
+				}
+				val, err := codegen.NumberValue(num.Value)
+				n, isInt := val.(int)
+				if err != nil || !isInt {
+					return fmt.Errorf("dimension %d for %s: array dimension must be a constant number", i+1, v.Name)
+				}
+				if n < 1 {
+					return fmt.Errorf("dimension %d for %s must be >= 1", i+1, v.Name)
+				}
+				sizes[i], dims[i] = n, fmt.Sprint(n)
+			}
+			t.dims[key] = sizes
+			list := strings.Join(dims, ", ")
+			t.emit("%s, %s = rt.Array(%s), []int{%s}", g, t.dimsVar(v.Name), list, list)
+		case v.Dimensions != nil:
+			t.dims[key] = []int{}
+			t.emit("%s, %s = rt.Array(0), []int{}", g, t.dimsVar(v.Name))
+		case t.sem.TypeDefs[strings.ToLower(v.Type)] != nil:
+			t.emit("%s = rt.New(%q)", g, strings.ToLower(v.Type))
+		default:
+			val := "0"
+			switch strings.ToLower(v.Type) {
+			case "string", "str":
+				val = `""`
+			case "float", "single", "double":
+				val = "0.0"
+			case "boolean", "bool":
+				val = "false"
+			}
+			t.emit("%s = %s", g, val)
+		}
+	}
+	return nil
+}
+
+// redim translates REDIM, whose sizes the bytecode evaluates last to first.
+func (t *translator) redim(r *parser.RedimStatement) error {
+	if !t.isVar(r.Variable) {
+		return withLine(r, fmt.Errorf("variable %s not declared for REDIM", r.Variable))
+	}
+	sizes := make([]string, 0, len(r.Dimensions))
+	for i := len(r.Dimensions) - 1; i >= 0; i-- {
+		s, err := t.expr(r.Dimensions[i])
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, s)
+	}
+	t.emit("%s, %s = rt.Resize(%s)", goName("v_", r.Variable), t.dimsVar(r.Variable), strings.Join(sizes, ", "))
+	return nil
+}
+
+func (t *translator) emitStmt(em *parser.EmitStatement) error {
+	if len(em.Args) > 255 {
+		return withLine(em, fmt.Errorf("EMIT supports at most 255 arguments"))
+	}
+	var name string
+	if lit, ok := em.Name.(*parser.StringLiteral); ok {
+		name = literal(strings.ToLower(lit.Value))
+	} else {
+		var err error
+		if name, err = t.expr(em.Name); err != nil {
+			return err
+		}
+	}
+	args, err := t.exprList(em.Args)
+	if err != nil {
+		return err
+	}
+	t.emit("rt.Emit(%s)", strings.Join(append([]string{name}, args...), ", "))
+	return nil
+}
+
+// lineOf returns the source line a statement records for error positions: its own, or else that of the first
+// expression in it that has one.
+func lineOf(node parser.Node) int {
+	if loc, ok := node.(parser.HasSourceLoc); ok {
+		return loc.GetLine()
+	}
+	var first parser.Node
+	switch n := node.(type) {
+	case *parser.IfStatement:
+		first = n.Condition
+	case *parser.WhileStatement:
+		first = n.Condition
+	case *parser.ForStatement:
+		first = n.Start
+	case *parser.ReturnStatement:
+		first = n.Value
+	case *parser.SelectCaseStatement:
+		first = n.Expr
+	case *parser.AssertStatement:
+		first = n.Condition
+	case *parser.WaitSecondsStatement:
+		first = n.Seconds
+	case *parser.GameCommand:
+		if len(n.Arguments) > 0 {
+			first = n.Arguments[0]
+		}
+	case *parser.StartCoroutineStatement:
+		if len(n.Args) > 0 {
+			first = n.Args[0]
+		}
+	}
+	if loc, ok := first.(parser.HasSourceLoc); ok {
+		return loc.GetLine()
+	}
+	return 0
+}
+
+// withLine prefixes err with the line of node, as codegen's errors are.
+func withLine(node parser.Node, err error) error {
+	if line := lineOf(node); line > 0 {
+		return fmt.Errorf("line %d: %w", line, err)
+	}
+	return err
+}
+
+// namespaceRoot reports whether name is one of the namespaces of foreign functions (RL, BOX2D, BULLET, GAME).
+func namespaceRoot(name string) bool {
+	switch strings.ToLower(name) {
+	case "rl", "box2d", "bullet", "game":
+		return true
+	}
+	return false
+}
+
+// propPath returns the Go arguments for the property path (lowercased, as OpGetProp and OpSetProp take it).
+func propPath(path []string) (string, error) {
+	if len(path) < 1 || len(path) > 32 {
+		return "", fmt.Errorf("invalid property path length")
+	}
+	segs := make([]string, len(path))
+	for i, s := range path {
+		segs[i] = literal(strings.ToLower(s))
+	}
+	return strings.Join(segs, ", "), nil
+}
+
+// opArgs returns the arguments of rt.Op for op applied to args.
+func opArgs(op vm.OpCode, args []string) string {
+	return strings.Join(append([]string{"vm." + opName(op)}, args...), ", ")
+}
+
+// opName returns the Go name of the instruction op.
+func opName(op vm.OpCode) string {
+	return "Op" + op.String()
+}
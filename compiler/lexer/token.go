@@ -473,6 +473,11 @@ const (
 	TokenRead       // READ statement
 	TokenRestore    // RESTORE statement
 	TokenGosub      // GOSUB statement
+	TokenTry        // TRY block
+	TokenCatch      // CATCH [var]
+	TokenFinally    // FINALLY
+	TokenEndTry     // ENDTRY (or END TRY)
+	TokenThrow      // THROW expr
 
 	// Operators
 	TokenEqual
@@ -619,6 +624,11 @@ var KeywordMap = map[string]TokenType{
 	"READ":                      TokenRead,
 	"RESTORE":                   TokenRestore,
 	"GOSUB":                     TokenGosub,
+	"TRY":                       TokenTry,
+	"CATCH":                     TokenCatch,
+	"FINALLY":                   TokenFinally,
+	"ENDTRY":                    TokenEndTry,
+	"THROW":                     TokenThrow,
 }
 
 // OperatorMap maps operator strings to token types
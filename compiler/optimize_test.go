@@ -0,0 +1,138 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/optimizer"
+	"cyberbasic/compiler/vm"
+)
+
+// runLevel compiles src at level and runs it, returning what Note logged and the run error text.
+func runLevel(t *testing.T, src string, level optimizer.Level) (*vm.Chunk, string, string) {
+	t.Helper()
+	chunk, err := New().CompileWithOptions(src, CompileOptions{Optimize: level})
+	if err != nil {
+		t.Fatalf("compile -O%d: %v", level, err)
+	}
+	v, log := noteVM()
+	v.LoadChunk(chunk)
+	errText := ""
+	if err := v.Run(); err != nil {
+		errText = err.Error()
+	}
+	return chunk, strings.Join(*log, "|"), errText
+}
+
+// TestOptimizerPreservesBehavior runs programs at -O0 and -O1 and requires the same output and errors.
+func TestOptimizerPreservesBehavior(t *testing.T) {
+	programs := map[string]string{
+		"folding": `CONST W = 800
+CONST NAME = "demo"
+Note(W / 2 + 3 * 4 - 2 ^ 3)
+Note(NAME + " v" + STR(2))
+Note(-W \ 3)
+Note(7 % 3 = 1 AND NOT (2 > 3))
+Note(10 / 4)
+`,
+		"dead branches": `CONST DEBUG = 0
+IF DEBUG THEN
+  Note("debug")
+ELSE
+  Note("release")
+END IF
+IF 1 < 2 THEN
+  Note("yes")
+END IF
+WHILE 0
+  Note("never")
+WEND
+n = 0
+WHILE 1
+  n = n + 1
+  IF n = 3 THEN
+    EXIT WHILE
+  END IF
+WEND
+Note(n)
+`,
+		"loops and functions": `Function Fact(n)
+  If n <= 1 Then
+    Return 1
+  End If
+  Return n * Fact(n - 1)
+End Function
+Sub Show(label, value)
+  Note(label + STR(value))
+End Sub
+total = 0
+For i = 1 To 5
+  For j = 1 To 3
+    If j = 2 Then
+      Continue For
+    End If
+    total = total + i * j
+  Next j
+Next i
+Show("total ", total)
+Show("fact ", Fact(6))
+Select Case 2 + 1
+  Case 1
+    Note("one")
+  Case 3
+    Note("three")
+  Case Else
+    Note("other")
+End Select
+`,
+		"try": `Function Risky(n)
+  If n > 2 Then
+    Throw "too big"
+  End If
+  Return n
+End Function
+Try
+  Note(Risky(5))
+Catch e
+  Note("caught " + e.message + " at " + STR(e.line))
+Finally
+  Note("finally")
+End Try
+Note(Risky(1))
+`,
+		"runtime error": `Note("before")
+x = 1 / 0
+Note(x)
+Note(1 \ 0)
+Note("after")
+`,
+	}
+	for name, src := range programs {
+		t.Run(name, func(t *testing.T) {
+			plain, wantLog, wantErr := runLevel(t, src, optimizer.O0)
+			opt, gotLog, gotErr := runLevel(t, src, optimizer.O1)
+			if gotLog != wantLog || gotErr != wantErr {
+				t.Errorf("-O1 ran %q (err %q), -O0 ran %q (err %q)", gotLog, gotErr, wantLog, wantErr)
+			}
+			if len(opt.Code) >= len(plain.Code) {
+				t.Errorf("-O1 code is %d bytes, -O0 %d; expected it to shrink", len(opt.Code), len(plain.Code))
+			}
+		})
+	}
+}
+
+func TestOptimizerFoldsConstants(t *testing.T) {
+	chunk, err := New().CompileWithOptions("CONST W = 800\nx = W / 2 + 1\nIF 0 THEN\n  x = 2\nEND IF\n", CompileOptions{Optimize: optimizer.O1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []vm.OpCode{vm.OpDiv, vm.OpAdd, vm.OpJumpIfFalse, vm.OpJump} {
+		if chunkContainsOp(chunk, op) {
+			t.Errorf("-O1 chunk still contains %s", op)
+		}
+	}
+	if got := fmt.Sprint(chunk.Constants[chunk.Code[1]]); got != "401" {
+		t.Errorf("first constant loaded = %v, want 401", got)
+	}
+}
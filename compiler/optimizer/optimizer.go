@@ -0,0 +1,383 @@
+// Package optimizer rewrites an emitted Chunk into equivalent, shorter bytecode: constant folding (CONST names are
+// already OpLoadConst after codegen, so they fold too), constant IF/WHILE conditions, jump threading, unreachable code
+// and push/pop pairs. Folding runs the VM's own operators, so folded values are exactly what the program computes.
+package optimizer
+
+import (
+	"cyberbasic/compiler/valueutil"
+	"cyberbasic/compiler/vm"
+)
+
+// Level selects the optimizations Optimize applies (the -O0 / -O1 command-line flags).
+type Level int
+
+const (
+	O0 Level = iota // no optimization: the chunk is returned unchanged
+	O1              // folding, dead branches, jump threading, unreachable code and push/pop removal
+)
+
+// maxPasses bounds the rewrite loop; each pass only shrinks the code, so it settles long before this.
+const maxPasses = 16
+
+// inst is one decoded instruction. Jumps and code targets refer to instruction indices (len(insts) is the end of code).
+type inst struct {
+	vm.Instruction
+	line    int
+	target  int // index of the jump / code target operand's destination (-1 when the op has none)
+	removed bool
+}
+
+// Optimize returns an optimized copy of c (c itself when level is O0 or the chunk cannot be rewritten safely).
+// Function offsets, code targets, line tables and the source map are carried over, so errors, stack traces and
+// breakpoints still report source lines; breakpoints on code that was folded or removed no longer stop.
+func Optimize(c *vm.Chunk, level Level) *vm.Chunk {
+	if level < O1 {
+		return c
+	}
+	insts, ok := decode(c)
+	if !ok {
+		return c
+	}
+	// Folded values are added to a copy of the constant pool; c is never modified.
+	work := *c
+	work.Constants = append([]vm.Value(nil), c.Constants...)
+	o := &pass{chunk: &work, insts: insts}
+	for i := 0; i < maxPasses; i++ {
+		changed := o.fold()
+		changed = o.thread() || changed
+		changed = o.sweep() || changed
+		if !changed {
+			break
+		}
+	}
+	out, ok := o.encode()
+	if !ok {
+		return c
+	}
+	return out
+}
+
+// decode splits c into instructions and resolves jump targets. It refuses chunks with OpCall, whose legacy
+// handling looks at the byte before it, and targets that do not start an instruction.
+func decode(c *vm.Chunk) ([]inst, bool) {
+	var insts []inst
+	index := make(map[int]int)
+	for off := 0; off < len(c.Code); {
+		in, err := c.DecodeInstruction(off)
+		if err != nil || in.Op == vm.OpCall {
+			return nil, false
+		}
+		index[off] = len(insts)
+		insts = append(insts, inst{Instruction: in, line: c.LineAt(off), target: -1})
+		off += in.Size
+	}
+	index[len(c.Code)] = len(insts)
+	for i := range insts {
+		if k := jumpOperand(insts[i].Instruction); k >= 0 {
+			t, ok := index[insts[i].JumpTarget(k)]
+			if !ok {
+				return nil, false
+			}
+			insts[i].target = t
+		}
+	}
+	for _, off := range c.Functions {
+		if _, ok := index[off]; !ok {
+			return nil, false
+		}
+	}
+	return insts, true
+}
+
+// jumpOperand returns the index of in's jump or code-target operand, or -1.
+func jumpOperand(in vm.Instruction) int {
+	for i, k := range in.Kinds {
+		if k == vm.OperandJump || k == vm.OperandTarget {
+			return i
+		}
+	}
+	return -1
+}
+
+type pass struct {
+	chunk *vm.Chunk
+	insts []inst
+}
+
+// next returns the index of the first live instruction at or after i (len(insts) at the end of code).
+func (o *pass) next(i int) int {
+	for i < len(o.insts) && o.insts[i].removed {
+		i++
+	}
+	return i
+}
+
+// targets marks the instructions something jumps to; a rewrite may not swallow them into a preceding pattern.
+func (o *pass) targets() map[int]bool {
+	t := make(map[int]bool)
+	for i := range o.insts {
+		if !o.insts[i].removed && o.insts[i].target >= 0 {
+			t[o.next(o.insts[i].target)] = true
+		}
+	}
+	for _, off := range o.chunk.Functions {
+		for i := range o.insts {
+			if o.insts[i].Offset == off {
+				t[o.next(i)] = true
+			}
+		}
+	}
+	return t
+}
+
+// constant returns the value pushed by a live OpLoadConst / OpLoadString at i.
+func (o *pass) constant(i int) (vm.Value, bool) {
+	if i >= len(o.insts) {
+		return nil, false
+	}
+	in := o.insts[i]
+	if in.Op != vm.OpLoadConst && in.Op != vm.OpLoadString || in.Operands[0] >= len(o.chunk.Constants) {
+		return nil, false
+	}
+	return o.chunk.Constants[in.Operands[0]], true
+}
+
+var unaryOps = map[vm.OpCode]bool{vm.OpNeg: true, vm.OpNot: true}
+
+var binaryOps = map[vm.OpCode]bool{
+	vm.OpAdd: true, vm.OpSub: true, vm.OpMul: true, vm.OpDiv: true, vm.OpMod: true, vm.OpPower: true, vm.OpIntDiv: true,
+	vm.OpEqual: true, vm.OpNotEqual: true, vm.OpLess: true, vm.OpLessEqual: true, vm.OpGreater: true, vm.OpGreaterEqual: true,
+	vm.OpAnd: true, vm.OpOr: true, vm.OpXor: true,
+}
+
+// fold applies the peephole rewrites: constant operators, constant conditional jumps, jumps to the next
+// instruction and pushes that are popped straight away.
+func (o *pass) fold() bool {
+	changed := false
+	isTarget := o.targets()
+	for i := o.next(0); i < len(o.insts); i = o.next(i + 1) {
+		j := o.next(i + 1)
+		k := o.next(j + 1)
+		a, aConst := o.constant(i)
+		if aConst && j < len(o.insts) && !isTarget[j] {
+			if b, bConst := o.constant(j); bConst && k < len(o.insts) && !isTarget[k] && binaryOps[o.insts[k].Op] {
+				if o.replaceWithConstant(i, o.insts[k].Op, []vm.Value{a, b}, i, j, k) {
+					changed = true
+					continue
+				}
+			}
+			switch op := o.insts[j].Op; {
+			case unaryOps[op]:
+				if o.replaceWithConstant(i, op, []vm.Value{a}, i, j) {
+					changed = true
+					continue
+				}
+			case op == vm.OpJumpIfFalse || op == vm.OpJumpIfTrue:
+				// The pair leaves the stack as it found it, so anything jumping to i may land after it instead.
+				o.insts[i].removed = true
+				if valueutil.IsTruthy(a) == (op == vm.OpJumpIfTrue) {
+					o.insts[j].Op = vm.OpJump
+				} else {
+					o.insts[j].removed = true
+				}
+				changed = true
+				continue
+			case op == vm.OpPop:
+				o.insts[i].removed, o.insts[j].removed = true, true
+				changed = true
+				continue
+			}
+		}
+		in := o.insts[i]
+		if in.Op == vm.OpDup && j < len(o.insts) && !isTarget[j] && o.insts[j].Op == vm.OpPop {
+			o.insts[i].removed, o.insts[j].removed = true, true
+			changed = true
+			continue
+		}
+		if in.Op == vm.OpJump && o.next(in.target) == j {
+			o.insts[i].removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// replaceWithConstant evaluates op on args with the VM and turns instruction at into an OpLoadConst of the result,
+// removing the other instructions of the pattern. It declines when the VM reports an error (the program keeps its
+// runtime error), the result is not a poolable constant, or the new instruction would be longer than the pattern.
+func (o *pass) replaceWithConstant(at int, op vm.OpCode, args []vm.Value, pattern ...int) bool {
+	expr := vm.NewChunk()
+	for _, a := range args {
+		if err := expr.Emit(vm.OpLoadConst, expr.WriteConstant(a)); err != nil {
+			return false
+		}
+	}
+	expr.Write(byte(op))
+	result, err := vm.NewVM().Evaluate(expr)
+	if err != nil {
+		return false
+	}
+	switch result.(type) {
+	case string, float64, int, int64, bool:
+	default:
+		return false
+	}
+	size := 0
+	for _, p := range pattern {
+		size += o.insts[p].Size
+	}
+	idx := o.chunk.WriteConstant(result)
+	if idx > 255 && size < 6 || idx <= 255 && size < 2 {
+		return false
+	}
+	o.insts[at].Op = vm.OpLoadConst
+	o.insts[at].Operands = []int{idx}
+	o.insts[at].Kinds = []vm.OperandKind{vm.OperandConst}
+	o.insts[at].Wide = idx > 255
+	for _, p := range pattern {
+		if p != at {
+			o.insts[p].removed = true
+		}
+	}
+	return true
+}
+
+var jumpOps = map[vm.OpCode]bool{vm.OpJump: true, vm.OpJumpIfFalse: true, vm.OpJumpIfTrue: true}
+
+// thread points jumps whose destination is an unconditional jump at that jump's destination.
+func (o *pass) thread() bool {
+	changed := false
+	for i := range o.insts {
+		in := &o.insts[i]
+		if in.removed || !jumpOps[in.Op] {
+			continue
+		}
+		seen := map[int]bool{i: true}
+		for {
+			t := o.next(in.target)
+			if t >= len(o.insts) || o.insts[t].Op != vm.OpJump || seen[t] {
+				break
+			}
+			seen[t] = true
+			if o.next(o.insts[t].target) == o.next(in.target) {
+				break
+			}
+			in.target = o.insts[t].target
+			changed = true
+		}
+	}
+	return changed
+}
+
+// sweep removes instructions that no path reaches from the program start, a Sub/Function start or a live code target
+// (event handlers, TRY handlers, coroutine entries).
+func (o *pass) sweep() bool {
+	reached := make([]bool, len(o.insts))
+	var work []int
+	visit := func(i int) {
+		i = o.next(i)
+		if i < len(o.insts) && !reached[i] {
+			reached[i] = true
+			work = append(work, i)
+		}
+	}
+	visit(0)
+	for _, off := range o.chunk.Functions {
+		for i := range o.insts {
+			if o.insts[i].Offset == off {
+				visit(i)
+			}
+		}
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		in := o.insts[i]
+		if in.target >= 0 {
+			visit(in.target)
+		}
+		switch in.Op {
+		case vm.OpJump, vm.OpReturn, vm.OpReturnVal, vm.OpHalt, vm.OpQuit, vm.OpThrow:
+		default:
+			visit(i + 1)
+		}
+	}
+	changed := false
+	for i := range o.insts {
+		if !o.insts[i].removed && !reached[i] {
+			o.insts[i].removed = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// encode lays out the live instructions, keeping each one's wide form so no jump grows past its encoding.
+// It reports false if the layout does not settle (the caller then keeps the unoptimized chunk).
+func (o *pass) encode() (*vm.Chunk, bool) {
+	offsets := make([]int, len(o.insts)+1)
+	emit := func(out *vm.Chunk, measure bool) bool {
+		for i, in := range o.insts {
+			offsets[i] = len(out.Code)
+			if in.removed {
+				continue
+			}
+			operands := append([]int(nil), in.Operands...)
+			if k := jumpOperand(in.Instruction); k >= 0 && !measure {
+				target := offsets[o.next(in.target)]
+				if in.Kinds[k] == vm.OperandJump {
+					target -= offsets[i] + in.Size
+				}
+				operands[k] = target
+			}
+			out.SetLine(in.line)
+			start := len(out.Code)
+			var err error
+			if in.Wide {
+				err = out.EmitWide(in.Op, operands...)
+			} else {
+				err = out.Emit(in.Op, operands...)
+			}
+			if err != nil {
+				return false
+			}
+			o.insts[i].Size = len(out.Code) - start
+		}
+		offsets[len(o.insts)] = len(out.Code)
+		return true
+	}
+	// The first layout measures sizes with zero targets (an operand's width depends only on the wide form);
+	// the second fills in the targets and must reproduce the same offsets.
+	if !emit(vm.NewChunk(), true) {
+		return nil, false
+	}
+	measured := append([]int(nil), offsets...)
+	out := vm.NewChunk()
+	if !emit(out, false) {
+		return nil, false
+	}
+	for i := range offsets {
+		if offsets[i] != measured[i] {
+			return nil, false
+		}
+	}
+
+	c := o.chunk
+	out.Constants = c.Constants
+	out.Variables = c.Variables
+	out.VarDims = c.VarDims
+	out.Params = c.Params
+	out.Enums = c.Enums
+	out.Types = c.Types
+	out.DataValues = c.DataValues
+	out.SourceMap = c.SourceMap
+	for name, off := range c.Functions {
+		for i := range o.insts {
+			if o.insts[i].Offset == off {
+				out.Functions[name] = offsets[o.next(i)]
+				break
+			}
+		}
+	}
+	return out, true
+}
@@ -0,0 +1,75 @@
+package optimizer
+
+import (
+	"bytes"
+	"testing"
+
+	"cyberbasic/compiler/vm"
+)
+
+func mustEmit(t *testing.T, c *vm.Chunk, op vm.OpCode, operands ...int) {
+	t.Helper()
+	if err := c.Emit(op, operands...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestThreadsJumpsAndRelocatesFunctions builds JumpIfFalse -> Jump -> end and a function after removed code.
+func TestThreadsJumpsAndRelocatesFunctions(t *testing.T) {
+	c := vm.NewChunk()
+	x := c.AddVariable("x")
+	mustEmit(t, c, vm.OpLoadVar, x)
+	mustEmit(t, c, vm.OpJumpIfFalse, 3) // -> the Jump below
+	mustEmit(t, c, vm.OpJump, 0)        // to the next instruction: removed
+	mustEmit(t, c, vm.OpJump, 7)        // -> Halt
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(1.0))
+	mustEmit(t, c, vm.OpStoreVar, x) // unreachable
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(2.0))
+	mustEmit(t, c, vm.OpReturnVal)
+	c.Functions["two"] = 15
+	c.Write(byte(vm.OpHalt))
+	before := append([]byte(nil), c.Code...)
+
+	out := Optimize(c, O1)
+	if !bytes.Equal(c.Code, before) {
+		t.Fatal("Optimize modified its input")
+	}
+	var listing bytes.Buffer
+	_ = vm.Disassemble(&listing, out)
+	first, err := out.DecodeInstruction(2)
+	if err != nil || first.Op != vm.OpJumpIfFalse || first.JumpTarget(0) != out.Functions["two"]+3 {
+		t.Errorf("JumpIfFalse not threaded to Halt:\n%s", listing.String())
+	}
+	if off := out.Functions["two"]; off != 8 {
+		t.Errorf("function two at %d, want 8:\n%s", off, listing.String())
+	}
+	if len(out.Code) != 12 {
+		t.Errorf("optimized code is %d bytes, want 12:\n%s", len(out.Code), listing.String())
+	}
+}
+
+func TestLeavesUnsafeChunksAlone(t *testing.T) {
+	c := vm.NewChunk()
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(1.0))
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(2.0))
+	c.Write(byte(vm.OpAdd))
+	if Optimize(c, O0) != c {
+		t.Error("-O0 should return the chunk unchanged")
+	}
+	mustEmit(t, c, vm.OpCall, 0) // legacy OpCall inspects the preceding byte
+	if Optimize(c, O1) != c {
+		t.Error("chunk with OpCall should not be rewritten")
+	}
+}
+
+func TestKeepsRuntimeErrors(t *testing.T) {
+	c := vm.NewChunk()
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(int64(1)))
+	mustEmit(t, c, vm.OpLoadConst, c.WriteConstant(int64(0)))
+	c.Write(byte(vm.OpIntDiv))
+	c.Write(byte(vm.OpHalt))
+	out := Optimize(c, O1)
+	if !bytes.Equal(out.Code, c.Code) {
+		t.Error("1 \\ 0 was folded; the runtime error must stay")
+	}
+}
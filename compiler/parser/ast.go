@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -58,6 +59,15 @@ const (
 	NodeReadStatement
 	NodeRestoreStatement
 	NodeGosubStatement
+	NodeTryStatement
+	NodeThrowStatement
+	NodeEmitStatement
+	NodeAwaitExpression
+	NodeFunctionExpression
+	NodeForEachStatement
+	NodeListLiteral
+	NodeMapLiteral
+	NodeTestStatement
 )
 
 // Node represents a node in the Abstract Syntax Tree
@@ -142,6 +152,28 @@ func (f *ForStatement) String() string {
 	return result
 }
 
+// ForEachStatement represents FOR EACH item IN coll ... NEXT, or FOR EACH key, value IN coll ... NEXT. With
+// one variable a MAP yields its keys and a LIST its items; with two, Key receives the key or index.
+type ForEachStatement struct {
+	Key        string // empty for the one-variable form
+	Variable   string
+	Collection Node
+	Body       *Block
+	Line       int
+	Col        int
+}
+
+func (f *ForEachStatement) Type() NodeType { return NodeForEachStatement }
+func (f *ForEachStatement) GetLine() int   { return f.Line }
+func (f *ForEachStatement) GetCol() int    { return f.Col }
+func (f *ForEachStatement) String() string {
+	vars := f.Variable
+	if f.Key != "" {
+		vars = f.Key + ", " + f.Variable
+	}
+	return "FOR EACH " + vars + " IN " + f.Collection.String() + "\n" + f.Body.String() + "NEXT"
+}
+
 // WhileStatement represents a WHILE...WEND loop
 type WhileStatement struct {
 	Condition Node
@@ -167,12 +199,17 @@ func (m *MainLoopStatement) String() string {
 type FunctionDecl struct {
 	Name       string
 	ModuleName string // set when inside Module X ... End Module
+	TypeName   string // set for a method inside TYPE X ... END TYPE; Parameters[0] is the implicit ME
 	Parameters []string
 	ReturnType string
 	Body       *Block
+	Line       int
+	Col        int
 }
 
 func (f *FunctionDecl) Type() NodeType { return NodeFunctionDecl }
+func (f *FunctionDecl) GetLine() int   { return f.Line }
+func (f *FunctionDecl) GetCol() int    { return f.Col }
 func (f *FunctionDecl) String() string {
 	result := "FUNCTION " + f.Name + "("
 	for i, param := range f.Parameters {
@@ -193,11 +230,16 @@ func (f *FunctionDecl) String() string {
 type SubDecl struct {
 	Name       string
 	ModuleName string // set when inside Module X ... End Module
+	TypeName   string // set for a method inside TYPE X ... END TYPE; Parameters[0] is the implicit ME
 	Parameters []string
 	Body       *Block
+	Line       int
+	Col        int
 }
 
 func (s *SubDecl) Type() NodeType { return NodeSubDecl }
+func (s *SubDecl) GetLine() int   { return s.Line }
+func (s *SubDecl) GetCol() int    { return s.Col }
 func (s *SubDecl) String() string {
 	result := "SUB " + s.Name + "("
 	for i, param := range s.Parameters {
@@ -225,10 +267,12 @@ func (m *ModuleStatement) String() string {
 	return result + "END MODULE"
 }
 
-// OnEventStatement represents On KeyDown("ESCAPE") ... End On
+// OnEventStatement represents On KeyDown("ESCAPE") ... End On, or On Name("key", param, ...) ... End On for
+// any other event (mouse, gamepad, window, timers, physics, network, EMIT).
 type OnEventStatement struct {
-	EventType string // "keydown", "keypressed"
-	Key       string // key name e.g. "ESCAPE" or empty for any
+	EventType string   // lowercase event name: "keydown", "mousepressed", "timer", a user event, ...
+	Key       string   // key/button/timer name the handler is limited to, or empty for any
+	Params    []string // names the event's arguments are bound to inside the handler
 	Body      *Block
 }
 
@@ -237,14 +281,63 @@ func (o *OnEventStatement) String() string {
 	return "On " + o.EventType + "(\"" + o.Key + "\") ... End On"
 }
 
-// StartCoroutineStatement represents StartCoroutine SubName()
+// EmitStatement represents EMIT name [, arg, ...]: post a user event to the ON name handlers.
+type EmitStatement struct {
+	Name Node // StringLiteral for EMIT name, or any string expression
+	Args []Node
+	Line int
+	Col  int
+}
+
+func (e *EmitStatement) Type() NodeType { return NodeEmitStatement }
+func (e *EmitStatement) GetLine() int   { return e.Line }
+func (e *EmitStatement) GetCol() int    { return e.Col }
+func (e *EmitStatement) String() string { return "EMIT " + e.Name.String() }
+
+// StartCoroutineStatement represents StartCoroutine SubName([args]). Used as an expression
+// (t = StartCoroutine Worker(1)) it evaluates to the coroutine's task, which AWAIT waits for.
 type StartCoroutineStatement struct {
 	SubName string
+	Args    []Node
 }
 
 func (s *StartCoroutineStatement) Type() NodeType { return NodeStartCoroutineStatement }
 func (s *StartCoroutineStatement) String() string { return "StartCoroutine " + s.SubName + "()" }
 
+// AwaitExpression represents AWAIT expr: suspend the coroutine until the task, channel operation or async
+// foreign result expr evaluates to has completed, and evaluate to its result.
+type AwaitExpression struct {
+	Value Node
+	Line  int
+	Col   int
+}
+
+func (a *AwaitExpression) Type() NodeType { return NodeAwaitExpression }
+func (a *AwaitExpression) GetLine() int   { return a.Line }
+func (a *AwaitExpression) GetCol() int    { return a.Col }
+func (a *AwaitExpression) String() string { return "AWAIT " + a.Value.String() }
+
+// FunctionExpression is an anonymous FUNCTION(params) ... END FUNCTION used as a value. It captures the
+// parameters of the Sub, Function or handler it appears in.
+type FunctionExpression struct {
+	Parameters []string
+	ReturnType string
+	Body       *Block
+	Line       int
+	Col        int
+}
+
+func (f *FunctionExpression) Type() NodeType { return NodeFunctionExpression }
+func (f *FunctionExpression) GetLine() int   { return f.Line }
+func (f *FunctionExpression) GetCol() int    { return f.Col }
+func (f *FunctionExpression) String() string {
+	result := "FUNCTION(" + strings.Join(f.Parameters, ", ") + ")"
+	if f.ReturnType != "" {
+		result += " AS " + f.ReturnType
+	}
+	return result + "\n" + f.Body.String() + "END FUNCTION"
+}
+
 // YieldStatement represents Yield
 type YieldStatement struct{}
 
@@ -299,6 +392,43 @@ type GosubStatement struct {
 func (g *GosubStatement) Type() NodeType { return NodeGosubStatement }
 func (g *GosubStatement) String() string { return "GOSUB " + g.SubName }
 
+// TryStatement represents TRY ... CATCH [var] ... FINALLY ... END TRY.
+// At least one of CatchBlock and FinallyBlock is set.
+type TryStatement struct {
+	Body         *Block
+	CatchVar     string // receives the error object; empty = CATCH without a variable
+	CatchBlock   *Block // nil = no CATCH clause (error is rethrown after FINALLY)
+	FinallyBlock *Block // nil = no FINALLY clause
+	Line         int
+	Col          int
+}
+
+func (t *TryStatement) Type() NodeType { return NodeTryStatement }
+func (t *TryStatement) GetLine() int   { return t.Line }
+func (t *TryStatement) GetCol() int    { return t.Col }
+func (t *TryStatement) String() string {
+	out := "TRY\n" + t.Body.String()
+	if t.CatchBlock != nil {
+		out += "CATCH " + t.CatchVar + "\n" + t.CatchBlock.String()
+	}
+	if t.FinallyBlock != nil {
+		out += "FINALLY\n" + t.FinallyBlock.String()
+	}
+	return out + "END TRY"
+}
+
+// ThrowStatement represents THROW expr (raise an error with expr as its message or rethrow a caught error).
+type ThrowStatement struct {
+	Value Node
+	Line  int
+	Col   int
+}
+
+func (t *ThrowStatement) Type() NodeType { return NodeThrowStatement }
+func (t *ThrowStatement) GetLine() int   { return t.Line }
+func (t *ThrowStatement) GetCol() int    { return t.Col }
+func (t *ThrowStatement) String() string { return "THROW " + t.Value.String() }
+
 // Assignment represents variable assignment (scalar or array element)
 type Assignment struct {
 	Variable string
@@ -370,14 +500,52 @@ func (a *AssertStatement) String() string {
 	return "ASSERT " + a.Condition.String()
 }
 
+// TestStatement represents TEST "name" ... END TEST, a test case that `cyberbasic test` reports. It runs in
+// place, and an error in its body (a failed ASSERT) ends the test, not the program.
+type TestStatement struct {
+	Name    string
+	Body    *Block
+	Line    int
+	Col     int
+	lowered *Block
+}
+
+func (t *TestStatement) Type() NodeType { return NodeTestStatement }
+func (t *TestStatement) GetLine() int   { return t.Line }
+func (t *TestStatement) GetCol() int    { return t.Col }
+func (t *TestStatement) String() string {
+	return "TEST " + strconv.Quote(t.Name) + "\n" + t.Body.String() + "END TEST"
+}
+
+// Lowered returns the statements the test compiles to (the same Block on every call):
+//
+//	TestBegin(name, line)
+//	TRY body CATCH __testerr TestFail(__testerr) END TRY
+//	TestEnd()
+func (t *TestStatement) Lowered() *Block {
+	if t.lowered == nil {
+		fail := &Call{Name: "TestFail", Arguments: []Node{&Identifier{Name: "__testerr", Line: t.Line, Col: t.Col}}, Line: t.Line, Col: t.Col}
+		t.lowered = &Block{Statements: []Node{
+			&Call{Name: "TestBegin", Arguments: []Node{&StringLiteral{Value: t.Name}, &Number{Value: strconv.Itoa(t.Line)}}, Line: t.Line, Col: t.Col},
+			&TryStatement{Body: t.Body, CatchVar: "__testerr", CatchBlock: &Block{Statements: []Node{fail}}, Line: t.Line, Col: t.Col},
+			&Call{Name: "TestEnd", Line: t.Line, Col: t.Col},
+		}}
+	}
+	return t.lowered
+}
+
 // MemberAccess represents dot notation: expr.member (e.g. pos.x, GetMousePosition().y)
 type MemberAccess struct {
 	Object Node
 	Member string
+	Line   int // position of the member name
+	Col    int
 }
 
 func (m *MemberAccess) Type() NodeType { return NodeMemberAccess }
 func (m *MemberAccess) String() string  { return m.Object.String() + "." + m.Member }
+func (m *MemberAccess) GetLine() int    { return m.Line }
+func (m *MemberAccess) GetCol() int     { return m.Col }
 
 // JSONIndexAccess represents obj["key"] sugar, compiled to GetJSONKey(obj, "key")
 type JSONIndexAccess struct {
@@ -468,6 +636,46 @@ func (d *DictLiteral) String() string {
 	return s + "}"
 }
 
+// ListLiteral represents [a, b, c], a new LIST.
+type ListLiteral struct {
+	Elements []Node
+	Line     int
+	Col      int
+}
+
+func (l *ListLiteral) Type() NodeType { return NodeListLiteral }
+func (l *ListLiteral) GetLine() int   { return l.Line }
+func (l *ListLiteral) GetCol() int    { return l.Col }
+func (l *ListLiteral) String() string {
+	parts := make([]string, len(l.Elements))
+	for i, el := range l.Elements {
+		parts[i] = el.String()
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// MapLiteral represents [key: value, ...], a new MAP; [:] is the empty map. Keys are expressions.
+type MapLiteral struct {
+	Keys   []Node
+	Values []Node
+	Line   int
+	Col    int
+}
+
+func (m *MapLiteral) Type() NodeType { return NodeMapLiteral }
+func (m *MapLiteral) GetLine() int   { return m.Line }
+func (m *MapLiteral) GetCol() int    { return m.Col }
+func (m *MapLiteral) String() string {
+	if len(m.Keys) == 0 {
+		return "[:]"
+	}
+	parts := make([]string, len(m.Keys))
+	for i := range m.Keys {
+		parts[i] = m.Keys[i].String() + ": " + m.Values[i].String()
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
 // BinaryOp represents a binary operation
 type BinaryOp struct {
 	Operator string
@@ -639,10 +847,14 @@ func (e *EnumStatement) String() string {
 	return s
 }
 
-// TypeDecl represents TYPE Name ... ENDTYPE (UDT definition).
+// TypeDecl represents TYPE Name ... ENDTYPE (UDT definition). Methods are the FUNCTION and SUB declarations
+// inside it (*FunctionDecl or *SubDecl with TypeName set); a method named New is the constructor.
 type TypeDecl struct {
-	Name   string
-	Fields []TypeField
+	Name    string
+	Fields  []TypeField
+	Methods []Node
+	Line    int
+	Col     int
 }
 
 // TypeField is one field in a TYPE: Name, optional AS FieldType, optional = ConstValue (for constant groups).
@@ -686,6 +898,9 @@ func (t *TypeDecl) String() string {
 		}
 		s += "\n"
 	}
+	for _, m := range t.Methods {
+		s += m.String() + "\n"
+	}
 	return s + "ENDTYPE"
 }
 
@@ -698,6 +913,8 @@ type VariableDecl struct {
 	Name       string
 	Type       string
 	Dimensions []Node // nil = scalar; e.g. [10], [10,20] for DIM a(10) or DIM a(10,20)
+	Line       int
+	Col        int
 }
 
 func (d *DimStatement) Type() NodeType { return NodeDimStatement }
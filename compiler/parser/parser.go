@@ -70,6 +70,16 @@ func (p *Parser) statement() (Node, error) {
 		return nil, nil
 	}
 
+	if p.isEmitStatement() {
+		return p.emitStatement()
+	}
+	if p.isTestStatement() {
+		return p.testStatement()
+	}
+	if p.isAwait() {
+		return p.awaitExpression() // AWAIT task as a statement: wait and drop the result
+	}
+
 	switch p.peek().Type {
 	case lexer.TokenIf:
 		return p.ifStatement()
@@ -148,6 +158,12 @@ func (p *Parser) statement() (Node, error) {
 		return p.restoreStatement()
 	case lexer.TokenGosub:
 		return p.gosubStatement()
+	case lexer.TokenTry:
+		return p.tryStatement()
+	case lexer.TokenThrow:
+		return p.throwStatement()
+	case lexer.TokenCatch, lexer.TokenFinally, lexer.TokenEndTry:
+		return nil, &Error{Message: "CATCH, FINALLY or END TRY without TRY", Line: p.line(), Col: p.col()}
 	case lexer.TokenLoadImage, lexer.TokenCreateSprite, lexer.TokenSetSpritePosition,
 		lexer.TokenDrawSprite, lexer.TokenLoadModel, lexer.TokenCreateCamera,
 		lexer.TokenSetCameraPosition, lexer.TokenDrawModel, lexer.TokenPlayMusic,
@@ -175,6 +175,9 @@ func (p *Parser) unary() (Node, error) {
 		}
 		return &UnaryOp{Operator: "NOT", Operand: right, Line: p.line(), Col: p.col()}, nil
 	}
+	if p.isAwait() {
+		return p.awaitExpression()
+	}
 
 	return p.primary()
 }
@@ -224,6 +227,63 @@ func (p *Parser) dictLiteral() (Node, error) {
 	return &DictLiteral{Pairs: pairs}, nil
 }
 
+// collectionLiteral parses a LIST [a, b, c] or a MAP [key: value, ...]; [] is an empty LIST and [:] an empty MAP.
+// The first element decides which: a ':' after it makes the literal a MAP and every element needs a key.
+func (p *Parser) collectionLiteral() (Node, error) {
+	line, col := p.line(), p.col()
+	p.advance() // [
+	skipNewLines := func() {
+		for p.match(lexer.TokenNewLine) {
+			continue
+		}
+	}
+	skipNewLines()
+	if p.match(lexer.TokenColon) {
+		skipNewLines()
+		if !p.match(lexer.TokenRightBracket) {
+			return nil, &Error{Message: "expected ']' after '[:' (empty map)", Line: p.line(), Col: p.col()}
+		}
+		return &MapLiteral{Line: line, Col: col}, nil
+	}
+	list := &ListLiteral{Line: line, Col: col}
+	var m *MapLiteral
+	for !p.check(lexer.TokenRightBracket) && !p.isAtEnd() {
+		el, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Elements) == 0 && m == nil && p.check(lexer.TokenColon) {
+			m = &MapLiteral{Line: line, Col: col}
+		}
+		if m != nil {
+			if !p.match(lexer.TokenColon) {
+				return nil, &Error{Message: "expected ':' after map key", Line: p.line(), Col: p.col()}
+			}
+			skipNewLines()
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			m.Keys = append(m.Keys, el)
+			m.Values = append(m.Values, value)
+		} else {
+			list.Elements = append(list.Elements, el)
+		}
+		skipNewLines()
+		if !p.match(lexer.TokenComma) {
+			break
+		}
+		skipNewLines()
+	}
+	if !p.match(lexer.TokenRightBracket) {
+		return nil, &Error{Message: "expected ']' to close list or map literal", Line: p.line(), Col: p.col()}
+	}
+	if m != nil {
+		return m, nil
+	}
+	return list, nil
+}
+
 // primary parses primary expressions
 func (p *Parser) primary() (Node, error) {
 	switch p.peek().Type {
@@ -245,6 +305,16 @@ func (p *Parser) primary() (Node, error) {
 		return &NilLiteral{}, nil
 	case lexer.TokenLeftBrace:
 		return p.dictLiteral()
+	case lexer.TokenLeftBracket:
+		lit, err := p.collectionLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseMemberAccessChain(lit)
+	case lexer.TokenStartCoroutine, lexer.TokenStartTask:
+		return p.startCoroutineStatement() // evaluates to the coroutine's task
+	case lexer.TokenFunction:
+		return p.functionExpression()
 	case lexer.TokenShouldClose:
 		p.advance()
 		if p.match(lexer.TokenLeftParen) {
@@ -315,7 +385,7 @@ func (p *Parser) primary() (Node, error) {
 				return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
 			}
 			callName := memberAccessToQualifiedName(ma)
-			return p.parseMemberAccessChain(&Call{Name: callName, Arguments: arguments})
+			return p.parseMemberAccessChain(&Call{Name: callName, Arguments: arguments, Line: prev.Line, Col: prev.Col})
 		}
 		// Bare identifier followed by ( is a call (e.g. Sin(x), Sqrt(y))
 		if id, ok := left.(*Identifier); ok && p.match(lexer.TokenLeftParen) {
@@ -333,7 +403,7 @@ func (p *Parser) primary() (Node, error) {
 			if !p.match(lexer.TokenRightParen) {
 				return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
 			}
-			return p.parseMemberAccessChain(&Call{Name: id.Name, Arguments: arguments})
+			return p.parseMemberAccessChain(&Call{Name: id.Name, Arguments: arguments, Line: id.Line, Col: id.Col})
 		}
 		return left, nil
 	}
@@ -359,8 +429,8 @@ func (p *Parser) parseMemberAccessChain(left Node) (Node, error) {
 			if !p.match(lexer.TokenIdentifier) {
 				return nil, &Error{Message: "expected identifier after '.'", Line: p.line(), Col: p.col()}
 			}
-			member := p.previous().Value
-			left = &MemberAccess{Object: left, Member: member}
+			tok := p.previous()
+			left = &MemberAccess{Object: left, Member: tok.Value, Line: tok.Line, Col: tok.Col}
 		} else if p.match(lexer.TokenLeftBracket) {
 			if p.match(lexer.TokenString) {
 				key := p.previous().Value
@@ -504,3 +574,36 @@ func memberAccessToQualifiedName(ma *MemberAccess) string {
 	}
 	return strings.ToLower(prefix) + "." + strings.ToLower(ma.Member)
 }
+
+// functionExpression parses an anonymous function: FUNCTION(params) [AS type], then either a body ending in
+// END FUNCTION or, on the same line, a single expression that is its return value.
+func (p *Parser) functionExpression() (Node, error) {
+	tok := p.advance() // FUNCTION
+	if !p.match(lexer.TokenLeftParen) {
+		return nil, &Error{Message: "expected '(' after FUNCTION in an expression", Line: p.line(), Col: p.col()}
+	}
+	parameters, err := p.parameterList()
+	if err != nil {
+		return nil, err
+	}
+	returnType, err := p.returnType()
+	if err != nil {
+		return nil, err
+	}
+	fn := &FunctionExpression{Parameters: parameters, ReturnType: returnType, Line: tok.Line, Col: tok.Col}
+	if !p.check(lexer.TokenNewLine) && !p.check(lexer.TokenColon) && !p.isAtEnd() {
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		fn.Body = &Block{Statements: []Node{&ReturnStatement{Value: value}}}
+		return fn, nil
+	}
+	if fn.Body, err = p.block(false); err != nil {
+		return nil, err
+	}
+	if err := p.endFunction(); err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
@@ -63,7 +63,11 @@ func (p *Parser) ifStatement() (Node, error) {
 
 // forStatement parses FOR...TO...STEP...NEXT
 func (p *Parser) forStatement() (Node, error) {
-	p.advance() // Skip FOR
+	forTok := p.advance() // Skip FOR
+
+	if p.check(lexer.TokenIdentifier) && strings.EqualFold(p.peek().Value, "EACH") && p.current+1 < len(p.tokens) && p.tokens[p.current+1].Type == lexer.TokenIdentifier {
+		return p.forEachStatement(forTok.Line, forTok.Col)
+	}
 
 	if !p.match(lexer.TokenIdentifier) {
 		return nil, &Error{Message: "expected variable name after FOR", Line: p.line(), Col: p.col()}
@@ -114,6 +118,38 @@ func (p *Parser) forStatement() (Node, error) {
 	}, nil
 }
 
+// forEachStatement parses FOR EACH item IN coll ... NEXT and FOR EACH key, value IN coll ... NEXT (after FOR).
+// EACH and IN are plain identifiers here, so they stay usable as variable names elsewhere.
+func (p *Parser) forEachStatement(line, col int) (Node, error) {
+	p.advance() // Skip EACH
+	p.advance() // first variable, checked by forStatement
+	stmt := &ForEachStatement{Variable: p.previous().Value, Line: line, Col: col}
+	if p.match(lexer.TokenComma) {
+		if !p.match(lexer.TokenIdentifier) {
+			return nil, &Error{Message: "expected value variable after ',' in FOR EACH", Line: p.line(), Col: p.col()}
+		}
+		stmt.Key, stmt.Variable = stmt.Variable, p.previous().Value
+	}
+	if !p.check(lexer.TokenIdentifier) || !strings.EqualFold(p.peek().Value, "IN") {
+		return nil, &Error{Message: "expected IN after FOR EACH variable", Line: p.line(), Col: p.col()}
+	}
+	p.advance()
+	coll, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Collection = coll
+	body, err := p.block(false)
+	if err != nil {
+		return nil, err
+	}
+	if !p.match(lexer.TokenNext) {
+		return nil, &Error{Message: "expected NEXT", Line: p.line(), Col: p.col()}
+	}
+	stmt.Body = body
+	return stmt, nil
+}
+
 // whileStatement parses WHILE...WEND
 func (p *Parser) whileStatement() (Node, error) {
 	p.advance() // Skip WHILE
@@ -169,47 +205,28 @@ func (p *Parser) functionDecl() (Node, error) {
 	if !p.match(lexer.TokenIdentifier) {
 		return nil, &Error{Message: "expected function name", Line: p.line(), Col: p.col()}
 	}
-	name := p.previous().Value
+	nameTok := p.previous()
+	name := nameTok.Value
 
 	var parameters []string
 	if p.match(lexer.TokenLeftParen) {
-		for !p.check(lexer.TokenRightParen) {
-			if !p.match(lexer.TokenIdentifier) {
-				return nil, &Error{Message: "expected parameter name", Line: p.line(), Col: p.col()}
-			}
-			parameters = append(parameters, p.previous().Value)
-
-			if !p.match(lexer.TokenComma) {
-				break
-			}
-		}
-		if !p.match(lexer.TokenRightParen) {
-			return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
+		var err error
+		if parameters, err = p.parameterList(); err != nil {
+			return nil, err
 		}
 	}
 
-	var returnType string
-	if p.match(lexer.TokenAs) {
-		if !p.match(lexer.TokenIdentifier) {
-			return nil, &Error{Message: "expected return type", Line: p.line(), Col: p.col()}
-		}
-		returnType = p.previous().Value
+	returnType, err := p.returnType()
+	if err != nil {
+		return nil, err
 	}
 
 	body, err := p.block(false)
 	if err != nil {
 		return nil, err
 	}
-
-	// ENDFUNCTION (single-word) or END FUNCTION (two words)
-	if p.match(lexer.TokenEndFunction) {
-		// consumed
-	} else if p.match(lexer.TokenEnd) {
-		if !p.match(lexer.TokenFunction) {
-			return nil, &Error{Message: "expected FUNCTION after END", Line: p.line(), Col: p.col()}
-		}
-	} else {
-		return nil, &Error{Message: "expected ENDFUNCTION or END FUNCTION", Line: p.line(), Col: p.col()}
+	if err := p.endFunction(); err != nil {
+		return nil, err
 	}
 
 	return &FunctionDecl{
@@ -217,9 +234,55 @@ func (p *Parser) functionDecl() (Node, error) {
 		Parameters: parameters,
 		ReturnType: returnType,
 		Body:       body,
+		Line:       nameTok.Line,
+		Col:        nameTok.Col,
 	}, nil
 }
 
+// parameterList parses the names of a parameter list after its '(' up to and including the ')'.
+func (p *Parser) parameterList() ([]string, error) {
+	var parameters []string
+	for !p.check(lexer.TokenRightParen) {
+		if !p.match(lexer.TokenIdentifier) {
+			return nil, &Error{Message: "expected parameter name", Line: p.line(), Col: p.col()}
+		}
+		parameters = append(parameters, p.previous().Value)
+
+		if !p.match(lexer.TokenComma) {
+			break
+		}
+	}
+	if !p.match(lexer.TokenRightParen) {
+		return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
+	}
+	return parameters, nil
+}
+
+// returnType parses an optional AS type after a function's parameters.
+func (p *Parser) returnType() (string, error) {
+	if !p.match(lexer.TokenAs) {
+		return "", nil
+	}
+	if !p.match(lexer.TokenIdentifier, lexer.TokenInteger, lexer.TokenStringType, lexer.TokenFloat, lexer.TokenBoolean) {
+		return "", &Error{Message: "expected return type", Line: p.line(), Col: p.col()}
+	}
+	return p.previous().Value, nil
+}
+
+// endFunction consumes ENDFUNCTION (single-word) or END FUNCTION (two words).
+func (p *Parser) endFunction() error {
+	if p.match(lexer.TokenEndFunction) {
+		return nil
+	}
+	if p.match(lexer.TokenEnd) {
+		if !p.match(lexer.TokenFunction) {
+			return &Error{Message: "expected FUNCTION after END", Line: p.line(), Col: p.col()}
+		}
+		return nil
+	}
+	return &Error{Message: "expected ENDFUNCTION or END FUNCTION", Line: p.line(), Col: p.col()}
+}
+
 // subDecl parses SUB...END SUB
 func (p *Parser) subDecl() (Node, error) {
 	p.advance() // Skip SUB
@@ -227,22 +290,14 @@ func (p *Parser) subDecl() (Node, error) {
 	if !p.match(lexer.TokenIdentifier) {
 		return nil, &Error{Message: "expected sub name", Line: p.line(), Col: p.col()}
 	}
-	name := p.previous().Value
+	nameTok := p.previous()
+	name := nameTok.Value
 
 	var parameters []string
 	if p.match(lexer.TokenLeftParen) {
-		for !p.check(lexer.TokenRightParen) {
-			if !p.match(lexer.TokenIdentifier) {
-				return nil, &Error{Message: "expected parameter name", Line: p.line(), Col: p.col()}
-			}
-			parameters = append(parameters, p.previous().Value)
-
-			if !p.match(lexer.TokenComma) {
-				break
-			}
-		}
-		if !p.match(lexer.TokenRightParen) {
-			return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
+		var err error
+		if parameters, err = p.parameterList(); err != nil {
+			return nil, err
 		}
 	}
 
@@ -266,6 +321,8 @@ func (p *Parser) subDecl() (Node, error) {
 		Name:       name,
 		Parameters: parameters,
 		Body:       body,
+		Line:       nameTok.Line,
+		Col:        nameTok.Col,
 	}, nil
 }
 
@@ -320,7 +377,8 @@ func (p *Parser) moduleDecl() (Node, error) {
 	return &ModuleStatement{Name: moduleName, Body: body}, nil
 }
 
-// onEventStatement parses On KeyDown("ESCAPE") ... End On, or ON UPDATE ... END ON / ON DRAW ... END ON (implicit loop subs).
+// onEventStatement parses On KeyDown("ESCAPE") ... End On, ON name[("key", param, ...)] ... END ON for any other
+// event, or ON UPDATE ... END ON / ON DRAW ... END ON (implicit loop subs).
 func (p *Parser) onEventStatement() (Node, error) {
 	p.advance() // Skip ON
 	// ON UPDATE / ON DRAW -> Sub OnUpdate(dt) / Sub OnDraw() for implicit window mode
@@ -356,29 +414,45 @@ func (p *Parser) onEventStatement() (Node, error) {
 			}
 			return &SubDecl{Name: "OnDraw", Parameters: []string{}, Body: body}, nil
 		}
-		// Not ON UPDATE/DRAW: rewind so KeyDown path can run — put identifier back is hard; use unread not available.
-		// Fall through only works if we didn't consume — we consumed UPDATE/DRAW only in branches above.
-		// For wrong identifier after ON, error:
-		return nil, &Error{Message: "expected UPDATE, DRAW, KeyDown, or KeyPressed after ON", Line: p.line(), Col: p.col()}
+		return p.onEventBody(strings.ToLower(kw))
 	}
-	eventType := ""
 	switch {
 	case p.match(lexer.TokenKeyDown):
-		eventType = "keydown"
+		return p.onEventBody("keydown")
 	case p.match(lexer.TokenKeyPressed):
-		eventType = "keypressed"
-	default:
-		return nil, &Error{Message: "expected KeyDown or KeyPressed after On", Line: p.line(), Col: p.col()}
+		return p.onEventBody("keypressed")
+	}
+	// Event names that happen to be keywords (ON Timer, ON Collision3D, ...) are still names here.
+	if tok := p.peek(); isWordToken(tok.Value) && tok.Type != lexer.TokenNewLine {
+		p.advance()
+		return p.onEventBody(strings.ToLower(tok.Value))
 	}
+	return nil, &Error{Message: "expected an event name after ON (KeyDown, MousePressed, Timer, ...)", Line: p.line(), Col: p.col()}
+}
+
+// onEventBody parses the rest of ON event[("key", param, ...)] ... END ON after the event name. A leading string
+// or number limits the handler to that key, button or timer; the identifiers name the event's arguments.
+func (p *Parser) onEventBody(eventType string) (Node, error) {
 	key := ""
+	var params []string
 	if p.match(lexer.TokenLeftParen) {
-		if p.match(lexer.TokenString) {
-			key = p.previous().Value
-		} else if p.match(lexer.TokenNumber) {
+		if p.match(lexer.TokenString) || p.match(lexer.TokenNumber) {
 			key = p.previous().Value
+			if !p.check(lexer.TokenRightParen) && !p.match(lexer.TokenComma) {
+				return nil, &Error{Message: "expected ',' or ')' after event key", Line: p.line(), Col: p.col()}
+			}
+		}
+		for !p.check(lexer.TokenRightParen) {
+			if !p.match(lexer.TokenIdentifier) {
+				return nil, &Error{Message: "expected parameter name", Line: p.line(), Col: p.col()}
+			}
+			params = append(params, p.previous().Value)
+			if !p.match(lexer.TokenComma) {
+				break
+			}
 		}
 		if !p.match(lexer.TokenRightParen) {
-			return nil, &Error{Message: "expected ')' after key", Line: p.line(), Col: p.col()}
+			return nil, &Error{Message: "expected ')' after event parameters", Line: p.line(), Col: p.col()}
 		}
 	}
 	body, err := p.block(false)
@@ -394,22 +468,70 @@ func (p *Parser) onEventStatement() (Node, error) {
 	} else {
 		return nil, &Error{Message: "expected End On", Line: p.line(), Col: p.col()}
 	}
-	return &OnEventStatement{EventType: eventType, Key: key, Body: body}, nil
+	return &OnEventStatement{EventType: eventType, Key: key, Params: params, Body: body}, nil
 }
 
-// startCoroutineStatement parses StartCoroutine SubName()
+// isWordToken reports whether s looks like a name (letters, digits, underscore; not starting with a digit).
+func isWordToken(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// startCoroutineStatement parses StartCoroutine SubName([arg, ...])
 func (p *Parser) startCoroutineStatement() (Node, error) {
 	p.advance() // Skip StartCoroutine
 	if !p.match(lexer.TokenIdentifier) {
 		return nil, &Error{Message: "expected sub name after StartCoroutine", Line: p.line(), Col: p.col()}
 	}
 	subName := p.previous().Value
+	var args []Node
 	if p.match(lexer.TokenLeftParen) {
+		for !p.check(lexer.TokenRightParen) {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.match(lexer.TokenComma) {
+				break
+			}
+		}
 		if !p.match(lexer.TokenRightParen) {
 			return nil, &Error{Message: "expected ')'", Line: p.line(), Col: p.col()}
 		}
 	}
-	return &StartCoroutineStatement{SubName: subName}, nil
+	return &StartCoroutineStatement{SubName: subName, Args: args}, nil
+}
+
+// isAwait reports whether the current token is AWAIT followed by the start of an operand. AWAIT is not a
+// keyword, so a variable called await still parses as before (await = 1).
+func (p *Parser) isAwait() bool {
+	tok := p.peek()
+	if tok.Type != lexer.TokenIdentifier || !strings.EqualFold(tok.Value, "AWAIT") || p.current+1 >= len(p.tokens) {
+		return false
+	}
+	switch p.tokens[p.current+1].Type {
+	case lexer.TokenIdentifier, lexer.TokenLeftParen, lexer.TokenStartCoroutine, lexer.TokenStartTask:
+		return true
+	}
+	return false
+}
+
+// awaitExpression parses AWAIT operand (the operand binds like a unary operator's).
+func (p *Parser) awaitExpression() (Node, error) {
+	tok := p.advance() // AWAIT
+	value, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return &AwaitExpression{Value: value, Line: tok.Line, Col: tok.Col}, nil
 }
 
 // yieldStatement parses Yield
@@ -586,14 +708,24 @@ func (p *Parser) typeDeclStatement() (Node, error) {
 	if !p.match(lexer.TokenIdentifier) {
 		return nil, &Error{Message: "expected type name after TYPE", Line: p.line(), Col: p.col()}
 	}
-	typeName := p.previous().Value
+	nameTok := p.previous()
+	typeName := nameTok.Value
 	var fields []TypeField
+	var methods []Node
 	for {
 		for p.match(lexer.TokenNewLine) {
 		}
 		if p.match(lexer.TokenEndType) {
 			break
 		}
+		if p.check(lexer.TokenFunction) || p.check(lexer.TokenSub) {
+			m, err := p.methodDecl(typeName)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, m)
+			continue
+		}
 		if p.check(lexer.TokenEnd) {
 			p.advance()
 			if p.match(lexer.TokenTypeKw) {
@@ -605,7 +737,7 @@ func (p *Parser) typeDeclStatement() (Node, error) {
 			return nil, &Error{Message: "expected ENDTYPE or END TYPE", Line: p.line(), Col: p.col()}
 		}
 		if !p.match(lexer.TokenIdentifier) {
-			return nil, &Error{Message: "expected field name or ENDTYPE", Line: p.line(), Col: p.col()}
+			return nil, &Error{Message: "expected field name, FUNCTION, SUB or ENDTYPE", Line: p.line(), Col: p.col()}
 		}
 		fieldName := p.previous().Value
 		fieldType := ""
@@ -625,7 +757,30 @@ func (p *Parser) typeDeclStatement() (Node, error) {
 		}
 		fields = append(fields, TypeField{Name: fieldName, FieldType: fieldType, ConstValue: constVal})
 	}
-	return &TypeDecl{Name: typeName, Fields: fields}, nil
+	return &TypeDecl{Name: typeName, Fields: fields, Methods: methods, Line: nameTok.Line, Col: nameTok.Col}, nil
+}
+
+// methodDecl parses a FUNCTION or SUB inside TYPE typeName ... END TYPE. The instance it is called on is the
+// implicit first parameter ME (SELF is an alias).
+func (p *Parser) methodDecl(typeName string) (Node, error) {
+	if p.check(lexer.TokenFunction) {
+		n, err := p.functionDecl()
+		if err != nil {
+			return nil, err
+		}
+		fd := n.(*FunctionDecl)
+		fd.TypeName = typeName
+		fd.Parameters = append([]string{"Me"}, fd.Parameters...)
+		return fd, nil
+	}
+	n, err := p.subDecl()
+	if err != nil {
+		return nil, err
+	}
+	sd := n.(*SubDecl)
+	sd.TypeName = typeName
+	sd.Parameters = append([]string{"Me"}, sd.Parameters...)
+	return sd, nil
 }
 
 // checkEndEntity returns true if current position is ENDENTITY or END ENTITY.
@@ -689,7 +844,8 @@ func (p *Parser) dimStatement() (Node, error) {
 		if !p.match(lexer.TokenIdentifier) {
 			return nil, &Error{Message: "expected variable name", Line: p.line(), Col: p.col()}
 		}
-		name := p.previous().Value
+		nameTok := p.previous()
+		name := nameTok.Value
 
 		var dimensions []Node
 		if p.match(lexer.TokenLeftParen) {
@@ -716,7 +872,7 @@ func (p *Parser) dimStatement() (Node, error) {
 			varType = p.previous().Value
 		}
 
-		variables = append(variables, VariableDecl{Name: name, Type: varType, Dimensions: dimensions})
+		variables = append(variables, VariableDecl{Name: name, Type: varType, Dimensions: dimensions, Line: nameTok.Line, Col: nameTok.Col})
 
 		if !p.match(lexer.TokenComma) {
 			break
@@ -938,6 +1094,157 @@ func (p *Parser) gosubStatement() (Node, error) {
 	return &GosubStatement{SubName: name}, nil
 }
 
+// checkEndTry returns true if the current position is ENDTRY or END TRY (two words).
+func (p *Parser) checkEndTry() bool {
+	if p.check(lexer.TokenEndTry) {
+		return true
+	}
+	return p.check(lexer.TokenEnd) && p.current+1 < len(p.tokens) && p.tokens[p.current+1].Type == lexer.TokenTry
+}
+
+// blockUntilTryClause parses statements until CATCH, FINALLY or END TRY (does not consume them).
+func (p *Parser) blockUntilTryClause() (*Block, error) {
+	block := &Block{}
+	for !p.isAtEnd() && !p.check(lexer.TokenCatch) && !p.check(lexer.TokenFinally) && !p.checkEndTry() {
+		if p.check(lexer.TokenEnd) {
+			return nil, &Error{Message: "expected CATCH, FINALLY or END TRY", Line: p.line(), Col: p.col()}
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		if p.match(lexer.TokenNewLine) {
+			continue
+		}
+	}
+	return block, nil
+}
+
+// tryStatement parses TRY ... [CATCH [var] ...] [FINALLY ...] END TRY
+func (p *Parser) tryStatement() (Node, error) {
+	tryTok := p.advance() // TRY
+	body, err := p.blockUntilTryClause()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &TryStatement{Body: body, Line: tryTok.Line, Col: tryTok.Col}
+	if p.match(lexer.TokenCatch) {
+		if p.match(lexer.TokenIdentifier) {
+			stmt.CatchVar = p.previous().Value
+		}
+		if stmt.CatchBlock, err = p.blockUntilTryClause(); err != nil {
+			return nil, err
+		}
+	}
+	if p.match(lexer.TokenFinally) {
+		if stmt.FinallyBlock, err = p.blockUntilTryClause(); err != nil {
+			return nil, err
+		}
+	}
+	if p.check(lexer.TokenCatch) || p.check(lexer.TokenFinally) {
+		return nil, &Error{Message: "CATCH must come before FINALLY and each may appear once", Line: p.line(), Col: p.col()}
+	}
+	if p.match(lexer.TokenEndTry) {
+		// consumed
+	} else if p.match(lexer.TokenEnd) {
+		p.advance() // TRY (checked by checkEndTry)
+	} else {
+		return nil, &Error{Message: "expected END TRY", Line: p.line(), Col: p.col()}
+	}
+	if stmt.CatchBlock == nil && stmt.FinallyBlock == nil {
+		return nil, &Error{Message: "TRY requires a CATCH or FINALLY clause", Line: tryTok.Line, Col: tryTok.Col}
+	}
+	return stmt, nil
+}
+
+// isTestStatement reports whether the current token starts TEST "name". TEST is not a keyword, so a variable
+// or function called Test still parses as before.
+func (p *Parser) isTestStatement() bool {
+	tok := p.peek()
+	return tok.Type == lexer.TokenIdentifier && strings.EqualFold(tok.Value, "TEST") && p.current+1 < len(p.tokens) &&
+		p.tokens[p.current+1].Type == lexer.TokenString
+}
+
+// checkEndTest returns true if the current position is END TEST.
+func (p *Parser) checkEndTest() bool {
+	return p.check(lexer.TokenEnd) && p.current+1 < len(p.tokens) &&
+		p.tokens[p.current+1].Type == lexer.TokenIdentifier && strings.EqualFold(p.tokens[p.current+1].Value, "TEST")
+}
+
+// testStatement parses TEST "name" ... END TEST.
+func (p *Parser) testStatement() (Node, error) {
+	testTok := p.advance() // TEST
+	name := p.advance().Value
+	body := &Block{}
+	for !p.checkEndTest() {
+		if p.isAtEnd() || p.check(lexer.TokenEnd) {
+			return nil, &Error{Message: "expected END TEST", Line: p.line(), Col: p.col()}
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			body.Statements = append(body.Statements, stmt)
+		}
+		p.match(lexer.TokenNewLine)
+	}
+	p.advance() // END
+	p.advance() // TEST
+	return &TestStatement{Name: name, Body: body, Line: testTok.Line, Col: testTok.Col}, nil
+}
+
+// isEmitStatement reports whether the current token starts EMIT name [, args]. EMIT is not a keyword, so a
+// variable or function called Emit still parses as before (emit = 1, Emit(x)).
+func (p *Parser) isEmitStatement() bool {
+	tok := p.peek()
+	if tok.Type != lexer.TokenIdentifier || !strings.EqualFold(tok.Value, "EMIT") || p.current+1 >= len(p.tokens) {
+		return false
+	}
+	next := p.tokens[p.current+1]
+	return next.Type == lexer.TokenString || next.Type != lexer.TokenNewLine && isWordToken(next.Value)
+}
+
+// emitStatement parses EMIT name [, arg, ...] (name is an event name or a string expression).
+func (p *Parser) emitStatement() (Node, error) {
+	tok := p.advance() // EMIT
+	var name Node
+	if p.check(lexer.TokenString) {
+		n, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		name = n
+	} else {
+		name = &StringLiteral{Value: p.advance().Value}
+	}
+	var args []Node
+	for p.match(lexer.TokenComma) {
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return &EmitStatement{Name: name, Args: args, Line: tok.Line, Col: tok.Col}, nil
+}
+
+// throwStatement parses THROW expr
+func (p *Parser) throwStatement() (Node, error) {
+	tok := p.advance() // THROW
+	if p.check(lexer.TokenNewLine) || p.isAtEnd() {
+		return nil, &Error{Message: "THROW requires a value", Line: p.line(), Col: p.col()}
+	}
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &ThrowStatement{Value: value, Line: tok.Line, Col: tok.Col}, nil
+}
+
 // gameCommand parses game-specific commands
 func (p *Parser) gameCommand() (Node, error) {
 	command := p.advance().Value
@@ -390,3 +390,125 @@ VAR y = cfg["a"]["b"]
 		t.Errorf("inner JSONIndexAccess.Key: got %q", inner.Key)
 	}
 }
+
+func TestParseTry(t *testing.T) {
+	src := `Try
+  Throw "x"
+Catch e
+  Print(e.message)
+Finally
+  Print("done")
+EndTry
+Try
+  Print(1)
+Finally
+  Print(2)
+End Try
+`
+	prog := mustParse(t, src)
+	if len(prog.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(prog.Statements))
+	}
+	tr, ok := prog.Statements[0].(*TryStatement)
+	if !ok {
+		t.Fatalf("expected TryStatement, got %T", prog.Statements[0])
+	}
+	if tr.CatchVar != "e" || tr.CatchBlock == nil || tr.FinallyBlock == nil {
+		t.Errorf("unexpected try: %+v", tr)
+	}
+	if _, ok := tr.Body.Statements[0].(*ThrowStatement); !ok {
+		t.Errorf("expected ThrowStatement, got %T", tr.Body.Statements[0])
+	}
+	if tr2 := prog.Statements[1].(*TryStatement); tr2.CatchBlock != nil || tr2.FinallyBlock == nil {
+		t.Errorf("unexpected try/finally: %+v", tr2)
+	}
+
+	for _, bad := range []string{"Try\n  Print(1)\nEnd Try\n", "Catch e\n", "Try\n  Print(1)\n"} {
+		l := lexer.New(bad)
+		tokens, err := l.Tokenize()
+		if err != nil {
+			t.Fatalf("tokenize: %v", err)
+		}
+		if _, err := New(tokens).Parse(); err == nil {
+			t.Errorf("expected parse error for %q", bad)
+		}
+	}
+}
+
+func TestParseForEachAndCollectionLiterals(t *testing.T) {
+	src := `FOR EACH k, v IN ["a": 1, "b": [2, 3]]
+  PRINT k
+NEXT
+FOR EACH n IN []
+NEXT
+m = [:]
+each = 1
+FOR each = 1 TO 2
+NEXT
+`
+	prog := mustParse(t, src)
+	if len(prog.Statements) != 5 {
+		t.Fatalf("expected 5 statements, got %d", len(prog.Statements))
+	}
+	fe, ok := prog.Statements[0].(*ForEachStatement)
+	if !ok {
+		t.Fatalf("expected ForEachStatement, got %T", prog.Statements[0])
+	}
+	if fe.Key != "k" || fe.Variable != "v" {
+		t.Errorf("FOR EACH variables: key %q value %q", fe.Key, fe.Variable)
+	}
+	if got := fe.Collection.String(); got != `["a": 1, "b": [2, 3]]` {
+		t.Errorf("collection = %s", got)
+	}
+	if fe2 := prog.Statements[1].(*ForEachStatement); fe2.Key != "" || len(fe2.Collection.(*ListLiteral).Elements) != 0 {
+		t.Errorf("FOR EACH n IN []: %s", fe2)
+	}
+	if m := prog.Statements[2].(*Assignment).Value; m.String() != "[:]" {
+		t.Errorf("empty map literal = %s", m)
+	}
+	if _, ok := prog.Statements[4].(*ForStatement); !ok {
+		t.Errorf("FOR each = ... TO is a counted loop, got %T", prog.Statements[4])
+	}
+	for _, bad := range []string{"x = [1: 2, 3]\n", "x = [1, 2\n", "FOR EACH a IN\nNEXT\n", "FOR EACH a OF b\nNEXT\n"} {
+		tokens, _ := lexer.New(bad).Tokenize()
+		if _, err := New(tokens).Parse(); err == nil {
+			t.Errorf("parse %q: want error", bad)
+		}
+	}
+}
+
+func TestParseTestBlock(t *testing.T) {
+	src := `TEST "adds points"
+  ASSERT score = 5, "score"
+END TEST
+test = 1
+PRINT test
+`
+	prog := mustParse(t, src)
+	if len(prog.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(prog.Statements))
+	}
+	ts, ok := prog.Statements[0].(*TestStatement)
+	if !ok {
+		t.Fatalf("expected TestStatement, got %T", prog.Statements[0])
+	}
+	if ts.Name != "adds points" || ts.Line != 1 || len(ts.Body.Statements) != 1 {
+		t.Errorf("unexpected test: %+v", ts)
+	}
+	low := ts.Lowered()
+	if low != ts.Lowered() || len(low.Statements) != 3 {
+		t.Fatalf("Lowered = %v", low)
+	}
+	if got := low.Statements[0].String(); got != `TestBegin("adds points", 1)` {
+		t.Errorf("lowered begin = %s", got)
+	}
+	if tr := low.Statements[1].(*TryStatement); tr.Body != ts.Body || tr.CatchVar != "__testerr" {
+		t.Errorf("lowered try = %+v", tr)
+	}
+	for _, bad := range []string{"TEST \"a\"\n  PRINT 1\n", "TEST \"a\"\nEND IF\n"} {
+		tokens, _ := lexer.New(bad).Tokenize()
+		if _, err := New(tokens).Parse(); err == nil {
+			t.Errorf("parse %q: want error", bad)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package rng is the random number source behind RND, the std random commands, GetRandomValue and the particle
+// and scatter effects. It starts from a clock seed; --record stores the seed in the replay file and --replay
+// seeds it again, so a replayed session draws the same numbers.
+package rng
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	mu   sync.Mutex
+	seed int64
+	src  *rand.Rand
+)
+
+func init() {
+	Seed(time.Now().UnixNano())
+}
+
+// Seed restarts the source from s.
+func Seed(s int64) {
+	mu.Lock()
+	seed, src = s, rand.New(rand.NewSource(s))
+	mu.Unlock()
+}
+
+// Current returns the seed the source last started from.
+func Current() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return seed
+}
+
+// Float64 returns a number in [0, 1).
+func Float64() float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return src.Float64()
+}
+
+// Int returns a non-negative int.
+func Int() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return src.Int()
+}
+
+// Intn returns an int in [0, n); n must be positive.
+func Intn(n int) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return src.Intn(n)
+}
+
+// Int31 returns a non-negative int32.
+func Int31() int32 {
+	mu.Lock()
+	defer mu.Unlock()
+	return src.Int31()
+}
+
+// Int63 returns a non-negative int64, e.g. to seed a source of one's own.
+func Int63() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return src.Int63()
+}
@@ -1,16 +1,16 @@
 package runtime
 
 import (
-	"strings"
 	"sync"
 
 	gametime "cyberbasic/compiler/runtime/time"
+	"cyberbasic/compiler/vm"
 )
 
 var (
 	fixedUpdateMu    sync.RWMutex
 	fixedUpdateRate  float64 = 60
-	fixedUpdateLabel string
+	fixedUpdateLabel vm.Value
 )
 
 // SetFixedUpdateRate configures the fixed update rate and keeps the time package in sync.
@@ -31,15 +31,16 @@ func FixedUpdateRate() float64 {
 	return fixedUpdateRate
 }
 
-// SetFixedUpdateLabel sets the user callback invoked on each fixed step.
-func SetFixedUpdateLabel(label string) {
+// SetFixedUpdateLabel sets the user callback invoked on each fixed step: a Sub name or function value, normalized
+// by vm.Callback (nil or "" clears it).
+func SetFixedUpdateLabel(label vm.Value) {
 	fixedUpdateMu.Lock()
-	fixedUpdateLabel = strings.TrimSpace(label)
+	fixedUpdateLabel = vm.Callback(label)
 	fixedUpdateMu.Unlock()
 }
 
-// FixedUpdateLabel returns the user callback invoked on each fixed step.
-func FixedUpdateLabel() string {
+// FixedUpdateLabel returns the user callback invoked on each fixed step (nil when none).
+func FixedUpdateLabel() vm.Value {
 	fixedUpdateMu.RLock()
 	defer fixedUpdateMu.RUnlock()
 	return fixedUpdateLabel
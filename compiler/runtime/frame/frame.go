@@ -0,0 +1,79 @@
+// Package frame ends every rendered frame in one place (SYNC, EndDrawing, the hybrid and unified renderers and
+// the implicit loop), so tools such as --watch can run at the frame boundary and draw over the program's output.
+// It also counts frames, for --frames.
+package frame
+
+import (
+	"sync"
+
+	"cyberbasic/compiler/runtime/headless"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+var (
+	mu      sync.Mutex
+	hooks   []func()
+	count   int
+	limit   int
+	stop    func()
+	stopped bool
+)
+
+// OnEnd registers f to run at the end of every frame, after the program has drawn and before the frame is
+// presented. f runs on the program's thread and may draw with raylib.
+func OnEnd(f func()) {
+	mu.Lock()
+	hooks = append(hooks, f)
+	mu.Unlock()
+}
+
+// Limit ends the program after n more frames (--frames; 0 removes the limit): from the end of frame n on, Done
+// reports true, so WindowShouldClose does and a loop testing it ends normally. A program that ends another frame
+// anyway is stopped then: stopFn (e.g. VM.Quit) runs once. Count restarts from 0.
+func Limit(n int, stopFn func()) {
+	mu.Lock()
+	count, limit, stop, stopped = 0, n, stopFn, false
+	mu.Unlock()
+}
+
+// Count returns the number of frames that have ended (since Limit).
+func Count() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return count
+}
+
+// Done reports whether the frame limit has been reached.
+func Done() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return limit > 0 && count >= limit
+}
+
+// End runs the OnEnd functions, presents the frame (rl.EndDrawing unless headless) and ends the scripted frame
+// (headless.EndFrame, when headless or scripted).
+func End() {
+	mu.Lock()
+	run := hooks
+	mu.Unlock()
+	for _, f := range run {
+		f()
+	}
+	if !headless.Enabled() {
+		rl.EndDrawing()
+	}
+	if headless.Scripted() {
+		headless.EndFrame()
+	}
+	mu.Lock()
+	count++
+	var fn func()
+	if limit > 0 && count > limit && !stopped {
+		stopped, fn = true, stop
+	}
+	mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
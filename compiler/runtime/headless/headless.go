@@ -0,0 +1,193 @@
+// Package headless runs programs without a window or GPU (--headless), for tests in CI and dedicated servers.
+//
+// When it is enabled, bindings.RegisterAll replaces the commands that draw, open windows, touch GPU resources or
+// play audio with ones that only record their calls, and the runtime skips raylib at the frame boundary. Game
+// logic, timing, physics, ECS and networking still run. Every frame lasts exactly FrameTime seconds, and input
+// comes from a script (the Simulate commands or Go code calling SetKey, SetMouse...), not from devices.
+//
+// Script turns on only the scripted clock and input, with a window: --record and --replay (package replay)
+// feed them from the devices or from a replay file at every frame boundary.
+package headless
+
+import (
+	"sync"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Call is a drawing or resource command the null renderer received.
+type Call struct {
+	Name string
+	Args []interface{}
+}
+
+var (
+	mu        sync.Mutex
+	enabled   bool
+	scripted  bool
+	frameTime float32 = 1.0 / 60.0
+	elapsed   float64
+	width     = 800
+	height    = 600
+	current   []Call   // calls of the frame in progress
+	last      []Call   // calls of the last frame that ended
+	endHooks  []func() // OnEndFrame functions
+
+	// The window's frame time and clock, read when neither headless nor scripted (see SetDeviceClock); nil
+	// reads as 0.
+	deviceFrameTime func() float32
+	deviceTime      func() float64
+)
+
+// SetDeviceClock sets where FrameTime and Time read from when neither headless nor scripted. The raylib
+// bindings pass rl.GetFrameTime and rl.GetTime, so this package builds without cgo.
+func SetDeviceClock(frameTime func() float32, now func() float64) {
+	mu.Lock()
+	deviceFrameTime, deviceTime = frameTime, now
+	mu.Unlock()
+}
+
+// Enable turns headless mode on. It must be called before the bindings are registered.
+func Enable() {
+	mu.Lock()
+	enabled = true
+	mu.Unlock()
+}
+
+// Enabled reports whether the program runs headless.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Script makes the clock and the input commands scripted, as when headless, while the program still draws to a
+// window. It must be called before the bindings are registered.
+func Script() {
+	mu.Lock()
+	scripted = true
+	mu.Unlock()
+}
+
+// Scripted reports whether the clock and input are scripted (headless, --record or --replay).
+func Scripted() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled || scripted
+}
+
+// SetFrameTime sets the length of a scripted frame in seconds (SetTargetFPS sets it to 1/fps when headless).
+func SetFrameTime(sec float64) {
+	if sec <= 0 {
+		return
+	}
+	mu.Lock()
+	frameTime = float32(sec)
+	mu.Unlock()
+}
+
+// FrameTime returns the length of the last frame: the scripted frame time when scripted, otherwise raylib's
+// GetFrameTime. Bindings call it instead of rl.GetFrameTime.
+func FrameTime() float32 {
+	mu.Lock()
+	on, sec, device := enabled || scripted, frameTime, deviceFrameTime
+	mu.Unlock()
+	if on {
+		return sec
+	}
+	if device == nil {
+		return 0
+	}
+	return device()
+}
+
+// Time returns the seconds since the program started: the sum of the frame times of the frames ended so far
+// when scripted, otherwise raylib's GetTime.
+func Time() float64 {
+	mu.Lock()
+	on, now, device := enabled || scripted, elapsed, deviceTime
+	mu.Unlock()
+	if on {
+		return now
+	}
+	if device == nil {
+		return 0
+	}
+	return device()
+}
+
+// SetScreenSize sets the size of the window the program believes it has (InitWindow, SetWindowSize).
+func SetScreenSize(w, h int) {
+	mu.Lock()
+	width, height = w, h
+	mu.Unlock()
+}
+
+// ScreenSize returns the size set by SetScreenSize (800x600 by default).
+func ScreenSize() (w, h int) {
+	mu.Lock()
+	defer mu.Unlock()
+	return width, height
+}
+
+// Record notes a call received by the null renderer in the current frame.
+func Record(name string, args []interface{}) {
+	a := make([]interface{}, len(args))
+	copy(a, args)
+	mu.Lock()
+	current = append(current, Call{Name: name, Args: a})
+	mu.Unlock()
+}
+
+// RecordQueues records the VM's queued 2D, 3D and GUI draw commands in that order; the hybrid and unified
+// renderers call it in place of drawing them.
+func RecordQueues(v *vm.VM) {
+	q2D, q3D, qGUI := v.GetRenderQueues()
+	for _, q := range [][]vm.RenderQueueItem{q2D, q3D, qGUI} {
+		for _, item := range q {
+			Record(item.Name, item.Args)
+		}
+	}
+}
+
+// LastFrame returns the calls recorded during the last frame that ended.
+func LastFrame() []Call {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Call(nil), last...)
+}
+
+// EndFrame ends a scripted frame: time advances by the frame time, the recorded calls become LastFrame,
+// pressed/released input edges are cleared and the OnEndFrame functions run. frame.End calls it in place of
+// presenting when headless, and after presenting when only scripted.
+func EndFrame() {
+	mu.Lock()
+	elapsed += float64(frameTime)
+	last, current = current, nil
+	mu.Unlock()
+	endInputFrame()
+	mu.Lock()
+	next := endHooks
+	mu.Unlock()
+	for _, f := range next {
+		f()
+	}
+}
+
+// OnEndFrame registers f to run at the end of every EndFrame, before the next frame starts (package replay sets
+// the next frame's input there).
+func OnEndFrame(f func()) {
+	mu.Lock()
+	endHooks = append(endHooks, f)
+	mu.Unlock()
+}
+
+// Reset turns headless and scripted mode off and clears the clock, recording and input (for tests).
+func Reset() {
+	mu.Lock()
+	enabled, scripted, frameTime, elapsed = false, false, 1.0/60.0, 0
+	width, height = 800, 600
+	current, last = nil, nil
+	mu.Unlock()
+	resetInput()
+}
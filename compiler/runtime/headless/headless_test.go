@@ -0,0 +1,98 @@
+package headless
+
+import "testing"
+
+func TestClockAndRecording(t *testing.T) {
+	Enable()
+	defer Reset()
+	SetFrameTime(0.5)
+	Record("DrawCircle", []interface{}{1.0, 2.0})
+	if got := LastFrame(); len(got) != 0 {
+		t.Fatalf("LastFrame before the frame ended = %v", got)
+	}
+	EndFrame()
+	EndFrame()
+	if FrameTime() != 0.5 || Time() != 1 {
+		t.Errorf("FrameTime, Time = %v, %v; want 0.5, 1", FrameTime(), Time())
+	}
+	if got := LastFrame(); len(got) != 0 {
+		t.Errorf("second frame recorded %v", got)
+	}
+}
+
+func TestInputEdges(t *testing.T) {
+	Enable()
+	defer Reset()
+	SetKey(32, true)
+	SetKey(65, true)
+	if !KeyDown(32) || !KeyPressed(32) || KeyReleased(32) {
+		t.Error("key 32 not pressed in the frame it went down")
+	}
+	if NextKeyPressed() != 32 || NextKeyPressed() != 65 || NextKeyPressed() != 0 {
+		t.Error("GetKeyPressed order")
+	}
+	EndFrame()
+	if !KeyDown(32) || KeyPressed(32) {
+		t.Error("key 32 still pressed a frame later")
+	}
+	SetKey(32, false)
+	if !KeyReleased(32) {
+		t.Error("key 32 not released")
+	}
+
+	SetMouse(10, 20)
+	SetMouseButton(1, true)
+	if dx, dy := MouseDelta(); dx != 10 || dy != 20 || !MouseButtonPressed(1) {
+		t.Errorf("mouse delta %v,%v pressed %v", dx, dy, MouseButtonPressed(1))
+	}
+	EndFrame()
+	if dx, _ := MouseDelta(); dx != 0 || MouseButtonPressed(1) || !MouseButtonDown(1) {
+		t.Error("mouse edges not cleared at the end of the frame")
+	}
+}
+
+func TestGamepadAndScript(t *testing.T) {
+	Script()
+	defer Reset()
+	if Enabled() || !Scripted() {
+		t.Fatal("Script should script input without going headless")
+	}
+	if GamepadAvailable(0) {
+		t.Fatal("gamepad 0 connected before any input")
+	}
+	SetGamepadButton(0, 7, true)
+	SetGamepadAxis(0, 1, 0.75)
+	if !GamepadAvailable(0) || !GamepadButtonPressed(0, 7) || LastGamepadButtonPressed() != 7 || GamepadAxis(0, 1) != 0.75 {
+		t.Error("gamepad 0 button 7 and axis 1 not scripted")
+	}
+	EndFrame()
+	if GamepadButtonPressed(0, 7) || !GamepadButtonDown(0, 7) {
+		t.Error("gamepad button still pressed a frame later")
+	}
+	SetGamepad(0, false)
+	if GamepadAvailable(0) || !GamepadButtonReleased(0, 7) || GamepadAxis(0, 1) != 0 {
+		t.Error("disconnecting did not release the gamepad")
+	}
+}
+
+func TestRasterize(t *testing.T) {
+	img := Rasterize([]Call{
+		{"ClearBackground", []interface{}{0.0, 0.0, 64.0, 255.0}},
+		{"DrawRectangle", []interface{}{1.0, 1.0, 3.0, 2.0, 255.0, 0.0, 0.0}},
+		{"DrawPixel", []interface{}{7.0, 7.0, float64(0x00ff00)}},
+		{"DrawLine", []interface{}{0.0, 9.0, 9.0, 9.0}},
+		{"DrawRectangle", []interface{}{5.0, 0.0, 2.0, 2.0, 255.0, 255.0, 255.0, 0.0}},
+		{"DrawText", []interface{}{"skipped", 0.0, 0.0, 10.0}},
+	}, 10, 10)
+	for _, c := range []struct {
+		x, y    int
+		r, g, b uint8
+	}{
+		{0, 0, 0, 0, 64}, {1, 1, 255, 0, 0}, {3, 2, 255, 0, 0}, {4, 1, 0, 0, 64}, {1, 3, 0, 0, 64},
+		{7, 7, 0, 255, 0}, {5, 9, 255, 255, 255}, {5, 0, 0, 0, 64},
+	} {
+		if got := img.RGBAAt(c.x, c.y); got.R != c.r || got.G != c.g || got.B != c.b || got.A != 255 {
+			t.Errorf("pixel %d,%d = %v, want %d,%d,%d", c.x, c.y, got, c.r, c.g, c.b)
+		}
+	}
+}
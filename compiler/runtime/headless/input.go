@@ -0,0 +1,301 @@
+package headless
+
+import (
+	"sort"
+	"sync"
+)
+
+// Scripted input: keys are raylib key codes, mouse buttons 0 (left), 1 (right) and 2 (middle), and gamepads 0-3
+// with raylib's button (0-17) and axis (0-5) numbers, as the input commands take them. A key or button is pressed
+// in the frame it went down and released in the frame it went up.
+
+// Gamepad limits of the scripted input.
+const (
+	MaxGamepads    = 4
+	GamepadButtons = 18
+	GamepadAxes    = 6
+)
+
+type gamepad struct {
+	connected bool
+	buttons   [GamepadButtons]bool
+	axes      [GamepadAxes]float64
+}
+
+var (
+	inMu       sync.Mutex
+	keys       = map[int]bool{}
+	keysBefore = map[int]bool{} // state at the end of the previous frame
+	keyQueue   []int            // keys that went down this frame, for GetKeyPressed
+	buttons    [3]bool
+	btnBefore  [3]bool
+	mouseX     float64
+	mouseY     float64
+	mouseDX    float64
+	mouseDY    float64
+	wheel      float64
+	pads       [MaxGamepads]gamepad
+	padsBefore [MaxGamepads][GamepadButtons]bool
+)
+
+// SetKey presses (down) or releases the key.
+func SetKey(key int, down bool) {
+	inMu.Lock()
+	defer inMu.Unlock()
+	if down && !keys[key] {
+		keyQueue = append(keyQueue, key)
+	}
+	keys[key] = down
+}
+
+// KeyDown reports whether the key is held.
+func KeyDown(key int) bool {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return keys[key]
+}
+
+// KeyPressed reports whether the key went down this frame.
+func KeyPressed(key int) bool {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return keys[key] && !keysBefore[key]
+}
+
+// KeyReleased reports whether the key went up this frame.
+func KeyReleased(key int) bool {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return !keys[key] && keysBefore[key]
+}
+
+// KeysDown returns the held keys in ascending order.
+func KeysDown() []int {
+	inMu.Lock()
+	defer inMu.Unlock()
+	var down []int
+	for k, d := range keys {
+		if d {
+			down = append(down, k)
+		}
+	}
+	sort.Ints(down)
+	return down
+}
+
+// NextKeyPressed returns the next key that went down this frame, or 0 when there are no more (GetKeyPressed).
+func NextKeyPressed() int {
+	inMu.Lock()
+	defer inMu.Unlock()
+	if len(keyQueue) == 0 {
+		return 0
+	}
+	k := keyQueue[0]
+	keyQueue = keyQueue[1:]
+	return k
+}
+
+// SetMouse moves the mouse to (x, y).
+func SetMouse(x, y float64) {
+	inMu.Lock()
+	defer inMu.Unlock()
+	mouseDX, mouseDY = mouseDX+x-mouseX, mouseDY+y-mouseY
+	mouseX, mouseY = x, y
+}
+
+// Mouse returns the mouse position.
+func Mouse() (x, y float64) {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return mouseX, mouseY
+}
+
+// MouseDelta returns how far the mouse moved this frame.
+func MouseDelta() (dx, dy float64) {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return mouseDX, mouseDY
+}
+
+// SetMouseButton presses (down) or releases mouse button b.
+func SetMouseButton(b int, down bool) {
+	if b < 0 || b >= len(buttons) {
+		return
+	}
+	inMu.Lock()
+	buttons[b] = down
+	inMu.Unlock()
+}
+
+// MouseButtonDown reports whether mouse button b is held.
+func MouseButtonDown(b int) bool {
+	if b < 0 || b >= len(buttons) {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return buttons[b]
+}
+
+// MouseButtonPressed reports whether mouse button b went down this frame.
+func MouseButtonPressed(b int) bool {
+	if b < 0 || b >= len(buttons) {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return buttons[b] && !btnBefore[b]
+}
+
+// MouseButtonReleased reports whether mouse button b went up this frame.
+func MouseButtonReleased(b int) bool {
+	if b < 0 || b >= len(buttons) {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return !buttons[b] && btnBefore[b]
+}
+
+// SetWheel sets the mouse wheel movement of this frame.
+func SetWheel(d float64) {
+	inMu.Lock()
+	wheel = d
+	inMu.Unlock()
+}
+
+// Wheel returns the mouse wheel movement of this frame.
+func Wheel() float64 {
+	inMu.Lock()
+	defer inMu.Unlock()
+	return wheel
+}
+
+// SetGamepad connects or disconnects gamepad pad; disconnecting releases its buttons and centres its axes.
+func SetGamepad(pad int, connected bool) {
+	if pad < 0 || pad >= MaxGamepads {
+		return
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	if connected {
+		pads[pad].connected = true
+	} else {
+		pads[pad] = gamepad{}
+	}
+}
+
+// GamepadAvailable reports whether gamepad pad is connected.
+func GamepadAvailable(pad int) bool {
+	if pad < 0 || pad >= MaxGamepads {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return pads[pad].connected
+}
+
+// SetGamepadButton presses (down) or releases button b of gamepad pad, connecting the gamepad.
+func SetGamepadButton(pad, b int, down bool) {
+	if pad < 0 || pad >= MaxGamepads || b < 0 || b >= GamepadButtons {
+		return
+	}
+	inMu.Lock()
+	pads[pad].connected = true
+	pads[pad].buttons[b] = down
+	inMu.Unlock()
+}
+
+// GamepadButtonDown reports whether button b of gamepad pad is held.
+func GamepadButtonDown(pad, b int) bool {
+	if pad < 0 || pad >= MaxGamepads || b < 0 || b >= GamepadButtons {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return pads[pad].buttons[b]
+}
+
+// GamepadButtonPressed reports whether button b of gamepad pad went down this frame.
+func GamepadButtonPressed(pad, b int) bool {
+	if pad < 0 || pad >= MaxGamepads || b < 0 || b >= GamepadButtons {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return pads[pad].buttons[b] && !padsBefore[pad][b]
+}
+
+// GamepadButtonReleased reports whether button b of gamepad pad went up this frame.
+func GamepadButtonReleased(pad, b int) bool {
+	if pad < 0 || pad >= MaxGamepads || b < 0 || b >= GamepadButtons {
+		return false
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return !pads[pad].buttons[b] && padsBefore[pad][b]
+}
+
+// LastGamepadButtonPressed returns the highest-numbered button that went down this frame on any gamepad, or 0
+// (GetGamepadButtonPressed).
+func LastGamepadButtonPressed() int {
+	inMu.Lock()
+	defer inMu.Unlock()
+	last := 0 // GAMEPAD_BUTTON_UNKNOWN
+	for p := range pads {
+		for b, down := range pads[p].buttons {
+			if down && !padsBefore[p][b] && b > last {
+				last = b
+			}
+		}
+	}
+	return last
+}
+
+// SetGamepadAxis sets axis of gamepad pad to v (-1 to 1), connecting the gamepad.
+func SetGamepadAxis(pad, axis int, v float64) {
+	if pad < 0 || pad >= MaxGamepads || axis < 0 || axis >= GamepadAxes {
+		return
+	}
+	inMu.Lock()
+	pads[pad].connected = true
+	pads[pad].axes[axis] = v
+	inMu.Unlock()
+}
+
+// GamepadAxis returns the value of axis of gamepad pad.
+func GamepadAxis(pad, axis int) float64 {
+	if pad < 0 || pad >= MaxGamepads || axis < 0 || axis >= GamepadAxes {
+		return 0
+	}
+	inMu.Lock()
+	defer inMu.Unlock()
+	return pads[pad].axes[axis]
+}
+
+// endInputFrame makes this frame's state the previous one and clears the per-frame edges and movement.
+func endInputFrame() {
+	inMu.Lock()
+	defer inMu.Unlock()
+	keysBefore = make(map[int]bool, len(keys))
+	for k, down := range keys {
+		if down {
+			keysBefore[k] = true
+		}
+	}
+	keyQueue = nil
+	btnBefore = buttons
+	for p := range pads {
+		padsBefore[p] = pads[p].buttons
+	}
+	mouseDX, mouseDY, wheel = 0, 0, 0
+}
+
+func resetInput() {
+	inMu.Lock()
+	defer inMu.Unlock()
+	keys, keysBefore, keyQueue = map[int]bool{}, map[int]bool{}, nil
+	buttons, btnBefore = [3]bool{}, [3]bool{}
+	pads, padsBefore = [MaxGamepads]gamepad{}, [MaxGamepads][GamepadButtons]bool{}
+	mouseX, mouseY, mouseDX, mouseDY, wheel = 0, 0, 0, 0, 0
+}
@@ -0,0 +1,190 @@
+package headless
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Rasterize draws calls, as the null renderer recorded them, into a w x h image with a small software
+// rasterizer, for golden-image tests (cyberbasic test). It draws ClearBackground and the 2D shapes: filled and
+// outlined rectangles, circles and triangles, lines and pixels. Other calls (text, textures, 3D) are skipped.
+// A colour is the arguments after the shape's: r, g, b[, a], one packed 0xRRGGBB number, or none for white.
+func Rasterize(calls []Call, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for _, c := range calls {
+		a := c.Args
+		switch strings.ToLower(c.Name) {
+		case "clearbackground":
+			col := argColor(a)
+			if len(a) == 0 {
+				col = color.RGBA{A: 255}
+			}
+			for i := 0; i < len(img.Pix); i += 4 {
+				img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = col.R, col.G, col.B, col.A
+			}
+		case "drawrectangle", "drawrectfill", "drawrect", "rect":
+			if len(a) >= 4 {
+				x, y := int(num(a[0])), int(num(a[1]))
+				fillRect(img, x, y, x+int(num(a[2])), y+int(num(a[3])), argColor(a[4:]))
+			}
+		case "drawrectanglelines", "drawrectoutline":
+			if len(a) >= 4 {
+				x, y, rw, rh := int(num(a[0])), int(num(a[1])), int(num(a[2])), int(num(a[3]))
+				col := argColor(a[4:])
+				fillRect(img, x, y, x+rw, y+1, col)
+				fillRect(img, x, y+rh-1, x+rw, y+rh, col)
+				fillRect(img, x, y+1, x+1, y+rh-1, col)
+				fillRect(img, x+rw-1, y+1, x+rw, y+rh-1, col)
+			}
+		case "drawcircle", "drawcirclefill", "circle":
+			if len(a) >= 3 {
+				circle(img, num(a[0]), num(a[1]), num(a[2]), false, argColor(a[3:]))
+			}
+		case "drawcirclelines", "drawcircleoutline":
+			if len(a) >= 3 {
+				circle(img, num(a[0]), num(a[1]), num(a[2]), true, argColor(a[3:]))
+			}
+		case "drawline", "drawlinev":
+			if len(a) >= 4 {
+				line(img, int(num(a[0])), int(num(a[1])), int(num(a[2])), int(num(a[3])), argColor(a[4:]))
+			}
+		case "drawpixel":
+			if len(a) >= 2 {
+				blend(img, int(num(a[0])), int(num(a[1])), argColor(a[2:]))
+			}
+		case "drawtriangle":
+			if len(a) >= 6 {
+				triangle(img, num(a[0]), num(a[1]), num(a[2]), num(a[3]), num(a[4]), num(a[5]), argColor(a[6:]))
+			}
+		case "drawtrianglelines":
+			if len(a) >= 6 {
+				col := argColor(a[6:])
+				p := []int{int(num(a[0])), int(num(a[1])), int(num(a[2])), int(num(a[3])), int(num(a[4])), int(num(a[5]))}
+				line(img, p[0], p[1], p[2], p[3], col)
+				line(img, p[2], p[3], p[4], p[5], col)
+				line(img, p[4], p[5], p[0], p[1], col)
+			}
+		}
+	}
+	return img
+}
+
+// argColor reads a colour: r, g, b[, a], one packed 0xRRGGBB number, or white when args is empty.
+func argColor(args []interface{}) color.RGBA {
+	switch {
+	case len(args) >= 4:
+		return color.RGBA{uint8(num(args[0])), uint8(num(args[1])), uint8(num(args[2])), uint8(num(args[3]))}
+	case len(args) == 3:
+		return color.RGBA{uint8(num(args[0])), uint8(num(args[1])), uint8(num(args[2])), 255}
+	case len(args) >= 1:
+		u := uint32(num(args[0]))
+		return color.RGBA{uint8(u >> 16), uint8(u >> 8), uint8(u), 255}
+	}
+	return color.RGBA{255, 255, 255, 255}
+}
+
+// blend draws col over the pixel at (x, y), mixing by its alpha; points outside the image are skipped.
+func blend(img *image.RGBA, x, y int, col color.RGBA) {
+	if !(image.Point{x, y}.In(img.Rect)) || col.A == 0 {
+		return
+	}
+	i := img.PixOffset(x, y)
+	p := img.Pix[i : i+4 : i+4]
+	if col.A == 255 {
+		p[0], p[1], p[2], p[3] = col.R, col.G, col.B, 255
+		return
+	}
+	a, inv := uint32(col.A), 255-uint32(col.A)
+	p[0] = uint8((uint32(col.R)*a + uint32(p[0])*inv) / 255)
+	p[1] = uint8((uint32(col.G)*a + uint32(p[1])*inv) / 255)
+	p[2] = uint8((uint32(col.B)*a + uint32(p[2])*inv) / 255)
+	p[3] = uint8(a + uint32(p[3])*inv/255)
+}
+
+func fillRect(img *image.RGBA, x0, y0, x1, y1 int, col color.RGBA) {
+	r := image.Rect(x0, y0, x1, y1).Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			blend(img, x, y, col)
+		}
+	}
+}
+
+// circle fills the pixels whose centres are within radius r of (cx, cy), or with outline only those within half
+// a pixel of the edge.
+func circle(img *image.RGBA, cx, cy, r float64, outline bool, col color.RGBA) {
+	for y := int(math.Floor(cy - r - 1)); y <= int(math.Ceil(cy+r+1)); y++ {
+		for x := int(math.Floor(cx - r - 1)); x <= int(math.Ceil(cx+r+1)); x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			if d <= r && (!outline || d > r-1) {
+				blend(img, x, y, col)
+			}
+		}
+	}
+}
+
+// line draws from (x0, y0) to (x1, y1) with Bresenham's algorithm.
+func line(img *image.RGBA, x0, y0, x1, y1 int, col color.RGBA) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	for e := dx + dy; ; {
+		blend(img, x0, y0, col)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// triangle fills the pixels whose centres are inside the triangle, in either winding.
+func triangle(img *image.RGBA, x0, y0, x1, y1, x2, y2 float64, col color.RGBA) {
+	edge := func(ax, ay, bx, by, px, py float64) float64 { return (bx-ax)*(py-ay) - (by-ay)*(px-ax) }
+	minX, maxX := math.Min(x0, math.Min(x1, x2)), math.Max(x0, math.Max(x1, x2))
+	minY, maxY := math.Min(y0, math.Min(y1, y2)), math.Max(y0, math.Max(y1, y2))
+	for y := int(math.Floor(minY)); y <= int(math.Ceil(maxY)); y++ {
+		for x := int(math.Floor(minX)); x <= int(math.Ceil(maxX)); x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			e0, e1, e2 := edge(x0, y0, x1, y1, px, py), edge(x1, y1, x2, y2, px, py), edge(x2, y2, x0, y0, px, py)
+			if e0 >= 0 && e1 >= 0 && e2 >= 0 || e0 <= 0 && e1 <= 0 && e2 <= 0 {
+				blend(img, x, y, col)
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func num(a interface{}) float64 {
+	switch x := a.(type) {
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case int:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	}
+	return 0
+}
@@ -5,6 +5,8 @@ import (
 	"cyberbasic/compiler/bindings/inputmap"
 	"cyberbasic/compiler/bindings/raylib"
 	"cyberbasic/compiler/bindings/tween"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/runtime/renderer"
 	"cyberbasic/compiler/runtime/time"
 	"cyberbasic/compiler/vm"
@@ -15,9 +17,13 @@ import (
 const maxFixedCatchupSteps = 8
 
 func beginRuntimeFrame(v *vm.VM) (float64, error) {
-	rl.PollInputEvents()
+	if !headless.Enabled() {
+		rl.PollInputEvents()
+	}
 	inputmap.TickInputMap(v)
-	raylib.CaptureOrbitWheel()
+	if !headless.Enabled() {
+		raylib.CaptureOrbitWheel()
+	}
 	dt, err := v.CallForeign("GetFrameTime", nil)
 	if err != nil {
 		return 0, err
@@ -40,8 +46,8 @@ func beginRuntimeFrame(v *vm.VM) (float64, error) {
 		if _, err = v.CallForeign("StepAllPhysics3D", []interface{}{fixedStepArg}); err != nil {
 			return 0, err
 		}
-		if label := FixedUpdateLabel(); label != "" {
-			if err = v.InvokeSub(label, []interface{}{fixedStepArg}); err != nil {
+		if label := FixedUpdateLabel(); label != nil {
+			if _, err = v.Invoke(label, []interface{}{fixedStepArg}); err != nil {
 				return 0, err
 			}
 		}
@@ -52,6 +58,9 @@ func beginRuntimeFrame(v *vm.VM) (float64, error) {
 		time.ClampAccumulator(fixedStep)
 	}
 	tween.Tick(float64(dtVal))
+	if err = v.ProcessEvents(); err != nil {
+		return 0, err
+	}
 	return float64(dtVal), nil
 }
 
@@ -62,9 +71,7 @@ func StepFrame(v *vm.VM) error {
 	if v.Chunk() == nil {
 		return nil
 	}
-	chunk := v.Chunk()
-	_, hasUpdate := chunk.GetFunction("update")
-	_, hasDraw := chunk.GetFunction("draw")
+	hasUpdate, hasDraw := v.HasFunction("update"), v.HasFunction("draw")
 
 	dt, err := beginRuntimeFrame(v)
 	if err != nil {
@@ -95,9 +102,7 @@ func StepImplicitFrame(v *vm.VM) error {
 	if v.Chunk() == nil {
 		return nil
 	}
-	chunk := v.Chunk()
-	_, hasUpdate := chunk.GetFunction("onupdate")
-	_, hasDraw := chunk.GetFunction("ondraw")
+	hasUpdate, hasDraw := v.HasFunction("onupdate"), v.HasFunction("ondraw")
 
 	dt, err := beginRuntimeFrame(v)
 	if err != nil {
@@ -126,14 +131,16 @@ func StepImplicitFrame(v *vm.VM) error {
 	}
 
 	// Legacy path: direct BeginDrawing/EndDrawing (beginRuntimeFrame already polled; do not poll again or IsKeyPressed gets cleared)
-	rl.BeginDrawing()
-	rl.ClearBackground(rl.NewColor(0, 0, 0, 255))
+	if !headless.Enabled() {
+		rl.BeginDrawing()
+		rl.ClearBackground(rl.NewColor(0, 0, 0, 255))
+	}
 	if hasDraw {
 		if err = v.InvokeSub("OnDraw", nil); err != nil {
-			rl.EndDrawing()
+			frame.End()
 			return err
 		}
 	}
-	rl.EndDrawing()
+	frame.End()
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"sync"
 
 	"cyberbasic/compiler/bindings/effect"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
@@ -94,9 +96,17 @@ func (r *Renderer) SetClearColor(c rl.Color) {
 	r.clearColor = c
 }
 
-// Frame runs one full frame: BeginDrawing → 3D pass → 2D pass → GUI pass → EndDrawing.
+// Frame runs one full frame: BeginDrawing → 3D pass → 2D pass → GUI pass → EndDrawing. When headless it only
+// records the queued commands and ends the frame.
 // Timing is driven only by beginRuntimeFrame or BeginDrawing; do not call time.Update here or physics desyncs.
 func (r *Renderer) Frame() {
+	if headless.Enabled() {
+		if v := VM(); v != nil {
+			headless.RecordQueues(v)
+		}
+		frame.End()
+		return
+	}
 	// PollInputEvents is called once at frame start (beginRuntimeFrame); do not poll here or IsKeyPressed/IsMouseButtonPressed get cleared
 	rl.BeginDrawing()
 	rl.ClearBackground(r.clearColor)
@@ -113,7 +123,7 @@ func (r *Renderer) Frame() {
 	// GUI pass: queued GUI items
 	r.drawUI()
 
-	rl.EndDrawing()
+	frame.End()
 }
 
 // FrameIfUnified runs Frame() if unified mode is enabled. Returns true if frame was run.
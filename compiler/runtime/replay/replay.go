@@ -0,0 +1,284 @@
+// Package replay records a session's input to a file and plays it back (--record, --replay). A replay file is
+// JSON lines: a header with the random seed, then one Frame per frame with the frame time, held keys, mouse,
+// wheel and gamepads.
+//
+// Both modes make the clock and input scripted (headless.Script), so the program reads its input and frame time
+// from headless in the recorded run and in the replay alike. While recording, the input of the next frame is
+// sampled from the devices at every frame boundary (or taken from the scripted input when headless), written to
+// the file and applied; while replaying it is read from the file and applied. The first frame has no input and
+// the default frame time. RND and the other random commands draw from package rng, which both modes seed with
+// the recorded seed, so a program that reads input through the input commands runs the same frames again, with
+// a window or headless.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
+)
+
+const (
+	format  = "cyberbasic-replay"
+	version = 1
+)
+
+// Header is the first line of a replay file.
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	Seed    int64  `json:"seed"`
+}
+
+// Frame is the input of one frame: what the program sees from the start of the frame.
+type Frame struct {
+	DT      float32 `json:"dt"`
+	Keys    []int   `json:"keys,omitempty"` // held keys (raylib key codes)
+	MouseX  float64 `json:"mx,omitempty"`
+	MouseY  float64 `json:"my,omitempty"`
+	Buttons []int   `json:"buttons,omitempty"` // held mouse buttons
+	Wheel   float64 `json:"wheel,omitempty"`
+	Pads    []Pad   `json:"pads,omitempty"` // connected gamepads
+}
+
+// Pad is the state of a connected gamepad.
+type Pad struct {
+	ID      int       `json:"id"`
+	Buttons []int     `json:"buttons,omitempty"` // held buttons
+	Axes    []float64 `json:"axes,omitempty"`
+}
+
+var (
+	mu       sync.Mutex
+	hooked   bool
+	out      *os.File
+	enc      *json.Encoder
+	writeErr error
+	frames   []Frame      // frames still to replay
+	devices  func() Frame // samples the window's devices (SetDevices)
+)
+
+// SetDevices sets how a recording with a window reads the devices and frame time. The raylib bindings pass a
+// function reading them through raylib, so this package builds without cgo; until then a recording with a
+// window samples the scripted input.
+func SetDevices(sample func() Frame) {
+	mu.Lock()
+	devices = sample
+	mu.Unlock()
+}
+
+// Record starts recording to path, seeding rng with the seed it writes to the header. It must be called before
+// the bindings are registered.
+func Record(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	seed := rng.Current()
+	rng.Seed(seed)
+	e := json.NewEncoder(f)
+	if err := e.Encode(Header{Format: format, Version: version, Seed: seed}); err != nil {
+		f.Close()
+		return err
+	}
+	mu.Lock()
+	out, enc, writeErr = f, e, nil
+	mu.Unlock()
+	start()
+	return nil
+}
+
+// Replay loads the replay file at path and plays it back, seeding rng with its seed. It returns the number of
+// recorded frames; the recorded run ended after that many. It must be called before the bindings are registered.
+func Replay(path string) (int, error) {
+	h, fs, err := Load(path)
+	if err != nil {
+		return 0, err
+	}
+	rng.Seed(h.Seed)
+	mu.Lock()
+	frames = fs
+	mu.Unlock()
+	start()
+	return len(fs), nil
+}
+
+// Load reads a replay file.
+func Load(path string) (Header, []Frame, error) {
+	var h Header
+	f, err := os.Open(path)
+	if err != nil {
+		return h, nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return h, nil, err
+		}
+		return h, nil, fmt.Errorf("%s: empty replay file", path)
+	}
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Format != format {
+		return h, nil, fmt.Errorf("%s: not a replay file", path)
+	}
+	if h.Version != version {
+		return h, nil, fmt.Errorf("%s: replay version %d, want %d", path, h.Version, version)
+	}
+	var fs []Frame
+	for line := 2; sc.Scan(); line++ {
+		var fr Frame
+		if err := json.Unmarshal(sc.Bytes(), &fr); err != nil {
+			return h, nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		fs = append(fs, fr)
+	}
+	return h, fs, sc.Err()
+}
+
+// Close stops recording or replaying and closes the record file, returning the first write error.
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	err := writeErr
+	if out != nil {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}
+	out, enc, writeErr, frames = nil, nil, nil, nil
+	return err
+}
+
+// start makes the clock and input scripted and hooks the frame boundary (headless.EndFrame) once.
+func start() {
+	headless.Script()
+	mu.Lock()
+	defer mu.Unlock()
+	if !hooked {
+		hooked = true
+		headless.OnEndFrame(step)
+	}
+}
+
+// step runs at every frame boundary: it records the next frame's input, or applies the next recorded one.
+func step() {
+	mu.Lock()
+	recording := enc != nil
+	var next *Frame
+	if !recording && len(frames) > 0 {
+		next, frames = &frames[0], frames[1:]
+	}
+	mu.Unlock()
+	switch {
+	case recording:
+		fr := sample()
+		if !headless.Enabled() {
+			Apply(fr)
+		}
+		mu.Lock()
+		if enc != nil && writeErr == nil {
+			writeErr = enc.Encode(fr)
+		}
+		mu.Unlock()
+	case next != nil:
+		Apply(*next)
+	}
+}
+
+// Apply makes fr the scripted input: keys and buttons not in fr are released, the others pressed.
+func Apply(fr Frame) {
+	headless.SetFrameTime(float64(fr.DT))
+	held := map[int]bool{}
+	for _, k := range fr.Keys {
+		held[k] = true
+	}
+	for _, k := range headless.KeysDown() {
+		if !held[k] {
+			headless.SetKey(k, false)
+		}
+	}
+	for _, k := range fr.Keys {
+		headless.SetKey(k, true)
+	}
+	headless.SetMouse(fr.MouseX, fr.MouseY)
+	for b := 0; b < 3; b++ {
+		headless.SetMouseButton(b, contains(fr.Buttons, b))
+	}
+	headless.SetWheel(fr.Wheel)
+	for id := 0; id < headless.MaxGamepads; id++ {
+		var pad *Pad
+		for i := range fr.Pads {
+			if fr.Pads[i].ID == id {
+				pad = &fr.Pads[i]
+			}
+		}
+		if pad == nil {
+			headless.SetGamepad(id, false)
+			continue
+		}
+		headless.SetGamepad(id, true)
+		for b := 0; b < headless.GamepadButtons; b++ {
+			headless.SetGamepadButton(id, b, contains(pad.Buttons, b))
+		}
+		for a := 0; a < headless.GamepadAxes; a++ {
+			v := 0.0
+			if a < len(pad.Axes) {
+				v = pad.Axes[a]
+			}
+			headless.SetGamepadAxis(id, a, v)
+		}
+	}
+}
+
+// sample returns the input for the next frame: the devices' state and frame time with a window (SetDevices),
+// the scripted input when headless.
+func sample() Frame {
+	mu.Lock()
+	fn := devices
+	mu.Unlock()
+	if headless.Enabled() || fn == nil {
+		return scripted()
+	}
+	return fn()
+}
+
+// scripted returns the scripted input as a Frame (recording headless, where the program scripts its own input).
+func scripted() Frame {
+	fr := Frame{DT: headless.FrameTime(), Keys: headless.KeysDown(), Wheel: headless.Wheel()}
+	fr.MouseX, fr.MouseY = headless.Mouse()
+	for b := 0; b < 3; b++ {
+		if headless.MouseButtonDown(b) {
+			fr.Buttons = append(fr.Buttons, b)
+		}
+	}
+	for id := 0; id < headless.MaxGamepads; id++ {
+		if !headless.GamepadAvailable(id) {
+			continue
+		}
+		pad := Pad{ID: id}
+		for b := 0; b < headless.GamepadButtons; b++ {
+			if headless.GamepadButtonDown(id, b) {
+				pad.Buttons = append(pad.Buttons, b)
+			}
+		}
+		for a := 0; a < headless.GamepadAxes; a++ {
+			pad.Axes = append(pad.Axes, headless.GamepadAxis(id, a))
+		}
+		fr.Pads = append(fr.Pads, pad)
+	}
+	return fr
+}
+
+func contains(xs []int, x int) bool {
+	for _, y := range xs {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
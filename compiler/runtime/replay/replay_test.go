@@ -0,0 +1,67 @@
+package replay
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime/headless"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	headless.Enable()
+	defer headless.Reset()
+	path := filepath.Join(t.TempDir(), "session.replay")
+	if err := Record(path); err != nil {
+		t.Fatal(err)
+	}
+	seed, first := rng.Current(), rng.Float64()
+	headless.SetFrameTime(0.25)
+	headless.SetKey(32, true)
+	headless.SetGamepadAxis(1, 0, -0.5)
+	headless.EndFrame()
+	headless.SetKey(32, false)
+	headless.SetMouse(3, 4)
+	headless.SetMouseButton(0, true)
+	headless.EndFrame()
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, fs, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Seed != seed || len(fs) != 2 {
+		t.Fatalf("header %+v with %d frames, want seed %d and 2 frames", h, len(fs), seed)
+	}
+	if got := fmt.Sprintf("%v %v %+v", fs[0].DT, fs[0].Keys, fs[0].Pads); got != "0.25 [32] [{ID:1 Buttons:[] Axes:[-0.5 0 0 0 0 0]}]" {
+		t.Errorf("frame 1 = %s", got)
+	}
+	if fs[1].Keys != nil || fs[1].MouseX != 3 || fs[1].MouseY != 4 || fmt.Sprint(fs[1].Buttons) != "[0]" {
+		t.Errorf("frame 2 = %+v", fs[1])
+	}
+
+	headless.Reset()
+	headless.Enable()
+	n, err := Replay(path)
+	if err != nil || n != 2 {
+		t.Fatalf("Replay = %d, %v", n, err)
+	}
+	defer Close()
+	if rng.Float64() != first {
+		t.Error("replay did not restore the random seed")
+	}
+	headless.EndFrame()
+	if !headless.KeyPressed(32) || headless.FrameTime() != 0.25 || headless.GamepadAxis(1, 0) != -0.5 {
+		t.Error("frame 1 input not replayed")
+	}
+	headless.EndFrame()
+	if !headless.KeyReleased(32) || !headless.MouseButtonPressed(0) {
+		t.Error("frame 2 input not replayed")
+	}
+	if x, y := headless.Mouse(); x != 3 || y != 4 {
+		t.Errorf("mouse = %v,%v, want 3,4", x, y)
+	}
+}
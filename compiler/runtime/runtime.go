@@ -2,6 +2,8 @@ package runtime
 
 import (
 	"cyberbasic/compiler/bindings/windowdot"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/vm"
 	"fmt"
 	"strconv"
@@ -21,6 +23,7 @@ type Runtime struct {
 	graphics *GraphicsEngine
 	textures map[string]rl.Texture2D // filename -> texture for LOADIMAGE/CREATESPRITE/DRAWSPRITE
 	models3D map[string]rl.Model     // filename -> model for LOADMODEL/DRAWMODEL
+	focused  [2]bool                 // window focus last seen by PollEvent for "focus" and "blur"
 }
 
 // Sprite represents a 2D sprite
@@ -118,6 +121,11 @@ func NewRuntime() *Runtime {
 
 // LoadImage loads an image file as a texture (for use with CreateSprite/DrawSprite)
 func (r *Runtime) LoadImage(filename string) error {
+	if headless.Enabled() {
+		headless.Record("LoadImage", []interface{}{filename})
+		r.textures[filename] = rl.Texture2D{}
+		return nil
+	}
 	tex := rl.LoadTexture(filename)
 	r.textures[filename] = tex
 	return nil
@@ -163,6 +171,10 @@ func (r *Runtime) DrawSprite(id string) error {
 	if !sprite.Visible {
 		return nil
 	}
+	if headless.Enabled() {
+		headless.Record("DrawSprite", []interface{}{id, sprite.X, sprite.Y})
+		return nil
+	}
 	tex, ok := r.textures[sprite.Image]
 	if ok && tex.ID != 0 {
 		rl.DrawTexture(tex, int32(sprite.X), int32(sprite.Y), rl.White)
@@ -174,8 +186,11 @@ func (r *Runtime) DrawSprite(id string) error {
 
 // LoadModel loads a 3D model file and registers it by filename for later DrawModel calls
 func (r *Runtime) LoadModel(filename string) error {
-	model := rl.LoadModel(filename)
-	r.models3D[filename] = model
+	if headless.Enabled() {
+		headless.Record("LoadModel", []interface{}{filename})
+	} else {
+		r.models3D[filename] = rl.LoadModel(filename)
+	}
 	r.models[filename] = &Model{
 		ID:      filename,
 		Mesh:    filename,
@@ -359,7 +374,9 @@ func (r *Runtime) Render() error {
 
 	// Draw 3D models (requires BeginMode3D/EndMode3D - caller must ensure)
 	for _, model := range r.models {
-		if model.Visible {
+		if model.Visible && headless.Enabled() {
+			headless.Record("DrawModel", []interface{}{model.ID, model.X, model.Y, model.Z, model.Scale[0]})
+		} else if model.Visible {
 			rlModel, has3D := r.models3D[model.Mesh]
 			if has3D && rlModel.MeshCount > 0 {
 				pos := rl.Vector3{X: float32(model.X), Y: float32(model.Y), Z: float32(model.Z)}
@@ -380,6 +397,10 @@ func (r *Runtime) InitializeGraphics(width, height int, title string) error {
 	r.graphics.screenWidth = width
 	r.graphics.screenHeight = height
 	r.graphics.title = title
+	if headless.Enabled() {
+		headless.SetScreenSize(width, height)
+		return nil
+	}
 	if !r.graphics.windowOpen {
 		rl.InitWindow(int32(width), int32(height), title)
 		rl.SetTargetFPS(int32(r.graphics.fps))
@@ -391,10 +412,13 @@ func (r *Runtime) InitializeGraphics(width, height int, title string) error {
 	return nil
 }
 
-// ShouldClose returns true when the user requested to close the window.
+// ShouldClose returns true when the user requested to close the window or the --frames limit was reached.
 // Uses rl.IsWindowReady() so it works when window was opened via InitWindow or runtime.OpenWindow.
 func (r *Runtime) ShouldClose() bool {
-	if !rl.IsWindowReady() {
+	if frame.Done() {
+		return true
+	}
+	if headless.Enabled() || !rl.IsWindowReady() {
 		return false
 	}
 	return rl.WindowShouldClose()
@@ -439,35 +463,171 @@ var keyNameMap = map[string]int32{
 
 // IsKeyDown returns true if the key (e.g. "ESCAPE", "W") is currently held (for On KeyDown handlers)
 func (r *Runtime) IsKeyDown(keyName string) bool {
-	if !r.graphics.windowOpen {
+	if !r.graphics.windowOpen && !headless.Enabled() {
 		return false
 	}
 	k, ok := keyNameToRaylib(keyName)
 	if !ok {
 		return false
 	}
+	if headless.Scripted() {
+		return headless.KeyDown(int(k))
+	}
 	return rl.IsKeyDown(k)
 }
 
 // IsKeyPressed returns true if the key was pressed this frame (for On KeyPressed handlers)
 func (r *Runtime) IsKeyPressed(keyName string) bool {
-	if !r.graphics.windowOpen {
+	if !r.graphics.windowOpen && !headless.Enabled() {
 		return false
 	}
 	k, ok := keyNameToRaylib(keyName)
 	if !ok {
 		return false
 	}
+	if headless.Scripted() {
+		return headless.KeyPressed(int(k))
+	}
 	return rl.IsKeyPressed(k)
 }
 
+var mouseButtonMap = map[string]rl.MouseButton{
+	"LEFT": rl.MouseButtonLeft, "RIGHT": rl.MouseButtonRight, "MIDDLE": rl.MouseButtonMiddle,
+}
+
+var gamepadButtonMap = map[string]int32{
+	"A": rl.GamepadButtonRightFaceDown, "B": rl.GamepadButtonRightFaceRight,
+	"X": rl.GamepadButtonRightFaceLeft, "Y": rl.GamepadButtonRightFaceUp,
+	"UP": rl.GamepadButtonLeftFaceUp, "DOWN": rl.GamepadButtonLeftFaceDown,
+	"LEFT": rl.GamepadButtonLeftFaceLeft, "RIGHT": rl.GamepadButtonLeftFaceRight,
+	"LB": rl.GamepadButtonLeftTrigger1, "RB": rl.GamepadButtonRightTrigger1,
+	"LT": rl.GamepadButtonLeftTrigger2, "RT": rl.GamepadButtonRightTrigger2,
+	"BACK": rl.GamepadButtonMiddleLeft, "START": rl.GamepadButtonMiddleRight,
+	"L3": rl.GamepadButtonLeftThumb, "R3": rl.GamepadButtonRightThumb,
+}
+
+// PollEvent reports whether an input or window ON event fired this frame (vm.EventPoller). Mouse events take
+// LEFT, RIGHT or MIDDLE (empty = any) and pass the mouse position; gamepad events take a button name (A, B, X,
+// Y, UP, DOWN, LEFT, RIGHT, LB, RB, LT, RT, BACK, START, L3, R3) on gamepad 0; resize passes the new size.
+func (r *Runtime) PollEvent(eventType, key string) (bool, []vm.Value) {
+	if headless.Scripted() {
+		if fired, args, ok := pollScriptedEvent(eventType, key); ok || headless.Enabled() {
+			return fired, args
+		}
+	}
+	if !r.graphics.windowOpen && !rl.IsWindowReady() {
+		return false, nil
+	}
+	key = strings.ToUpper(strings.TrimSpace(key))
+	switch eventType {
+	case "keyreleased":
+		k, ok := keyNameToRaylib(key)
+		return ok && rl.IsKeyReleased(k), nil
+	case "mousedown", "mousepressed", "mousereleased":
+		test := rl.IsMouseButtonDown
+		if eventType == "mousepressed" {
+			test = rl.IsMouseButtonPressed
+		} else if eventType == "mousereleased" {
+			test = rl.IsMouseButtonReleased
+		}
+		fired := false
+		if b, ok := mouseButtonMap[key]; ok {
+			fired = test(b)
+		} else if key == "" {
+			for _, b := range mouseButtonMap {
+				fired = fired || test(b)
+			}
+		}
+		if !fired {
+			return false, nil
+		}
+		return true, []vm.Value{float64(rl.GetMouseX()), float64(rl.GetMouseY())}
+	case "gamepaddown", "gamepadpressed", "gamepadreleased":
+		b, ok := gamepadButtonMap[key]
+		if !ok || !rl.IsGamepadAvailable(0) {
+			return false, nil
+		}
+		switch eventType {
+		case "gamepadpressed":
+			return rl.IsGamepadButtonPressed(0, b), nil
+		case "gamepadreleased":
+			return rl.IsGamepadButtonReleased(0, b), nil
+		}
+		return rl.IsGamepadButtonDown(0, b), nil
+	case "resize":
+		if !rl.IsWindowResized() {
+			return false, nil
+		}
+		return true, []vm.Value{float64(rl.GetScreenWidth()), float64(rl.GetScreenHeight())}
+	case "focus", "blur":
+		i := 0
+		if eventType == "blur" {
+			i = 1
+		}
+		now := rl.IsWindowFocused()
+		was := r.focused[i]
+		r.focused[i] = now
+		if eventType == "focus" {
+			return now && !was, nil
+		}
+		return was && !now, nil
+	}
+	return false, nil
+}
+
+// pollScriptedEvent is PollEvent for the key, mouse and gamepad events when the input is scripted (--headless,
+// --record, --replay); ok is false for other events. Headless, the window never resizes or changes focus.
+func pollScriptedEvent(eventType, key string) (fired bool, args []vm.Value, ok bool) {
+	key = strings.ToUpper(strings.TrimSpace(key))
+	switch eventType {
+	case "keyreleased":
+		k, known := keyNameToRaylib(key)
+		return known && headless.KeyReleased(int(k)), nil, true
+	case "mousedown", "mousepressed", "mousereleased":
+		test := headless.MouseButtonDown
+		if eventType == "mousepressed" {
+			test = headless.MouseButtonPressed
+		} else if eventType == "mousereleased" {
+			test = headless.MouseButtonReleased
+		}
+		fired := false
+		if b, known := mouseButtonMap[key]; known {
+			fired = test(int(b))
+		} else if key == "" {
+			for _, b := range mouseButtonMap {
+				fired = fired || test(int(b))
+			}
+		}
+		if !fired {
+			return false, nil, true
+		}
+		x, y := headless.Mouse()
+		return true, []vm.Value{x, y}, true
+	case "gamepaddown", "gamepadpressed", "gamepadreleased":
+		b, known := gamepadButtonMap[key]
+		if !known || !headless.GamepadAvailable(0) {
+			return false, nil, true
+		}
+		switch eventType {
+		case "gamepadpressed":
+			return headless.GamepadButtonPressed(0, int(b)), nil, true
+		case "gamepadreleased":
+			return headless.GamepadButtonReleased(0, int(b)), nil, true
+		}
+		return headless.GamepadButtonDown(0, int(b)), nil, true
+	}
+	return false, nil, false
+}
+
 // Sync runs one frame. Delegates to SyncFrame so SYNC (statement) and Sync() (foreign) behave identically.
 // Uses rl.IsWindowReady() so SYNC works when window was opened via InitWindow (raylib) or runtime.OpenWindow.
 func (r *Runtime) Sync() error {
-	if !rl.IsWindowReady() {
+	if !rl.IsWindowReady() && !headless.Enabled() {
 		return nil
 	}
-	SyncFrame()
+	if SyncFrame() {
+		return r.vm.ProcessEvents()
+	}
 	return nil
 }
 
@@ -537,13 +697,7 @@ func (r *Runtime) MainLoop() error {
 
 // HasImplicitHandlers returns true if the loaded chunk has OnUpdate or OnDraw subs (DBP-style implicit loop).
 func (r *Runtime) HasImplicitHandlers() bool {
-	chunk := r.vm.Chunk()
-	if chunk == nil {
-		return false
-	}
-	_, hasUpdate := chunk.GetFunction("onupdate")
-	_, hasDraw := chunk.GetFunction("ondraw")
-	return hasUpdate || hasDraw
+	return r.vm.HasFunction("onupdate") || r.vm.HasFunction("ondraw")
 }
 
 // RunImplicitLoop runs the DBP-style implicit loop: InitWindow, OnStart once, then loop with OnUpdate/OnDraw.
@@ -569,10 +723,14 @@ func (r *Runtime) RunImplicitLoop() error {
 			tfps = wd.TargetFPS()
 		}
 	}
-	rl.SetTargetFPS(tfps)
+	if headless.Enabled() {
+		headless.SetFrameTime(1 / float64(tfps))
+	} else {
+		rl.SetTargetFPS(tfps)
+	}
 
 	// OnStart once
-	if _, ok := chunk.GetFunction("onstart"); ok {
+	if r.vm.HasFunction("onstart") {
 		if err := r.vm.InvokeSub("OnStart", nil); err != nil {
 			return err
 		}
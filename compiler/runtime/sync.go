@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"cyberbasic/compiler/bindings/raylib"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
 	"cyberbasic/compiler/runtime/renderer"
 	"cyberbasic/compiler/vm"
 
@@ -25,11 +27,12 @@ func RegisterFlushOverride(v *vm.VM) {
 // SyncFrame is called by the SYNC/Sync foreign command.
 // SYNC = the update: poll input (for next frame), end frame, present.
 // When UseUnifiedRenderer is enabled, runs the full unified frame.
-// Otherwise: PollInputEvents, CaptureOrbitWheel, rl.EndDrawing.
+// Otherwise: PollInputEvents, CaptureOrbitWheel, rl.EndDrawing (only frame.End when headless).
 // The WHILE loop defines the frame; SYNC is the update step at the end.
+// Returns true when it polled input, i.e. when SYNC is the frame boundary and ON handlers should run next.
 var syncDebugCount uint64
 
-func SyncFrame() {
+func SyncFrame() bool {
 	if raylib.DebugRender() {
 		syncDebugCount++
 		if syncDebugCount%60 == 1 {
@@ -37,9 +40,12 @@ func SyncFrame() {
 		}
 	}
 	if renderer.FrameIfUnified() {
-		return
+		return false
 	}
-	rl.PollInputEvents()
-	raylib.CaptureOrbitWheel()
-	rl.EndDrawing()
+	if !headless.Enabled() {
+		rl.PollInputEvents()
+		raylib.CaptureOrbitWheel()
+	}
+	frame.End()
+	return true
 }
@@ -4,7 +4,7 @@ package time
 import (
 	"sync"
 
-	rl "github.com/gen2brain/raylib-go/raylib"
+	"cyberbasic/compiler/runtime/headless"
 )
 
 var (
@@ -121,7 +121,7 @@ func GetFrameCounter() uint64 {
 	return FrameCounter
 }
 
-// GetRawDeltaTime returns raw frame time from raylib (unscaled).
+// GetRawDeltaTime returns raw frame time from raylib (unscaled), or the fixed frame time when headless.
 func GetRawDeltaTime() float32 {
-	return rl.GetFrameTime()
+	return headless.FrameTime()
 }
@@ -33,3 +33,27 @@ func DetectWindowMode(source string) WindowMode {
 	}
 	return ModeConsole
 }
+
+// String returns the mode name stored in precompiled .cbc metadata ("explicit", "implicit", "console").
+func (m WindowMode) String() string {
+	switch m {
+	case ModeExplicit:
+		return "explicit"
+	case ModeImplicit:
+		return "implicit"
+	default:
+		return "console"
+	}
+}
+
+// ParseWindowMode is the inverse of WindowMode.String; unknown names map to ModeConsole.
+func ParseWindowMode(s string) WindowMode {
+	switch s {
+	case "explicit":
+		return ModeExplicit
+	case "implicit":
+		return ModeImplicit
+	default:
+		return ModeConsole
+	}
+}
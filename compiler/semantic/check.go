@@ -0,0 +1,588 @@
+package semantic
+
+import (
+	cberrors "cyberbasic/compiler/errors"
+	"cyberbasic/compiler/parser"
+	"fmt"
+	"strings"
+)
+
+// funcSig is the checked signature of a user FUNCTION or SUB.
+type funcSig struct {
+	name   string
+	params int
+	ret    Type
+}
+
+// varInfo is a DIM'd variable: its declared type and whether it is an array.
+type varInfo struct {
+	typ   Type
+	array bool
+}
+
+// builtinReturns gives the result type of builtins whose type is fixed; other foreign calls are dynamic.
+var builtinReturns = map[string]Type{
+	"str": typeString, "str$": typeString, "chr": typeString, "chr$": typeString,
+	"left": typeString, "right": typeString, "mid": typeString, "upper": typeString, "lower": typeString,
+	"trim": typeString, "ltrim": typeString, "rtrim": typeString,
+	"len": typeInteger, "asc": typeInteger, "int": typeInteger, "instr": typeInteger,
+}
+
+// checker is the static type checking pass. Only declared types (DIM ... AS, TYPE fields, FUNCTION ... AS)
+// are enforced; undeclared variables and foreign calls are dynamic and never reported.
+type checker struct {
+	typeDefs map[string]*parser.TypeDecl
+	funcs    map[string]funcSig
+	globals  map[string]varInfo
+	locals   map[string]varInfo // nil at top level
+	inFunc   *funcSig
+	line     int // line of the innermost located node, for nodes without a position
+	errs     []error
+}
+
+// checkTypes runs the checker over main statements and declarations and returns every error found.
+func checkTypes(typeDefs map[string]*parser.TypeDecl, mainStmts, decls []parser.Node) []error {
+	c := &checker{
+		typeDefs: typeDefs,
+		funcs:    make(map[string]funcSig),
+		globals:  make(map[string]varInfo),
+	}
+	for _, d := range decls {
+		switch n := d.(type) {
+		case *parser.FunctionDecl:
+			c.funcs[QualifiedName(n)] = funcSig{name: n.Name, params: len(n.Parameters), ret: c.typeFromName(n.ReturnType)}
+		case *parser.SubDecl:
+			c.funcs[QualifiedName(n)] = funcSig{name: n.Name, params: len(n.Parameters)}
+		}
+	}
+	c.stmts(mainStmts)
+	for _, d := range decls {
+		switch n := d.(type) {
+		case *parser.FunctionDecl:
+			sig := c.funcs[QualifiedName(n)]
+			c.enterFunc(&sig, n.Parameters, n.Line)
+			c.receiver(n.TypeName)
+			c.block(n.Body)
+		case *parser.SubDecl:
+			sig := c.funcs[QualifiedName(n)]
+			c.enterFunc(&sig, n.Parameters, n.Line)
+			c.receiver(n.TypeName)
+			c.block(n.Body)
+		default:
+			c.locals, c.inFunc = nil, nil
+			c.stmt(d)
+		}
+	}
+	return c.errs
+}
+
+func (c *checker) enterFunc(sig *funcSig, params []string, line int) {
+	c.inFunc = sig
+	c.locals = make(map[string]varInfo, len(params))
+	for _, p := range params {
+		c.locals[strings.ToLower(p)] = varInfo{typ: typeUnknown}
+	}
+	c.line = line
+}
+
+// receiver types ME and its alias SELF inside a method of typeName (no-op outside methods).
+func (c *checker) receiver(typeName string) {
+	if typeName == "" {
+		return
+	}
+	me := varInfo{typ: c.typeFromName(typeName)}
+	c.locals["me"] = me
+	c.locals["self"] = me
+}
+
+func (c *checker) errorf(n parser.Node, format string, args ...interface{}) {
+	line, col := c.line, 0
+	if loc, ok := n.(parser.HasSourceLoc); ok && loc.GetLine() > 0 {
+		line, col = loc.GetLine(), loc.GetCol()
+	}
+	msg := fmt.Sprintf(format, args...)
+	switch {
+	case line > 0 && col > 0:
+		c.errs = append(c.errs, fmt.Errorf("line %d, col %d: %s", line, col, msg))
+	case line > 0:
+		c.errs = append(c.errs, fmt.Errorf("line %d: %s", line, msg))
+	default:
+		c.errs = append(c.errs, fmt.Errorf("%s", msg))
+	}
+}
+
+// typeFromName resolves an AS type name. Names that are neither builtin nor a TYPE are dynamic
+// (engine handles such as Vector3 or Color).
+func (c *checker) typeFromName(name string) Type {
+	if name == "" {
+		return typeUnknown
+	}
+	key := strings.ToLower(name)
+	if t, ok := builtinTypes[key]; ok {
+		return t
+	}
+	if td, ok := c.typeDefs[key]; ok {
+		return Type{Kind: KindUDT, UDT: td}
+	}
+	return typeUnknown
+}
+
+func (c *checker) lookupVar(name string) (varInfo, bool) {
+	key := strings.ToLower(name)
+	if c.locals != nil {
+		if v, ok := c.locals[key]; ok {
+			return v, true
+		}
+	}
+	v, ok := c.globals[key]
+	return v, ok
+}
+
+func (c *checker) declare(name string, v varInfo) {
+	key := strings.ToLower(name)
+	if c.locals != nil {
+		c.locals[key] = v
+		return
+	}
+	c.globals[key] = v
+}
+
+func (c *checker) block(b *parser.Block) {
+	if b != nil {
+		c.stmts(b.Statements)
+	}
+}
+
+func (c *checker) stmts(list []parser.Node) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *checker) stmt(node parser.Node) {
+	if loc, ok := node.(parser.HasSourceLoc); ok && loc.GetLine() > 0 {
+		c.line = loc.GetLine()
+	}
+	switch n := node.(type) {
+	case *parser.Statement:
+		c.stmt(n.Value)
+	case *parser.DimStatement:
+		for i := range n.Variables {
+			vd := &n.Variables[i]
+			for _, d := range vd.Dimensions {
+				c.infer(d)
+			}
+			c.declare(vd.Name, varInfo{typ: c.typeFromName(vd.Type), array: len(vd.Dimensions) > 0})
+		}
+	case *parser.Assignment:
+		c.assignment(n)
+	case *parser.CompoundAssign:
+		vt := c.infer(n.Value)
+		if v, ok := c.lookupVar(n.Variable); ok && !v.array {
+			if v.typ.Kind == KindString {
+				if n.Op != "+=" {
+					c.errorf(n, "type mismatch: operator %s is not valid on %s (STRING)", n.Op, n.Variable)
+				}
+			} else if !assignable(v.typ, vt) {
+				c.errorf(n, "type mismatch: cannot apply %s %s to %s (%s)", n.Op, vt, n.Variable, v.typ)
+			}
+		}
+	case *parser.Call:
+		c.infer(n)
+	case *parser.IfStatement:
+		c.infer(n.Condition)
+		c.block(n.ThenBlock)
+		for _, ei := range n.ElseIfs {
+			c.infer(ei.Condition)
+			c.block(ei.Block)
+		}
+		c.block(n.ElseBlock)
+	case *parser.ForStatement:
+		c.infer(n.Start)
+		c.infer(n.End)
+		if n.Step != nil {
+			c.infer(n.Step)
+		}
+		if v, ok := c.lookupVar(n.Variable); ok && v.typ.Kind == KindString {
+			c.errorf(n, "type mismatch: FOR variable %s is STRING", n.Variable)
+		}
+		c.block(n.Body)
+	case *parser.ForEachStatement:
+		c.infer(n.Collection)
+		c.block(n.Body)
+	case *parser.WhileStatement:
+		c.infer(n.Condition)
+		c.block(n.Body)
+	case *parser.RepeatStatement:
+		c.block(n.Body)
+		c.infer(n.Condition)
+	case *parser.MainLoopStatement:
+		c.block(n.Body)
+	case *parser.OnEventStatement:
+		saved := c.locals
+		if len(n.Params) > 0 {
+			c.locals = make(map[string]varInfo, len(n.Params))
+			for _, p := range n.Params {
+				c.locals[strings.ToLower(p)] = varInfo{typ: typeUnknown}
+			}
+		}
+		c.block(n.Body)
+		c.locals = saved
+	case *parser.SelectCaseStatement:
+		c.infer(n.Expr)
+		for _, cc := range n.Cases {
+			if cc.Value != nil {
+				c.infer(cc.Value)
+			}
+			c.block(cc.Block)
+		}
+		c.block(n.ElseBlock)
+	case *parser.AssertStatement:
+		c.infer(n.Condition)
+		if n.Message != nil {
+			c.infer(n.Message)
+		}
+	case *parser.ReturnStatement:
+		if n.Value == nil {
+			return
+		}
+		vt := c.infer(n.Value)
+		if c.inFunc != nil && !assignable(c.inFunc.ret, vt) {
+			c.errorf(n, "type mismatch: %s returns %s, got %s", c.inFunc.name, c.inFunc.ret, vt)
+		}
+	case *parser.WaitSecondsStatement:
+		c.infer(n.Seconds)
+	case *parser.WaitFramesStatement:
+		c.infer(n.Frames)
+	case *parser.GameCommand:
+		for _, a := range n.Arguments {
+			c.infer(a)
+		}
+	case *parser.AppendStatement:
+		c.infer(n.Value)
+	case *parser.RedimStatement:
+		for _, d := range n.Dimensions {
+			c.infer(d)
+		}
+	case *parser.ConstStatement:
+		for _, d := range n.Decls {
+			c.infer(d.Value)
+		}
+	case *parser.TryStatement:
+		c.block(n.Body)
+		c.block(n.CatchBlock)
+		c.block(n.FinallyBlock)
+	case *parser.TestStatement:
+		c.block(n.Lowered())
+	case *parser.ThrowStatement:
+		c.infer(n.Value)
+	case *parser.StartCoroutineStatement, *parser.AwaitExpression:
+		c.infer(n)
+	case *parser.EmitStatement:
+		c.infer(n.Name)
+		for _, a := range n.Args {
+			c.infer(a)
+		}
+	case *parser.ModuleStatement:
+		c.stmts(n.Body)
+	case *parser.Block:
+		c.block(n)
+	default:
+		// Other statements carry no typed expressions.
+	}
+}
+
+// assignment checks x = v, arr(i) = v and p.field.sub = v against declared types.
+func (c *checker) assignment(n *parser.Assignment) {
+	for _, idx := range n.Indices {
+		c.infer(idx)
+	}
+	vt := c.infer(n.Value)
+	parts := strings.Split(n.Variable, ".")
+	v, ok := c.lookupVar(parts[0])
+	if !ok {
+		return
+	}
+	if v.array && len(n.Indices) == 0 && len(parts) == 1 {
+		return // whole-array reassignment is dynamic
+	}
+	if !v.array && len(n.Indices) > 0 {
+		return // an item of a LIST or MAP can hold anything
+	}
+	dst := v.typ
+	name := parts[0]
+	for _, field := range parts[1:] {
+		if dst.Kind != KindUDT {
+			return
+		}
+		ft, found := c.fieldType(dst.UDT, field)
+		if !found {
+			c.unknownField(n, dst.UDT, field)
+			return
+		}
+		dst = ft
+		name += "." + field
+	}
+	if !assignable(dst, vt) {
+		c.errorf(n, "type mismatch: cannot assign %s to %s (%s)", vt, name, dst)
+	}
+}
+
+func (c *checker) unknownField(n parser.Node, td *parser.TypeDecl, field string) {
+	if s := cberrors.Nearest(field, fieldNames(td), 2); s != "" {
+		c.errorf(n, "unknown field %q on %s (did you mean %s?)", field, td.Name, s)
+		return
+	}
+	c.errorf(n, "unknown field %q on %s", field, td.Name)
+}
+
+// infer returns the static type of expr, reporting errors in its subexpressions.
+func (c *checker) infer(expr parser.Node) Type {
+	switch n := expr.(type) {
+	case nil:
+		return typeUnknown
+	case *parser.Number:
+		if strings.ContainsAny(n.Value, ".eE") && !strings.HasPrefix(strings.ToLower(n.Value), "0x") {
+			return typeFloat
+		}
+		return typeInteger
+	case *parser.StringLiteral, *parser.InterpolatedString:
+		if is, ok := n.(*parser.InterpolatedString); ok {
+			for _, p := range is.Parts {
+				c.infer(p)
+			}
+		}
+		return typeString
+	case *parser.Boolean:
+		return typeBoolean
+	case *parser.Identifier:
+		if v, ok := c.lookupVar(n.Name); ok && !v.array {
+			return v.typ
+		}
+		return typeUnknown
+	case *parser.UnaryOp:
+		t := c.infer(n.Operand)
+		if strings.EqualFold(n.Operator, "not") {
+			return typeBoolean
+		}
+		if t.Kind == KindString {
+			c.errorf(n, "type mismatch: cannot negate STRING")
+		}
+		return t
+	case *parser.BinaryOp:
+		return c.binary(n)
+	case *parser.Call:
+		return c.call(n)
+	case *parser.MemberAccess:
+		return c.member(n)
+	case *parser.SliceExpr:
+		c.infer(n.Object)
+		c.infer(n.Start)
+		c.infer(n.End)
+		for _, i := range n.Indices {
+			c.infer(i)
+		}
+		return typeUnknown
+	case *parser.JSONIndexAccess:
+		c.infer(n.Object)
+		return typeUnknown
+	case *parser.DictLiteral:
+		for _, p := range n.Pairs {
+			c.infer(p.Value)
+		}
+		return typeUnknown
+	case *parser.ListLiteral:
+		for _, el := range n.Elements {
+			c.infer(el)
+		}
+		return typeUnknown
+	case *parser.MapLiteral:
+		for i := range n.Keys {
+			c.infer(n.Keys[i])
+			c.infer(n.Values[i])
+		}
+		return typeUnknown
+	case *parser.StartCoroutineStatement:
+		for _, a := range n.Args {
+			c.infer(a)
+		}
+		if sig, ok := c.funcs[strings.ToLower(n.SubName)]; ok && len(n.Args) != sig.params {
+			c.errorf(n, "%s expects %d %s, got %d", sig.name, sig.params, plural(sig.params, "argument"), len(n.Args))
+		}
+		return typeUnknown
+	case *parser.AwaitExpression:
+		c.infer(n.Value)
+		return typeUnknown
+	case *parser.FunctionExpression:
+		c.functionExpression(n)
+		return typeUnknown
+	default:
+		return typeUnknown
+	}
+}
+
+// functionExpression checks an anonymous function's body with its parameters and the captured enclosing ones
+// in scope, then restores the enclosing function.
+func (c *checker) functionExpression(n *parser.FunctionExpression) {
+	savedLocals, savedFunc, savedLine := c.locals, c.inFunc, c.line
+	c.enterFunc(&funcSig{name: "FUNCTION", params: len(n.Parameters), ret: c.typeFromName(n.ReturnType)}, n.Parameters, n.Line)
+	for k, v := range savedLocals {
+		if _, shadowed := c.locals[k]; !shadowed {
+			c.locals[k] = v
+		}
+	}
+	c.block(n.Body)
+	c.locals, c.inFunc, c.line = savedLocals, savedFunc, savedLine
+}
+
+func (c *checker) binary(n *parser.BinaryOp) Type {
+	lt, rt := c.infer(n.Left), c.infer(n.Right)
+	op := strings.ToLower(n.Operator)
+	switch op {
+	case "=", "==", "<>", "<", "<=", ">", ">=":
+		if (lt.Kind == KindString && rt.numeric()) || (lt.numeric() && rt.Kind == KindString) {
+			c.errorf(n, "type mismatch: cannot compare %s with %s", lt, rt)
+		}
+		return typeBoolean
+	case "and", "or", "xor":
+		return typeBoolean
+	case "+":
+		if lt.Kind == KindString || rt.Kind == KindString {
+			return typeString
+		}
+	case "-", "*", "/", "%", "^", "\\", "mod":
+		if lt.Kind == KindString || rt.Kind == KindString {
+			c.errorf(n, "type mismatch: operator %s is not valid on STRING", n.Operator)
+			return typeUnknown
+		}
+		if op == "/" || op == "^" {
+			if lt.numeric() && rt.numeric() {
+				return typeFloat
+			}
+			return typeUnknown
+		}
+	}
+	switch {
+	case lt.Kind == KindFloat && rt.numeric(), rt.Kind == KindFloat && lt.numeric():
+		return typeFloat
+	case lt.Kind == KindInteger && rt.Kind == KindInteger:
+		return typeInteger
+	}
+	return typeUnknown
+}
+
+// call checks user FUNCTION/SUB arity and returns the declared return type. Calls that codegen
+// treats as array reads (name of a DIM'd array) are not calls.
+func (c *checker) call(n *parser.Call) Type {
+	for _, a := range n.Arguments {
+		c.infer(a)
+	}
+	if v, ok := c.lookupVar(n.Name); ok && v.array {
+		return v.typ
+	}
+	key := strings.ToLower(n.Name)
+	if sig, ok := c.funcs[key]; ok {
+		if len(n.Arguments) != sig.params {
+			c.errorf(n, "%s expects %d %s, got %d", sig.name, sig.params, plural(sig.params, "argument"), len(n.Arguments))
+		}
+		return sig.ret
+	}
+	if td, ok := c.typeDefs[key]; ok {
+		return c.constructor(n, td)
+	}
+	if i := strings.LastIndex(key, "."); i > 0 {
+		if t, ok := c.methodCall(n, key[:i], key[i+1:]); ok {
+			return t
+		}
+	}
+	if t, ok := builtinReturns[key]; ok {
+		return t
+	}
+	return typeUnknown
+}
+
+// constructor checks TypeName(args) against the type's New method (no arguments when it has none).
+func (c *checker) constructor(n *parser.Call, td *parser.TypeDecl) Type {
+	if sig, ok := c.funcs[strings.ToLower(td.Name)+".new"]; ok {
+		if len(n.Arguments) != sig.params-1 {
+			c.errorf(n, "%s expects %d %s, got %d", td.Name, sig.params-1, plural(sig.params-1, "argument"), len(n.Arguments))
+		}
+	} else if len(n.Arguments) > 0 {
+		c.errorf(n, "%s has no New method and takes no arguments, got %d", td.Name, len(n.Arguments))
+	}
+	return Type{Kind: KindUDT, UDT: td}
+}
+
+// methodCall checks obj.method(args) when obj (a variable or field path) has a TYPE: the method must exist
+// and get its declared arguments. ok is false when obj has no static TYPE.
+func (c *checker) methodCall(n *parser.Call, obj, method string) (Type, bool) {
+	parts := strings.Split(obj, ".")
+	v, found := c.lookupVar(parts[0])
+	if !found || v.array {
+		return typeUnknown, false
+	}
+	t := v.typ
+	for _, field := range parts[1:] {
+		if t.Kind != KindUDT {
+			return typeUnknown, false
+		}
+		if t, found = c.fieldType(t.UDT, field); !found {
+			return typeUnknown, false // reported as an unknown field by member checks
+		}
+	}
+	if t.Kind != KindUDT {
+		return typeUnknown, false
+	}
+	prefix := strings.ToLower(t.UDT.Name) + "."
+	sig, ok := c.funcs[prefix+method]
+	if !ok && hasField(t.UDT, method) {
+		return typeUnknown, true // a field holding a function value
+	}
+	if !ok {
+		var names []string
+		for _, m := range t.UDT.Methods {
+			names = append(names, QualifiedName(m)[len(prefix):])
+		}
+		if s := cberrors.Nearest(method, names, 2); s != "" {
+			c.errorf(n, "unknown method %q on %s (did you mean %s?)", method, t.UDT.Name, s)
+		} else {
+			c.errorf(n, "unknown method %q on %s", method, t.UDT.Name)
+		}
+		return typeUnknown, true
+	}
+	if len(n.Arguments) != sig.params-1 {
+		c.errorf(n, "%s.%s expects %d %s, got %d", t.UDT.Name, sig.name, sig.params-1, plural(sig.params-1, "argument"), len(n.Arguments))
+	}
+	return sig.ret, true
+}
+
+// member resolves p.field on a UDT variable and TypeName.member on a constant group.
+func (c *checker) member(n *parser.MemberAccess) Type {
+	if id, ok := n.Object.(*parser.Identifier); ok {
+		if _, isVar := c.lookupVar(id.Name); !isVar {
+			if td, isType := c.typeDefs[strings.ToLower(id.Name)]; isType {
+				t, found := c.fieldType(td, n.Member)
+				if !found {
+					c.unknownField(n, td, n.Member)
+				}
+				return t
+			}
+		}
+	}
+	ot := c.infer(n.Object)
+	if ot.Kind != KindUDT {
+		return typeUnknown
+	}
+	t, found := c.fieldType(ot.UDT, n.Member)
+	if !found {
+		c.unknownField(n, ot.UDT, n.Member)
+	}
+	return t
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-// QualifiedName returns the lowercase qualified name for a user function/sub (Module.Name or Name).
+// QualifiedName returns the lowercase qualified name for a user function/sub (Module.Name, Type.Method or Name).
 // It is exported for use by codegen or tests.
 func QualifiedName(node parser.Node) string {
 	switch n := node.(type) {
@@ -15,20 +15,27 @@ func QualifiedName(node parser.Node) string {
 		if n.ModuleName != "" {
 			return strings.ToLower(n.ModuleName) + "." + strings.ToLower(n.Name)
 		}
+		if n.TypeName != "" {
+			return strings.ToLower(n.TypeName) + "." + strings.ToLower(n.Name)
+		}
 		return strings.ToLower(n.Name)
 	case *parser.SubDecl:
 		if n.ModuleName != "" {
 			return strings.ToLower(n.ModuleName) + "." + strings.ToLower(n.Name)
 		}
+		if n.TypeName != "" {
+			return strings.ToLower(n.TypeName) + "." + strings.ToLower(n.Name)
+		}
 		return strings.ToLower(n.Name)
 	default:
 		return ""
 	}
 }
 
-// Analyze walks the program AST and builds the symbol table and statement split.
-// It collects all validation errors (e.g. duplicate TYPE, ENTITY, or function/sub) and returns
-// them together via errors.Join so the user sees every issue in one run.
+// Analyze walks the program AST and builds the symbol table and statement split, then type checks it.
+// It collects all validation errors (e.g. duplicate TYPE, ENTITY, or function/sub, type mismatches,
+// wrong argument counts, unknown TYPE fields) and returns them together via errors.Join so the user
+// sees every issue in one run.
 func Analyze(program *parser.Program) (*Result, error) {
 	typeDefs := make(map[string]*parser.TypeDecl)
 	entityNames := make(map[string]bool)
@@ -44,6 +51,19 @@ func Analyze(program *parser.Program) (*Result, error) {
 			} else {
 				typeDefs[key] = td
 			}
+			for _, m := range td.Methods {
+				q := QualifiedName(m)
+				name := q[len(key)+1:]
+				switch {
+				case userFuncs[q]:
+					errs = append(errs, fmt.Errorf("duplicate method %q on type %s", name, td.Name))
+				case hasField(td, name):
+					errs = append(errs, fmt.Errorf("method %q on type %s has the name of a field", name, td.Name))
+				default:
+					userFuncs[q] = true
+				}
+				decls = append(decls, m)
+			}
 		}
 		if ed, ok := stmt.(*parser.EntityDecl); ok {
 			key := strings.ToLower(ed.Name)
@@ -81,6 +101,8 @@ func Analyze(program *parser.Program) (*Result, error) {
 		}
 	}
 
+	errs = append(errs, checkTypes(typeDefs, mainStmts, decls)...)
+
 	if len(errs) > 0 {
 		return nil, errors.Join(errs...)
 	}
@@ -93,3 +115,12 @@ func Analyze(program *parser.Program) (*Result, error) {
 		Decls:       decls,
 	}, nil
 }
+
+func hasField(td *parser.TypeDecl, name string) bool {
+	for _, f := range td.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return true
+		}
+	}
+	return false
+}
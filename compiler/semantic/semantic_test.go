@@ -3,6 +3,7 @@ package semantic
 import (
 	"cyberbasic/compiler/lexer"
 	"cyberbasic/compiler/parser"
+	"strings"
 	"testing"
 )
 
@@ -152,3 +153,84 @@ func TestQualifiedName(t *testing.T) {
 		})
 	}
 }
+
+func TestAnalyze_TypeCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string // substrings expected in the error; nil = no error
+	}{
+		{"int ok", "DIM x AS INTEGER\nx = 1 + 2 * 3\n", nil},
+		{"float from int", "DIM f AS FLOAT\nf = 2\n", nil},
+		{"string concat", "DIM s AS STRING\ns = \"n=\" + 3\ns += \"!\"\n", nil},
+		{"dynamic foreign", "DIM x AS INTEGER\nx = GetScreenWidth()\n", nil},
+		{"string into int", "DIM x AS INTEGER\nx = \"hello\"\n", []string{"line 2", "cannot assign STRING to x (INTEGER)"}},
+		{"int into string", "DIM s AS STRING\ns = 5\n", []string{"cannot assign INTEGER to s (STRING)"}},
+		{"string minus", "DIM s AS STRING\ns -= 1\n", []string{"operator -= is not valid on s (STRING)"}},
+		{"arity", "FUNCTION Add(a, b)\n  RETURN a + b\nEND FUNCTION\nPRINT Add(1)\n", []string{"line 4, col", "add expects 2 arguments, got 1"}},
+		{"sub arity", "SUB Hit(n)\nEND SUB\nHit(1, 2)\n", []string{"hit expects 1 argument, got 2"}},
+		{"module arity", "MODULE M\n  FUNCTION F(a)\n    RETURN a\n  END FUNCTION\nEND MODULE\nPRINT M.F()\n", []string{"f expects 1 argument, got 0"}},
+		{"return type", "FUNCTION Name() AS STRING\n  RETURN 3\nEND FUNCTION\n", []string{"name returns STRING, got INTEGER"}},
+		{"array read not a call", "DIM a(10) AS INTEGER\nDIM x AS INTEGER\nx = a(1)\na(2) = x\n", nil},
+		{"array element type", "DIM a(10) AS INTEGER\na(1) = \"x\"\n", []string{"cannot assign STRING to a (INTEGER)"}},
+		{"field ok", "TYPE Player\n  name AS STRING\n  hp AS INTEGER\nEND TYPE\nDIM p AS Player\np.name = \"Ann\"\np.hp = p.hp - 1\n", nil},
+		{"unknown field read", "TYPE Player\n  name AS STRING\nEND TYPE\nDIM p AS Player\nPRINT p.nmae\n", []string{"line 5", "unknown field \"nmae\" on player (did you mean name?)"}},
+		{"unknown field write", "TYPE Player\n  hp AS INTEGER\nEND TYPE\nDIM p AS Player\np.xyzzy = 1\n", []string{"unknown field \"xyzzy\" on player"}},
+		{"field type", "TYPE Player\n  hp AS INTEGER\nEND TYPE\nDIM p AS Player\np.hp = \"full\"\n", []string{"cannot assign STRING to p.hp (INTEGER)"}},
+		{"nested field", "TYPE Pos\n  x AS FLOAT\nEND TYPE\nTYPE Player\n  pos AS Pos\nEND TYPE\nDIM p AS Player\np.pos.x = 1.5\nPRINT p.pos.y\n", []string{"unknown field \"y\" on pos"}},
+		{"const group", "TYPE Keys\n  Jump = 32\nEND TYPE\nDIM k AS INTEGER\nk = Keys.Jump\nk = Keys.Jmp\n", []string{"unknown field \"jmp\" on keys (did you mean jump?)"}},
+		{"compare", "DIM s AS STRING\nIF s = 1 THEN\n  PRINT s\nEND IF\n", []string{"cannot compare STRING with INTEGER"}},
+		{"local scope", "DIM x AS INTEGER\nSUB S(x)\n  x = \"ok\"\nEND SUB\n", nil},
+		{"method ok", "TYPE Player\n  hp AS INTEGER\n  SUB New(h)\n    ME.hp = h\n  END SUB\n  FUNCTION Alive() AS BOOLEAN\n    RETURN SELF.hp > 0\n  END FUNCTION\nEND TYPE\nDIM p AS Player\np = Player(3)\nPRINT p.Alive()\nPRINT Player.Alive(p)\n", nil},
+		{"unknown method", "TYPE Player\n  hp AS INTEGER\n  SUB Hurt(d)\n  END SUB\nEND TYPE\nDIM p AS Player\np.Hrut(1)\n", []string{"line 7", "unknown method \"hrut\" on player (did you mean hurt?)"}},
+		{"method arity", "TYPE Player\n  SUB Hurt(d)\n  END SUB\nEND TYPE\nDIM p AS Player\np.Hurt(1, 2)\n", []string{"player.hurt expects 1 argument, got 2"}},
+		{"method on ME", "TYPE Player\n  hp AS INTEGER\n  SUB Heal()\n    ME.hp = \"full\"\n    ME.Hurt()\n  END SUB\nEND TYPE\n", []string{"cannot assign STRING to me.hp (INTEGER)", "unknown method \"hurt\" on player"}},
+		{"constructor arity", "TYPE Player\n  SUB New(name)\n  END SUB\nEND TYPE\nx = Player()\n", []string{"player expects 1 argument, got 0"}},
+		{"no constructor", "TYPE Player\n  hp AS INTEGER\nEND TYPE\nx = Player(1)\n", []string{"player has no New method"}},
+		{"method named like field", "TYPE Player\n  hp AS INTEGER\n  SUB HP()\n  END SUB\nEND TYPE\n", []string{"has the name of a field"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Analyze(mustParse(t, tt.src))
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Analyze: unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Analyze: expected error containing %q", tt.want)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not contain %q", err.Error(), w)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyze_TypeCheckCollectsAll(t *testing.T) {
+	src := `DIM x AS INTEGER
+DIM s AS STRING
+FUNCTION F(a, b)
+  RETURN a
+END FUNCTION
+x = "a"
+s = 1
+x = F(1)
+`
+	_, err := Analyze(mustParse(t, src))
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 errors in one run, got %d: %v", len(lines), err)
+	}
+	for i, want := range []string{"line 6", "line 7", "line 8"} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Errorf("error %d = %q, want prefix %q", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package semantic
+
+import (
+	"cyberbasic/compiler/parser"
+	"strings"
+)
+
+// Kind classifies a static type. KindUnknown is the dynamic type: values of unknown type are never reported.
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	KindInteger
+	KindFloat
+	KindString
+	KindBoolean
+	KindUDT
+)
+
+// Type is a declared or inferred static type. UDT is set for KindUDT.
+type Type struct {
+	Kind Kind
+	UDT  *parser.TypeDecl
+}
+
+var (
+	typeUnknown = Type{Kind: KindUnknown}
+	typeInteger = Type{Kind: KindInteger}
+	typeFloat   = Type{Kind: KindFloat}
+	typeString  = Type{Kind: KindString}
+	typeBoolean = Type{Kind: KindBoolean}
+)
+
+func (t Type) String() string {
+	switch t.Kind {
+	case KindInteger:
+		return "INTEGER"
+	case KindFloat:
+		return "FLOAT"
+	case KindString:
+		return "STRING"
+	case KindBoolean:
+		return "BOOLEAN"
+	case KindUDT:
+		if t.UDT != nil {
+			return t.UDT.Name
+		}
+	}
+	return "ANY"
+}
+
+func (t Type) numeric() bool {
+	return t.Kind == KindInteger || t.Kind == KindFloat
+}
+
+// builtinTypes maps AS type names (lowercase) accepted by DIM and TYPE fields to their kind.
+// Names not listed here and not a TYPE (e.g. Vector3, Color, Body) are dynamic.
+var builtinTypes = map[string]Type{
+	"integer": typeInteger, "int": typeInteger, "long": typeInteger,
+	"float": typeFloat, "single": typeFloat, "double": typeFloat,
+	"string": typeString, "str": typeString,
+	"boolean": typeBoolean, "bool": typeBoolean,
+}
+
+// assignable reports whether a value of type src may be stored where dst is declared.
+// Numbers and BOOLEAN interconvert (the VM coerces them), so mismatches are STRING vs number and
+// UDT vs anything else. Unknown types always pass.
+func assignable(dst, src Type) bool {
+	if dst.Kind == KindUnknown || src.Kind == KindUnknown {
+		return true
+	}
+	switch dst.Kind {
+	case KindInteger, KindFloat, KindBoolean:
+		return src.numeric() || src.Kind == KindBoolean
+	case KindUDT:
+		return src.Kind == KindUDT && src.UDT == dst.UDT
+	default:
+		return dst.Kind == src.Kind
+	}
+}
+
+// fieldType returns the declared type of field name in td, and whether the field exists.
+func (c *checker) fieldType(td *parser.TypeDecl, name string) (Type, bool) {
+	for _, f := range td.Fields {
+		if strings.EqualFold(f.Name, name) {
+			if f.ConstValue != nil {
+				return c.infer(f.ConstValue), true
+			}
+			return c.typeFromName(f.FieldType), true
+		}
+	}
+	return typeUnknown, false
+}
+
+func fieldNames(td *parser.TypeDecl) []string {
+	names := make([]string, 0, len(td.Fields))
+	for _, f := range td.Fields {
+		names = append(names, f.Name)
+	}
+	return names
+}
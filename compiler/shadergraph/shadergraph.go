@@ -0,0 +1,505 @@
+// Package shadergraph compiles node-based shader graphs (ShaderGraphCreate, ShaderNode*, ShaderGraphCompile) to
+// GLSL 330 vertex and fragment shaders for raylib. A Graph is a set of Nodes whose inputs are other nodes'
+// outputs or float constants; the output node's value is the fragment colour. Compile walks the graph from the
+// output, checks the types (float, vec2, vec3, vec4 and sampler), and emits one GLSL variable per node, declaring
+// the uniforms the graph uses: sampler2D textures, colours (initialised to the node's colour), time and viewPos.
+package shadergraph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type is the type of a node's output or input.
+type Type int
+
+const (
+	Float Type = iota + 1
+	Vec2
+	Vec3
+	Vec4
+	Sampler
+)
+
+func (t Type) String() string {
+	switch t {
+	case Float:
+		return "float"
+	case Vec2:
+		return "vec2"
+	case Vec3:
+		return "vec3"
+	case Vec4:
+		return "vec4"
+	case Sampler:
+		return "sampler2D"
+	}
+	return "?"
+}
+
+// numeric reports whether t is float or a vector.
+func (t Type) numeric() bool { return t >= Float && t <= Vec4 }
+
+// port is one input of a node kind. want is its type (0 for any float or vector); def is the GLSL used when it is
+// not connected ("" when it must be).
+type port struct {
+	name string
+	want Type
+	def  string
+}
+
+// kinds lists the node kinds and their inputs, in the order the ShaderNode* commands take them.
+var kinds = map[string][]port{
+	"sampler":     nil,
+	"texture":     {{"texture", Sampler, "texture0"}, {"uv", Vec2, "fragTexCoord"}},
+	"normalmap":   {{"texture", Sampler, "texture0"}, {"strength", Float, "1.0"}, {"uv", Vec2, "fragTexCoord"}},
+	"color":       nil,
+	"time":        nil,
+	"uv":          nil,
+	"vertexcolor": nil,
+	"add":         {{"a", 0, ""}, {"b", 0, ""}},
+	"multiply":    {{"a", 0, ""}, {"b", 0, ""}},
+	"lerp":        {{"a", 0, ""}, {"b", 0, ""}, {"t", 0, ""}},
+	"fresnel":     {{"power", Float, "5.0"}, {"normal", Vec3, "normalize(fragNormal)"}},
+	"noise":       {{"scale", Float, "10.0"}, {"uv", Vec2, "fragTexCoord"}},
+	"uvscroll":    {{"speedx", Float, "0.0"}, {"speedy", Float, "0.0"}, {"uv", Vec2, "fragTexCoord"}},
+}
+
+// InputIndex returns the index of kind's input called name (case-insensitive), or -1.
+func InputIndex(kind, name string) int {
+	for i, p := range kinds[kind] {
+		if strings.EqualFold(p.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// InputType returns the type of input i of kind, or 0 when it takes any float or vector.
+func InputType(kind string, i int) Type {
+	if i < 0 || i >= len(kinds[kind]) {
+		return 0
+	}
+	return kinds[kind][i].want
+}
+
+// Node is a node of a graph. Inputs has one entry per input of its kind.
+type Node struct {
+	ID      string
+	Kind    string
+	Inputs  []Input
+	Texture string     // sampler: the texture bound to it; "" for the texture being drawn (texture0)
+	Color   [4]float64 // color: r, g, b, a in 0..1
+}
+
+// Input is an input of a node: unconnected (the zero value), another node's output or a float constant.
+type Input struct {
+	Node  string
+	Value float64
+	Const bool
+}
+
+// NewNode returns a node of the given kind with its inputs unconnected.
+func NewNode(id, kind string) (*Node, error) {
+	ports, ok := kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown shader node kind %q", kind)
+	}
+	return &Node{ID: id, Kind: kind, Inputs: make([]Input, len(ports))}, nil
+}
+
+// Clone returns a copy of n that can be connected without changing n.
+func (n *Node) Clone() *Node {
+	c := *n
+	c.Inputs = append([]Input(nil), n.Inputs...)
+	return &c
+}
+
+// Graph is a shader graph.
+type Graph struct {
+	Nodes  map[string]*Node
+	Output string // the node whose value is the fragment colour; "" for the target of the last Connect
+	last   string
+}
+
+// New returns an empty graph.
+func New() *Graph { return &Graph{Nodes: map[string]*Node{}} }
+
+// Add adds n to the graph.
+func (g *Graph) Add(n *Node) { g.Nodes[n.ID] = n }
+
+// Connect plugs the output of node from into input number input of node to, or into its first unconnected input
+// when input is negative. Connecting to "output" makes from the graph's output.
+func (g *Graph) Connect(from, to string, input int) error {
+	if g.Nodes[from] == nil {
+		return fmt.Errorf("unknown shader node %q", from)
+	}
+	if strings.EqualFold(to, "output") {
+		g.Output = from
+		return nil
+	}
+	n := g.Nodes[to]
+	if n == nil {
+		return fmt.Errorf("unknown shader node %q", to)
+	}
+	if input < 0 {
+		for i, in := range n.Inputs {
+			if in == (Input{}) {
+				input = i
+				break
+			}
+		}
+		if input < 0 {
+			return fmt.Errorf("shader node %s (%s) has no unconnected input", to, n.Kind)
+		}
+	}
+	if input >= len(n.Inputs) {
+		return fmt.Errorf("shader node %s (%s) has %d inputs, not %d", to, n.Kind, len(n.Inputs), input+1)
+	}
+	n.Inputs[input] = Input{Node: from}
+	g.last = to
+	return nil
+}
+
+// Uniform is a uniform a compiled graph declares.
+type Uniform struct {
+	Name    string
+	Type    Type
+	Texture string    // sampler: the texture to bind ("" for texture0, which raylib binds to the texture drawn)
+	Value   []float64 // color: the initial value
+}
+
+// Shader is a compiled graph.
+type Shader struct {
+	Vertex   string
+	Fragment string
+	Uniforms []Uniform // in declaration order
+}
+
+// Uniform returns the uniform called name, or nil.
+func (s *Shader) Uniform(name string) *Uniform {
+	for i := range s.Uniforms {
+		if s.Uniforms[i].Name == name {
+			return &s.Uniforms[i]
+		}
+	}
+	return nil
+}
+
+// Compile type-checks g and compiles it to GLSL 330.
+func Compile(g *Graph) (*Shader, error) {
+	out := g.Output
+	if out == "" {
+		out = g.last
+	}
+	if out == "" {
+		return nil, fmt.Errorf("shader graph has no output: connect a node to \"output\"")
+	}
+	c := &compiler{g: g, vars: map[string]value{}, visiting: map[string]bool{}, samplers: map[string]string{}}
+	v, err := c.node(out)
+	if err != nil {
+		return nil, err
+	}
+	var color string
+	switch v.t {
+	case Float:
+		color = "vec4(vec3(" + v.expr + "), 1.0)"
+	case Vec2:
+		color = "vec4(" + v.expr + ", 0.0, 1.0)"
+	case Vec3:
+		color = "vec4(" + v.expr + ", 1.0)"
+	case Vec4:
+		color = v.expr
+	default:
+		return nil, fmt.Errorf("shader graph output %s is a %s, not a colour", out, v.t)
+	}
+	fmt.Fprintf(&c.body, "    finalColor = %s;\n", color)
+	return &Shader{Vertex: c.vertex(), Fragment: c.fragment(), Uniforms: c.uniforms}, nil
+}
+
+// value is a compiled node or input: a GLSL expression (a variable, uniform or literal) and its type.
+type value struct {
+	expr string
+	t    Type
+}
+
+type compiler struct {
+	g        *Graph
+	vars     map[string]value // compiled nodes
+	visiting map[string]bool
+	body     strings.Builder // statements of main
+	uniforms []Uniform
+	samplers map[string]string // texture id -> sampler uniform
+	textures int               // samplers other than texture0
+	colors   int
+	tbn      bool // normal maps: the vertex shader passes fragTBN
+	noise    bool // the noise helper functions are needed
+}
+
+// node compiles node id and the nodes it reads, once each.
+func (c *compiler) node(id string) (value, error) {
+	if v, ok := c.vars[id]; ok {
+		return v, nil
+	}
+	n := c.g.Nodes[id]
+	if n == nil {
+		return value{}, fmt.Errorf("unknown shader node %q", id)
+	}
+	if c.visiting[id] {
+		return value{}, fmt.Errorf("shader graph has a cycle through node %s", id)
+	}
+	c.visiting[id] = true
+	defer delete(c.visiting, id)
+
+	ports := kinds[n.Kind]
+	in := make([]value, len(ports))
+	for i, p := range ports {
+		var v value
+		var from string
+		switch {
+		case i < len(n.Inputs) && n.Inputs[i].Node != "":
+			from = n.Inputs[i].Node
+			var err error
+			if v, err = c.node(from); err != nil {
+				return value{}, err
+			}
+		case i < len(n.Inputs) && n.Inputs[i].Const:
+			v = value{floatLit(n.Inputs[i].Value), Float}
+		case p.def == "":
+			return value{}, fmt.Errorf("shader node %s (%s): input %s is not connected", id, n.Kind, p.name)
+		case p.want == Sampler:
+			v = value{c.sampler(""), Sampler}
+		default:
+			v = value{p.def, p.want}
+		}
+		if p.want != 0 && v.t != p.want || p.want == 0 && !v.t.numeric() {
+			want := p.want.String()
+			if p.want == 0 {
+				want = "float or vector"
+			}
+			if from != "" {
+				return value{}, fmt.Errorf("shader node %s (%s): input %s needs a %s, but %s gives a %s", id, n.Kind, p.name, want, from, v.t)
+			}
+			return value{}, fmt.Errorf("shader node %s (%s): input %s needs a %s, not a %s", id, n.Kind, p.name, want, v.t)
+		}
+		in[i] = v
+	}
+
+	t, expr, err := c.emit(n, in)
+	if err != nil {
+		return value{}, err
+	}
+	v := value{expr, t}
+	if t != Sampler {
+		// Samplers cannot be variables in GLSL 330; every other node gets one.
+		name := c.varName(id)
+		fmt.Fprintf(&c.body, "    %s %s = %s;\n", t, name, expr)
+		v.expr = name
+	}
+	c.vars[id] = v
+	return v, nil
+}
+
+// emit returns the type and GLSL expression of n given its inputs.
+func (c *compiler) emit(n *Node, in []value) (Type, string, error) {
+	switch n.Kind {
+	case "sampler":
+		return Sampler, c.sampler(n.Texture), nil
+	case "texture":
+		return Vec4, fmt.Sprintf("texture(%s, %s)", in[0].expr, in[1].expr), nil
+	case "normalmap":
+		c.tbn = true
+		return Vec3, fmt.Sprintf("normalize(fragTBN*mix(vec3(0.0, 0.0, 1.0), texture(%s, %s).rgb*2.0 - 1.0, %s))", in[0].expr, in[2].expr, in[1].expr), nil
+	case "color":
+		name := fmt.Sprintf("color%d", c.colors)
+		c.colors++
+		c.uniforms = append(c.uniforms, Uniform{Name: name, Type: Vec4, Value: n.Color[:]})
+		return Vec4, name, nil
+	case "time":
+		return Float, c.uniform("time", Float), nil
+	case "uv":
+		return Vec2, "fragTexCoord", nil
+	case "vertexcolor":
+		return Vec4, "fragColor", nil
+	case "add", "multiply":
+		t, err := combine(n, in[0].t, in[1].t)
+		op := " + "
+		if n.Kind == "multiply" {
+			op = "*"
+		}
+		return t, in[0].expr + op + in[1].expr, err
+	case "lerp":
+		t, err := combine(n, in[0].t, in[1].t)
+		if err != nil {
+			return 0, "", err
+		}
+		if in[2].t != Float && in[2].t != t {
+			return 0, "", fmt.Errorf("shader node %s (lerp): t must be a float or a %s, not a %s", n.ID, t, in[2].t)
+		}
+		return t, fmt.Sprintf("mix(%s, %s, %s)", promote(in[0], t), promote(in[1], t), in[2].expr), nil
+	case "fresnel":
+		view := c.uniform("viewPos", Vec3)
+		return Float, fmt.Sprintf("pow(1.0 - max(dot(%s, normalize(%s - fragPosition)), 0.0), %s)", in[1].expr, view, in[0].expr), nil
+	case "noise":
+		c.noise = true
+		return Float, fmt.Sprintf("cbNoise(%s*%s)", in[1].expr, in[0].expr), nil
+	case "uvscroll":
+		return Vec2, fmt.Sprintf("%s + vec2(%s, %s)*%s", in[2].expr, in[0].expr, in[1].expr, c.uniform("time", Float)), nil
+	}
+	return 0, "", fmt.Errorf("unknown shader node kind %q", n.Kind)
+}
+
+// combine returns the type of an operation on a and b: their type, or the vector's when the other is a float.
+func combine(n *Node, a, b Type) (Type, error) {
+	switch {
+	case a == b:
+		return a, nil
+	case a == Float:
+		return b, nil
+	case b == Float:
+		return a, nil
+	}
+	return 0, fmt.Errorf("shader node %s (%s): cannot combine a %s and a %s", n.ID, n.Kind, a, b)
+}
+
+// promote converts a float to t (GLSL's mix needs both ends of the same type).
+func promote(v value, t Type) string {
+	if v.t == t {
+		return v.expr
+	}
+	return t.String() + "(" + v.expr + ")"
+}
+
+// sampler returns the sampler uniform for a texture: texture0 for "", else texture1, texture2, ... in order of use.
+func (c *compiler) sampler(texture string) string {
+	if name, ok := c.samplers[texture]; ok {
+		return name
+	}
+	name := "texture0"
+	if texture != "" {
+		c.textures++
+		name = fmt.Sprintf("texture%d", c.textures)
+	}
+	c.samplers[texture] = name
+	c.uniforms = append(c.uniforms, Uniform{Name: name, Type: Sampler, Texture: texture})
+	return name
+}
+
+// uniform declares a uniform once and returns its name.
+func (c *compiler) uniform(name string, t Type) string {
+	for _, u := range c.uniforms {
+		if u.Name == name {
+			return name
+		}
+	}
+	c.uniforms = append(c.uniforms, Uniform{Name: name, Type: t})
+	return name
+}
+
+func (c *compiler) vertex() string {
+	var b strings.Builder
+	b.WriteString("#version 330\n\n")
+	b.WriteString("in vec3 vertexPosition;\nin vec2 vertexTexCoord;\nin vec3 vertexNormal;\nin vec4 vertexColor;\n")
+	if c.tbn {
+		b.WriteString("in vec4 vertexTangent;\n")
+	}
+	b.WriteString("\nuniform mat4 mvp;\nuniform mat4 matModel;\nuniform mat4 matNormal;\n\n")
+	b.WriteString("out vec2 fragTexCoord;\nout vec4 fragColor;\nout vec3 fragPosition;\nout vec3 fragNormal;\n")
+	if c.tbn {
+		b.WriteString("out mat3 fragTBN;\n")
+	}
+	b.WriteString("\nvoid main()\n{\n")
+	b.WriteString("    fragTexCoord = vertexTexCoord;\n    fragColor = vertexColor;\n")
+	b.WriteString("    fragPosition = vec3(matModel*vec4(vertexPosition, 1.0));\n")
+	b.WriteString("    fragNormal = normalize(vec3(matNormal*vec4(vertexNormal, 1.0)));\n")
+	if c.tbn {
+		b.WriteString("    vec3 tangent = normalize(vec3(matModel*vec4(vertexTangent.xyz, 0.0)));\n")
+		b.WriteString("    tangent = normalize(tangent - dot(tangent, fragNormal)*fragNormal);\n")
+		b.WriteString("    fragTBN = mat3(tangent, cross(fragNormal, tangent)*vertexTangent.w, fragNormal);\n")
+	}
+	b.WriteString("    gl_Position = mvp*vec4(vertexPosition, 1.0);\n}\n")
+	return b.String()
+}
+
+func (c *compiler) fragment() string {
+	var b strings.Builder
+	b.WriteString("#version 330\n\n")
+	b.WriteString("in vec2 fragTexCoord;\nin vec4 fragColor;\nin vec3 fragPosition;\nin vec3 fragNormal;\n")
+	if c.tbn {
+		b.WriteString("in mat3 fragTBN;\n")
+	}
+	b.WriteString("\n")
+	for _, u := range c.uniforms {
+		if u.Value != nil {
+			fmt.Fprintf(&b, "uniform %s %s = vec4(%s, %s, %s, %s);\n", u.Type, u.Name,
+				floatLit(u.Value[0]), floatLit(u.Value[1]), floatLit(u.Value[2]), floatLit(u.Value[3]))
+		} else {
+			fmt.Fprintf(&b, "uniform %s %s;\n", u.Type, u.Name)
+		}
+	}
+	if len(c.uniforms) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("out vec4 finalColor;\n\n")
+	if c.noise {
+		b.WriteString(noiseGLSL)
+	}
+	b.WriteString("void main()\n{\n")
+	b.WriteString(c.body.String())
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// noiseGLSL is 2D value noise in [0, 1].
+const noiseGLSL = `float cbHash(vec2 p)
+{
+    return fract(sin(dot(p, vec2(127.1, 311.7)))*43758.5453);
+}
+
+float cbNoise(vec2 p)
+{
+    vec2 i = floor(p);
+    vec2 f = fract(p);
+    vec2 u = f*f*(3.0 - 2.0*f);
+    return mix(mix(cbHash(i), cbHash(i + vec2(1.0, 0.0)), u.x),
+               mix(cbHash(i + vec2(0.0, 1.0)), cbHash(i + vec2(1.0, 1.0)), u.x), u.y);
+}
+
+`
+
+// varName makes node id a GLSL variable name: n_<id> for ids of letters and digits. Any other id gets the number
+// of nodes compiled so far, so that ids like "a-b" and "a_b" stay apart, and the rest of the id with each run of
+// other characters as one "_" (GLSL reserves names containing "__").
+func (c *compiler) varName(id string) string {
+	plain := id != ""
+	var b strings.Builder
+	under := false
+	for _, r := range id {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			if under && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			under = false
+		} else {
+			plain, under = false, true
+		}
+	}
+	if plain {
+		return "n_" + b.String()
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("n%d", len(c.vars))
+	}
+	return fmt.Sprintf("n%d_%s", len(c.vars), b.String())
+}
+
+// floatLit formats f as a GLSL float literal, with float precision.
+func floatLit(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 32)
+	if !strings.ContainsAny(s, ".eEn") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,217 @@
+package shadergraph
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// build returns a graph of the nodes, each given as kind and inputs: node ids, float64 constants or nil.
+func build(t *testing.T, nodes map[string][]interface{}) *Graph {
+	t.Helper()
+	g := New()
+	for id, def := range nodes {
+		n, err := NewNode(id, def[0].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, in := range def[1:] {
+			switch x := in.(type) {
+			case string:
+				n.Inputs[i] = Input{Node: x}
+			case float64:
+				n.Inputs[i] = Input{Value: x, Const: true}
+			}
+		}
+		g.Add(n)
+	}
+	return g
+}
+
+func TestCompileTintedScrollingTexture(t *testing.T) {
+	g := build(t, map[string][]interface{}{
+		"scroll": {"uvscroll", 0.5, nil},
+		"tex":    {"texture", "bricks", "scroll"},
+		"bricks": {"sampler"},
+		"tint":   {"color"},
+		"out":    {"multiply", "tex", "tint"},
+	})
+	g.Nodes["bricks"].Texture = "tex_3"
+	g.Nodes["tint"].Color = [4]float64{1, 128.0 / 255, 0, 1}
+	if err := g.Connect("out", "output", -1); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Compile(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `#version 330
+
+in vec2 fragTexCoord;
+in vec4 fragColor;
+in vec3 fragPosition;
+in vec3 fragNormal;
+
+uniform sampler2D texture1;
+uniform float time;
+uniform vec4 color0 = vec4(1.0, 0.5019608, 0.0, 1.0);
+
+out vec4 finalColor;
+
+void main()
+{
+    vec2 n_scroll = fragTexCoord + vec2(0.5, 0.0)*time;
+    vec4 n_tex = texture(texture1, n_scroll);
+    vec4 n_tint = color0;
+    vec4 n_out = n_tex*n_tint;
+    finalColor = n_out;
+}
+`
+	if s.Fragment != want {
+		t.Errorf("fragment shader:\n%s\nwant:\n%s", s.Fragment, want)
+	}
+	if u := s.Uniform("texture1"); u == nil || u.Type != Sampler || u.Texture != "tex_3" {
+		t.Errorf("texture1 uniform = %+v", u)
+	}
+	for _, frag := range []string{"#version 330\n", "in vec3 vertexPosition;", "uniform mat4 mvp;", "out vec2 fragTexCoord;", "gl_Position = mvp*vec4(vertexPosition, 1.0);"} {
+		if !strings.Contains(s.Vertex, frag) {
+			t.Errorf("vertex shader lacks %q:\n%s", frag, s.Vertex)
+		}
+	}
+	if strings.Contains(s.Vertex, "vertexTangent") {
+		t.Error("vertex shader passes tangents without a normal map")
+	}
+}
+
+func TestCompileLightingNodes(t *testing.T) {
+	g := build(t, map[string][]interface{}{
+		"normal": {"normalmap", nil, 0.5},
+		"rim":    {"fresnel", 3.0, "normal"},
+		"n1":     {"noise", 4.0},
+		"n2":     {"noise"},
+		"mask":   {"add", "n1", "n2"},
+		"base":   {"texture"},
+		"glow":   {"color"},
+		"mixed":  {"lerp", "base", "glow", "rim"},
+		"out":    {"multiply", "mixed", "mask"},
+	})
+	g.Output = "out"
+	s, err := Compile(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frag := range []string{
+		"in mat3 fragTBN;",
+		"uniform sampler2D texture0;\nuniform vec4 color0 = vec4(0.0, 0.0, 0.0, 0.0);\nuniform vec3 viewPos;",
+		"vec3 n_normal = normalize(fragTBN*mix(vec3(0.0, 0.0, 1.0), texture(texture0, fragTexCoord).rgb*2.0 - 1.0, 0.5));",
+		"float n_rim = pow(1.0 - max(dot(n_normal, normalize(viewPos - fragPosition)), 0.0), 3.0);",
+		"float n_n1 = cbNoise(fragTexCoord*4.0);",
+		"float n_n2 = cbNoise(fragTexCoord*10.0);",
+		"vec4 n_mixed = mix(n_base, n_glow, n_rim);",
+		"vec4 n_out = n_mixed*n_mask;",
+	} {
+		if !strings.Contains(s.Fragment, frag) {
+			t.Errorf("fragment shader lacks %q:\n%s", frag, s.Fragment)
+		}
+	}
+	if strings.Count(s.Fragment, "float cbNoise(vec2 p)") != 1 || strings.Count(s.Fragment, "uniform sampler2D texture0;") != 1 {
+		t.Errorf("helpers or samplers declared more than once:\n%s", s.Fragment)
+	}
+	if !strings.Contains(s.Vertex, "in vec4 vertexTangent;") || !strings.Contains(s.Vertex, "fragTBN = mat3(") {
+		t.Errorf("vertex shader does not pass the TBN matrix:\n%s", s.Vertex)
+	}
+}
+
+func TestCompileOutputAndConnect(t *testing.T) {
+	g := build(t, map[string][]interface{}{
+		"time": {"time"},
+		"uv":   {"uv"},
+		"a":    {"add"},
+	})
+	if err := g.Connect("time", "a", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Connect("time", "a", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Connect("uv", "a", -1); err == nil {
+		t.Error("connected a third input to add")
+	}
+	if err := g.Connect("uv", "a", 2); err == nil {
+		t.Error("connected input 3 of add")
+	}
+	s, err := Compile(g) // the output is the last node connected to
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(s.Fragment, "float n_a = n_time + n_time;\n    finalColor = vec4(vec3(n_a), 1.0);") {
+		t.Errorf("float output:\n%s", s.Fragment)
+	}
+	g.Output = "uv"
+	if s, _ := Compile(g); !strings.Contains(s.Fragment, "finalColor = vec4(n_uv, 0.0, 1.0);") {
+		t.Errorf("vec2 output:\n%s", s.Fragment)
+	}
+	if _, err := Compile(New()); err == nil {
+		t.Error("compiled a graph without an output")
+	}
+}
+
+func TestCompileVariableNames(t *testing.T) {
+	g := build(t, map[string][]interface{}{
+		"a-b":  {"time"},
+		"a_b":  {"multiply", "a-b", 2.0},
+		"-":    {"time"},
+		"x__y": {"add", "a_b", "-"},
+	})
+	g.Output = "x__y"
+	s, err := Compile(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, m := range regexp.MustCompile(`(?m)^    float (\w+) = `).FindAllStringSubmatch(s.Fragment, -1) {
+		name := m[1]
+		if seen[name] || strings.Contains(name, "__") {
+			t.Errorf("variable %s declared twice or reserved:\n%s", name, s.Fragment)
+		}
+		seen[name] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("declared %d variables, want 4:\n%s", len(seen), s.Fragment)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, c := range []struct {
+		nodes map[string][]interface{}
+		want  string
+	}{
+		{map[string][]interface{}{"o": {"add", "uv", "c"}, "uv": {"uv"}, "c": {"vertexcolor"}},
+			"shader node o (add): cannot combine a vec2 and a vec4"},
+		{map[string][]interface{}{"o": {"texture", nil, "c"}, "c": {"vertexcolor"}},
+			"shader node o (texture): input uv needs a vec2, but c gives a vec4"},
+		{map[string][]interface{}{"o": {"texture", 1.0}},
+			"shader node o (texture): input texture needs a sampler2D, not a float"},
+		{map[string][]interface{}{"o": {"multiply", "s", 2.0}, "s": {"sampler"}},
+			"shader node o (multiply): input a needs a float or vector, but s gives a sampler2D"},
+		{map[string][]interface{}{"o": {"lerp", "uv", "uv", "c"}, "uv": {"uv"}, "c": {"vertexcolor"}},
+			"shader node o (lerp): t must be a float or a vec2, not a vec4"},
+		{map[string][]interface{}{"o": {"add", "x", 1.0}, "x": {"multiply", "o", 2.0}},
+			"shader graph has a cycle through node o"},
+		{map[string][]interface{}{"o": {"add", 1.0}},
+			"shader node o (add): input b is not connected"},
+		{map[string][]interface{}{"o": {"sampler"}},
+			"shader graph output o is a sampler2D, not a colour"},
+		{map[string][]interface{}{"o": {"add", "missing", 1.0}},
+			`unknown shader node "missing"`},
+	} {
+		g := build(t, c.nodes)
+		g.Output = "o"
+		if _, err := Compile(g); err == nil || err.Error() != c.want {
+			t.Errorf("Compile error = %v, want %s", err, c.want)
+		}
+	}
+	if _, err := NewNode("x", "blur"); err == nil {
+		t.Error("NewNode accepted an unknown kind")
+	}
+}
@@ -0,0 +1,134 @@
+// Package srcmap maps line numbers of preprocessed source (after #include / IMPORT splicing) back to the
+// file and line they came from. The compiler, Chunk, error printer and debugger all work on preprocessed
+// lines; a Map turns those into "enemies.bas:42" for users and resolves "file:line" breakpoints.
+package srcmap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Pos is an original source position. File is empty when no map is available (single-file program).
+type Pos struct {
+	File string
+	Line int
+}
+
+// String renders "file:line", or "line N" when the file is unknown (the form used in compiler errors).
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Origin is the source of one preprocessed line: an index into Map.Files and a 1-based line in that file.
+type Origin struct {
+	File int
+	Line int
+}
+
+// Map records the origin of every line of preprocessed source. Files[0] is the main program.
+// All methods accept a nil *Map and then treat preprocessed lines as lines of a single unnamed file.
+type Map struct {
+	Files []string
+	Lines []Origin // Lines[i] is the origin of preprocessed line i+1
+}
+
+// New returns an empty map whose main file is mainFile.
+func New(mainFile string) *Map {
+	return &Map{Files: []string{mainFile}}
+}
+
+// Add appends the origin of the next preprocessed line.
+func (m *Map) Add(file string, line int) {
+	idx := -1
+	for i, f := range m.Files {
+		if f == file {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		idx = len(m.Files)
+		m.Files = append(m.Files, file)
+	}
+	m.Lines = append(m.Lines, Origin{File: idx, Line: line})
+}
+
+// Lookup returns the original position of preprocessed line (1-based). Lines outside the map are returned unchanged.
+func (m *Map) Lookup(line int) Pos {
+	if m == nil || line < 1 || line > len(m.Lines) {
+		return Pos{Line: line}
+	}
+	o := m.Lines[line-1]
+	if o.File < 0 || o.File >= len(m.Files) {
+		return Pos{Line: line}
+	}
+	return Pos{File: m.Files[o.File], Line: o.Line}
+}
+
+// Find returns the preprocessed lines that came from file:line (file "" means the main program).
+// A file matches by exact path, by base name, or as a path suffix, so "enemies.bas" finds "lib/enemies.bas".
+func (m *Map) Find(file string, line int) []int {
+	if m == nil {
+		if file == "" {
+			return []int{line}
+		}
+		return nil
+	}
+	var out []int
+	for i, o := range m.Lines {
+		if o.Line == line && m.fileMatches(o.File, file) {
+			out = append(out, i+1)
+		}
+	}
+	return out
+}
+
+func (m *Map) fileMatches(idx int, file string) bool {
+	if idx < 0 || idx >= len(m.Files) {
+		return false
+	}
+	if file == "" {
+		return idx == 0
+	}
+	have := filepath.ToSlash(filepath.Clean(m.Files[idx]))
+	want := filepath.ToSlash(filepath.Clean(file))
+	return have == want || strings.HasSuffix(have, "/"+want) || filepath.Base(have) == want
+}
+
+// FileText reconstructs the text of file from the preprocessed source, with original line numbers
+// (lines that were not spliced, such as the #include directives themselves, are blank).
+func (m *Map) FileText(preprocessed, file string) string {
+	if m == nil {
+		return preprocessed
+	}
+	src := strings.Split(preprocessed, "\n")
+	var lines []string
+	for i, o := range m.Lines {
+		if i >= len(src) || o.File < 0 || o.File >= len(m.Files) || m.Files[o.File] != file {
+			continue
+		}
+		for len(lines) < o.Line {
+			lines = append(lines, "")
+		}
+		lines[o.Line-1] = src[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseLocation parses a breakpoint location "file:line" or "line" (file "" = main program).
+func ParseLocation(s string) (file string, line int, err error) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		file, s = s[:i], s[i+1:]
+	}
+	line, err = strconv.Atoi(s)
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid line in %q", s)
+	}
+	return file, line, nil
+}
@@ -0,0 +1,74 @@
+package srcmap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func sampleMap() *Map {
+	m := New("game.bas")
+	m.Add("game.bas", 1)
+	m.Add("lib/enemies.bas", 1)
+	m.Add("lib/enemies.bas", 2)
+	m.Add("game.bas", 3)
+	return m
+}
+
+func TestLookup(t *testing.T) {
+	m := sampleMap()
+	if got := m.Lookup(3); got != (Pos{File: "lib/enemies.bas", Line: 2}) {
+		t.Errorf("Lookup(3) = %v", got)
+	}
+	if got := m.Lookup(4); got.String() != "game.bas:3" {
+		t.Errorf("Lookup(4) = %v", got)
+	}
+	if got := m.Lookup(99); got != (Pos{Line: 99}) {
+		t.Errorf("Lookup outside map = %v", got)
+	}
+	var none *Map
+	if got := none.Lookup(5); got.String() != "line 5" {
+		t.Errorf("nil map Lookup = %v", got)
+	}
+}
+
+func TestFind(t *testing.T) {
+	m := sampleMap()
+	for _, tt := range []struct {
+		file string
+		line int
+		want []int
+	}{
+		{"", 3, []int{4}},
+		{"game.bas", 1, []int{1}},
+		{"enemies.bas", 2, []int{3}},
+		{"lib/enemies.bas", 1, []int{2}},
+		{"other.bas", 1, nil},
+	} {
+		if got := m.Find(tt.file, tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q, %d) = %v, want %v", tt.file, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFileText(t *testing.T) {
+	m := sampleMap()
+	pre := "a\nb\nc\nd\n"
+	if got := m.FileText(pre, "game.bas"); got != "a\n\nd" {
+		t.Errorf("FileText(game.bas) = %q", got)
+	}
+	if got := m.FileText(pre, "lib/enemies.bas"); got != "b\nc" {
+		t.Errorf("FileText(lib) = %q", got)
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	if f, l, err := ParseLocation(" lib/enemies.bas:42 "); err != nil || f != "lib/enemies.bas" || l != 42 {
+		t.Errorf("got %q %d %v", f, l, err)
+	}
+	if f, l, err := ParseLocation("7"); err != nil || f != "" || l != 7 {
+		t.Errorf("got %q %d %v", f, l, err)
+	}
+	if _, _, err := ParseLocation("x.bas:zero"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,7 @@
+DIM grid(2, 3)
+grid(1, 2) = 7
+TRY
+  THROW "boom"
+CATCH e
+  PRINT e
+END TRY
@@ -0,0 +1,12 @@
+total = 0
+FOR i = 1 TO 3
+  total = total + i
+NEXT i
+WHILE total > 0
+  total = total - 2
+WEND
+IF total = 0 THEN
+  PRINT "even"
+ELSE
+  PRINT "odd"
+END IF
@@ -0,0 +1,11 @@
+Function Twice(n)
+  Return n * 2
+End Function
+
+Sub Show(label, value)
+  PRINT label + STR(value)
+End Sub
+
+x = Twice(21)
+Show("x = ", x)
+InitWindow(800, 600, "demo")
@@ -1,6 +1,11 @@
 package vm
 
-import "strings"
+import (
+	"cyberbasic/compiler/srcmap"
+	"encoding/binary"
+	"math"
+	"strings"
+)
 
 // OpCode represents bytecode instructions
 type OpCode int
@@ -222,6 +227,48 @@ const (
 	// Sub/Function parameter slots (indices 0..n-1); address stack[frame.stackBase+idx] while in OpCallUser frame.
 	OpLoadParam  // paramIndex (1 byte)
 	OpStoreParam // paramIndex (1 byte); pop value
+
+	// Structured exceptions: OpTry pushes a handler; an error raised before the matching OpEndTry unwinds the
+	// call, GOSUB and value stacks to where OpTry ran, pushes the error object and jumps to the handler.
+	OpTry    // handler target (2 bytes, 4 when wide)
+	OpEndTry // pop the innermost handler
+	OpThrow  // pop value; raise it as an error (a caught error object is rethrown unchanged)
+
+	// Event bus: OpEmit pops argCount args, then the event name, and queues the event for ON handlers.
+	OpEmit // argCount (1 byte)
+
+	// Tasks: OpStartTask starts a coroutine like OpStartCoroutine with argCount popped args as its parameters
+	// and pushes its Task; OpAwait replaces the Awaitable on top with its result, parking the fiber until then.
+	OpStartTask // target (2 bytes, 4 when wide), sub name constant index, argCount (1 byte)
+	OpAwait
+
+	// First-class functions: OpMakeFunction pushes a *Function for the code at target, popping captureCount values
+	// (the enclosing parameters it captures); OpCallValue pops a callee pushed after its argCount args and calls it,
+	// or calls the foreign function name when the callee is not a function value.
+	OpMakeFunction // target (2 bytes, 4 when wide), name constant index, paramCount (1 byte), captureCount (1 byte)
+	OpCallValue    // name constant index, argCount (1 byte)
+
+	// OpNewObject pushes a new instance of the TYPE named by the constant (Chunk.Types) with its fields at their
+	// defaults; OpCallMethod on it calls the user function "type.method" with the instance as ME.
+	OpNewObject // type name constant index
+
+	// Collections: OpMakeList pops count items and pushes a LIST of them; OpMakeMap pops count key/value pairs and
+	// pushes a MAP. OpIndex pops an index and a collection and pushes coll[index]; OpSetIndex pops a value, an index
+	// and a collection and assigns coll[index]. OpIterNew replaces the collection on top with a FOR EACH iterator for
+	// varCount loop variables; OpIterNext pops an iterator and pushes key, value and true, or only false when done.
+	OpMakeList // count (1 byte)
+	OpMakeMap  // pair count (1 byte)
+	OpIndex
+	OpSetIndex
+	OpIterNew // varCount (1 byte)
+	OpIterNext
+
+	// OpWide prefixes the next instruction: its constant, variable and parameter indices and absolute targets are
+	// 4-byte unsigned, and its jump offset is a 4-byte signed int. Counts (args, dims, path length) stay 1 byte.
+	OpWide
+
+	// opCodeCount is the number of opcodes; keep last. Stored in .cbc files to reject chunks from another opcode set.
+	opCodeCount
 )
 
 // Value represents a value in the VM
@@ -233,16 +280,24 @@ type EnumMembers map[string]int64
 // Chunk represents a compiled bytecode chunk
 type Chunk struct {
 	Code      []byte
-	Lines     []int // source line per byte in Code (same length as Code; 0 = unknown)
+	Lines     []int // source line per byte in Code (same length as Code; 0 = unknown); lines of the preprocessed source
 	Constants []Value
 	Variables map[string]int
-	VarDims   map[string][]int // array dimensions per variable (nil = scalar)
-	Functions map[string]int   // user Sub/Function name (lowercase) -> code offset
+	VarDims   map[string][]int    // array dimensions per variable (nil = scalar)
+	Functions map[string]int      // user Sub/Function name (lowercase) -> code offset
+	Params    map[string][]string // user Sub/Function name (lowercase) -> parameter names (for debuggers)
 	// Enums: enum name (lowercase) -> member name (lowercase) -> value; used by Enum.getValue/getName/hasValue at runtime
 	Enums map[string]EnumMembers
+	// Types: TYPE name (lowercase) -> data fields, for OpNewObject
+	Types map[string]*TypeInfo
 	// DataValues holds all DATA values in program order for READ/RESTORE
 	DataValues []Value
+	// SourceMap maps Lines back to the original file and line when the source was spliced from #include / IMPORT (nil = single file)
+	SourceMap   *srcmap.Map
 	currentLine int // used by compiler when emitting; Write records this into Lines
+	// constSlots indexes Constants[:constIndexed] by value for WriteConstant (first index of each value).
+	constSlots   map[Value]int
+	constIndexed int
 }
 
 // NewChunk creates a new bytecode chunk
@@ -254,7 +309,9 @@ func NewChunk() *Chunk {
 		Variables:  make(map[string]int),
 		VarDims:    make(map[string][]int),
 		Functions:  make(map[string]int),
+		Params:     make(map[string][]string),
 		Enums:      make(map[string]EnumMembers),
+		Types:      make(map[string]*TypeInfo),
 		DataValues: make([]Value, 0),
 	}
 }
@@ -272,27 +329,49 @@ func (c *Chunk) LineAt(ip int) int {
 	return c.Lines[ip]
 }
 
+// PosAt returns the original file and line for the given instruction pointer (File is empty without a SourceMap).
+func (c *Chunk) PosAt(ip int) srcmap.Pos {
+	return c.SourceMap.Lookup(c.LineAt(ip))
+}
+
 // Write adds a byte to the chunk and records the current source line for this instruction.
 func (c *Chunk) Write(b byte) {
 	c.Code = append(c.Code, b)
 	c.Lines = append(c.Lines, c.currentLine)
 }
 
-// WriteJumpOffset writes a 2-byte signed offset (int16 little-endian) for backward-compatible long jumps.
-func (c *Chunk) WriteJumpOffset(offset int) {
-	off := int16(offset)
-	c.Code = append(c.Code, byte(off), byte(off>>8))
-	c.Lines = append(c.Lines, c.currentLine, c.currentLine)
+// PatchJump writes a relative jump offset into the operand at position at (as returned by EmitJump).
+// wide selects the 4-byte encoding. It returns false when offset does not fit, so the compiler can retry with wide jumps.
+func (c *Chunk) PatchJump(at int, offset int, wide bool) bool {
+	if wide {
+		if offset < math.MinInt32 || offset > math.MaxInt32 || at+4 > len(c.Code) {
+			return false
+		}
+		binary.LittleEndian.PutUint32(c.Code[at:], uint32(int32(offset)))
+		return true
+	}
+	if offset < math.MinInt16 || offset > math.MaxInt16 || at+2 > len(c.Code) {
+		return false
+	}
+	binary.LittleEndian.PutUint16(c.Code[at:], uint16(int16(offset)))
+	return true
 }
 
-// PatchJumpOffset patches the last 2 bytes at the given position with the given offset (int16).
-func (c *Chunk) PatchJumpOffset(at int, offset int) {
-	off := int16(offset)
-	if at+2 > len(c.Code) {
-		return
+// PatchTarget writes an absolute code offset (OpRegisterEvent handler, OpStartCoroutine target) at position at.
+// It returns false when target does not fit the narrow 2-byte encoding and wide is false.
+func (c *Chunk) PatchTarget(at int, target int, wide bool) bool {
+	if wide {
+		if target < 0 || target > math.MaxUint32 || at+4 > len(c.Code) {
+			return false
+		}
+		binary.LittleEndian.PutUint32(c.Code[at:], uint32(target))
+		return true
+	}
+	if target < 0 || target > math.MaxUint16 || at+2 > len(c.Code) {
+		return false
 	}
-	c.Code[at] = byte(off)
-	c.Code[at+1] = byte(off >> 8)
+	binary.LittleEndian.PutUint16(c.Code[at:], uint16(target))
+	return true
 }
 
 // constantEqual reports whether two pool values are the same for deduplication.
@@ -325,16 +404,49 @@ func constantEqual(a, b Value) bool {
 }
 
 // WriteConstant adds a constant to the chunk and returns its index.
-// Equal values reuse the same index so the 256-entry pool is not exhausted
-// by repeated foreign names or numeric literals.
+// Equal values reuse the same index so indices stay in the 1-byte range (no OpWide prefix) as long as possible.
 func (c *Chunk) WriteConstant(value Value) int {
-	for i, v := range c.Constants {
-		if constantEqual(v, value) {
+	c.indexConstants()
+	key, ok := constantKey(value)
+	if ok {
+		if i, found := c.constSlots[key]; found {
 			return i
 		}
 	}
 	c.Constants = append(c.Constants, value)
-	return len(c.Constants) - 1
+	i := len(c.Constants) - 1
+	if ok {
+		c.constSlots[key] = i
+	}
+	c.constIndexed = len(c.Constants)
+	return i
+}
+
+// indexConstants brings the WriteConstant index up to date with Constants, which ReadChunk and EmitReload fill
+// directly; a pool that was replaced by a shorter one is indexed again from the start.
+func (c *Chunk) indexConstants() {
+	if c.constSlots == nil || c.constIndexed > len(c.Constants) {
+		c.constSlots = make(map[Value]int, len(c.Constants))
+		c.constIndexed = 0
+	}
+	for i := c.constIndexed; i < len(c.Constants); i++ {
+		if key, ok := constantKey(c.Constants[i]); ok {
+			if _, dup := c.constSlots[key]; !dup {
+				c.constSlots[key] = i
+			}
+		}
+	}
+	c.constIndexed = len(c.Constants)
+}
+
+// constantKey returns v as a map key when WriteConstant deduplicates it (the kinds constantEqual compares).
+// The dynamic type is part of an interface key, so 1 and 1.0 stay distinct as they do for constantEqual.
+func constantKey(v Value) (Value, bool) {
+	switch v.(type) {
+	case nil, string, float64, int, int64, bool:
+		return v, true
+	}
+	return nil, false
 }
 
 // AddVariable adds a variable and returns its index (name normalized to lowercase)
@@ -370,3 +482,15 @@ func (c *Chunk) GetFunction(name string) (int, bool) {
 	off, ok := c.Functions[strings.ToLower(name)]
 	return off, ok
 }
+
+// FunctionAt returns the user Sub/Function (lowercase) whose body contains ip, or "" for the main program.
+// Bodies follow the main program in declaration order, so this is the nearest function start at or before ip.
+func (c *Chunk) FunctionAt(ip int) string {
+	name, start := "", -1
+	for fn, off := range c.Functions {
+		if off <= ip && (off > start || off == start && fn < name) {
+			name, start = fn, off
+		}
+	}
+	return name
+}
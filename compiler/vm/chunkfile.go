@@ -0,0 +1,491 @@
+package vm
+
+import (
+	"bufio"
+	"cyberbasic/compiler/srcmap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// ChunkFileMagic starts every precompiled .cbc file.
+const ChunkFileMagic = "CBC\x1a"
+
+// ChunkFileVersion is the current .cbc layout version. Bump when the encoding of any section changes.
+const ChunkFileVersion = 4
+
+// ErrIncompatibleChunk is returned (wrapped) when a .cbc file was built by a compiler with another format or opcode set.
+var ErrIncompatibleChunk = errors.New("incompatible bytecode file")
+
+// Constant pool value tags in .cbc files.
+const (
+	tagNil byte = iota
+	tagBool
+	tagInt
+	tagInt64
+	tagFloat
+	tagString
+)
+
+// ChunkMeta carries build-time facts that are not part of the bytecode (e.g. window mode detected from source).
+// Keys and values are free-form strings; unknown keys are ignored by the loader.
+type ChunkMeta map[string]string
+
+// WriteChunk encodes chunk (and optional meta) in the versioned .cbc binary format.
+// Map sections are written in sorted key order so the same chunk always produces the same bytes.
+func WriteChunk(w io.Writer, chunk *Chunk, meta ChunkMeta) error {
+	if chunk == nil {
+		return fmt.Errorf("write chunk: nil chunk")
+	}
+	if len(chunk.Lines) != len(chunk.Code) {
+		return fmt.Errorf("write chunk: %d line entries for %d code bytes", len(chunk.Lines), len(chunk.Code))
+	}
+	bw := bufio.NewWriter(w)
+	cw := &chunkWriter{w: bw}
+	cw.raw([]byte(ChunkFileMagic))
+	cw.u16(ChunkFileVersion)
+	cw.u16(uint16(opCodeCount))
+	cw.u64(opSetHash())
+
+	cw.bytes(chunk.Code)
+	cw.uvarint(uint64(len(chunk.Lines)))
+	for _, l := range chunk.Lines {
+		cw.uvarint(uint64(l))
+	}
+	cw.uvarint(uint64(len(chunk.Constants)))
+	for _, v := range chunk.Constants {
+		cw.value(v)
+	}
+	cw.uvarint(uint64(len(chunk.Variables)))
+	for _, name := range sortedKeys(chunk.Variables) {
+		cw.str(name)
+		cw.uvarint(uint64(chunk.Variables[name]))
+	}
+	cw.uvarint(uint64(len(chunk.VarDims)))
+	for _, name := range sortedKeys(chunk.VarDims) {
+		dims := chunk.VarDims[name]
+		cw.str(name)
+		cw.uvarint(uint64(len(dims)))
+		for _, d := range dims {
+			cw.varint(int64(d))
+		}
+	}
+	cw.uvarint(uint64(len(chunk.Functions)))
+	for _, name := range sortedKeys(chunk.Functions) {
+		cw.str(name)
+		cw.uvarint(uint64(chunk.Functions[name]))
+	}
+	cw.uvarint(uint64(len(chunk.Enums)))
+	for _, name := range sortedKeys(chunk.Enums) {
+		members := chunk.Enums[name]
+		cw.str(name)
+		cw.uvarint(uint64(len(members)))
+		for _, m := range sortedKeys(members) {
+			cw.str(m)
+			cw.varint(members[m])
+		}
+	}
+	cw.uvarint(uint64(len(chunk.DataValues)))
+	for _, v := range chunk.DataValues {
+		cw.value(v)
+	}
+	cw.uvarint(uint64(len(meta)))
+	for _, k := range sortedKeys(meta) {
+		cw.str(k)
+		cw.str(meta[k])
+	}
+	// Source map (version 2): file table, then one (file, line) origin per preprocessed line; 0 files = no map.
+	if m := chunk.SourceMap; m != nil {
+		cw.uvarint(uint64(len(m.Files)))
+		for _, f := range m.Files {
+			cw.str(f)
+		}
+		cw.uvarint(uint64(len(m.Lines)))
+		for _, o := range m.Lines {
+			cw.uvarint(uint64(o.File))
+			cw.uvarint(uint64(o.Line))
+		}
+	} else {
+		cw.uvarint(0)
+	}
+	// Parameter names (version 3), for debuggers.
+	cw.uvarint(uint64(len(chunk.Params)))
+	for _, name := range sortedKeys(chunk.Params) {
+		params := chunk.Params[name]
+		cw.str(name)
+		cw.uvarint(uint64(len(params)))
+		for _, p := range params {
+			cw.str(p)
+		}
+	}
+	// TYPE layouts (version 4), for OpNewObject.
+	cw.uvarint(uint64(len(chunk.Types)))
+	for _, key := range sortedKeys(chunk.Types) {
+		ti := chunk.Types[key]
+		cw.str(key)
+		cw.str(ti.Name)
+		cw.uvarint(uint64(len(ti.Fields)))
+		for _, f := range ti.Fields {
+			cw.str(f.Name)
+			cw.value(f.Default)
+			cw.str(f.TypeName)
+		}
+	}
+	if cw.err != nil {
+		return fmt.Errorf("write chunk: %w", cw.err)
+	}
+	return bw.Flush()
+}
+
+// ReadChunk decodes a .cbc stream written by WriteChunk. It rejects files whose format version or
+// opcode set differs from this build with an error wrapping ErrIncompatibleChunk.
+func ReadChunk(r io.Reader) (*Chunk, ChunkMeta, error) {
+	cr := &chunkReader{r: bufio.NewReader(r)}
+	magic := cr.raw(len(ChunkFileMagic))
+	if cr.err != nil || string(magic) != ChunkFileMagic {
+		return nil, nil, fmt.Errorf("read chunk: not a CyberBasic bytecode file")
+	}
+	version := cr.u16()
+	ops := cr.u16()
+	opSet := cr.u64()
+	if cr.err != nil {
+		return nil, nil, fmt.Errorf("read chunk: %w", cr.err)
+	}
+	if version != ChunkFileVersion {
+		return nil, nil, fmt.Errorf("%w: format version %d, this build reads version %d (rebuild with --build)", ErrIncompatibleChunk, version, ChunkFileVersion)
+	}
+	if int(ops) != int(opCodeCount) {
+		return nil, nil, fmt.Errorf("%w: built for %d opcodes, this VM has %d (rebuild with --build)", ErrIncompatibleChunk, ops, int(opCodeCount))
+	}
+	if opSet != opSetHash() {
+		return nil, nil, fmt.Errorf("%w: built for another opcode set (rebuild with --build)", ErrIncompatibleChunk)
+	}
+
+	chunk := NewChunk()
+	chunk.Code = cr.bytes()
+	nLines := cr.count()
+	chunk.Lines = make([]int, 0, nLines)
+	for i := 0; i < nLines; i++ {
+		chunk.Lines = append(chunk.Lines, int(cr.uvarint()))
+	}
+	nConst := cr.count()
+	for i := 0; i < nConst; i++ {
+		chunk.Constants = append(chunk.Constants, cr.value())
+	}
+	nVars := cr.count()
+	for i := 0; i < nVars; i++ {
+		name := cr.str()
+		chunk.Variables[name] = int(cr.uvarint())
+	}
+	nDims := cr.count()
+	for i := 0; i < nDims; i++ {
+		name := cr.str()
+		n := cr.count()
+		dims := make([]int, 0, n)
+		for j := 0; j < n; j++ {
+			dims = append(dims, int(cr.varint()))
+		}
+		chunk.VarDims[name] = dims
+	}
+	nFuncs := cr.count()
+	for i := 0; i < nFuncs; i++ {
+		name := cr.str()
+		chunk.Functions[name] = int(cr.uvarint())
+	}
+	nEnums := cr.count()
+	for i := 0; i < nEnums; i++ {
+		name := cr.str()
+		n := cr.count()
+		members := make(EnumMembers, n)
+		for j := 0; j < n; j++ {
+			m := cr.str()
+			members[m] = cr.varint()
+		}
+		chunk.Enums[name] = members
+	}
+	nData := cr.count()
+	for i := 0; i < nData; i++ {
+		chunk.DataValues = append(chunk.DataValues, cr.value())
+	}
+	nMeta := cr.count()
+	meta := make(ChunkMeta, nMeta)
+	for i := 0; i < nMeta; i++ {
+		k := cr.str()
+		meta[k] = cr.str()
+	}
+	if nFiles := cr.count(); nFiles > 0 {
+		m := &srcmap.Map{Files: make([]string, 0, nFiles)}
+		for i := 0; i < nFiles; i++ {
+			m.Files = append(m.Files, cr.str())
+		}
+		nOrigins := cr.count()
+		m.Lines = make([]srcmap.Origin, 0, nOrigins)
+		for i := 0; i < nOrigins; i++ {
+			o := srcmap.Origin{File: int(cr.uvarint()), Line: int(cr.uvarint())}
+			if o.File >= nFiles && cr.err == nil {
+				cr.err = fmt.Errorf("source map file index %d out of range", o.File)
+			}
+			m.Lines = append(m.Lines, o)
+		}
+		chunk.SourceMap = m
+	}
+	nParams := cr.count()
+	for i := 0; i < nParams; i++ {
+		name := cr.str()
+		n := cr.count()
+		params := make([]string, 0, n)
+		for j := 0; j < n; j++ {
+			params = append(params, cr.str())
+		}
+		chunk.Params[name] = params
+	}
+	nTypes := cr.count()
+	for i := 0; i < nTypes; i++ {
+		key := cr.str()
+		ti := &TypeInfo{Name: cr.str()}
+		n := cr.count()
+		for j := 0; j < n; j++ {
+			ti.Fields = append(ti.Fields, FieldInfo{Name: cr.str(), Default: cr.value(), TypeName: cr.str()})
+		}
+		chunk.Types[key] = ti
+	}
+	if cr.err != nil {
+		return nil, nil, fmt.Errorf("read chunk: %w", cr.err)
+	}
+	if len(chunk.Lines) != len(chunk.Code) {
+		return nil, nil, fmt.Errorf("read chunk: %d line entries for %d code bytes", len(chunk.Lines), len(chunk.Code))
+	}
+	for name, off := range chunk.Functions {
+		if off < 0 || off > len(chunk.Code) {
+			return nil, nil, fmt.Errorf("read chunk: function %s offset %d outside code", name, off)
+		}
+	}
+	return chunk, meta, nil
+}
+
+// SaveChunkFile writes chunk to path in .cbc format.
+func SaveChunkFile(path string, chunk *Chunk, meta ChunkMeta) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteChunk(f, chunk, meta); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadChunkFile reads a .cbc file written by SaveChunkFile.
+func LoadChunkFile(path string) (*Chunk, ChunkMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	return ReadChunk(f)
+}
+
+// IsChunkFile reports whether the first bytes of data are the .cbc magic (used to run precompiled files by content).
+func IsChunkFile(data []byte) bool {
+	return len(data) >= len(ChunkFileMagic) && string(data[:len(ChunkFileMagic)]) == ChunkFileMagic
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// chunkWriter is a sticky-error little-endian writer for the .cbc sections.
+type chunkWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (cw *chunkWriter) raw(b []byte) {
+	if cw.err == nil {
+		_, cw.err = cw.w.Write(b)
+	}
+}
+
+func (cw *chunkWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(cw.buf[:2], v)
+	cw.raw(cw.buf[:2])
+}
+
+func (cw *chunkWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(cw.buf[:8], v)
+	cw.raw(cw.buf[:8])
+}
+
+func (cw *chunkWriter) uvarint(v uint64) {
+	n := binary.PutUvarint(cw.buf[:], v)
+	cw.raw(cw.buf[:n])
+}
+
+func (cw *chunkWriter) varint(v int64) {
+	n := binary.PutVarint(cw.buf[:], v)
+	cw.raw(cw.buf[:n])
+}
+
+func (cw *chunkWriter) bytes(b []byte) {
+	cw.uvarint(uint64(len(b)))
+	cw.raw(b)
+}
+
+func (cw *chunkWriter) str(s string) {
+	cw.bytes([]byte(s))
+}
+
+func (cw *chunkWriter) value(v Value) {
+	switch x := v.(type) {
+	case nil:
+		cw.raw([]byte{tagNil})
+	case bool:
+		b := byte(0)
+		if x {
+			b = 1
+		}
+		cw.raw([]byte{tagBool, b})
+	case int:
+		cw.raw([]byte{tagInt})
+		cw.varint(int64(x))
+	case int64:
+		cw.raw([]byte{tagInt64})
+		cw.varint(x)
+	case float64:
+		cw.raw([]byte{tagFloat})
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+		cw.raw(b[:])
+	case string:
+		cw.raw([]byte{tagString})
+		cw.str(x)
+	default:
+		if cw.err == nil {
+			cw.err = fmt.Errorf("constant of type %T cannot be serialized", v)
+		}
+	}
+}
+
+// chunkReader mirrors chunkWriter; after the first error every read returns a zero value.
+type chunkReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+// maxChunkSection bounds section lengths so a corrupt file cannot trigger huge allocations.
+const maxChunkSection = 1 << 28
+
+func (cr *chunkReader) raw(n int) []byte {
+	if cr.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(cr.r, b); err != nil {
+		cr.err = fmt.Errorf("truncated file: %w", err)
+		return nil
+	}
+	return b
+}
+
+func (cr *chunkReader) u16() uint16 {
+	b := cr.raw(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (cr *chunkReader) u64() uint64 {
+	b := cr.raw(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (cr *chunkReader) uvarint() uint64 {
+	if cr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		cr.err = fmt.Errorf("truncated file: %w", err)
+	}
+	return v
+}
+
+func (cr *chunkReader) varint() int64 {
+	if cr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(cr.r)
+	if err != nil {
+		cr.err = fmt.Errorf("truncated file: %w", err)
+	}
+	return v
+}
+
+func (cr *chunkReader) count() int {
+	n := cr.uvarint()
+	if n > maxChunkSection {
+		if cr.err == nil {
+			cr.err = fmt.Errorf("section length %d too large", n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (cr *chunkReader) bytes() []byte {
+	n := cr.count()
+	if cr.err != nil {
+		return nil
+	}
+	return cr.raw(n)
+}
+
+func (cr *chunkReader) str() string {
+	return string(cr.bytes())
+}
+
+func (cr *chunkReader) value() Value {
+	tag := cr.raw(1)
+	if tag == nil {
+		return nil
+	}
+	switch tag[0] {
+	case tagNil:
+		return nil
+	case tagBool:
+		b := cr.raw(1)
+		return b != nil && b[0] != 0
+	case tagInt:
+		return int(cr.varint())
+	case tagInt64:
+		return cr.varint()
+	case tagFloat:
+		b := cr.raw(8)
+		if b == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case tagString:
+		return cr.str()
+	default:
+		if cr.err == nil {
+			cr.err = fmt.Errorf("unknown constant tag %d", tag[0])
+		}
+		return nil
+	}
+}
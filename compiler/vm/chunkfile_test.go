@@ -0,0 +1,151 @@
+package vm
+
+import (
+	"bytes"
+	"cyberbasic/compiler/srcmap"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func sampleChunk() *Chunk {
+	c := NewChunk()
+	c.SetLine(3)
+	c.Write(byte(OpLoadConst))
+	c.Write(byte(c.WriteConstant(1.5)))
+	c.SetLine(4)
+	c.Write(byte(OpStoreVar))
+	c.Write(byte(c.AddVariable("x")))
+	c.Write(byte(OpHalt))
+	c.WriteConstant("hello")
+	c.WriteConstant(int64(-7))
+	c.WriteConstant(42)
+	c.WriteConstant(true)
+	c.WriteConstant(nil)
+	c.SetVarDims("grid", []int{3, 4})
+	c.Functions["update"] = 5
+	c.Params["update"] = []string{"dt"}
+	c.Enums["color"] = EnumMembers{"red": 0, "blue": 2}
+	c.Types["player"] = &TypeInfo{Name: "Player", Fields: []FieldInfo{{Name: "hp", Default: 0}, {Name: "name", Default: ""}, {Name: "pos", TypeName: "vec"}}}
+	c.DataValues = []Value{1.0, "two", false}
+	c.SourceMap = srcmap.New("game.bas")
+	c.SourceMap.Add("game.bas", 1)
+	c.SourceMap.Add("lib/enemies.bas", 1)
+	c.SourceMap.Add("lib/enemies.bas", 2)
+	c.SourceMap.Add("game.bas", 3)
+	return c
+}
+
+func TestChunkFileRoundTrip(t *testing.T) {
+	src := sampleChunk()
+	var buf bytes.Buffer
+	if err := WriteChunk(&buf, src, ChunkMeta{"windowmode": "explicit"}); err != nil {
+		t.Fatal(err)
+	}
+	if !IsChunkFile(buf.Bytes()) {
+		t.Fatal("written file should start with the .cbc magic")
+	}
+	got, meta, err := ReadChunk(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta["windowmode"] != "explicit" {
+		t.Errorf("meta windowmode = %q", meta["windowmode"])
+	}
+	if !bytes.Equal(got.Code, src.Code) || !reflect.DeepEqual(got.Lines, src.Lines) {
+		t.Errorf("code/lines mismatch")
+	}
+	if !reflect.DeepEqual(got.Constants, src.Constants) {
+		t.Errorf("constants = %#v, want %#v", got.Constants, src.Constants)
+	}
+	for _, pair := range [][2]interface{}{
+		{got.Variables, src.Variables},
+		{got.VarDims, src.VarDims},
+		{got.Functions, src.Functions},
+		{got.Params, src.Params},
+		{got.Enums, src.Enums},
+		{got.Types, src.Types},
+		{got.DataValues, src.DataValues},
+		{got.SourceMap, src.SourceMap},
+	} {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("got %#v, want %#v", pair[0], pair[1])
+		}
+	}
+
+	// Deterministic: encoding the decoded chunk gives identical bytes.
+	var again bytes.Buffer
+	if err := WriteChunk(&again, got, meta); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Error("re-encoded chunk differs from original encoding")
+	}
+}
+
+func TestChunkFileRunsLikeSource(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteChunk(&buf, sampleChunk(), nil); err != nil {
+		t.Fatal(err)
+	}
+	chunk, _, err := ReadChunk(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVM()
+	v.LoadChunk(chunk)
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if x, ok := v.WatchValue("x"); !ok || x != 1.5 {
+		t.Errorf("x = %v, want 1.5", x)
+	}
+}
+
+func TestChunkFileRejectsIncompatible(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteChunk(&buf, sampleChunk(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	hdr := len(ChunkFileMagic)
+
+	badVersion := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(badVersion[hdr:], ChunkFileVersion+1)
+	if _, _, err := ReadChunk(bytes.NewReader(badVersion)); !errors.Is(err, ErrIncompatibleChunk) {
+		t.Errorf("wrong version: err = %v, want ErrIncompatibleChunk", err)
+	}
+
+	badOps := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(badOps[hdr+2:], uint16(opCodeCount)-1)
+	if _, _, err := ReadChunk(bytes.NewReader(badOps)); !errors.Is(err, ErrIncompatibleChunk) {
+		t.Errorf("wrong opcode count: err = %v, want ErrIncompatibleChunk", err)
+	}
+
+	// Same opcode count, different opcode set (an opcode inserted or moved).
+	badSet := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(badSet[hdr+4:], opSetHash()+1)
+	if _, _, err := ReadChunk(bytes.NewReader(badSet)); !errors.Is(err, ErrIncompatibleChunk) {
+		t.Errorf("reordered opcode set: err = %v, want ErrIncompatibleChunk", err)
+	}
+
+	if _, _, err := ReadChunk(bytes.NewReader([]byte("REM not bytecode"))); err == nil {
+		t.Error("expected error for non-bytecode input")
+	}
+	if _, _, err := ReadChunk(bytes.NewReader(data[:len(data)-3])); err == nil {
+		t.Error("expected error for truncated file")
+	}
+}
+
+func TestEveryOpcodeHasAName(t *testing.T) {
+	seen := make(map[string]OpCode)
+	for op, name := range opNames {
+		if name == "" {
+			t.Errorf("opcode %d has no name in opNames", op)
+		} else if prev, dup := seen[name]; dup {
+			t.Errorf("opcodes %d and %d are both named %s", prev, op, name)
+		}
+		seen[name] = OpCode(op)
+	}
+}
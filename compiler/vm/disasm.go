@@ -0,0 +1,243 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// String returns the opcode name used in disassembly listings ("LoadConst" for OpLoadConst).
+func (op OpCode) String() string {
+	if op >= 0 && op < opCodeCount && opNames[op] != "" {
+		return opNames[op]
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Instruction is one decoded instruction. Offset and Size include the OpWide prefix when Wide is set.
+type Instruction struct {
+	Offset   int
+	Size     int
+	Op       OpCode
+	Wide     bool
+	Kinds    []OperandKind // one per operand (constant lists expanded)
+	Operands []int
+}
+
+// JumpTarget returns the absolute code offset the i-th operand points at (jumps are relative to the next instruction).
+func (in Instruction) JumpTarget(i int) int {
+	if in.Kinds[i] == OperandJump {
+		return in.Offset + in.Size + in.Operands[i]
+	}
+	return in.Operands[i]
+}
+
+// DecodeInstruction decodes the instruction at offset using the same operand layout as Emit.
+func (c *Chunk) DecodeInstruction(offset int) (Instruction, error) {
+	in := Instruction{Offset: offset}
+	pos := offset
+	if pos >= len(c.Code) {
+		return in, fmt.Errorf("offset %d past end of code", offset)
+	}
+	in.Op = OpCode(c.Code[pos])
+	pos++
+	if in.Op == OpWide {
+		if pos >= len(c.Code) {
+			return in, fmt.Errorf("unexpected end of code after OpWide at %d", offset)
+		}
+		in.Wide = true
+		in.Op = OpCode(c.Code[pos])
+		pos++
+		if in.Op == OpWide {
+			return in, fmt.Errorf("OpWide cannot prefix OpWide at %d", offset)
+		}
+	}
+	if in.Op >= opCodeCount {
+		return in, fmt.Errorf("unknown opcode %d at %d", int(in.Op), offset)
+	}
+	read := func(k OperandKind) (int, error) {
+		n := operandWidth(k, in.Wide)
+		if pos+n > len(c.Code) {
+			return 0, fmt.Errorf("unexpected end of code in %s at %d", in.Op, offset)
+		}
+		var v int
+		switch {
+		case n == 1:
+			v = int(c.Code[pos])
+		case n == 2 && k == OperandJump:
+			v = int(int16(binary.LittleEndian.Uint16(c.Code[pos:])))
+		case n == 2:
+			v = int(binary.LittleEndian.Uint16(c.Code[pos:]))
+		case k == OperandJump:
+			v = int(int32(binary.LittleEndian.Uint32(c.Code[pos:])))
+		default:
+			v = int(binary.LittleEndian.Uint32(c.Code[pos:]))
+		}
+		pos += n
+		return v, nil
+	}
+	for _, k := range opOperands[in.Op] {
+		count := 1
+		if k == OperandConstList {
+			count, k = in.Operands[len(in.Operands)-1], OperandConst
+		}
+		for ; count > 0; count-- {
+			v, err := read(k)
+			if err != nil {
+				return in, err
+			}
+			in.Kinds = append(in.Kinds, k)
+			in.Operands = append(in.Operands, v)
+		}
+	}
+	in.Size = pos - offset
+	return in, nil
+}
+
+// namedCalls are the opcodes whose first operand is the constant holding the called name and whose count is an argument count.
+var namedCalls = map[OpCode]bool{OpCallForeign: true, OpCallUser: true, OpGosub: true, OpCallMethod: true}
+
+// Disassemble writes a listing of c: one instruction per line with its offset, source line ("|" when unchanged,
+// file:line inside #include files), opcode, raw operands and decoded operands (constant values, variable and
+// parameter names, jump targets, called names). Sub/Function bodies are headed by their name and parameters.
+// Undecodable bytes are listed and the listing stops; only write errors are returned.
+func Disassemble(w io.Writer, c *Chunk) error {
+	vars := make(map[int]string, len(c.Variables))
+	for name, idx := range c.Variables {
+		vars[idx] = name
+	}
+	starts := make(map[int][]string)
+	for name, off := range c.Functions {
+		starts[off] = append(starts[off], name)
+	}
+	for _, names := range starts {
+		sort.Strings(names)
+	}
+
+	if _, err := fmt.Fprintf(w, "; %d bytes, %d constants, %d variables, %d functions\nmain:\n",
+		len(c.Code), len(c.Constants), len(c.Variables), len(c.Functions)); err != nil {
+		return err
+	}
+	width := 4
+	for off := range c.Code {
+		if n := len(c.listingPos(off)); n > width {
+			width = n
+		}
+	}
+	fn, lastPos := "", ""
+	for off := 0; off < len(c.Code); {
+		for _, name := range starts[off] {
+			fn = name
+			lastPos = ""
+			if _, err := fmt.Fprintf(w, "\n%s(%s):\n", name, strings.Join(c.Params[name], ", ")); err != nil {
+				return err
+			}
+		}
+		pos := c.listingPos(off)
+		col := pos
+		if pos == lastPos {
+			col = "|"
+		}
+		lastPos = pos
+		in, err := c.DecodeInstruction(off)
+		if err != nil {
+			_, err = fmt.Fprintf(w, "%04d %*s  <%v>\n", off, width, col, err)
+			return err
+		}
+		text := c.formatInstruction(in, vars, fn, starts)
+		if _, err := fmt.Fprintf(w, "%04d %*s  %s\n", off, width, col, text); err != nil {
+			return err
+		}
+		off += in.Size
+	}
+	return nil
+}
+
+// listingPos is the source position column: the line in the main program, file:line in an included file, "-" if unknown.
+func (c *Chunk) listingPos(off int) string {
+	line := c.LineAt(off)
+	if line == 0 {
+		return "-"
+	}
+	pos := c.PosAt(off)
+	if pos.File == "" || pos.File == c.SourceMap.Files[0] {
+		return fmt.Sprint(pos.Line)
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(pos.File), pos.Line)
+}
+
+func (c *Chunk) formatInstruction(in Instruction, vars map[int]string, fn string, starts map[int][]string) string {
+	name := in.Op.String()
+	if in.Wide {
+		name += ".w"
+	}
+	raw := make([]string, len(in.Operands))
+	var notes []string
+	for i, v := range in.Operands {
+		raw[i] = fmt.Sprint(v)
+		switch in.Kinds[i] {
+		case OperandConst:
+			if i == 0 && namedCalls[in.Op] {
+				notes = append(notes, c.constName(v))
+			} else {
+				notes = append(notes, c.constText(v))
+			}
+		case OperandVar:
+			if n, ok := vars[v]; ok {
+				notes = append(notes, n)
+			} else {
+				notes = append(notes, fmt.Sprintf("<var %d>", v))
+			}
+		case OperandParam:
+			if p := c.Params[fn]; v < len(p) {
+				notes = append(notes, p[v])
+			} else {
+				notes = append(notes, fmt.Sprintf("<param %d>", v))
+			}
+		case OperandCount:
+			if namedCalls[in.Op] {
+				if v == 1 {
+					notes = append(notes, "(1 arg)")
+				} else {
+					notes = append(notes, fmt.Sprintf("(%d args)", v))
+				}
+			}
+		case OperandJump, OperandTarget:
+			target := in.JumpTarget(i)
+			note := fmt.Sprintf("-> %04d", target)
+			if names := starts[target]; len(names) > 0 && in.Kinds[i] == OperandTarget {
+				note += " " + names[0]
+			}
+			notes = append(notes, note)
+		}
+	}
+	line := fmt.Sprintf("%-16s %s", name, strings.Join(raw, " "))
+	if len(notes) > 0 {
+		line = fmt.Sprintf("%-30s ; %s", line, strings.Join(notes, " "))
+	}
+	return strings.TrimRight(line, " ")
+}
+
+// constText renders constant idx as a literal (strings quoted).
+func (c *Chunk) constText(idx int) string {
+	if idx < 0 || idx >= len(c.Constants) {
+		return fmt.Sprintf("<const %d>", idx)
+	}
+	if s, ok := c.Constants[idx].(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", c.Constants[idx])
+}
+
+// constName renders constant idx holding a called name, unquoted.
+func (c *Chunk) constName(idx int) string {
+	if idx >= 0 && idx < len(c.Constants) {
+		if s, ok := c.Constants[idx].(string); ok {
+			return s
+		}
+	}
+	return c.constText(idx)
+}
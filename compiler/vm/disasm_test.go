@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+func TestOpCodeNames(t *testing.T) {
+	if OpCallForeign.String() != "CallForeign" || OpCode(999).String() != "Op(999)" {
+		t.Errorf("names: %s, %s", OpCallForeign, OpCode(999))
+	}
+}
+
+func TestDisassembleDecodesOperands(t *testing.T) {
+	c := NewChunk()
+	for i := 0; i < 300; i++ {
+		c.WriteConstant(float64(i))
+	}
+	name := c.WriteConstant("DrawText")
+	x := c.AddVariable("x")
+	c.Functions["twice"] = 0
+	c.Params["twice"] = []string{"n"}
+	m := srcmap.New("main.bas")
+	m.Add("lib/util.bas", 7)
+	m.Add("main.bas", 2)
+	c.SourceMap = m
+	c.SetLine(1)
+	_ = c.Emit(OpLoadParam, 0)
+	_ = c.Emit(OpLoadConst, 299) // wide
+	c.SetLine(2)
+	_ = c.Emit(OpStoreVar, x)
+	_ = c.Emit(OpCallForeign, name, 3)
+	_ = c.Emit(OpJump, -18)
+	c.SetLine(0)
+	c.Write(byte(OpHalt))
+
+	var out bytes.Buffer
+	if err := Disassemble(&out, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"twice(n):",
+		"0000 util.bas:7  LoadParam        0             ; n",
+		"0002          |  LoadConst.w      299           ; 299",
+		"0008          2  StoreVar         0             ; x",
+		"0010          |  CallForeign.w    300 3         ; DrawText (3 args)",
+		"0017          |  Jump             -18           ; -> 0002",
+		"0020          -  Halt",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("listing missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDisassembleTruncatedCode(t *testing.T) {
+	c := NewChunk()
+	c.Write(byte(OpLoadConst))
+	var out bytes.Buffer
+	if err := Disassemble(&out, c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<unexpected end of code in LoadConst at 0>") {
+		t.Errorf("listing = %q", out.String())
+	}
+	if _, err := c.DecodeInstruction(5); err == nil {
+		t.Error("decoding past the end should fail")
+	}
+}
@@ -0,0 +1,271 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// OperandKind describes how one instruction operand is encoded and whether OpWide widens it.
+type OperandKind uint8
+
+const (
+	OperandConst     OperandKind = iota + 1 // constant pool index: 1 byte, 4 bytes when wide
+	OperandVar                              // variable slot index: 1 byte, 4 bytes when wide
+	OperandParam                            // Sub/Function parameter index: 1 byte, 4 bytes when wide
+	OperandCount                            // argument / dimension / path count: always 1 byte
+	OperandJump                             // relative jump offset from the end of the instruction: int16, int32 when wide
+	OperandTarget                           // absolute code offset: uint16, uint32 when wide
+	OperandByte                             // raw byte literal (OpPush): always 1 byte
+	OperandConstList                        // one constant index per unit of the preceding OperandCount
+)
+
+// opOperands lists the operands of every opcode that has any; opcodes not listed take none.
+var opOperands = map[OpCode][]OperandKind{
+	OpPush:            {OperandByte},
+	OpLoadVar:         {OperandVar},
+	OpStoreVar:        {OperandVar},
+	OpLoadGlobal:      {OperandConst},
+	OpStoreGlobal:     {OperandConst},
+	OpLoadEntityProp:  {OperandConst, OperandConst},
+	OpStoreEntityProp: {OperandConst, OperandConst},
+	OpLoadConst:       {OperandConst},
+	OpLoadString:      {OperandConst},
+	OpJump:            {OperandJump},
+	OpJumpIfFalse:     {OperandJump},
+	OpJumpIfTrue:      {OperandJump},
+	OpCall:            {OperandCount},
+	OpMatMul:          {OperandConst, OperandConst, OperandConst},
+	OpCreateArray:     {OperandCount, OperandConstList, OperandVar},
+	OpLoadArray:       {OperandVar},
+	OpStoreArray:      {OperandVar},
+	OpResizeArray:     {OperandCount, OperandVar},
+	OpAppendArray:     {OperandVar},
+	OpCallForeign:     {OperandConst, OperandCount},
+	OpCallUser:        {OperandConst, OperandCount},
+	OpRegisterEvent:   {OperandConst, OperandConst, OperandTarget},
+	OpStartCoroutine:  {OperandTarget, OperandConst},
+	OpRead:            {OperandVar},
+	OpGosub:           {OperandConst, OperandCount},
+	OpGetProp:         {OperandCount, OperandConstList},
+	OpSetProp:         {OperandCount, OperandConstList},
+	OpCallMethod:      {OperandConst, OperandCount},
+	OpLoadParam:       {OperandParam},
+	OpStoreParam:      {OperandParam},
+	OpTry:             {OperandTarget},
+	OpEmit:            {OperandCount},
+	OpStartTask:       {OperandTarget, OperandConst, OperandCount},
+	OpMakeFunction:    {OperandTarget, OperandConst, OperandCount, OperandCount},
+	OpCallValue:       {OperandConst, OperandCount},
+	OpNewObject:       {OperandConst},
+	OpMakeList:        {OperandCount},
+	OpMakeMap:         {OperandCount},
+	OpIterNew:         {OperandCount},
+}
+
+// Operands returns the operand layout of op (nil when it takes none).
+func Operands(op OpCode) []OperandKind {
+	return opOperands[op]
+}
+
+// operandWidth returns the encoded size in bytes of one operand of kind k.
+func operandWidth(k OperandKind, wide bool) int {
+	switch k {
+	case OperandCount, OperandByte:
+		return 1
+	case OperandJump, OperandTarget:
+		if wide {
+			return 4
+		}
+		return 2
+	default:
+		if wide {
+			return 4
+		}
+		return 1
+	}
+}
+
+// expandOperands resolves OperandConstList against the operand values so each value has one kind.
+func expandOperands(op OpCode, operands []int) ([]OperandKind, error) {
+	layout := opOperands[op]
+	kinds := make([]OperandKind, 0, len(operands))
+	i := 0
+	for li, k := range layout {
+		if k != OperandConstList {
+			kinds = append(kinds, k)
+			i++
+			continue
+		}
+		if li == 0 || layout[li-1] != OperandCount || i == 0 {
+			return nil, fmt.Errorf("opcode %d: constant list without count", op)
+		}
+		if i > len(operands) {
+			return nil, fmt.Errorf("opcode %d: missing count operand", op)
+		}
+		count := operands[i-1]
+		if count < 0 {
+			return nil, fmt.Errorf("opcode %d: negative count %d", op, count)
+		}
+		for n := 0; n < count; n++ {
+			kinds = append(kinds, OperandConst)
+		}
+		i += count
+	}
+	if len(kinds) != len(operands) {
+		return nil, fmt.Errorf("opcode %d takes %d operands, got %d", op, len(kinds), len(operands))
+	}
+	return kinds, nil
+}
+
+// Emit writes op and its operands. Index and target operands that do not fit the 1/2-byte encoding make Emit prefix
+// OpWide; counts above 255 are an error (never silently truncated).
+func (c *Chunk) Emit(op OpCode, operands ...int) error {
+	return c.emit(op, false, operands)
+}
+
+// EmitWide is Emit with the OpWide prefix forced, for instructions whose jump/target operand is patched later.
+func (c *Chunk) EmitWide(op OpCode, operands ...int) error {
+	return c.emit(op, true, operands)
+}
+
+// EmitJump writes a jump-style instruction (op's operands with a zero jump/target placeholder) and returns the
+// position of the placeholder for PatchJump / PatchTarget, and whether the instruction was emitted wide (another
+// operand may force OpWide even when wide is false).
+func (c *Chunk) EmitJump(op OpCode, wide bool, operands ...int) (pos int, isWide bool, err error) {
+	kinds, err := expandOperands(op, operands)
+	if err != nil {
+		return 0, false, err
+	}
+	start := len(c.Code)
+	if err := c.emit(op, wide, operands); err != nil {
+		return 0, false, err
+	}
+	isWide = OpCode(c.Code[start]) == OpWide
+	pos = len(c.Code)
+	for i := len(kinds) - 1; i >= 0; i-- {
+		pos -= operandWidth(kinds[i], isWide)
+		if kinds[i] == OperandJump || kinds[i] == OperandTarget {
+			return pos, isWide, nil
+		}
+	}
+	return 0, false, fmt.Errorf("opcode %d has no jump operand", op)
+}
+
+func (c *Chunk) emit(op OpCode, wide bool, operands []int) error {
+	kinds, err := expandOperands(op, operands)
+	if err != nil {
+		return err
+	}
+	for i, k := range kinds {
+		v := operands[i]
+		switch k {
+		case OperandCount, OperandByte:
+			if v < 0 || v > math.MaxUint8 {
+				return fmt.Errorf("operand %d out of range 0..255 for opcode %d", v, op)
+			}
+		case OperandJump:
+			if v < math.MinInt16 || v > math.MaxInt16 {
+				wide = true
+			}
+			if v < math.MinInt32 || v > math.MaxInt32 {
+				return fmt.Errorf("jump offset %d out of range", v)
+			}
+		case OperandTarget:
+			if v < 0 || v > math.MaxUint32 {
+				return fmt.Errorf("code offset %d out of range", v)
+			}
+			if v > math.MaxUint16 {
+				wide = true
+			}
+		default:
+			if v < 0 || v > math.MaxUint32 {
+				return fmt.Errorf("index %d out of range", v)
+			}
+			if v > math.MaxUint8 {
+				wide = true
+			}
+		}
+	}
+	if wide {
+		c.Write(byte(OpWide))
+	}
+	c.Write(byte(op))
+	var buf [4]byte
+	for i, k := range kinds {
+		v := operands[i]
+		switch operandWidth(k, wide) {
+		case 1:
+			c.Write(byte(v))
+		case 2:
+			binary.LittleEndian.PutUint16(buf[:2], uint16(v))
+			c.Write(buf[0])
+			c.Write(buf[1])
+		case 4:
+			binary.LittleEndian.PutUint32(buf[:], uint32(v))
+			for _, b := range buf {
+				c.Write(b)
+			}
+		}
+	}
+	return nil
+}
+
+// readIndex reads a constant / variable / parameter index operand (1 byte, 4 bytes when wide).
+func (vm *VM) readIndex(wide bool) (int, error) {
+	if !wide {
+		return vm.readCount()
+	}
+	if vm.ip+4 > len(vm.chunk.Code) {
+		return 0, fmt.Errorf("unexpected end of code")
+	}
+	v := binary.LittleEndian.Uint32(vm.chunk.Code[vm.ip:])
+	vm.ip += 4
+	return int(v), nil
+}
+
+// readCount reads a 1-byte operand (argument counts, dimension counts, OpPush literals).
+func (vm *VM) readCount() (int, error) {
+	if vm.ip >= len(vm.chunk.Code) {
+		return 0, fmt.Errorf("unexpected end of code")
+	}
+	v := int(vm.chunk.Code[vm.ip])
+	vm.ip++
+	return v, nil
+}
+
+// readJump reads a relative jump offset (int16, int32 when wide).
+func (vm *VM) readJump(wide bool) (int, error) {
+	if wide {
+		if vm.ip+4 > len(vm.chunk.Code) {
+			return 0, fmt.Errorf("unexpected end of code for jump")
+		}
+		v := int32(binary.LittleEndian.Uint32(vm.chunk.Code[vm.ip:]))
+		vm.ip += 4
+		return int(v), nil
+	}
+	if vm.ip+2 > len(vm.chunk.Code) {
+		return 0, fmt.Errorf("unexpected end of code for jump")
+	}
+	v := int16(binary.LittleEndian.Uint16(vm.chunk.Code[vm.ip:]))
+	vm.ip += 2
+	return int(v), nil
+}
+
+// readTarget reads an absolute code offset (uint16, uint32 when wide).
+func (vm *VM) readTarget(wide bool) (int, error) {
+	if wide {
+		if vm.ip+4 > len(vm.chunk.Code) {
+			return 0, fmt.Errorf("unexpected end of code for target")
+		}
+		v := binary.LittleEndian.Uint32(vm.chunk.Code[vm.ip:])
+		vm.ip += 4
+		return int(v), nil
+	}
+	if vm.ip+2 > len(vm.chunk.Code) {
+		return 0, fmt.Errorf("unexpected end of code for target")
+	}
+	v := binary.LittleEndian.Uint16(vm.chunk.Code[vm.ip:])
+	vm.ip += 2
+	return int(v), nil
+}
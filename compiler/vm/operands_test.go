@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"testing"
+)
+
+func TestEmitWidensLargeIndices(t *testing.T) {
+	c := NewChunk()
+	for i := 0; i < 300; i++ {
+		c.WriteConstant(float64(i))
+	}
+	x := c.AddVariable("x")
+	if err := c.Emit(OpLoadConst, 299); err != nil {
+		t.Fatal(err)
+	}
+	if OpCode(c.Code[0]) != OpWide {
+		t.Fatalf("constant index 299 should be emitted with OpWide, got opcode %d", c.Code[0])
+	}
+	if err := c.Emit(OpStoreVar, x); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(byte(OpHalt))
+
+	v := NewVM()
+	v.LoadChunk(c)
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := v.WatchValue("x"); !ok || got != 299.0 {
+		t.Errorf("x = %v, want 299", got)
+	}
+}
+
+func TestEmitRejectsOversizedCount(t *testing.T) {
+	c := NewChunk()
+	if err := c.Emit(OpCallForeign, 0, 256); err == nil {
+		t.Error("expected error for 256 call arguments")
+	}
+	if err := c.Emit(OpLoadConst); err == nil {
+		t.Error("expected error for missing operand")
+	}
+	for _, ops := range [][]int{nil, {}, {2}, {-1, 0}} {
+		if err := c.Emit(OpCreateArray, ops...); err == nil {
+			t.Errorf("Emit(OpCreateArray, %v): expected error", ops)
+		}
+	}
+}
+
+func TestWideJumpAndPatch(t *testing.T) {
+	c := NewChunk()
+	pos, wide, err := c.EmitJump(OpJump, true, 0)
+	if err != nil || !wide {
+		t.Fatalf("EmitJump: pos=%d wide=%v err=%v", pos, wide, err)
+	}
+	if c.PatchJump(pos, 40000, false) {
+		t.Error("narrow patch of offset 40000 should fail")
+	}
+	if !c.PatchJump(pos, 1, true) {
+		t.Fatal("wide patch failed")
+	}
+	c.Write(byte(OpHalt)) // skipped by the jump
+	x := c.AddVariable("x")
+	if err := c.Emit(OpLoadConst, c.WriteConstant(7.0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Emit(OpStoreVar, x); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(byte(OpHalt))
+
+	v := NewVM()
+	v.LoadChunk(c)
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := v.WatchValue("x"); got != 7.0 {
+		t.Errorf("x = %v, want 7 (wide jump not taken?)", got)
+	}
+}
+
+func TestWriteConstantDedupes(t *testing.T) {
+	c := NewChunk()
+	for i := 0; i < 50000; i++ {
+		if got := c.WriteConstant(i); got != i {
+			t.Fatalf("WriteConstant(%d) = %d", i, got)
+		}
+	}
+	if got := c.WriteConstant(123); got != 123 {
+		t.Errorf("WriteConstant(123) again = %d, want 123", got)
+	}
+	if got := c.WriteConstant(123.0); got != 50000 {
+		t.Errorf("WriteConstant(123.0) = %d, want a new slot (50000)", got)
+	}
+
+	// A pool filled directly, as ReadChunk and EmitReload do, is indexed before the next write.
+	d := NewChunk()
+	d.Constants = append(d.Constants, "a", nil, "b")
+	if got := d.WriteConstant("b"); got != 2 {
+		t.Errorf(`WriteConstant("b") = %d, want 2`, got)
+	}
+	if got := d.WriteConstant(nil); got != 1 {
+		t.Errorf("WriteConstant(nil) = %d, want 1", got)
+	}
+	d.Constants = []Value{"z"}
+	if got := d.WriteConstant("z"); got != 0 {
+		t.Errorf(`WriteConstant("z") after replacing the pool = %d, want 0`, got)
+	}
+}
@@ -0,0 +1,205 @@
+package vm
+
+import "hash/fnv"
+
+// opNames are the opcodes' names (the constant name without the Op prefix), as shown in disassembly listings.
+// They are part of the opcode set a .cbc file is checked against, so every opcode needs one.
+var opNames = [opCodeCount]string{
+	OpPush:                    "Push",
+	OpPop:                     "Pop",
+	OpDup:                     "Dup",
+	OpSwap:                    "Swap",
+	OpLoadVar:                 "LoadVar",
+	OpStoreVar:                "StoreVar",
+	OpLoadGlobal:              "LoadGlobal",
+	OpStoreGlobal:             "StoreGlobal",
+	OpLoadEntityProp:          "LoadEntityProp",
+	OpStoreEntityProp:         "StoreEntityProp",
+	OpLoadConst:               "LoadConst",
+	OpLoadString:              "LoadString",
+	OpAdd:                     "Add",
+	OpSub:                     "Sub",
+	OpMul:                     "Mul",
+	OpDiv:                     "Div",
+	OpMod:                     "Mod",
+	OpPower:                   "Power",
+	OpIntDiv:                  "IntDiv",
+	OpNeg:                     "Neg",
+	OpEqual:                   "Equal",
+	OpNotEqual:                "NotEqual",
+	OpLess:                    "Less",
+	OpLessEqual:               "LessEqual",
+	OpGreater:                 "Greater",
+	OpGreaterEqual:            "GreaterEqual",
+	OpAnd:                     "And",
+	OpOr:                      "Or",
+	OpXor:                     "Xor",
+	OpNot:                     "Not",
+	OpJump:                    "Jump",
+	OpJumpIfFalse:             "JumpIfFalse",
+	OpJumpIfTrue:              "JumpIfTrue",
+	OpCall:                    "Call",
+	OpReturn:                  "Return",
+	OpReturnVal:               "ReturnVal",
+	OpPrint:                   "Print",
+	OpStr:                     "Str",
+	OpInitGraphics3D:          "InitGraphics3D",
+	OpBegin3DMode:             "Begin3DMode",
+	OpEnd3DMode:               "End3DMode",
+	OpDrawModel3D:             "DrawModel3D",
+	OpDrawGrid3D:              "DrawGrid3D",
+	OpDrawAxes3D:              "DrawAxes3D",
+	OpCreatePhysicsWorld2D:    "CreatePhysicsWorld2D",
+	OpDestroyPhysicsWorld2D:   "DestroyPhysicsWorld2D",
+	OpStepPhysics2D:           "StepPhysics2D",
+	OpCreatePhysicsBody2D:     "CreatePhysicsBody2D",
+	OpDestroyPhysicsBody2D:    "DestroyPhysicsBody2D",
+	OpSetPhysicsPosition2D:    "SetPhysicsPosition2D",
+	OpGetPhysicsPosition2D:    "GetPhysicsPosition2D",
+	OpSetPhysicsAngle2D:       "SetPhysicsAngle2D",
+	OpGetPhysicsAngle2D:       "GetPhysicsAngle2D",
+	OpSetPhysicsVelocity2D:    "SetPhysicsVelocity2D",
+	OpGetPhysicsVelocity2D:    "GetPhysicsVelocity2D",
+	OpApplyPhysicsForce2D:     "ApplyPhysicsForce2D",
+	OpApplyPhysicsImpulse2D:   "ApplyPhysicsImpulse2D",
+	OpSetPhysicsDensity2D:     "SetPhysicsDensity2D",
+	OpSetPhysicsFriction2D:    "SetPhysicsFriction2D",
+	OpSetPhysicsRestitution2D: "SetPhysicsRestitution2D",
+	OpRayCast2D:               "RayCast2D",
+	OpCheckCollision2D:        "CheckCollision2D",
+	OpQueryAABB2D:             "QueryAABB2D",
+	OpCreatePhysicsWorld3D:    "CreatePhysicsWorld3D",
+	OpDestroyPhysicsWorld3D:   "DestroyPhysicsWorld3D",
+	OpStepPhysics3D:           "StepPhysics3D",
+	OpCreatePhysicsBody3D:     "CreatePhysicsBody3D",
+	OpDestroyPhysicsBody3D:    "DestroyPhysicsBody3D",
+	OpSetPhysicsPosition3D:    "SetPhysicsPosition3D",
+	OpGetPhysicsPosition3D:    "GetPhysicsPosition3D",
+	OpSetPhysicsRotation3D:    "SetPhysicsRotation3D",
+	OpGetPhysicsRotation3D:    "GetPhysicsRotation3D",
+	OpSetPhysicsVelocity3D:    "SetPhysicsVelocity3D",
+	OpGetPhysicsVelocity3D:    "GetPhysicsVelocity3D",
+	OpApplyPhysicsForce3D:     "ApplyPhysicsForce3D",
+	OpApplyPhysicsImpulse3D:   "ApplyPhysicsImpulse3D",
+	OpSetPhysicsMass3D:        "SetPhysicsMass3D",
+	OpCheckCollision3D:        "CheckCollision3D",
+	OpQueryAABB3D:             "QueryAABB3D",
+	OpLoadImage:               "LoadImage",
+	OpCreateSprite:            "CreateSprite",
+	OpSetSpritePosition:       "SetSpritePosition",
+	OpDrawSprite:              "DrawSprite",
+	OpLoadModel:               "LoadModel",
+	OpCreateCamera:            "CreateCamera",
+	OpSetCameraPosition:       "SetCameraPosition",
+	OpDrawModel:               "DrawModel",
+	OpPlayMusic:               "PlayMusic",
+	OpPlaySound:               "PlaySound",
+	OpLoadSound:               "LoadSound",
+	OpCreatePhysicsBody:       "CreatePhysicsBody",
+	OpSetVelocity:             "SetVelocity",
+	OpApplyForce:              "ApplyForce",
+	OpRayCast3D:               "RayCast3D",
+	OpSync:                    "Sync",
+	OpShouldClose:             "ShouldClose",
+	OpRandom:                  "Random",
+	OpRandomN:                 "RandomN",
+	OpSleep:                   "Sleep",
+	OpInt:                     "Int",
+	OpTimer:                   "Timer",
+	OpResetTimer:              "ResetTimer",
+	OpSin:                     "Sin",
+	OpCos:                     "Cos",
+	OpTan:                     "Tan",
+	OpSqrt:                    "Sqrt",
+	OpAbs:                     "Abs",
+	OpLerp:                    "Lerp",
+	OpNoise2D:                 "Noise2D",
+	OpFloor:                   "Floor",
+	OpCeil:                    "Ceil",
+	OpRound:                   "Round",
+	OpMin:                     "Min",
+	OpMax:                     "Max",
+	OpClamp:                   "Clamp",
+	OpPow:                     "Pow",
+	OpExp:                     "Exp",
+	OpLog:                     "Log",
+	OpLog10:                   "Log10",
+	OpAtan2:                   "Atan2",
+	OpSign:                    "Sign",
+	OpDeg2Rad:                 "Deg2Rad",
+	OpRad2Deg:                 "Rad2Deg",
+	OpDistance2D:              "Distance2D",
+	OpDistance3D:              "Distance3D",
+	OpDistSq2D:                "DistSq2D",
+	OpDistSq3D:                "DistSq3D",
+	OpInRadius2D:              "InRadius2D",
+	OpInRadius3D:              "InRadius3D",
+	OpAngle2D:                 "Angle2D",
+	OpMatMul:                  "MatMul",
+	OpLeftStr:                 "LeftStr",
+	OpRightStr:                "RightStr",
+	OpMidStr:                  "MidStr",
+	OpLenStr:                  "LenStr",
+	OpStrSlice:                "StrSlice",
+	OpStrSliceFrom:            "StrSliceFrom",
+	OpEOF:                     "EOF",
+	OpOpenFile:                "OpenFile",
+	OpReadLine:                "ReadLine",
+	OpWriteLine:               "WriteLine",
+	OpCloseFile:               "CloseFile",
+	OpReadByte:                "ReadByte",
+	OpWriteByte:               "WriteByte",
+	OpCreateArray:             "CreateArray",
+	OpLoadArray:               "LoadArray",
+	OpStoreArray:              "StoreArray",
+	OpResizeArray:             "ResizeArray",
+	OpAppendArray:             "AppendArray",
+	OpCallForeign:             "CallForeign",
+	OpCallUser:                "CallUser",
+	OpRegisterEvent:           "RegisterEvent",
+	OpStartCoroutine:          "StartCoroutine",
+	OpYield:                   "Yield",
+	OpWaitSeconds:             "WaitSeconds",
+	OpRead:                    "Read",
+	OpRestore:                 "Restore",
+	OpGosub:                   "Gosub",
+	OpQuit:                    "Quit",
+	OpHalt:                    "Halt",
+	OpGetProp:                 "GetProp",
+	OpSetProp:                 "SetProp",
+	OpCallMethod:              "CallMethod",
+	OpLoadParam:               "LoadParam",
+	OpStoreParam:              "StoreParam",
+	OpTry:                     "Try",
+	OpEndTry:                  "EndTry",
+	OpThrow:                   "Throw",
+	OpEmit:                    "Emit",
+	OpStartTask:               "StartTask",
+	OpAwait:                   "Await",
+	OpMakeFunction:            "MakeFunction",
+	OpCallValue:               "CallValue",
+	OpNewObject:               "NewObject",
+	OpMakeList:                "MakeList",
+	OpMakeMap:                 "MakeMap",
+	OpIndex:                   "Index",
+	OpSetIndex:                "SetIndex",
+	OpIterNew:                 "IterNew",
+	OpIterNext:                "IterNext",
+	OpWide:                    "Wide",
+}
+
+// opSetHash identifies the opcode set: the opcodes' names in order and their operand layouts. A .cbc file records
+// it so a VM whose opcodes were inserted, removed, reordered or re-laid out rejects the file instead of
+// misexecuting it.
+func opSetHash() uint64 {
+	h := fnv.New64a()
+	for op, name := range opNames {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		for _, k := range opOperands[OpCode(op)] {
+			h.Write([]byte{byte(k)})
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,275 @@
+package vm
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteFolded writes one line per distinct stack in the folded format read by flamegraph.pl, speedscope and
+// inferno: frames root first separated by ';', then the time in microseconds.
+func (p *Profiler) WriteFolded(w io.Writer) error {
+	var order []string
+	nanos := map[string]int64{}
+	for _, s := range p.Samples() {
+		names := make([]string, len(s.Stack))
+		for i, f := range s.Stack {
+			names[i] = f.Func
+		}
+		key := strings.Join(names, ";")
+		if _, ok := nanos[key]; !ok {
+			order = append(order, key)
+		}
+		nanos[key] += s.Nanos
+	}
+	for _, key := range order {
+		us := nanos[key] / int64(time.Microsecond)
+		if us == 0 {
+			us = 1
+		}
+		if _, err := fmt.Fprintf(w, "%s %d\n", key, us); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePprof writes a gzipped profile.proto that `go tool pprof` reads. Each sample carries two values: the
+// sample (or foreign call) count and the time in nanoseconds. Locations are source lines, functions are Subs,
+// Functions, "main" and foreign commands.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	var strs []string
+	strIndex := map[string]int64{}
+	str := func(s string) int64 {
+		if i, ok := strIndex[s]; ok {
+			return i
+		}
+		strIndex[s] = int64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+	str("")
+
+	var prof pbuf
+	for _, st := range [][2]string{{"samples", "count"}, {"time", "nanoseconds"}} {
+		var vt pbuf
+		vt.int(1, str(st[0]))
+		vt.int(2, str(st[1]))
+		prof.msg(1, vt) // sample_type
+	}
+
+	funcIDs := map[[2]string]uint64{}
+	locIDs := map[ProfileFrame]uint64{}
+	var funcs, locs []pbuf
+	location := func(f ProfileFrame) uint64 {
+		if id, ok := locIDs[f]; ok {
+			return id
+		}
+		fk := [2]string{f.Func, f.File}
+		fid, ok := funcIDs[fk]
+		if !ok {
+			fid = uint64(len(funcIDs) + 1)
+			funcIDs[fk] = fid
+			var fn pbuf
+			fn.uint(1, fid)
+			fn.int(2, str(f.Func))
+			fn.int(3, str(f.Func))
+			fn.int(4, str(f.File))
+			funcs = append(funcs, fn)
+		}
+		id := uint64(len(locIDs) + 1)
+		locIDs[f] = id
+		var line pbuf
+		line.uint(1, fid)
+		line.int(2, int64(f.Line))
+		var loc pbuf
+		loc.uint(1, id)
+		loc.msg(4, line)
+		locs = append(locs, loc)
+		return id
+	}
+	for _, s := range p.Samples() {
+		ids := make([]uint64, len(s.Stack))
+		for i, f := range s.Stack {
+			ids[len(s.Stack)-1-i] = location(f) // pprof lists the leaf first
+		}
+		var sample pbuf
+		sample.packedUint(1, ids)
+		sample.packedInt(2, []int64{s.Count, s.Nanos})
+		prof.msg(2, sample)
+	}
+	for _, l := range locs {
+		prof.msg(4, l)
+	}
+	for _, f := range funcs {
+		prof.msg(5, f)
+	}
+	var period pbuf
+	period.int(1, str("time"))
+	period.int(2, str("nanoseconds"))
+	for _, s := range strs {
+		prof.bytes(6, []byte(s))
+	}
+	prof.int(9, p.Start.UnixNano())
+	prof.int(10, int64(p.Duration()))
+	prof.msg(11, period)
+	prof.int(12, int64(p.Interval))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(prof); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pbuf is a minimal protocol buffer encoder for WritePprof.
+type pbuf []byte
+
+func (b *pbuf) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *pbuf) uint(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *pbuf) int(field int, v int64) {
+	b.uint(field, uint64(v))
+}
+
+func (b *pbuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *pbuf) msg(field int, m pbuf) {
+	b.bytes(field, m)
+}
+
+func (b *pbuf) packedUint(field int, vs []uint64) {
+	var inner pbuf
+	for _, v := range vs {
+		inner.varint(v)
+	}
+	b.bytes(field, inner)
+}
+
+func (b *pbuf) packedInt(field int, vs []int64) {
+	var inner pbuf
+	for _, v := range vs {
+		inner.varint(uint64(v))
+	}
+	b.bytes(field, inner)
+}
+
+// profileRow is one line of the text report.
+type profileRow struct {
+	name        string
+	self, total int64
+	calls       int64
+}
+
+// WriteReport writes a text summary: time per Sub/Function (self and including callees), per foreign command
+// (calls, total, average) and per source line. A line's time includes the foreign calls made on it.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	samples := p.Samples()
+	var all, sampled, foreign int64
+	var sampleCount, foreignCalls int64
+	funcs := map[string]*profileRow{}
+	foreigns := map[string]*profileRow{}
+	lines := map[string]*profileRow{}
+	row := func(m map[string]*profileRow, name string) *profileRow {
+		r, ok := m[name]
+		if !ok {
+			r = &profileRow{name: name}
+			m[name] = r
+		}
+		return r
+	}
+	for _, s := range samples {
+		all += s.Nanos
+		leaf := s.Stack[len(s.Stack)-1]
+		basic := s.Stack
+		if leaf.Foreign {
+			foreign += s.Nanos
+			foreignCalls += s.Count
+			r := row(foreigns, leaf.Func)
+			r.calls += s.Count
+			r.total += s.Nanos
+			basic = s.Stack[:len(s.Stack)-1]
+		} else {
+			sampled += s.Nanos
+			sampleCount += s.Count
+		}
+		if len(basic) == 0 {
+			continue
+		}
+		top := basic[len(basic)-1]
+		if !leaf.Foreign {
+			row(funcs, top.Func).self += s.Nanos
+		}
+		seen := map[string]bool{}
+		for _, f := range basic {
+			if !seen[f.Func] {
+				seen[f.Func] = true
+				row(funcs, f.Func).total += s.Nanos
+			}
+		}
+		loc := fmt.Sprintf("line %d", top.Line)
+		if top.File != "" {
+			loc = fmt.Sprintf("%s:%d", filepath.Base(top.File), top.Line)
+		}
+		row(lines, loc+" ("+top.Func+")").self += s.Nanos
+	}
+	pct := func(n int64) string {
+		if all == 0 {
+			return "0.0%"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(all))
+	}
+	dur := func(n int64) string { return time.Duration(n).Round(time.Microsecond).String() }
+	sorted := func(m map[string]*profileRow, key func(*profileRow) int64) []*profileRow {
+		out := make([]*profileRow, 0, len(m))
+		for _, r := range m {
+			out = append(out, r)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if key(out[i]) != key(out[j]) {
+				return key(out[i]) > key(out[j])
+			}
+			return out[i].name < out[j].name
+		})
+		return out
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Profile: %s wall, %d samples every %s (%s), %d foreign calls (%s)\n",
+		p.Duration().Round(time.Millisecond), sampleCount, p.Interval, dur(sampled), foreignCalls, dur(foreign))
+	fmt.Fprintf(&b, "\nSubs and Functions (self: running its own code; total: including callees and foreign calls)\n")
+	fmt.Fprintf(&b, "%12s %7s %12s %7s  %s\n", "self", "self%", "total", "total%", "name")
+	for _, r := range sorted(funcs, func(r *profileRow) int64 { return r.total }) {
+		fmt.Fprintf(&b, "%12s %7s %12s %7s  %s\n", dur(r.self), pct(r.self), dur(r.total), pct(r.total), r.name)
+	}
+	if len(foreigns) > 0 {
+		fmt.Fprintf(&b, "\nForeign commands\n%8s %12s %7s %12s  %s\n", "calls", "total", "total%", "avg", "name")
+		for _, r := range sorted(foreigns, func(r *profileRow) int64 { return r.total }) {
+			fmt.Fprintf(&b, "%8d %12s %7s %12s  %s\n", r.calls, dur(r.total), pct(r.total), dur(r.total/r.calls), r.name)
+		}
+	}
+	fmt.Fprintf(&b, "\nSource lines (including foreign calls made on the line)\n%12s %7s  %s\n", "time", "time%", "line")
+	for _, r := range sorted(lines, func(r *profileRow) int64 { return r.self }) {
+		fmt.Fprintf(&b, "%12s %7s  %s\n", dur(r.self), pct(r.self), r.name)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
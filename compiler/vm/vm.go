@@ -2,9 +2,12 @@ package vm
 
 import (
 	"bufio"
+	"cyberbasic/compiler/srcmap"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,22 +27,35 @@ type VM struct {
 	running        bool
 	runtime        GameRuntime // optional: when set, game opcodes call runtime instead of no-op
 	foreign        map[string]ForeignFunc
+	foreignNames   map[string]string // lowercase -> name as first registered (for tooling)
 	// Entity getter/setter: when set, entityName.prop read/write can be intercepted (e.g. for physics).
 	entityGetters  map[string]func(entityName, prop string) (Value, bool) // key: prop name (lowercase) or "entity.prop"
 	entitySetters  map[string]func(entityName, prop string, v Value)
-	timerZero      time.Time
+	timerZero      float64        // clock reading TIMER counts from (start or ResetTimer)
+	clock          func() float64 // TIMER's clock in seconds (SetClock); nil is the wall clock
 	fileHandles    map[int]*os.File
 	fileReaders    map[int]*bufio.Reader // for ReadLine
 	nextFileHandle int
 	eventHandlers       []eventHandler
-	collisionHandlers   map[string]string // bodyId -> subName for 2D collision callbacks
+	collisionHandlers   map[string]Value // bodyId -> Sub name or function for 2D collision callbacks
+	contactHandlers     map[string]map[string]Value // worldId -> bodyId+"\x00"+event -> Sub name or function (OnCollision3D/2D)
+	eventQueue          []postedEvent // events waiting for ProcessEvents (PostEvent, EMIT, timers)
+	eventMu             sync.Mutex    // guards eventQueue; bindings may post from other goroutines
+	eventTimers         []eventTimer
 	fibers              []fiberState
 	fiberQueue          []int
 	currentFiber        int
 	fiberNames          map[int]string // fiberIndex -> sub name for StopTask/PauseTask/ResumeTask
-	sleeping            []sleepEntry   // fibers waiting for resume time (non-blocking WaitSeconds)
+	sleeping            []sleepEntry   // fibers waiting for resume time (non-blocking WaitSeconds) or an AWAIT
+	idle                bool           // every fiber is sleeping; Run loads the first one to wake
+	invokeDepth         int            // > 0 while InvokeSub / event handlers run; AWAIT blocks there instead of switching fibers
 	dataIndex           int            // current position for READ (into chunk.DataValues)
 	gosubStack          []int          // return addresses for GOSUB
+	tryHandlers         []tryHandler   // active TRY blocks of the current fiber, innermost last
+	tryFloor            int            // handlers below this index belong to code outside the current InvokeSub/event call
+	varSlots            int            // stack slots at the bottom of the stack kept for program variables
+	spareVars           int            // slots LoadChunk reserves beyond the chunk's variables for ones Reload adds
+	natives             map[string]*Function // Subs/Functions of a program compiled to Go (RegisterFunction)
 
 	// Hybrid update/draw: when inside draw(), render commands are queued instead of executed.
 	insideDraw         bool
@@ -52,6 +68,8 @@ type VM struct {
 	// Debugger: breakpoints and mode
 	breakpoints map[int]bool
 	debugMode   bool
+	debugHook   DebugHook
+	profiler    *Profiler
 }
 
 // RenderType classifies a foreign command for the hybrid render queue (2D, 3D, or GUI).
@@ -73,17 +91,21 @@ type RenderQueueItem struct {
 type sleepEntry struct {
 	fiberIndex int
 	resumeAt   time.Time
-	isPaused   bool // if true, never auto-wake; ResumeTask moves back to queue
+	isPaused   bool      // if true, never auto-wake; ResumeTask moves back to queue
+	awaiting   Awaitable // if set, wake when it completes (AWAIT) instead of at resumeAt
 }
 
 type eventHandler struct {
 	eventType string
 	key       string
 	handlerIP int
+	fn        *Function // Go handler (RegisterEventHandler); handlerIP is unused when set
 }
 
 type userCallFrame struct {
-	stackBase int // callee args start at stack[stackBase]; truncate stack to stackBase on return
+	stackBase int       // callee args start at stack[stackBase]; truncate stack to stackBase on return
+	fn        *Function // function value being called (OpCallValue, Invoke); its captures follow the params
+	value     bool      // the caller takes a result: RETURN without a value pushes nil
 }
 
 type fiberState struct {
@@ -92,6 +114,9 @@ type fiberState struct {
 	callStack      []int
 	drawFrameStack []bool
 	userCallFrames []userCallFrame
+	tryHandlers    []tryHandler
+	done           bool  // the fiber's Sub/Function returned (or StopTask stopped it)
+	result         Value // its return value, for AWAIT on the fiber's Task
 }
 
 // NewVM creates a new virtual machine instance
@@ -139,17 +164,21 @@ func (vm *VM) RegisterEntitySetter(key string, fn func(entityName, prop string,
 	vm.entitySetters[strings.ToLower(key)] = fn
 }
 
-// shrinkStackAfterUserReturn truncates the stack to the caller's length when returning from OpCallUser/InvokeSub.
+// shrinkStackAfterUserReturn truncates the stack to the caller's length when returning from OpCallUser/InvokeSub,
+// saving a function value's captured slots first. It reports whether the caller takes a result.
 // Invariant: len(userCallFrames) <= len(callStack)+1; after popping one return address, if len(userCallFrames) > len(callStack), the return was from a user frame.
-func (vm *VM) shrinkStackAfterUserReturn() {
+func (vm *VM) shrinkStackAfterUserReturn() (value bool) {
 	if len(vm.userCallFrames) > len(vm.callStack) {
 		fr := vm.userCallFrames[len(vm.userCallFrames)-1]
 		vm.userCallFrames = vm.userCallFrames[:len(vm.userCallFrames)-1]
 		if fr.stackBase > len(vm.stack) {
 			fr.stackBase = len(vm.stack)
 		}
+		fr.fn.saveCaptured(vm.stack[fr.stackBase:])
 		vm.stack = vm.stack[:fr.stackBase]
+		return fr.value
 	}
+	return false
 }
 
 // paramSlot returns the stack index for the current callee's parameter (must be inside a user call frame).
@@ -162,18 +191,29 @@ func (vm *VM) paramSlot(paramIdx int) int {
 func (vm *VM) LoadChunk(chunk *Chunk) {
 	vm.chunk = chunk
 	vm.ip = 0
-	vm.stack = make([]Value, 0)
+	// Every program variable has its slot from the start, so one first assigned inside a Sub cannot land on the
+	// Sub's parameters or a loop's temporaries above the variables.
+	vm.varSlots = len(chunk.Variables) + vm.spareVars
+	vm.stack = make([]Value, vm.varSlots)
 	vm.callStack = vm.callStack[:0]
 	vm.eventHandlers = vm.eventHandlers[:0]
-	vm.collisionHandlers = make(map[string]string)
-	vm.fibers = []fiberState{{ip: 0, stack: []Value{}, callStack: []int{}, userCallFrames: nil}}
+	vm.collisionHandlers = make(map[string]Value)
+	vm.contactHandlers = nil
+	vm.eventMu.Lock()
+	vm.eventQueue = nil
+	vm.eventMu.Unlock()
+	vm.eventTimers = nil
+	vm.fibers = []fiberState{{ip: 0, stack: append([]Value(nil), vm.stack...), callStack: []int{}, userCallFrames: nil}}
 	vm.fiberQueue = []int{0}
 	vm.currentFiber = 0
 	vm.fiberNames = map[int]string{0: ""} // main fiber has no name
 	vm.sleeping = vm.sleeping[:0]
+	vm.idle = false
 	vm.dataIndex = 0
 	vm.gosubStack = vm.gosubStack[:0]
-	vm.timerZero = time.Now()
+	vm.tryHandlers = vm.tryHandlers[:0]
+	vm.tryFloor = 0
+	vm.timerZero = vm.now()
 	vm.fileHandles = make(map[int]*os.File)
 	vm.fileReaders = make(map[int]*bufio.Reader)
 	vm.nextFileHandle = 1
@@ -193,73 +233,134 @@ func (vm *VM) SetRuntime(r GameRuntime) {
 	vm.runtime = r
 }
 
+// SetClock makes TIMER read now (seconds) instead of the wall clock and restarts it from 0. --headless, --record
+// and --replay pass headless.Time, so a run driven by scripted frames sees the same TIMER values every time.
+func (vm *VM) SetClock(now func() float64) {
+	vm.clock = now
+	vm.timerZero = vm.now()
+}
+
+// now reads TIMER's clock.
+func (vm *VM) now() float64 {
+	if vm.clock != nil {
+		return vm.clock()
+	}
+	return float64(time.Now().UnixNano()) / 1e9
+}
+
 // GetRuntime returns the game runtime (may be nil). Used by foreign APIs such as GAME.SyncSpriteToBody2D.
 func (vm *VM) GetRuntime() GameRuntime {
 	return vm.runtime
 }
 
-// RegisterCollisionHandler registers a Sub to call when bodyId has a collision (2D). Used by GAME.SetCollisionHandler.
-func (vm *VM) RegisterCollisionHandler(bodyId, subName string) {
+// RegisterCollisionHandler registers a Sub name or function value (see Callback) to call when bodyId has a
+// collision (2D). Used by GAME.SetCollisionHandler.
+func (vm *VM) RegisterCollisionHandler(bodyId string, handler Value) {
 	if vm.collisionHandlers == nil {
-		vm.collisionHandlers = make(map[string]string)
+		vm.collisionHandlers = make(map[string]Value)
 	}
-	vm.collisionHandlers[strings.ToLower(bodyId)] = strings.ToLower(subName)
+	vm.collisionHandlers[strings.ToLower(bodyId)] = handler
 }
 
-// GetCollisionHandlers returns a copy of bodyId -> subName for collision callbacks.
-func (vm *VM) GetCollisionHandlers() map[string]string {
-	out := make(map[string]string)
+// GetCollisionHandlers returns a copy of bodyId -> handler for collision callbacks; call them with Invoke.
+func (vm *VM) GetCollisionHandlers() map[string]Value {
+	out := make(map[string]Value)
 	for k, v := range vm.collisionHandlers {
 		out[k] = v
 	}
 	return out
 }
 
+// RegisterContactHandler registers a Sub name or function value (see Callback) to call for a physics contact
+// event of bodyId in worldId (bullet and box2d). event is "begin", "stay", "end", "enter" or "exit"; a nil
+// handler removes it.
+func (vm *VM) RegisterContactHandler(worldId, bodyId, event string, handler Value) {
+	key := bodyId + "\x00" + strings.ToLower(event)
+	if handler == nil {
+		delete(vm.contactHandlers[worldId], key)
+		return
+	}
+	if name, ok := handler.(string); ok {
+		handler = strings.ToLower(name)
+	}
+	if vm.contactHandlers == nil {
+		vm.contactHandlers = make(map[string]map[string]Value)
+	}
+	if vm.contactHandlers[worldId] == nil {
+		vm.contactHandlers[worldId] = make(map[string]Value)
+	}
+	vm.contactHandlers[worldId][key] = handler
+}
+
+// ContactHandler returns the handler registered for bodyId's event in worldId, or nil.
+func (vm *VM) ContactHandler(worldId, bodyId, event string) Value {
+	return vm.contactHandlers[worldId][bodyId+"\x00"+event]
+}
+
+// HasContactHandlers reports whether any contact handler is registered for worldId, so physics bindings can
+// skip collecting events nobody listens to.
+func (vm *VM) HasContactHandlers(worldId string) bool {
+	return len(vm.contactHandlers[worldId]) > 0
+}
+
 // InvokeSub calls a BASIC Sub by name with the given arguments. Sub sees them as first, second, ... param (stack[0]=first). Returns when the Sub returns.
 func (vm *VM) InvokeSub(name string, args []interface{}) error {
 	if vm.chunk == nil {
 		return nil
 	}
+	if f := vm.natives[strings.ToLower(name)]; f != nil {
+		_, err := f.callNative(args)
+		return err
+	}
 	subIP, ok := vm.chunk.GetFunction(strings.ToLower(name))
 	if !ok {
 		return nil
 	}
+	_, err := vm.invokeAt(subIP, nil, args, strings.ToLower(name) == "draw")
+	return err
+}
+
+// invokeAt runs the code at ip as a Sub call with args (InvokeSub, Invoke, ON handlers) and returns its result
+// when it returns. fn is the function value being called, if any.
+func (vm *VM) invokeAt(ip int, fn *Function, args []interface{}, isDraw bool) (Value, error) {
 	savedIP := vm.ip
 	argVals := make([]Value, len(args))
 	for i, a := range args {
 		argVals[i] = a
 	}
+	if fn != nil {
+		argVals = fn.frameArgs(argVals)
+	}
 	restoreLen := len(vm.stack)
-	vm.userCallFrames = append(vm.userCallFrames, userCallFrame{stackBase: restoreLen})
+	savedFloor := vm.tryFloor
+	vm.tryFloor = len(vm.tryHandlers)
+	defer func() {
+		// Handlers left by an error or early exit inside the Sub must not catch the caller's errors.
+		if len(vm.tryHandlers) > vm.tryFloor {
+			vm.tryHandlers = vm.tryHandlers[:vm.tryFloor]
+		}
+		vm.tryFloor = savedFloor
+	}()
+	vm.userCallFrames = append(vm.userCallFrames, userCallFrame{stackBase: restoreLen, fn: fn, value: true})
 	vm.stack = append(vm.stack, argVals...)
-	returnAddr := len(vm.chunk.Code)
+	vm.invokeDepth++
+	defer func() { vm.invokeDepth-- }()
+	depth := len(vm.callStack)
+	wasRunning := vm.running
 	vm.callStack = append(vm.callStack, vm.ip)
-	isDraw := strings.ToLower(name) == "draw"
+	// One entry per call frame, as OpCallUser pushes, so the Sub's RETURN pops its own entry.
+	drawDepth := len(vm.drawFrameStack)
+	vm.drawFrameStack = append(vm.drawFrameStack, isDraw)
 	if isDraw {
-		vm.drawFrameStack = append(vm.drawFrameStack, true)
 		vm.insideDraw = true
 	}
-	vm.ip = subIP
-	for vm.ip < len(vm.chunk.Code) {
-		if err := vm.Step(); err != nil {
-			if isDraw {
-				vm.drawFrameStack = vm.drawFrameStack[:len(vm.drawFrameStack)-1]
-				vm.insideDraw = false
-				for _, b := range vm.drawFrameStack {
-					if b {
-						vm.insideDraw = true
-						break
-					}
-				}
-			}
-			return err
+	defer func() {
+		if len(vm.drawFrameStack) > drawDepth {
+			vm.drawFrameStack = vm.drawFrameStack[:drawDepth]
 		}
-		if vm.ip == returnAddr {
-			break
+		if !isDraw {
+			return // leave insideDraw as the caller set it (SetInsideDraw around OnDraw)
 		}
-	}
-	if isDraw {
-		vm.drawFrameStack = vm.drawFrameStack[:len(vm.drawFrameStack)-1]
 		vm.insideDraw = false
 		for _, b := range vm.drawFrameStack {
 			if b {
@@ -267,17 +368,32 @@ func (vm *VM) InvokeSub(name string, args []interface{}) error {
 				break
 			}
 		}
+	}()
+	vm.ip = ip
+	// Run until the Sub's own RETURN pops its frame (not to the end of the code: when called from a foreign
+	// mid-program, the caller's code must resume in the caller), or until it ENDs the program.
+	for len(vm.callStack) > depth && vm.ip < len(vm.chunk.Code) && !(wasRunning && !vm.running) {
+		if err := vm.Step(); err != nil {
+			return nil, err
+		}
 	}
 	vm.ip = savedIP
-	return nil
+	var result Value
+	if len(vm.callStack) == depth && len(vm.stack) > restoreLen {
+		result = vm.stack[len(vm.stack)-1]
+		vm.stack = vm.stack[:restoreLen]
+	}
+	return result, nil
 }
 
 // SetForeignRegistry sets the map of foreign API functions (e.g. "RL.InitWindow" -> wrapper).
 // Names are case-insensitive (canonical form: lowercase).
 func (vm *VM) SetForeignRegistry(registry map[string]ForeignFunc) {
 	vm.foreign = make(map[string]ForeignFunc)
+	vm.foreignNames = make(map[string]string)
 	for k, v := range registry {
 		vm.foreign[strings.ToLower(k)] = v
+		vm.noteForeignName(k)
 	}
 }
 
@@ -287,6 +403,33 @@ func (vm *VM) RegisterForeign(name string, fn ForeignFunc) {
 		vm.foreign = make(map[string]ForeignFunc)
 	}
 	vm.foreign[strings.ToLower(name)] = fn
+	vm.noteForeignName(name)
+}
+
+func (vm *VM) noteForeignName(name string) {
+	if vm.foreignNames == nil {
+		vm.foreignNames = make(map[string]string)
+	}
+	if _, ok := vm.foreignNames[strings.ToLower(name)]; !ok {
+		vm.foreignNames[strings.ToLower(name)] = name
+	}
+}
+
+// ForeignNames returns the registered foreign function names, sorted case-insensitively, in the
+// spelling they were first registered with (e.g. "DrawRectangle"). Used by --list-commands style tooling.
+func (vm *VM) ForeignNames() []string {
+	names := make([]string, 0, len(vm.foreignNames))
+	for _, n := range vm.foreignNames {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+	return names
+}
+
+// Quit ends the program as QUIT does: Run, and a Sub the runtime is running, return after the current
+// instruction. Foreign functions call it, e.g. when the --frames limit is reached.
+func (vm *VM) Quit() {
+	vm.running = false
 }
 
 // Run executes the loaded bytecode
@@ -303,24 +446,39 @@ func (vm *VM) Run() error {
 			if len(vm.sleeping) == 0 {
 				break
 			}
-			nextWake := vm.sleeping[0].resumeAt
-			for _, e := range vm.sleeping[1:] {
-				if e.resumeAt.Before(nextWake) {
-					nextWake = e.resumeAt
+			// Sleep until the next WaitSeconds is due; poll AWAITs every millisecond.
+			wait := time.Duration(-1)
+			for _, e := range vm.sleeping {
+				d := max(time.Until(e.resumeAt), 0)
+				switch {
+				case e.isPaused:
+					continue
+				case e.awaiting != nil:
+					d = time.Millisecond
 				}
+				if wait < 0 || d < wait {
+					wait = d
+				}
+			}
+			if wait < 0 {
+				break // only paused fibers are left and nothing can resume them
 			}
-			if d := time.Until(nextWake); d > 0 {
-				time.Sleep(d)
+			if wait > 0 {
+				time.Sleep(wait)
 			}
 			continue
 		}
+		if vm.idle {
+			vm.loadFiber(vm.fiberQueue[0])
+		}
 		if vm.ip >= len(vm.chunk.Code) {
 			break
 		}
 		if vm.debugMode && vm.breakpoints != nil {
 			line := vm.chunk.LineAt(vm.ip)
 			if line > 0 && vm.breakpoints[line] {
-				return &ErrBreakpoint{Line: line}
+				pos := vm.chunk.PosAt(vm.ip)
+				return &ErrBreakpoint{File: pos.File, Line: pos.Line}
 			}
 		}
 		if err := vm.Step(); err != nil {
@@ -336,24 +494,45 @@ func (vm *VM) Step() error {
 	if vm.chunk == nil || vm.ip >= len(vm.chunk.Code) {
 		return nil
 	}
+	if vm.debugHook != nil {
+		if err := vm.debugHook(vm); err != nil {
+			return err
+		}
+	}
+	if vm.profiler != nil {
+		vm.profiler.tick(vm)
+	}
+	ip := vm.ip
 	instruction := vm.chunk.Code[vm.ip]
 	vm.ip++
 	err := vm.executeInstruction(instruction)
 	if err != nil && vm.chunk != nil {
-		line := vm.chunk.LineAt(vm.ip - 1)
-		if line > 0 {
-			err = fmt.Errorf("line %d: %w", line, err)
+		if vm.catch(err, ip) {
+			return nil
+		}
+		pos := vm.chunk.PosAt(ip)
+		if se, ok := err.(*ScriptError); ok && se.Line > 0 {
+			pos = srcmap.Pos{File: se.File, Line: se.Line} // uncaught THROW: report where it was raised
+		}
+		if pos.Line > 0 {
+			err = fmt.Errorf("%s: %w", pos, err)
 		}
 	}
 	return err
 }
 
-// StackFrame is one frame in a stack trace (IP and source line).
+// StackFrame is one frame in a stack trace (IP and original source position; File is empty for single-file programs).
 type StackFrame struct {
 	IP   int
+	File string
 	Line int
 }
 
+// Pos returns the frame's source position.
+func (f StackFrame) Pos() srcmap.Pos {
+	return srcmap.Pos{File: f.File, Line: f.Line}
+}
+
 // StackTrace returns the current call stack for debugging (current IP first, then return addresses).
 func (vm *VM) StackTrace() []StackFrame {
 	if vm.chunk == nil {
@@ -361,11 +540,13 @@ func (vm *VM) StackTrace() []StackFrame {
 	}
 	var frames []StackFrame
 	if vm.ip >= 0 && vm.ip <= len(vm.chunk.Code) {
-		frames = append(frames, StackFrame{IP: vm.ip, Line: vm.chunk.LineAt(vm.ip)})
+		pos := vm.chunk.PosAt(vm.ip)
+		frames = append(frames, StackFrame{IP: vm.ip, File: pos.File, Line: pos.Line})
 	}
 	for i := len(vm.callStack) - 1; i >= 0; i-- {
 		ip := vm.callStack[i]
-		frames = append(frames, StackFrame{IP: ip, Line: vm.chunk.LineAt(ip)})
+		pos := vm.chunk.PosAt(ip)
+		frames = append(frames, StackFrame{IP: ip, File: pos.File, Line: pos.Line})
 	}
 	return frames
 }
@@ -0,0 +1,333 @@
+package vm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Awaitable is a value AWAIT can wait for: a coroutine's Task, a channel operation, or the Future returned by an
+// asynchronous foreign. Poll is called on the VM goroutine; once it reports done it must not be polled again.
+type Awaitable interface {
+	Poll() (done bool, val Value, err error)
+}
+
+// awaitResult is what a parked fiber finds on its stack when the value it awaited completed.
+type awaitResult struct {
+	val Value
+	err error
+}
+
+// await implements OpAwait at ip: a value that is not Awaitable is its own result; a completed one is replaced by
+// its result; otherwise the fiber parks on it and OpAwait runs again when it completes. Inside InvokeSub (event
+// handlers, update/draw) other fibers cannot run, so only a Future is waited for there, by blocking.
+func (vm *VM) await(ip int) error {
+	var done bool
+	var val Value
+	var err error
+	switch a := vm.peek().(type) {
+	case awaitResult:
+		done, val, err = true, a.val, a.err
+	case Awaitable:
+		done, val, err = a.Poll()
+		if done {
+			awaited(a)
+			break
+		}
+		if vm.invokeDepth > 0 {
+			f, ok := a.(*Future)
+			if !ok {
+				return fmt.Errorf("AWAIT %v cannot finish inside a handler called by the runtime; await it from a coroutine", a)
+			}
+			done = true
+			val, err = f.Wait()
+			awaited(f)
+			break
+		}
+		vm.ip = ip
+		vm.saveFiber()
+		vm.sleeping = append(vm.sleeping, sleepEntry{fiberIndex: vm.currentFiber, awaiting: a})
+		vm.parkFiber()
+		return nil
+	default:
+		return nil
+	}
+	vm.pop()
+	if err != nil {
+		return err
+	}
+	vm.push(val)
+	return nil
+}
+
+// startFiber queues a new fiber that runs the Sub or Function at ip with args bound as its parameters, and
+// returns its Task.
+func (vm *VM) startFiber(ip int, name string, args []Value) *Task {
+	f := fiberState{ip: ip, stack: append([]Value{}, args...), callStack: []int{}}
+	if len(args) > 0 {
+		f.userCallFrames = []userCallFrame{{stackBase: 0}}
+	}
+	idx := len(vm.fibers)
+	vm.fibers = append(vm.fibers, f)
+	vm.fiberQueue = append(vm.fiberQueue, idx)
+	if vm.fiberNames == nil {
+		vm.fiberNames = make(map[int]string)
+	}
+	vm.fiberNames[idx] = name
+	return &Task{vm: vm, chunk: vm.chunk, fiber: idx, name: name}
+}
+
+// Task is the handle StartCoroutine returns when used as an expression (t = StartCoroutine Worker()). AWAIT t
+// waits for the coroutine to finish and evaluates to its return value.
+type Task struct {
+	vm    *VM
+	chunk *Chunk // the program that started it; a Task from before LoadChunk never completes
+	fiber int
+	name  string
+}
+
+// Poll reports whether the coroutine has returned, with its return value.
+func (t *Task) Poll() (bool, Value, error) {
+	if t.vm.chunk != t.chunk || t.fiber >= len(t.vm.fibers) {
+		return true, nil, nil
+	}
+	f := t.vm.fibers[t.fiber]
+	return f.done, f.result, nil
+}
+
+// Done reports whether the coroutine has finished.
+func (t *Task) Done() bool {
+	done, _, _ := t.Poll()
+	return done
+}
+
+func (t *Task) String() string { return "task " + t.name }
+
+// Future is the result of work running outside the VM, such as HttpGetAsync's request. The foreign returns the
+// Future at once; the worker calls Resolve; AWAIT suspends the calling coroutine until then.
+type Future struct {
+	id   string
+	done chan struct{}
+	once sync.Once
+	val  Value
+	err  error
+
+	onAwaited   func()
+	awaitedOnce sync.Once
+}
+
+// NewFuture returns an unresolved Future; id is its string form (so job-id based APIs keep working).
+func NewFuture(id string) *Future {
+	return &Future{id: id, done: make(chan struct{})}
+}
+
+// Go runs fn on a new goroutine and returns a Future resolved with its result.
+func Go(id string, fn func() (Value, error)) *Future {
+	f := NewFuture(id)
+	go func() { f.Resolve(fn()) }()
+	return f
+}
+
+// Resolve completes the Future; later calls are ignored. Safe from any goroutine.
+func (f *Future) Resolve(val Value, err error) {
+	f.once.Do(func() {
+		f.val, f.err = val, err
+		close(f.done)
+	})
+}
+
+// Poll reports whether the Future has been resolved, with its result.
+func (f *Future) Poll() (bool, Value, error) {
+	select {
+	case <-f.done:
+		return true, f.val, f.err
+	default:
+		return false, nil, nil
+	}
+}
+
+// Wait blocks until the Future is resolved.
+func (f *Future) Wait() (Value, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// OnAwaited sets fn to run (on the VM goroutine, once) when AWAIT takes the Future's result, so a foreign that
+// also tracks the Future by id can forget it. Call it before returning the Future to the VM.
+func (f *Future) OnAwaited(fn func()) {
+	f.onAwaited = fn
+}
+
+// awaited runs a's OnAwaited callback if a is a Future that has one.
+func awaited(a Awaitable) {
+	if f, ok := a.(*Future); ok && f.onAwaited != nil {
+		f.awaitedOnce.Do(f.onAwaited)
+	}
+}
+
+func (f *Future) String() string { return f.id }
+
+// Channel passes values between coroutines (ChanCreate). Sends beyond its capacity wait in order until a
+// receiver takes them; capacity 0 hands each value straight to a receiver.
+type Channel struct {
+	mu       sync.Mutex
+	capacity int
+	buf      []Value
+	senders  []*channelSend // sends waiting for room, oldest first
+	closed   bool
+}
+
+// NewChannel returns an open channel that buffers up to capacity values.
+func NewChannel(capacity int) *Channel {
+	return &Channel{capacity: max(capacity, 0)}
+}
+
+// channelSend is a send waiting for room; AWAIT on it finishes when a receiver has taken the value.
+type channelSend struct {
+	ch   *Channel
+	val  Value
+	done bool
+}
+
+func (s *channelSend) Poll() (bool, Value, error) {
+	s.ch.mu.Lock()
+	defer s.ch.mu.Unlock()
+	return s.done, nil, nil
+}
+
+// completedAwait is an operation that finished when it was started.
+type completedAwait struct {
+	val Value
+	err error
+}
+
+func (c completedAwait) Poll() (bool, Value, error) { return true, c.val, c.err }
+
+// Send queues val. The returned Awaitable completes once the value is in the buffer or taken by a receiver; the
+// value is delivered whether or not the sender awaits it.
+func (c *Channel) Send(val Value) Awaitable {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return completedAwait{err: fmt.Errorf("send on closed channel")}
+	}
+	if len(c.senders) == 0 && len(c.buf) < c.capacity {
+		c.buf = append(c.buf, val)
+		return completedAwait{}
+	}
+	s := &channelSend{ch: c, val: val}
+	c.senders = append(c.senders, s)
+	return s
+}
+
+// tryReceive takes the oldest value: from the buffer (refilling it from the first waiting sender) or, with no
+// buffer, straight from a waiting sender. ok is false when nothing is ready; err is set once the channel is
+// closed and drained. The caller holds c.mu.
+func (c *Channel) tryReceive() (val Value, ok bool, err error) {
+	switch {
+	case len(c.buf) > 0:
+		val, c.buf = c.buf[0], c.buf[1:]
+		if len(c.senders) > 0 {
+			s := c.senders[0]
+			c.senders = c.senders[1:]
+			c.buf = append(c.buf, s.val)
+			s.done = true
+		}
+		return val, true, nil
+	case len(c.senders) > 0:
+		s := c.senders[0]
+		c.senders = c.senders[1:]
+		s.done = true
+		return s.val, true, nil
+	case c.closed:
+		return nil, true, fmt.Errorf("receive from closed channel")
+	}
+	return nil, false, nil
+}
+
+// Receive returns an Awaitable for the next value.
+func (c *Channel) Receive() Awaitable {
+	return &channelReceive{ch: c}
+}
+
+type channelReceive struct {
+	ch *Channel
+}
+
+func (r *channelReceive) Poll() (bool, Value, error) {
+	r.ch.mu.Lock()
+	defer r.ch.mu.Unlock()
+	val, ok, err := r.ch.tryReceive()
+	return ok, val, err
+}
+
+// Len returns the number of values ready to receive (buffered plus waiting senders).
+func (c *Channel) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.buf) + len(c.senders)
+}
+
+// Close stops further sends. Values already sent can still be received; after that, receives fail.
+func (c *Channel) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+}
+
+// Closed reports whether Close was called.
+func (c *Channel) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Channel) String() string { return "channel" }
+
+// Select waits for the first of several channels to have a value. AWAIT on it evaluates to the 1-based index of
+// the channel received from, or 0 when timeout passes first (a negative timeout waits forever); Value returns
+// what was received.
+type Select struct {
+	chans    []*Channel
+	deadline time.Time
+	index    int
+	val      Value
+}
+
+// NewSelect returns a Select over chans that gives up after timeout seconds (never when timeout < 0).
+func NewSelect(timeout float64, chans []*Channel) *Select {
+	s := &Select{chans: chans}
+	if timeout >= 0 {
+		s.deadline = time.Now().Add(time.Duration(timeout * float64(time.Second)))
+	}
+	return s
+}
+
+func (s *Select) Poll() (bool, Value, error) {
+	closed := 0
+	for i, c := range s.chans {
+		c.mu.Lock()
+		val, ok, err := c.tryReceive()
+		c.mu.Unlock()
+		if err != nil {
+			closed++
+			continue
+		}
+		if ok {
+			s.index, s.val = i+1, val
+			return true, s.index, nil
+		}
+	}
+	if closed > 0 && closed == len(s.chans) {
+		return true, nil, fmt.Errorf("select on closed channels")
+	}
+	if !s.deadline.IsZero() && !time.Now().Before(s.deadline) {
+		return true, 0, nil
+	}
+	return false, nil, nil
+}
+
+// Value returns the value received by the completed Select (nil after a timeout).
+func (s *Select) Value() Value { return s.val }
+
+func (s *Select) String() string { return "select" }
@@ -0,0 +1,815 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler/errors"
+)
+
+// List is a LIST value, created by [a, b, c]. Lists are shared by reference like TYPE instances: assigning one or
+// passing it to a Sub does not copy it (use .copy() for a deep copy).
+type List struct {
+	Items []Value
+}
+
+// NewList returns a list holding items (not copied).
+func NewList(items ...Value) *List {
+	return &List{Items: items}
+}
+
+// Map is a MAP value, created by [key: value, ...] or [:]. Keys are numbers, strings or booleans; a whole-number
+// float key is the same key as the int (m[1] and m[1.0]). Iteration follows insertion order.
+type Map struct {
+	keys   []Value
+	values []Value
+	index  map[Value]int
+}
+
+// NewMap returns an empty map.
+func NewMap() *Map {
+	return &Map{index: make(map[Value]int)}
+}
+
+// mapKey normalizes k for use as a Map key.
+func mapKey(k Value) (Value, error) {
+	switch v := k.(type) {
+	case int, string, bool:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v), nil
+		}
+		return v, nil
+	case float32:
+		return mapKey(float64(v))
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	}
+	return nil, &errors.CyberError{
+		Code:    errors.ErrTypeMismatch,
+		Message: fmt.Sprintf("MAP keys must be numbers, strings or booleans, got %s", typeName(k)),
+	}
+}
+
+// Len returns the number of entries.
+func (m *Map) Len() int { return len(m.keys) }
+
+// Get returns the value stored under key and whether it was there.
+func (m *Map) Get(key Value) (Value, bool) {
+	k, err := mapKey(key)
+	if err != nil {
+		return nil, false
+	}
+	i, ok := m.index[k]
+	if !ok {
+		return nil, false
+	}
+	return m.values[i], true
+}
+
+// Set stores val under key, keeping the key's place if it already exists.
+func (m *Map) Set(key, val Value) error {
+	k, err := mapKey(key)
+	if err != nil {
+		return err
+	}
+	if i, ok := m.index[k]; ok {
+		m.values[i] = val
+		return nil
+	}
+	m.index[k] = len(m.keys)
+	m.keys = append(m.keys, k)
+	m.values = append(m.values, val)
+	return nil
+}
+
+// Delete removes key and reports whether it was there.
+func (m *Map) Delete(key Value) bool {
+	k, err := mapKey(key)
+	if err != nil {
+		return false
+	}
+	i, ok := m.index[k]
+	if !ok {
+		return false
+	}
+	delete(m.index, k)
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	m.values = append(m.values[:i], m.values[i+1:]...)
+	for j := i; j < len(m.keys); j++ {
+		m.index[m.keys[j]] = j
+	}
+	return true
+}
+
+// Keys returns the keys in insertion order.
+func (m *Map) Keys() []Value { return append([]Value(nil), m.keys...) }
+
+// Values returns the values in key order.
+func (m *Map) Values() []Value { return append([]Value(nil), m.values...) }
+
+func (m *Map) clear() {
+	m.keys, m.values = nil, nil
+	m.index = make(map[Value]int)
+}
+
+// typeName is how BASIC error messages name the type of v.
+func typeName(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "NIL"
+	case int, float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case *List:
+		return "LIST"
+	case *Map:
+		return "MAP"
+	case *Object:
+		return v.Type.Name
+	case *Function:
+		return "function"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func argCount(name string, args []Value, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("%s expects %d arguments, got %d", name, min, len(args))
+		}
+		return fmt.Errorf("%s expects %d to %d arguments, got %d", name, min, max, len(args))
+	}
+	return nil
+}
+
+// at checks i against the list's bounds; negative indices count from the end (-1 is the last item).
+func (l *List) at(i Value) (int, error) {
+	n, ok := i.(int)
+	if f, isFloat := i.(float64); isFloat && f == math.Trunc(f) {
+		n, ok = int(f), true
+	}
+	if !ok {
+		return 0, &errors.CyberError{Code: errors.ErrTypeMismatch, Message: fmt.Sprintf("LIST index must be a whole number, got %v", i)}
+	}
+	if n < 0 {
+		n += len(l.Items)
+	}
+	if n < 0 || n >= len(l.Items) {
+		return 0, fmt.Errorf("LIST index %v out of range (length %d)", i, len(l.Items))
+	}
+	return n, nil
+}
+
+// GetProp supports .length and .count.
+func (l *List) GetProp(path []string) (Value, error) {
+	if len(path) == 1 && (path[0] == "length" || path[0] == "count") {
+		return len(l.Items), nil
+	}
+	return nil, &errors.CyberError{
+		Code:       errors.ErrDotAccess,
+		Message:    fmt.Sprintf("LIST has no property %s", strings.Join(path, ".")),
+		Suggestion: "Use list[i] for items and list.length for the size.",
+	}
+}
+
+// SetProp always fails: items are assigned with list[i] = value.
+func (l *List) SetProp(path []string, val Value) error {
+	return &errors.CyberError{Code: errors.ErrDotAccess, Message: "cannot assign a property of a LIST; use list[i] = value"}
+}
+
+// CallMethod implements the LIST methods that do not call back into BASIC. sort with a comparator, filter and map
+// run in the VM (see VM.callCollectionMethod).
+func (l *List) CallMethod(name string, args []Value) (Value, error) {
+	switch name {
+	case "add", "push":
+		l.Items = append(l.Items, args...)
+		return nil, nil
+	case "insert":
+		if err := argCount("LIST.insert", args, 2, 2); err != nil {
+			return nil, err
+		}
+		i := valueToInt(args[0])
+		if i < 0 || i > len(l.Items) {
+			return nil, fmt.Errorf("LIST.insert: index %d out of range (length %d)", i, len(l.Items))
+		}
+		l.Items = append(l.Items, nil)
+		copy(l.Items[i+1:], l.Items[i:])
+		l.Items[i] = args[1]
+		return nil, nil
+	case "removeat":
+		if err := argCount("LIST.removeAt", args, 1, 1); err != nil {
+			return nil, err
+		}
+		i, err := l.at(args[0])
+		if err != nil {
+			return nil, err
+		}
+		l.Items = append(l.Items[:i], l.Items[i+1:]...)
+		return nil, nil
+	case "remove":
+		if err := argCount("LIST.remove", args, 1, 1); err != nil {
+			return nil, err
+		}
+		if i := l.indexOf(args[0]); i >= 0 {
+			l.Items = append(l.Items[:i], l.Items[i+1:]...)
+		}
+		return nil, nil
+	case "pop":
+		if len(l.Items) == 0 {
+			return nil, fmt.Errorf("LIST.pop: list is empty")
+		}
+		last := l.Items[len(l.Items)-1]
+		l.Items = l.Items[:len(l.Items)-1]
+		if last == nil {
+			return nil, fmt.Errorf("LIST.pop: cannot return a NIL item")
+		}
+		return last, nil
+	case "contains":
+		if err := argCount("LIST.contains", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return l.indexOf(args[0]) >= 0, nil
+	case "indexof":
+		if err := argCount("LIST.indexOf", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return l.indexOf(args[0]), nil
+	case "clear":
+		l.Items = nil
+		return nil, nil
+	case "copy":
+		return DeepCopy(l), nil
+	case "length", "count":
+		return len(l.Items), nil
+	case "reverse":
+		for i, j := 0, len(l.Items)-1; i < j; i, j = i+1, j-1 {
+			l.Items[i], l.Items[j] = l.Items[j], l.Items[i]
+		}
+		return nil, nil
+	case "join":
+		sep := ""
+		if len(args) > 0 {
+			sep = valueToString(args[0])
+		}
+		parts := make([]string, len(l.Items))
+		for i, it := range l.Items {
+			parts[i] = valueToString(it)
+		}
+		return strings.Join(parts, sep), nil
+	case "slice":
+		if err := argCount("LIST.slice", args, 1, 2); err != nil {
+			return nil, err
+		}
+		end := Value(nil)
+		if len(args) == 2 {
+			end = args[1]
+		}
+		return l.slice(args[0], end), nil
+	case "sort":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("LIST.sort with a comparator runs in the VM")
+		}
+		var err error
+		sort.SliceStable(l.Items, func(i, j int) bool {
+			c, cerr := compareValues(l.Items[i], l.Items[j])
+			if cerr != nil && err == nil {
+				err = cerr
+			}
+			return c < 0
+		})
+		return nil, err
+	case "filter", "map":
+		return nil, fmt.Errorf("LIST.%s runs in the VM", name)
+	}
+	return nil, &errors.CyberError{
+		Code:       errors.ErrDotAccess,
+		Message:    fmt.Sprintf("LIST has no method %s", name),
+		Suggestion: "LIST methods: add, insert, remove, removeAt, pop, contains, indexOf, clear, copy, reverse, join, slice, sort, filter, map.",
+	}
+}
+
+func (l *List) indexOf(v Value) int {
+	for i, it := range l.Items {
+		if Equal(it, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// slice returns a new list of the items from start up to end (nil = the end), clamped like string slices.
+func (l *List) slice(startVal, endVal Value) *List {
+	start, end := valueToInt(startVal), len(l.Items)
+	if endVal != nil {
+		end = valueToInt(endVal)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(l.Items) {
+		end = len(l.Items)
+	}
+	if start >= end {
+		return NewList()
+	}
+	return NewList(append([]Value(nil), l.Items[start:end]...)...)
+}
+
+func (l *List) String() string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, it := range l.Items {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(formatItem(it))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// GetProp supports .length and .count; entries are read with m[key].
+func (m *Map) GetProp(path []string) (Value, error) {
+	if len(path) == 1 && (path[0] == "length" || path[0] == "count") {
+		return m.Len(), nil
+	}
+	return nil, &errors.CyberError{
+		Code:       errors.ErrDotAccess,
+		Message:    fmt.Sprintf("MAP has no property %s", strings.Join(path, ".")),
+		Suggestion: "Use map[\"key\"] to read an entry.",
+	}
+}
+
+// SetProp always fails: entries are assigned with m[key] = value.
+func (m *Map) SetProp(path []string, val Value) error {
+	return &errors.CyberError{Code: errors.ErrDotAccess, Message: "cannot assign a property of a MAP; use map[key] = value"}
+}
+
+// CallMethod implements the MAP methods.
+func (m *Map) CallMethod(name string, args []Value) (Value, error) {
+	switch name {
+	case "has", "contains":
+		if err := argCount("MAP."+name, args, 1, 1); err != nil {
+			return nil, err
+		}
+		_, ok := m.Get(args[0])
+		return ok, nil
+	case "get":
+		if err := argCount("MAP.get", args, 1, 2); err != nil {
+			return nil, err
+		}
+		if v, ok := m.Get(args[0]); ok {
+			return v, nil
+		}
+		if len(args) == 2 {
+			return args[1], nil
+		}
+		return nil, fmt.Errorf("MAP.get: no key %s", formatItem(args[0]))
+	case "set":
+		if err := argCount("MAP.set", args, 2, 2); err != nil {
+			return nil, err
+		}
+		return nil, m.Set(args[0], args[1])
+	case "remove":
+		if err := argCount("MAP.remove", args, 1, 1); err != nil {
+			return nil, err
+		}
+		m.Delete(args[0])
+		return nil, nil
+	case "keys":
+		return NewList(m.Keys()...), nil
+	case "values":
+		return NewList(m.Values()...), nil
+	case "length", "count":
+		return m.Len(), nil
+	case "clear":
+		m.clear()
+		return nil, nil
+	case "copy":
+		return DeepCopy(m), nil
+	}
+	return nil, &errors.CyberError{
+		Code:       errors.ErrDotAccess,
+		Message:    fmt.Sprintf("MAP has no method %s", name),
+		Suggestion: "MAP methods: has, get, set, remove, keys, values, count, clear, copy.",
+	}
+}
+
+func (m *Map) String() string {
+	if m.Len() == 0 {
+		return "[:]"
+	}
+	var b strings.Builder
+	b.WriteString("[")
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(formatItem(k) + ": " + formatItem(m.values[i]))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// formatItem prints a list item or map key/value: strings quoted, everything else as PRINT shows it.
+func formatItem(v Value) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
+
+// compareValues orders two values for sorting: numbers by value, strings by bytes, false before true.
+func compareValues(a, b Value) (int, error) {
+	switch x := a.(type) {
+	case int, float64:
+		switch b.(type) {
+		case int, float64:
+			fa, fb := valueToFloat64(a), valueToFloat64(b)
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !x:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, &errors.CyberError{
+		Code:       errors.ErrTypeMismatch,
+		Message:    fmt.Sprintf("cannot compare %s with %s", typeName(a), typeName(b)),
+		Suggestion: "Pass a comparator: list.sort(FUNCTION(a, b) ... END FUNCTION).",
+	}
+}
+
+// Equal reports whether a and b are equal the way = compares them: numbers by value (1 = 1.0), LISTs and MAPs
+// item by item, TYPE instances field by field, and everything else (handles, functions) by identity.
+func Equal(a, b Value) bool {
+	return equalValues(a, b, nil)
+}
+
+func equalValues(a, b Value, seen map[[2]any]bool) bool {
+	switch x := a.(type) {
+	case int:
+		switch y := b.(type) {
+		case int:
+			return x == y
+		case float64:
+			return float64(x) == y
+		}
+		return false
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return x == float64(y)
+		case float64:
+			return x == y
+		}
+		return false
+	case *List:
+		y, ok := b.(*List)
+		if !ok || len(x.Items) != len(y.Items) {
+			return false
+		}
+		if x == y || seen[[2]any{x, y}] {
+			return true
+		}
+		seen = markSeen(seen, x, y)
+		for i := range x.Items {
+			if !equalValues(x.Items[i], y.Items[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Map:
+		y, ok := b.(*Map)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+		if x == y || seen[[2]any{x, y}] {
+			return true
+		}
+		seen = markSeen(seen, x, y)
+		for i, k := range x.keys {
+			yv, has := y.Get(k)
+			if !has || !equalValues(x.values[i], yv, seen) {
+				return false
+			}
+		}
+		return true
+	case *Object:
+		y, ok := b.(*Object)
+		if !ok || x.Type != y.Type {
+			return false
+		}
+		if x == y || seen[[2]any{x, y}] {
+			return true
+		}
+		seen = markSeen(seen, x, y)
+		for i := range x.Fields {
+			if !equalValues(x.Fields[i], y.Fields[i], seen) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equalValues(x[i], y[i], seen) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			yv, has := y[k]
+			if !has || !equalValues(xv, yv, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if ta := reflect.TypeOf(a); ta != reflect.TypeOf(b) || !ta.Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func markSeen(seen map[[2]any]bool, x, y any) map[[2]any]bool {
+	if seen == nil {
+		seen = make(map[[2]any]bool)
+	}
+	seen[[2]any{x, y}] = true
+	return seen
+}
+
+// DeepCopy returns a copy of v in which LISTs, MAPs, TYPE instances and dictionaries are copied all the way
+// down, keeping shared and cyclic structure. Other values (numbers, strings, handles, functions) are returned as is.
+func DeepCopy(v Value) Value {
+	return deepCopy(v, make(map[any]Value))
+}
+
+func deepCopy(v Value, done map[any]Value) Value {
+	switch x := v.(type) {
+	case *List:
+		if c, ok := done[x]; ok {
+			return c
+		}
+		c := &List{Items: make([]Value, len(x.Items))}
+		done[x] = c
+		for i, it := range x.Items {
+			c.Items[i] = deepCopy(it, done)
+		}
+		return c
+	case *Map:
+		if c, ok := done[x]; ok {
+			return c
+		}
+		c := NewMap()
+		done[x] = c
+		for i, k := range x.keys {
+			c.index[k] = i
+			c.keys = append(c.keys, k)
+			c.values = append(c.values, deepCopy(x.values[i], done))
+		}
+		return c
+	case *Object:
+		if c, ok := done[x]; ok {
+			return c
+		}
+		c := &Object{Type: x.Type, Fields: make([]Value, len(x.Fields))}
+		done[x] = c
+		for i, f := range x.Fields {
+			c.Fields[i] = deepCopy(f, done)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(x))
+		for i, it := range x {
+			c[i] = deepCopy(it, done)
+		}
+		return c
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(x))
+		for k, it := range x {
+			c[k] = deepCopy(it, done)
+		}
+		return c
+	}
+	return v
+}
+
+// index implements coll[i] (OpIndex): LIST items by position, MAP entries by key (NIL when missing), legacy
+// dictionaries, JSON arrays and handles, and single characters of a string ("" when out of range).
+func (vm *VM) index(coll, i Value) (Value, error) {
+	switch c := coll.(type) {
+	case *List:
+		n, err := c.at(i)
+		if err != nil {
+			return nil, err
+		}
+		return c.Items[n], nil
+	case *Map:
+		if _, err := mapKey(i); err != nil {
+			return nil, err
+		}
+		v, _ := c.Get(i)
+		return v, nil
+	case map[string]interface{}:
+		return c[valueToString(i)], nil
+	case []interface{}:
+		n := valueToInt(i)
+		if n < 0 || n >= len(c) {
+			return nil, fmt.Errorf("index %d out of range (length %d)", n, len(c))
+		}
+		return c[n], nil
+	case string:
+		if key, ok := i.(string); ok {
+			// h["key"] on a JSON handle from the std bindings (LoadJSON, ParseJSON).
+			if fn := vm.foreign["getjsonkey"]; fn != nil {
+				return fn([]interface{}{c, key})
+			}
+		}
+	case int, float64, bool, nil:
+	default:
+		return nil, &errors.CyberError{
+			Code:    errors.ErrTypeMismatch,
+			Message: fmt.Sprintf("cannot index a %s", typeName(coll)),
+		}
+	}
+	// A single character, as s[i:i+1]: "" when out of range.
+	s, n := valueToString(coll), valueToInt(i)
+	if n < 0 || n >= len(s) {
+		return "", nil
+	}
+	return s[n : n+1], nil
+}
+
+// setIndex implements coll[i] = val (OpSetIndex).
+func (vm *VM) setIndex(coll, i, val Value) error {
+	switch c := coll.(type) {
+	case *List:
+		n, err := c.at(i)
+		if err != nil {
+			return err
+		}
+		c.Items[n] = val
+		return nil
+	case *Map:
+		return c.Set(i, val)
+	case map[string]interface{}:
+		c[valueToString(i)] = val
+		return nil
+	case []interface{}:
+		n := valueToInt(i)
+		if n < 0 || n >= len(c) {
+			return fmt.Errorf("index %d out of range (length %d)", n, len(c))
+		}
+		c[n] = val
+		return nil
+	}
+	return &errors.CyberError{
+		Code:       errors.ErrTypeMismatch,
+		Message:    fmt.Sprintf("cannot assign an item of a %s", typeName(coll)),
+		Suggestion: "Only LISTs, MAPs and arrays declared with DIM can be assigned by index.",
+	}
+}
+
+// iterator is the state of a FOR EACH loop (OpIterNew/OpIterNext): a snapshot of the keys and values taken when
+// the loop starts, so changing the collection inside the loop does not change what the loop visits.
+type iterator struct {
+	keys, values []Value
+	pos          int
+}
+
+// newIterator snapshots coll for FOR EACH. With one loop variable (vars == 1) a MAP or dictionary yields its keys;
+// otherwise the key (or index) and the value.
+func newIterator(coll Value, vars int) (*iterator, error) {
+	it := &iterator{}
+	indexed := func(n int, item func(int) Value) {
+		for i := 0; i < n; i++ {
+			it.keys = append(it.keys, i)
+			it.values = append(it.values, item(i))
+		}
+	}
+	switch c := coll.(type) {
+	case *List:
+		indexed(len(c.Items), func(i int) Value { return c.Items[i] })
+	case []interface{}:
+		indexed(len(c), func(i int) Value { return c[i] })
+	case string:
+		indexed(len(c), func(i int) Value { return c[i : i+1] })
+	case *Map:
+		it.keys, it.values = c.Keys(), c.Values()
+	case map[string]interface{}:
+		keys := make([]string, 0, len(c))
+		for k := range c {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			it.keys = append(it.keys, k)
+			it.values = append(it.values, c[k])
+		}
+	case nil:
+	default:
+		return nil, &errors.CyberError{
+			Code:       errors.ErrTypeMismatch,
+			Message:    fmt.Sprintf("FOR EACH cannot iterate a %s", typeName(coll)),
+			Suggestion: "FOR EACH works on LISTs, MAPs, dictionaries and strings.",
+		}
+	}
+	if vars == 1 {
+		switch coll.(type) {
+		case *Map, map[string]interface{}:
+			it.values = it.keys
+		}
+	}
+	return it, nil
+}
+
+// callCollectionMethod runs the LIST methods that call a BASIC function: sort(cmp), filter(fn) and map(fn). It
+// reports false for every other method, which List.CallMethod handles.
+func (vm *VM) callCollectionMethod(l *List, name string, args []Value) (Value, bool, error) {
+	switch {
+	case name == "sort" && len(args) == 1:
+		cmp := args[0]
+		items := append([]Value(nil), l.Items...)
+		var err error
+		sort.SliceStable(items, func(i, j int) bool {
+			if err != nil {
+				return false
+			}
+			r, cerr := vm.Invoke(cmp, []interface{}{items[i], items[j]})
+			if cerr != nil {
+				err = cerr
+				return false
+			}
+			switch r := r.(type) {
+			case bool:
+				return r
+			case int, float64:
+				return valueToFloat64(r) < 0
+			}
+			err = fmt.Errorf("LIST.sort comparator must return a boolean (a before b) or a number, got %s", typeName(r))
+			return false
+		})
+		if err != nil {
+			return nil, true, err
+		}
+		copy(l.Items, items)
+		return nil, true, nil
+	case name == "filter" || name == "map":
+		if len(args) != 1 {
+			return nil, true, fmt.Errorf("LIST.%s expects 1 argument (a function), got %d", name, len(args))
+		}
+		out := NewList()
+		for _, it := range append([]Value(nil), l.Items...) {
+			r, err := vm.Invoke(args[0], []interface{}{it})
+			if err != nil {
+				return nil, true, err
+			}
+			if name == "map" {
+				out.Items = append(out.Items, r)
+			} else if vm.isTruthy(r) {
+				out.Items = append(out.Items, it)
+			}
+		}
+		return out, true, nil
+	}
+	return nil, false, nil
+}
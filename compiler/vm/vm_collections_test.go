@@ -0,0 +1,62 @@
+package vm
+
+import "testing"
+
+func TestEqualAndDeepCopy(t *testing.T) {
+	m := NewMap()
+	_ = m.Set("xs", NewList(1, 2.5, "a"))
+	_ = m.Set(2.0, true)
+	if v, ok := m.Get(2); !ok || v != true {
+		t.Errorf("m[2] after m[2.0] = true: got %v, %v", v, ok)
+	}
+
+	c := DeepCopy(m).(*Map)
+	if !Equal(m, c) {
+		t.Fatalf("copy %v not equal to %v", c, m)
+	}
+	xs, _ := c.Get("xs")
+	xs.(*List).Items[0] = 7
+	if Equal(m, c) {
+		t.Error("changing the copy's nested list changed the original")
+	}
+
+	// Cycles are copied and compared without looping forever.
+	l := NewList(1)
+	l.Items = append(l.Items, l)
+	lc := DeepCopy(l).(*List)
+	if lc.Items[1] != lc || !Equal(l, lc) {
+		t.Errorf("cyclic copy = %v", lc.Items)
+	}
+
+	for _, tc := range []struct {
+		a, b Value
+		want bool
+	}{
+		{1, 1.0, true},
+		{"1", 1, false},
+		{NewList(), NewMap(), false},
+		{map[string]interface{}{"k": []interface{}{1}}, map[string]interface{}{"k": []interface{}{1.0}}, true},
+		{[]int{1}, []int{1}, false}, // uncomparable Go values are never equal and do not panic
+		{nil, nil, true},
+	} {
+		if got := Equal(tc.a, tc.b); got != tc.want {
+			t.Errorf("Equal(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestIteratorSnapshots(t *testing.T) {
+	it, err := newIterator(map[string]interface{}{"b": 2, "a": 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(it.keys) != 2 || it.keys[0] != "a" || it.values[1] != 2 {
+		t.Errorf("dictionary iteration = %v %v, want keys in sorted order", it.keys, it.values)
+	}
+	l := NewList(1, 2)
+	it, _ = newIterator(l, 1)
+	l.Items = append(l.Items, 3)
+	if len(it.values) != 2 {
+		t.Errorf("items added during the loop were visited: %v", it.values)
+	}
+}
@@ -1,21 +1,47 @@
 package vm
 
-import "fmt"
+import (
+	"cyberbasic/compiler/srcmap"
+	"fmt"
+	"sort"
+	"strings"
+)
 
-// ErrBreakpoint is returned when execution hits a breakpoint.
+// ErrBreakpoint is returned when execution hits a breakpoint. File and Line are the original source position.
 type ErrBreakpoint struct {
+	File string
 	Line int
 }
 
 func (e *ErrBreakpoint) Error() string {
-	return fmt.Sprintf("breakpoint at line %d", e.Line)
+	return fmt.Sprintf("breakpoint at %s", srcmap.Pos{File: e.File, Line: e.Line})
 }
 
-// SetBreakpoints sets the line numbers at which execution should stop.
+// SetBreakpoints sets the chunk line numbers at which execution should stop (see SetBreakpointsAt for file:line).
 func (vm *VM) SetBreakpoints(lines map[int]bool) {
 	vm.breakpoints = lines
 }
 
+// SetBreakpointsAt resolves original file:line locations through the chunk's SourceMap (file "" = main program)
+// and sets them as breakpoints. It returns the locations that match no code line.
+func (vm *VM) SetBreakpointsAt(locs []srcmap.Pos) (unresolved []srcmap.Pos) {
+	lines := make(map[int]bool)
+	for _, loc := range locs {
+		var found []int
+		if vm.chunk != nil {
+			found = vm.chunk.SourceMap.Find(loc.File, loc.Line)
+		}
+		if len(found) == 0 {
+			unresolved = append(unresolved, loc)
+		}
+		for _, l := range found {
+			lines[l] = true
+		}
+	}
+	vm.breakpoints = lines
+	return unresolved
+}
+
 // AddBreakpoint adds a breakpoint at the given line.
 func (vm *VM) AddBreakpoint(line int) {
 	if vm.breakpoints == nil {
@@ -41,7 +67,7 @@ func (vm *VM) SetDebugMode(enabled bool) {
 	vm.debugMode = enabled
 }
 
-// CurrentLine returns the source line at the current IP (0 if unknown).
+// CurrentLine returns the chunk (preprocessed source) line at the current IP (0 if unknown).
 func (vm *VM) CurrentLine() int {
 	if vm.chunk == nil || vm.ip < 0 || vm.ip >= len(vm.chunk.Code) {
 		return 0
@@ -49,6 +75,14 @@ func (vm *VM) CurrentLine() int {
 	return vm.chunk.LineAt(vm.ip)
 }
 
+// CurrentPos returns the original file and line at the current IP.
+func (vm *VM) CurrentPos() srcmap.Pos {
+	if vm.chunk == nil || vm.ip < 0 || vm.ip >= len(vm.chunk.Code) {
+		return srcmap.Pos{}
+	}
+	return vm.chunk.PosAt(vm.ip)
+}
+
 // CallDepth returns the current call stack depth (0 = top level).
 func (vm *VM) CallDepth() int {
 	return len(vm.callStack)
@@ -106,3 +140,163 @@ func (vm *VM) WatchValue(name string) (interface{}, bool) {
 	}
 	return vm.stack[idx], true
 }
+
+// DebugHook is called by Step before every instruction while set (see SetDebugHook). A debugger uses it to stop
+// on breakpoints and steps: it may block (e.g. waiting for "continue") and inspect the VM meanwhile.
+// A non-nil error aborts execution with that error.
+type DebugHook func(vm *VM) error
+
+// SetDebugHook installs hook (nil removes it).
+func (vm *VM) SetDebugHook(hook DebugHook) {
+	vm.debugHook = hook
+}
+
+// DebugVar is a named value shown by a debugger.
+type DebugVar struct {
+	Name  string
+	Value Value
+}
+
+// DebugFrame is a StackTrace frame with the Sub/Function it is in ("" = main program) and, when known, its parameters.
+type DebugFrame struct {
+	StackFrame
+	Func   string
+	Params []DebugVar
+}
+
+// DebugFrames returns StackTrace with function names and parameter values. Parameters are read from the
+// OpCallUser/InvokeSub frame of each call; frames entered some other way (GOSUB, event handlers) have none.
+func (vm *VM) DebugFrames() []DebugFrame {
+	trace := vm.StackTrace()
+	frames := make([]DebugFrame, len(trace))
+	for i, f := range trace {
+		frames[i] = DebugFrame{StackFrame: f, Func: vm.chunk.FunctionAt(f.IP)}
+	}
+	// Each call pushes a return address and (for user calls) a frame, so with equal counts frame k belongs to
+	// trace entry k. Otherwise only the innermost frame is certain.
+	n := len(vm.userCallFrames)
+	for k := 0; k < n && k < len(frames); k++ {
+		if n != len(vm.callStack) && k > 0 {
+			break
+		}
+		if frames[k].Func == "" {
+			continue
+		}
+		base := vm.userCallFrames[n-1-k].stackBase
+		for i, name := range vm.chunk.Params[frames[k].Func] {
+			var v Value
+			if base+i < len(vm.stack) {
+				v = vm.stack[base+i]
+			}
+			frames[k].Params = append(frames[k].Params, DebugVar{Name: name, Value: v})
+		}
+	}
+	return frames
+}
+
+// DebugVariables returns the program's variables (chunk slots) sorted by name, skipping compiler temporaries ("__" prefix).
+func (vm *VM) DebugVariables() []DebugVar {
+	if vm.chunk == nil {
+		return nil
+	}
+	vars := make([]DebugVar, 0, len(vm.chunk.Variables))
+	for name, idx := range vm.chunk.Variables {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		var v Value
+		if idx < len(vm.stack) {
+			v = vm.stack[idx]
+		}
+		vars = append(vars, DebugVar{Name: name, Value: v})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
+
+// Fiber states reported by Fibers.
+const (
+	FiberRunning  = "running"
+	FiberReady    = "ready"
+	FiberSleeping = "sleeping"
+	FiberPaused   = "paused"
+	FiberDone     = "done"
+)
+
+// FiberInfo describes one fiber: index 0 is the main program, others were started by StartCoroutine.
+type FiberInfo struct {
+	Index int
+	Name  string // Sub name; "" for the main program
+	State string
+	IP    int
+	File  string
+	Line  int
+}
+
+// Fibers returns every fiber with its state and position (the current IP for the running fiber).
+func (vm *VM) Fibers() []FiberInfo {
+	if vm.chunk == nil {
+		return nil
+	}
+	state := make(map[int]string)
+	for _, i := range vm.fiberQueue {
+		state[i] = FiberReady
+	}
+	for _, e := range vm.sleeping {
+		if e.isPaused {
+			state[e.fiberIndex] = FiberPaused
+		} else {
+			state[e.fiberIndex] = FiberSleeping
+		}
+	}
+	state[vm.currentFiber] = FiberRunning
+	out := make([]FiberInfo, len(vm.fibers))
+	for i, f := range vm.fibers {
+		ip := f.ip
+		if i == vm.currentFiber {
+			ip = vm.ip
+		}
+		st, ok := state[i]
+		if !ok {
+			st = FiberDone
+		}
+		pos := vm.chunk.PosAt(ip)
+		out[i] = FiberInfo{Index: i, Name: vm.fiberNames[i], State: st, IP: ip, File: pos.File, Line: pos.Line}
+	}
+	return out
+}
+
+// CurrentFiber returns the index of the running fiber (0 = main program; see Fibers).
+func (vm *VM) CurrentFiber() int {
+	return vm.currentFiber
+}
+
+// Evaluate runs expr, a chunk compiled from one expression against the loaded chunk's variables
+// (see codegen.EmitExpression), in the current state and returns its value. The program's IP, stack
+// and call frames are left as they were, so a stopped program can be inspected and then resumed.
+func (vm *VM) Evaluate(expr *Chunk) (result Value, err error) {
+	savedChunk, savedIP, savedStack := vm.chunk, vm.ip, len(vm.stack)
+	savedCalls, savedFrames, savedTry := len(vm.callStack), len(vm.userCallFrames), len(vm.tryHandlers)
+	savedRunning, savedDraw := vm.running, vm.insideDraw
+	defer func() {
+		vm.chunk, vm.ip = savedChunk, savedIP
+		vm.stack = vm.stack[:savedStack]
+		vm.callStack = vm.callStack[:savedCalls]
+		vm.userCallFrames = vm.userCallFrames[:savedFrames]
+		vm.tryHandlers = vm.tryHandlers[:savedTry]
+		vm.running, vm.insideDraw = savedRunning, savedDraw
+	}()
+	// Variable slots below savedStack are shared; OpLoadVar may grow the stack for slots the program has not reached yet.
+	vm.chunk, vm.ip = expr, 0
+	for vm.ip < len(expr.Code) {
+		op := expr.Code[vm.ip]
+		vm.ip++
+		if err := vm.executeInstruction(op); err != nil {
+			return nil, err
+		}
+	}
+	if len(vm.stack) <= savedStack {
+		return nil, fmt.Errorf("expression has no value")
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
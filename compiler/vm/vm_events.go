@@ -0,0 +1,163 @@
+package vm
+
+import (
+	"strings"
+	"time"
+)
+
+// polledEvents are the ON events ProcessEvents asks the runtime about each frame; every other event name is
+// delivered only when something posts it (EMIT, PostEvent from a binding, event timers).
+var polledEvents = map[string]bool{
+	"keydown": true, "keypressed": true, "keyreleased": true,
+	"mousedown": true, "mousepressed": true, "mousereleased": true,
+	"gamepaddown": true, "gamepadpressed": true, "gamepadreleased": true,
+	"resize": true, "focus": true, "blur": true,
+}
+
+// EventPoller is implemented by runtimes that report input and window state beyond keys. ProcessEvents asks it
+// once per frame for each distinct (eventType, key) an ON handler listens to; args are passed to the handler
+// (mouse position for mouse events, width and height for "resize").
+type EventPoller interface {
+	PollEvent(eventType, key string) (fired bool, args []Value)
+}
+
+// postedEvent is an event waiting in the queue for ProcessEvents.
+type postedEvent struct {
+	name string
+	key  string
+	args []interface{}
+}
+
+// eventTimer posts a "timer" event keyed by name every interval (once unless repeat).
+type eventTimer struct {
+	name     string
+	interval time.Duration
+	due      time.Time
+	repeat   bool
+}
+
+// PostEvent queues an event for ON name handlers; key narrows it to handlers registered with that key (ON
+// Collision3D("begin"), ON Timer("spawn")) and args are passed as the handler's parameters. Safe to call from
+// any goroutine; handlers run in posting order at the next ProcessEvents.
+func (vm *VM) PostEvent(name, key string, args ...interface{}) {
+	vm.eventMu.Lock()
+	vm.eventQueue = append(vm.eventQueue, postedEvent{name: strings.ToLower(name), key: key, args: args})
+	vm.eventMu.Unlock()
+}
+
+// HasEventHandler reports whether an ON handler for name has been registered, so bindings can skip building
+// events nobody listens to.
+func (vm *VM) HasEventHandler(name string) bool {
+	name = strings.ToLower(name)
+	for _, h := range vm.eventHandlers {
+		if h.eventType == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StartEventTimer posts a "timer" event keyed by name after seconds, and every seconds after that if repeat.
+// Starting a timer that already exists restarts it.
+func (vm *VM) StartEventTimer(name string, seconds float64, repeat bool) {
+	vm.StopEventTimer(name)
+	d := time.Duration(seconds * float64(time.Second))
+	vm.eventTimers = append(vm.eventTimers, eventTimer{name: name, interval: d, due: time.Now().Add(d), repeat: repeat})
+}
+
+// StopEventTimer removes the timer started with name, if any.
+func (vm *VM) StopEventTimer(name string) {
+	for i, t := range vm.eventTimers {
+		if strings.EqualFold(t.name, name) {
+			vm.eventTimers = append(vm.eventTimers[:i], vm.eventTimers[i+1:]...)
+			return
+		}
+	}
+}
+
+// ProcessEvents runs ON handlers: first input and window handlers whose condition the runtime reports this frame
+// (in registration order), then due timers, then the events posted since the last call in posting order. Events
+// posted while handlers run wait for the next call. The runtime calls it once per frame after input is polled
+// and physics has stepped (PollInputEvents, SYNC, the update/draw loop). Returns the first handler error.
+func (vm *VM) ProcessEvents() error {
+	if vm.chunk == nil {
+		return nil
+	}
+	if vm.runtime != nil {
+		poller, _ := vm.runtime.(EventPoller)
+		type polled struct {
+			fired bool
+			args  []Value
+		}
+		seen := make(map[[2]string]polled)
+		for _, h := range vm.eventHandlers {
+			if !polledEvents[h.eventType] {
+				continue
+			}
+			k := [2]string{h.eventType, h.key}
+			p, ok := seen[k]
+			if !ok {
+				switch {
+				case h.eventType == "keydown":
+					p.fired = vm.runtime.IsKeyDown(h.key)
+				case h.eventType == "keypressed":
+					p.fired = vm.runtime.IsKeyPressed(h.key)
+				case poller != nil:
+					p.fired, p.args = poller.PollEvent(h.eventType, h.key)
+				}
+				seen[k] = p
+			}
+			if !p.fired {
+				continue
+			}
+			args := make([]interface{}, len(p.args))
+			for i, a := range p.args {
+				args[i] = a
+			}
+			if err := vm.runHandler(h, args); err != nil {
+				return err
+			}
+		}
+	}
+	now := time.Now()
+	for i := 0; i < len(vm.eventTimers); i++ {
+		t := &vm.eventTimers[i]
+		if now.Before(t.due) {
+			continue
+		}
+		vm.PostEvent("timer", t.name, t.name)
+		if !t.repeat || t.interval <= 0 {
+			vm.eventTimers = append(vm.eventTimers[:i], vm.eventTimers[i+1:]...)
+			i--
+			continue
+		}
+		for !now.Before(t.due) {
+			t.due = t.due.Add(t.interval)
+		}
+	}
+	vm.eventMu.Lock()
+	queue := vm.eventQueue
+	vm.eventQueue = nil
+	vm.eventMu.Unlock()
+	for _, ev := range queue {
+		for _, h := range vm.eventHandlers {
+			if h.eventType != ev.name || (h.key != "" && !strings.EqualFold(h.key, ev.key)) {
+				continue
+			}
+			if err := vm.runHandler(h, ev.args); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runHandler calls the ON handler h with args.
+func (vm *VM) runHandler(h eventHandler, args []interface{}) error {
+	if h.fn != nil {
+		_, err := h.fn.callNative(args)
+		return err
+	}
+	_, err := vm.invokeAt(h.handlerIP, nil, args, false)
+	return err
+}
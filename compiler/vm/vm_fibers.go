@@ -18,7 +18,7 @@ func (vm *VM) errWithStack(err error) error {
 	var parts []string
 	for _, f := range trace {
 		if f.Line > 0 {
-			parts = append(parts, fmt.Sprintf("line %d", f.Line))
+			parts = append(parts, f.Pos().String())
 		}
 	}
 	if len(parts) > 0 {
@@ -46,6 +46,12 @@ func (vm *VM) StopTaskByName(name string) {
 		}
 	}
 	vm.sleeping = stillSleeping
+	// Stopped fibers count as finished, so AWAIT on their task does not wait forever.
+	for i, n := range vm.fiberNames {
+		if n == name && i < len(vm.fibers) {
+			vm.fibers[i].done = true
+		}
+	}
 	// If current fiber was stopped, switch to next (handled by Run loop when queue empty)
 }
 
@@ -78,7 +84,8 @@ func (vm *VM) ResumeTaskByName(name string) {
 	vm.sleeping = stillSleeping
 }
 
-// wakeSleeping moves any sleeping fibers whose resumeAt <= now back onto the run queue.
+// wakeSleeping moves any sleeping fibers whose resumeAt <= now back onto the run queue, and fibers whose AWAIT
+// has completed (the result replaces the awaited value on their stack for OpAwait to pick up).
 // Paused fibers (isPaused) are never auto-woken; only ResumeTask moves them.
 func (vm *VM) wakeSleeping() {
 	now := time.Now()
@@ -88,6 +95,18 @@ func (vm *VM) wakeSleeping() {
 			stillSleeping = append(stillSleeping, e)
 			continue
 		}
+		if e.awaiting != nil {
+			done, val, err := e.awaiting.Poll()
+			if !done {
+				stillSleeping = append(stillSleeping, e)
+				continue
+			}
+			awaited(e.awaiting)
+			f := &vm.fibers[e.fiberIndex]
+			f.stack[len(f.stack)-1] = awaitResult{val: val, err: err}
+			vm.fiberQueue = append(vm.fiberQueue, e.fiberIndex)
+			continue
+		}
 		if !e.resumeAt.After(now) {
 			vm.fiberQueue = append(vm.fiberQueue, e.fiberIndex)
 		} else {
@@ -97,31 +116,63 @@ func (vm *VM) wakeSleeping() {
 	vm.sleeping = stillSleeping
 }
 
-// ProcessEvents invokes registered On KeyDown/KeyPressed handlers when the runtime reports matching key state.
-// Call after PollInputEvents in the game loop. Returns the first error from a handler if any.
-func (vm *VM) ProcessEvents() error {
-	if vm.runtime == nil || vm.chunk == nil {
-		return nil
+// saveFiber stores the running fiber's registers in vm.fibers so another fiber can run.
+func (vm *VM) saveFiber() {
+	vm.fibers[vm.currentFiber] = fiberState{
+		ip:             vm.ip,
+		stack:          append([]Value(nil), vm.stack...),
+		callStack:      append([]int(nil), vm.callStack...),
+		drawFrameStack: append([]bool(nil), vm.drawFrameStack...),
+		userCallFrames: append([]userCallFrame(nil), vm.userCallFrames...),
+		tryHandlers:    append([]tryHandler(nil), vm.tryHandlers...),
 	}
-	depth := len(vm.callStack)
-	for _, h := range vm.eventHandlers {
-		trigger := false
-		switch h.eventType {
-		case "keydown":
-			trigger = vm.runtime.IsKeyDown(h.key)
-		case "keypressed":
-			trigger = vm.runtime.IsKeyPressed(h.key)
-		}
-		if !trigger {
-			continue
+}
+
+// loadFiber makes fiber i the running fiber, restoring the registers saveFiber stored.
+func (vm *VM) loadFiber(i int) {
+	vm.currentFiber = i
+	next := &vm.fibers[i]
+	vm.ip = next.ip
+	vm.stack = append(vm.stack[:0], next.stack...)
+	vm.callStack = append(vm.callStack[:0], next.callStack...)
+	vm.drawFrameStack = append(vm.drawFrameStack[:0], next.drawFrameStack...)
+	vm.userCallFrames = append([]userCallFrame(nil), next.userCallFrames...)
+	vm.tryHandlers = append(vm.tryHandlers[:0], next.tryHandlers...)
+	vm.insideDraw = false
+	for _, b := range vm.drawFrameStack {
+		if b {
+			vm.insideDraw = true
+			break
 		}
-		vm.callStack = append(vm.callStack, vm.ip)
-		vm.ip = h.handlerIP
-		for len(vm.callStack) > depth && vm.running && vm.ip < len(vm.chunk.Code) {
-			if err := vm.Step(); err != nil {
-				return err
-			}
+	}
+	vm.idle = false
+}
+
+// parkFiber takes the running fiber (already saved) off the run queue and switches to the next one. With none
+// runnable, the VM goes idle and Run waits for a sleeping or awaiting fiber to wake.
+func (vm *VM) parkFiber() {
+	newQueue := make([]int, 0, len(vm.fiberQueue))
+	for _, i := range vm.fiberQueue {
+		if i != vm.currentFiber {
+			newQueue = append(newQueue, i)
 		}
 	}
-	return nil
+	vm.fiberQueue = newQueue
+	if len(vm.fiberQueue) == 0 {
+		vm.idle = true
+		return
+	}
+	vm.loadFiber(vm.fiberQueue[0])
+}
+
+// finishFiber records that the running fiber returned result (for AWAIT on its task) and switches to the next
+// runnable fiber. It reports false when no other fiber is left to run or wake, i.e. the program is over.
+func (vm *VM) finishFiber(result Value) bool {
+	f := &vm.fibers[vm.currentFiber]
+	f.done, f.result = true, result
+	if len(vm.fiberQueue) <= 1 && len(vm.sleeping) == 0 {
+		return false
+	}
+	vm.parkFiber()
+	return true
 }
@@ -3,6 +3,7 @@ package vm
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // RegisterRenderType registers a command name for the hybrid render queue (2D, 3D, or GUI).
@@ -49,6 +50,69 @@ func (vm *VM) CallForeign(name string, args []interface{}) (interface{}, error)
 	return fn(args)
 }
 
+// callForeign implements OpCallForeign: it pops argCount args and calls the foreign function name, pushing its
+// result unless it is nil. A name with no foreign function calls the function value in the variable of that name.
+func (vm *VM) callForeign(name string, argCount int) error {
+	if len(vm.stack) < argCount {
+		return fmt.Errorf("stack underflow for foreign call %s: need %d args, have %d", name, argCount, len(vm.stack))
+	}
+	args := make([]interface{}, argCount)
+	for i := argCount - 1; i >= 0; i-- {
+		args[i] = vm.pop()
+	}
+	if vm.queueRenderCommand(name, args) {
+		return nil
+	}
+	fn := vm.foreign[strings.ToLower(name)]
+	if fn == nil {
+		if f := vm.functionVariable(name); f != nil {
+			vals := make([]Value, len(args))
+			for i, a := range args {
+				vals[i] = a
+			}
+			vm.callFunction(f, vals)
+			return nil
+		}
+		return fmt.Errorf("unknown foreign function: %s", name)
+	}
+	result, err := vm.runForeign(name, fn, args)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		vm.push(result)
+	}
+	return nil
+}
+
+// queueRenderCommand queues the call when it is a render command made inside draw() (hybrid loop) and reports
+// whether it did.
+func (vm *VM) queueRenderCommand(name string, args []interface{}) bool {
+	if vm.insideDraw && vm.renderCommandType != nil {
+		if typ := vm.renderCommandType[strings.ToLower(name)]; typ != RenderNone {
+			vm.PushRenderCommand(name, args, typ)
+			return true
+		}
+	}
+	return false
+}
+
+// runForeign calls fn, the foreign function name, for the profiler and with failures wrapped as ForeignError.
+func (vm *VM) runForeign(name string, fn ForeignFunc, args []interface{}) (Value, error) {
+	var started time.Time
+	if vm.profiler != nil {
+		started = time.Now()
+	}
+	result, err := fn(args)
+	if vm.profiler != nil {
+		vm.profiler.foreignCall(vm, vm.ip-1, name, time.Since(started))
+	}
+	if err != nil {
+		return nil, &ForeignError{Name: name, Err: err}
+	}
+	return result, nil
+}
+
 // SetInsideDraw sets whether we are inside the user's draw() call (so render commands are queued).
 func (vm *VM) SetInsideDraw(b bool) {
 	vm.insideDraw = b
@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Function is a first-class function value: a user Function or Sub referenced by name, or an anonymous
+// FUNCTION(...) ... END FUNCTION expression. Captured holds the enclosing parameters an anonymous function
+// captured when it was created; they follow the declared parameters in its call frame, and assignments to them
+// persist from one call to the next.
+type Function struct {
+	Name     string // lowercase Sub/Function name; "" for an anonymous function
+	IP       int
+	Params   int
+	Captured []Value
+	Native   func(args []Value) (Value, error) // Go body of a program compiled by gogen; IP is unused when set
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "function"
+	}
+	return "function " + f.Name
+}
+
+// frameArgs lays out args for a call of f: the declared parameters (missing ones nil, extra ones dropped)
+// followed by the captured values.
+func (f *Function) frameArgs(args []Value) []Value {
+	if len(args) == f.Params && len(f.Captured) == 0 {
+		return args
+	}
+	out := make([]Value, f.Params, f.Params+len(f.Captured))
+	copy(out, args)
+	return append(out, f.Captured...)
+}
+
+// saveCaptured copies the captured slots of a returning call frame (params, then captures) back into f.
+func (f *Function) saveCaptured(frame []Value) {
+	if f == nil || len(f.Captured) == 0 || len(frame) <= f.Params {
+		return
+	}
+	copy(f.Captured, frame[f.Params:])
+}
+
+// enterUserCall enters the user Sub/Function name at ip with args as its parameters (OpCallUser).
+func (vm *VM) enterUserCall(name string, ip int, args []Value) {
+	vm.userCallFrames = append(vm.userCallFrames, userCallFrame{stackBase: len(vm.stack)})
+	vm.callStack = append(vm.callStack, vm.ip)
+	isDraw := name == "draw"
+	vm.drawFrameStack = append(vm.drawFrameStack, isDraw)
+	if isDraw {
+		vm.insideDraw = true
+	}
+	vm.stack = append(vm.stack, args...)
+	vm.ip = ip
+}
+
+// callFunction enters f like OpCallUser, with a frame that leaves a result on the stack even when f is a Sub.
+func (vm *VM) callFunction(f *Function, args []Value) {
+	vm.userCallFrames = append(vm.userCallFrames, userCallFrame{stackBase: len(vm.stack), fn: f, value: true})
+	vm.callStack = append(vm.callStack, vm.ip)
+	isDraw := f.Name == "draw"
+	vm.drawFrameStack = append(vm.drawFrameStack, isDraw)
+	if isDraw {
+		vm.insideDraw = true
+	}
+	vm.stack = append(vm.stack, f.frameArgs(args)...)
+	vm.ip = f.IP
+}
+
+// functionVariable returns the function value held by the program variable name, or nil. Calls compiled before
+// the variable was first assigned (in a Sub compiled later) reach it through here.
+func (vm *VM) functionVariable(name string) *Function {
+	if vm.chunk == nil {
+		return nil
+	}
+	if idx, ok := vm.chunk.GetVariable(name); ok && idx < len(vm.stack) {
+		if f, ok := vm.stack[idx].(*Function); ok {
+			return f
+		}
+	}
+	f, _ := vm.globals[strings.ToLower(name)].(*Function)
+	return f
+}
+
+// Callback normalizes a callback argument given to a foreign function: a function value is kept, a Sub name is
+// trimmed, and nil or "" is nil (no callback). Bindings store the result and call it with Invoke.
+func Callback(v Value) Value {
+	switch c := v.(type) {
+	case nil:
+		return nil
+	case *Function:
+		return c
+	}
+	if name := strings.TrimSpace(fmt.Sprint(v)); name != "" {
+		return name
+	}
+	return nil
+}
+
+// Callable reports whether callback is a function value or names a Sub/Function of the loaded program.
+func (vm *VM) Callable(callback Value) bool {
+	switch c := callback.(type) {
+	case *Function:
+		return true
+	case string:
+		return vm.HasFunction(c)
+	}
+	return false
+}
+
+// Invoke calls callback, a function value or the name of a Sub/Function, with args and returns its result (nil
+// for a Sub). As with InvokeSub, an unknown name or nil does nothing. Returns when the callee returns.
+func (vm *VM) Invoke(callback Value, args []interface{}) (Value, error) {
+	if vm.chunk == nil || callback == nil {
+		return nil, nil
+	}
+	switch c := callback.(type) {
+	case *Function:
+		if c.Native != nil {
+			return c.callNative(args)
+		}
+		return vm.invokeAt(c.IP, c, args, c.Name == "draw")
+	case string:
+		name := strings.ToLower(c)
+		if f := vm.natives[name]; f != nil {
+			return f.callNative(args)
+		}
+		ip, ok := vm.chunk.GetFunction(name)
+		if !ok {
+			return nil, nil
+		}
+		return vm.invokeAt(ip, nil, args, name == "draw")
+	}
+	return nil, fmt.Errorf("cannot call %v: expected a function or a Sub name, got %T", callback, callback)
+}
@@ -0,0 +1,316 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"cyberbasic/compiler/errors"
+)
+
+// A program translated to Go by gogen runs its statements as Go code and uses the VM only as its runtime: the
+// foreign function registry, globals, events and the value semantics of the instructions. The methods here are
+// that interface; each does what the instruction of the same name does in bytecode.
+
+// RegisterFunction makes fn the user Sub or Function name (qualified, e.g. "player.move") of a program compiled
+// to Go, so InvokeSub, Invoke, Callable, HasFunction and method calls on TYPE instances find it like bytecode
+// ones. params is its parameter count (ME included for methods). It returns the function value for name.
+func (vm *VM) RegisterFunction(name string, params int, fn func(args []Value) (Value, error)) *Function {
+	if vm.natives == nil {
+		vm.natives = make(map[string]*Function)
+	}
+	f := NativeFunction(name, params, fn)
+	vm.natives[f.Name] = f
+	return f
+}
+
+// NativeFunction returns a function value whose body is fn, for anonymous FUNCTIONs of a program compiled to Go.
+func NativeFunction(name string, params int, fn func(args []Value) (Value, error)) *Function {
+	return &Function{Name: strings.ToLower(name), Params: params, Native: fn}
+}
+
+// HasFunction reports whether the program defines the Sub or Function name (in bytecode or with RegisterFunction).
+func (vm *VM) HasFunction(name string) bool {
+	name = strings.ToLower(name)
+	if vm.natives[name] != nil {
+		return true
+	}
+	if vm.chunk == nil {
+		return false
+	}
+	_, ok := vm.chunk.GetFunction(name)
+	return ok
+}
+
+// callNative runs the Go body of f with args laid out as for a bytecode call.
+func (f *Function) callNative(args []interface{}) (Value, error) {
+	vals := make([]Value, len(args))
+	for i, a := range args {
+		vals[i] = a
+	}
+	return f.Native(f.frameArgs(vals))
+}
+
+// RegisterEventHandler adds fn as an ON eventType(key) handler, as OpRegisterEvent does for a bytecode handler.
+func (vm *VM) RegisterEventHandler(eventType, key string, fn *Function) {
+	vm.eventHandlers = append(vm.eventHandlers, eventHandler{eventType: strings.ToLower(eventType), key: key, fn: fn})
+}
+
+// Apply runs the operand-free instruction op on args and returns what it leaves on the stack (nil when
+// nothing): arithmetic, comparisons, PRINT, STR and the math, string, file and collection builtins.
+// Instructions that move control (RETURN, YIELD, AWAIT, THROW, ...) are refused.
+func (vm *VM) Apply(op OpCode, args ...Value) (Value, error) {
+	if len(opOperands[op]) > 0 || notApplicable[op] {
+		return nil, fmt.Errorf("%s cannot be applied outside the VM", op)
+	}
+	base := len(vm.stack)
+	vm.stack = append(vm.stack, args...)
+	err := vm.execute(op, false)
+	var result Value
+	if len(vm.stack) > base {
+		result = vm.stack[len(vm.stack)-1]
+		vm.stack = vm.stack[:base]
+	}
+	return result, err
+}
+
+// notApplicable are the operand-free instructions Apply refuses: they change control flow or fiber state, or
+// leave more than one value.
+var notApplicable = map[OpCode]bool{
+	OpWide: true, OpPop: true, OpDup: true, OpSwap: true, OpEndTry: true, OpThrow: true,
+	OpReturn: true, OpReturnVal: true, OpYield: true, OpWaitSeconds: true, OpAwait: true,
+	OpHalt: true, OpQuit: true, OpIterNext: true,
+}
+
+// Global returns the global name as OpLoadGlobal does: a global variable, or else the result of the foreign
+// function of that name called without arguments (KEY_W).
+func (vm *VM) Global(name string) (Value, error) {
+	key := strings.ToLower(name)
+	if value, exists := vm.globals[key]; exists {
+		return value, nil
+	}
+	if fn := vm.foreign[key]; fn != nil {
+		result, err := fn(nil)
+		if err != nil {
+			return nil, fmt.Errorf("global/constant %s: %w", name, err)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("undefined global variable: %s", name)
+}
+
+// EntityProp reads entity.prop as OpLoadEntityProp does: through a registered entity getter, else from the
+// ENTITY's dictionary (nil for a missing property).
+func (vm *VM) EntityProp(entity, prop string) (Value, error) {
+	entityLower := strings.ToLower(entity)
+	propLower := strings.ToLower(prop)
+	for _, key := range []string{propLower, entityLower + "." + propLower} {
+		if fn := vm.entityGetters[key]; fn != nil {
+			if v, ok := fn(entityLower, propLower); ok {
+				return v, nil
+			}
+		}
+	}
+	m, err := vm.entityDict(entity)
+	if err != nil {
+		return nil, err
+	}
+	if val, has := m[prop]; has {
+		return val, nil
+	}
+	return m[propLower], nil
+}
+
+// SetEntityProp assigns entity.prop as OpStoreEntityProp does: through a registered entity setter, else in the
+// ENTITY's dictionary.
+func (vm *VM) SetEntityProp(entity, prop string, val Value) error {
+	entityLower := strings.ToLower(entity)
+	propLower := strings.ToLower(prop)
+	for _, key := range []string{propLower, entityLower + "." + propLower} {
+		if fn := vm.entitySetters[key]; fn != nil {
+			fn(entityLower, propLower, val)
+			return nil
+		}
+	}
+	m, err := vm.entityDict(entity)
+	if err != nil {
+		return err
+	}
+	m[prop] = val
+	return nil
+}
+
+func (vm *VM) entityDict(entity string) (map[string]interface{}, error) {
+	g, exists := vm.globals[strings.ToLower(entity)]
+	if !exists {
+		return nil, fmt.Errorf("entity not found: %s", entity)
+	}
+	m, ok := g.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("entity %s is not a map", entity)
+	}
+	return m, nil
+}
+
+// GetProp reads obj.path (OpGetProp). A vector ([]interface{} from GetMousePosition, Vector3, …) has .x/.y/.z.
+func (vm *VM) GetProp(obj Value, path []string) (Value, error) {
+	if vec, ok := obj.([]interface{}); ok && len(path) == 1 {
+		if i := strings.Index("xyz", strings.ToLower(path[0])); i >= 0 && len(path[0]) == 1 {
+			if i < len(vec) {
+				return valueToFloat64(vec[i]), nil
+			}
+			return float64(0), nil
+		}
+	}
+	d, ok := obj.(DotObject)
+	if !ok {
+		return nil, &errors.CyberError{
+			Code:       errors.ErrDotAccess,
+			Message:    fmt.Sprintf("Value of type %T does not support property access", obj),
+			Suggestion: "Use a handle returned from PHYSICS, WINDOW, AUDIO, etc.",
+		}
+	}
+	return d.GetProp(path)
+}
+
+// SetProp assigns obj.path = val (OpSetProp).
+func (vm *VM) SetProp(obj Value, path []string, val Value) error {
+	d, ok := obj.(DotObject)
+	if !ok {
+		return &errors.CyberError{
+			Code:       errors.ErrDotAccess,
+			Message:    fmt.Sprintf("Cannot assign property on type %T", obj),
+			Suggestion: "Assign to handles returned from high-level APIs (WINDOW, PHYSICS, …).",
+		}
+	}
+	return d.SetProp(path, val)
+}
+
+// CallMethod calls obj.name(args) (OpCallMethod). The methods of a TYPE instance must have been registered
+// with RegisterFunction; the bytecode ones run only inside the VM.
+func (vm *VM) CallMethod(obj Value, name string, args []Value) (Value, error) {
+	name = strings.ToLower(name)
+	o, ok := obj.(*Object)
+	if !ok {
+		return vm.callValueMethod(obj, name, args)
+	}
+	qualified := strings.ToLower(o.Type.Name) + "." + name
+	f := vm.natives[qualified]
+	if f == nil {
+		if i := o.field(name); i >= 0 {
+			if fn, isFn := o.Fields[i].(*Function); isFn {
+				callArgs := make([]interface{}, len(args))
+				for j, a := range args {
+					callArgs[j] = a
+				}
+				return vm.Invoke(fn, callArgs)
+			}
+		}
+		return nil, &errors.CyberError{
+			Code:    errors.ErrDotAccess,
+			Message: fmt.Sprintf("TYPE %s has no method %s", o.Type.Name, name),
+		}
+	}
+	if f.Params-1 != len(args) {
+		return nil, fmt.Errorf("%s.%s expects %d arguments, got %d", o.Type.Name, name, f.Params-1, len(args))
+	}
+	return f.Native(append([]Value{o}, args...))
+}
+
+// callValueMethod calls name on a LIST (its higher-order methods included) or a DotObject handle.
+func (vm *VM) callValueMethod(obj Value, name string, args []Value) (Value, error) {
+	if l, ok := obj.(*List); ok {
+		ret, handled, err := vm.callCollectionMethod(l, name, args)
+		if err != nil || handled {
+			return ret, err
+		}
+	}
+	d, ok := obj.(DotObject)
+	if !ok {
+		return nil, &errors.CyberError{
+			Code:       errors.ErrDotAccess,
+			Message:    fmt.Sprintf("Value of type %T has no methods", obj),
+			Suggestion: "Call methods on API handles (sound.PLAY, etc.).",
+		}
+	}
+	return d.CallMethod(name, args)
+}
+
+// NewObject returns a new instance of the TYPE name with default fields (OpNewObject).
+func (vm *VM) NewObject(name string) (*Object, error) {
+	if vm.chunk == nil {
+		return nil, fmt.Errorf("unknown TYPE %s", name)
+	}
+	return vm.newObject(strings.ToLower(name), nil)
+}
+
+// Each returns the keys and values FOR EACH visits in coll (OpIterNew with vars loop variables).
+func Each(coll Value, vars int) (keys, values []Value, err error) {
+	it, err := newIterator(coll, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	return it.keys, it.values, nil
+}
+
+// Foreign calls the foreign function name as OpCallForeign does: inside draw() a render command is queued
+// instead, and a failure is returned as a *ForeignError.
+func (vm *VM) Foreign(name string, args []interface{}) (Value, error) {
+	if vm.queueRenderCommand(name, args) {
+		return nil, nil
+	}
+	fn := vm.foreign[strings.ToLower(name)]
+	if fn == nil {
+		return nil, fmt.Errorf("unknown foreign function: %s", name)
+	}
+	return vm.runForeign(name, fn, args)
+}
+
+// InsideDraw reports whether render commands are being queued (see SetInsideDraw).
+func (vm *VM) InsideDraw() bool {
+	return vm.insideDraw
+}
+
+// ArrayOffset returns where the element at indices lies in the flat storage of a DIM array (OpLoadArray,
+// OpStoreArray): row-major over dims, or the single index of a DIM a() array when dims is empty.
+func ArrayOffset(arr []Value, dims []int, indices []Value) (int, error) {
+	if len(dims) == 0 {
+		dims = []int{len(arr)}
+	}
+	if len(indices) != len(dims) {
+		return 0, fmt.Errorf("array has %d dimensions, got %d indices", len(dims), len(indices))
+	}
+	idx := 0
+	stride := 1
+	for d := len(dims) - 1; d >= 0; d-- {
+		idx += valueToInt(indices[d]) * stride
+		stride *= dims[d]
+	}
+	if idx < 0 || idx >= len(arr) {
+		return 0, fmt.Errorf("array index out of bounds: %d", idx)
+	}
+	return idx, nil
+}
+
+// MatMul stores the matrix product a × b in r (OpMatMul); each is a 2D DIM array with the given dims.
+func MatMul(r, a, b []Value, rd, ad, bd []int) error {
+	if len(rd) != 2 || len(ad) != 2 || len(bd) != 2 {
+		return fmt.Errorf("OpMatMul: all three must be 2D arrays")
+	}
+	n, m, p := ad[0], ad[1], bd[1]
+	if bd[0] != m {
+		return fmt.Errorf("OpMatMul: A columns (%d) != B rows (%d)", m, bd[0])
+	}
+	if rd[0] != n || rd[1] != p {
+		return fmt.Errorf("OpMatMul: result must be %d×%d", n, p)
+	}
+	for i := 0; i < n; i++ {
+		for j := 0; j < p; j++ {
+			var sum float64
+			for k := 0; k < m; k++ {
+				sum += valueToFloat64(a[i*m+k]) * valueToFloat64(b[k*p+j])
+			}
+			r[i*p+j] = sum
+		}
+	}
+	return nil
+}
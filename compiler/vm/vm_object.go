@@ -0,0 +1,158 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"cyberbasic/compiler/errors"
+)
+
+// TypeInfo describes a TYPE ... END TYPE for OpNewObject: its declared name and data fields in declaration order.
+// Its methods are the user functions "type.method" in Chunk.Functions.
+type TypeInfo struct {
+	Name   string
+	Fields []FieldInfo
+}
+
+// FieldInfo is one data field of a TYPE: its lowercase name, the value a new instance starts with, and the
+// lowercase name of its TYPE when the field is itself a TYPE (a new instance gets a nested instance).
+type FieldInfo struct {
+	Name     string
+	Default  Value
+	TypeName string
+}
+
+// Object is an instance of a TYPE, created by DIM p AS Type or Type(args). Objects are shared by reference:
+// assigning one or passing it to a Sub does not copy it.
+type Object struct {
+	Type   *TypeInfo
+	Fields []Value // in Type.Fields order
+}
+
+func (o *Object) field(name string) int {
+	for i, f := range o.Type.Fields {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *Object) noField(name string) error {
+	return &errors.CyberError{
+		Code:    errors.ErrDotAccess,
+		Message: fmt.Sprintf("TYPE %s has no field %s", o.Type.Name, name),
+	}
+}
+
+// GetProp returns the field path[0], descending into nested objects and handles for the rest of the path.
+func (o *Object) GetProp(path []string) (Value, error) {
+	if len(path) == 0 {
+		return o, nil
+	}
+	i := o.field(strings.ToLower(path[0]))
+	if i < 0 {
+		return nil, o.noField(path[0])
+	}
+	if len(path) == 1 {
+		return o.Fields[i], nil
+	}
+	d, ok := o.Fields[i].(DotObject)
+	if !ok {
+		return nil, fmt.Errorf("%s.%s has no field %s", o.Type.Name, path[0], path[1])
+	}
+	return d.GetProp(path[1:])
+}
+
+// SetProp assigns the field at path, descending into nested objects and handles.
+func (o *Object) SetProp(path []string, val Value) error {
+	if len(path) == 0 {
+		return fmt.Errorf("cannot assign to a %s instance itself", o.Type.Name)
+	}
+	i := o.field(strings.ToLower(path[0]))
+	if i < 0 {
+		return o.noField(path[0])
+	}
+	if len(path) == 1 {
+		o.Fields[i] = val
+		return nil
+	}
+	d, ok := o.Fields[i].(DotObject)
+	if !ok {
+		return fmt.Errorf("%s.%s has no field %s", o.Type.Name, path[0], path[1])
+	}
+	return d.SetProp(path[1:], val)
+}
+
+// CallMethod is only reached from Go: OpCallMethod runs an object's BASIC methods itself (callMethod).
+func (o *Object) CallMethod(name string, args []Value) (Value, error) {
+	return nil, fmt.Errorf("%s.%s: methods of a TYPE run in the VM", o.Type.Name, name)
+}
+
+func (o *Object) String() string {
+	var b strings.Builder
+	b.WriteString(o.Type.Name + "{")
+	for i, f := range o.Type.Fields {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s: %v", f.Name, o.Fields[i])
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// newObject creates an instance of the TYPE named name (lowercase) with default fields. A field whose TYPE is
+// already being built further up (a linked list node) starts as nil instead of recursing.
+func (vm *VM) newObject(name string, building map[string]bool) (*Object, error) {
+	ti := vm.chunk.Types[name]
+	if ti == nil {
+		return nil, fmt.Errorf("unknown TYPE %s", name)
+	}
+	o := &Object{Type: ti, Fields: make([]Value, len(ti.Fields))}
+	for i, f := range ti.Fields {
+		o.Fields[i] = f.Default
+		if f.TypeName == "" || vm.chunk.Types[f.TypeName] == nil {
+			continue
+		}
+		if building == nil {
+			building = map[string]bool{}
+		}
+		if building[f.TypeName] || f.TypeName == name {
+			o.Fields[i] = nil
+			continue
+		}
+		building[name] = true
+		nested, err := vm.newObject(f.TypeName, building)
+		delete(building, name)
+		if err != nil {
+			return nil, err
+		}
+		o.Fields[i] = nested
+	}
+	return o, nil
+}
+
+// callMethod enters the method name of o's TYPE like OpCallUser, with o as ME. A field holding a function value
+// is called the same way without ME.
+func (vm *VM) callMethod(o *Object, name string, args []Value) error {
+	qualified := strings.ToLower(o.Type.Name) + "." + name
+	ip, ok := vm.chunk.GetFunction(qualified)
+	if !ok {
+		if i := o.field(name); i >= 0 {
+			if f, isFn := o.Fields[i].(*Function); isFn {
+				vm.callFunction(f, args)
+				return nil
+			}
+		}
+		return &errors.CyberError{
+			Code:    errors.ErrDotAccess,
+			Message: fmt.Sprintf("TYPE %s has no method %s", o.Type.Name, name),
+		}
+	}
+	if params, known := vm.chunk.Params[qualified]; known && len(params)-1 != len(args) {
+		return fmt.Errorf("%s.%s expects %d arguments, got %d", o.Type.Name, name, len(params)-1, len(args))
+	}
+	vm.enterUserCall(qualified, ip, append([]Value{o}, args...))
+	return nil
+}
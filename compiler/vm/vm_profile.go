@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileCheckEvery is how many instructions run between clock reads while profiling (a power of two).
+const profileCheckEvery = 64
+
+// ProfileFrame is one level of a sampled BASIC call stack. Func is the Sub/Function name ("main" for the main
+// program); foreign calls are leaf frames with Foreign set and no source position.
+type ProfileFrame struct {
+	Func    string
+	File    string
+	Line    int
+	Foreign bool
+}
+
+// ProfileSample aggregates identical stacks (root first). Count is the number of samples or foreign calls and
+// Nanos the time they stand for: the sampling interval per sample, the measured duration for foreign calls.
+type ProfileSample struct {
+	Stack []ProfileFrame
+	Count int64
+	Nanos int64
+}
+
+// Profiler samples the running VM's call stack every Interval and times every OpCallForeign.
+// Attach it with VM.SetProfiler before Run; it is not safe for use from other goroutines while the VM runs.
+type Profiler struct {
+	Interval time.Duration
+	Start    time.Time
+	End      time.Time // set by Stop
+
+	steps   uint64
+	last    time.Time
+	samples map[string]*ProfileSample
+	order   []string // sample keys in first-seen order, for stable output
+}
+
+// NewProfiler returns a profiler sampling every interval (1ms when interval <= 0).
+func NewProfiler(interval time.Duration) *Profiler {
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	now := time.Now()
+	return &Profiler{Interval: interval, Start: now, last: now, samples: make(map[string]*ProfileSample)}
+}
+
+// SetProfiler attaches p (nil detaches). Sampling happens between instructions, so the VM needs no extra goroutine.
+func (vm *VM) SetProfiler(p *Profiler) {
+	vm.profiler = p
+}
+
+// Stop records the end of the profiled run.
+func (p *Profiler) Stop() {
+	p.End = time.Now()
+}
+
+// Duration is the profiled wall time (until now if Stop was not called).
+func (p *Profiler) Duration() time.Duration {
+	if p.End.IsZero() {
+		return time.Since(p.Start)
+	}
+	return p.End.Sub(p.Start)
+}
+
+// Samples returns the aggregated stacks in first-seen order.
+func (p *Profiler) Samples() []ProfileSample {
+	out := make([]ProfileSample, 0, len(p.order))
+	for _, k := range p.order {
+		out = append(out, *p.samples[k])
+	}
+	return out
+}
+
+// tick is called before each instruction; it takes a sample once Interval has passed since the last one.
+func (p *Profiler) tick(vm *VM) {
+	p.steps++
+	if p.steps%profileCheckEvery != 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(p.last) < p.Interval {
+		return
+	}
+	p.last = now
+	p.add(vm.profileStack(vm.ip), 1, int64(p.Interval))
+}
+
+// foreignCall records one foreign call of duration d made from the instruction at ip. The sampling clock skips
+// the call so its time is not also charged to the BASIC code that made it.
+func (p *Profiler) foreignCall(vm *VM, ip int, name string, d time.Duration) {
+	if n, ok := vm.foreignNames[strings.ToLower(name)]; ok {
+		name = n
+	}
+	stack := append(vm.profileStack(ip), ProfileFrame{Func: name, Foreign: true})
+	p.add(stack, 1, int64(d))
+	p.last = p.last.Add(d)
+}
+
+func (p *Profiler) add(stack []ProfileFrame, count, nanos int64) {
+	var key strings.Builder
+	for _, f := range stack {
+		key.WriteString(f.Func)
+		key.WriteByte(0)
+		key.WriteString(f.File)
+		key.WriteByte(0)
+		key.WriteString(strconv.Itoa(f.Line))
+		key.WriteByte(1)
+	}
+	k := key.String()
+	s, ok := p.samples[k]
+	if !ok {
+		s = &ProfileSample{Stack: stack}
+		p.samples[k] = s
+		p.order = append(p.order, k)
+	}
+	s.Count += count
+	s.Nanos += nanos
+}
+
+// profileStack returns the BASIC call stack at ip, root first. Callers are located by their return addresses
+// (the call instruction ends just before them); function names come from Chunk.Functions.
+func (vm *VM) profileStack(ip int) []ProfileFrame {
+	frames := make([]ProfileFrame, 0, len(vm.callStack)+1)
+	for _, ret := range vm.callStack {
+		if ret <= 0 || ret >= len(vm.chunk.Code) {
+			continue // entered from Go (InvokeSub after the main program ended): no BASIC caller
+		}
+		frames = append(frames, vm.profileFrame(ret-1))
+	}
+	return append(frames, vm.profileFrame(ip))
+}
+
+func (vm *VM) profileFrame(ip int) ProfileFrame {
+	name := vm.chunk.FunctionAt(ip)
+	if name == "" {
+		name = "main"
+	}
+	pos := vm.chunk.PosAt(ip)
+	return ProfileFrame{Func: name, File: pos.File, Line: pos.Line}
+}
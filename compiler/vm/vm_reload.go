@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"fmt"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+// SetSpareVariables makes LoadChunk reserve n stack slots beyond the chunk's variables, so a Reload can add up
+// to n variables (say, a Sub edited to use a new one) while the program runs. Call it before LoadChunk.
+func (vm *VM) SetSpareVariables(n int) {
+	vm.spareVars = n
+}
+
+// Reload swaps in next, a newer build of the running program compiled with CompileOptions.Base set to Chunk().
+// Every value the program holds survives: variables, objects, assets, physics worlds and entities.
+// next's code is appended after the running code, so the main program, the calls in progress and ON handlers
+// carry on in the code they started with, while every later call of a Sub, Function or TYPE method by name
+// (update and draw included) runs the new code. Function values made before the reload keep their old code.
+// Call it between instructions, e.g. from a foreign function at the end of a frame.
+func (vm *VM) Reload(next *Chunk) error {
+	old := vm.chunk
+	if old == nil {
+		return fmt.Errorf("no chunk loaded")
+	}
+	for name, idx := range old.Variables {
+		if j, ok := next.Variables[name]; !ok || j != idx {
+			return fmt.Errorf("variable %s has a different slot in the new program (compile it with the running chunk as Base)", name)
+		}
+	}
+	if len(next.Constants) < len(old.Constants) {
+		return fmt.Errorf("the new program has fewer constants than the running one (compile it with the running chunk as Base)")
+	}
+	for i, c := range old.Constants {
+		if !constantEqual(c, next.Constants[i]) {
+			return fmt.Errorf("constant %d differs in the new program (compile it with the running chunk as Base)", i)
+		}
+	}
+	if len(next.Variables) > vm.varSlots {
+		return fmt.Errorf("the new program adds %d variables but only %d more fit while it runs; restart it",
+			len(next.Variables)-len(old.Variables), vm.varSlots-len(old.Variables))
+	}
+
+	offset := len(old.Code)
+	merged := *next
+	merged.Code = make([]byte, 0, offset+len(next.Code))
+	merged.Code = append(append(merged.Code, old.Code...), next.Code...)
+	// Jumps are relative; absolute targets (handlers, coroutines, TRY, function values) move with the code.
+	for pos := 0; pos < len(next.Code); {
+		in, err := next.DecodeInstruction(pos)
+		if err != nil {
+			return err
+		}
+		at := offset + pos + 1
+		if in.Wide {
+			at++
+		}
+		for i, k := range in.Kinds {
+			if k == OperandTarget && !merged.PatchTarget(at, in.Operands[i]+offset, in.Wide) {
+				return fmt.Errorf("the reloaded code does not fit after %d bytes of running code; restart the program", offset)
+			}
+			at += operandWidth(k, in.Wide)
+		}
+		pos += in.Size
+	}
+	merged.Functions = make(map[string]int, len(next.Functions))
+	for name, ip := range next.Functions {
+		merged.Functions[name] = ip + offset
+	}
+
+	m, base := reloadSourceMap(old, next)
+	merged.SourceMap = m
+	merged.Lines = make([]int, 0, len(merged.Code))
+	merged.Lines = append(merged.Lines, old.Lines...)
+	for _, line := range next.Lines {
+		if line > 0 {
+			line += base
+		}
+		merged.Lines = append(merged.Lines, line)
+	}
+
+	vm.chunk = &merged
+	if vm.dataIndex > len(merged.DataValues) {
+		vm.dataIndex = len(merged.DataValues)
+	}
+	return nil
+}
+
+// reloadSourceMap returns a map covering the lines of old followed by those of next, and the number of old
+// lines, which next's line numbers are shifted by. Chunks without a map count as one unnamed file.
+func reloadSourceMap(old, next *Chunk) (*srcmap.Map, int) {
+	main := ""
+	if old.SourceMap != nil && len(old.SourceMap.Files) > 0 {
+		main = old.SourceMap.Files[0]
+	}
+	m := srcmap.New(main)
+	add := func(c *Chunk) int {
+		n := 0
+		if c.SourceMap != nil {
+			n = len(c.SourceMap.Lines)
+		}
+		for _, line := range c.Lines {
+			n = max(n, line)
+		}
+		for line := 1; line <= n; line++ {
+			p := c.SourceMap.Lookup(line)
+			m.Add(p.File, p.Line)
+		}
+		return n
+	}
+	base := add(old)
+	add(next)
+	return m, base
+}
@@ -2,27 +2,40 @@ package vm
 
 import (
 	"bufio"
-	"cyberbasic/compiler/errors"
 	"fmt"
 	"math"
-	"math/rand"
 	"os"
 	"strings"
 	"time"
+
+	"cyberbasic/compiler/rng"
 )
 
 // executeInstruction executes a single bytecode instruction
 func (vm *VM) executeInstruction(instruction byte) error {
-	op := OpCode(instruction)
+	return vm.execute(OpCode(instruction), false)
+}
 
+// execute runs op; wide is set when the instruction was prefixed by OpWide (4-byte indices, 32-bit jumps).
+func (vm *VM) execute(op OpCode, wide bool) error {
 	switch op {
-	case OpPush:
+	case OpWide:
 		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+			return fmt.Errorf("unexpected end of code after OpWide")
 		}
-		value := vm.chunk.Code[vm.ip]
+		next := OpCode(vm.chunk.Code[vm.ip])
 		vm.ip++
-		vm.push(value)
+		if next == OpWide {
+			return fmt.Errorf("OpWide cannot prefix OpWide")
+		}
+		return vm.execute(next, true)
+
+	case OpPush:
+		value, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		vm.push(byte(value))
 
 	case OpPop:
 		vm.pop()
@@ -43,11 +56,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(b)
 
 	case OpLoadConst:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		constIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		constIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		if constIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds")
@@ -55,11 +67,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(vm.chunk.Constants[constIndex])
 
 	case OpLoadString:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		constIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		constIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		if constIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds")
@@ -67,11 +78,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(vm.chunk.Constants[constIndex])
 
 	case OpLoadVar:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		// Ensure stack has space for variables
 		for varIndex >= len(vm.stack) {
@@ -81,11 +91,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(vm.stack[varIndex])
 
 	case OpStoreVar:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		var value Value
 		if len(vm.stack) > 0 {
@@ -101,12 +110,41 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 		vm.stack[varIndex] = value
 
+	case OpTry:
+		catchIP, err := vm.readTarget(wide)
+		if err != nil {
+			return err
+		}
+		vm.pushTryHandler(catchIP)
+
+	case OpEndTry:
+		vm.popTryHandler()
+
+	case OpThrow:
+		if len(vm.stack) == 0 {
+			return fmt.Errorf("stack underflow for THROW")
+		}
+		return vm.throwValue(vm.pop(), vm.ip-1)
+
+	case OpEmit:
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) < argCount+1 {
+			return fmt.Errorf("stack underflow for EMIT")
+		}
+		args := make([]interface{}, argCount)
+		for i := argCount - 1; i >= 0; i-- {
+			args[i] = vm.pop()
+		}
+		vm.PostEvent(fmt.Sprint(vm.pop()), "", args...)
+
 	case OpLoadParam:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		paramIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		paramIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if len(vm.userCallFrames) == 0 {
 			return fmt.Errorf("LoadParam outside Sub/Function call")
 		}
@@ -117,11 +155,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(vm.stack[slot])
 
 	case OpStoreParam:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		paramIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		paramIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if len(vm.userCallFrames) == 0 {
 			return fmt.Errorf("StoreParam outside Sub/Function call")
 		}
@@ -138,11 +175,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.stack[slot] = value
 
 	case OpLoadGlobal:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		constIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		constIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		if constIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds")
@@ -152,30 +188,18 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("global name must be a string")
 		}
-		// Case-insensitive: canonical form is lowercase
-		key := strings.ToLower(varName)
-
-		value, exists := vm.globals[key]
-		if !exists {
-			// Allow 0-arg foreign functions as constants (e.g. KEY_W, KEY_A)
-			if fn := vm.foreign[key]; fn != nil {
-				result, err := fn(nil)
-				if err != nil {
-					return fmt.Errorf("global/constant %s: %w", varName, err)
-				}
-				vm.push(result)
-				break
-			}
-			return fmt.Errorf("undefined global variable: %s", varName)
+		// Case-insensitive; 0-arg foreign functions double as constants (e.g. KEY_W, KEY_A)
+		value, err := vm.Global(varName)
+		if err != nil {
+			return err
 		}
 		vm.push(value)
 
 	case OpStoreGlobal:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		constIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		constIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		if constIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds")
@@ -195,50 +219,24 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.globals[key] = vm.pop()
 
 	case OpLoadEntityProp:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpLoadEntityProp")
+		entityIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		propIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		entityIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		propIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if entityIdx >= len(vm.chunk.Constants) || propIdx >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds for entity prop")
 		}
 		entityName, _ := vm.chunk.Constants[entityIdx].(string)
 		propName, _ := vm.chunk.Constants[propIdx].(string)
-		entityLower := strings.ToLower(entityName)
-		propLower := strings.ToLower(propName)
-		if vm.entityGetters != nil {
-			if fn := vm.entityGetters[propLower]; fn != nil {
-				if v, ok := fn(entityLower, propLower); ok {
-					vm.push(v)
-					break
-				}
-			}
-			if fn := vm.entityGetters[entityLower+"."+propLower]; fn != nil {
-				if v, ok := fn(entityLower, propLower); ok {
-					vm.push(v)
-					break
-				}
-			}
-		}
-		// Fallback: load from globals[entityName] map
-		g, exists := vm.globals[entityLower]
-		if !exists {
-			return fmt.Errorf("entity not found: %s", entityName)
-		}
-		m, ok := g.(map[string]interface{})
-		if !ok {
-			return fmt.Errorf("entity %s is not a map", entityName)
-		}
-		if val, has := m[propName]; has {
-			vm.push(val)
-		} else if val, has := m[propLower]; has {
-			vm.push(val)
-		} else {
-			vm.push(nil)
+		val, err := vm.EntityProp(entityName, propName)
+		if err != nil {
+			return err
 		}
+		vm.push(val)
 
 	case OpStoreEntityProp:
 		if vm.ip+2 > len(vm.chunk.Code) {
@@ -248,37 +246,22 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			return fmt.Errorf("stack underflow for OpStoreEntityProp")
 		}
 		value := vm.pop()
-		entityIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		propIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
+		entityIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		propIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
 		if entityIdx >= len(vm.chunk.Constants) || propIdx >= len(vm.chunk.Constants) {
 			return fmt.Errorf("constant index out of bounds for entity prop")
 		}
 		entityName, _ := vm.chunk.Constants[entityIdx].(string)
 		propName, _ := vm.chunk.Constants[propIdx].(string)
-		entityLower := strings.ToLower(entityName)
-		propLower := strings.ToLower(propName)
-		if vm.entitySetters != nil {
-			if fn := vm.entitySetters[propLower]; fn != nil {
-				fn(entityLower, propLower, value)
-				break
-			}
-			if fn := vm.entitySetters[entityLower+"."+propLower]; fn != nil {
-				fn(entityLower, propLower, value)
-				break
-			}
-		}
-		// Fallback: set on globals[entityName] map
-		g, exists := vm.globals[entityLower]
-		if !exists {
-			return fmt.Errorf("entity not found: %s", entityName)
-		}
-		m, ok := g.(map[string]interface{})
-		if !ok {
-			return fmt.Errorf("entity %s is not a map", entityName)
+		if err := vm.SetEntityProp(entityName, propName, value); err != nil {
+			return err
 		}
-		m[propName] = value
 
 	case OpAdd:
 		b := vm.pop()
@@ -354,12 +337,12 @@ func (vm *VM) executeInstruction(instruction byte) error {
 	case OpEqual:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(a == b)
+		vm.push(Equal(a, b))
 
 	case OpNotEqual:
 		b := vm.pop()
 		a := vm.pop()
-		vm.push(a != b)
+		vm.push(!Equal(a, b))
 
 	case OpLess:
 		b := vm.pop()
@@ -418,54 +401,44 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(!vm.isTruthy(a))
 
 	case OpJump:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpJump")
+		// Signed offset (int16, int32 when wide) so backward jumps work for large loop bodies
+		offset, err := vm.readJump(wide)
+		if err != nil {
+			return err
 		}
-		// 2-byte signed offset (int16 little-endian) so backward jumps work for large loop bodies
-		low := uint16(vm.chunk.Code[vm.ip])
-		high := uint16(vm.chunk.Code[vm.ip+1])
-		offset := int(int16(low | (high << 8)))
-		vm.ip += 2
 		vm.ip += offset
 
 	case OpJumpIfFalse:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpJumpIfFalse")
+		offset, err := vm.readJump(wide)
+		if err != nil {
+			return err
 		}
-		low := uint16(vm.chunk.Code[vm.ip])
-		high := uint16(vm.chunk.Code[vm.ip+1])
-		offset := int(int16(low | (high << 8)))
-		vm.ip += 2
 
 		if !vm.isTruthy(vm.pop()) {
 			vm.ip += offset
 		}
 
 	case OpJumpIfTrue:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpJumpIfTrue")
+		offset, err := vm.readJump(wide)
+		if err != nil {
+			return err
 		}
-		low := uint16(vm.chunk.Code[vm.ip])
-		high := uint16(vm.chunk.Code[vm.ip+1])
-		offset := int(int16(low | (high << 8)))
-		vm.ip += 2
 
 		if vm.isTruthy(vm.pop()) {
 			vm.ip += offset
 		}
 
 	case OpCall:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code")
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		argCount := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 
 		// Special handling for PRINT and STR
 		if len(vm.stack) > 0 {
 			// Check if this is a PRINT or STR call by looking at the previous instruction
 			if vm.ip > 0 {
-				prevInstr := vm.chunk.Code[vm.ip-2]
+				prevInstr := vm.chunk.Code[vm.ip-2] // legacy: OpPrint/OpStr followed by OpCall 0
 				if prevInstr == byte(OpPrint) || prevInstr == byte(OpStr) {
 					// This is a PRINT or STR call, handle specially
 					return nil
@@ -479,13 +452,14 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpCallUser:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpCallUser")
+		nameConstIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		nameConstIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		argCount := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if nameConstIndex < 0 || nameConstIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("invalid constant index for user call: %d", nameConstIndex)
 		}
@@ -506,25 +480,16 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		for i := argCount - 1; i >= 0; i-- {
 			args[i] = vm.pop()
 		}
-		stackBase := len(vm.stack)
-		vm.userCallFrames = append(vm.userCallFrames, userCallFrame{stackBase: stackBase})
-		vm.callStack = append(vm.callStack, vm.ip)
-		isDraw := (name == "draw")
-		vm.drawFrameStack = append(vm.drawFrameStack, isDraw)
-		if isDraw {
-			vm.insideDraw = true
-		}
-		vm.stack = append(vm.stack, args...)
-		vm.ip = targetIP
+		vm.enterUserCall(name, targetIP, args)
 
 	case OpGosub:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpGosub")
+		nameConstIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		if _, err := vm.readCount(); err != nil { // arg count (0 for GOSUB)
+			return err
 		}
-		nameConstIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		_ = int(vm.chunk.Code[vm.ip]) // arg count (0 for GOSUB)
-		vm.ip++
 		if nameConstIndex < 0 || nameConstIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("invalid constant index for GOSUB: %d", nameConstIndex)
 		}
@@ -545,41 +510,15 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if len(vm.gosubStack) > 0 {
 			vm.ip = vm.gosubStack[len(vm.gosubStack)-1]
 			vm.gosubStack = vm.gosubStack[:len(vm.gosubStack)-1]
+			vm.dropStaleTryHandlers()
 			break
 		}
 		if len(vm.callStack) == 0 {
-			// Fiber or main ended: remove from queue if fiber, else halt
-			if len(vm.fiberQueue) > 1 {
-				// Remove current fiber from queue
-				newQueue := make([]int, 0, len(vm.fiberQueue)-1)
-				for _, i := range vm.fiberQueue {
-					if i != vm.currentFiber {
-						newQueue = append(newQueue, i)
-					}
-				}
-				vm.fiberQueue = newQueue
-				if len(vm.fiberQueue) == 0 {
-					vm.running = false
-					return nil
-				}
-				vm.currentFiber = vm.fiberQueue[0]
-				next := &vm.fibers[vm.currentFiber]
-				vm.ip = next.ip
-				vm.stack = append(vm.stack[:0], next.stack...)
-				vm.callStack = append(vm.callStack[:0], next.callStack...)
-				vm.drawFrameStack = append(vm.drawFrameStack[:0], next.drawFrameStack...)
-				vm.userCallFrames = append([]userCallFrame(nil), next.userCallFrames...)
-				vm.insideDraw = false
-				for _, b := range vm.drawFrameStack {
-					if b {
-						vm.insideDraw = true
-						break
-					}
-				}
-			} else {
+			// Fiber or main ended: switch to the next fiber, or halt when none is left
+			if !vm.finishFiber(nil) {
 				vm.running = false
+				vm.userCallFrames = vm.userCallFrames[:0]
 			}
-			vm.userCallFrames = vm.userCallFrames[:0]
 			return nil
 		}
 		vm.ip = vm.callStack[len(vm.callStack)-1]
@@ -597,7 +536,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 				}
 			}
 		}
-		vm.shrinkStackAfterUserReturn()
+		if vm.shrinkStackAfterUserReturn() {
+			vm.push(nil)
+		}
+		vm.dropStaleTryHandlers()
 
 	case OpReturnVal:
 		if len(vm.stack) == 0 {
@@ -605,37 +547,7 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 		val := vm.pop()
 		if len(vm.callStack) == 0 {
-			if len(vm.fiberQueue) > 1 {
-				newQueue := make([]int, 0, len(vm.fiberQueue)-1)
-				for _, i := range vm.fiberQueue {
-					if i != vm.currentFiber {
-						newQueue = append(newQueue, i)
-					}
-				}
-				vm.fiberQueue = newQueue
-				if len(vm.fiberQueue) == 0 {
-					vm.running = false
-					vm.stack = vm.stack[:0]
-					vm.userCallFrames = vm.userCallFrames[:0]
-					vm.push(val)
-					return nil
-				}
-				vm.currentFiber = vm.fiberQueue[0]
-				next := &vm.fibers[vm.currentFiber]
-				vm.ip = next.ip
-				vm.stack = append(vm.stack[:0], next.stack...)
-				vm.callStack = append(vm.callStack[:0], next.callStack...)
-				vm.drawFrameStack = append(vm.drawFrameStack[:0], next.drawFrameStack...)
-				vm.userCallFrames = append([]userCallFrame(nil), next.userCallFrames...)
-				vm.insideDraw = false
-				for _, b := range vm.drawFrameStack {
-					if b {
-						vm.insideDraw = true
-						break
-					}
-				}
-				vm.push(val)
-			} else {
+			if !vm.finishFiber(val) {
 				vm.running = false
 				vm.stack = vm.stack[:0]
 				vm.userCallFrames = vm.userCallFrames[:0]
@@ -659,20 +571,22 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			}
 		}
 		vm.shrinkStackAfterUserReturn()
+		vm.dropStaleTryHandlers()
 		vm.push(val)
 
 	case OpRegisterEvent:
-		if vm.ip+4 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpRegisterEvent")
+		eventTypeIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		keyIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		handlerIP, err := vm.readTarget(wide)
+		if err != nil {
+			return err
 		}
-		eventTypeIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		keyIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		low := uint16(vm.chunk.Code[vm.ip])
-		high := uint16(vm.chunk.Code[vm.ip+1])
-		vm.ip += 2
-		handlerIP := int(low | (high << 8))
 		if eventTypeIdx >= 0 && eventTypeIdx < len(vm.chunk.Constants) {
 			if kIdx := keyIdx; kIdx >= 0 && kIdx < len(vm.chunk.Constants) {
 				eventType, _ := vm.chunk.Constants[eventTypeIdx].(string)
@@ -682,55 +596,30 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpStartCoroutine:
-		if vm.ip+3 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpStartCoroutine")
-		}
-		low := uint16(vm.chunk.Code[vm.ip])
-		high := uint16(vm.chunk.Code[vm.ip+1])
-		nameConstIdx := int(vm.chunk.Code[vm.ip+2])
-		vm.ip += 3
-		targetIP := int(low | (high << 8))
+		targetIP, err := vm.readTarget(wide)
+		if err != nil {
+			return err
+		}
+		nameConstIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
 		name := ""
 		if nameConstIdx < len(vm.chunk.Constants) {
 			if s, ok := vm.chunk.Constants[nameConstIdx].(string); ok {
 				name = s
 			}
 		}
-		fiberIdx := len(vm.fibers)
-		vm.fibers = append(vm.fibers, fiberState{ip: targetIP, stack: []Value{}, callStack: []int{}, drawFrameStack: nil, userCallFrames: nil})
-		vm.fiberQueue = append(vm.fiberQueue, fiberIdx)
-		if vm.fiberNames == nil {
-			vm.fiberNames = make(map[int]string)
-		}
-		vm.fiberNames[fiberIdx] = name
+		vm.startFiber(targetIP, name, nil)
 
 	case OpYield:
 		// Save current state, rotate queue, load next fiber
-		vm.fibers[vm.currentFiber] = fiberState{
-			ip:             vm.ip,
-			stack:          append([]Value(nil), vm.stack...),
-			callStack:      append([]int(nil), vm.callStack...),
-			drawFrameStack: append([]bool(nil), vm.drawFrameStack...),
-			userCallFrames: append([]userCallFrame(nil), vm.userCallFrames...),
-		}
+		vm.saveFiber()
 		if len(vm.fiberQueue) < 2 {
 			break
 		}
 		vm.fiberQueue = append(vm.fiberQueue[1:], vm.fiberQueue[0])
-		vm.currentFiber = vm.fiberQueue[0]
-		next := &vm.fibers[vm.currentFiber]
-		vm.ip = next.ip
-		vm.stack = append(vm.stack[:0], next.stack...)
-		vm.callStack = append(vm.callStack[:0], next.callStack...)
-		vm.drawFrameStack = append(vm.drawFrameStack[:0], next.drawFrameStack...)
-		vm.userCallFrames = append([]userCallFrame(nil), next.userCallFrames...)
-		vm.insideDraw = false
-		for _, b := range vm.drawFrameStack {
-			if b {
-				vm.insideDraw = true
-				break
-			}
-		}
+		vm.loadFiber(vm.fiberQueue[0])
 
 	case OpWaitSeconds:
 		if len(vm.stack) == 0 {
@@ -750,46 +639,43 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			break
 		}
 		// Non-blocking: save fiber state, remove from queue, add to sleeping, switch to next fiber
-		vm.fibers[vm.currentFiber] = fiberState{
-			ip:             vm.ip,
-			stack:          append([]Value(nil), vm.stack...),
-			callStack:      append([]int(nil), vm.callStack...),
-			drawFrameStack: append([]bool(nil), vm.drawFrameStack...),
-			userCallFrames: append([]userCallFrame(nil), vm.userCallFrames...),
-		}
+		vm.saveFiber()
 		resumeAt := time.Now().Add(time.Duration(sec * float64(time.Second)))
 		vm.sleeping = append(vm.sleeping, sleepEntry{fiberIndex: vm.currentFiber, resumeAt: resumeAt, isPaused: false})
-		newQueue := make([]int, 0, len(vm.fiberQueue)-1)
-		for _, i := range vm.fiberQueue {
-			if i != vm.currentFiber {
-				newQueue = append(newQueue, i)
-			}
+		vm.parkFiber()
+
+	case OpStartTask:
+		targetIP, err := vm.readTarget(wide)
+		if err != nil {
+			return err
 		}
-		vm.fiberQueue = newQueue
-		if len(vm.fiberQueue) == 0 {
-			break
+		nameConstIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		vm.currentFiber = vm.fiberQueue[0]
-		next := &vm.fibers[vm.currentFiber]
-		vm.ip = next.ip
-		vm.stack = append(vm.stack[:0], next.stack...)
-		vm.callStack = append(vm.callStack[:0], next.callStack...)
-		vm.drawFrameStack = append(vm.drawFrameStack[:0], next.drawFrameStack...)
-		vm.userCallFrames = append([]userCallFrame(nil), next.userCallFrames...)
-		vm.insideDraw = false
-		for _, b := range vm.drawFrameStack {
-			if b {
-				vm.insideDraw = true
-				break
-			}
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) < argCount {
+			return fmt.Errorf("stack underflow for StartCoroutine arguments")
+		}
+		name, _ := vm.chunk.Constants[nameConstIdx].(string)
+		args := append([]Value(nil), vm.stack[len(vm.stack)-argCount:]...)
+		vm.stack = vm.stack[:len(vm.stack)-argCount]
+		vm.push(vm.startFiber(targetIP, name, args))
+
+	case OpAwait:
+		if len(vm.stack) == 0 {
+			return fmt.Errorf("stack underflow for AWAIT")
 		}
+		return vm.await(vm.ip - 1)
 
 	case OpRead:
-		if vm.ip+1 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpRead")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		var val Value
 		if vm.chunk.DataValues != nil && vm.dataIndex < len(vm.chunk.DataValues) {
 			val = vm.chunk.DataValues[vm.dataIndex]
@@ -806,13 +692,14 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.dataIndex = 0
 
 	case OpCallForeign:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpCallForeign")
+		constIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		constIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		argCount := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if constIndex < 0 || constIndex >= len(vm.chunk.Constants) {
 			return fmt.Errorf("invalid constant index for foreign call: %d", constIndex)
 		}
@@ -821,31 +708,58 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("foreign call name must be string constant, got %T", nameVal)
 		}
-		if len(vm.stack) < argCount {
-			return fmt.Errorf("stack underflow for foreign call %s: need %d args, have %d", name, argCount, len(vm.stack))
+		return vm.callForeign(name, argCount)
+
+	case OpMakeFunction:
+		targetIP, err := vm.readTarget(wide)
+		if err != nil {
+			return err
 		}
-		args := make([]interface{}, argCount)
-		for i := argCount - 1; i >= 0; i-- {
-			args[i] = vm.pop()
+		nameConstIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		// Hybrid draw: when inside draw(), queue render commands instead of executing.
-		if vm.insideDraw && vm.renderCommandType != nil {
-			if typ := vm.renderCommandType[strings.ToLower(name)]; typ != RenderNone {
-				vm.PushRenderCommand(name, args, typ)
-				break
-			}
+		paramCount, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		fn := vm.foreign[strings.ToLower(name)]
-		if fn == nil {
-			return fmt.Errorf("unknown foreign function: %s", name)
+		captureCount, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) < captureCount {
+			return fmt.Errorf("stack underflow for function captures")
+		}
+		name, _ := vm.chunk.Constants[nameConstIdx].(string)
+		f := &Function{Name: name, IP: targetIP, Params: paramCount}
+		if captureCount > 0 {
+			f.Captured = append([]Value(nil), vm.stack[len(vm.stack)-captureCount:]...)
+			vm.stack = vm.stack[:len(vm.stack)-captureCount]
+		}
+		vm.push(f)
+
+	case OpCallValue:
+		nameConstIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		result, err := fn(args)
+		argCount, err := vm.readCount()
 		if err != nil {
-			return fmt.Errorf("foreign call %s: %w", name, err)
+			return err
 		}
-		if result != nil {
-			vm.push(result)
+		if len(vm.stack) < argCount+1 {
+			return fmt.Errorf("stack underflow for call: need %d args, have %d", argCount, len(vm.stack)-1)
+		}
+		callee := vm.pop()
+		if f, ok := callee.(*Function); ok {
+			args := append([]Value(nil), vm.stack[len(vm.stack)-argCount:]...)
+			vm.stack = vm.stack[:len(vm.stack)-argCount]
+			vm.callFunction(f, args)
+			break
 		}
+		// A variable that is not a function value does not hide the foreign function of the same name.
+		name, _ := vm.chunk.Constants[nameConstIndex].(string)
+		return vm.callForeign(name, argCount)
 
 	case OpPrint:
 		// Pop argument and print it
@@ -902,14 +816,14 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpRandom:
-		vm.push(rand.Float64())
+		vm.push(rng.Float64())
 
 	case OpRandomN:
 		if len(vm.stack) == 0 {
 			return fmt.Errorf("stack underflow for Random(n)")
 		}
 		max := valueToFloat64(vm.pop())
-		vm.push(rand.Float64() * max)
+		vm.push(rng.Float64() * max)
 
 	case OpSleep:
 		if len(vm.stack) == 0 {
@@ -926,10 +840,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(int(valueToFloat64(v)))
 
 	case OpTimer:
-		vm.push(time.Since(vm.timerZero).Seconds())
+		vm.push(vm.now() - vm.timerZero)
 
 	case OpResetTimer:
-		vm.timerZero = time.Now()
+		vm.timerZero = vm.now()
 
 	case OpSin:
 		if len(vm.stack) == 0 {
@@ -1156,13 +1070,18 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.push(math.Atan2(y2-y1, x2-x1))
 
 	case OpMatMul:
-		if vm.ip+3 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpMatMul")
+		rci, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		aci, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		bci, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		rci := int(vm.chunk.Code[vm.ip])
-		aci := int(vm.chunk.Code[vm.ip+1])
-		bci := int(vm.chunk.Code[vm.ip+2])
-		vm.ip += 3
 		if rci >= len(vm.chunk.Constants) || aci >= len(vm.chunk.Constants) || bci >= len(vm.chunk.Constants) {
 			return fmt.Errorf("OpMatMul: invalid constant index")
 		}
@@ -1187,19 +1106,6 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("OpMatMul: matrix B %s not found", bName)
 		}
-		rd := vm.chunk.VarDims[rName]
-		ad := vm.chunk.VarDims[aName]
-		bd := vm.chunk.VarDims[bName]
-		if len(rd) != 2 || len(ad) != 2 || len(bd) != 2 {
-			return fmt.Errorf("OpMatMul: all three must be 2D arrays")
-		}
-		n, m, p := ad[0], ad[1], bd[1]
-		if bd[0] != m {
-			return fmt.Errorf("OpMatMul: A columns (%d) != B rows (%d)", m, bd[0])
-		}
-		if rd[0] != n || rd[1] != p {
-			return fmt.Errorf("OpMatMul: result must be %d×%d", n, p)
-		}
 		for raIdx >= len(vm.stack) {
 			vm.stack = append(vm.stack, nil)
 		}
@@ -1221,14 +1127,8 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("OpMatMul: %s is not an array", rName)
 		}
-		for i := 0; i < n; i++ {
-			for j := 0; j < p; j++ {
-				var sum float64
-				for k := 0; k < m; k++ {
-					sum += valueToFloat64(aArr[i*m+k]) * valueToFloat64(bArr[k*p+j])
-				}
-				rArr[i*p+j] = sum
-			}
+		if err := MatMul(rArr, aArr, bArr, vm.chunk.VarDims[rName], vm.chunk.VarDims[aName], vm.chunk.VarDims[bName]); err != nil {
+			return err
 		}
 
 	case OpLeftStr:
@@ -1284,14 +1184,26 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if len(vm.stack) == 0 {
 			return fmt.Errorf("stack underflow for Len")
 		}
-		vm.push(len(valueToString(vm.pop())))
+		switch c := vm.pop().(type) {
+		case *List:
+			vm.push(len(c.Items))
+		case *Map:
+			vm.push(c.Len())
+		default:
+			vm.push(len(valueToString(c)))
+		}
 	case OpStrSlice:
 		if len(vm.stack) < 3 {
 			return fmt.Errorf("stack underflow for StrSlice")
 		}
 		endVal := vm.pop()
 		startVal := vm.pop()
-		s := valueToString(vm.pop())
+		obj := vm.pop()
+		if l, ok := obj.(*List); ok {
+			vm.push(l.slice(startVal, endVal))
+			break
+		}
+		s := valueToString(obj)
 		start := 0
 		if startVal != nil {
 			start = valueToInt(startVal)
@@ -1315,8 +1227,14 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if len(vm.stack) < 2 {
 			return fmt.Errorf("stack underflow for StrSliceFrom")
 		}
-		start := valueToInt(vm.pop())
-		s := valueToString(vm.pop())
+		startVal := vm.pop()
+		obj := vm.pop()
+		if l, ok := obj.(*List); ok {
+			vm.push(l.slice(startVal, nil))
+			break
+		}
+		start := valueToInt(startVal)
+		s := valueToString(obj)
 		if start < 0 {
 			start = 0
 		}
@@ -1444,33 +1362,30 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpCreateArray:
-		if vm.ip+1 >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpCreateArray")
+		nDims, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		nDims := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if nDims < 1 || nDims > 8 {
 			return fmt.Errorf("invalid array dimensions count: %d", nDims)
 		}
 		dims := make([]int, nDims)
 		size := 1
 		for i := 0; i < nDims; i++ {
-			if vm.ip >= len(vm.chunk.Code) {
-				return fmt.Errorf("unexpected end of code for OpCreateArray dims")
+			ci, err := vm.readIndex(wide)
+			if err != nil {
+				return err
 			}
-			ci := int(vm.chunk.Code[vm.ip])
-			vm.ip++
 			if ci >= len(vm.chunk.Constants) {
 				return fmt.Errorf("constant index out of bounds in OpCreateArray")
 			}
 			dims[i] = valueToInt(vm.chunk.Constants[ci])
 			size *= dims[i]
 		}
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpCreateArray varIndex")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		arr := make([]Value, size)
 		for i := range arr {
 			arr[i] = 0
@@ -1481,13 +1396,14 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.stack[varIndex] = arr
 
 	case OpResizeArray:
-		if vm.ip+2 >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpResizeArray")
+		nDims, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		nDims := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if nDims < 1 || nDims > 8 {
 			return fmt.Errorf("invalid OpResizeArray nDims: %d", nDims)
 		}
@@ -1519,11 +1435,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpAppendArray:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpAppendArray")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if len(vm.stack) == 0 {
 			return fmt.Errorf("stack underflow for OpAppendArray value")
 		}
@@ -1538,11 +1453,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		vm.stack[varIndex] = append(arr, value)
 
 	case OpLoadArray:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpLoadArray")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		var dims []int
 		for name, idx := range vm.chunk.Variables {
 			if idx == varIndex {
@@ -1560,30 +1474,22 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("OpLoadArray: slot %d is not an array", varIndex)
 		}
-		if len(dims) == 0 {
-			dims = []int{len(arr)}
-		}
-		idx := 0
-		stride := 1
-		for d := len(dims) - 1; d >= 0; d-- {
-			if len(vm.stack) == 0 {
-				return fmt.Errorf("stack underflow for OpLoadArray indices")
-			}
-			i := valueToInt(vm.pop())
-			idx += i * stride
-			stride *= dims[d]
+		n := max(len(dims), 1)
+		if len(vm.stack) < n {
+			return fmt.Errorf("stack underflow for OpLoadArray indices")
 		}
-		if idx < 0 || idx >= len(arr) {
-			return fmt.Errorf("array index out of bounds: %d", idx)
+		idx, err := ArrayOffset(arr, dims, vm.stack[len(vm.stack)-n:])
+		vm.stack = vm.stack[:len(vm.stack)-n]
+		if err != nil {
+			return err
 		}
 		vm.push(arr[idx])
 
 	case OpStoreArray:
-		if vm.ip >= len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpStoreArray")
+		varIndex, err := vm.readIndex(wide)
+		if err != nil {
+			return err
 		}
-		varIndex := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		var dims []int
 		for name, idx := range vm.chunk.Variables {
 			if idx == varIndex {
@@ -1601,26 +1507,16 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if !ok {
 			return fmt.Errorf("OpStoreArray: slot %d is not an array", varIndex)
 		}
-		if dims == nil || len(dims) == 0 {
-			dims = []int{len(arr)}
-		}
 		// Stack is [..., value, index0, index1, ...] with index1 on top; pop indices first, then value
-		idx := 0
-		stride := 1
-		for d := len(dims) - 1; d >= 0; d-- {
-			if len(vm.stack) == 0 {
-				return fmt.Errorf("stack underflow for OpStoreArray indices")
-			}
-			i := valueToInt(vm.pop())
-			idx += i * stride
-			stride *= dims[d]
-		}
-		if len(vm.stack) == 0 {
-			return fmt.Errorf("stack underflow for OpStoreArray value")
+		n := max(len(dims), 1)
+		if len(vm.stack) < n+1 {
+			return fmt.Errorf("stack underflow for OpStoreArray")
 		}
+		idx, err := ArrayOffset(arr, dims, vm.stack[len(vm.stack)-n:])
+		vm.stack = vm.stack[:len(vm.stack)-n]
 		value := vm.pop()
-		if idx < 0 || idx >= len(arr) {
-			return fmt.Errorf("array index out of bounds: %d", idx)
+		if err != nil {
+			return err
 		}
 		arr[idx] = value
 
@@ -1840,18 +1736,19 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		}
 
 	case OpGetProp:
-		if vm.ip+1 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpGetProp")
+		n, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		n := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if n < 1 || n > 32 || vm.ip+n > len(vm.chunk.Code) {
 			return fmt.Errorf("invalid OpGetProp path length")
 		}
 		path := make([]string, 0, n)
 		for i := 0; i < n; i++ {
-			ci := int(vm.chunk.Code[vm.ip])
-			vm.ip++
+			ci, err := vm.readIndex(wide)
+			if err != nil {
+				return err
+			}
 			if ci < 0 || ci >= len(vm.chunk.Constants) {
 				return fmt.Errorf("OpGetProp: invalid const index")
 			}
@@ -1864,34 +1761,26 @@ func (vm *VM) executeInstruction(instruction byte) error {
 		if len(vm.stack) < 1 {
 			return fmt.Errorf("stack underflow for OpGetProp")
 		}
-		obj := vm.pop()
-		d, ok := obj.(DotObject)
-		if !ok {
-			return &errors.CyberError{
-				Code:       errors.ErrDotAccess,
-				Message:    fmt.Sprintf("Value of type %T does not support property access", obj),
-				Suggestion: "Use a handle returned from PHYSICS, WINDOW, AUDIO, etc.",
-			}
-		}
-		val, err := d.GetProp(path)
+		val, err := vm.GetProp(vm.pop(), path)
 		if err != nil {
 			return err
 		}
 		vm.push(val)
 
 	case OpSetProp:
-		if vm.ip+1 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpSetProp")
+		n, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		n := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if n < 1 || n > 32 || vm.ip+n > len(vm.chunk.Code) {
 			return fmt.Errorf("invalid OpSetProp path length")
 		}
 		path := make([]string, 0, n)
 		for i := 0; i < n; i++ {
-			ci := int(vm.chunk.Code[vm.ip])
-			vm.ip++
+			ci, err := vm.readIndex(wide)
+			if err != nil {
+				return err
+			}
 			if ci < 0 || ci >= len(vm.chunk.Constants) {
 				return fmt.Errorf("OpSetProp: invalid const index")
 			}
@@ -1905,27 +1794,19 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			return fmt.Errorf("stack underflow for OpSetProp")
 		}
 		val := vm.pop()
-		obj := vm.pop()
-		d, ok := obj.(DotObject)
-		if !ok {
-			return &errors.CyberError{
-				Code:       errors.ErrDotAccess,
-				Message:    fmt.Sprintf("Cannot assign property on type %T", obj),
-				Suggestion: "Assign to handles returned from high-level APIs (WINDOW, PHYSICS, …).",
-			}
-		}
-		if err := d.SetProp(path, val); err != nil {
+		if err := vm.SetProp(vm.pop(), path, val); err != nil {
 			return err
 		}
 
 	case OpCallMethod:
-		if vm.ip+2 > len(vm.chunk.Code) {
-			return fmt.Errorf("unexpected end of code for OpCallMethod")
+		nameIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		argCount, err := vm.readCount()
+		if err != nil {
+			return err
 		}
-		nameIdx := int(vm.chunk.Code[vm.ip])
-		vm.ip++
-		argCount := int(vm.chunk.Code[vm.ip])
-		vm.ip++
 		if nameIdx < 0 || nameIdx >= len(vm.chunk.Constants) {
 			return fmt.Errorf("OpCallMethod: invalid name const")
 		}
@@ -1941,15 +1822,10 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			args[i] = vm.pop()
 		}
 		obj := vm.pop()
-		d, ok := obj.(DotObject)
-		if !ok {
-			return &errors.CyberError{
-				Code:       errors.ErrDotAccess,
-				Message:    fmt.Sprintf("Value of type %T has no methods", obj),
-				Suggestion: "Call methods on API handles (sound.PLAY, etc.).",
-			}
+		if o, ok := obj.(*Object); ok {
+			return vm.callMethod(o, strings.ToLower(name), args)
 		}
-		ret, err := d.CallMethod(strings.ToLower(name), args)
+		ret, err := vm.callValueMethod(obj, strings.ToLower(name), args)
 		if err != nil {
 			return err
 		}
@@ -1957,6 +1833,106 @@ func (vm *VM) executeInstruction(instruction byte) error {
 			vm.push(ret)
 		}
 
+	case OpNewObject:
+		nameIdx, err := vm.readIndex(wide)
+		if err != nil {
+			return err
+		}
+		if nameIdx < 0 || nameIdx >= len(vm.chunk.Constants) {
+			return fmt.Errorf("OpNewObject: invalid type name const")
+		}
+		name, ok := vm.chunk.Constants[nameIdx].(string)
+		if !ok {
+			return fmt.Errorf("OpNewObject: type name must be string")
+		}
+		o, err := vm.newObject(name, nil)
+		if err != nil {
+			return err
+		}
+		vm.push(o)
+
+	case OpMakeList:
+		count, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) < count {
+			return fmt.Errorf("stack underflow for MakeList")
+		}
+		items := append([]Value(nil), vm.stack[len(vm.stack)-count:]...)
+		vm.stack = vm.stack[:len(vm.stack)-count]
+		vm.push(NewList(items...))
+
+	case OpMakeMap:
+		count, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) < 2*count {
+			return fmt.Errorf("stack underflow for MakeMap")
+		}
+		pairs := vm.stack[len(vm.stack)-2*count:]
+		m := NewMap()
+		for i := 0; i < len(pairs); i += 2 {
+			if err := m.Set(pairs[i], pairs[i+1]); err != nil {
+				return err
+			}
+		}
+		vm.stack = vm.stack[:len(vm.stack)-2*count]
+		vm.push(m)
+
+	case OpIndex:
+		if len(vm.stack) < 2 {
+			return fmt.Errorf("stack underflow for Index")
+		}
+		i := vm.pop()
+		v, err := vm.index(vm.pop(), i)
+		if err != nil {
+			return err
+		}
+		vm.push(v)
+
+	case OpSetIndex:
+		if len(vm.stack) < 3 {
+			return fmt.Errorf("stack underflow for SetIndex")
+		}
+		val := vm.pop()
+		i := vm.pop()
+		if err := vm.setIndex(vm.pop(), i, val); err != nil {
+			return err
+		}
+
+	case OpIterNew:
+		vars, err := vm.readCount()
+		if err != nil {
+			return err
+		}
+		if len(vm.stack) == 0 {
+			return fmt.Errorf("stack underflow for IterNew")
+		}
+		it, err := newIterator(vm.pop(), vars)
+		if err != nil {
+			return err
+		}
+		vm.push(it)
+
+	case OpIterNext:
+		if len(vm.stack) == 0 {
+			return fmt.Errorf("stack underflow for IterNext")
+		}
+		it, ok := vm.pop().(*iterator)
+		if !ok {
+			return fmt.Errorf("IterNext: not a FOR EACH iterator")
+		}
+		if it.pos >= len(it.values) {
+			vm.push(false)
+			break
+		}
+		vm.push(it.keys[it.pos])
+		vm.push(it.values[it.pos])
+		vm.push(true)
+		it.pos++
+
 	case OpRayCast3D:
 		if len(vm.stack) < 7 {
 			return fmt.Errorf("stack underflow for RAYCAST3D")
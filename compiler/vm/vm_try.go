@@ -0,0 +1,172 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tryHandler is one active TRY block: where to resume and how deep each stack was when OpTry ran.
+type tryHandler struct {
+	catchIP    int
+	stackLen   int
+	callDepth  int
+	frameDepth int // len(userCallFrames)
+	gosubDepth int
+	drawDepth  int
+}
+
+// ForeignError is returned when a foreign function fails; CATCH exposes Name as the error's func property.
+type ForeignError struct {
+	Name string
+	Err  error
+}
+
+func (e *ForeignError) Error() string { return fmt.Sprintf("foreign call %s: %v", e.Name, e.Err) }
+func (e *ForeignError) Unwrap() error { return e.Err }
+
+// ScriptError is the error object seen by CATCH: a THROWn value or a runtime / foreign-call error.
+// Scripts read it as e.message, e.line, e.file, e.func (failing foreign function, if any) and e.value (what was thrown).
+type ScriptError struct {
+	Message string
+	File    string // empty for single-file programs
+	Line    int
+	Func    string
+	Value   Value
+}
+
+func (e *ScriptError) Error() string { return e.Message }
+
+// GetProp implements DotObject for e.message, e.line, e.file, e.func and e.value.
+func (e *ScriptError) GetProp(path []string) (Value, error) {
+	if len(path) != 1 {
+		return nil, fmt.Errorf("error object has no property %s", strings.Join(path, "."))
+	}
+	switch strings.ToLower(path[0]) {
+	case "message":
+		return e.Message, nil
+	case "line":
+		return e.Line, nil
+	case "file":
+		return e.File, nil
+	case "func":
+		return e.Func, nil
+	case "value":
+		return e.Value, nil
+	}
+	return nil, fmt.Errorf("error object has no property %s (use message, line, file, func or value)", path[0])
+}
+
+// SetProp implements DotObject; error objects are read-only.
+func (e *ScriptError) SetProp(path []string, val Value) error {
+	return fmt.Errorf("error object is read-only")
+}
+
+// CallMethod implements DotObject; error objects have no methods.
+func (e *ScriptError) CallMethod(name string, args []Value) (Value, error) {
+	return nil, fmt.Errorf("error object has no method %s", name)
+}
+
+// pushTryHandler records a handler for OpTry.
+func (vm *VM) pushTryHandler(catchIP int) {
+	vm.tryHandlers = append(vm.tryHandlers, tryHandler{
+		catchIP:    catchIP,
+		stackLen:   len(vm.stack),
+		callDepth:  len(vm.callStack),
+		frameDepth: len(vm.userCallFrames),
+		gosubDepth: len(vm.gosubStack),
+		drawDepth:  len(vm.drawFrameStack),
+	})
+}
+
+// popTryHandler drops the innermost handler (OpEndTry).
+func (vm *VM) popTryHandler() {
+	if len(vm.tryHandlers) > vm.tryFloor {
+		vm.tryHandlers = vm.tryHandlers[:len(vm.tryHandlers)-1]
+	}
+}
+
+// dropStaleTryHandlers removes handlers of frames that returned (RETURN inside a TRY in a Sub/Function or GOSUB).
+func (vm *VM) dropStaleTryHandlers() {
+	n := len(vm.tryHandlers)
+	for n > vm.tryFloor {
+		h := vm.tryHandlers[n-1]
+		if h.callDepth <= len(vm.callStack) && h.gosubDepth <= len(vm.gosubStack) {
+			break
+		}
+		n--
+	}
+	vm.tryHandlers = vm.tryHandlers[:n]
+}
+
+// catch transfers control to the innermost handler of the current fiber for err raised by the instruction at ip.
+// It reports false when no handler is active (above the floor set by InvokeSub / ProcessEvents).
+func (vm *VM) catch(err error, ip int) bool {
+	if len(vm.tryHandlers) <= vm.tryFloor {
+		return false
+	}
+	h := vm.tryHandlers[len(vm.tryHandlers)-1]
+	vm.tryHandlers = vm.tryHandlers[:len(vm.tryHandlers)-1]
+	obj := vm.errorObject(err, ip)
+
+	if h.callDepth < len(vm.callStack) {
+		vm.callStack = vm.callStack[:h.callDepth]
+	}
+	if h.frameDepth < len(vm.userCallFrames) {
+		vm.userCallFrames = vm.userCallFrames[:h.frameDepth]
+	}
+	if h.gosubDepth < len(vm.gosubStack) {
+		vm.gosubStack = vm.gosubStack[:h.gosubDepth]
+	}
+	if h.drawDepth < len(vm.drawFrameStack) {
+		vm.drawFrameStack = vm.drawFrameStack[:h.drawDepth]
+		vm.insideDraw = false
+		for _, b := range vm.drawFrameStack {
+			if b {
+				vm.insideDraw = true
+				break
+			}
+		}
+	}
+	// Variable slots live at the bottom of the value stack; never drop ones created inside the TRY body.
+	keep := h.stackLen
+	if keep < vm.varSlots {
+		keep = vm.varSlots
+	}
+	if keep < len(vm.stack) {
+		vm.stack = vm.stack[:keep]
+	}
+	vm.push(obj)
+	vm.ip = h.catchIP
+	return true
+}
+
+// errorObject converts err into the ScriptError pushed for CATCH. Thrown error objects keep their origin.
+func (vm *VM) errorObject(err error, ip int) *ScriptError {
+	var se *ScriptError
+	if errors.As(err, &se) {
+		return se
+	}
+	pos := vm.chunk.PosAt(ip)
+	obj := &ScriptError{Message: err.Error(), File: pos.File, Line: pos.Line}
+	var fe *ForeignError
+	if errors.As(err, &fe) {
+		obj.Func = fe.Name
+		obj.Message = fe.Err.Error()
+	}
+	obj.Value = obj.Message
+	return obj
+}
+
+// throwValue builds the error raised by OpThrow at ip.
+func (vm *VM) throwValue(v Value, ip int) error {
+	if se, ok := v.(*ScriptError); ok {
+		return se
+	}
+	pos := vm.chunk.PosAt(ip)
+	msg, ok := v.(string)
+	if !ok {
+		msg = fmt.Sprintf("%v", v)
+	}
+	return &ScriptError{Message: msg, File: pos.File, Line: pos.Line, Value: v}
+}
@@ -2,9 +2,11 @@
 package app
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"cyberbasic/compiler"
@@ -12,12 +14,33 @@ import (
 	"cyberbasic/compiler/bindings/std"
 	"cyberbasic/compiler/errors"
 	"cyberbasic/compiler/gogen"
+	"cyberbasic/compiler/optimizer"
 	"cyberbasic/compiler/runtime"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/compiler/runtime/replay"
+	"cyberbasic/compiler/srcmap"
 	"cyberbasic/compiler/vm"
+	"cyberbasic/internal/bastest"
 )
 
 // Main is the application entry (called from package main with build Version).
 func Main(version string) {
+	// --dap and --lsp speak their protocols on stdout, so they must run before anything is printed.
+	for _, arg := range os.Args[1:] {
+		if arg == "--lsp" {
+			os.Exit(runLSP())
+		}
+		if arg == "--dap" || strings.HasPrefix(arg, "--dap=") {
+			os.Exit(runDAP(strings.TrimPrefix(strings.TrimPrefix(arg, "--dap"), "="), firstFileArg()))
+		}
+	}
+
+	// `cyberbasic test` reports in TAP or its own format, so it too runs before the banner.
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTests(os.Args[2:]))
+	}
+
 	fmt.Println("CyberBasic starting...")
 
 	// Check for --help and --version first
@@ -39,17 +62,7 @@ func Main(version string) {
 		}
 	}
 
-	// Filename is the first argument that does not start with -
-	var filename string
-	for i := 1; i < len(os.Args); i++ {
-		if !strings.HasPrefix(os.Args[i], "-") {
-			filename = os.Args[i]
-			break
-		}
-		if os.Args[i] == "--gen-go" && i+1 < len(os.Args) {
-			i++ // skip gen-go output path
-		}
-	}
+	filename := firstFileArg()
 	replMode := false
 	for _, arg := range os.Args {
 		if arg == "--repl" {
@@ -68,22 +81,73 @@ func Main(version string) {
 	}
 
 	compileOnly := false
+	dumpBytecode := false
+	optLevel := optimizer.O0
+	profileOut := ""
 	debug := false
 	genGo := false
 	genGoOut := ""
-	var debuggerBreakpoints []int
+	buildOut := ""
+	nativeOut := ""
+	watch := false
+	headlessMode := false
+	frames := 0
+	recordOut := ""
+	replayIn := ""
+	var debuggerBreakpoints []srcmap.Pos
 
 	for i := 1; i < len(os.Args); i++ {
+		if strings.HasPrefix(os.Args[i], "--profile=") {
+			profileOut = strings.TrimPrefix(os.Args[i], "--profile=")
+			continue
+		}
+		if strings.HasPrefix(os.Args[i], "--frames=") {
+			frames = parseFrames(strings.TrimPrefix(os.Args[i], "--frames="))
+			continue
+		}
+		if strings.HasPrefix(os.Args[i], "--record=") {
+			recordOut = strings.TrimPrefix(os.Args[i], "--record=")
+			continue
+		}
+		if strings.HasPrefix(os.Args[i], "--replay=") {
+			replayIn = strings.TrimPrefix(os.Args[i], "--replay=")
+			continue
+		}
 		switch os.Args[i] {
 		case "--compile-only":
 			compileOnly = true
 		case "--lint":
 			compileOnly = true
+		case "--dump-bytecode":
+			dumpBytecode = true
+		case "-O0":
+			optLevel = optimizer.O0
+		case "-O1":
+			optLevel = optimizer.O1
 		case "--debug":
 			debug = true
 			_ = os.Setenv("CYBERBASIC_DEBUG", "1")
 		case "--debugger":
 			debug = true
+		case "--watch", "--dev":
+			watch = true
+		case "--headless":
+			headlessMode = true
+		case "--frames":
+			if i+1 < len(os.Args) {
+				i++
+				frames = parseFrames(os.Args[i])
+			}
+		case "--record":
+			if i+1 < len(os.Args) {
+				i++
+				recordOut = os.Args[i]
+			}
+		case "--replay":
+			if i+1 < len(os.Args) {
+				i++
+				replayIn = os.Args[i]
+			}
 		case "--break":
 			arg := ""
 			if strings.Contains(os.Args[i], "=") {
@@ -94,9 +158,8 @@ func Main(version string) {
 				arg = os.Args[i]
 			}
 			for _, s := range strings.Split(arg, ",") {
-				var line int
-				if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d", &line); err == nil && line > 0 {
-					debuggerBreakpoints = append(debuggerBreakpoints, line)
+				if file, line, err := srcmap.ParseLocation(s); err == nil {
+					debuggerBreakpoints = append(debuggerBreakpoints, srcmap.Pos{File: file, Line: line})
 				}
 			}
 		case "--gen-go":
@@ -105,6 +168,27 @@ func Main(version string) {
 				i++
 				genGoOut = os.Args[i]
 			}
+		case "--profile":
+			if i+1 < len(os.Args) && len(os.Args[i+1]) > 0 && !strings.HasPrefix(os.Args[i+1], "-") {
+				i++
+				profileOut = os.Args[i]
+			} else {
+				profileOut = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)) + ".folded"
+			}
+		case "--build-native":
+			if i+1 < len(os.Args) && len(os.Args[i+1]) > 0 && !strings.HasPrefix(os.Args[i+1], "-") {
+				i++
+				nativeOut = os.Args[i]
+			} else {
+				nativeOut = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+			}
+		case "--build":
+			if i+1 < len(os.Args) && len(os.Args[i+1]) > 0 && !strings.HasPrefix(os.Args[i+1], "-") {
+				i++
+				buildOut = os.Args[i]
+			} else {
+				buildOut = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".cbc"
+			}
 		}
 	}
 	if genGo && genGoOut == "" {
@@ -140,99 +224,230 @@ func Main(version string) {
 		os.Exit(1)
 	}
 
-	baseDir := filepath.Dir(filename)
-	source = PreprocessIncludes(source, baseDir, nil)
+	var chunk *vm.Chunk
+	var sourceStr string
+	var smap *srcmap.Map
+	var mode runtime.WindowMode
+	if vm.IsChunkFile(source) {
+		if watch {
+			fmt.Println("Error: --watch needs the .bas source, not precompiled bytecode")
+			os.Exit(1)
+		}
+		if genGo || buildOut != "" || nativeOut != "" {
+			fmt.Printf("Error: %s is already precompiled bytecode\n", filename)
+			os.Exit(1)
+		}
+		var meta vm.ChunkMeta
+		chunk, meta, err = vm.ReadChunk(bytes.NewReader(source))
+		if err != nil {
+			fmt.Printf("Error loading %s: %v\n", filename, err)
+			os.Exit(1)
+		}
+		mode = runtime.ParseWindowMode(meta["windowmode"])
+	} else {
+		source, smap = PreprocessIncludes(source, filename)
 
-	if genGo {
-		runGenGo(string(source), genGoOut, filename)
-		os.Exit(0)
-	}
+		if genGo {
+			runGenGo(string(source), genGoOut, filename, smap)
+			os.Exit(0)
+		}
+		if nativeOut != "" {
+			os.Exit(runBuildNative(string(source), nativeOut, filename, smap))
+		}
 
-	fmt.Printf("Compiling %s...\n", filename)
+		fmt.Printf("Compiling %s...\n", filename)
 
-	comp := compiler.New()
-	comp.Filename = filename
-	sourceStr := string(source)
-	chunk, err := comp.Compile(sourceStr)
-	if err != nil {
-		errors.PrettyPrint(os.Stdout, sourceStr, filename, err)
-		os.Exit(1)
+		comp := compiler.New()
+		sourceStr = string(source)
+		chunk, err = comp.CompileWithOptions(sourceStr, compiler.CompileOptions{Filename: filename, SourceMap: smap, Optimize: optLevel})
+		if err != nil {
+			errors.PrettyPrintMapped(os.Stdout, sourceStr, filename, smap, err)
+			os.Exit(1)
+		}
+		mode = runtime.DetectWindowMode(sourceStr)
 	}
 
 	if debug {
 		fmt.Printf("Compiled %d bytes of bytecode with %d constants\n", len(chunk.Code), len(chunk.Constants))
 	}
 
+	if buildOut != "" {
+		meta := vm.ChunkMeta{"windowmode": mode.String(), "source": filepath.Base(filename)}
+		if err := vm.SaveChunkFile(buildOut, chunk, meta); err != nil {
+			fmt.Printf("Build error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Built %s\n", buildOut)
+		os.Exit(0)
+	}
+
+	if dumpBytecode {
+		if err := vm.Disassemble(os.Stdout, chunk); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if compileOnly {
 		fmt.Println("Compilation successful!")
 		os.Exit(0)
 	}
 
+	if recordOut != "" && replayIn != "" {
+		fmt.Println("Error: --record and --replay cannot be combined")
+		os.Exit(1)
+	}
+	if headlessMode {
+		headless.Enable()
+	}
+	if recordOut != "" {
+		if err := replay.Record(recordOut); err != nil {
+			fmt.Printf("Error: --record: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if replayIn != "" {
+		n, err := replay.Replay(replayIn)
+		if err != nil {
+			fmt.Printf("Error: --replay: %v\n", err)
+			os.Exit(1)
+		}
+		if frames == 0 {
+			frames = n // end where the recorded run ended
+		}
+	}
 	rt := runtime.NewRuntime()
+	if watch {
+		rt.GetVM().SetSpareVariables(watchSpareVariables)
+	}
 	rt.GetVM().LoadChunk(chunk)
 	stdRegisterEnumsAndRuntime(rt, chunk)
-	if err := bindings.RegisterAll(rt.GetVM(), bindings.RegisterOptions{Source: sourceStr}); err != nil {
+	if err := bindings.RegisterAll(rt.GetVM(), bindings.RegisterOptions{Source: sourceStr, Mode: &mode, Headless: headlessMode,
+		ScriptedInput: recordOut != "" || replayIn != ""}); err != nil {
 		fmt.Printf("Register bindings: %v\n", err)
 		os.Exit(1)
 	}
+	if isTestFile(filename) {
+		bastest.RegisterBasTest(rt.GetVM())
+	}
 
 	fmt.Println("Running program...")
 
 	v := rt.GetVM()
+	if frames > 0 {
+		frame.Limit(frames, v.Quit)
+	}
 	if len(debuggerBreakpoints) > 0 {
-		bpMap := make(map[int]bool)
-		for _, l := range debuggerBreakpoints {
-			bpMap[l] = true
+		for _, p := range v.SetBreakpointsAt(debuggerBreakpoints) {
+			fmt.Printf("Warning: no code at breakpoint %s\n", p)
 		}
-		v.SetBreakpoints(bpMap)
 		v.SetDebugMode(true)
 	}
 
+	if watch {
+		frame.OnEnd(newWatcher(filename, v, smap, optLevel).endFrame)
+		fmt.Printf("Watching %s: saved changes to Subs and Functions are reloaded while it runs\n", filename)
+	}
+
+	finishProfile := func() {}
+	if profileOut != "" {
+		finishProfile = startProfile(v, profileOut)
+	}
+	finish := func() {
+		finishProfile()
+		if err := replay.Close(); err != nil {
+			fmt.Printf("Warning: --record: %v\n", err)
+		}
+	}
+
 	err = v.Run()
 	if err != nil {
 		if bp, ok := err.(*vm.ErrBreakpoint); ok {
-			fmt.Printf("Breakpoint hit at line %d\n", bp.Line)
-			for i, f := range v.StackTrace() {
-				fmt.Printf("  #%d line %d (ip %d)\n", i, f.Line, f.IP)
-			}
+			fmt.Printf("Breakpoint hit at %s\n", srcmap.Pos{File: bp.File, Line: bp.Line})
+			printStackTrace(v)
 			rt.CloseWindow()
+			finish()
 			os.Exit(0)
 		}
 		fmt.Printf("Runtime error: %v\n", err)
 		if debug {
-			for i, f := range v.StackTrace() {
-				fmt.Printf("  #%d line %d (ip %d)\n", i, f.Line, f.IP)
-			}
+			printStackTrace(v)
 		}
 		rt.CloseWindow()
+		finish()
 		os.Exit(2)
 	}
 
-	if rt.HasImplicitHandlers() && runtime.DetectWindowMode(sourceStr) != runtime.ModeExplicit {
+	if rt.HasImplicitHandlers() && mode != runtime.ModeExplicit {
 		err = rt.RunImplicitLoop()
 		if err != nil {
 			fmt.Printf("Runtime error: %v\n", err)
 			if debug {
-				for i, f := range rt.GetVM().StackTrace() {
-					fmt.Printf("  #%d line %d (ip %d)\n", i, f.Line, f.IP)
-				}
+				printStackTrace(rt.GetVM())
 			}
 			rt.CloseWindow()
+			finish()
 			os.Exit(2)
 		}
 	}
 
 	rt.CloseWindow()
+	finish()
 	fmt.Println("Program completed successfully!")
 	os.Exit(0)
 }
 
+// firstFileArg returns the program filename: the first argument that does not start with -.
+func firstFileArg() string {
+	for i := 1; i < len(os.Args); i++ {
+		if !strings.HasPrefix(os.Args[i], "-") {
+			return os.Args[i]
+		}
+		if (os.Args[i] == "--gen-go" || os.Args[i] == "--build" || os.Args[i] == "--build-native" || os.Args[i] == "--profile") && i+1 < len(os.Args) {
+			i++ // skip gen-go / build / build-native / profile output path
+		} else if os.Args[i] == "--frames" || os.Args[i] == "--record" || os.Args[i] == "--replay" {
+			i++ // skip the frame count or replay file
+		}
+	}
+	return ""
+}
+
+// parseFrames parses the --frames count, exiting on a bad one.
+func parseFrames(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		fmt.Printf("Error: --frames needs a positive frame count, got %q\n", s)
+		os.Exit(1)
+	}
+	return n
+}
+
+func printStackTrace(v *vm.VM) {
+	for i, f := range v.StackTrace() {
+		fmt.Printf("  #%d %s (ip %d)\n", i, f.Pos(), f.IP)
+	}
+}
+
 func stdRegisterEnumsAndRuntime(rt *runtime.Runtime, chunk *vm.Chunk) {
 	std.RegisterEnums(chunk.Enums)
 	rt.GetVM().SetRuntime(rt)
 }
 
-func runGenGo(source, genGoOut, basFilename string) {
+func runGenGo(source, genGoOut, basFilename string, smap *srcmap.Map) {
+	goCode := genGoSource(source, basFilename, smap)
+	if dir := filepath.Dir(genGoOut); dir != "." {
+		_ = os.MkdirAll(dir, 0755)
+	}
+	if err := os.WriteFile(genGoOut, []byte(goCode), 0644); err != nil {
+		fmt.Printf("Write error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Generated %s\n", genGoOut)
+}
+
+// genGoSource translates the program to Go, or reports its errors and exits.
+func genGoSource(source, basFilename string, smap *srcmap.Map) string {
 	comp := compiler.New()
 	comp.Filename = basFilename
 	program, err := comp.Parse(source)
@@ -240,34 +455,42 @@ func runGenGo(source, genGoOut, basFilename string) {
 		fmt.Printf("Parse error: %v\n", err)
 		os.Exit(1)
 	}
-	goCode, err := gogen.Generate(program)
+	mode := runtime.DetectWindowMode(source)
+	goCode, err := gogen.Generate(program, gogen.Options{Mode: mode.String(), SourceMap: smap})
 	if err != nil {
-		fmt.Printf("Go gen error: %v\n", err)
+		errors.PrettyPrintMapped(os.Stdout, source, basFilename, smap, err)
 		os.Exit(1)
 	}
-	if dir := filepath.Dir(genGoOut); dir != "." {
-		_ = os.MkdirAll(dir, 0755)
-	}
-	if err := os.WriteFile(genGoOut, []byte(goCode), 0644); err != nil {
-		fmt.Printf("Write error: %v\n", err)
-		os.Exit(1)
-	}
-	fmt.Printf("Generated %s\n", genGoOut)
+	return goCode
 }
 
 func printHelp() {
 	fmt.Println("CyberBasic - A BASIC-like language with Raylib + Bullet physics")
-	fmt.Println("Usage: cyberbasic <filename.bas> [options]")
+	fmt.Println("Usage: cyberbasic <filename.bas|filename.cbc> [options]")
+	fmt.Println("       cyberbasic test [--tap] [--junit file] [--update] [--frames N] [files or dirs]")
+	fmt.Println("         Run the TEST blocks of *_test.bas files headless, comparing PRINT output and TestSnapshot() frames")
+	fmt.Println("         with golden files in testdata/ (--update rewrites them); --tap prints TAP, --junit writes JUnit XML")
 	fmt.Println("Options:")
 	fmt.Println("  --compile-only    Compile but don't run")
-	fmt.Println("  --gen-go [file]   Generate Go source that calls raylib directly (default: <basename>_gen.go)")
+	fmt.Println("  --gen-go [file]   Translate the program to Go linking the VM's bindings (default: generated/<basename>_gen.go)")
+	fmt.Println("  --build-native [exe]  Translate to Go and build a standalone executable (default: <basename>); needs Go and the CyberBasic source tree")
+	fmt.Println("  --build [file]    Compile to precompiled bytecode (default: <basename>.cbc); run it with cyberbasic <file>.cbc")
 	fmt.Println("  --debug           Enable debug output")
 	fmt.Println("  --list-commands   Print built-in command names (2D, 3D, GUI, Physics, Std)")
 	fmt.Println("  --lint            Check program (compile only, no run); same as --compile-only")
+	fmt.Println("  --profile [out]   Sample the running program; writes out (.folded flamegraph stacks, or pprof for .pb.gz/.pprof) and out.txt")
+	fmt.Println("  -O0 / -O1         Bytecode optimization: off (default) / constant folding, dead code, jump threading")
+	fmt.Println("  --dump-bytecode   Print a disassembly of the compiled bytecode (or a .cbc file) and exit")
 	fmt.Println("  --repl            Interactive REPL (read-eval-print loop)")
-	fmt.Println("  --dev             Live reload (experimental; not fully implemented)")
+	fmt.Println("  --headless        Run without a window or GPU (CI, servers): drawing is recorded, not shown; frames last exactly 1/fps seconds")
+	fmt.Println("  --frames N        Stop after N frames (with --headless, or to end a windowed run)")
+	fmt.Println("  --record file     Record each frame's keyboard, mouse and gamepad input, frame time and the random seed to file")
+	fmt.Println("  --replay file     Play a --record file back deterministically (with a window or --headless); stops where the recording ended")
+	fmt.Println("  --watch           Hot reload: recompile on save and swap in the new Subs/Functions (update/draw too) while the game keeps running (--dev is an alias)")
 	fmt.Println("  --debugger        Enable debugger (breakpoints, stack trace)")
-	fmt.Println("  --break=5,10      Set breakpoints at lines 5 and 10")
+	fmt.Println("  --break=5,lib.bas:10  Set breakpoints at line 5 of the program and line 10 of included lib.bas")
+	fmt.Println("  --dap[=port]      Debug Adapter Protocol server for editors (stdio, or TCP when a port is given)")
+	fmt.Println("  --lsp             Language Server Protocol server for editors (stdio)")
 	fmt.Println("  --help            Show this help")
 	fmt.Println("  --version         Print version and exit")
 	fmt.Println("  (Multi-window: --window --parent=host:port --title=... --width=... --height=...)")
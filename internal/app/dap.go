@@ -0,0 +1,159 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/bindings"
+	"cyberbasic/compiler/errors"
+	"cyberbasic/compiler/runtime"
+	"cyberbasic/compiler/srcmap"
+	"cyberbasic/compiler/vm"
+	"cyberbasic/internal/bastest"
+	"cyberbasic/internal/dap"
+)
+
+// runDAP serves the Debug Adapter Protocol on stdin/stdout, or on a TCP port when addr is set
+// ("4711", ":4711" or "host:4711"). The program comes from the launch request, else filename.
+// It returns the process exit code.
+func runDAP(addr, filename string) int {
+	protocolOut := os.Stdout
+	// Nothing but protocol messages may reach stdout; program output is forwarded as output events.
+	os.Stdout = os.Stderr
+
+	var r io.Reader = os.Stdin
+	var w io.Writer = protocolOut
+	if addr != "" {
+		if !strings.Contains(addr, ":") {
+			addr = ":" + addr
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "DAP: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "DAP server listening on %s\n", ln.Addr())
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "DAP: %v\n", err)
+			return 1
+		}
+		defer conn.Close()
+		r, w = conn, conn
+	}
+
+	srv := dap.NewServer(r, w)
+	var rt *runtime.Runtime
+	err := srv.Serve(func(program string) (*dap.Target, error) {
+		if program == "" {
+			program = filename
+		}
+		if program == "" {
+			return nil, fmt.Errorf("no program to debug (set \"program\" in the launch configuration)")
+		}
+		var mode runtime.WindowMode
+		var err error
+		rt, mode, err = loadForDebug(program)
+		if err != nil {
+			return nil, err
+		}
+		run := func() error {
+			if err := rt.GetVM().Run(); err != nil {
+				return err
+			}
+			if rt.HasImplicitHandlers() && mode != runtime.ModeExplicit {
+				return rt.RunImplicitLoop()
+			}
+			return nil
+		}
+		return &dap.Target{VM: rt.GetVM(), Main: program, Run: func() error { return forwardStdout(srv, run) }}, nil
+	})
+	if rt != nil {
+		rt.CloseWindow()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "DAP: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// loadForDebug compiles (or loads a precompiled .cbc) program and registers bindings, as Main does before Run.
+func loadForDebug(program string) (*runtime.Runtime, runtime.WindowMode, error) {
+	var mode runtime.WindowMode
+	source, err := os.ReadFile(program)
+	if err != nil {
+		return nil, mode, err
+	}
+	if abs, err := filepath.Abs(program); err == nil {
+		_ = os.Setenv("CYBERBASIC_SCRIPT", abs)
+	}
+	var chunk *vm.Chunk
+	var sourceStr string
+	if vm.IsChunkFile(source) {
+		var meta vm.ChunkMeta
+		chunk, meta, err = vm.ReadChunk(bytes.NewReader(source))
+		if err != nil {
+			return nil, mode, fmt.Errorf("loading %s: %w", program, err)
+		}
+		mode = runtime.ParseWindowMode(meta["windowmode"])
+	} else {
+		var smap *srcmap.Map
+		source, smap = PreprocessIncludes(source, program)
+		sourceStr = string(source)
+		chunk, err = compiler.New().CompileWithOptions(sourceStr, compiler.CompileOptions{Filename: program, SourceMap: smap})
+		if err != nil {
+			var msg strings.Builder
+			errors.PrettyPrintMapped(&msg, sourceStr, program, smap, err)
+			return nil, mode, fmt.Errorf("%s", strings.TrimSpace(msg.String()))
+		}
+		mode = runtime.DetectWindowMode(sourceStr)
+	}
+	rt := runtime.NewRuntime()
+	rt.GetVM().LoadChunk(chunk)
+	stdRegisterEnumsAndRuntime(rt, chunk)
+	if err := bindings.RegisterAll(rt.GetVM(), bindings.RegisterOptions{Source: sourceStr, Mode: &mode}); err != nil {
+		return nil, mode, fmt.Errorf("register bindings: %w", err)
+	}
+	if isTestFile(program) {
+		bastest.RegisterBasTest(rt.GetVM())
+	}
+	return rt, mode, nil
+}
+
+// forwardStdout runs fn with os.Stdout redirected to the client as "stdout" output events.
+func forwardStdout(srv *dap.Server, fn func() error) error {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fn()
+	}
+	saved := os.Stdout
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				srv.Output("stdout", string(buf[:n]))
+			}
+			if err != nil {
+				break
+			}
+		}
+		close(done)
+	}()
+	err = fn()
+	os.Stdout = saved
+	pw.Close()
+	<-done
+	pr.Close()
+	return err
+}
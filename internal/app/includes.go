@@ -6,24 +6,40 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+var (
+	includeRe = regexp.MustCompile(`^\s*#include\s*"([^"]+)"\s*$`)
+	importRe  = regexp.MustCompile(`(?i)^\s*IMPORT\s*"([^"]+)"\s*$`)
 )
 
-// PreprocessIncludes expands #include "file.bas" and IMPORT "file.bas" with file contents (relative to baseDir). seen prevents cycles.
-func PreprocessIncludes(source []byte, baseDir string, seen map[string]bool) []byte {
-	if seen == nil {
-		seen = make(map[string]bool)
+// PreprocessIncludes expands #include "file.bas" and IMPORT "file.bas" with file contents (relative to the including file).
+// Each file is spliced at most once. The returned map gives the original file and line of every output line.
+func PreprocessIncludes(source []byte, filename string) ([]byte, *srcmap.Map) {
+	m := srcmap.New(filename)
+	seen := make(map[string]bool)
+	if abs, err := filepath.Abs(filename); err == nil {
+		seen[abs] = true
 	}
-	includeRe := regexp.MustCompile(`^\s*#include\s*"([^"]+)"\s*$`)
-	importRe := regexp.MustCompile(`(?i)^\s*IMPORT\s*"([^"]+)"\s*$`)
 	var out strings.Builder
+	preprocessFile(source, filename, seen, &out, m)
+	return []byte(out.String()), m
+}
+
+func preprocessFile(source []byte, filename string, seen map[string]bool, out *strings.Builder, m *srcmap.Map) {
+	baseDir := filepath.Dir(filename)
 	sc := bufio.NewScanner(strings.NewReader(string(source)))
+	lineNo := 0
 	for sc.Scan() {
 		line := sc.Text()
+		lineNo++
 		var filePath string
-		if m := includeRe.FindStringSubmatch(line); m != nil {
-			filePath = m[1]
-		} else if m := importRe.FindStringSubmatch(line); m != nil {
-			filePath = m[1]
+		if match := includeRe.FindStringSubmatch(line); match != nil {
+			filePath = match[1]
+		} else if match := importRe.FindStringSubmatch(line); match != nil {
+			filePath = match[1]
 		}
 		if filePath != "" {
 			path := filepath.Join(baseDir, filePath)
@@ -33,21 +49,13 @@ func PreprocessIncludes(source []byte, baseDir string, seen map[string]bool) []b
 			}
 			seen[abs] = true
 			inc, err := os.ReadFile(path)
-			if err != nil {
-				out.WriteString(line)
-				out.WriteByte('\n')
+			if err == nil {
+				preprocessFile(inc, path, seen, out, m)
 				continue
 			}
-			incDir := filepath.Dir(path)
-			inc = PreprocessIncludes(inc, incDir, seen)
-			out.Write(inc)
-			if len(inc) > 0 && inc[len(inc)-1] != '\n' {
-				out.WriteByte('\n')
-			}
-			continue
 		}
 		out.WriteString(line)
 		out.WriteByte('\n')
+		m.Add(filename, lineNo)
 	}
-	return []byte(out.String())
 }
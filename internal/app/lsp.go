@@ -0,0 +1,34 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"cyberbasic/compiler/bindings"
+	"cyberbasic/compiler/bindings/std"
+	"cyberbasic/compiler/vm"
+	"cyberbasic/internal/lsp"
+)
+
+// runLSP serves the Language Server Protocol on stdin/stdout and returns the process exit code.
+// Foreign completions come from the same registry a running program sees.
+func runLSP() int {
+	protocolOut := os.Stdout
+	// Bindings may log while registering; only protocol messages may reach stdout.
+	os.Stdout = os.Stderr
+
+	v := vm.NewVM()
+	if err := bindings.RegisterAll(v, bindings.RegisterOptions{}); err != nil {
+		fmt.Fprintf(os.Stderr, "LSP: register bindings: %v\n", err)
+	}
+	srv := lsp.NewServer(os.Stdin, protocolOut, lsp.Options{
+		Foreign:    v.ForeignNames(),
+		Help:       std.HelpLine,
+		Preprocess: PreprocessIncludes,
+	})
+	if err := srv.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "LSP: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+// runBuildNative translates the program to Go and builds it into the executable out (--build-native).
+// The generated code imports the VM and bindings packages, so it is built inside the CyberBasic module,
+// named by CYBERBASIC_ROOT or found above the working directory or the cyberbasic executable. It returns the
+// exit code.
+func runBuildNative(source, out, basFilename string, smap *srcmap.Map) int {
+	goCode := genGoSource(source, basFilename, smap)
+	root := moduleRoot()
+	if root == "" {
+		fmt.Println("Build error: --build-native needs the CyberBasic source tree (go.mod with module cyberbasic); set CYBERBASIC_ROOT to it")
+		return 1
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		fmt.Println("Build error: --build-native needs the Go toolchain on PATH")
+		return 1
+	}
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		fmt.Printf("Build error: %v\n", err)
+		return 1
+	}
+	dir, err := os.MkdirTemp(root, ".cbnative-")
+	if err != nil {
+		fmt.Printf("Build error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(file, []byte(goCode), 0644); err != nil {
+		fmt.Printf("Write error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Building %s...\n", out)
+	cmd := exec.Command("go", "build", "-o", absOut, file)
+	cmd.Dir = root
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Build error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Built %s\n", out)
+	return 0
+}
+
+// moduleRoot returns the directory of the cyberbasic go.mod in CYBERBASIC_ROOT or above the working directory
+// or the executable, or "" when there is none.
+func moduleRoot() string {
+	if root := os.Getenv("CYBERBASIC_ROOT"); root != "" && isModuleRoot(root) {
+		return root
+	}
+	var starts []string
+	if wd, err := os.Getwd(); err == nil {
+		starts = append(starts, wd)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			starts = append(starts, filepath.Dir(exe))
+		}
+	}
+	for _, dir := range starts {
+		for {
+			if isModuleRoot(dir) {
+				return dir
+			}
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return ""
+}
+
+// isModuleRoot reports whether dir holds the go.mod of module cyberbasic.
+func isModuleRoot(dir string) bool {
+	mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return false
+	}
+	line, _, _ := bytes.Cut(bytes.TrimSpace(mod), []byte("\n"))
+	return string(bytes.TrimSpace(line)) == "module cyberbasic"
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cyberbasic/compiler/vm"
+)
+
+// startProfile attaches a sampling profiler to v and returns the function that writes its results: out gets
+// pprof data when it ends in .pb.gz or .pprof and folded flamegraph stacks otherwise; out+".txt" gets the report.
+func startProfile(v *vm.VM, out string) func() {
+	p := vm.NewProfiler(0)
+	v.SetProfiler(p)
+	return func() {
+		v.SetProfiler(nil)
+		p.Stop()
+		write := p.WriteFolded
+		if strings.HasSuffix(out, ".pb.gz") || strings.HasSuffix(out, ".pprof") {
+			write = p.WritePprof
+		}
+		if err := writeProfileFile(out, write); err != nil {
+			fmt.Fprintf(os.Stderr, "Profile: %v\n", err)
+			return
+		}
+		if err := writeProfileFile(out+".txt", p.WriteReport); err != nil {
+			fmt.Fprintf(os.Stderr, "Profile: %v\n", err)
+			return
+		}
+		fmt.Printf("Profile written to %s (report: %s.txt)\n", out, out)
+	}
+}
+
+func writeProfileFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/bindings"
+	"cyberbasic/compiler/errors"
+	"cyberbasic/compiler/rng"
+	"cyberbasic/compiler/runtime"
+	"cyberbasic/compiler/runtime/frame"
+	"cyberbasic/compiler/runtime/headless"
+	"cyberbasic/internal/bastest"
+)
+
+// testFrames is the default frame limit of a test file, so a game loop cannot hang `cyberbasic test`.
+const testFrames = 600
+
+// runTests implements `cyberbasic test [--tap] [--junit file] [--update] [--frames n] [files or dirs]`: it runs
+// each *_test.bas file (found under the directories, "." by default) headless and reports its TEST blocks
+// (package bastest). It returns the exit code: 1 when a test or a file failed.
+func runTests(args []string) int {
+	tap, update, junit, frames := false, false, "", testFrames
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--tap":
+			tap = true
+		case arg == "--update":
+			update = true
+		case arg == "--junit" && i+1 < len(args):
+			i++
+			junit = args[i]
+		case strings.HasPrefix(arg, "--junit="):
+			junit = strings.TrimPrefix(arg, "--junit=")
+		case arg == "--frames" && i+1 < len(args):
+			i++
+			frames = parseFrames(args[i])
+		case strings.HasPrefix(arg, "--frames="):
+			frames = parseFrames(strings.TrimPrefix(arg, "--frames="))
+		case strings.HasPrefix(arg, "-"):
+			fmt.Printf("Error: unknown test option %s\n", arg)
+			return 1
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Println("no _test.bas files found")
+		return 0
+	}
+
+	var suites []*bastest.Suite
+	for _, f := range files {
+		s := bastest.NewSuite(f, update)
+		if err := bastest.Run(s, func() error { return runTestFile(f, frames) }); err != nil {
+			fmt.Printf("Error: %s: %v\n", f, err)
+			return 1
+		}
+		suites = append(suites, s)
+	}
+
+	if tap {
+		bastest.WriteTAP(os.Stdout, suites)
+	} else {
+		bastest.WriteText(os.Stdout, suites)
+	}
+	if junit != "" {
+		out, err := os.Create(junit)
+		if err == nil {
+			err = bastest.WriteJUnit(out, suites)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: --junit: %v\n", err)
+			return 1
+		}
+	}
+	for _, s := range suites {
+		if s.Failed() {
+			if !tap {
+				fmt.Println("FAIL")
+			}
+			return 1
+		}
+	}
+	if !tap {
+		fmt.Println("PASS")
+	}
+	return 0
+}
+
+// findTestFiles returns the files named in paths and the *_test.bas files under the directories among them,
+// skipping testdata and hidden directories, sorted.
+func findTestFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if name := d.Name(); path != p && (name == "testdata" || strings.HasPrefix(name, ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isTestFile(d.Name()) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isTestFile reports whether name is a *_test.bas file, whose TEST blocks need the bastest foreigns.
+func isTestFile(name string) bool { return strings.HasSuffix(name, "_test.bas") }
+
+// runTestFile compiles and runs one test file headless, for at most frames frames, with the random source seeded
+// the same every run. A compile or runtime error is returned.
+func runTestFile(filename string, frames int) error {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	source, smap := PreprocessIncludes(source, filename)
+	src := string(source)
+	chunk, err := compiler.New().CompileWithOptions(src, compiler.CompileOptions{Filename: filename, SourceMap: smap})
+	if err != nil {
+		var b strings.Builder
+		errors.PrettyPrintMapped(&b, src, filename, smap, err)
+		return fmt.Errorf("%s", strings.TrimSpace(b.String()))
+	}
+	if absPath, err := filepath.Abs(filename); err == nil {
+		_ = os.Setenv("CYBERBASIC_SCRIPT", absPath)
+	}
+
+	rng.Seed(1)
+	headless.Reset()
+	headless.Enable()
+	defer headless.Reset()
+	mode := runtime.DetectWindowMode(src)
+	rt := runtime.NewRuntime()
+	v := rt.GetVM()
+	v.LoadChunk(chunk)
+	stdRegisterEnumsAndRuntime(rt, chunk)
+	if err := bindings.RegisterAll(v, bindings.RegisterOptions{Source: src, Mode: &mode, Headless: true}); err != nil {
+		return err
+	}
+	bastest.RegisterBasTest(v)
+	frame.Limit(frames, v.Quit)
+	defer frame.Limit(0, nil)
+	defer rt.CloseWindow()
+	if err := v.Run(); err != nil {
+		return fmt.Errorf("runtime error: %v", err)
+	}
+	if rt.HasImplicitHandlers() && mode != runtime.ModeExplicit {
+		if err := rt.RunImplicitLoop(); err != nil {
+			return fmt.Errorf("runtime error: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/errors"
+	"cyberbasic/compiler/optimizer"
+	"cyberbasic/compiler/srcmap"
+	"cyberbasic/compiler/vm"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+const (
+	// watchSpareVariables is how many variables an edit may add before the program has to be restarted.
+	watchSpareVariables = 1024
+	// watchPoll is how often --watch looks at the modification times of the program's files.
+	watchPoll = 300 * time.Millisecond
+)
+
+// watcher implements --watch: at the end of a frame it recompiles the program when the main file or one of
+// its #include / IMPORT files has changed and reloads the result into the running VM (see VM.Reload).
+// A failed build is printed and drawn over the game until it is fixed; the last good build keeps running.
+type watcher struct {
+	filename string
+	v        *vm.VM
+	optimize optimizer.Level
+	modTimes map[string]time.Time // files of the last build -> modification time when it was read
+	lastPoll time.Time
+	problem  string // compile or reload error shown on screen ("" = none)
+}
+
+func newWatcher(filename string, v *vm.VM, smap *srcmap.Map, optimize optimizer.Level) *watcher {
+	w := &watcher{filename: filename, v: v, optimize: optimize}
+	w.noteFiles(smap)
+	return w
+}
+
+// noteFiles records the modification times of the main file and the files smap says were spliced into it.
+func (w *watcher) noteFiles(smap *srcmap.Map) {
+	w.modTimes = map[string]time.Time{w.filename: modTime(w.filename)}
+	if smap != nil {
+		for _, f := range smap.Files {
+			w.modTimes[f] = modTime(f)
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+func (w *watcher) changed() bool {
+	for f, t := range w.modTimes {
+		if !modTime(f).Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// endFrame is registered with frame.OnEnd.
+func (w *watcher) endFrame() {
+	if time.Since(w.lastPoll) >= watchPoll {
+		w.lastPoll = time.Now()
+		if w.changed() {
+			w.reload()
+		}
+	}
+	w.drawProblem()
+}
+
+func (w *watcher) reload() {
+	source, err := os.ReadFile(w.filename)
+	if err != nil {
+		w.fail(err.Error())
+		return
+	}
+	source, smap := PreprocessIncludes(source, w.filename)
+	w.noteFiles(smap)
+	chunk, err := compiler.New().CompileWithOptions(string(source), compiler.CompileOptions{
+		Filename: w.filename, SourceMap: smap, Optimize: w.optimize, Base: w.v.Chunk(),
+	})
+	if err != nil {
+		var b bytes.Buffer
+		errors.PrettyPrintMapped(&b, string(source), w.filename, smap, err)
+		fmt.Print(b.String())
+		w.fail(b.String())
+		return
+	}
+	if err := w.v.Reload(chunk); err != nil {
+		w.fail("Reload: " + err.Error())
+		fmt.Println("Reload:", err)
+		return
+	}
+	w.problem = ""
+	fmt.Printf("Reloaded %s\n", w.filename)
+}
+
+func (w *watcher) fail(msg string) {
+	w.problem = strings.TrimSpace(msg)
+	fmt.Println("Still running the last good build; fix the error and save to reload.")
+}
+
+// drawProblem draws the current error over the top of the frame.
+func (w *watcher) drawProblem() {
+	if w.problem == "" || !rl.IsWindowReady() {
+		return
+	}
+	lines := strings.Split(w.problem, "\n")
+	if len(lines) > 12 {
+		lines = lines[:12]
+	}
+	const size, pad = 20, 10
+	h := int32(len(lines)*(size+4) + 2*pad)
+	rl.DrawRectangle(0, 0, int32(rl.GetScreenWidth()), h, rl.NewColor(40, 0, 0, 220))
+	for i, line := range lines {
+		rl.DrawText(line, pad, int32(pad+i*(size+4)), size, rl.NewColor(255, 120, 120, 255))
+	}
+}
@@ -0,0 +1,220 @@
+// Package bastest runs the TEST blocks of BASIC programs for `cyberbasic test`. A TEST "name" ... END TEST
+// block compiles to TestBegin(name, line), its body in a TRY whose CATCH calls TestFail(err), and TestEnd();
+// RegisterBasTest installs those and TestSnapshot, which internal/app does only for `cyberbasic test` and
+// *_test.bas files (they are not part of bindings.RegisterAll). Under Run they report to a Suite: each TEST becomes a Case
+// with its failures and the PRINT output of its body, compared with golden files (see golden.go), and WriteText,
+// WriteTAP and WriteJUnit report the suites. Without a running suite (a _test.bas started with `cyberbasic
+// file`), a failed test prints a FAIL line and the program carries on.
+package bastest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cyberbasic/compiler/vm"
+)
+
+// Case is the result of one TEST block.
+type Case struct {
+	Name     string
+	Line     int
+	Failures []string // failed ASSERTs, errors and golden mismatches, in order
+	Output   string   // what the body PRINTed
+	Duration time.Duration
+}
+
+// Failed reports whether the test failed.
+func (c *Case) Failed() bool { return len(c.Failures) > 0 }
+
+// Suite is the result of running one _test.bas file.
+type Suite struct {
+	File     string
+	Golden   string // directory of the golden files, testdata/<file name without .bas> beside File
+	Update   bool   // write the golden files instead of comparing with them
+	Cases    []*Case
+	Err      error  // compile or runtime error outside the TEST blocks
+	Output   string // everything the program PRINTed
+	Duration time.Duration
+
+	out   *os.File // captured os.Stdout while running
+	open  *Case    // the TEST block being run
+	mark  int64    // out offset where open began
+	began time.Time
+}
+
+// NewSuite returns a Suite for the test file at path.
+func NewSuite(path string, update bool) *Suite {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &Suite{File: path, Golden: filepath.Join(filepath.Dir(path), "testdata", name), Update: update}
+}
+
+// Failed reports whether the suite had an error or a failed test.
+func (s *Suite) Failed() bool {
+	if s.Err != nil {
+		return true
+	}
+	for _, c := range s.Cases {
+		if c.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	mu      sync.Mutex
+	current *Suite
+	loose   *Case // the TEST block being run without a suite
+)
+
+// Run makes s the current suite and calls run (which compiles and runs s.File) with os.Stdout captured. An
+// error from run becomes s.Err; a TEST block still open when run returns (the program quit inside it) ends there.
+func Run(s *Suite, run func() error) error {
+	out, err := os.CreateTemp("", "cyberbasic-test-*.out")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	mu.Lock()
+	s.out, s.Cases, s.Err = out, nil, nil
+	current = s
+	mu.Unlock()
+	start := time.Now()
+
+	s.Err = run()
+
+	mu.Lock()
+	if s.open != nil {
+		s.end()
+	}
+	current, s.out = nil, nil
+	mu.Unlock()
+	os.Stdout = stdout
+	s.Duration = time.Since(start)
+	all, err := io.ReadAll(io.NewSectionReader(out, 0, 1<<62))
+	s.Output = string(all)
+	return err
+}
+
+// RegisterBasTest registers TestBegin, TestFail, TestEnd and TestSnapshot.
+func RegisterBasTest(v *vm.VM) {
+	v.RegisterForeign("TestBegin", func(args []interface{}) (interface{}, error) {
+		c := &Case{}
+		if len(args) >= 1 {
+			c.Name = fmt.Sprint(args[0])
+		}
+		if len(args) >= 2 {
+			c.Line = toInt(args[1])
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		s := current
+		if s == nil {
+			loose = c
+			return nil, nil
+		}
+		if s.open != nil {
+			s.end()
+		}
+		s.open, s.began = c, time.Now()
+		s.mark, _ = s.out.Seek(0, io.SeekCurrent)
+		return nil, nil
+	})
+	v.RegisterForeign("TestFail", func(args []interface{}) (interface{}, error) {
+		msg := "test failed"
+		if len(args) >= 1 {
+			msg = failure(args[0])
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case current != nil && current.open != nil:
+			current.open.Failures = append(current.open.Failures, msg)
+		case loose != nil:
+			fmt.Printf("FAIL %s: %s\n", loose.Name, msg)
+		default:
+			fmt.Printf("FAIL: %s\n", msg)
+		}
+		return nil, nil
+	})
+	v.RegisterForeign("TestEnd", func(args []interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if current != nil && current.open != nil {
+			current.end()
+		}
+		loose = nil
+		return nil, nil
+	})
+	// TestSnapshot([name]) compares the last drawn frame with a golden image (see snapshot).
+	v.RegisterForeign("TestSnapshot", func(args []interface{}) (interface{}, error) {
+		name := ""
+		if len(args) >= 1 {
+			name = fmt.Sprint(args[0])
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if s := current; s != nil && s.open != nil {
+			if msg := s.snapshot(s.open, name); msg != "" {
+				s.open.Failures = append(s.open.Failures, msg)
+			}
+		}
+		return nil, nil
+	})
+}
+
+// end closes the open case: it takes the output printed since TestBegin and compares it with the golden output.
+// The caller holds mu.
+func (s *Suite) end() {
+	c := s.open
+	s.open = nil
+	c.Duration = time.Since(s.began)
+	if pos, err := s.out.Seek(0, io.SeekCurrent); err == nil && pos > s.mark {
+		buf := make([]byte, pos-s.mark)
+		n, _ := s.out.ReadAt(buf, s.mark)
+		c.Output = string(buf[:n])
+	}
+	if msg := s.compareOutput(c); msg != "" {
+		c.Failures = append(c.Failures, msg)
+	}
+	s.Cases = append(s.Cases, c)
+}
+
+// failure formats what TestFail received: a failed ASSERT or runtime error as "file:line: message".
+func failure(a interface{}) string {
+	e, ok := a.(*vm.ScriptError)
+	if !ok {
+		return fmt.Sprint(a)
+	}
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return e.Message
+}
+
+func toInt(a interface{}) int {
+	switch x := a.(type) {
+	case int:
+		return x
+	case int32:
+		return int(x)
+	case int64:
+		return int(x)
+	case float64:
+		return int(x)
+	case float32:
+		return int(x)
+	}
+	return 0
+}
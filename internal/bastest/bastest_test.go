@@ -0,0 +1,109 @@
+package bastest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/bindings/std"
+	"cyberbasic/compiler/runtime"
+)
+
+func runSuite(t *testing.T, s *Suite, src string) {
+	t.Helper()
+	chunk, err := compiler.New().Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Run(s, func() error {
+		rt := runtime.NewRuntime()
+		v := rt.GetVM()
+		v.LoadChunk(chunk)
+		v.SetRuntime(rt)
+		std.RegisterStd(v)
+		RegisterBasTest(v)
+		return v.Run()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunReportsCasesAndGoldenOutput(t *testing.T) {
+	src := `VAR score = 0
+PRINT "setup"
+TEST "adds points"
+  score = score + 5
+  PRINT "score " + STR(score)
+END TEST
+TEST "fails"
+  PRINT "before"
+  ASSERT score = 6, "score is " + STR(score)
+  PRINT "not reached"
+END TEST
+PRINT "done"
+`
+	s := NewSuite(filepath.Join(t.TempDir(), "score_test.bas"), false)
+	runSuite(t, s, src)
+	if s.Err != nil || len(s.Cases) != 2 {
+		t.Fatalf("suite: err %v, %d cases", s.Err, len(s.Cases))
+	}
+	pass, fail := s.Cases[0], s.Cases[1]
+	if pass.Name != "adds points" || pass.Line != 3 || pass.Failed() || pass.Output != "score 5\n" {
+		t.Errorf("first case = %+v", pass)
+	}
+	if !fail.Failed() || fail.Failures[0] != "line 9: score is 5" || fail.Output != "before\n" {
+		t.Errorf("second case = %+v", fail)
+	}
+	if s.Output != "setup\nscore 5\nbefore\ndone\n" {
+		t.Errorf("suite output = %q", s.Output)
+	}
+
+	// --update writes the golden output of tests that print; a later run compares with it.
+	s.Update = true
+	runSuite(t, s, src)
+	if got, err := os.ReadFile(filepath.Join(s.Golden, "adds_points.out")); err != nil || string(got) != "score 5\n" {
+		t.Fatalf("golden output = %q, %v", got, err)
+	}
+	s.Update = false
+	runSuite(t, s, strings.Replace(src, "score + 5", "score + 4", 1))
+	if c := s.Cases[0]; !c.Failed() || !strings.Contains(c.Failures[0], `line 1: got "score 4", want "score 5"`) {
+		t.Errorf("changed output: %+v", c)
+	}
+}
+
+func TestReports(t *testing.T) {
+	s := &Suite{File: "dir/a_test.bas", Cases: []*Case{
+		{Name: "ok"},
+		{Name: "bad # one", Failures: []string{"line 2: boom", "second"}, Output: "x\n"},
+	}}
+	var tap bytes.Buffer
+	WriteTAP(&tap, []*Suite{s})
+	want := "TAP version 13\n1..2\nok 1 - a_test.bas: ok\nnot ok 2 - a_test.bas: bad \\# one\n" +
+		"  ---\n  message: |\n    line 2: boom\n    second\n  ...\n"
+	if tap.String() != want {
+		t.Errorf("TAP:\n%s\nwant:\n%s", tap.String(), want)
+	}
+
+	var junit bytes.Buffer
+	if err := WriteJUnit(&junit, []*Suite{s}); err != nil {
+		t.Fatal(err)
+	}
+	for _, frag := range []string{
+		`<testsuites tests="2" failures="1" errors="0"`,
+		`<testsuite name="dir/a_test.bas" tests="2" failures="1"`,
+		`<testcase name="ok" classname="a_test" time="0.000"></testcase>`,
+		`<failure message="line 2: boom">line 2: boom&#xA;second</failure>`,
+	} {
+		if !strings.Contains(junit.String(), frag) {
+			t.Errorf("JUnit report lacks %s:\n%s", frag, junit.String())
+		}
+	}
+
+	if got := slug("Player: jumps & lands!"); got != "player_jumps_lands" {
+		t.Errorf("slug = %q", got)
+	}
+}
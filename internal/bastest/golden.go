@@ -0,0 +1,144 @@
+package bastest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cyberbasic/compiler/runtime/headless"
+)
+
+// Golden files live in the suite's Golden directory, named after the test: <test>.out holds the PRINT output of
+// the test's body and <test>.png (or <test>_<name>.png for TestSnapshot(name)) a snapshot. With Update they are
+// written; otherwise output is compared when its golden file exists, and a snapshot always (a missing golden
+// image fails the test). A differing snapshot is written beside its golden file as .actual.png.
+
+// compareOutput compares c.Output with the golden output, returning a failure message or "".
+func (s *Suite) compareOutput(c *Case) string {
+	path := filepath.Join(s.Golden, slug(c.Name)+".out")
+	if s.Update {
+		if c.Output == "" {
+			return ""
+		}
+		return writeGolden(path, []byte(c.Output))
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	if d := diffLines(string(want), c.Output); d != "" {
+		return fmt.Sprintf("output differs from %s: %s", path, d)
+	}
+	return ""
+}
+
+// snapshot rasterizes the last drawn frame (headless.Rasterize) and compares it with the golden image, returning a
+// failure message or "".
+func (s *Suite) snapshot(c *Case, name string) string {
+	base := slug(c.Name)
+	if name != "" {
+		base += "_" + slug(name)
+	}
+	path := filepath.Join(s.Golden, base+".png")
+	w, h := headless.ScreenSize()
+	got := headless.Rasterize(headless.LastFrame(), w, h)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, got); err != nil {
+		return err.Error()
+	}
+	if s.Update {
+		return writeGolden(path, buf.Bytes())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Sprintf("no golden image %s (run with --update to write it)", path)
+	}
+	want, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Sprintf("%s: %v", path, err)
+	}
+	n := diffPixels(want, got)
+	actual := strings.TrimSuffix(path, ".png") + ".actual.png"
+	if n == 0 {
+		os.Remove(actual) // from an earlier failing run
+		return ""
+	}
+	os.WriteFile(actual, buf.Bytes(), 0o644)
+	if n < 0 {
+		return fmt.Sprintf("snapshot is %dx%d, golden image %s is %dx%d (wrote %s)", w, h, path, want.Bounds().Dx(), want.Bounds().Dy(), actual)
+	}
+	return fmt.Sprintf("snapshot differs from %s in %d pixels (wrote %s)", path, n, actual)
+}
+
+func writeGolden(path string, data []byte) string {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err.Error()
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// diffPixels returns the number of pixels that differ, or -1 when the sizes do.
+func diffPixels(want image.Image, got *image.RGBA) int {
+	b := want.Bounds()
+	if b.Dx() != got.Rect.Dx() || b.Dy() != got.Rect.Dy() {
+		return -1
+	}
+	n := 0
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, a := want.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := got.At(x, y).RGBA()
+			if r != r2 || g != g2 || bl != b2 || a != a2 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// diffLines describes the first line where got differs from want, or returns "" when they are equal.
+func diffLines(want, got string) string {
+	if want == got {
+		return ""
+	}
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(w):
+			return fmt.Sprintf("line %d: got %q, want end of output", i+1, g[i])
+		case i >= len(g):
+			return fmt.Sprintf("line %d: got end of output, want %q", i+1, w[i])
+		case w[i] != g[i]:
+			return fmt.Sprintf("line %d: got %q, want %q", i+1, g[i], w[i])
+		}
+	}
+}
+
+// slug makes a test name a file name: lower-case letters and digits, other runs of characters become "_".
+func slug(name string) string {
+	var b strings.Builder
+	under := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if under && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			under = false
+		} else {
+			under = true
+		}
+	}
+	if b.Len() == 0 {
+		return "test"
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package bastest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WriteText writes the default report: a line per test, the failures and output of failed tests, and a
+// PASS or FAIL line per file.
+func WriteText(w io.Writer, suites []*Suite) {
+	for _, s := range suites {
+		for _, c := range s.Cases {
+			if !c.Failed() {
+				fmt.Fprintf(w, "--- PASS: %s (%ss)\n", c.Name, seconds(c.Duration))
+				continue
+			}
+			fmt.Fprintf(w, "--- FAIL: %s (%ss)\n", c.Name, seconds(c.Duration))
+			for _, f := range c.Failures {
+				fmt.Fprintf(w, "    %s\n", indent(f, "    "))
+			}
+			if c.Output != "" {
+				fmt.Fprintf(w, "    output:\n        %s\n", indent(strings.TrimSuffix(c.Output, "\n"), "        "))
+			}
+		}
+		if s.Err != nil {
+			fmt.Fprintf(w, "    %s\n", indent(s.Err.Error(), "    "))
+		}
+		status := "ok  "
+		if s.Failed() {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s %s (%d tests, %ss)\n", status, s.File, len(s.Cases), seconds(s.Duration))
+	}
+}
+
+// WriteTAP writes a TAP version 13 report, one test point per TEST block and one for each suite error.
+func WriteTAP(w io.Writer, suites []*Suite) {
+	n := 0
+	for _, s := range suites {
+		n += len(s.Cases)
+		if s.Err != nil {
+			n++
+		}
+	}
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", n)
+	i := 0
+	point := func(ok bool, desc string, failures []string) {
+		i++
+		status := "ok"
+		if !ok {
+			status = "not ok"
+		}
+		fmt.Fprintf(w, "%s %d - %s\n", status, i, desc)
+		if !ok {
+			fmt.Fprintf(w, "  ---\n  message: |\n    %s\n  ...\n", indent(strings.Join(failures, "\n"), "    "))
+		}
+	}
+	for _, s := range suites {
+		file := filepath.Base(s.File)
+		for _, c := range s.Cases {
+			point(!c.Failed(), tapEscape(file+": "+c.Name), c.Failures)
+		}
+		if s.Err != nil {
+			point(false, tapEscape(file), []string{s.Err.Error()})
+		}
+	}
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes a JUnit XML report: a testsuite per file and a testcase per TEST block. A suite error is a
+// testcase with an error element, named after the file.
+func WriteJUnit(w io.Writer, suites []*Suite) error {
+	var all junitSuites
+	var total time.Duration
+	for _, s := range suites {
+		js := junitSuite{Name: s.File, Time: seconds(s.Duration)}
+		class := strings.TrimSuffix(filepath.Base(s.File), filepath.Ext(s.File))
+		for _, c := range s.Cases {
+			jc := junitCase{Name: c.Name, Classname: class, Time: seconds(c.Duration), SystemOut: c.Output}
+			if c.Failed() {
+				jc.Failure = &junitProblem{Message: c.Failures[0], Text: strings.Join(c.Failures, "\n")}
+				js.Failures++
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+		if s.Err != nil {
+			js.Cases = append(js.Cases, junitCase{Name: filepath.Base(s.File), Classname: class, Time: seconds(0),
+				Error: &junitProblem{Message: s.Err.Error(), Text: s.Err.Error()}})
+			js.Errors++
+		}
+		js.Tests = len(js.Cases)
+		all.Tests += js.Tests
+		all.Failures += js.Failures
+		all.Errors += js.Errors
+		total += s.Duration
+		all.Suites = append(all.Suites, js)
+	}
+	all.Time = seconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(all); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// seconds formats d in seconds with millisecond precision, as JUnit reports want it.
+func seconds(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
+
+func indent(s, prefix string) string { return strings.ReplaceAll(s, "\n", "\n"+prefix) }
+
+// tapEscape escapes the characters TAP gives a meaning in a description.
+func tapEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "#", "\\#", "\n", " ").Replace(s)
+}
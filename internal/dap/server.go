@@ -0,0 +1,637 @@
+package dap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/vm"
+)
+
+// Target is a compiled program loaded into a VM, ready to run under the debugger.
+type Target struct {
+	VM   *vm.VM
+	Main string       // main source file as recorded in the chunk's source map (used for single-file programs)
+	Run  func() error // runs the program to completion on the calling goroutine (the VM's thread)
+}
+
+// Launcher compiles program (the "program" launch argument; may be empty) and loads it into a VM.
+type Launcher func(program string) (*Target, error)
+
+// errDisconnected aborts the program when the client disconnects.
+var errDisconnected = errors.New("debugger disconnected")
+
+// maxChildren limits how many array elements or fields are sent for one variable.
+const maxChildren = 1000
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// frameRef is a stack frame reported by stackTrace while stopped; its DAP id is index+1 in Server.frames.
+type frameRef struct {
+	top   bool // innermost frame of the running fiber: the only one whose parameters OpLoadParam can read
+	frame vm.DebugFrame
+}
+
+// Server is one debug session. Requests are read on a separate goroutine; everything that touches the VM
+// runs on the goroutine that called Serve, inside the VM's debug hook while the program runs.
+type Server struct {
+	conn     *conn
+	requests chan *request
+	readErr  error
+	launch   Launcher
+	target   *Target
+
+	configured  bool
+	quit        bool
+	stopOnEntry bool
+	breakpoints map[string][]int // client source path -> requested lines
+	bpLines     map[int]bool     // resolved chunk lines
+	codeLines   map[int]bool     // chunk lines that have code
+
+	stopped   bool
+	pause     bool
+	step      stepMode
+	stepDepth int
+	stepFiber int
+	lastLine  int
+	lastDepth int
+	lastFiber int
+	ticks     int
+
+	frames  []frameRef
+	handles []func() []variable // variablesReference-1 -> children; valid while stopped
+}
+
+// NewServer returns a session that reads requests from r and writes responses and events to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		conn:        newConn(r, w),
+		requests:    make(chan *request, 16),
+		breakpoints: make(map[string][]int),
+	}
+}
+
+// Output sends program output to the client (category "stdout", "stderr" or "console"). Safe from any goroutine.
+func (s *Server) Output(category, text string) {
+	_ = s.conn.event("output", map[string]interface{}{"category": category, "output": text})
+}
+
+// Serve runs the session: configuration requests until configurationDone, then the program (on the calling
+// goroutine), then requests until the client disconnects. It returns nil on disconnect or end of input.
+func (s *Server) Serve(launch Launcher) error {
+	s.launch = launch
+	go s.readLoop()
+	for !s.configured || s.target == nil {
+		req, ok := <-s.requests
+		if !ok {
+			return s.readErr
+		}
+		s.handle(req)
+		if s.quit {
+			return nil
+		}
+	}
+
+	v := s.target.VM
+	s.lastLine = -1
+	v.SetDebugHook(s.hook)
+	err := s.target.Run()
+	v.SetDebugHook(nil)
+	s.stopped = false
+	if s.quit {
+		return nil
+	}
+	exitCode := 0
+	if err != nil {
+		s.Output("stderr", fmt.Sprintf("Runtime error: %v\n", err))
+		exitCode = 2
+	}
+	_ = s.conn.event("exited", map[string]interface{}{"exitCode": exitCode})
+	_ = s.conn.event("terminated", nil)
+	for !s.quit {
+		req, ok := <-s.requests
+		if !ok {
+			return nil
+		}
+		s.handle(req)
+	}
+	return nil
+}
+
+func (s *Server) readLoop() {
+	for {
+		req, err := s.conn.read()
+		if err != nil {
+			if err != io.EOF {
+				s.readErr = err
+			}
+			close(s.requests)
+			return
+		}
+		s.requests <- req
+	}
+}
+
+// hook is the VM debug hook: it services requests now and then and stops when a new line is entered
+// at a breakpoint, at the end of a step, or after a pause request.
+func (s *Server) hook(v *vm.VM) error {
+	s.ticks++
+	if s.ticks&255 == 0 {
+		if err := s.poll(); err != nil {
+			return err
+		}
+	}
+	if s.pause {
+		return s.stop(v, "pause")
+	}
+	line, depth, fiber := v.CurrentLine(), v.CallDepth(), v.CurrentFiber()
+	if line == s.lastLine && depth == s.lastDepth && fiber == s.lastFiber {
+		return nil
+	}
+	s.lastLine, s.lastDepth, s.lastFiber = line, depth, fiber
+	if line <= 0 {
+		return nil
+	}
+	switch {
+	case s.stopOnEntry:
+		s.stopOnEntry = false
+		return s.stop(v, "entry")
+	case s.bpLines[line]:
+		return s.stop(v, "breakpoint")
+	case s.step == stepIn,
+		s.step == stepOver && fiber == s.stepFiber && depth <= s.stepDepth,
+		s.step == stepOut && fiber == s.stepFiber && depth < s.stepDepth:
+		return s.stop(v, "step")
+	}
+	return nil
+}
+
+// poll handles requests that arrived while the program runs, without blocking.
+func (s *Server) poll() error {
+	for {
+		select {
+		case req, ok := <-s.requests:
+			if !ok {
+				s.quit = true
+			} else {
+				s.handle(req)
+			}
+			if s.quit {
+				return errDisconnected
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// stop reports a stopped event and handles requests until the client resumes or disconnects.
+func (s *Server) stop(v *vm.VM, reason string) error {
+	s.pause, s.step = false, stepNone
+	s.stopped = true
+	s.frames, s.handles = nil, nil
+	_ = s.conn.event("stopped", map[string]interface{}{
+		"reason": reason, "threadId": v.CurrentFiber() + 1, "allThreadsStopped": true,
+	})
+	for s.stopped {
+		req, ok := <-s.requests
+		if !ok {
+			s.quit = true
+		} else {
+			s.handle(req)
+		}
+		if s.quit {
+			return errDisconnected
+		}
+	}
+	return nil
+}
+
+// resume leaves the stopped state with the given step mode.
+func (s *Server) resume(mode stepMode) {
+	if !s.stopped {
+		return
+	}
+	v := s.target.VM
+	s.step, s.stepDepth, s.stepFiber = mode, v.CallDepth(), v.CurrentFiber()
+	s.stopped = false
+	s.frames, s.handles = nil, nil
+}
+
+func (s *Server) handle(req *request) {
+	var err error
+	switch req.Command {
+	case "initialize":
+		err = s.conn.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		})
+	case "launch":
+		err = s.onLaunch(req)
+	case "setBreakpoints":
+		err = s.onSetBreakpoints(req)
+	case "setExceptionBreakpoints":
+		err = s.conn.respond(req, map[string]interface{}{"breakpoints": []breakpoint{}})
+	case "configurationDone":
+		s.configured = true
+		err = s.conn.respond(req, nil)
+	case "threads":
+		err = s.conn.respond(req, map[string]interface{}{"threads": s.threads()})
+	case "stackTrace":
+		err = s.onStackTrace(req)
+	case "scopes":
+		err = s.onScopes(req)
+	case "variables":
+		err = s.onVariables(req)
+	case "evaluate":
+		err = s.onEvaluate(req)
+	case "continue":
+		s.resume(stepNone)
+		err = s.conn.respond(req, map[string]interface{}{"allThreadsContinued": true})
+	case "next":
+		s.resume(stepOver)
+		err = s.conn.respond(req, nil)
+	case "stepIn":
+		s.resume(stepIn)
+		err = s.conn.respond(req, nil)
+	case "stepOut":
+		s.resume(stepOut)
+		err = s.conn.respond(req, nil)
+	case "pause":
+		s.pause = s.target != nil
+		err = s.conn.respond(req, nil)
+	case "disconnect", "terminate":
+		s.quit = true
+		s.stopped = false
+		err = s.conn.respond(req, nil)
+	default:
+		err = s.conn.fail(req, "unsupported request %s", req.Command)
+	}
+	if err != nil {
+		// The client is gone; end the session like a disconnect.
+		s.quit = true
+	}
+}
+
+func (s *Server) onLaunch(req *request) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil && len(req.Arguments) > 0 {
+		return s.conn.fail(req, "launch: %v", err)
+	}
+	if s.target != nil {
+		return s.conn.fail(req, "program already launched")
+	}
+	t, err := s.launch(args.Program)
+	if err != nil {
+		return s.conn.fail(req, "%v", err)
+	}
+	s.target = t
+	s.stopOnEntry = args.StopOnEntry
+	s.codeLines = make(map[int]bool)
+	for _, l := range t.VM.Chunk().Lines {
+		s.codeLines[l] = true
+	}
+	s.resolveBreakpoints()
+	if err := s.conn.respond(req, nil); err != nil {
+		return err
+	}
+	return s.conn.event("initialized", nil)
+}
+
+func (s *Server) onSetBreakpoints(req *request) error {
+	var args struct {
+		Source      source             `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.fail(req, "setBreakpoints: %v", err)
+	}
+	path := args.Source.Path
+	if path == "" {
+		path = args.Source.Name
+	}
+	lines := make([]int, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		lines[i] = bp.Line
+	}
+	s.breakpoints[path] = lines
+	s.resolveBreakpoints()
+	out := make([]breakpoint, len(lines))
+	for i, l := range lines {
+		out[i] = breakpoint{Line: l, Verified: s.target != nil && len(s.findLines(path, l)) > 0}
+		if s.target != nil && !out[i].Verified {
+			out[i].Message = "no code on this line"
+		}
+	}
+	return s.conn.respond(req, map[string]interface{}{"breakpoints": out})
+}
+
+// resolveBreakpoints maps every requested file:line to chunk lines.
+func (s *Server) resolveBreakpoints() {
+	s.bpLines = make(map[int]bool)
+	if s.target == nil {
+		return
+	}
+	for path, lines := range s.breakpoints {
+		for _, l := range lines {
+			for _, cl := range s.findLines(path, l) {
+				s.bpLines[cl] = true
+			}
+		}
+	}
+}
+
+// findLines returns the chunk lines with code that came from line of the client's source path.
+// Paths are compared as absolute paths; a path matching no recorded file falls back to its base name.
+func (s *Server) findLines(path string, line int) []int {
+	chunk := s.target.VM.Chunk()
+	want := absPath(path)
+	file := filepath.Base(path)
+	if chunk.SourceMap == nil {
+		if want != absPath(s.target.Main) {
+			return nil
+		}
+		file = ""
+	} else {
+		for _, f := range chunk.SourceMap.Files {
+			if absPath(f) == want {
+				file = f
+				break
+			}
+		}
+	}
+	var out []int
+	for _, cl := range chunk.SourceMap.Find(file, line) {
+		if s.codeLines[cl] {
+			out = append(out, cl)
+		}
+	}
+	return out
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// threads reports one DAP thread per fiber; thread id is fiber index + 1.
+func (s *Server) threads() []thread {
+	if s.target == nil {
+		return []thread{{ID: 1, Name: "main"}}
+	}
+	var out []thread
+	for _, f := range s.target.VM.Fibers() {
+		out = append(out, thread{ID: f.Index + 1, Name: fmt.Sprintf("%s (%s)", funcName(f.Name), f.State)})
+	}
+	return out
+}
+
+func funcName(name string) string {
+	if name == "" {
+		return "main"
+	}
+	return name
+}
+
+func (s *Server) source(file string) *source {
+	if file == "" {
+		file = s.target.Main
+	}
+	return &source{Name: filepath.Base(file), Path: absPath(file)}
+}
+
+func (s *Server) onStackTrace(req *request) error {
+	var args struct {
+		ThreadID   int `json:"threadId"`
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.fail(req, "stackTrace: %v", err)
+	}
+	if !s.stopped {
+		return s.conn.fail(req, "program is running")
+	}
+	v := s.target.VM
+	fibers := v.Fibers()
+	idx := args.ThreadID - 1
+	if idx < 0 || idx >= len(fibers) {
+		return s.conn.fail(req, "unknown thread %d", args.ThreadID)
+	}
+	var frames []vm.DebugFrame
+	if idx == v.CurrentFiber() {
+		frames = v.DebugFrames()
+	} else {
+		// Waiting fibers keep only their resume position.
+		f := fibers[idx]
+		frames = []vm.DebugFrame{{StackFrame: vm.StackFrame{IP: f.IP, File: f.File, Line: f.Line}, Func: v.Chunk().FunctionAt(f.IP)}}
+	}
+	end := len(frames)
+	if args.Levels > 0 && args.StartFrame+args.Levels < end {
+		end = args.StartFrame + args.Levels
+	}
+	out := []stackFrame{}
+	for i := args.StartFrame; i < end; i++ {
+		f := frames[i]
+		s.frames = append(s.frames, frameRef{top: i == 0 && idx == v.CurrentFiber(), frame: f})
+		out = append(out, stackFrame{ID: len(s.frames), Name: funcName(f.Func), Source: s.source(f.File), Line: f.Line, Column: 1})
+	}
+	return s.conn.respond(req, map[string]interface{}{"stackFrames": out, "totalFrames": len(frames)})
+}
+
+func (s *Server) frame(id int) (frameRef, bool) {
+	if id < 1 || id > len(s.frames) {
+		return frameRef{}, false
+	}
+	return s.frames[id-1], true
+}
+
+func (s *Server) onScopes(req *request) error {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.fail(req, "scopes: %v", err)
+	}
+	if !s.stopped {
+		return s.conn.fail(req, "program is running")
+	}
+	f, ok := s.frame(args.FrameID)
+	if !ok {
+		return s.conn.fail(req, "unknown frame %d", args.FrameID)
+	}
+	v := s.target.VM
+	var scopes []scope
+	if len(f.frame.Params) > 0 {
+		params := f.frame.Params
+		scopes = append(scopes, scope{Name: "Parameters", VariablesReference: s.addHandle(func() []variable {
+			return s.describeAll(params)
+		})})
+	}
+	scopes = append(scopes,
+		scope{Name: "Variables", VariablesReference: s.addHandle(func() []variable {
+			return s.describeAll(v.DebugVariables())
+		})},
+		scope{Name: "Globals", VariablesReference: s.addHandle(func() []variable {
+			globals := make(map[string]interface{}, len(v.Globals()))
+			for k, g := range v.Globals() {
+				globals[k] = g
+			}
+			return s.describeMap(globals)
+		})},
+		scope{Name: "Fibers", VariablesReference: s.addHandle(func() []variable {
+			var out []variable
+			for _, fb := range v.Fibers() {
+				out = append(out, variable{
+					Name:  fmt.Sprintf("#%d %s", fb.Index, funcName(fb.Name)),
+					Value: fmt.Sprintf("%s at %s:%d", fb.State, filepath.Base(s.source(fb.File).Path), fb.Line),
+				})
+			}
+			return out
+		})},
+	)
+	return s.conn.respond(req, map[string]interface{}{"scopes": scopes})
+}
+
+func (s *Server) onVariables(req *request) error {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.fail(req, "variables: %v", err)
+	}
+	if !s.stopped {
+		return s.conn.fail(req, "program is running")
+	}
+	ref := args.VariablesReference
+	if ref < 1 || ref > len(s.handles) {
+		return s.conn.fail(req, "unknown variables reference %d", ref)
+	}
+	vars := s.handles[ref-1]()
+	if vars == nil {
+		vars = []variable{}
+	}
+	return s.conn.respond(req, map[string]interface{}{"variables": vars})
+}
+
+// onEvaluate compiles the expression against the stopped program and runs it in place. Parameters are
+// visible in the innermost frame of the running fiber, which is also used when no frame is given.
+func (s *Server) onEvaluate(req *request) error {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.fail(req, "evaluate: %v", err)
+	}
+	if !s.stopped {
+		return s.conn.fail(req, "program is running")
+	}
+	v := s.target.VM
+	f, ok := s.frame(args.FrameID)
+	if !ok {
+		if frames := v.DebugFrames(); len(frames) > 0 {
+			f = frameRef{top: true, frame: frames[0]}
+		}
+	}
+	var params []string
+	if f.top && len(f.frame.Params) > 0 {
+		params = v.Chunk().Params[f.frame.Func]
+	}
+	expr, err := compiler.New().CompileExpression(args.Expression, v.Chunk(), params)
+	if err != nil {
+		return s.conn.fail(req, "%v", err)
+	}
+	val, err := v.Evaluate(expr)
+	if err != nil {
+		return s.conn.fail(req, "%v", err)
+	}
+	d := s.describe(args.Expression, val)
+	return s.conn.respond(req, map[string]interface{}{"result": d.Value, "type": d.Type, "variablesReference": d.VariablesReference})
+}
+
+func (s *Server) addHandle(fn func() []variable) int {
+	s.handles = append(s.handles, fn)
+	return len(s.handles)
+}
+
+func (s *Server) describeAll(vars []vm.DebugVar) []variable {
+	out := make([]variable, 0, len(vars))
+	for _, dv := range vars {
+		out = append(out, s.describe(dv.Name, dv.Value))
+	}
+	return out
+}
+
+func (s *Server) describeMap(m map[string]interface{}) []variable {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vars := make([]vm.DebugVar, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, vm.DebugVar{Name: k, Value: m[k]})
+	}
+	return s.describeAll(vars)
+}
+
+// describe renders a value; arrays, maps (TYPE instances, entities) and error objects are expandable.
+func (s *Server) describe(name string, val vm.Value) variable {
+	out := variable{Name: name}
+	switch x := val.(type) {
+	case nil:
+		out.Value = "nil"
+	case string:
+		out.Value, out.Type = strconv.Quote(x), "string"
+	case bool:
+		out.Value, out.Type = strconv.FormatBool(x), "boolean"
+	case int, int32, int64, float32, float64:
+		out.Value, out.Type = fmt.Sprint(x), "number"
+	case []vm.Value:
+		out.Value, out.Type = fmt.Sprintf("array(%d)", len(x)), "array"
+		out.VariablesReference = s.addHandle(func() []variable {
+			var vars []variable
+			for i, e := range x {
+				if i == maxChildren {
+					vars = append(vars, variable{Name: "...", Value: fmt.Sprintf("%d more", len(x)-i)})
+					break
+				}
+				vars = append(vars, s.describe(fmt.Sprintf("(%d)", i), e))
+			}
+			return vars
+		})
+	case map[string]interface{}:
+		out.Value, out.Type = fmt.Sprintf("{%d fields}", len(x)), "map"
+		out.VariablesReference = s.addHandle(func() []variable { return s.describeMap(x) })
+	case *vm.ScriptError:
+		out.Value, out.Type = strconv.Quote(x.Message), "error"
+		out.VariablesReference = s.addHandle(func() []variable {
+			return s.describeAll([]vm.DebugVar{
+				{Name: "message", Value: x.Message}, {Name: "line", Value: x.Line}, {Name: "file", Value: x.File},
+				{Name: "func", Value: x.Func}, {Name: "value", Value: x.Value},
+			})
+		})
+	default:
+		out.Value, out.Type = fmt.Sprintf("%v", x), fmt.Sprintf("%T", x)
+	}
+	return out
+}
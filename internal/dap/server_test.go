@@ -0,0 +1,239 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/srcmap"
+	"cyberbasic/compiler/vm"
+)
+
+const testProgram = `total = 10
+Function Twice(n)
+  Return n * 2
+End Function
+total = Twice(21)
+Note(total)
+Note("done")
+`
+
+// client drives a Server over pipes and collects the messages it skips while waiting.
+type client struct {
+	t    *testing.T
+	w    io.Writer
+	r    *textproto.Reader
+	seq  int
+	seen []map[string]interface{}
+}
+
+func startServer(t *testing.T, src string) (*client, chan error, *[]string) {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	srv := NewServer(inR, outW)
+	var notes []string
+	launch := func(program string) (*Target, error) {
+		if program != "main.bas" {
+			return nil, fmt.Errorf("unexpected program %q", program)
+		}
+		m := srcmap.New("main.bas")
+		for i := range strings.Split(src, "\n") {
+			m.Add("main.bas", i+1)
+		}
+		chunk, err := compiler.New().CompileWithOptions(src, compiler.CompileOptions{SourceMap: m})
+		if err != nil {
+			return nil, err
+		}
+		v := vm.NewVM()
+		v.RegisterForeign("Note", func(args []interface{}) (interface{}, error) {
+			notes = append(notes, fmt.Sprint(args[0]))
+			return nil, nil
+		})
+		v.LoadChunk(chunk)
+		return &Target{VM: v, Main: "main.bas", Run: v.Run}, nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.Serve(launch)
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+	return &client{t: t, w: inW, r: textproto.NewReader(bufio.NewReader(outR))}, done, &notes
+}
+
+func (c *client) send(command string, args interface{}) {
+	c.t.Helper()
+	c.seq++
+	body, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("send %s: %v", command, err)
+	}
+}
+
+func (c *client) next() map[string]interface{} {
+	c.t.Helper()
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read header: %v", err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatalf("read body: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("decode %s: %v", body, err)
+	}
+	return msg
+}
+
+// expect reads until the response to command or the named event arrives.
+func (c *client) expect(kind, name string) map[string]interface{} {
+	c.t.Helper()
+	for {
+		msg := c.next()
+		if msg["type"] == kind && (msg["command"] == name || msg["event"] == name) {
+			return msg
+		}
+		c.seen = append(c.seen, msg)
+	}
+}
+
+// request sends command and returns the body of its successful response.
+func (c *client) request(command string, args interface{}) map[string]interface{} {
+	c.t.Helper()
+	c.send(command, args)
+	resp := c.expect("response", command)
+	if resp["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, resp["message"])
+	}
+	body, _ := resp["body"].(map[string]interface{})
+	return body
+}
+
+func list(body map[string]interface{}, key string) []map[string]interface{} {
+	var out []map[string]interface{}
+	items, _ := body[key].([]interface{})
+	for _, it := range items {
+		out = append(out, it.(map[string]interface{}))
+	}
+	return out
+}
+
+func TestBreakpointInspectAndStep(t *testing.T) {
+	c, done, notes := startServer(t, testProgram)
+	c.request("initialize", map[string]interface{}{"adapterID": "cyberbasic"})
+	c.request("launch", map[string]interface{}{"program": "main.bas"})
+	c.expect("event", "initialized")
+	path, _ := filepath.Abs("main.bas")
+	bps := list(c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": path},
+		"breakpoints": []map[string]interface{}{{"line": 3}, {"line": 4}},
+	}), "breakpoints")
+	if len(bps) != 2 || bps[0]["verified"] != true || bps[1]["verified"] != false {
+		t.Fatalf("breakpoints = %v, want line 3 verified and line 4 (END FUNCTION) not", bps)
+	}
+	c.request("configurationDone", nil)
+
+	stopped := c.expect("event", "stopped")["body"].(map[string]interface{})
+	if stopped["reason"] != "breakpoint" || stopped["threadId"] != float64(1) {
+		t.Fatalf("stopped = %v", stopped)
+	}
+	frames := list(c.request("stackTrace", map[string]interface{}{"threadId": 1}), "stackFrames")
+	if len(frames) != 2 || frames[0]["name"] != "twice" || frames[0]["line"] != float64(3) || frames[1]["line"] != float64(5) {
+		t.Fatalf("stackFrames = %v", frames)
+	}
+	scopes := list(c.request("scopes", map[string]interface{}{"frameId": frames[0]["id"]}), "scopes")
+	if len(scopes) != 4 || scopes[0]["name"] != "Parameters" {
+		t.Fatalf("scopes = %v", scopes)
+	}
+	params := list(c.request("variables", map[string]interface{}{"variablesReference": scopes[0]["variablesReference"]}), "variables")
+	if len(params) != 1 || params[0]["name"] != "n" || params[0]["value"] != "21" {
+		t.Errorf("parameters = %v, want n = 21", params)
+	}
+	vars := list(c.request("variables", map[string]interface{}{"variablesReference": scopes[1]["variablesReference"]}), "variables")
+	if len(vars) != 1 || vars[0]["name"] != "total" || vars[0]["value"] != "10" {
+		t.Errorf("variables = %v, want total = 10", vars)
+	}
+	fibers := list(c.request("variables", map[string]interface{}{"variablesReference": scopes[3]["variablesReference"]}), "variables")
+	if len(fibers) != 1 || !strings.HasPrefix(fibers[0]["value"].(string), "running at main.bas:3") {
+		t.Errorf("fibers = %v", fibers)
+	}
+	eval := c.request("evaluate", map[string]interface{}{"expression": "n * 10 + total", "frameId": frames[0]["id"], "context": "watch"})
+	if eval["result"] != "220" {
+		t.Errorf("evaluate = %v, want 220", eval)
+	}
+	c.send("evaluate", map[string]interface{}{"expression": "missing(", "frameId": frames[0]["id"]})
+	if resp := c.expect("response", "evaluate"); resp["success"] != false {
+		t.Errorf("bad expression evaluated: %v", resp)
+	}
+
+	c.request("stepOut", map[string]interface{}{"threadId": 1})
+	c.expect("event", "stopped")
+	frames = list(c.request("stackTrace", map[string]interface{}{"threadId": 1}), "stackFrames")
+	if len(frames) != 1 || frames[0]["line"] != float64(5) {
+		t.Fatalf("after stepOut stackFrames = %v, want main at line 5", frames)
+	}
+	c.request("next", map[string]interface{}{"threadId": 1})
+	c.expect("event", "stopped")
+	frames = list(c.request("stackTrace", map[string]interface{}{"threadId": 1}), "stackFrames")
+	if frames[0]["line"] != float64(6) {
+		t.Fatalf("after next stackFrames = %v, want line 6", frames)
+	}
+
+	c.request("continue", map[string]interface{}{"threadId": 1})
+	if code := c.expect("event", "exited")["body"].(map[string]interface{})["exitCode"]; code != float64(0) {
+		t.Errorf("exitCode = %v", code)
+	}
+	c.expect("event", "terminated")
+	c.request("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if got := strings.Join(*notes, "|"); got != "42|done" {
+		t.Errorf("program output = %q, want 42|done", got)
+	}
+}
+
+func TestDisconnectWhileStopped(t *testing.T) {
+	c, done, notes := startServer(t, testProgram)
+	c.request("initialize", nil)
+	c.request("launch", map[string]interface{}{"program": "main.bas", "stopOnEntry": true})
+	c.request("configurationDone", nil)
+	if reason := c.expect("event", "stopped")["body"].(map[string]interface{})["reason"]; reason != "entry" {
+		t.Fatalf("stop reason = %v, want entry", reason)
+	}
+	threads := list(c.request("threads", nil), "threads")
+	if len(threads) != 1 || threads[0]["name"] != "main (running)" {
+		t.Errorf("threads = %v", threads)
+	}
+	c.request("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	if len(*notes) != 0 {
+		t.Errorf("program kept running after disconnect: %v", *notes)
+	}
+}
+
+func TestLaunchCompileError(t *testing.T) {
+	c, done, _ := startServer(t, "Print (")
+	c.request("initialize", nil)
+	c.send("launch", map[string]interface{}{"program": "main.bas"})
+	if resp := c.expect("response", "launch"); resp["success"] != false || !strings.Contains(fmt.Sprint(resp["message"]), "parse error") {
+		t.Errorf("launch = %v, want parse error", resp)
+	}
+	c.request("disconnect", nil)
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+}
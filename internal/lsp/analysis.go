@@ -0,0 +1,282 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cyberbasic/compiler"
+	"cyberbasic/compiler/parser"
+	"cyberbasic/compiler/srcmap"
+)
+
+// symbol is a Function, Sub or TYPE declared in the analysed program or one of its #include files.
+type symbol struct {
+	Name   string // declared name (identifiers are lowercase after lexing)
+	Kind   int    // completion item kind
+	Detail string // signature shown by hover and completion
+	File   string // original file (absolute path)
+	Line   int    // original 1-based line of the declaration
+	Text   string // source text of the declaration line, to find the name's column
+}
+
+// analysis is what the server knows about one open document: symbols for completion, hover and
+// definition, and diagnostics per file (an included file's errors are reported on that file).
+type analysis struct {
+	funcs   map[string]*symbol            // qualified lowercase name ("mymod.update" for module members)
+	modules map[string][]*symbol          // module (lowercase) -> its Functions and Subs
+	types   map[string]*symbol            // TYPE name (lowercase)
+	fields  map[string][]parser.TypeField // TYPE name (lowercase) -> fields
+	vars    map[string]string             // variable (lowercase) -> declared type ("" when untyped)
+	diags   map[string][]diagnostic       // absolute file -> diagnostics
+	parsed  bool                          // false when lexing or parsing failed (symbols are then empty)
+}
+
+// Preprocessor splices #include / IMPORT files into source (see app.PreprocessIncludes).
+type Preprocessor func(source []byte, filename string) ([]byte, *srcmap.Map)
+
+// analyze runs the compiler front end (Tokenize, Parse, Analyze) over the document at path.
+func analyze(path, text string, preprocess Preprocessor) *analysis {
+	a := &analysis{
+		funcs:   make(map[string]*symbol),
+		modules: make(map[string][]*symbol),
+		types:   make(map[string]*symbol),
+		fields:  make(map[string][]parser.TypeField),
+		vars:    make(map[string]string),
+		diags:   make(map[string][]diagnostic),
+	}
+	source := []byte(text)
+	var m *srcmap.Map
+	if preprocess != nil {
+		source, m = preprocess(source, path)
+	}
+	lines := strings.Split(string(source), "\n")
+	c := compiler.New()
+	tokens, err := c.Tokenize(string(source))
+	if err != nil {
+		a.addErrors(err, path, m, lines)
+		return a
+	}
+	program, err := c.ParseTokens(tokens)
+	if err != nil {
+		a.addErrors(err, path, m, lines)
+		return a
+	}
+	a.parsed = true
+	a.collect(program.Statements, "", path, m, lines)
+	if _, err := c.Analyze(program); err != nil {
+		a.addErrors(err, path, m, lines)
+	}
+	return a
+}
+
+// locate maps a preprocessed line to its original file (absolute) and line.
+func locate(line int, path string, m *srcmap.Map) (string, int) {
+	pos := m.Lookup(line)
+	if pos.File == "" {
+		return path, pos.Line
+	}
+	return absPath(pos.File), pos.Line
+}
+
+func lineText(lines []string, line int) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+func (a *analysis) collect(stmts []parser.Node, module, path string, m *srcmap.Map, lines []string) {
+	for _, stmt := range stmts {
+		switch n := stmt.(type) {
+		case *parser.ModuleStatement:
+			a.collect(n.Body, n.Name, path, m, lines)
+		case *parser.FunctionDecl:
+			a.addFunc("FUNCTION", n.Name, module, n.Parameters, n.ReturnType, n.Line, path, m, lines)
+			a.collectVars(n.Body.Statements)
+		case *parser.SubDecl:
+			a.addFunc("SUB", n.Name, module, n.Parameters, "", n.Line, path, m, lines)
+			a.collectVars(n.Body.Statements)
+		case *parser.TypeDecl:
+			file, line := locate(n.Line, path, m)
+			var b strings.Builder
+			fmt.Fprintf(&b, "TYPE %s", n.Name)
+			for _, f := range n.Fields {
+				b.WriteString("\n  " + fieldDetail(f))
+			}
+			for _, method := range n.Methods {
+				switch fn := method.(type) {
+				case *parser.FunctionDecl:
+					a.addFunc("FUNCTION", fn.Name, n.Name, fn.Parameters[1:], fn.ReturnType, fn.Line, path, m, lines)
+					fmt.Fprintf(&b, "\n  FUNCTION %s(%s)", fn.Name, strings.Join(fn.Parameters[1:], ", "))
+					a.collectVars(fn.Body.Statements)
+				case *parser.SubDecl:
+					a.addFunc("SUB", fn.Name, n.Name, fn.Parameters[1:], "", fn.Line, path, m, lines)
+					fmt.Fprintf(&b, "\n  SUB %s(%s)", fn.Name, strings.Join(fn.Parameters[1:], ", "))
+					a.collectVars(fn.Body.Statements)
+				}
+			}
+			b.WriteString("\nEND TYPE")
+			key := strings.ToLower(n.Name)
+			a.types[key] = &symbol{Name: n.Name, Kind: kindStruct, Detail: b.String(), File: file, Line: line, Text: lineText(lines, n.Line)}
+			a.fields[key] = n.Fields
+		default:
+			a.collectVars([]parser.Node{stmt})
+		}
+	}
+}
+
+func (a *analysis) addFunc(keyword, name, module string, params []string, ret string, declLine int, path string, m *srcmap.Map, lines []string) {
+	file, line := locate(declLine, path, m)
+	key, qualified := strings.ToLower(name), name
+	if module != "" {
+		key = strings.ToLower(module) + "." + key
+		qualified = module + "." + name
+	}
+	sig := fmt.Sprintf("%s %s(%s)", keyword, qualified, strings.Join(params, ", "))
+	if ret != "" {
+		sig += " AS " + strings.ToUpper(ret)
+	}
+	s := &symbol{Name: name, Kind: kindFunction, Detail: sig, File: file, Line: line, Text: lineText(lines, declLine)}
+	if module != "" {
+		a.modules[strings.ToLower(module)] = append(a.modules[strings.ToLower(module)], s)
+	}
+	a.funcs[key] = s
+}
+
+// collectVars records DIM declarations (with their AS type) and assigned variable names.
+func (a *analysis) collectVars(stmts []parser.Node) {
+	for _, stmt := range stmts {
+		switch n := stmt.(type) {
+		case *parser.DimStatement:
+			for _, v := range n.Variables {
+				a.vars[strings.ToLower(v.Name)] = v.Type
+			}
+		case *parser.Assignment:
+			if _, ok := a.vars[strings.ToLower(n.Variable)]; !ok {
+				a.vars[strings.ToLower(n.Variable)] = ""
+			}
+		}
+	}
+}
+
+func fieldDetail(f parser.TypeField) string {
+	if f.FieldType != "" {
+		return f.Name + " AS " + strings.ToUpper(f.FieldType)
+	}
+	return f.Name
+}
+
+var errLineRe = regexp.MustCompile(`line (\d+)(?:, col (\d+))?: `)
+
+// addErrors turns compiler errors into diagnostics. Semantic analysis joins all its errors; each becomes one diagnostic.
+func (a *analysis) addErrors(err error, path string, m *srcmap.Map, lines []string) {
+	for _, e := range splitErrors(err) {
+		msg := e.Error()
+		line, col := 0, 0
+		if loc := errLineRe.FindAllStringSubmatchIndex(msg, -1); loc != nil {
+			last := loc[len(loc)-1]
+			line, _ = strconv.Atoi(msg[last[2]:last[3]])
+			if last[4] >= 0 {
+				col, _ = strconv.Atoi(msg[last[4]:last[5]])
+			}
+			msg = msg[last[1]:]
+		} else {
+			for _, phase := range []string{"lexical error: ", "parse error: ", "semantic error: "} {
+				msg = strings.TrimPrefix(msg, phase)
+			}
+		}
+		file, origLine := path, 1
+		if line > 0 {
+			file, origLine = locate(line, path, m)
+		}
+		a.diags[file] = append(a.diags[file], diagnostic{
+			Range:    wordRange(origLine-1, lineText(lines, line), col),
+			Severity: 1,
+			Source:   "cyberbasic",
+			Message:  msg,
+		})
+	}
+}
+
+// splitErrors flattens errors.Join results, also under the phase prefix added by the compiler driver.
+func splitErrors(err error) []error {
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var out []error
+		for _, e := range j.Unwrap() {
+			out = append(out, splitErrors(e)...)
+		}
+		return out
+	}
+	if inner := errors.Unwrap(err); inner != nil {
+		if _, ok := inner.(interface{ Unwrap() []error }); ok {
+			return splitErrors(inner)
+		}
+	}
+	return []error{err}
+}
+
+// wordRange returns the range of the word at the compiler's 1-based column col on a line (the whole line when col is 0).
+// The lexer reports identifier columns one past their start, so the start snaps back to the beginning of the word.
+func wordRange(line int, text string, col int) lspRange {
+	if col <= 0 {
+		return lspRange{Start: position{Line: line}, End: position{Line: line, Character: len(text)}}
+	}
+	start := col - 1
+	if start > len(text) {
+		start = len(text)
+	}
+	for start > 0 && isWordChar(text[start-1]) {
+		start--
+	}
+	end := start
+	for end < len(text) && isWordChar(text[end]) {
+		end++
+	}
+	if end == start && end < len(text) {
+		end++
+	}
+	return lspRange{Start: position{Line: line, Character: start}, End: position{Line: line, Character: end}}
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// nameRange finds the declared name on its declaration line (0-based line).
+func (s *symbol) nameRange() lspRange {
+	re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(s.Name) + `\b`)
+	if loc := re.FindStringIndex(s.Text); loc != nil {
+		return lspRange{Start: position{Line: s.Line - 1, Character: loc[0]}, End: position{Line: s.Line - 1, Character: loc[1]}}
+	}
+	return lspRange{Start: position{Line: s.Line - 1}, End: position{Line: s.Line - 1}}
+}
+
+// fieldsOf returns the fields reachable through base (a variable DIM'd AS a TYPE, or a TYPE name for constant groups).
+func (a *analysis) fieldsOf(base string) ([]parser.TypeField, string) {
+	base = strings.ToLower(base)
+	if t, ok := a.vars[base]; ok && t != "" {
+		if f, ok := a.fields[strings.ToLower(t)]; ok {
+			return f, a.types[strings.ToLower(t)].Name
+		}
+	}
+	if f, ok := a.fields[base]; ok {
+		return f, a.types[base].Name
+	}
+	return nil, ""
+}
+
+// sortedFuncs returns the Functions and Subs declared outside modules, sorted by name.
+func (a *analysis) sortedFuncs() []*symbol {
+	var out []*symbol
+	for key, s := range a.funcs {
+		if !strings.Contains(key, ".") {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,404 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cyberbasic/compiler/lexer"
+)
+
+// Options supplies the data the server cannot derive from a document.
+type Options struct {
+	Foreign    []string            // registered foreign command names (see vm.ForeignNames)
+	Help       func(string) string // one-line help for a command, "" if none (see std.HelpLine)
+	Preprocess Preprocessor        // #include / IMPORT splicing; nil analyses documents on their own
+}
+
+// document is an open text document and its latest analysis.
+type document struct {
+	path      string
+	text      string
+	analysis  *analysis
+	published map[string]bool // file URIs that currently have diagnostics from this document
+}
+
+// Server is a language server session. Messages are handled one at a time in Serve.
+type Server struct {
+	conn     *conn
+	opts     Options
+	docs     map[string]*document // by URI
+	foreign  map[string]string    // lowercase -> registered name
+	keywords []string
+}
+
+// NewServer returns a session reading from r and writing to w.
+func NewServer(r io.Reader, w io.Writer, opts Options) *Server {
+	s := &Server{conn: newConn(r, w), opts: opts, docs: make(map[string]*document), foreign: make(map[string]string)}
+	for _, name := range opts.Foreign {
+		s.foreign[strings.ToLower(name)] = name
+	}
+	for kw := range lexer.KeywordMap {
+		if !strings.ContainsAny(kw, " _") {
+			s.keywords = append(s.keywords, kw)
+		}
+	}
+	sort.Strings(s.keywords)
+	return s
+}
+
+// Serve handles messages until the client sends exit or closes the stream.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) error {
+	switch msg.Method {
+	case "initialize":
+		return s.conn.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   map[string]interface{}{"openClose": true, "change": 1, "save": true},
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+				"hoverProvider":      true,
+				"definitionProvider": true,
+			},
+			"serverInfo": map[string]interface{}{"name": "cyberbasic"},
+		})
+	case "shutdown":
+		return s.conn.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &p) == nil {
+			return s.update(p.TextDocument.URI, p.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		// Full sync: the last change holds the whole document.
+		if json.Unmarshal(msg.Params, &p) == nil && len(p.ContentChanges) > 0 {
+			return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+		}
+	case "textDocument/didSave":
+		// Included files may have changed on disk; re-analyse every open document.
+		for uri, d := range s.docs {
+			if err := s.update(uri, d.text); err != nil {
+				return err
+			}
+		}
+	case "textDocument/didClose":
+		var p textDocumentPosition
+		if json.Unmarshal(msg.Params, &p) == nil {
+			if d, ok := s.docs[p.TextDocument.URI]; ok {
+				delete(s.docs, p.TextDocument.URI)
+				for uri := range d.published {
+					if err := s.publish(uri, nil); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case "textDocument/completion":
+		return s.positional(msg, s.completion)
+	case "textDocument/hover":
+		return s.positional(msg, s.hover)
+	case "textDocument/definition":
+		return s.positional(msg, s.definition)
+	default:
+		if len(msg.ID) > 0 {
+			return s.conn.replyError(msg.ID, codeMethodNotFound, "unsupported method %s", msg.Method)
+		}
+	}
+	return nil
+}
+
+// positional decodes a TextDocumentPositionParams request and replies with fn's result (null for unknown documents).
+func (s *Server) positional(msg *message, fn func(d *document, pos position) interface{}) error {
+	var p textDocumentPosition
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return s.conn.replyError(msg.ID, codeInvalidParams, "%v", err)
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return s.conn.reply(msg.ID, nil)
+	}
+	return s.conn.reply(msg.ID, fn(d, p.Position))
+}
+
+// update re-analyses a document and publishes diagnostics for it and for included files with errors.
+func (s *Server) update(uri, text string) error {
+	d, ok := s.docs[uri]
+	if !ok {
+		d = &document{path: uriToPath(uri), published: make(map[string]bool)}
+		s.docs[uri] = d
+	}
+	d.text = text
+	a := analyze(d.path, text, s.opts.Preprocess)
+	if !a.parsed && d.analysis != nil {
+		// Keep the last symbols while the user is mid-edit.
+		a.funcs, a.modules, a.types, a.fields, a.vars = d.analysis.funcs, d.analysis.modules, d.analysis.types, d.analysis.fields, d.analysis.vars
+	}
+	d.analysis = a
+
+	next := make(map[string]bool)
+	files := make([]string, 0, len(a.diags))
+	for file := range a.diags {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fileURI := pathToURI(file)
+		next[fileURI] = true
+		if err := s.publish(fileURI, a.diags[file]); err != nil {
+			return err
+		}
+	}
+	if !next[uri] {
+		if err := s.publish(uri, nil); err != nil {
+			return err
+		}
+	}
+	for fileURI := range d.published {
+		if !next[fileURI] && fileURI != uri {
+			if err := s.publish(fileURI, nil); err != nil {
+				return err
+			}
+		}
+	}
+	d.published = next
+	return nil
+}
+
+func (s *Server) publish(uri string, diags []diagnostic) error {
+	if diags == nil {
+		diags = []diagnostic{}
+	}
+	return s.conn.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": diags})
+}
+
+var (
+	memberPrefixRe = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.([A-Za-z0-9_]*)$`)
+	wordPrefixRe   = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// completion offers TYPE fields or module members after "name.", otherwise keywords, user Functions/Subs,
+// TYPEs, modules, variables and foreign commands starting with the word being typed.
+func (s *Server) completion(d *document, pos position) interface{} {
+	before := linePrefix(d.text, pos)
+	a := d.analysis
+	items := []completionItem{}
+	if m := memberPrefixRe.FindStringSubmatch(before); m != nil {
+		base, prefix := strings.ToLower(m[1]), strings.ToLower(m[2])
+		for _, fn := range a.modules[base] {
+			if strings.HasPrefix(strings.ToLower(fn.Name), prefix) {
+				items = append(items, completionItem{Label: fn.Name, Kind: kindFunction, Detail: fn.Detail})
+			}
+		}
+		fields, typeName := a.fieldsOf(base)
+		for _, f := range fields {
+			if strings.HasPrefix(strings.ToLower(f.Name), prefix) {
+				items = append(items, completionItem{Label: f.Name, Kind: kindField, Detail: typeName + "." + fieldDetail(f)})
+			}
+		}
+		return map[string]interface{}{"isIncomplete": false, "items": items}
+	}
+	prefix := strings.ToLower(wordPrefixRe.FindString(before))
+	add := func(label string, kind int, detail string) {
+		if strings.HasPrefix(strings.ToLower(label), prefix) {
+			items = append(items, completionItem{Label: label, Kind: kind, Detail: detail})
+		}
+	}
+	for _, kw := range s.keywords {
+		add(kw, kindKeyword, "")
+	}
+	for _, fn := range a.sortedFuncs() {
+		add(fn.Name, kindFunction, fn.Detail)
+	}
+	for _, key := range sortedKeys(a.types) {
+		add(a.types[key].Name, kindStruct, "TYPE")
+	}
+	for _, key := range sortedKeys(a.modules) {
+		add(key, kindModule, "MODULE")
+	}
+	for _, name := range sortedKeys(a.vars) {
+		detail := ""
+		if t := a.vars[name]; t != "" {
+			detail = "AS " + t
+		}
+		add(name, kindVariable, detail)
+	}
+	for _, name := range s.opts.Foreign {
+		add(name, kindFunction, s.help(name))
+	}
+	return map[string]interface{}{"isIncomplete": false, "items": items}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) help(name string) string {
+	if s.opts.Help == nil {
+		return ""
+	}
+	return s.opts.Help(name)
+}
+
+// hover shows the signature of a Function/Sub, the fields of a TYPE, a TYPE field's declaration,
+// or the help line of a foreign command.
+func (s *Server) hover(d *document, pos position) interface{} {
+	part, r := wordAt(d.text, pos)
+	if part == "" {
+		return nil
+	}
+	text := s.describe(d.analysis, part)
+	if text == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]interface{}{"kind": "markdown", "value": "```basic\n" + text + "\n```"},
+		"range":    r,
+	}
+}
+
+func (s *Server) describe(a *analysis, part string) string {
+	if sym := a.lookup(part); sym != nil {
+		return sym.Detail
+	}
+	if base, field, ok := strings.Cut(part, "."); ok {
+		fields, typeName := a.fieldsOf(base)
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, field) {
+				return typeName + "." + fieldDetail(f)
+			}
+		}
+	}
+	name := part
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if t, ok := a.vars[strings.ToLower(name)]; ok && t != "" && !strings.Contains(part, ".") {
+		return "DIM " + name + " AS " + t
+	}
+	if reg, ok := s.foreign[strings.ToLower(name)]; ok {
+		if h := s.help(reg); h != "" {
+			return h
+		}
+		return reg + "(...) – foreign command"
+	}
+	return ""
+}
+
+// definition jumps to the declaration of a Function, Sub or TYPE, in whichever file declared it.
+func (s *Server) definition(d *document, pos position) interface{} {
+	part, _ := wordAt(d.text, pos)
+	if part == "" {
+		return nil
+	}
+	sym := d.analysis.lookup(part)
+	if sym == nil {
+		return nil
+	}
+	return location{URI: pathToURI(sym.File), Range: sym.nameRange()}
+}
+
+// lookup resolves the dotted name under the cursor, up to the end of the segment under it
+// ("mymod.update" on "update", "mymod" on "mymod"), to a Function, Sub or TYPE.
+func (a *analysis) lookup(part string) *symbol {
+	key := strings.ToLower(part)
+	if sym, ok := a.funcs[key]; ok {
+		return sym
+	}
+	return a.types[key]
+}
+
+// linePrefix returns the text of pos's line before pos.
+func linePrefix(text string, pos position) string {
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[pos.Line], "\r")
+	if pos.Character < len(line) {
+		line = line[:pos.Character]
+	}
+	return line
+}
+
+// wordAt returns the dotted name at pos up to the end of the segment under the cursor, and that segment's range.
+func wordAt(text string, pos position) (part string, r lspRange) {
+	line := linePrefix(text, position{Line: pos.Line, Character: 1 << 30})
+	c := pos.Character
+	if c > len(line) {
+		c = len(line)
+	}
+	segStart, segEnd := c, c
+	for segStart > 0 && isWordChar(line[segStart-1]) {
+		segStart--
+	}
+	for segEnd < len(line) && isWordChar(line[segEnd]) {
+		segEnd++
+	}
+	if segStart == segEnd {
+		return "", r
+	}
+	start := segStart
+	for start > 0 && (isWordChar(line[start-1]) || line[start-1] == '.') {
+		start--
+	}
+	part = strings.TrimLeft(line[start:segEnd], ".")
+	r = lspRange{Start: position{Line: pos.Line, Character: segStart}, End: position{Line: pos.Line, Character: segEnd}}
+	return part, r
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath(path))}).String()
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
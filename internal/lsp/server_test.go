@@ -0,0 +1,255 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"cyberbasic/compiler/srcmap"
+)
+
+var testInclude = regexp.MustCompile(`^\s*#include\s*"([^"]+)"\s*$`)
+
+// testPreprocess splices #include lines like app.PreprocessIncludes (which this package cannot import).
+func testPreprocess(source []byte, filename string) ([]byte, *srcmap.Map) {
+	m := srcmap.New(filename)
+	var out strings.Builder
+	var splice func(src, file string)
+	splice = func(src, file string) {
+		for i, line := range strings.Split(strings.TrimSuffix(src, "\n"), "\n") {
+			if match := testInclude.FindStringSubmatch(line); match != nil {
+				path := filepath.Join(filepath.Dir(file), match[1])
+				if inc, err := os.ReadFile(path); err == nil {
+					splice(string(inc), path)
+					continue
+				}
+			}
+			out.WriteString(line + "\n")
+			m.Add(file, i+1)
+		}
+	}
+	splice(string(source), filename)
+	return []byte(out.String()), m
+}
+
+type client struct {
+	t   *testing.T
+	w   io.Writer
+	r   *textproto.Reader
+	seq int
+}
+
+func startServer(t *testing.T) *client {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	srv := NewServer(inR, outW, Options{
+		Foreign: []string{"DrawRectangle", "DrawText", "InitWindow"},
+		Help: func(name string) string {
+			if strings.EqualFold(name, "DrawText") {
+				return "DrawText(text, x, y, size, r, g, b, a) – draw text"
+			}
+			return ""
+		},
+		Preprocess: testPreprocess,
+	})
+	go func() {
+		if err := srv.Serve(); err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		outW.Close()
+	}()
+	t.Cleanup(func() { inW.Close() })
+	c := &client{t: t, w: inW, r: textproto.NewReader(bufio.NewReader(outR))}
+	c.call("initialize", map[string]interface{}{})
+	return c
+}
+
+func (c *client) send(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	body, _ := json.Marshal(msg)
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+}
+
+func (c *client) next() map[string]interface{} {
+	c.t.Helper()
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read header: %v", err)
+	}
+	n, _ := strconv.Atoi(header.Get("Content-Length"))
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatalf("read body: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("decode %s: %v", body, err)
+	}
+	return msg
+}
+
+// call sends a request and returns its result, skipping notifications.
+func (c *client) call(method string, params interface{}) interface{} {
+	c.t.Helper()
+	c.seq++
+	c.send(map[string]interface{}{"id": c.seq, "method": method, "params": params})
+	for {
+		msg := c.next()
+		if msg["id"] == float64(c.seq) {
+			if msg["error"] != nil {
+				c.t.Fatalf("%s: %v", method, msg["error"])
+			}
+			return msg["result"]
+		}
+	}
+}
+
+// open sends didOpen and returns the published diagnostics by URI (one notification per file).
+func (c *client) open(uri, text string, files int) map[string][]interface{} {
+	c.t.Helper()
+	c.send(map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "basic", "version": 1, "text": text},
+	}})
+	diags := make(map[string][]interface{})
+	for len(diags) < files {
+		msg := c.next()
+		if msg["method"] != "textDocument/publishDiagnostics" {
+			c.t.Fatalf("unexpected message %v", msg)
+		}
+		p := msg["params"].(map[string]interface{})
+		diags[p["uri"].(string)], _ = p["diagnostics"].([]interface{})
+	}
+	return diags
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+	}
+}
+
+func labels(result interface{}) []string {
+	var out []string
+	for _, it := range result.(map[string]interface{})["items"].([]interface{}) {
+		out = append(out, it.(map[string]interface{})["label"].(string))
+	}
+	return out
+}
+
+func contains(list []string, want string) bool {
+	for _, s := range list {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+const testLib = `TYPE Player
+  name AS STRING
+  hp AS INTEGER
+END TYPE
+
+MODULE Enemies
+  SUB Spawn(x, y)
+  END SUB
+END MODULE
+`
+
+const testMain = `#include "lib.bas"
+DIM p AS Player
+p.hp = 10
+FUNCTION Heal(amount) AS INTEGER
+  RETURN amount * 2
+END FUNCTION
+x = Heal(5)
+Enemies.Spawn(1, 2)
+DrawText("hi", 1, 2, 20, 255, 255, 255, 255)
+`
+
+func writeProject(t *testing.T) (mainURI, libURI string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lib.bas"), []byte(testLib), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pathToURI(filepath.Join(dir, "main.bas")), pathToURI(filepath.Join(dir, "lib.bas"))
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := startServer(t)
+	mainURI, _ := writeProject(t)
+	bad := strings.Replace(testMain, "p.hp = 10", `p.hp = "ten"`, 1) + "p.nmae = \"x\"\n"
+	diags := c.open(mainURI, bad, 1)[mainURI]
+	if len(diags) != 2 {
+		t.Fatalf("diagnostics = %v, want 2", diags)
+	}
+	first := diags[0].(map[string]interface{})
+	if line := first["range"].(map[string]interface{})["start"].(map[string]interface{})["line"]; line != float64(2) {
+		t.Errorf("first diagnostic on line %v, want 2 (0-based): %v", line, first)
+	}
+	if msg := diags[1].(map[string]interface{})["message"].(string); !strings.Contains(msg, "did you mean name") {
+		t.Errorf("second diagnostic = %q", msg)
+	}
+
+	c.send(map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": mainURI, "version": 2},
+		"contentChanges": []map[string]interface{}{{"text": testMain}},
+	}})
+	msg := c.next()
+	if p := msg["params"].(map[string]interface{}); p["uri"] != mainURI || len(p["diagnostics"].([]interface{})) != 0 {
+		t.Errorf("after fix: %v, want empty diagnostics", msg)
+	}
+}
+
+func TestCompletionHoverDefinition(t *testing.T) {
+	c := startServer(t)
+	mainURI, libURI := writeProject(t)
+	c.open(mainURI, testMain+"p.\nEnemies.\nDraw", 1)
+
+	if got := labels(c.call("textDocument/completion", at(mainURI, 9, 2))); !contains(got, "name") || !contains(got, "hp") || len(got) != 2 {
+		t.Errorf("p. completions = %v, want TYPE fields name, hp", got)
+	}
+	if got := labels(c.call("textDocument/completion", at(mainURI, 10, 8))); len(got) != 1 || got[0] != "spawn" {
+		t.Errorf("Enemies. completions = %v, want spawn", got)
+	}
+	if got := labels(c.call("textDocument/completion", at(mainURI, 11, 4))); !contains(got, "DrawText") || !contains(got, "DrawRectangle") || contains(got, "InitWindow") {
+		t.Errorf("Draw completions = %v", got)
+	}
+
+	hover := c.call("textDocument/hover", at(mainURI, 6, 5)).(map[string]interface{})
+	if v := hover["contents"].(map[string]interface{})["value"].(string); !strings.Contains(v, "FUNCTION heal(amount) AS INTEGER") {
+		t.Errorf("hover Heal = %q", v)
+	}
+	hover = c.call("textDocument/hover", at(mainURI, 8, 2)).(map[string]interface{})
+	if v := hover["contents"].(map[string]interface{})["value"].(string); !strings.Contains(v, "draw text") {
+		t.Errorf("hover DrawText = %q", v)
+	}
+
+	def := c.call("textDocument/definition", at(mainURI, 7, 10)).(map[string]interface{})
+	start := def["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if def["uri"] != libURI || start["line"] != float64(6) || start["character"] != float64(6) {
+		t.Errorf("definition of Enemies.Spawn = %v, want lib.bas line 6 col 6", def)
+	}
+	def = c.call("textDocument/definition", at(mainURI, 1, 12)).(map[string]interface{})
+	if def["uri"] != libURI || def["range"].(map[string]interface{})["start"].(map[string]interface{})["line"] != float64(0) {
+		t.Errorf("definition of Player = %v, want lib.bas line 0", def)
+	}
+	if def := c.call("textDocument/definition", at(mainURI, 6, 1)); def != nil {
+		t.Errorf("definition of x = %v, want null", def)
+	}
+	c.call("shutdown", nil)
+}